@@ -0,0 +1,210 @@
+package ivy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Type AccessLogOptions is a struct holding the settings of the access log.
+// When it is turned on, every read, query and write of a record is appended
+// to the file at Path as a line of JSON, an AccessLogEntry, whether it
+// succeeded or not. When the file would grow past MaxSize bytes, it is
+// renamed with a ".1" suffix, older files move on to ".2" and so on, and the
+// oldest beyond MaxFiles are deleted. The access log requires the local file
+// system.
+type AccessLogOptions struct {
+	// Path is the file the log is written to. It is required.
+	Path string
+
+	// MaxSize is the size, in bytes, at which the log is rotated. It defaults
+	// to 10MB.
+	MaxSize int64
+
+	// MaxFiles is the number of rotated files kept. It defaults to 5.
+	MaxFiles int
+
+	// Actor identifies the caller in every entry, such as the name of the
+	// service using the database, unless the context of the operation
+	// carries an actor of its own; see WithActor.
+	Actor string
+}
+
+// Type AccessLogEntry is a struct holding an entry of the access log. Op is
+// find, ids, query, create, update, delete, export or stream; Id is empty for
+// operations on a whole table. Namespace is the namespace the operation was
+// made in, if any; see DB.Namespace. Duration is in nanoseconds when marshalled.
+// Outcome is "ok", or the error the operation returned.
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Table     string        `json:"table"`
+	Id        string        `json:"id,omitempty"`
+	Op        string        `json:"op"`
+	Actor     string        `json:"actor,omitempty"`
+	Namespace string        `json:"namespace,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Outcome   string        `json:"outcome"`
+}
+
+// accessLog is an append-only log of operations, rotated by size.
+type accessLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	actor    string
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openAccessLog opens the access log, appending to it if it exists.
+func openAccessLog(opts AccessLogOptions) (*accessLog, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("ivy: access log path is required")
+	}
+
+	l := &accessLog{path: opts.Path, maxSize: opts.MaxSize, maxFiles: opts.MaxFiles, actor: opts.Actor}
+
+	if l.maxSize <= 0 {
+		l.maxSize = 10 << 20
+	}
+	if l.maxFiles <= 0 {
+		l.maxFiles = 5
+	}
+
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the current file of the log.
+func (l *accessLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = info.Size()
+
+	return nil
+}
+
+// record appends an entry to the log, rotating it first if the entry would
+// take it past its maximum size.
+func (l *accessLog) record(e AccessLogEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return ErrClosed
+	}
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		err = l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+
+	return err
+}
+
+// rotate moves the current file and the rotated ones one suffix up, deleting
+// the oldest, and starts a new file. The caller must hold l.mu.
+func (l *accessLog) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err = os.Rename(l.path, l.path+".1")
+	if err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// close closes the log.
+func (l *accessLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// logAccess records an operation that started at the supplied time and
+// returned err in the access log, if there is one.
+func (db *DB) logAccess(tblName string, fileId string, op string, actor string, start time.Time, err error) {
+	if db.accessLog == nil {
+		return
+	}
+
+	if actor == "" {
+		actor = db.accessLog.actor
+	}
+
+	e := AccessLogEntry{
+		Time:      start.UTC(),
+		Table:     tblName,
+		Id:        fileId,
+		Op:        op,
+		Actor:     actor,
+		Namespace: db.namespace,
+		Duration:  time.Since(start),
+		Outcome:   "ok",
+	}
+	if err != nil {
+		e.Outcome = err.Error()
+	}
+
+	if lerr := db.accessLog.record(e); lerr != nil {
+		db.logger.Error("ivy: access log entry not written", "table", tblName, "id", fileId, "op", op, "err", lerr)
+	}
+}
@@ -0,0 +1,75 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// The fields stamped with the actor of a write; see Options.ActorStamps.
+const (
+	createdByField = "created_by"
+	updatedByField = "updated_by"
+)
+
+// actorKey is the key of the actor in a context.
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the identity of whoever is acting,
+// such as the name of the logged-in user. Operations passed the context, such
+// as CreateCtx, UpdateCtx and ImportCSVCtx, record the actor in the access
+// log entries and webhook events they cause, and in the records they write if
+// Options.ActorStamps is set, so that who changed a record is known without
+// passing the actor to every call.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by a context, as set by
+// WithActor, and whether there is one.
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// stampActor sets the "created_by" and "updated_by" fields of a marshalled
+// record to the actor of a write: "created_by" only if the record is new,
+// and otherwise to the "created_by" of the version the write replaces,
+// oldData. Records are stamped only if Options.ActorStamps is set and the
+// write has an actor.
+func (db *DB) stampActor(actor string, data []byte, oldData []byte) ([]byte, error) {
+	if !db.actorStamps || actor == "" {
+		return data, nil
+	}
+
+	var rec map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &rec); err != nil || rec == nil {
+		return data, nil
+	}
+
+	stamp, err := json.Marshal(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	rec[updatedByField] = stamp
+
+	if oldData == nil {
+		rec[createdByField] = stamp
+	} else {
+		var old map[string]json.RawMessage
+		json.Unmarshal(oldData, &old)
+
+		if createdBy, ok := old[createdByField]; ok {
+			rec[createdByField] = createdBy
+		} else {
+			delete(rec, createdByField)
+		}
+	}
+
+	return json.Marshal(rec)
+}
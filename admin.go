@@ -0,0 +1,556 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// adminMaxBody limits the size of the records sent to AdminHandler.
+const adminMaxBody = 16 << 20
+
+// adminPageSize is the number of records AdminHandler lists by default.
+const adminPageSize = 50
+
+// adminIndex is the page of the admin UI.
+//
+//go:embed admin/index.html
+var adminIndex []byte
+
+// adminTable describes a table and its statistics in the admin UI.
+type adminTable struct {
+	Name string `json:"name"`
+	TableStats
+}
+
+// adminPage is a page of records listed by the admin UI, with the number of
+// records matching the search.
+type adminPage struct {
+	Total   int           `json:"total"`
+	Records []exportedRec `json:"records"`
+}
+
+// qlTags holds if a record's tags include all of the supplied ones.
+type qlTags struct {
+	tags []string
+}
+
+func (e *qlTags) eval(rec map[string]interface{}) bool {
+	recTags, _ := rec["tags"].([]interface{})
+
+	for _, tag := range e.tags {
+		found := false
+		for _, t := range recTags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// AdminHandler returns an HTTP handler serving a small web UI for browsing and
+// editing the database: it lists the tables with their record counts and
+// index stats, pages through records, searches them by field value, tags or
+// query string, and creates, edits and deletes them. The page and its assets
+// are embedded in the package. The UI calls a JSON API served by the same
+// handler under /api/tables:
+//
+//	GET    /api/tables                      the tables
+//	GET    /api/tables/TABLE                a table and its index stats
+//	GET    /api/tables/TABLE/records        a page of records, filtered by the
+//	                                        field and value, tags (separated by
+//	                                        commas) or q parameters, and paged
+//	                                        by offset and limit
+//	POST   /api/tables/TABLE/records        create a record, returning its id
+//	GET    /api/tables/TABLE/records/ID     a record
+//	PUT    /api/tables/TABLE/records/ID     replace a record
+//	DELETE /api/tables/TABLE/records/ID     delete a record
+//	GET    /api/queries                     the saved queries
+//	PUT    /api/queries/NAME                save a query, sent as a SavedQuery
+//	DELETE /api/queries/NAME                delete a saved query
+//	POST   /api/queries/NAME/run            the ids of the records matching a
+//	                                        saved query, run with the
+//	                                        arguments of its placeholders sent
+//	                                        as a JSON array
+//
+// The handler expects to be served at the root of its path, so mount it with
+// http.StripPrefix to serve it elsewhere. It does no authentication of its
+// own, so anything but a local development server should wrap it with
+// TokenAuth.Wrap, which limits every request to what its token's role allows.
+func (db *DB) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+
+		if path == "" || path == "index.html" {
+			if r.Method != "GET" && r.Method != "HEAD" {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(adminIndex)
+			return
+		}
+
+		parts := strings.Split(path, "/")
+
+		if len(parts) >= 2 && parts[0] == "api" && parts[1] == "queries" {
+			db.adminQueries(w, r, parts[2:])
+			return
+		}
+
+		if len(parts) < 2 || parts[0] != "api" || parts[1] != "tables" || len(parts) > 5 ||
+			(len(parts) >= 4 && parts[3] != "records") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if len(parts) == 2 {
+			if authorize(w, r, "*", false) {
+				db.adminTables(w, r)
+			}
+			return
+		}
+
+		tblName := parts[2]
+
+		write := r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE"
+		if !authorize(w, r, tblName, write) {
+			return
+		}
+
+		if db.tblLock(tblName) == nil {
+			http.Error(w, fmt.Sprintf("ivy: table %s does not exist", tblName), http.StatusNotFound)
+			return
+		}
+
+		switch len(parts) {
+		case 3:
+			db.adminTable(w, r, tblName)
+		case 4:
+			db.adminRecords(w, r, tblName)
+		default:
+			db.adminRecord(w, r, tblName, parts[4])
+		}
+	})
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// adminTables serves the list of tables.
+func (db *DB) adminTables(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := db.enter(); err != nil {
+		adminError(w, err)
+		return
+	}
+	defer db.leave()
+
+	tbls := []adminTable{}
+
+	for _, tblName := range db.tableNames() {
+		tbl, err := db.adminTableStats(tblName)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		tbls = append(tbls, *tbl)
+	}
+
+	adminJSON(w, http.StatusOK, tbls)
+}
+
+// adminTable serves a table and its index stats.
+func (db *DB) adminTable(w http.ResponseWriter, r *http.Request, tblName string) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := db.enter(); err != nil {
+		adminError(w, err)
+		return
+	}
+	defer db.leave()
+
+	tbl, err := db.adminTableStats(tblName)
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	adminJSON(w, http.StatusOK, tbl)
+}
+
+// adminTableStats returns the statistics of a table.
+func (db *DB) adminTableStats(tblName string) (*adminTable, error) {
+	ts, err := db.tblStats(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminTable{Name: tblName, TableStats: *ts}, nil
+}
+
+// adminRecords serves a page of the records of a table, or creates one.
+func (db *DB) adminRecords(w http.ResponseWriter, r *http.Request, tblName string) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		data, ok := adminBody(w, r)
+		if !ok {
+			return
+		}
+
+		fileId, err := db.CreateCtx(r.Context(), tblName, data)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		adminJSON(w, http.StatusCreated, map[string]string{"id": fileId})
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+
+	offset, limit := 0, adminPageSize
+
+	var err error
+
+	if v := params.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	q := &query{limit: -1}
+
+	switch {
+	case params.Get("q") != "":
+		q, err = parseQuery(params.Get("q"), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case params.Get("field") != "":
+		q.where = newCompare(params.Get("field"), "=", params.Get("value"))
+	case params.Get("tags") != "":
+		q.where = &qlTags{tags: strings.Split(params.Get("tags"), ",")}
+	}
+
+	if err := db.enter(); err != nil {
+		adminError(w, err)
+		return
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(r.Context(), tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	fileIds, err := db.runQuery(r.Context(), tblName, q)
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	page := adminPage{Total: len(fileIds), Records: []exportedRec{}}
+
+	if offset > len(fileIds) {
+		offset = len(fileIds)
+	}
+	fileIds = fileIds[offset:]
+
+	if limit < len(fileIds) {
+		fileIds = fileIds[:limit]
+	}
+
+	// Records are read as Find reads them, not redacted as for an export, so
+	// that they can be edited.
+	for _, fileId := range fileIds {
+		var data []byte
+
+		data, err = db.backupRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			err = nil
+			continue
+		}
+		if err == nil {
+			data, err = db.plainRec(tblName, fileId, data)
+		}
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		page.Records = append(page.Records, exportedRec{Id: fileId, Data: data})
+	}
+
+	adminJSON(w, http.StatusOK, page)
+}
+
+// adminRecord serves, replaces or deletes a record.
+func (db *DB) adminRecord(w http.ResponseWriter, r *http.Request, tblName string, fileId string) {
+	var err error
+
+	switch r.Method {
+	case "GET":
+		var data []byte
+
+		if err = db.enter(); err == nil {
+			data, err = db.adminFind(r.Context(), tblName, fileId)
+			db.leave()
+		}
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		adminJSON(w, http.StatusOK, exportedRec{Id: fileId, Data: data})
+		return
+	case "PUT":
+		data, ok := adminBody(w, r)
+		if !ok {
+			return
+		}
+
+		// Update creates missing records, which the UI does not mean to do.
+		if err = db.enter(); err == nil {
+			_, err = db.backupRec(tblName, fileId)
+			db.leave()
+		}
+		if err == nil {
+			err = db.UpdateCtx(r.Context(), tblName, data, fileId)
+		}
+	case "DELETE":
+		err = db.DeleteCtx(r.Context(), tblName, fileId)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminFind reads a record for the admin UI, as Find reads it.
+func (db *DB) adminFind(ctx context.Context, tblName string, fileId string) (data []byte, err error) {
+	op, err := db.beginOp(ctx, tblName, fileId, "find")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = db.backupRec(tblName, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.plainRec(tblName, fileId, data)
+}
+
+// adminQueries serves, saves, deletes or runs saved queries, named by the
+// parts of the path after /api/queries.
+func (db *DB) adminQueries(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0:
+		if !authorize(w, r, "*", false) {
+			return
+		}
+
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		queries, err := db.SavedQueries()
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		adminJSON(w, http.StatusOK, queries)
+	case len(parts) == 1:
+		if !authorize(w, r, savedQueriesTable, true) {
+			return
+		}
+
+		var err error
+
+		switch r.Method {
+		case "PUT":
+			data, ok := adminBody(w, r)
+			if !ok {
+				return
+			}
+
+			var spec SavedQuery
+			if err := json.Unmarshal(data, &spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = db.SaveQuery(parts[0], spec)
+		case "DELETE":
+			err = db.DeleteSavedQuery(parts[0])
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "run":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := db.savedQuery(parts[0])
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		if !authorize(w, r, q.Table, false) {
+			return
+		}
+
+		var args []interface{}
+
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, adminMaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+
+			if err := dec.Decode(&args); err != nil {
+				http.Error(w, "the arguments must be a JSON array", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ids, err := db.QueryStringCtx(r.Context(), q.Table, q.Query, args...)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		if ids == nil {
+			ids = []string{}
+		}
+
+		adminJSON(w, http.StatusOK, ids)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// adminBody reads a record sent to the admin UI, answering 400 Bad Request if
+// it is not a JSON object.
+func adminBody(w http.ResponseWriter, r *http.Request) (json.RawMessage, bool) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, adminMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' || !isJSONObject(data) {
+		http.Error(w, "the record must be a JSON object", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return json.RawMessage(data), true
+}
+
+// adminJSON writes a JSON response.
+func adminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError answers with the status matching an error.
+func adminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case os.IsNotExist(err), errors.Is(err, ErrNotFound), errors.Is(err, ErrTableNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidID):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrFollower), errors.Is(err, ErrReadOnly), errors.Is(err, ErrConflict),
+		errors.Is(err, ErrImmutable):
+		status = http.StatusConflict
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrClosed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrRecordTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrQuotaExceeded):
+		status = http.StatusInsufficientStorage
+	}
+
+	http.Error(w, err.Error(), status)
+}
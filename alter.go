@@ -0,0 +1,602 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alterDir is the directory of the .ivy directory holding the progress of
+// the alterations of tables, one file per table, so that an alteration
+// interrupted by Close or a crash is resumed by OpenDB.
+const alterDir = "alter"
+
+// Field change operations.
+const (
+	fieldAdd    = "add"
+	fieldDrop   = "drop"
+	fieldRename = "rename"
+)
+
+// Type FieldChange is a struct describing a change to the fields of every
+// record of a table, made by DB.AlterTable. See AddField, DropField and
+// RenameField. Fields of nested objects are named by their path, such as
+// "address.city".
+type FieldChange struct {
+	Op    string      `json:"op"`
+	Field string      `json:"field"`
+	Value interface{} `json:"value,omitempty"`
+	To    string      `json:"to,omitempty"`
+}
+
+// AddField returns a FieldChange setting a field to a default value in the
+// records that do not have it. The value has to marshal to JSON.
+func AddField(field string, value interface{}) FieldChange {
+	return FieldChange{Op: fieldAdd, Field: field, Value: value}
+}
+
+// DropField returns a FieldChange removing a field from the records.
+func DropField(field string) FieldChange {
+	return FieldChange{Op: fieldDrop, Field: field}
+}
+
+// RenameField returns a FieldChange moving the value of a field to another
+// field, replacing its value, in the records that have the field.
+func RenameField(field string, to string) FieldChange {
+	return FieldChange{Op: fieldRename, Field: field, To: to}
+}
+
+// Type AlterOptions is a struct holding how fast DB.AlterTableWithOptions
+// rewrites records.
+type AlterOptions struct {
+	// BatchSize is the number of records rewritten while holding the
+	// table's lock. It defaults to 500.
+	BatchSize int
+
+	// Pause is the time to wait between batches, so that the alteration
+	// leaves room for the application's reads and writes.
+	Pause time.Duration
+}
+
+// Type AlterProgress is a struct holding how far an alteration is. Total is
+// the number of records to go through, Done those gone through, and Changed
+// those rewritten. Err is the error that stopped the alteration, if any.
+type AlterProgress struct {
+	Table    string
+	Total    int
+	Done     int
+	Changed  int
+	Finished bool
+	Err      error
+}
+
+// Type Alteration is a struct holding an alteration of a table running in
+// the background; see DB.AlterTable.
+type Alteration struct {
+	db      *DB
+	tblName string
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu    sync.Mutex
+	state alterState
+	err   error
+}
+
+// alterState is the progress of an alteration, as saved between batches.
+// Last is the id of the last record gone through; records are gone through
+// in id order.
+type alterState struct {
+	Changes []FieldChange `json:"changes"`
+	Options AlterOptions  `json:"options"`
+	Last    string        `json:"last,omitempty"`
+	Total   int           `json:"total"`
+	Done    int           `json:"done"`
+	Changed int           `json:"changed"`
+}
+
+// Wait waits for the alteration to finish. It returns the error that stopped
+// it, if any.
+func (a *Alteration) Wait() error {
+	<-a.done
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.err
+}
+
+// Progress returns how far the alteration is.
+func (a *Alteration) Progress() AlterProgress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	finished := false
+	select {
+	case <-a.done:
+		finished = a.err == nil
+	default:
+	}
+
+	return AlterProgress{
+		Table:    a.tblName,
+		Total:    a.state.Total,
+		Done:     a.state.Done,
+		Changed:  a.state.Changed,
+		Finished: finished,
+		Err:      a.err,
+	}
+}
+
+// Cancel stops the alteration after the batch in progress and waits for it.
+// The records rewritten so far keep their changes; the alteration is not
+// resumed by OpenDB.
+func (a *Alteration) Cancel() {
+	a.cancel()
+	<-a.done
+
+	a.db.removeAlterState(a.tblName)
+}
+
+// apply makes the change to a decoded record. It reports whether the record
+// changed.
+func (c FieldChange) apply(rec map[string]interface{}) (bool, error) {
+	parent, name := fieldParent(rec, c.Field)
+
+	switch c.Op {
+	case fieldAdd:
+		if parent != nil {
+			if _, ok := parent[name]; ok {
+				return false, nil
+			}
+		}
+
+		return true, setPath(rec, c.Field, c.Value)
+	case fieldDrop:
+		if _, ok := parent[name]; !ok {
+			return false, nil
+		}
+
+		delete(parent, name)
+
+		return true, nil
+	case fieldRename:
+		value, ok := parent[name]
+		if !ok {
+			return false, nil
+		}
+
+		delete(parent, name)
+
+		return true, setPath(rec, c.To, value)
+	}
+
+	return false, fmt.Errorf("ivy: unknown field change %q", c.Op)
+}
+
+// validate checks that a change can be made.
+func (c FieldChange) validate() error {
+	switch {
+	case c.Op != fieldAdd && c.Op != fieldDrop && c.Op != fieldRename:
+		return fmt.Errorf("ivy: unknown field change %q", c.Op)
+	case c.Field == "" || (c.Op == fieldRename && c.To == ""):
+		return fmt.Errorf("ivy: field change %s needs a field name", c.Op)
+	}
+
+	if c.Op == fieldAdd {
+		if _, err := json.Marshal(c.Value); err != nil {
+			return fmt.Errorf("ivy: default value of field %s: %w", c.Field, err)
+		}
+	}
+
+	return nil
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// AlterTable changes the fields of every record of a table, such as
+//
+//	db.AlterTable("planes", ivy.AddField("wingspan", 0), ivy.DropField("obsolete"))
+//
+// rewriting the records in the background, in batches, while the table stays
+// in use. Rewritten records update the indexes and are reported to webhooks,
+// watchers and the audit log as any update is. The progress is saved after
+// every batch, so that an alteration interrupted by Close or a crash is
+// resumed where it stopped by the next OpenDB; see DB.Alteration. Only one
+// alteration of a table runs at a time. It takes a table name and the
+// changes, which are made in order to every record. It returns the running
+// alteration and any error encountered.
+func (db *DB) AlterTable(tblName string, changes ...FieldChange) (*Alteration, error) {
+	return db.AlterTableWithOptions(tblName, AlterOptions{}, changes...)
+}
+
+// AlterTableCtx is AlterTable with a context, which is handed to the
+// authorizer.
+func (db *DB) AlterTableCtx(ctx context.Context, tblName string, changes ...FieldChange) (*Alteration, error) {
+	return db.AlterTableWithOptionsCtx(ctx, tblName, AlterOptions{}, changes...)
+}
+
+// AlterTableWithOptions is AlterTable with options setting the size of the
+// batches and the pause between them.
+func (db *DB) AlterTableWithOptions(tblName string, opts AlterOptions, changes ...FieldChange) (*Alteration, error) {
+	return db.AlterTableWithOptionsCtx(context.Background(), tblName, opts, changes...)
+}
+
+// AlterTableWithOptionsCtx is AlterTableWithOptions with a context, which is
+// handed to the authorizer.
+func (db *DB) AlterTableWithOptionsCtx(ctx context.Context, tblName string, opts AlterOptions, changes ...FieldChange) (_ *Alteration, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "alter")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if err := db.checkMutable(tblName); err != nil {
+		return nil, err
+	}
+
+	// The rewritten records would lose the fields the read policy masks.
+	if err := db.checkUnrestricted(tblName); err != nil {
+		return nil, err
+	}
+
+	if len(changes) == 0 {
+		return nil, errors.New("ivy: an alteration needs at least one change")
+	}
+
+	for _, change := range changes {
+		if err := change.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	return db.startAlteration(tblName, alterState{Changes: changes, Options: opts})
+}
+
+// Alteration returns the alteration of a table that is running, such as one
+// resumed by OpenDB, or nil if there is none.
+func (db *DB) Alteration(tblName string) *Alteration {
+	db.altersMu.Lock()
+	defer db.altersMu.Unlock()
+
+	return db.alters[tblName]
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// startAlteration starts running an alteration of a table in the background.
+func (db *DB) startAlteration(tblName string, state alterState) (*Alteration, error) {
+	db.altersMu.Lock()
+	defer db.altersMu.Unlock()
+
+	if db.alters == nil {
+		db.alters = make(map[string]*Alteration)
+	}
+
+	if db.alters[tblName] != nil {
+		return nil, fmt.Errorf("%w: table %s is being altered", ErrConflict, tblName)
+	}
+
+	ctx, cancel := context.WithCancel(db.jobsCtx)
+
+	a := &Alteration{db: db, tblName: tblName, cancel: cancel, done: make(chan struct{}), state: state}
+
+	if err := db.saveAlterState(tblName, state); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	db.alters[tblName] = a
+
+	db.jobsWg.Add(1)
+	go db.runAlteration(ctx, a)
+
+	return a, nil
+}
+
+// runAlteration rewrites the records of an alteration, batch by batch, until
+// it is done, cancelled or the database is closed.
+func (db *DB) runAlteration(ctx context.Context, a *Alteration) {
+	defer db.jobsWg.Done()
+	defer a.cancel()
+
+	err := db.alterRecs(ctx, a)
+
+	a.mu.Lock()
+	a.err = err
+	state := a.state
+	a.mu.Unlock()
+
+	db.altersMu.Lock()
+	delete(db.alters, a.tblName)
+	db.altersMu.Unlock()
+
+	switch {
+	case err == nil:
+		db.removeAlterState(a.tblName)
+		db.logger.Info("ivy: table altered", "table", a.tblName, "records", state.Done, "changed", state.Changed)
+	case ctx.Err() != nil:
+		// The alteration is resumed by the next OpenDB, unless it was
+		// cancelled.
+	default:
+		db.logger.Error("ivy: table alteration failed", "table", a.tblName, "err", err)
+	}
+
+	close(a.done)
+}
+
+// alterRecs goes through the records of an alteration not gone through yet.
+func (db *DB) alterRecs(ctx context.Context, a *Alteration) error {
+	a.mu.Lock()
+	state := a.state
+	a.mu.Unlock()
+
+	fileIds, err := db.alterIds(a.tblName, state.Last)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.state.Total = a.state.Done + len(fileIds)
+	a.mu.Unlock()
+
+	batchSize := state.Options.BatchSize
+
+	for start := 0; start < len(fileIds); start += batchSize {
+		if start > 0 && state.Options.Pause > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(state.Options.Pause):
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + batchSize
+		if end > len(fileIds) {
+			end = len(fileIds)
+		}
+
+		changed, err := db.alterBatch(ctx, a.tblName, state.Changes, fileIds[start:end])
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		a.state.Last = fileIds[end-1]
+		a.state.Done += end - start
+		a.state.Changed += changed
+		state = a.state
+		a.mu.Unlock()
+
+		if err := db.saveAlterState(a.tblName, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// alterIds returns the ids of the records of a table after an id, in id
+// order.
+func (db *DB) alterIds(tblName string, last string) ([]string, error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	ids, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []string
+	for _, fileId := range ids {
+		if last == "" || idNum(fileId) > idNum(last) {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	return fileIds, nil
+}
+
+// alterBatch makes the changes to a batch of records while holding the
+// table's lock. It returns the number of records rewritten.
+func (db *DB) alterBatch(ctx context.Context, tblName string, changes []FieldChange, fileIds []string) (int, error) {
+	if err := db.enter(); err != nil {
+		return 0, err
+	}
+	defer db.leave()
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return 0, tableNotFoundErr(tblName)
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	n := 0
+
+	for _, fileId := range fileIds {
+		data, err := db.readRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return n, recErr(tblName, fileId, err)
+		}
+
+		data, err = db.openFields(tblName, fileId, data)
+		if err != nil {
+			return n, err
+		}
+
+		var rec map[string]interface{}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+
+		err = dec.Decode(&rec)
+		if err != nil {
+			return n, corruptErr(tblName, fileId, err.Error())
+		}
+
+		changed := false
+
+		for _, change := range changes {
+			c, err := change.apply(rec)
+			if err != nil {
+				return n, fmt.Errorf("ivy: %s/%s: %w", tblName, fileId, err)
+			}
+			changed = changed || c
+		}
+
+		if !changed {
+			continue
+		}
+
+		data, err = json.Marshal(rec)
+		if err != nil {
+			return n, err
+		}
+
+		err = db.writeRec(ctx, tblName, fileId, data, true)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// resumeAlterations resumes the alterations interrupted when the database
+// was last open.
+func (db *DB) resumeAlterations() error {
+	if db.path == "" || db.readOnly || db.follower != nil {
+		return nil
+	}
+
+	files, err := db.fs.ReadDir(db.metaPath(alterDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		tblName := strings.TrimSuffix(file.Name(), ".json")
+		if tblName == file.Name() {
+			continue
+		}
+
+		data, err := db.fs.ReadFile(db.metaPath(alterDir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		var state alterState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("%w: alteration of %s: %v", ErrCorrupt, tblName, err)
+		}
+
+		if db.tblLock(tblName) == nil {
+			db.logger.Warn("ivy: dropping the alteration of a missing table", "table", tblName)
+			db.removeAlterState(tblName)
+			continue
+		}
+
+		if _, err := db.startAlteration(tblName, state); err != nil {
+			return err
+		}
+
+		db.logger.Info("ivy: resuming table alteration", "table", tblName, "done", state.Done)
+	}
+
+	return nil
+}
+
+// saveAlterState saves the progress of the alteration of a table.
+func (db *DB) saveAlterState(tblName string, state alterState) error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	err = db.fs.MkdirAll(db.metaPath(alterDir), db.modes.dir)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.fs, db.metaPath(alterDir, tblName+".json"), data, db.modes.file)
+}
+
+// removeAlterState removes the progress of the alteration of a table.
+func (db *DB) removeAlterState(tblName string) {
+	if db.path != "" {
+		db.fs.Remove(db.metaPath(alterDir, tblName+".json"))
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// fieldParent returns the object holding a field of a record, named by its
+// path, and the field's name in it. The object is nil if the path leads
+// through something else.
+func fieldParent(rec map[string]interface{}, path string) (map[string]interface{}, string) {
+	names := strings.Split(path, ".")
+
+	for _, name := range names[:len(names)-1] {
+		obj, ok := rec[name].(map[string]interface{})
+		if !ok {
+			return nil, names[len(names)-1]
+		}
+
+		rec = obj
+	}
+
+	return rec, names[len(names)-1]
+}
@@ -0,0 +1,109 @@
+package ivy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EnglishStopWords is a list of common English words, for Analyzer.StopWords.
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will",
+	"with",
+}
+
+// Type TokenFilter is a function applied by an Analyzer to every term of a
+// text, such as to fold accents or map synonyms. It returns the term to keep,
+// or "" to drop the term.
+type TokenFilter func(term string) string
+
+// Type Analyzer is a struct configuring how the text of a table's text
+// searches and text indexes is split into terms, set in Options.Analyzers.
+// Text is split into words at anything but letters and digits, which are
+// then, in order, lowercased unless KeepCase is set, dropped if they are
+// StopWords, stemmed if Stem is set, and passed through the Filters. The
+// search and the text it is matched with are analyzed alike. The text indexes
+// of a table have to be rebuilt, such as with DB.Reindex, after its analyzer
+// changes.
+type Analyzer struct {
+	// KeepCase compares terms with regard to case.
+	KeepCase bool
+
+	// StopWords lists the terms left out of texts and searches, such as
+	// EnglishStopWords. They are compared after lowercasing.
+	StopWords []string
+
+	// Stem removes English plural endings from terms, so that "bombers"
+	// matches "bomber" and "factories" matches "factory".
+	Stem bool
+
+	// Filters are applied to every term, in order, after stemming.
+	Filters []TokenFilter
+}
+
+// Terms returns the terms of a text, in order.
+func (a Analyzer) Terms(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := words[:0]
+
+	for _, term := range words {
+		if !a.KeepCase {
+			term = strings.ToLower(term)
+		}
+
+		if stringInSlice(term, a.StopWords) {
+			continue
+		}
+
+		if a.Stem {
+			term = stemPlural(term)
+		}
+
+		for _, filter := range a.Filters {
+			if term == "" {
+				break
+			}
+			term = filter(term)
+		}
+
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// stemPlural removes the plural ending of an English word: "ies" becomes "y",
+// as in "factories", and a final "s" is dropped unless the word ends in "ss",
+// "us", or an "es" that belongs to the stem, as in "shoes" or "trees".
+func stemPlural(term string) string {
+	n := len(term)
+	if n < 3 || term[n-1] != 's' {
+		return term
+	}
+
+	switch term[n-2] {
+	case 'u', 's':
+		return term
+	case 'e':
+		if n > 3 && term[n-3] == 'i' && term[n-4] != 'a' && term[n-4] != 'e' {
+			return term[:n-3] + "y"
+		}
+
+		switch term[n-3] {
+		case 'i', 'a', 'o', 'e':
+			return term
+		}
+	}
+
+	return term[:n-1]
+}
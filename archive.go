@@ -0,0 +1,413 @@
+package ivy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveExt is the extension of the segment files of an archive directory.
+// Every call of DB.Archive writes a segment for the table, named after the
+// time it was written, holding a line of JSON, an archivedRec, per record.
+const archiveExt = ".jsonl.gz"
+
+// Type Archive is a struct holding a read-only handle on an archive
+// directory written by DB.Archive. Its records can be listed, found and
+// filtered as those of a database can, while no database has to be opened.
+type Archive struct {
+	dir string
+}
+
+// archivedRec is a line of an archive segment.
+type archivedRec struct {
+	Id   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// OpenArchive returns a handle on an archive directory. It takes the path of
+// the directory. It returns the handle and any error encountered.
+func OpenArchive(dir string) (*Archive, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("ivy: archive %s is not a directory", dir)
+	}
+
+	return &Archive{dir: dir}, nil
+}
+
+// TableNames returns the names of the archived tables, in order. It returns
+// any error encountered.
+func (a *Archive) TableNames() ([]string, error) {
+	files, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, file := range files {
+		if file.IsDir() && !isHidden(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Ids returns the ids of the archived records of a table, in id order. It
+// takes a table name. It returns a slice of record ids and any error
+// encountered, wrapping ErrTableNotFound if nothing of the table was
+// archived.
+func (a *Archive) Ids(tblName string) ([]string, error) {
+	recs, err := a.records(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedIds(recs), nil
+}
+
+// Find unmarshals the archived record of a table with the supplied id into
+// rec. It takes a table name, a pointer to the value to unmarshal into and
+// the id. It returns any error encountered, wrapping ErrNotFound if the
+// record was not archived.
+func (a *Archive) Find(tblName string, rec interface{}, fileId string) error {
+	recs, err := a.records(tblName)
+	if err != nil {
+		return err
+	}
+
+	data, ok := recs[fileId]
+	if !ok {
+		return fmt.Errorf("%w: %s/%s in archive", ErrNotFound, tblName, fileId)
+	}
+
+	return json.Unmarshal(data, rec)
+}
+
+// Filter returns the ids of the archived records of a table matching a
+// filter; see DB.Filter. It takes a table name and the filter. It returns a
+// slice of record ids, in id order, and any error encountered.
+func (a *Archive) Filter(tblName string, filter M) ([]string, error) {
+	where, err := filterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	recs, err := a.records(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []string
+
+	for _, fileId := range sortedIds(recs) {
+		var rec map[string]interface{}
+
+		if err := json.Unmarshal(recs[fileId], &rec); err != nil {
+			return nil, corruptErr(tblName, fileId, err.Error())
+		}
+
+		if where == nil || where.eval(rec) {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	return fileIds, nil
+}
+
+// records reads every segment of a table, returning its records keyed by
+// id. A record archived twice, such as after a crash in the middle of an
+// archival, has the version of the later segment.
+func (a *Archive) records(tblName string) (map[string][]byte, error) {
+	paths, err := archiveSegments(filepath.Join(a.dir, tblName))
+	if os.IsNotExist(err) {
+		return nil, tableNotFoundErr(tblName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make(map[string][]byte)
+
+	for _, path := range paths {
+		segment, err := readArchiveSegment(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range segment {
+			recs[rec.Id] = rec.Data
+		}
+	}
+
+	return recs, nil
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Archive moves the records of a table matching a filter, such as the orders
+// closed over a year ago, out of the table into an archive directory, where
+// they are kept gzipped and stay readable through OpenArchive, so that the
+// live table stays small without losing its history. Each call adds a
+// segment file to the table's subdirectory of the archive directory, which is
+// written and synced before the records are deleted from the table; deleting
+// them is reported to webhooks, watchers and the audit log as any delete is.
+// Archived records are stored decrypted, like backups, apart from their
+// encrypted fields. It takes a table name, the filter, as for Filter, and the
+// path of the archive directory, which is created if necessary. It returns
+// the number of records archived and any error encountered.
+func (db *DB) Archive(tblName string, criteria M, dest string) (int, error) {
+	return db.ArchiveCtx(context.Background(), tblName, criteria, dest)
+}
+
+// ArchiveCtx is Archive with a context, which is handed to the authorizer.
+func (db *DB) ArchiveCtx(ctx context.Context, tblName string, criteria M, dest string) (n int, err error) {
+	if err := db.enter(); err != nil {
+		return 0, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "archive")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return 0, err
+	}
+
+	if dest == "" {
+		return 0, errors.New("ivy: archive needs a destination directory")
+	}
+
+	where, err := filterExpr(criteria)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// The archive would hold the fields the read policy masks.
+	if err := db.checkUnrestricted(tblName); err != nil {
+		return 0, err
+	}
+
+	// The query finds the candidates, using the indexes.
+	candidates, err := db.runQuery(ctx, tblName, &query{where: where, limit: -1})
+	if err != nil {
+		return 0, err
+	}
+
+	var match func(rec map[string]interface{}) bool
+	if where != nil {
+		match = where.eval
+	}
+
+	fileIds, err := db.archiveRecs(ctx, tblName, candidates, match, dest)
+	n = len(fileIds)
+	if err != nil {
+		return n, err
+	}
+
+	if n > 0 {
+		db.logger.Info("ivy: archived records", "table", tblName, "dest", dest, "records", n)
+	}
+
+	return n, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// archiveRecs moves the candidate records of a table that still match, or
+// every candidate if match is nil, to a new segment of the archive directory
+// dest and deletes them from the table. With an empty dest, the records are
+// only deleted. It returns the ids of the records moved.
+func (db *DB) archiveRecs(ctx context.Context, tblName string, candidates []string, match func(rec map[string]interface{}) bool, dest string) ([]string, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	var recs []archivedRec
+
+	for _, fileId := range candidates {
+		data, err := db.readRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, recErr(tblName, fileId, err)
+		}
+
+		// The candidates were found before the lock was taken, so they
+		// may have changed since.
+		if match != nil {
+			rec, err := db.decodeFields(data)
+			if err != nil {
+				return nil, corruptErr(tblName, fileId, err.Error())
+			}
+			if !match(rec) {
+				continue
+			}
+		}
+
+		recs = append(recs, archivedRec{Id: fileId, Data: data})
+	}
+
+	if len(recs) == 0 {
+		return nil, nil
+	}
+
+	if dest != "" {
+		err := db.writeArchiveSegment(filepath.Join(dest, tblName), recs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fileIds []string
+
+	for _, rec := range recs {
+		err := db.removeRec(ctx, tblName, rec.Id)
+		if err != nil {
+			return fileIds, err
+		}
+
+		fileIds = append(fileIds, rec.Id)
+	}
+
+	return fileIds, nil
+}
+
+// writeArchiveSegment writes the records to a new segment of the archive
+// directory of a table.
+func (db *DB) writeArchiveSegment(dir string, recs []archivedRec) error {
+	err := db.modes.mkdirAll(dir)
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + archiveExt
+
+	return db.writeArchiveFile(filepath.Join(dir, name), recs)
+}
+
+// writeArchiveFile writes the records to a segment, gzipped, replacing it
+// atomically if it exists, and syncs it to disk.
+func (db *DB) writeArchiveFile(path string, recs []archivedRec) error {
+	var buf bytes.Buffer
+
+	gw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gw)
+
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	tmpPath := path + tmpMarker + "archive"
+
+	f, err := db.modes.openFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// readArchiveSegment returns the records of an archive segment, in the order
+// they were written.
+func readArchiveSegment(path string) ([]archivedRec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%w: archive segment %s: %v", ErrCorrupt, path, err)
+	}
+
+	var recs []archivedRec
+
+	dec := json.NewDecoder(gr)
+
+	for {
+		var rec archivedRec
+
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: archive segment %s: %v", ErrCorrupt, path, err)
+		}
+
+		recs = append(recs, rec)
+	}
+}
+
+// archiveSegments returns the paths of the segments of an archive directory
+// of a table, in the order they were written.
+func archiveSegments(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+
+	// Segment names sort in the order they were written.
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), archiveExt) {
+			paths = append(paths, filepath.Join(dir, file.Name()))
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,448 @@
+package ivy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLogName is the name of the audit log in the metadata directory.
+const auditLogName = "audit.log"
+
+// Changes recorded in the audit log besides create, update and delete.
+const (
+	auditBaseline   = "baseline"
+	auditRecover    = "recover"
+	auditRepair     = "repair"
+	auditQuarantine = "quarantine"
+)
+
+// Type AuditReport is a struct describing an audit log that VerifyAuditChain
+// found intact. Entries is the number of entries in the log and Records the
+// number of records checked against it. Head is the hash of the last entry;
+// keeping it somewhere else, such as in a ticket, shows later whether the log
+// was cut short and rebuilt since.
+type AuditReport struct {
+	Entries int
+	Records int
+	Head    string
+}
+
+// auditEntry is an entry of the audit log. Content is the hash of the stored
+// record after the change, empty if the change removed it. Hash is the hash
+// of the entry itself, with Hash empty, which covers Prev, the hash of the
+// entry before it.
+type auditEntry struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Table   string    `json:"table"`
+	Id      string    `json:"id"`
+	Op      string    `json:"op"`
+	Actor   string    `json:"actor,omitempty"`
+	Content string    `json:"content,omitempty"`
+	Prev    string    `json:"prev"`
+	Hash    string    `json:"hash"`
+}
+
+// auditLog is the append-only, hash-chained log of the changes to records
+// kept with Options.AuditChain.
+type auditLog struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  uint64
+	head string
+}
+
+// auditScan follows the chain of an audit log, remembering the last change
+// of every record. It can be resumed to read the entries appended since.
+type auditScan struct {
+	offset  int64
+	seq     uint64
+	head    string
+	entries int
+	last    map[string]string
+}
+
+// openAuditLog opens the audit log at path, creating it if necessary, and
+// cuts off an entry only partly written before a crash. It returns the log
+// and whether it has no entries yet. The log is created with the file mode of
+// modes.
+func openAuditLog(path string, modes fileModes) (*auditLog, bool, error) {
+	f, err := modes.openFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		err = f.Truncate(int64(complete))
+		if err != nil {
+			f.Close()
+			return nil, false, err
+		}
+	}
+
+	l := &auditLog{path: path, f: f}
+
+	if complete > 0 {
+		lines := bytes.Split(data[:complete-1], []byte("\n"))
+
+		var e auditEntry
+
+		err = json.Unmarshal(lines[len(lines)-1], &e)
+		if err != nil {
+			f.Close()
+			return nil, false, fmt.Errorf("%w: last entry of the audit log: %v", ErrTampered, err)
+		}
+
+		l.seq, l.head = e.Seq, e.Hash
+	}
+
+	return l, complete == 0, nil
+}
+
+// record appends an entry for a change to the log, chaining it to the entry
+// before it. data is the stored record after the change, nil if the change
+// removed it.
+func (l *auditLog) record(tblName string, fileId string, op string, actor string, data []byte) error {
+	e := auditEntry{
+		Time:  time.Now().UTC(),
+		Table: tblName,
+		Id:    fileId,
+		Op:    op,
+		Actor: actor,
+	}
+	if data != nil {
+		e.Content = contentHash(data)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return ErrClosed
+	}
+
+	e.Seq = l.seq + 1
+	e.Prev = l.head
+
+	hash, err := e.hash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	l.seq, l.head = e.Seq, e.Hash
+
+	return nil
+}
+
+// close closes the log.
+func (l *auditLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+// hash returns the hash of an entry, computed with its Hash empty.
+func (e auditEntry) hash() (string, error) {
+	e.Hash = ""
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	return contentHash(data), nil
+}
+
+// resume reads the entries appended to the audit log at path since the scan
+// last stopped, checking that each is chained to the one before it and that
+// its hash matches. It returns an error wrapping ErrTampered at the first
+// entry that does not.
+func (s *auditScan) resume(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: the audit log is missing", ErrTampered)
+		}
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < s.offset {
+		return fmt.Errorf("%w: the audit log was cut short", ErrTampered)
+	}
+
+	_, err = f.Seek(s.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partly written entry is left for the next resume.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e auditEntry
+
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("%w: audit log entry %d is unreadable: %v", ErrTampered, s.seq+1, err)
+		}
+
+		hash, err := e.hash()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case e.Seq != s.seq+1:
+			return fmt.Errorf("%w: audit log entry %d follows entry %d", ErrTampered, e.Seq, s.seq)
+		case e.Prev != s.head:
+			return fmt.Errorf("%w: audit log entry %d is not chained to the entry before it", ErrTampered, e.Seq)
+		case e.Hash != hash:
+			return fmt.Errorf("%w: audit log entry %d does not match its hash", ErrTampered, e.Seq)
+		}
+
+		s.last[e.Table+"/"+e.Id] = e.Content
+		s.seq, s.head = e.Seq, e.Hash
+		s.offset += int64(len(line))
+		s.entries++
+	}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// VerifyAuditChain checks the audit log of a database opened with
+// Options.AuditChain, and the records against it. Every entry has to hold
+// the hash of the entry before it, and every record has to match the last
+// entry for it, so that editing, inserting or removing either entries or
+// record files behind the database's back is detected. It returns a report
+// and any error encountered, wrapping ErrTampered for the first entry that
+// breaks the chain or listing the records that do not match the log.
+func (db *DB) VerifyAuditChain() (*AuditReport, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	if !db.auditChain {
+		return nil, errors.New("ivy: the database has no audit chain")
+	}
+
+	path := db.metaPath(auditLogName)
+
+	scan := &auditScan{last: make(map[string]string)}
+
+	report := &AuditReport{}
+
+	var altered []string
+
+	checked := make(map[string]bool)
+
+	for _, tblName := range db.tableNames() {
+		n, tblAltered, err := db.verifyAuditTable(tblName, path, scan)
+		if err != nil {
+			return nil, err
+		}
+
+		checked[tblName] = true
+		report.Records += n
+		altered = append(altered, tblAltered...)
+	}
+
+	// Records of tables that are gone altogether.
+	for name, content := range scan.last {
+		if content != "" && !checked[auditTable(name)] && db.tblLock(auditTable(name)) == nil {
+			altered = append(altered, name)
+		}
+	}
+
+	if len(altered) > 0 {
+		sort.Strings(altered)
+		return nil, fmt.Errorf("%w: records changed outside the database: %s", ErrTampered, strings.Join(altered, ", "))
+	}
+
+	report.Entries = scan.entries
+	report.Head = scan.head
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// openAuditLog opens the audit log. It returns whether the log is new, in
+// which case auditBaseline has to be called once the records are recovered.
+func (db *DB) openAuditLog() (bool, error) {
+	err := db.fs.MkdirAll(db.metaPath(), db.modes.dir)
+	if err != nil {
+		return false, err
+	}
+
+	l, fresh, err := openAuditLog(db.metaPath(auditLogName), db.modes)
+	if err != nil {
+		return false, err
+	}
+
+	db.audit = l
+
+	return fresh, nil
+}
+
+// auditBaseline starts a new audit log with a baseline entry for every record
+// already in the database, so that the records written before the audit
+// chain was turned on are covered too.
+func (db *DB) auditBaseline(tblNames []string) error {
+	for _, tblName := range tblNames {
+		fileIds, err := db.engine.ids(tblName)
+		if err != nil {
+			return err
+		}
+
+		for _, fileId := range fileIds {
+			data, err := db.engine.read(tblName, fileId)
+			if err != nil {
+				return err
+			}
+
+			err = db.audit.record(tblName, fileId, auditBaseline, "", data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// auditRec records a change to a record in the audit log, if there is one.
+// data is the stored record after the change, nil if the change removed it.
+func (db *DB) auditRec(tblName string, fileId string, op string, actor string, data []byte) {
+	if db.audit == nil {
+		return
+	}
+
+	if err := db.audit.record(tblName, fileId, op, actor, data); err != nil {
+		db.logger.Error("ivy: audit log entry not written", "table", tblName, "id", fileId, "op", op, "err", err)
+	}
+}
+
+// verifyAuditTable reads the audit log entries appended since the scan last
+// stopped and checks the records of a table against the last entry for
+// each. Holding the table's read lock, no write to it can be missing from
+// the log. It returns the number of records checked and the records that do
+// not match the log, as table/id.
+func (db *DB) verifyAuditTable(tblName string, path string, scan *auditScan) (int, []string, error) {
+	rwLock := db.tblLock(tblName)
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	// A read-only database has no log of its own to wait for.
+	if db.audit != nil {
+		db.audit.mu.Lock()
+		defer db.audit.mu.Unlock()
+	}
+
+	err := scan.resume(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var altered []string
+
+	present := make(map[string]bool, len(fileIds))
+
+	for _, fileId := range fileIds {
+		name := tblName + "/" + fileId
+		present[name] = true
+
+		data, err := db.engine.read(tblName, fileId)
+		if err != nil && !errors.Is(err, ErrCorrupt) {
+			return 0, nil, err
+		}
+
+		content := scan.last[name]
+		if content == "" || err != nil || contentHash(data) != content {
+			altered = append(altered, name)
+		}
+	}
+
+	for name, content := range scan.last {
+		if content != "" && auditTable(name) == tblName && !present[name] {
+			altered = append(altered, name)
+		}
+	}
+
+	return len(fileIds), altered, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// auditTable returns the table of a record named table/id.
+func auditTable(name string) string {
+	return name[:strings.LastIndexByte(name, '/')]
+}
+
+// contentHash returns the hex encoded SHA-256 hash of data.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,211 @@
+package ivy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Type Role is the level of access an APIToken grants.
+type Role int
+
+const (
+	// RoleRead allows reading every table.
+	RoleRead Role = iota + 1
+
+	// RoleWrite allows reading every table and writing the tables listed in
+	// the token, or all of them if it lists none.
+	RoleWrite
+
+	// RoleAdmin allows everything, including following the database with
+	// ReplicationHandler.
+	RoleAdmin
+)
+
+// roleNames holds the names of the roles, as used in token files.
+var roleNames = map[Role]string{RoleRead: "read", RoleWrite: "write", RoleAdmin: "admin"}
+
+// Type APIToken is a struct describing what the holder of an API token may do.
+type APIToken struct {
+	// Name identifies the holder of the token, such as a service, in logs.
+	Name string `json:"name,omitempty"`
+
+	// Role is the level of access the token grants.
+	Role Role `json:"role"`
+
+	// Tables limits the writes of a RoleWrite token to the listed tables.
+	Tables []string `json:"tables,omitempty"`
+}
+
+// Type TokenAuth is a map of API tokens, keyed by the secret presented in the
+// Authorization header as "Bearer <secret>". Its Wrap method authenticates
+// the requests of the HTTP handlers of a database, which then only allow what
+// the token's role does. A server of an application's own enforces the same
+// rules with Authenticate and the CanRead, CanWrite and IsAdmin methods of the
+// token.
+type TokenAuth map[string]APIToken
+
+// authKey is the context key of the token of an authenticated request.
+type authKey struct{}
+
+// anonymous is the token in the context of a request that TokenAuth.Wrap let
+// through without credentials. It allows nothing.
+var anonymous = &APIToken{}
+
+// String returns the name of a role.
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// MarshalText returns the name of a role.
+func (r Role) MarshalText() ([]byte, error) {
+	if _, ok := roleNames[r]; !ok {
+		return nil, fmt.Errorf("ivy: unknown role %d", int(r))
+	}
+
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText sets a role from its name: read, write or admin.
+func (r *Role) UnmarshalText(text []byte) error {
+	for role, name := range roleNames {
+		if name == string(text) {
+			*r = role
+			return nil
+		}
+	}
+
+	return fmt.Errorf("ivy: unknown role %q", text)
+}
+
+// CanRead reports whether the token allows reading a table.
+func (t APIToken) CanRead(tblName string) bool {
+	return t.Role >= RoleRead && t.Role <= RoleAdmin
+}
+
+// CanWrite reports whether the token allows writing a table.
+func (t APIToken) CanWrite(tblName string) bool {
+	switch t.Role {
+	case RoleAdmin:
+		return true
+	case RoleWrite:
+		return len(t.Tables) == 0 || stringInSlice(tblName, t.Tables)
+	}
+
+	return false
+}
+
+// IsAdmin reports whether the token allows everything.
+func (t APIToken) IsAdmin() bool {
+	return t.Role == RoleAdmin
+}
+
+// Authenticate returns the token matching a secret, and whether there is one.
+// Secrets are compared in constant time.
+func (a TokenAuth) Authenticate(secret string) (APIToken, bool) {
+	if secret == "" {
+		return APIToken{}, false
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+
+	var found APIToken
+	ok := false
+
+	for s, token := range a {
+		other := sha256.Sum256([]byte(s))
+		if subtle.ConstantTimeCompare(sum[:], other[:]) == 1 {
+			found, ok = token, true
+		}
+	}
+
+	return found, ok
+}
+
+// Wrap returns a handler that passes requests on to h with their token in
+// their context, where AdminHandler and ReplicationHandler check it. Requests
+// with an unknown token are answered 401 Unauthorized. Requests without one
+// are passed on as anonymous, so that a browser can load the admin UI's page
+// before asking for a token, and the handlers answer 401 Unauthorized to
+// anything else they ask for. A handler of your own wrapped with Wrap has to
+// check TokenFromContext likewise. The name of a token, if it has one, is
+// the actor of the request's writes; see WithActor.
+func (a TokenAuth) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := anonymous
+
+		if header := r.Header.Get("Authorization"); header != "" {
+			secret := ""
+			if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+				secret = strings.TrimSpace(header[7:])
+			}
+
+			found, ok := a.Authenticate(secret)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			token = &found
+		}
+
+		ctx := context.WithValue(r.Context(), authKey{}, token)
+		if token.Name != "" {
+			ctx = WithActor(ctx, token.Name)
+		}
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TokenFromContext returns the token of a request authenticated by
+// TokenAuth.Wrap, and whether there is one. It returns false for requests
+// that Wrap let through without a token.
+func TokenFromContext(ctx context.Context) (APIToken, bool) {
+	token, ok := ctx.Value(authKey{}).(*APIToken)
+	if !ok || token == anonymous {
+		return APIToken{}, false
+	}
+
+	return *token, true
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// authorize checks whether a request may read or write a table, or do
+// anything if the table name is empty, answering 401 Unauthorized or 403
+// Forbidden if it may not. Requests that did not go through TokenAuth.Wrap
+// are allowed everything, as they were before tokens.
+func authorize(w http.ResponseWriter, r *http.Request, tblName string, write bool) bool {
+	token, ok := r.Context().Value(authKey{}).(*APIToken)
+
+	switch {
+	case !ok:
+		return true
+	case token == anonymous:
+		unauthorized(w)
+		return false
+	case tblName == "" && token.IsAdmin(),
+		tblName != "" && write && token.CanWrite(tblName),
+		tblName != "" && !write && token.CanRead(tblName):
+		return true
+	}
+
+	http.Error(w, "forbidden", http.StatusForbidden)
+	return false
+}
+
+// unauthorized answers 401 Unauthorized, asking for a bearer token.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="ivy"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,33 @@
+package ivy
+
+import "context"
+
+// Type Authorizer is a function deciding whether an operation may go ahead,
+// set in Options.Authorizer. It is called with the context of the operation,
+// which carries whatever identifies the user of an embedded application, the
+// table name, the operation and the id of the record, if the operation is
+// about a single existing record. Every method reading or writing records
+// asks it first. The operations are:
+//
+//	find, ids, query, filter   reading records, also through AdminHandler
+//	create, update, delete     writing records
+//	export                     ExportTable, ExportCSV, ExportJSON,
+//	                           ExportToSQLite and CopyTo
+//	stream                     Stream
+//	import                     ImportTable, ImportJSON, ImportCSV,
+//	                           ImportMongo and LoadFixtures
+//	copy                       CopyTable, for both tables
+//	backup, restore            Backup, BackupIncremental, BackupTo and Restore
+//	replicate, follow, sync    Changes, ReplicationHandler, Follow and SyncWith
+//	watch, subscribe           Watch and Subscribe
+//	alter, repair, compact     AlterTable, Repair and Compact
+//	verify, reindex, pin       Verify, Reindex, Pin and PinTable
+//	shred, archive, retention  Shred, Archive and ApplyRetention
+//	emit                       Tx.Emit, on the outbox table
+//
+// Operations on several tables, such as a backup of the whole database, ask
+// about every table they read or write, and ImportJSON asks about every table
+// as it reaches it in the export. SyncWith asks the authorizers of both
+// databases. Returning an error refuses the operation, which then fails with
+// an error wrapping both ErrForbidden and the returned error.
+type Authorizer func(ctx context.Context, tblName string, op string, fileId string) error
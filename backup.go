@@ -0,0 +1,695 @@
+package ivy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupRecordExt is the extension of the record files in a backup archive.
+const backupRecordExt = ".json"
+
+// backupManifestName is the name of the manifest inside a backup archive. It
+// is the last entry of the archive.
+const backupManifestName = metaDir + "/backup-manifest.json"
+
+// backupManifestVersion is the version of the backup manifest format.
+const backupManifestVersion = 1
+
+// Type RestoreOptions is a struct holding the options of DB.Restore. Table is
+// the table to restore. Id, if set, restricts the restore to a single record.
+type RestoreOptions struct {
+	Table string
+	Id    string
+}
+
+// Type BackupManifest is a struct describing the contents of a backup. Tables
+// maps every table, and every record id in the table, to a checksum of the
+// record, so that a later incremental backup can tell which records changed.
+// The manifest of an incremental backup lists all records of the database,
+// including the unchanged ones left out of the archive, so it can serve as
+// the base of the next incremental backup. LSN is set if the database has a
+// write-ahead log: every change up to it is in the backup, which makes it the
+// position a replication follower restored from the backup starts from.
+type BackupManifest struct {
+	Version     int                          `json:"version"`
+	Created     time.Time                    `json:"created"`
+	Incremental bool                         `json:"incremental"`
+	LSN         uint64                       `json:"lsn,omitempty"`
+	Tables      map[string]map[string]string `json:"tables"`
+}
+
+// RestoreBackup unpacks a backup archive written by DB.Backup into a new
+// database directory, which can then be opened with OpenDB. The archive is
+// unpacked into a temporary directory next to dbPath that is only renamed into
+// place once it is complete, so an interrupted restore never leaves a partial
+// database behind. It takes a reader to read the archive from and the path of
+// the database directory, which must not exist or be empty. It returns any
+// error encountered.
+func RestoreBackup(r io.Reader, dbPath string) error {
+	return restoreBackup(r, dbPath, nil)
+}
+
+// RestoreIncremental applies an incremental backup written by
+// DB.BackupIncremental to a database directory restored from the earlier
+// backups it builds on: changed and new records are written, and records that
+// no longer existed at the time of the backup are deleted. The database must
+// not be open while it is restored. It takes a reader to read the archive
+// from and the path of the database directory. It returns any error
+// encountered.
+func RestoreIncremental(r io.Reader, dbPath string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Clean(dbPath) + tmpMarker + hex.EncodeToString(suffix)
+	defer os.RemoveAll(tmpPath)
+
+	err := os.MkdirAll(tmpPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := extractBackup(r, tmpPath)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return errors.New("ivy: backup archive has no manifest")
+	}
+
+	for tblName, sums := range manifest.Tables {
+		err = applyIncrementalTbl(tmpPath, dbPath, tblName, sums)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Join(dbPath, metaDir), 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(tmpPath, filepath.FromSlash(backupManifestName)), filepath.Join(dbPath, filepath.FromSlash(backupManifestName)))
+}
+
+// ReadBackupManifest reads the manifest of a backup archive written by
+// DB.Backup or DB.BackupIncremental, to be passed to the next
+// DB.BackupIncremental. It takes a reader to read the archive from. It
+// returns the manifest and any error encountered.
+func ReadBackupManifest(r io.Reader) (*BackupManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("ivy: backup archive has no manifest")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Name == backupManifestName {
+			return decodeBackupManifest(tr)
+		}
+	}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Backup writes a snapshot of every table to w as a gzipped tar archive. The
+// archive holds a directory per table and a json file per record, the same
+// layout a database with default options uses on disk, so it can be restored
+// with RestoreBackup or simply unpacked with tar. Backups are hot: each record
+// is read under its table's read lock only for as long as it takes to read it,
+// so writers are never held up for the duration of the backup. Every record
+// in the archive is consistent, but records changed while the backup runs may
+// appear in their old or their new version, and records created after a table
+// was listed are left out. It takes the writer to write the archive to. It
+// returns any error encountered.
+func (db *DB) Backup(w io.Writer) error {
+	return db.BackupCtx(context.Background(), w)
+}
+
+// BackupCtx is Backup with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (db *DB) BackupCtx(ctx context.Context, w io.Writer) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	ops, err := db.beginOps(ctx, db.tableNames(), "backup")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	return db.backup(ctx, w, nil)
+}
+
+// BackupIncremental writes an incremental backup to w. It works like Backup,
+// except that records that have not changed since the backup described by
+// since are left out of the archive. Restore it on top of a database restored
+// from the earlier backups with RestoreIncremental. It takes the writer to
+// write the archive to, and the manifest of the previous backup, as returned
+// by ReadBackupManifest. It returns any error encountered.
+func (db *DB) BackupIncremental(w io.Writer, since *BackupManifest) error {
+	return db.BackupIncrementalCtx(context.Background(), w, since)
+}
+
+// BackupIncrementalCtx is BackupIncremental with a context. It stops reading
+// records with the context's error as soon as the context is done.
+func (db *DB) BackupIncrementalCtx(ctx context.Context, w io.Writer, since *BackupManifest) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	if since == nil {
+		return errors.New("ivy: incremental backup needs the manifest of a previous backup")
+	}
+
+	ops, err := db.beginOps(ctx, db.tableNames(), "backup")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	return db.backup(ctx, w, since)
+}
+
+// Restore restores part of a backup archive written by Backup or
+// BackupIncremental into the live database. With only RestoreOptions.Table
+// set, the whole table is restored: every record in the archive is written,
+// and records that did not exist at the time of the backup are deleted. With
+// RestoreOptions.Id set too, only that record is written. The table stays
+// locked for writing while it is restored and its indexes are kept up to
+// date. It takes a reader to read the archive from and the restore options.
+// It returns any error encountered.
+func (db *DB) Restore(r io.Reader, opts RestoreOptions) error {
+	return db.RestoreCtx(context.Background(), r, opts)
+}
+
+// RestoreCtx is Restore with a context, which is handed to the authorizer.
+func (db *DB) RestoreCtx(ctx context.Context, r io.Reader, opts RestoreOptions) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, opts.Table, opts.Id, "restore")
+	defer func() { db.endOp(op, opts.Id, err) }()
+	if err != nil {
+		return err
+	}
+
+	rwLock := db.tblLock(opts.Table)
+	if rwLock == nil {
+		return fmt.Errorf("ivy: no table %q to restore into", opts.Table)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if opts.Id != "" {
+		if err := checkId(opts.Id); err != nil {
+			return err
+		}
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	var manifest *BackupManifest
+	restored := make(map[string]bool)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == backupManifestName {
+			manifest, err = decodeBackupManifest(tr)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		tblName, fileName := path.Split(hdr.Name)
+		fileId := strings.TrimSuffix(fileName, backupRecordExt)
+
+		if hdr.Typeflag != tar.TypeReg || tblName != opts.Table+"/" || fileId == fileName {
+			continue
+		}
+		if opts.Id != "" && fileId != opts.Id {
+			continue
+		}
+
+		// Ids name the record files, so a crafted archive must not get to
+		// choose a path.
+		if err := checkId(fileId); err != nil {
+			return fmt.Errorf("ivy: cannot restore %s: %w", hdr.Name, err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		err = db.writeRec(ctx, opts.Table, fileId, data, true)
+		if err != nil {
+			return err
+		}
+
+		restored[fileId] = true
+	}
+
+	if opts.Id != "" {
+		if !restored[opts.Id] {
+			return notExist("restore", opts.Table, opts.Id)
+		}
+		return nil
+	}
+
+	// Delete the records that did not exist at the time of the backup. The
+	// manifest of an incremental backup also lists the records it left out.
+	keep := restored
+	if manifest != nil {
+		keep = make(map[string]bool)
+		for fileId := range manifest.Tables[opts.Table] {
+			keep[fileId] = true
+		}
+	}
+
+	fileIds, err := db.engine.ids(opts.Table)
+	if err != nil {
+		return err
+	}
+
+	for _, fileId := range fileIds {
+		if keep[fileId] {
+			continue
+		}
+
+		err = db.removeRec(ctx, opts.Table, fileId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// backup writes a backup archive, leaving out the records that are unchanged
+// since the backup described by since, if it is not nil. It stops with the
+// context's error as soon as the context is done.
+func (db *DB) backup(ctx context.Context, w io.Writer, since *BackupManifest) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest := &BackupManifest{
+		Version:     backupManifestVersion,
+		Created:     time.Now().UTC(),
+		Incremental: since != nil,
+		Tables:      make(map[string]map[string]string),
+	}
+
+	if db.wal != nil {
+		manifest.LSN = db.wal.horizon() - 1
+	}
+
+	for _, tblName := range db.tableNames() {
+		err := db.backupTbl(ctx, tw, tblName, manifest, since)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, backupManifestName, data, manifest.Created)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// backupTbl writes a table and its records to a tar archive, adding them to
+// the manifest. Records whose checksum matches the one in since are left out.
+func (db *DB) backupTbl(ctx context.Context, tw *tar.Writer, tblName string, manifest *BackupManifest, since *BackupManifest) error {
+	modTime := manifest.Created
+	sums := make(map[string]string)
+	manifest.Tables[tblName] = sums
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     tblName + "/",
+		Mode:     0700,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	db.tblLock(tblName).RLock()
+	fileIds, err := db.engine.ids(tblName)
+	db.tblLock(tblName).RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := db.backupRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			// Deleted since the table was listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err = db.redact(tblName, fileId, data)
+		if err != nil {
+			return err
+		}
+
+		sum := fmt.Sprintf("%08x", crc32.Checksum(data, checksumTable))
+		sums[fileId] = sum
+
+		if since != nil && since.Tables[tblName][fileId] == sum {
+			continue
+		}
+
+		err = writeTarFile(tw, path.Join(tblName, fileId+backupRecordExt), data, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupRec reads a record for a backup, holding the table's read lock only
+// while doing so.
+func (db *DB) backupRec(tblName string, fileId string) ([]byte, error) {
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	return db.readRec(tblName, fileId)
+}
+
+// tableNames returns the names of all tables of the database, in order.
+func (db *DB) tableNames() []string {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
+
+	tblNames := make([]string, 0, len(db.rwLocks))
+	for tblName := range db.rwLocks {
+		tblNames = append(tblNames, tblName)
+	}
+	sort.Strings(tblNames)
+
+	return tblNames
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// restoreBackup unpacks a backup archive into a new database directory, as
+// RestoreBackup does. If prepare is not nil, it is called with the path of the
+// unpacked database and the manifest of the backup before the database is
+// renamed into place.
+func restoreBackup(r io.Reader, dbPath string, prepare func(tmpPath string, manifest *BackupManifest) error) error {
+	files, err := ioutil.ReadDir(dbPath)
+	if err == nil && len(files) > 0 {
+		return fmt.Errorf("ivy: cannot restore into %s: directory is not empty", dbPath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Clean(dbPath) + tmpMarker + hex.EncodeToString(suffix)
+
+	err = os.MkdirAll(tmpPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := extractBackup(r, tmpPath)
+	if err == nil && manifest != nil && manifest.Incremental {
+		err = errors.New("ivy: cannot restore an incremental backup on its own; use RestoreIncremental")
+	}
+	if err == nil && prepare != nil {
+		err = prepare(tmpPath, manifest)
+	}
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return err
+	}
+
+	os.Remove(dbPath)
+
+	err = os.Rename(tmpPath, dbPath)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// extractBackup unpacks a backup archive into a directory. It returns the
+// manifest of the backup, or nil if the archive has none.
+func extractBackup(r io.Reader, dir string) (*BackupManifest, error) {
+	var manifest *BackupManifest
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := backupEntryPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(target), 0700)
+			if err == nil {
+				err = extractTarFile(tr, target)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Name == backupManifestName {
+			f, err := os.Open(target)
+			if err != nil {
+				return nil, err
+			}
+			manifest, err = decodeBackupManifest(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// applyIncrementalTbl copies the records of a table unpacked from an
+// incremental backup into the database directory and deletes the records
+// that are not listed in the manifest.
+func applyIncrementalTbl(srcPath string, dbPath string, tblName string, sums map[string]string) error {
+	srcDir := filepath.Join(srcPath, tblName)
+	dstDir := filepath.Join(dbPath, tblName)
+
+	err := os.MkdirAll(dstDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(srcDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, file := range files {
+		err = os.Rename(filepath.Join(srcDir, file.Name()), filepath.Join(dstDir, file.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	files, err = ioutil.ReadDir(dstDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		fileId := strings.TrimSuffix(file.Name(), backupRecordExt)
+		if file.IsDir() || fileId == file.Name() {
+			continue
+		}
+
+		if _, ok := sums[fileId]; !ok {
+			err = os.Remove(filepath.Join(dstDir, file.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeBackupManifest reads a backup manifest.
+func decodeBackupManifest(r io.Reader) (*BackupManifest, error) {
+	var manifest BackupManifest
+
+	err := json.NewDecoder(r).Decode(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version != backupManifestVersion {
+		return nil, fmt.Errorf("ivy: unsupported backup manifest version %d", manifest.Version)
+	}
+
+	return &manifest, nil
+}
+
+// extractTarFile writes the current file of a tar archive to disk.
+func extractTarFile(tr *tar.Reader, target string) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, tr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// backupEntryPath checks the name of an entry in a backup archive and returns
+// it as a relative path, or "" for the archive root. Names that would escape
+// the database directory are rejected.
+func backupEntryPath(name string) (string, error) {
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+
+	if clean == "." {
+		return "", nil
+	}
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("ivy: invalid backup entry " + name)
+	}
+
+	return filepath.FromSlash(clean), nil
+}
+
+// writeTarFile adds a regular file to a tar archive.
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+
+	return err
+}
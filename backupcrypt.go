@@ -0,0 +1,235 @@
+package ivy
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// encryptedBackupMagic starts every encrypted backup.
+const encryptedBackupMagic = "IVYENC01"
+
+// encryptedChunkSize is the amount of plaintext sealed in each chunk of an
+// encrypted backup.
+const encryptedChunkSize = 64 << 10
+
+// encryptedNoncePrefixSize is the size of the random part of chunk nonces.
+// The rest of the 12 byte nonce is a 4 byte chunk counter and a byte marking
+// the last chunk, so chunks cannot be reordered, dropped or truncated without
+// detection.
+const encryptedNoncePrefixSize = 7
+
+// ErrBadBackupKey is returned when an encrypted backup cannot be decrypted,
+// because the key is wrong or the backup was tampered with.
+var ErrBadBackupKey = errors.New("ivy: wrong key or corrupt encrypted backup")
+
+// encryptWriter seals everything written to it in chunks with AES-GCM.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	count  uint32
+	err    error
+}
+
+// decryptReader opens the chunks written by an encryptWriter.
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	plain  []byte
+	count  uint32
+	done   bool
+}
+
+// EncryptBackup returns a writer that encrypts everything written to it with
+// AES-GCM before passing it on to w, for backups that leave the host. Wrap the
+// writer passed to DB.Backup or DB.BackupIncremental with it, and close it
+// when the backup is written. The data is sealed in chunks, so that backups
+// of any size can be streamed, and each chunk is authenticated, so a backup
+// that was tampered with or cut short fails to decrypt. It takes the writer to
+// write the encrypted backup to and a 16, 24 or 32 byte AES key. It returns
+// the encrypting writer and any error encountered.
+func EncryptBackup(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, encryptedNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptedBackupMagic), prefix...)
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, aead: aead, header: header, prefix: prefix}, nil
+}
+
+// DecryptBackup returns a reader that decrypts a backup encrypted with
+// EncryptBackup. Pass it to RestoreBackup, RestoreIncremental, DB.Restore or
+// ReadBackupManifest. Reads return ErrBadBackupKey if the key is wrong or the
+// backup was tampered with. It takes the reader to read the encrypted backup
+// from and the key it was encrypted with. It returns the decrypting reader and
+// any error encountered.
+func DecryptBackup(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(encryptedBackupMagic)+encryptedNoncePrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(encryptedBackupMagic)]) != encryptedBackupMagic {
+		return nil, errors.New("ivy: not an encrypted backup")
+	}
+
+	return &decryptReader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		prefix: header[len(encryptedBackupMagic):],
+		buf:    make([]byte, encryptedChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		n := encryptedChunkSize - len(e.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		// Only seal a full chunk once more data arrives, so that the last
+		// chunk is always sealed by Close.
+		if len(e.buf) == encryptedChunkSize && len(p) > 0 {
+			if e.err = e.seal(false); e.err != nil {
+				return written, e.err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	e.err = e.seal(true)
+	if e.err != nil {
+		return e.err
+	}
+
+	e.err = errors.New("ivy: write to closed backup encrypter")
+
+	return nil
+}
+
+// seal encrypts and writes the buffered chunk.
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.count, last), e.buf, e.header)
+
+	e.buf = e.buf[:0]
+	e.count++
+
+	_, err := e.w.Write(sealed)
+
+	return err
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	if err == io.EOF {
+		// The last chunk is missing.
+		return ErrBadBackupKey
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.prefix, d.count, last), d.buf[:n], d.header)
+	if err != nil {
+		return ErrBadBackupKey
+	}
+
+	d.plain = plain
+	d.count++
+	d.done = last
+
+	return nil
+}
+
+// newBackupAEAD returns an AES-GCM cipher using key.
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk of an encrypted backup.
+func chunkNonce(prefix []byte, count uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedNoncePrefixSize:], count)
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
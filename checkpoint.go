@@ -0,0 +1,368 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// metaDir is the name of the hidden directory, inside the database directory,
+// holding ivy's own files such as index checkpoints.
+const metaDir = ".ivy"
+
+// indexCheckpointVersion is the version of the index checkpoint format.
+const indexCheckpointVersion = 2
+
+// indexCheckpoint is the on-disk form of the indexes of a table.
+//
+// LSN is the sequence number of the last write-ahead log entry the indexes
+// reflect, or zero without a log. When the database is opened, the changes
+// to the table logged after it are replayed into the indexes. Fingerprint
+// identifies the state of the record files the indexes were built from; a
+// checkpoint with no changes to replay whose fingerprint no longer matches
+// the table (because the program crashed after changing records but before
+// checkpointing, or because records were edited by hand) is ignored and the
+// indexes are rebuilt from the records.
+type indexCheckpoint struct {
+	Version     int                            `json:"version"`
+	LSN         uint64                         `json:"lsn"`
+	Fields      []string                       `json:"fields"`
+	Fingerprint string                         `json:"fingerprint"`
+	TagIndex    map[string][]string            `json:"tagIndex"`
+	FldIndexes  map[string]map[string][]string `json:"fldIndexes"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Checkpoint writes the indexes of every table that changed since its last
+// checkpoint to disk, so that the next OpenDB can load them instead of reading
+// every record. Checkpoints are written to a temporary file that is then
+// renamed into place, so a crash never leaves a half-written checkpoint. It
+// only has work to do when Options.PersistentIndexes is set.
+// It returns any error encountered.
+func (db *DB) Checkpoint() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	return db.checkpoint()
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkpoint writes the index checkpoint of every table that changed.
+func (db *DB) checkpoint() error {
+	if !db.persistIndexes {
+		return nil
+	}
+
+	for _, tblName := range db.indexedTables() {
+		err := db.checkpointTbl(tblName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkpointTbl writes the index checkpoint of a table, if it changed.
+func (db *DB) checkpointTbl(tblName string) error {
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	db.genMu.Lock()
+	generation := db.generations[tblName]
+	checkpointed, ok := db.checkpointed[tblName]
+	db.genMu.Unlock()
+
+	if ok && checkpointed == generation {
+		return nil
+	}
+
+	// Flush pending writes first, so that the fingerprint covers every change
+	// reflected in the indexes.
+	err := db.engine.sync()
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := db.engine.fingerprint(tblName)
+	if err != nil || fingerprint == "" {
+		return err
+	}
+
+	// Every write to the table that was logged has been applied, as the
+	// table is locked.
+	var lsn uint64
+	if db.wal != nil {
+		lsn = db.wal.last()
+	}
+
+	fldNames, _ := db.indexFields(tblName)
+
+	cp := indexCheckpoint{
+		Version:     indexCheckpointVersion,
+		LSN:         lsn,
+		Fields:      sortedStrings(fldNames),
+		Fingerprint: fingerprint,
+		TagIndex:    db.tagIndex(tblName),
+		FldIndexes:  db.fldIndex(tblName),
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	data, err = db.sealer.seal(data, "indexes/"+tblName)
+	if err != nil {
+		return err
+	}
+
+	err = db.fs.MkdirAll(db.metaPath("indexes"), db.modes.dir)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(db.fs, db.indexCheckpointPath(tblName), data, db.modes.file)
+	if err != nil {
+		return err
+	}
+
+	db.genMu.Lock()
+	db.checkpointed[tblName] = generation
+	db.genMu.Unlock()
+
+	return nil
+}
+
+// loadTblIndexes initializes the indexes of a table from its checkpoint if
+// the checkpoint is still valid, bringing them up to date with the entries of
+// the write-ahead log, and from the records otherwise.
+func (db *DB) loadTblIndexes(tblName string, entries []walEntry) error {
+	if db.persistIndexes {
+		if db.readIndexCheckpoint(tblName, entries) {
+			return nil
+		}
+
+		db.logger.Info("ivy: index checkpoint missing or stale, rebuilding", "table", tblName)
+	}
+
+	return db.initTblIndexes(context.Background(), tblName)
+}
+
+// readIndexCheckpoint loads the index checkpoint of a table, replaying the
+// changes logged in the write-ahead log entries since. It answers whether a
+// valid checkpoint was loaded. An unreadable checkpoint is not an error; the
+// indexes are simply rebuilt.
+func (db *DB) readIndexCheckpoint(tblName string, entries []walEntry) bool {
+	data, err := db.fs.ReadFile(db.indexCheckpointPath(tblName))
+	if err != nil {
+		return false
+	}
+
+	data, err = db.sealer.open(data, "indexes/"+tblName)
+	if err != nil {
+		return false
+	}
+
+	var cp indexCheckpoint
+
+	err = json.Unmarshal(data, &cp)
+	if err != nil || cp.Version != indexCheckpointVersion {
+		return false
+	}
+
+	fldNames, _ := db.indexFields(tblName)
+
+	if !equalStrings(cp.Fields, sortedStrings(fldNames)) {
+		return false
+	}
+
+	// Without the changes since the checkpoint, the records must still be
+	// as they were.
+	changes, ok := walChangesSince(entries, tblName, cp.LSN)
+	if !ok || len(changes) == 0 {
+		fingerprint, err := db.engine.fingerprint(tblName)
+		if err != nil || fingerprint == "" || fingerprint != cp.Fingerprint {
+			return false
+		}
+
+		changes = nil
+	}
+
+	if cp.TagIndex == nil {
+		cp.TagIndex = make(map[string][]string)
+	}
+	if cp.FldIndexes == nil {
+		cp.FldIndexes = make(map[string]map[string][]string)
+	}
+	for _, fldName := range fldNames {
+		if fldName != "tags" && cp.FldIndexes[fldName] == nil {
+			cp.FldIndexes[fldName] = make(map[string][]string)
+		}
+	}
+
+	db.setIndexes(tblName, cp.TagIndex, cp.FldIndexes)
+
+	if len(changes) > 0 {
+		err = db.replayIndexes(tblName, changes)
+		if err != nil {
+			return false
+		}
+
+		db.logger.Info("ivy: replayed logged changes into the index checkpoint", "table", tblName,
+			"from", cp.LSN+1, "changes", len(changes))
+
+		// The checkpoint is out of date until it is written again.
+		db.bumpGeneration(tblName)
+
+		return true
+	}
+
+	db.genMu.Lock()
+	db.checkpointed[tblName] = db.generations[tblName]
+	db.genMu.Unlock()
+
+	return true
+}
+
+// replayIndexes brings the indexes of a table loaded from a checkpoint up to
+// date with the changes logged since: the index entries of every changed
+// record move from its version at the time of the checkpoint to its current
+// one. The changes are those returned by walChangesSince.
+func (db *DB) replayIndexes(tblName string, changes []walEntry) error {
+	replayed := make(map[string]bool)
+
+	for _, e := range changes {
+		if replayed[e.Id] {
+			continue
+		}
+		replayed[e.Id] = true
+
+		// The first change of a record replaced its version at the time of
+		// the checkpoint.
+		var oldData, newData []byte
+		var err error
+
+		if e.Old != nil {
+			oldData, err = db.decodeRec(tblName, e.Id, e.Old)
+			if err != nil {
+				return err
+			}
+		}
+
+		raw, err := db.engine.read(tblName, e.Id)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			newData, err = db.decodeRec(tblName, e.Id, raw)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = db.updateTblIndexes(tblName, e.Id, oldData, newData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bumpGeneration records that a table changed.
+func (db *DB) bumpGeneration(tblName string) {
+	db.genMu.Lock()
+	defer db.genMu.Unlock()
+
+	db.generations[tblName]++
+}
+
+// indexCheckpointPath returns the path of the index checkpoint of a table.
+func (db *DB) indexCheckpointPath(tblName string) string {
+	return db.metaPath("indexes", tblName+".json")
+}
+
+// metaPath returns the path of a file or directory inside the metadata
+// directory.
+func (db *DB) metaPath(elem ...string) string {
+	return filepath.Join(append([]string{db.path, metaDir}, elem...)...)
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// walChangesSince returns the changes to a table in write-ahead log entries
+// that were logged after the sequence number lsn, in order, leaving out those
+// of transactions that did not commit. It answers false if lsn is zero or the
+// entries no longer start right after it.
+func walChangesSince(entries []walEntry, tblName string, lsn uint64) ([]walEntry, bool) {
+	if lsn == 0 || len(entries) == 0 || entries[0].LSN > lsn+1 {
+		return nil, false
+	}
+
+	committed := make(map[uint64]bool)
+	aborted := make(map[uint64]bool)
+
+	for _, e := range entries {
+		if e.LSN <= lsn {
+			continue
+		}
+
+		switch e.Op {
+		case walPut, walDelete:
+			if e.Commit {
+				committed[e.Tx] = true
+			}
+		case walCommit:
+			committed[e.Tx] = true
+		case walAbort:
+			aborted[e.Tx] = true
+		}
+	}
+
+	var changes []walEntry
+
+	for _, e := range entries {
+		if e.LSN <= lsn || e.Table != tblName || (e.Op != walPut && e.Op != walDelete) {
+			continue
+		}
+		if committed[e.Tx] && !aborted[e.Tx] {
+			changes = append(changes, e)
+		}
+	}
+
+	return changes, true
+}
+
+// sortedStrings returns a sorted copy of a slice of strings.
+func sortedStrings(list []string) []string {
+	sorted := append([]string(nil), list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// equalStrings answers whether two slices hold the same strings in the same
+// order.
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+)
+
+// checksumField is the name of the field that holds a record's checksum when
+// checksums are enabled. It is appended to the record when it is written and
+// stripped again when it is read.
+const checksumField = "_checksum"
+
+// checksumTable is the CRC-32 polynomial used for record checksums.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumSuffix matches the checksum field at the end of a record.
+var checksumSuffix = regexp.MustCompile(`[,{]"` + checksumField + `":"([0-9a-f]{8})"}$`)
+
+// Type VerifyReport is a struct describing the result of verifying a table.
+// Checked is the number of records read, Unchecked the number of those that
+// had no checksum (because they were written before checksums were enabled),
+// and Corrupt holds the ids of the records that failed verification.
+type VerifyReport struct {
+	Checked   int
+	Unchecked int
+	Corrupt   []string
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Verify reads every record of a table and checks it against its checksum.
+// Records without a checksum are only checked for being valid json. It takes
+// a table name. It returns a report listing the corrupt records and any error
+// encountered. A corrupt record is not an error.
+func (db *DB) Verify(tblName string) (*VerifyReport, error) {
+	return db.VerifyCtx(context.Background(), tblName)
+}
+
+// VerifyCtx is Verify with a context, which is handed to the authorizer.
+func (db *DB) VerifyCtx(ctx context.Context, tblName string) (_ *VerifyReport, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "verify")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{}
+
+	for _, fileId := range fileIds {
+		data, err := db.engine.read(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Checked++
+
+		if checksumSuffix.Find(data) == nil {
+			report.Unchecked++
+		}
+
+		data, err = db.decodeRec(tblName, fileId, data)
+		if err == nil && !json.Valid(data) {
+			err = corruptErr(tblName, fileId, "invalid json")
+		}
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, fileId)
+		}
+	}
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// encodeRec prepares a marshalled record for storage, appending its checksum
+// if checksums are enabled.
+func (db *DB) encodeRec(data []byte) []byte {
+	if !db.checksums {
+		return data
+	}
+
+	// The trailing newline of Options.StableOutput goes after the checksum.
+	if db.stableOutput != nil {
+		return append(appendChecksum(data), '\n')
+	}
+
+	return appendChecksum(data)
+}
+
+// decodeRec verifies and strips the checksum of a stored record. Records
+// without a checksum are returned unchanged, whether or not checksums are
+// enabled, so that tables written before checksums were enabled stay
+// readable.
+func (db *DB) decodeRec(tblName string, fileId string, data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+
+	loc := checksumSuffix.FindSubmatchIndex(data)
+	if loc == nil {
+		return data, nil
+	}
+
+	want := string(data[loc[2]:loc[3]])
+
+	var decoded []byte
+	if data[loc[0]] == '{' {
+		decoded = append(append(decoded, data[:loc[0]+1]...), '}')
+	} else {
+		decoded = append(append(decoded, data[:loc[0]]...), '}')
+	}
+
+	if got := fmt.Sprintf("%08x", crc32.Checksum(decoded, checksumTable)); got != want {
+		return nil, corruptErr(tblName, fileId, "checksum mismatch")
+	}
+
+	return decoded, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// appendChecksum appends the checksum field to a marshalled record.
+func appendChecksum(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '{' || data[len(data)-1] != '}' {
+		return data
+	}
+
+	sep := ","
+	if bytes.Equal(data, []byte("{}")) {
+		sep = ""
+	}
+
+	field := fmt.Sprintf(`%s"%s":"%08x"}`, sep, checksumField, crc32.Checksum(data, checksumTable))
+
+	encoded := make([]byte, 0, len(data)+len(field))
+	encoded = append(encoded, data[:len(data)-1]...)
+	encoded = append(encoded, field...)
+
+	return encoded
+}
+
+// corruptErr returns an error wrapping ErrCorrupt that names the record.
+func corruptErr(tblName string, fileId string, reason string) error {
+	return fmt.Errorf("%w: %s/%s: %s", ErrCorrupt, tblName, fileId, reason)
+}
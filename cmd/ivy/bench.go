@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jameycribbs/ivy"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchTable is the table the bench command fills with synthetic records.
+const benchTable = "bench"
+
+// benchKinds are the kinds of operations the bench command runs, in the order
+// they are reported.
+var benchKinds = []string{"read", "write", "query", "scan"}
+
+// benchRecord is a synthetic record of the bench command.
+type benchRecord struct {
+	Name     string   `json:"name"`
+	Category string   `json:"category"`
+	Value    float64  `json:"value"`
+	Payload  string   `json:"payload"`
+	Tags     []string `json:"tags"`
+}
+
+func (r *benchRecord) AfterFind(db *ivy.DB, fileId string) {
+}
+
+// benchMix is the share of every kind of operation, from the -mix flag.
+type benchMix map[string]int
+
+func (m benchMix) String() string {
+	var parts []string
+	for _, kind := range benchKinds {
+		if m[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", kind, m[kind]))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (m benchMix) Set(value string) error {
+	for k := range m {
+		delete(m, k)
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		i := strings.IndexByte(part, '=')
+		if i <= 0 {
+			return errors.New("expected kind=weight,...")
+		}
+
+		kind := part[:i]
+		if !stringIn(kind, benchKinds) {
+			return fmt.Errorf("unknown operation %s, expected one of %s", kind, strings.Join(benchKinds, ", "))
+		}
+
+		weight, err := strconv.Atoi(part[i+1:])
+		if err != nil || weight < 0 {
+			return fmt.Errorf("invalid weight %q", part[i+1:])
+		}
+
+		m[kind] = weight
+	}
+
+	return nil
+}
+
+// pick returns a kind of operation at random, according to the mix.
+func (m benchMix) pick(rnd *rand.Rand) string {
+	total := 0
+	for _, kind := range benchKinds {
+		total += m[kind]
+	}
+
+	n := rnd.Intn(total)
+	for _, kind := range benchKinds {
+		if n < m[kind] {
+			return kind
+		}
+		n -= m[kind]
+	}
+
+	return benchKinds[0]
+}
+
+// benchCmd runs the bench command, which opens a database of its own in an
+// empty directory, fills a table with synthetic records and runs a mix of
+// operations on it, reporting their throughput and latency. It returns the
+// exit status.
+func benchCmd(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ivy bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	storage := flags.String("storage", "file", "the storage engine: file, packed or memory")
+	records := flags.Int("records", 1000, "the number of records loaded before the run")
+	ops := flags.Int("ops", 10000, "the number of operations run")
+	workers := flags.Int("workers", 4, "the number of operations run at the same time")
+	size := flags.Int("size", 256, "the size, in bytes, of the payload of every record")
+	seed := flags.Int64("seed", 1, "the seed of the random operations and records")
+	checksums := flags.Bool("checksums", false, "store a checksum with every record")
+	wal := flags.Bool("wal", false, "turn on the write-ahead log")
+	keep := flags.Bool("keep", false, "keep the database after the run")
+	mix := benchMix{"read": 80, "write": 15, "query": 5}
+	flags.Var(mix, "mix", "the share of every kind of operation: read, write, query and scan")
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: ivy bench [flags] DIR")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 || *records < 1 || *ops < 0 || *workers < 1 || *size < 0 || mix.String() == "" {
+		flags.Usage()
+		return 2
+	}
+
+	opts := ivy.Options{Checksums: *checksums}
+
+	switch *storage {
+	case "file":
+		opts.Storage = ivy.FileStorage
+	case "packed":
+		opts.Storage = ivy.PackedStorage
+	case "memory":
+		opts.Storage = ivy.MemoryStorage
+	default:
+		fmt.Fprintf(stderr, "ivy: unknown storage %s\n", *storage)
+		return 2
+	}
+
+	if *wal {
+		opts.WAL = &ivy.WALOptions{}
+	}
+
+	dir := flags.Arg(0)
+
+	err := bench(dir, opts, *records, *ops, *workers, *size, *seed, mix, *keep, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "ivy:", strings.TrimPrefix(err.Error(), "ivy: "))
+		return 1
+	}
+
+	return 0
+}
+
+// bench runs a benchmark in dir and writes its report to w.
+func bench(dir string, opts ivy.Options, records int, ops int, workers int, size int, seed int64,
+	mix benchMix, keep bool, w io.Writer) error {
+	dbPath := dir
+
+	if opts.Storage == ivy.MemoryStorage {
+		dbPath = ""
+	} else {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("%s is not empty; bench needs a directory of its own", dir)
+		}
+
+		err = os.MkdirAll(filepath.Join(dir, benchTable), 0700)
+		if err != nil {
+			return err
+		}
+
+		if !keep {
+			defer os.RemoveAll(dir)
+		}
+	}
+
+	db, err := ivy.OpenDBWithOptions(dbPath, map[string][]string{benchTable: {"category"}}, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rnd := rand.New(rand.NewSource(seed))
+	payload := strings.Repeat("x", size)
+
+	newRecord := func(rnd *rand.Rand, n int) *benchRecord {
+		return &benchRecord{
+			Name:     fmt.Sprintf("item-%d", n),
+			Category: fmt.Sprintf("cat-%d", rnd.Intn(20)),
+			Value:    rnd.Float64(),
+			Payload:  payload,
+			Tags:     []string{},
+		}
+	}
+
+	start := time.Now()
+
+	fileIds := make([]string, records)
+	for i := range fileIds {
+		fileIds[i], err = db.Create(benchTable, newRecord(rnd, i))
+		if err != nil {
+			return err
+		}
+	}
+
+	load := time.Since(start)
+
+	latencies := make(map[string][]time.Duration)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+
+	start = time.Now()
+
+	for i := 0; i < workers; i++ {
+		n := ops / workers
+		if i < ops%workers {
+			n++
+		}
+
+		wg.Add(1)
+
+		go func(rnd *rand.Rand, n int) {
+			defer wg.Done()
+
+			local := make(map[string][]time.Duration)
+
+			for j := 0; j < n; j++ {
+				kind := mix.pick(rnd)
+				fileId := fileIds[rnd.Intn(len(fileIds))]
+
+				opStart := time.Now()
+
+				var err error
+
+				switch kind {
+				case "read":
+					err = db.Find(benchTable, &benchRecord{}, fileId)
+				case "write":
+					err = db.Update(benchTable, newRecord(rnd, j), fileId)
+				case "query":
+					_, err = db.QueryString(benchTable, fmt.Sprintf("category = 'cat-%d' AND value > 0.5", rnd.Intn(20)))
+				case "scan":
+					_, err = db.QueryString(benchTable, fmt.Sprintf("value > %v", rnd.Float64()))
+				}
+
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+
+				local[kind] = append(local[kind], time.Since(opStart))
+			}
+
+			mu.Lock()
+			for kind, ds := range local {
+				latencies[kind] = append(latencies[kind], ds...)
+			}
+			mu.Unlock()
+		}(rand.New(rand.NewSource(seed+int64(i)+1)), n)
+	}
+
+	wg.Wait()
+
+	elapsed := time.Since(start)
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	fmt.Fprintf(w, "storage %s, %d records of %d bytes, %d operations (%s), %d workers\n",
+		storageName(opts.Storage), records, size, ops, mix, workers)
+	fmt.Fprintf(w, "load: %d records in %v (%.0f records/s)\n\n", records, load.Round(time.Microsecond), rate(records, load))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\tops/s\tp50\tp90\tp99\tmax\t")
+
+	for _, kind := range benchKinds {
+		ds := latencies[kind]
+		if len(ds) == 0 {
+			continue
+		}
+
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%v\t%v\t%v\t%v\t\n", kind, len(ds), rate(len(ds), elapsed),
+			percentile(ds, 50), percentile(ds, 90), percentile(ds, 99), percentile(ds, 100))
+	}
+
+	fmt.Fprintf(tw, "total\t%d\t%.0f\t\t\t\t\t\n", ops, rate(ops, elapsed))
+
+	return tw.Flush()
+}
+
+// storageName returns the name of a storage engine, as the -storage flag of
+// the bench command takes it.
+func storageName(storage ivy.Storage) string {
+	switch storage {
+	case ivy.PackedStorage:
+		return "packed"
+	case ivy.MemoryStorage:
+		return "memory"
+	}
+
+	return "file"
+}
+
+// rate returns the number of events per second.
+func rate(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return float64(n) / d.Seconds()
+}
+
+// percentile returns the p-th percentile of sorted durations, rounded to the
+// microsecond.
+func percentile(ds []time.Duration, p int) time.Duration {
+	i := (len(ds)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return ds[i].Round(time.Microsecond)
+}
+
+// stringIn reports whether a string is in a slice.
+func stringIn(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
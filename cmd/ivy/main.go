@@ -0,0 +1,484 @@
+// Command ivy inspects and edits an ivy database from the command line.
+//
+// Usage:
+//
+//	ivy [-db dir] [-index table=field,...] [-tokens file] command [arguments]
+//
+// The commands are:
+//
+//	tables                      list the tables
+//	ids TABLE                   list the ids of the records of a table
+//	get TABLE ID                print a record
+//	create TABLE JSON           create a record and print its id
+//	update TABLE ID JSON        replace a record
+//	delete TABLE ID             delete a record
+//	find TABLE FIELD VALUE      list the ids of the records with a field value
+//	tags TABLE TAG...           list the ids of the records with all the tags
+//	query TABLE QUERY           list the ids of the records matching a query
+//	explain TABLE QUERY         describe how a query runs and which index it uses
+//	save NAME TABLE QUERY       save a query under a name
+//	saved                       list the saved queries
+//	run NAME [ARG...]           list the ids of the records matching a saved query
+//	export TABLE                print the records of a table, one per line
+//	verify TABLE                check the records of a table for corruption
+//	reindex TABLE               rebuild the indexes of a table
+//	backup FILE                 write a backup archive to FILE
+//	serve ADDR                  serve the web admin UI at ADDR, such as :8080
+//	bench [flags] DIR           benchmark a synthetic table in the empty directory DIR
+//
+// With -tokens, serve requires the API tokens listed in the file, a JSON object
+// mapping each secret to its role and the tables it may write, such as
+// {"s3cret": {"role": "write", "tables": ["notes"]}}.
+//
+// The bench command ignores -db and -index, and takes flags of its own after
+// its name: -storage, -records, -ops, -workers, -mix, -size, -seed,
+// -checksums, -wal and -keep; see "ivy bench -h". It runs a mix of reads,
+// writes, indexed queries and scans, such as -mix read=70,write=20,query=10,
+// and reports the throughput and latency percentiles of each. The directory
+// is removed afterwards unless -keep is given.
+//
+// The arguments of run fill the "?" placeholders of the saved query, in order;
+// each is a JSON value, such as 300 or true, or else a string.
+//
+// A JSON argument of "-" is read from standard input. Tables are indexed on
+// the fields listed with -index, which may be repeated; tag queries index the
+// table's tags. The database must not be open in another process.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jameycribbs/ivy"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// rawRecord holds a record as marshalled JSON.
+type rawRecord []byte
+
+func (r *rawRecord) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+func (r *rawRecord) AfterFind(db *ivy.DB, fileId string) {
+}
+
+// indexFlag collects the -index flags.
+type indexFlag map[string][]string
+
+func (f indexFlag) String() string {
+	return ""
+}
+
+func (f indexFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 || i == len(value)-1 {
+		return errors.New("expected table=field,...")
+	}
+
+	tblName := value[:i]
+	f[tblName] = append(f[tblName], strings.Split(value[i+1:], ",")...)
+
+	return nil
+}
+
+// command is a command of the tool, with the number of arguments it takes, or
+// -n for n or more, and whether its first argument is a table.
+type command struct {
+	args  int
+	table bool
+	run   func(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"tables":  {0, false, tablesCmd},
+	"ids":     {1, true, idsCmd},
+	"get":     {2, true, getCmd},
+	"create":  {2, true, createCmd},
+	"update":  {3, true, updateCmd},
+	"delete":  {2, true, deleteCmd},
+	"find":    {3, true, findCmd},
+	"tags":    {-2, true, tagsCmd},
+	"query":   {2, true, queryCmd},
+	"explain": {2, true, explainCmd},
+	"save":    {3, false, saveCmd},
+	"saved":   {0, false, savedCmd},
+	"run":     {-1, false, runCmd},
+	"export":  {1, true, exportCmd},
+	"verify":  {1, true, verifyCmd},
+	"reindex": {1, true, reindexCmd},
+	"backup":  {1, false, backupCmd},
+	"serve":   {1, false, serveCmd},
+}
+
+// tokensPath is the file of the API tokens of the serve command.
+var tokensPath string
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the tool with the supplied arguments. It returns the exit status.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ivy", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	dbPath := flags.String("db", ".", "the database directory")
+	fieldsToIndex := indexFlag{}
+	flags.Var(fieldsToIndex, "index", "index a table on fields, as table=field,...")
+	flags.StringVar(&tokensPath, "tokens", "", "the file of the API tokens serve requires")
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: ivy [-db dir] [-index table=field,...] [-tokens file] command [arguments]")
+		fmt.Fprintln(stderr, "commands: tables, ids, get, create, update, delete, find, tags, query, explain, save, saved, run, export, verify, reindex, backup, serve, bench")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if args[0] == "bench" {
+		return benchCmd(args[1:], stdout, stderr)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok || (cmd.args >= 0 && len(args)-1 != cmd.args) || (cmd.args < 0 && len(args)-1 < -cmd.args) {
+		flags.Usage()
+		return 2
+	}
+
+	if args[0] == "tags" {
+		fieldsToIndex[args[1]] = append(fieldsToIndex[args[1]], "tags")
+	}
+
+	db, err := ivy.OpenDB(*dbPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		fmt.Fprintln(stderr, "ivy:", err)
+		return 1
+	}
+
+	if cmd.table {
+		err = checkTable(db, args[1])
+	}
+	if err == nil {
+		err = cmd.run(db, args[1:], stdin, stdout)
+	}
+
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "ivy:", strings.TrimPrefix(err.Error(), "ivy: "))
+		return 1
+	}
+
+	return 0
+}
+
+func tablesCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	tblNames, err := db.TableNames()
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, tblNames)
+}
+
+func idsCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	ids, err := db.FindAllIds(args[0])
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, ids)
+}
+
+func getCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	var rec rawRecord
+
+	err := db.Find(args[0], &rec, args[1])
+	if errors.Is(err, ivy.ErrNotFound) {
+		return fmt.Errorf("no record %s in table %s", args[1], args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(json.RawMessage(rec), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdout, "%s\n", out)
+
+	return err
+}
+
+func createCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	data, err := jsonArg(args[1], stdin)
+	if err != nil {
+		return err
+	}
+
+	fileId, err := db.Create(args[0], data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(stdout, fileId)
+
+	return err
+}
+
+func updateCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	data, err := jsonArg(args[2], stdin)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(args[0], data, args[1])
+}
+
+func deleteCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	err := db.Delete(args[0], args[1])
+	if errors.Is(err, ivy.ErrNotFound) {
+		return fmt.Errorf("no record %s in table %s", args[1], args[0])
+	}
+
+	return err
+}
+
+func findCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	ids, err := db.FindAllIdsForField(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, ids)
+}
+
+func tagsCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	ids, err := db.FindAllIdsForTags(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, ids)
+}
+
+func queryCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	ids, err := db.QueryString(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, ids)
+}
+
+func explainCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	plan, err := db.Explain(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(stdout, plan.String())
+
+	return err
+}
+
+func saveCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	return db.SaveQuery(args[0], ivy.SavedQuery{Table: args[1], Query: args[2]})
+}
+
+func savedCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	queries, err := db.SavedQueries()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range queries {
+		if _, err := fmt.Fprintf(stdout, "%s\t%s\t%s\n", q.Name, q.Table, q.Query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	queryArgs := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		queryArgs[i] = queryArg(arg)
+	}
+
+	ids, err := db.RunSaved(args[0], queryArgs...)
+	if errors.Is(err, ivy.ErrNotFound) {
+		return fmt.Errorf("no query saved as %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	return printLines(stdout, ids)
+}
+
+func exportCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	return db.ExportTable(args[0], stdout)
+}
+
+func verifyCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	report, err := db.Verify(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%d records checked, %d without checksum, %d corrupt\n",
+		report.Checked, report.Unchecked, len(report.Corrupt))
+
+	if len(report.Corrupt) > 0 {
+		printLines(stdout, report.Corrupt)
+		return fmt.Errorf("table %s has corrupt records", args[0])
+	}
+
+	return nil
+}
+
+func reindexCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	report, err := db.Repair(args[0], ivy.RepairOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdout, "%d records indexed, %d quarantined\n",
+		report.Checked-len(report.Quarantined), len(report.Quarantined))
+
+	return err
+}
+
+func backupCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = db.Backup(f)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(args[0])
+	}
+
+	return err
+}
+
+func serveCmd(db *ivy.DB, args []string, stdin io.Reader, stdout io.Writer) error {
+	handler := db.AdminHandler()
+
+	if tokensPath != "" {
+		data, err := ioutil.ReadFile(tokensPath)
+		if err != nil {
+			return err
+		}
+
+		var auth ivy.TokenAuth
+
+		err = json.Unmarshal(data, &auth)
+		if err != nil {
+			return fmt.Errorf("invalid tokens file %s: %v", tokensPath, err)
+		}
+
+		handler = auth.Wrap(handler)
+	}
+
+	fmt.Fprintf(stdout, "serving the admin UI at %s\n", args[0])
+
+	return http.ListenAndServe(args[0], handler)
+}
+
+// checkTable returns an error if the database has no table with the supplied
+// name.
+func checkTable(db *ivy.DB, tblName string) error {
+	tblNames, err := db.TableNames()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tblNames {
+		if name == tblName {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("table %s does not exist", tblName)
+}
+
+// jsonArg returns a JSON argument, read from stdin if it is "-", checking
+// that it holds an object.
+func jsonArg(arg string, stdin io.Reader) (json.RawMessage, error) {
+	data := []byte(arg)
+
+	if arg == "-" {
+		var err error
+
+		data, err = ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rec map[string]interface{}
+
+	if err := json.Unmarshal(data, &rec); err != nil || rec == nil {
+		return nil, errors.New("the record must be a JSON object")
+	}
+
+	return json.RawMessage(data), nil
+}
+
+// queryArg returns the value of an argument of a saved query: the JSON value
+// it holds, if it is a string, number, bool or null, or else the argument
+// itself as a string.
+func queryArg(arg string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(arg))
+	dec.UseNumber()
+
+	var value interface{}
+
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return arg
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return arg
+	}
+
+	return value
+}
+
+// printLines writes a list of strings, one per line.
+func printLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
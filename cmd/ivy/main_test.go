@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runIvy(t *testing.T, dir string, stdin string, args ...string) (string, int) {
+	var stdout, stderr bytes.Buffer
+
+	status := run(append([]string{"-db", dir}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	if status != 0 {
+		return stderr.String(), status
+	}
+
+	return stdout.String(), status
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-cmd")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "planes"), 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	out, status := runIvy(t, dir, "", "create", "planes", `{"name":"Spitfire","maker":"Supermarine","tags":["uk"]}`)
+	if status != 0 || out != "1\n" {
+		t.Fatalf("Unexpected create output %q (%d)", out, status)
+	}
+
+	out, status = runIvy(t, dir, `{"name":"Mustang","maker":"North American","tags":["us"]}`, "create", "planes", "-")
+	if status != 0 || out != "2\n" {
+		t.Fatalf("Unexpected create output %q (%d)", out, status)
+	}
+
+	out, _ = runIvy(t, dir, "", "tables")
+	if out != "planes\n" {
+		t.Errorf("Unexpected tables output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "get", "planes", "2")
+	if !strings.Contains(out, `"name": "Mustang"`) {
+		t.Errorf("Unexpected get output %q", out)
+	}
+
+	_, status = runIvy(t, dir, "", "update", "planes", "2", `{"name":"P-51","maker":"North American","tags":["us"]}`)
+	if status != 0 {
+		t.Error("Expected update to succeed")
+	}
+
+	out, _ = runIvy(t, dir, "", "-index", "planes=maker", "find", "planes", "maker", "North American")
+	if out != "2\n" {
+		t.Errorf("Unexpected find output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "tags", "planes", "uk")
+	if out != "1\n" {
+		t.Errorf("Unexpected tags output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "query", "planes", "name = 'P-51'")
+	if out != "2\n" {
+		t.Errorf("Unexpected query output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "-index", "planes=maker", "explain", "planes", "maker = 'Supermarine' ORDER BY name")
+	if out != "index: field maker\nrecords scanned: 1 of 2\nsort: in memory by name, all matches held\n" {
+		t.Errorf("Unexpected explain output %q", out)
+	}
+
+	_, status = runIvy(t, dir, "", "save", "by-maker", "planes", "maker = ?")
+	if status != 0 {
+		t.Error("Expected save to succeed")
+	}
+
+	out, _ = runIvy(t, dir, "", "saved")
+	if out != "by-maker\tplanes\tmaker = ?\n" {
+		t.Errorf("Unexpected saved output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "run", "by-maker", "North American")
+	if out != "2\n" {
+		t.Errorf("Unexpected run output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "export", "planes")
+	if strings.Count(out, "\n") != 2 || !strings.Contains(out, "P-51") {
+		t.Errorf("Unexpected export output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "verify", "planes")
+	if !strings.HasPrefix(out, "2 records checked") {
+		t.Errorf("Unexpected verify output %q", out)
+	}
+
+	out, _ = runIvy(t, dir, "", "reindex", "planes")
+	if out != "2 records indexed, 0 quarantined\n" {
+		t.Errorf("Unexpected reindex output %q", out)
+	}
+
+	backupPath := filepath.Join(dir, "backup.tar")
+
+	_, status = runIvy(t, dir, "", "backup", backupPath)
+	if _, err := os.Stat(backupPath); status != 0 || err != nil {
+		t.Error("Expected backup to be written:", err)
+	}
+
+	_, status = runIvy(t, dir, "", "delete", "planes", "1")
+	if status != 0 {
+		t.Error("Expected delete to succeed")
+	}
+
+	out, _ = runIvy(t, dir, "", "ids", "planes")
+	if out != "2\n" {
+		t.Errorf("Unexpected ids output %q", out)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-cmd")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "planes"), 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{[]string{}, 2, "usage"},
+		{[]string{"fly"}, 2, "usage"},
+		{[]string{"get", "planes"}, 2, "usage"},
+		{[]string{"get", "trains", "1"}, 1, "table trains does not exist"},
+		{[]string{"get", "planes", "9"}, 1, "no record 9 in table planes"},
+		{[]string{"create", "planes", "[1]"}, 1, "must be a JSON object"},
+		{[]string{"query", "planes", "name ="}, 1, "invalid query"},
+		{[]string{"run", "nothing"}, 1, "no query saved as nothing"},
+	}
+
+	for _, test := range tests {
+		out, status := runIvy(t, dir, "", test.args...)
+		if status != test.status || !strings.Contains(out, test.out) {
+			t.Errorf("%v: expected %d %q, got %d %q", test.args, test.status, test.out, status, out)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-bench")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []string{"file", "packed", "memory"} {
+		benchDir := filepath.Join(dir, storage)
+
+		out, status := runIvy(t, dir, "", "bench", "-storage", storage, "-records", "50", "-ops", "200",
+			"-mix", "read=5,write=3,query=1,scan=1", "-size", "16", benchDir)
+		if status != 0 {
+			t.Fatalf("bench %s failed: %s", storage, out)
+		}
+
+		for _, s := range []string{"storage " + storage + ", 50 records", "load: 50 records", "p99", "read", "write", "query", "scan", "total"} {
+			if !strings.Contains(out, s) {
+				t.Errorf("Expected %q in the %s output %q", s, storage, out)
+			}
+		}
+
+		if _, err := os.Stat(benchDir); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", benchDir, err)
+		}
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{[]string{"bench"}, 2, "usage: ivy bench"},
+		{[]string{"bench", "-mix", "fly=1", dir}, 2, "unknown operation fly"},
+		{[]string{"bench", "-storage", "tape", dir}, 2, "unknown storage tape"},
+		{[]string{"bench", dir}, 1, "is not empty"},
+	}
+
+	os.MkdirAll(filepath.Join(dir, "taken"), 0700)
+
+	for _, test := range tests {
+		out, status := runIvy(t, dir, "", test.args...)
+		if status != test.status || !strings.Contains(out, test.out) {
+			t.Errorf("%v: expected %d %q, got %d %q", test.args, test.status, test.out, status, out)
+		}
+	}
+}
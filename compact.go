@@ -0,0 +1,75 @@
+package ivy
+
+import "context"
+
+// Type CompactReport is a struct holding the bytes a table took up in storage
+// before and after DB.Compact.
+type CompactReport struct {
+	BytesBefore int64
+	BytesAfter  int64
+
+	// Versions is the number of old record versions dropped; see
+	// Options.MVCC.
+	Versions int
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Compact reclaims the space left behind in a packed table's file by deleted
+// records and by records that grew and moved, by copying the live records to
+// a new file that then replaces the old one. Reads and writes go on while the
+// records are copied and only wait for the files to be swapped. Tables of the
+// other storage engines have no space to reclaim. With Options.MVCC, the old
+// versions of the table's records that no open snapshot can read are dropped
+// as well. CompactJob compacts tables in the background. It takes a table
+// name. It returns a report of the bytes reclaimed and any error encountered.
+func (db *DB) Compact(tblName string) (*CompactReport, error) {
+	return db.CompactCtx(context.Background(), tblName)
+}
+
+// CompactCtx is Compact with a context, which is handed to the authorizer.
+func (db *DB) CompactCtx(ctx context.Context, tblName string) (_ *CompactReport, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "compact")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	before, err := db.engine.stat(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.engine.compact(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := db.engine.stat(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CompactReport{BytesBefore: before.bytes, BytesAfter: after.bytes}
+
+	if db.versions != nil {
+		report.Versions = db.versions.collect(tblName)
+	}
+
+	if report.BytesAfter < report.BytesBefore {
+		db.logger.Info("ivy: table compacted", "table", tblName, "before", report.BytesBefore, "after", report.BytesAfter)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,235 @@
+package ivy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// configName is the name of the file of the .ivy directory holding the
+// settings of the database.
+const configName = "config.json"
+
+// configVersion is the version of the on-disk format of the database. A
+// database whose config file has a later version was written by a later
+// release of ivy, and is not opened.
+const configVersion = 1
+
+// Type DBConfig is a struct holding the settings every program opening a
+// database has to agree on, kept in the database's .ivy/config.json. The
+// first OpenDB of a database writes the file, and later ones fail with
+// ErrConfigMismatch if their options disagree with it, rather than reading
+// or writing records the way the database is not laid out. Options.
+// Reconfigure replaces the settings instead, such as after the records were
+// migrated to them. Codec and Layout are the Go types of Options.Codec and
+// Options.Layout, and LayoutSettings the layout's exported fields, such as
+// the FileNaming of its tables. Indexes are the fields indexed by table:
+// tables not passed to OpenDB are indexed on the fields of the file, while
+// the fields of tables passed to it, or to RegisterTable, replace those of
+// the file.
+type DBConfig struct {
+	Version        int                 `json:"version"`
+	Storage        string              `json:"storage"`
+	Codec          string              `json:"codec"`
+	Layout         string              `json:"layout"`
+	LayoutSettings json.RawMessage     `json:"layout_settings,omitempty"`
+	Indexes        map[string][]string `json:"indexes,omitempty"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Config returns the settings of the database as kept in its config file.
+func (db *DB) Config() DBConfig {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
+
+	return db.config()
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkConfig checks the options the database was opened with against its
+// config file, and writes the file if there is none or the options say to
+// replace it. Tables indexed in the file and found in storage, but not
+// passed to OpenDB, are indexed on the fields of the file.
+func (db *DB) checkConfig(opts Options, tblNames []string) error {
+	if db.path == "" || opts.Storage == MemoryStorage {
+		return nil
+	}
+
+	data, err := db.fs.ReadFile(db.metaPath(configName))
+	if os.IsNotExist(err) || (err == nil && opts.Reconfigure) {
+		return db.saveConfig()
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored DBConfig
+
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupt, configName, err)
+	}
+
+	if stored.Version > configVersion {
+		return fmt.Errorf("%w: the database has format version %d, this release reads up to %d", ErrConfigMismatch, stored.Version, configVersion)
+	}
+
+	cfg := db.config()
+
+	switch {
+	case stored.Storage != cfg.Storage:
+		return configErr("storage", stored.Storage, cfg.Storage)
+	case stored.Codec != cfg.Codec:
+		return configErr("codec", stored.Codec, cfg.Codec)
+	case stored.Layout != cfg.Layout:
+		return configErr("layout", stored.Layout, cfg.Layout)
+	case !jsonEqual(stored.LayoutSettings, cfg.LayoutSettings):
+		return configErr("layout settings", string(stored.LayoutSettings), string(cfg.LayoutSettings))
+	}
+
+	changed := false
+
+	for tblName, fldNames := range cfg.Indexes {
+		storedNames, ok := stored.Indexes[tblName]
+		if ok && reflect.DeepEqual(sortedFields(storedNames), sortedFields(fldNames)) {
+			continue
+		}
+
+		if ok {
+			db.logger.Info("ivy: index fields changed", "table", tblName, "from", storedNames, "to", fldNames)
+		}
+
+		changed = true
+	}
+
+	for _, tblName := range tblNames {
+		if _, ok := cfg.Indexes[tblName]; ok {
+			continue
+		}
+
+		if fldNames, ok := stored.Indexes[tblName]; ok {
+			db.setIndexFields(tblName, fldNames)
+		}
+	}
+
+	if changed || stored.Version < configVersion {
+		return db.saveConfig()
+	}
+
+	return nil
+}
+
+// saveConfig writes the settings of the database to its config file, unless
+// the database has no directory or is read-only.
+func (db *DB) saveConfig() error {
+	if db.path == "" || db.opts.Storage == MemoryStorage || db.readOnly {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(db.config(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = db.fs.MkdirAll(db.metaPath(), db.modes.dir)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.fs, db.metaPath(configName), data, db.modes.file)
+}
+
+// saveTblConfig writes the config file after the index fields of a table
+// changed. A failure is only logged, as the next OpenDB passed the table
+// writes the file again. The caller must hold tblMu.
+func (db *DB) saveTblConfig(tblName string) {
+	if err := db.saveConfig(); err != nil {
+		db.logger.Warn("ivy: saving the config failed", "table", tblName, "err", err)
+	}
+}
+
+// config returns the settings of the database. The caller must hold tblMu
+// unless the database is being opened.
+func (db *DB) config() DBConfig {
+	cfg := DBConfig{
+		Version: configVersion,
+		Storage: storageName(db.opts.Storage),
+		Codec:   fmt.Sprintf("%T", db.codec),
+		Indexes: make(map[string][]string, len(db.fieldsToIndex)),
+	}
+
+	var layout Layout = &DefaultLayout{}
+	if db.opts.Layout != nil {
+		layout = db.opts.Layout
+	}
+
+	cfg.Layout = fmt.Sprintf("%T", layout)
+
+	// Layouts that cannot be marshalled are only compared by type.
+	if settings, err := json.Marshal(layout); err == nil {
+		cfg.LayoutSettings = settings
+	}
+
+	for tblName, fldNames := range db.fieldsToIndex {
+		if fldNames == nil {
+			fldNames = []string{}
+		}
+		cfg.Indexes[tblName] = fldNames
+	}
+
+	return cfg
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// configErr returns the error of a setting the options disagree with the
+// config file about.
+func configErr(setting string, stored string, opened string) error {
+	return fmt.Errorf("%w: the database's %s is %s, not %s", ErrConfigMismatch, setting, stored, opened)
+}
+
+// storageName returns the name of a storage engine in the config file.
+func storageName(storage Storage) string {
+	switch storage {
+	case FileStorage:
+		return "file"
+	case PackedStorage:
+		return "packed"
+	case MemoryStorage:
+		return "memory"
+	}
+
+	return fmt.Sprintf("storage(%d)", int(storage))
+}
+
+// sortedFields returns a sorted copy of field names.
+func sortedFields(fldNames []string) []string {
+	sorted := append([]string{}, fldNames...)
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// jsonEqual reports whether two JSON documents hold the same values.
+func jsonEqual(a []byte, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,189 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// CopyTo writes a fresh copy of every table of the database, with the same
+// records, ids and index fields, to a new database directory, while the
+// database stays in use. The copy is written with the supplied options, such
+// as another Storage, Codec, EncryptionKey or Checksums, which makes it the
+// way to move a database to other storage options, or to shrink a directory
+// bloated by deleted and rewritten records. Records are read from a Snapshot,
+// so the copy holds the database as it was when CopyTo was called. Encrypted
+// fields are sealed again with the keys of the options. The contents of the
+// .ivy directory, such as the write-ahead log, the audit log and saved
+// queries, are not copied, and neither Jobs nor Webhooks of the options run
+// while the copy is written. A failed copy leaves a partial directory
+// behind. It takes the path of the new directory, which must not exist or be
+// empty, and the options of the copy. It returns any error encountered.
+func (db *DB) CopyTo(newPath string, opts Options) error {
+	return db.CopyToCtx(context.Background(), newPath, opts)
+}
+
+// CopyToCtx is CopyTo with a context, which is handed to the authorizer.
+func (db *DB) CopyToCtx(ctx context.Context, newPath string, opts Options) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	ops, err := db.beginOps(ctx, db.tableNames(), "export")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	if newPath == "" || opts.Storage == MemoryStorage {
+		return errors.New("ivy: a copy needs a database directory")
+	}
+
+	if db.path != "" {
+		src, _ := filepath.Abs(db.path)
+		dst, _ := filepath.Abs(newPath)
+		if src == dst {
+			return fmt.Errorf("%w: cannot copy the database onto itself", ErrConflict)
+		}
+	}
+
+	// The copy would lose the fields the read policies mask.
+	for tblName := range db.readPolicies {
+		if err := db.checkUnrestricted(tblName); err != nil {
+			return err
+		}
+	}
+
+	opts.Jobs, opts.Webhooks, opts.AccessLog = nil, nil, nil
+	opts.ReadOnly, opts.Restricted = false, false
+
+	err = prepareCopyDir(newPath, opts)
+	if err != nil {
+		return err
+	}
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	dst, err := OpenDBWithOptions(newPath, nil, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, tblName := range snap.TableNames() {
+		err = db.copyTbl(ctx, snap, dst, tblName)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	db.logger.Info("ivy: database copied", "path", newPath)
+
+	return nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// copyTbl copies a table of a snapshot of the database to the database of a
+// copy, decoding every record with the database's codec and encoding it with
+// the copy's if they differ.
+func (db *DB) copyTbl(ctx context.Context, snap *Snapshot, dst *DB, tblName string) error {
+	fldNames, _ := db.indexFields(tblName)
+
+	dstLock, err := dst.addTable(tblName, fldNames)
+	if err != nil {
+		return err
+	}
+	defer dstLock.Unlock()
+
+	rwLock, err := snap.lock(tblName)
+	if err != nil {
+		return err
+	}
+	defer rwLock.RUnlock()
+
+	fileIds, err := snap.tblIds(tblName)
+	if err != nil {
+		return err
+	}
+
+	_, srcJSON := db.codec.(JSONCodec)
+	_, dstJSON := dst.codec.(JSONCodec)
+
+	for _, fileId := range fileIds {
+		data, err := snap.readRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return recErr(tblName, fileId, err)
+		}
+
+		data, err = db.openFields(tblName, fileId, data)
+		if err != nil {
+			return err
+		}
+
+		if !srcJSON || !dstJSON {
+			var rec interface{}
+
+			err = db.codec.Unmarshal(data, &rec)
+			if err != nil {
+				return corruptErr(tblName, fileId, err.Error())
+			}
+
+			data, err = dst.codec.Marshal(rec)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = dst.writeRec(ctx, tblName, fileId, data, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// prepareCopyDir creates the directory of a copy of a database, which must
+// not exist or be empty, on the file system of the copy's options.
+func prepareCopyDir(newPath string, opts Options) error {
+	fs := opts.FileSystem
+	if fs == nil {
+		fs = newFileModes(opts).fs()
+	}
+
+	files, err := fs.ReadDir(newPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("%w: %s is not empty", ErrConflict, newPath)
+	}
+
+	return fs.MkdirAll(newPath, newFileModes(opts).dir)
+}
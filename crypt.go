@@ -0,0 +1,171 @@
+package ivy
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// sealedMagic starts every record, index checkpoint and write-ahead log entry
+// encrypted with Options.EncryptionKey. It cannot start the data of a record
+// written before the database was encrypted, which is read as it is.
+const sealedMagic = "\x00IVYENC"
+
+// keyCheckName is the name of the file, in the metadata directory, holding a
+// known text sealed with the key of an encrypted database, so that opening it
+// with the wrong key fails at once rather than every record failing to
+// decrypt.
+const keyCheckName = "key-check"
+
+// keyCheckText is the text sealed in the key check file.
+const keyCheckText = "ivy"
+
+// sealer encrypts and authenticates data with AES-GCM. Each sealed piece of
+// data is bound to a context, such as the table and id of a record, so that
+// it cannot be passed off as another. A nil sealer leaves data as it is.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// cryptEngine wraps another engine, encrypting records before they are
+// written and decrypting them after they are read.
+type cryptEngine struct {
+	engine
+	s *sealer
+}
+
+// newSealer returns a sealer for a 16, 24 or 32 byte AES key.
+func newSealer(key []byte) (*sealer, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: invalid encryption key: %v", err)
+	}
+
+	return &sealer{aead: aead}, nil
+}
+
+// seal returns data encrypted and bound to context.
+func (s *sealer) seal(data []byte, context string) ([]byte, error) {
+	if s == nil {
+		return data, nil
+	}
+
+	nonceSize := s.aead.NonceSize()
+
+	sealed := make([]byte, len(sealedMagic)+nonceSize, len(sealedMagic)+nonceSize+len(data)+s.aead.Overhead())
+	copy(sealed, sealedMagic)
+
+	nonce := sealed[len(sealedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(sealed, nonce, data, []byte(context)), nil
+}
+
+// open returns the data sealed with context. Data that was not sealed, having
+// been written before the database was encrypted, is returned as it is. It
+// returns an error if the data cannot be decrypted, because it was sealed
+// with another key or context or was tampered with.
+func (s *sealer) open(data []byte, context string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(sealedMagic)) {
+		return data, nil
+	}
+
+	if s == nil {
+		return nil, errors.New("encrypted, and no encryption key was supplied")
+	}
+
+	data = data[len(sealedMagic):]
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("cannot decrypt")
+	}
+
+	plain, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(context))
+	if err != nil {
+		return nil, errors.New("cannot decrypt")
+	}
+
+	return plain, nil
+}
+
+func (e *cryptEngine) read(tblName string, fileId string) ([]byte, error) {
+	data, err := e.engine.read(tblName, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = e.s.open(data, recContext(tblName, fileId))
+	if err != nil {
+		return nil, corruptErr(tblName, fileId, err.Error())
+	}
+
+	return data, nil
+}
+
+func (e *cryptEngine) write(tblName string, fileId string, data []byte) error {
+	data, err := e.s.seal(data, recContext(tblName, fileId))
+	if err != nil {
+		return err
+	}
+
+	return e.engine.write(tblName, fileId, data)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkKey makes sure that an encrypted database is opened with its key, and
+// that a database with a key has a key check file. It returns an error
+// wrapping ErrBadKey if the key is wrong or missing.
+func (db *DB) checkKey() error {
+	path := db.metaPath(keyCheckName)
+
+	data, err := db.fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		if db.sealer == nil || db.readOnly {
+			return nil
+		}
+
+		data, err = db.sealer.seal([]byte(keyCheckText), keyCheckName)
+		if err != nil {
+			return err
+		}
+
+		err = db.fs.MkdirAll(db.metaPath(), db.modes.dir)
+		if err != nil {
+			return err
+		}
+
+		return writeFileAtomic(db.fs, path, data, db.modes.file)
+	}
+	if err != nil {
+		return err
+	}
+
+	if db.sealer == nil {
+		return fmt.Errorf("%w: the database is encrypted and no key was supplied", ErrBadKey)
+	}
+
+	text, err := db.sealer.open(data, keyCheckName)
+	if err != nil || string(text) != keyCheckText {
+		return ErrBadKey
+	}
+
+	return nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// recContext returns the context a record is sealed with.
+func recContext(tblName string, fileId string) string {
+	return "records/" + tblName + "/" + fileId
+}
@@ -0,0 +1,512 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type CSVOptions is a struct holding the options of DB.ExportCSVWithOptions.
+type CSVOptions struct {
+	// Fields are the fields exported, one column each, in order. Fields of
+	// nested objects are named by their path, such as "address.city". If
+	// Fields is empty, every field found in the exported records is
+	// exported, in alphabetical order.
+	Fields []string
+
+	// Ids restricts the export to the records with these ids, such as the
+	// result of FindAllIdsForField, in the given order. If it is nil, every
+	// record of the table is exported, in id order.
+	Ids []string
+
+	// NoHeader leaves out the header row naming the columns.
+	NoHeader bool
+
+	// IdColumn is the header of the first column, which holds the record
+	// ids. It defaults to "id".
+	IdColumn string
+}
+
+// Type CSVType is the type a CSV column is converted to on import.
+type CSVType int
+
+const (
+	// CSVString keeps the text of the cell. It is the default.
+	CSVString CSVType = iota
+
+	// CSVInt converts the cell to an integer.
+	CSVInt
+
+	// CSVFloat converts the cell to a floating point number.
+	CSVFloat
+
+	// CSVBool converts the cell to a boolean, accepting the values understood
+	// by strconv.ParseBool.
+	CSVBool
+
+	// CSVTags splits the cell into a list of strings separated by commas or
+	// semicolons, as written by ExportCSV.
+	CSVTags
+)
+
+// Type CSVColumn is a struct describing the record field a CSV column is
+// imported into. Field may be a path into nested objects, such as
+// "address.city".
+type CSVColumn struct {
+	Field string
+	Type  CSVType
+}
+
+// Type CSVMapping maps the columns of a CSV file, by header name, to record
+// fields.
+type CSVMapping map[string]CSVColumn
+
+// Type CSVRowError is a struct describing a row of a CSV file that could not
+// be imported. Row is the line number of the row, counting the header as line
+// 1.
+type CSVRowError struct {
+	Row int
+	Err error
+}
+
+func (e *CSVRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *CSVRowError) Unwrap() error {
+	return e.Err
+}
+
+// Type CSVImportReport is a struct describing the result of ImportCSV. Ids
+// holds the ids of the created records, and Errors the rows that were not
+// imported.
+type CSVImportReport struct {
+	Ids    []string
+	Errors []*CSVRowError
+}
+
+// csvRow is a record flattened for CSV export.
+type csvRow struct {
+	id     string
+	fields map[string]string
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// ExportCSV writes the records of a table to w as CSV, for spreadsheets, with
+// a header row and a column holding the record ids, followed by a column for
+// every field. Nested objects are flattened into one column per field, and
+// arrays of plain values are joined with semicolons. It takes a table name,
+// the writer to write to, and the fields to export, which default to all of
+// them. It returns any error encountered.
+func (db *DB) ExportCSV(tblName string, w io.Writer, fields ...string) error {
+	return db.ExportCSVWithOptions(tblName, w, CSVOptions{Fields: fields})
+}
+
+// ExportCSVCtx is ExportCSV with a context, which is handed to the
+// authorizer.
+func (db *DB) ExportCSVCtx(ctx context.Context, tblName string, w io.Writer, fields ...string) error {
+	return db.ExportCSVWithOptionsCtx(ctx, tblName, w, CSVOptions{Fields: fields})
+}
+
+// ExportCSVWithOptions writes records of a table to w as CSV, as ExportCSV
+// does, using the supplied options. It takes a table name, the writer to
+// write to, and the CSV options. It returns any error encountered.
+func (db *DB) ExportCSVWithOptions(tblName string, w io.Writer, opts CSVOptions) error {
+	return db.ExportCSVWithOptionsCtx(context.Background(), tblName, w, opts)
+}
+
+// ExportCSVWithOptionsCtx is ExportCSVWithOptions with a context, which is
+// handed to the authorizer.
+func (db *DB) ExportCSVWithOptionsCtx(ctx context.Context, tblName string, w io.Writer, opts CSVOptions) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "export")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	fileIds := opts.Ids
+	if fileIds == nil {
+		ids, err := db.engine.ids(tblName)
+		if err != nil {
+			return err
+		}
+
+		fileIds = sortedIdList(ids)
+	}
+
+	rows := make([]csvRow, 0, len(fileIds))
+
+	for _, fileId := range fileIds {
+		data, err := db.readRec(tblName, fileId)
+		if os.IsNotExist(err) && opts.Ids != nil {
+			// The record was deleted since it was found.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err = db.redact(tblName, fileId, data)
+		if err != nil {
+			return err
+		}
+
+		row, err := flattenRec(data)
+		if err != nil {
+			return corruptErr(tblName, fileId, err.Error())
+		}
+
+		rows = append(rows, csvRow{id: fileId, fields: row})
+	}
+
+	fields := opts.Fields
+	if len(fields) == 0 {
+		fields = rowFields(rows)
+	}
+
+	idColumn := opts.IdColumn
+	if idColumn == "" {
+		idColumn = "id"
+	}
+
+	cw := csv.NewWriter(w)
+
+	if !opts.NoHeader {
+		err := cw.Write(append([]string{idColumn}, fields...))
+		if err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, len(fields)+1)
+
+	for _, row := range rows {
+		record[0] = row.id
+		for i, field := range fields {
+			record[i+1] = row.fields[field]
+		}
+
+		err := cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// ImportCSV creates a record for every row of a CSV file, whose first row
+// must name the columns. The mapping decides which record field, and type,
+// each column is imported into; columns missing from it are left out. If the
+// mapping is nil, every column except "id" is imported as a string field of
+// the same name. All records are created in one go, holding the table's lock.
+// A row that cannot be converted or stored is reported and skipped, without
+// aborting the rest of the import. It takes a table name, the reader to read
+// the CSV from, and the column mapping. It returns a report of the import and
+// any error that stopped it.
+func (db *DB) ImportCSV(tblName string, r io.Reader, mapping CSVMapping) (*CSVImportReport, error) {
+	return db.ImportCSVCtx(context.Background(), tblName, r, mapping)
+}
+
+// ImportCSVCtx is ImportCSV with a context, which is handed to the
+// authorizer.
+func (db *DB) ImportCSVCtx(ctx context.Context, tblName string, r io.Reader, mapping CSVMapping) (_ *CSVImportReport, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "import")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]*CSVColumn, len(header))
+
+	for i, name := range header {
+		if mapping == nil {
+			if name != "id" {
+				columns[i] = &CSVColumn{Field: name}
+			}
+			continue
+		}
+
+		if column, ok := mapping[name]; ok {
+			columns[i] = &column
+		}
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	nextId, err := db.nextAvailableFileId(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	next := idNum(nextId)
+	report := &CSVImportReport{}
+
+	for {
+		cells, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Errors = append(report.Errors, &CSVRowError{Row: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		row, _ := cr.FieldPos(0)
+
+		data, err := csvRecord(columns, cells)
+		if err != nil {
+			report.Errors = append(report.Errors, &CSVRowError{Row: row, Err: err})
+			continue
+		}
+
+		fileId := strconv.Itoa(next)
+
+		err = db.writeRec(ctx, tblName, fileId, data, false)
+		if errors.Is(err, ErrRecordTooLarge) || errors.Is(err, ErrQuotaExceeded) {
+			report.Errors = append(report.Errors, &CSVRowError{Row: row, Err: err})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Ids = append(report.Ids, fileId)
+		next++
+	}
+
+	return report, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// csvRecord returns the marshalled record holding the cells of a CSV row.
+func csvRecord(columns []*CSVColumn, cells []string) ([]byte, error) {
+	if len(cells) != len(columns) {
+		return nil, fmt.Errorf("row has %d columns, the header %d", len(cells), len(columns))
+	}
+
+	rec := make(map[string]interface{})
+
+	for i, column := range columns {
+		if column == nil {
+			continue
+		}
+
+		value, err := csvCell(cells[i], column.Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %d (%s): %v", i+1, column.Field, err)
+		}
+		if value == nil {
+			continue
+		}
+
+		err = setPath(rec, column.Field, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(rec)
+}
+
+// csvCell converts the text of a CSV cell to the supplied type. Empty cells
+// of types other than strings and tags convert to nil.
+func csvCell(cell string, typ CSVType) (interface{}, error) {
+	trimmed := strings.TrimSpace(cell)
+
+	switch typ {
+	case CSVString:
+		return cell, nil
+	case CSVTags:
+		tags := strings.FieldsFunc(cell, func(r rune) bool { return r == ',' || r == ';' })
+		for i := range tags {
+			tags[i] = strings.TrimSpace(tags[i])
+		}
+		return tags, nil
+	}
+
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	switch typ {
+	case CSVInt:
+		return strconv.ParseInt(trimmed, 10, 64)
+	case CSVFloat:
+		return strconv.ParseFloat(trimmed, 64)
+	case CSVBool:
+		return strconv.ParseBool(trimmed)
+	}
+
+	return nil, fmt.Errorf("unknown column type %d", typ)
+}
+
+// setPath sets a field of a record, creating the nested objects named by the
+// dots in its path.
+func setPath(rec map[string]interface{}, path string, value interface{}) error {
+	names := strings.Split(path, ".")
+
+	for _, name := range names[:len(names)-1] {
+		next, ok := rec[name]
+		if !ok {
+			next = make(map[string]interface{})
+			rec[name] = next
+		}
+
+		obj, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is not an object", name)
+		}
+
+		rec = obj
+	}
+
+	rec[names[len(names)-1]] = value
+
+	return nil
+}
+
+// flattenRec turns a marshalled record into a map from the path of every
+// field to its value as text.
+func flattenRec(data []byte) (map[string]string, error) {
+	var fields map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]string)
+	flattenFields(row, "", fields)
+
+	return row, nil
+}
+
+// flattenFields adds the fields of an object to a flattened record, prefixing
+// their names with the supplied path.
+func flattenFields(row map[string]string, prefix string, fields map[string]interface{}) {
+	for name, value := range fields {
+		if obj, ok := value.(map[string]interface{}); ok {
+			flattenFields(row, prefix+name+".", obj)
+			continue
+		}
+
+		row[prefix+name] = csvValue(value)
+	}
+}
+
+// csvValue returns a JSON value as text for a CSV cell.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			switch elem.(type) {
+			case map[string]interface{}, []interface{}:
+				data, _ := json.Marshal(v)
+				return string(data)
+			}
+			elems[i] = csvValue(elem)
+		}
+		return strings.Join(elems, ";")
+	}
+
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// rowFields returns the names of all fields of a set of flattened records,
+// in alphabetical order.
+func rowFields(rows []csvRow) []string {
+	seen := make(map[string]bool)
+	var fields []string
+
+	for _, row := range rows {
+		for field := range row.fields {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+// sortedIdList returns a copy of a list of record ids, in numeric order.
+func sortedIdList(fileIds []string) []string {
+	sorted := append([]string(nil), fileIds...)
+	sort.Slice(sorted, func(i, j int) bool { return idNum(sorted[i]) < idNum(sorted[j]) })
+
+	return sorted
+}
@@ -3,14 +3,19 @@
 package ivy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"log/slog"
+	"math/big"
 	"os"
-	"path"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Type Record is an interface that your table model needs to implement.
@@ -26,50 +31,541 @@ type Record interface {
 
 // Type DB is a struct representing the database connection.
 type DB struct {
-	path          string
-	rwLocks       map[string]*sync.RWMutex
+	path   string
+	fs     FileSystem
+	engine engine
+
+	// tblMu guards the maps keyed by table name, which change when tables are
+	// added. The indexes themselves are guarded by the table locks.
+	tblMu         sync.RWMutex
+	rwLocks       map[string]*tblMutex
 	fieldsToIndex map[string][]string
 	tagIndexes    map[string]map[string][]string
 	fldIndexes    map[string]map[string]map[string][]string
+
+	checksums       bool
+	stableOutput    *StableOutputOptions
+	sealer          *sealer
+	fieldSealer     *sealer
+	encryptedFields map[string][]string
+	recordKeys      map[string]bool
+	recKeysMu       sync.Mutex
+	recKeys         map[string]*sealer
+	codec           Codec
+	readOnly        bool
+	useNumber       bool
+	persistIndexes  bool
+	genMu           sync.Mutex
+	generations     map[string]uint64
+	checkpointed    map[string]uint64
+	maxRecordSize   int
+	quotas          map[string]Quota
+	exportPolicies  map[string]ExportPolicy
+	readPolicies    map[string]ReadPolicy
+	restricted      bool
+	authorizer      Authorizer
+	actorStamps     bool
+	analyzers       map[string]Analyzer
+	eventTables     map[string]bool
+	usage           map[string]*tblUsage
+	lockPath        string
+	wal             *wal
+	recovery        *RecoveryReport
+	follower        *Follower
+	syncBases       map[string]syncBase
+	webhooks        []*webhook
+	accessLog       *accessLog
+	audit           *auditLog
+	modes           fileModes
+	opts            Options
+	namespace       string
+	nsMu            sync.Mutex
+	namespaces      map[string]*DB
+	auditChain      bool
+	lockWaitWarning time.Duration
+	queriesMu       sync.Mutex
+	subsMu          sync.Mutex
+	subs            map[*Subscription]bool
+	watchMu         sync.Mutex
+	watchers        map[*Watcher]bool
+	jobs            map[string]*scheduledJob
+	jobsCtx         context.Context
+	stopJobs        context.CancelFunc
+	jobsWg          sync.WaitGroup
+	opsMu           sync.Mutex
+	ops             map[*operation]bool
+	logger          *slog.Logger
+	metrics         metrics
+	pins            pinSet
+	files           *fileWatch
+	gitOpts         *GitOptions
+	gitMu           sync.Mutex
+	versions        *versionStore
+	snapsMu         sync.Mutex
+	snaps           map[*Snapshot]bool
+	altersMu        sync.Mutex
+	alters          map[string]*Alteration
+	outbox          *outbox
+
+	stateMu sync.Mutex
+	idle    *sync.Cond
+	active  int
+	closed  bool
+}
+
+// Type Options is a struct holding optional database settings that can be
+// passed to OpenDBWithOptions.
+type Options struct {
+	// FileSystem is used for all file access. If it is nil, the local disk is
+	// used.
+	FileSystem FileSystem
+
+	// Storage selects the storage engine. It defaults to FileStorage.
+	Storage Storage
+
+	// Layout decides where tables and records live inside the database
+	// directory. It defaults to a DefaultLayout with default file naming.
+	Layout Layout
+
+	// MmapReads memory-maps packed table files so that repeated Finds are
+	// served from the OS page cache without read syscalls. It is meant for
+	// read-mostly databases, requires PackedStorage, and is ignored on
+	// platforms without mmap support.
+	MmapReads bool
+
+	// WriteBehind, if set, turns on write-behind mode: writes update memory
+	// immediately and are flushed to storage by a background goroutine.
+	WriteBehind *WriteBehindOptions
+
+	// Checksums stores a checksum with every record that is verified on every
+	// read. Records that fail verification return ErrCorrupt.
+	Checksums bool
+
+	// EncryptionKey, a 16, 24 or 32 byte AES key, encrypts the records, the
+	// index checkpoints and the write-ahead log with AES-GCM, so that the
+	// data directory holds no readable data. Opening an encrypted database
+	// with the wrong key, or without one, fails with ErrBadKey. Records
+	// written before a key was supplied stay readable, and are encrypted
+	// when next written, so a database restored from a backup, which holds
+	// the records decrypted, is only encrypted as its records are updated;
+	// encrypt the backups themselves with EncryptBackup. Memory storage
+	// cannot be encrypted.
+	EncryptionKey []byte
+
+	// EncryptionKeyProvider fetches EncryptionKey when the database is
+	// opened, such as from the environment or a key management service.
+	// Only one of the two may be set.
+	EncryptionKeyProvider KeyProvider
+
+	// EncryptedFields lists, by table name, top-level fields whose values,
+	// such as social security numbers or API keys, are encrypted with
+	// FieldKey. Their values are stored as strings starting with "ivyenc:"
+	// on disk, in backups, exports, change feeds and webhooks, while the
+	// rest of the record stays readable. Find and Select decrypt them. A
+	// database opened without the fields listed, or by a program without the
+	// key, reads the encrypted strings. Encrypted fields cannot be searched
+	// or indexed.
+	EncryptedFields map[string][]string
+
+	// FieldKey is the 16, 24 or 32 byte AES key of EncryptedFields.
+	FieldKey []byte
+
+	// FieldKeyProvider fetches FieldKey when the database is opened. Only one
+	// of the two may be set.
+	FieldKeyProvider KeyProvider
+
+	// KeyTimeout bounds how long fetching the keys of EncryptionKeyProvider
+	// and FieldKeyProvider may take, so that a key management service that
+	// does not answer makes opening the database fail rather than hang. It
+	// defaults to 30 seconds.
+	KeyTimeout time.Duration
+
+	// RecordKeys lists tables whose encrypted fields are sealed with a data
+	// key of their own for every record, rather than with FieldKey itself,
+	// so that DB.Shred can erase a record's fields, and every copy of them,
+	// by destroying its key. The data keys are sealed with FieldKey and kept
+	// in the .ivy/keys directory, which backups leave out, so it has to be
+	// backed up separately for the encrypted fields of restored records to
+	// be readable. Deleting a record destroys its data key too, so the
+	// encrypted fields of a deleted record restored from a backup read as
+	// null.
+	RecordKeys []string
+
+	// StableOutput, if set, writes records in a canonical form, with sorted
+	// keys, numbers as marshalled and a trailing newline, so that writing a
+	// record whose field values did not change leaves its file byte for byte
+	// the same, and diffs of a data directory kept in version control only
+	// show real changes. Encrypted records and fields differ on every write
+	// regardless.
+	StableOutput *StableOutputOptions
+
+	// Codec marshals records to the data of the database and back. It
+	// defaults to JSONCodec.
+	Codec Codec
+
+	// ReadOnly rejects Create, Update and Delete with ErrReadOnly.
+	ReadOnly bool
+
+	// UseNumber decodes the numbers of records as json.Number rather than
+	// float64 when searching, indexing and querying them, so that integers
+	// too large for a float64, such as ids of other systems, keep their
+	// precision and compare exactly.
+	UseNumber bool
+
+	// MaxRecordSize, if positive, is the largest marshalled record, in bytes,
+	// that Create and Update accept. Larger records are rejected with
+	// ErrRecordTooLarge before anything is written.
+	MaxRecordSize int
+
+	// NoLock skips taking the lock file that keeps other processes from
+	// opening the database at the same time. Without the lock, leftovers of
+	// crashed writes are not cleaned up on open either.
+	NoLock bool
+
+	// FileMode is the mode of the files the database creates, such as 0640
+	// for a data directory other members of a group may read. It defaults to
+	// 0600. The process's umask still clears bits of it unless ExactModes is
+	// set.
+	FileMode os.FileMode
+
+	// DirMode is the mode of the directories the database creates. It
+	// defaults to 0700.
+	DirMode os.FileMode
+
+	// ExactModes gives the files and directories the database creates
+	// FileMode and DirMode exactly, whatever the process's umask. Together
+	// with os.ModeSetgid in DirMode, the files then keep the group of the
+	// data directory, whichever user the process runs as. It only applies
+	// to the local file system.
+	ExactModes bool
+
+	// MVCC keeps the versions of the records replaced by writes for as long
+	// as the snapshots that may read them are open, so that DB.Snapshot
+	// returns at once, without locking every table, and snapshots never see
+	// part of a transaction; see DB.Transact. The old versions are held in
+	// memory until Compact drops those no snapshot can read anymore.
+	MVCC bool
+
+	// WatchFiles, if positive, polls the record files of every table at
+	// this interval for files created, edited or removed by other programs,
+	// such as records edited by hand while the program runs, and refreshes
+	// the indexes, pinned records and quota usage of their records. Edits are
+	// not reported to webhooks, watchers or the audit log, and snapshots taken
+	// before an edit see it. It requires FileStorage without WriteBehind.
+	WatchFiles time.Duration
+
+	// Git, if set, commits every change to a record to the git repository
+	// the database directory is in, once the change is made, or once its
+	// transaction commits, with a message naming the table, the id, the
+	// operation and the actor, so that git log and git blame tell the
+	// history of the records. The table directories of the changes are
+	// staged and committed, along with any other change made to them since
+	// the last commit. It requires FileStorage without WriteBehind, and a
+	// git executable.
+	Git *GitOptions
+
+	// Outbox, if set, keeps the events staged by Tx.Emit in a table, where
+	// they are stored, or rolled back, with the writes of their transaction,
+	// and publishes them once it commits, in order. An event is removed from
+	// the table once published; one that was published when the program
+	// stopped before removing it is published again, with the same id, so
+	// that consumers that drop duplicates get every event exactly once.
+	// Without a publisher, the events wait in the table, where a Watcher of
+	// the table sees them as they are committed.
+	Outbox *OutboxOptions
+
+	// Reconfigure replaces the settings kept in the database's config file
+	// with those of these options, instead of failing with
+	// ErrConfigMismatch if they disagree; see DBConfig.
+	Reconfigure bool
+
+	// PinnedTables lists the tables whose records are kept in memory; see
+	// DB.PinTable.
+	PinnedTables []string
+
+	// WAL, if set, turns on the write-ahead log, which lets OpenDB recover
+	// from a crash. See Recovery.
+	WAL *WALOptions
+
+	// Quotas limits the size of tables, keyed by table name.
+	Quotas map[string]Quota
+
+	// ExportPolicies redacts fields of the records of exports and backups,
+	// keyed by table name.
+	ExportPolicies map[string]ExportPolicy
+
+	// ReadPolicies masks fields of the records read by a database opened
+	// with Restricted, keyed by table name.
+	ReadPolicies map[string]ReadPolicy
+
+	// Restricted applies ReadPolicies, so that code that must not see some
+	// fields, such as a reporting job, can open the same data directory as
+	// code that may. A restricted database cannot update the records of
+	// tables with a read policy, and returns ErrReadOnly instead.
+	Restricted bool
+
+	// ActorStamps sets the "created_by" and "updated_by" fields of the
+	// records written with a context carrying an actor, set by WithActor, to
+	// the actor. "created_by" is only set when a record is created, and is
+	// kept by later writes.
+	ActorStamps bool
+
+	// AuditChain keeps an audit log of every change to a record in the
+	// database's .ivy directory, each entry holding the hash of the record
+	// and of the entry before it, so that VerifyAuditChain detects records or
+	// entries edited afterwards. The log is never rotated. It requires the
+	// local file system.
+	AuditChain bool
+
+	// Authorizer is asked whether every operation on records may go ahead,
+	// so that an application serving several users can enforce which tenant
+	// may read or write which table in one place. See Authorizer.
+	Authorizer Authorizer
+
+	// Analyzers configures how the text of text searches and indexes is split
+	// into terms, keyed by table name. Tables without one use the zero
+	// Analyzer.
+	Analyzers map[string]Analyzer
+
+	// EventTables names the tables holding immutable events. Records of an
+	// event table can only be created, with ids increasing in the order they
+	// are created; Update and Delete reject them with ErrImmutable.
+	EventTables []string
+
+	// PersistentIndexes saves index checkpoints in the database's .ivy
+	// directory on Checkpoint and Close, so that OpenDB does not have to read
+	// every record to rebuild them. With the write-ahead log, the changes
+	// logged after a checkpoint are replayed into it.
+	PersistentIndexes bool
+
+	// Webhooks lists the HTTP endpoints that are sent the changes of records.
+	Webhooks []Webhook
+
+	// Jobs lists the maintenance tasks the database runs periodically, such
+	// as CheckpointJob, VerifyJob and BackupJob, until it is closed.
+	Jobs []Job
+
+	// AccessLog, if set, turns on the access log, which records every
+	// operation on a record. See AccessLogOptions.
+	AccessLog *AccessLogOptions
+
+	// LockWaitWarning, if positive, logs a warning whenever an operation
+	// waits longer than this for the lock of a table. Lock waits, the holders
+	// of the locks and the operations in progress are reported by Stats.
+	LockWaitWarning time.Duration
+
+	// Logger receives structured events: writes and index rebuilds at the
+	// debug level, cleanups after a crash at the info level, and recoveries
+	// and errors that do not fail an operation, such as skipped corrupt
+	// records or failed background flushes, at the warn and error levels.
+	// Every event has a message starting with "ivy:" and attributes such as
+	// "table", "id" and "err". If it is nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// OpenDB initializes an ivy database configured by the supplied options, such
+// as:
+//
+//	db, err := ivy.OpenDB("data", ivy.WithIndexes(fieldsToIndex), ivy.WithReadOnly())
+//
+// It takes the path of the database directory and the options.
+// It returns a pointer to a DB struct and any error encountered.
+func OpenDB(dbPath string, options ...Option) (*DB, error) {
+	var c openConfig
+
+	for _, option := range options {
+		option(&c)
+	}
+
+	return OpenDBWithOptions(dbPath, c.fieldsToIndex, c.opts)
 }
 
-// OpenDB initializes an ivy database.
+// OpenDBWithOptions initializes an ivy database using the supplied options.
 // It returns a pointer to a DB struct and any error encountered.
-func OpenDB(dbPath string, fieldsToIndex map[string][]string) (*DB, error) {
+func OpenDBWithOptions(dbPath string, fieldsToIndex map[string][]string, opts Options) (_ *DB, err error) {
 	db := new(DB)
+	db.idle = sync.NewCond(&db.stateMu)
 	db.path = dbPath
 	db.fieldsToIndex = fieldsToIndex
+	db.checksums = opts.Checksums
+	db.stableOutput = opts.StableOutput
+	db.codec = opts.Codec
+	if db.codec == nil {
+		db.codec = JSONCodec{}
+	}
+	db.readOnly = opts.ReadOnly
+	db.useNumber = opts.UseNumber
+	db.persistIndexes = opts.PersistentIndexes
+	db.maxRecordSize = opts.MaxRecordSize
+	db.quotas = opts.Quotas
+	db.exportPolicies = opts.ExportPolicies
+	db.readPolicies = opts.ReadPolicies
+	db.authorizer = opts.Authorizer
+	db.actorStamps = opts.ActorStamps
+	db.auditChain = opts.AuditChain
+	if opts.MVCC {
+		db.versions = newVersionStore()
+	}
+	db.restricted = opts.Restricted
+	db.analyzers = opts.Analyzers
+	db.eventTables = make(map[string]bool)
+	for _, tblName := range opts.EventTables {
+		db.eventTables[tblName] = true
+	}
+	db.logger = opts.Logger
+	if db.logger == nil {
+		db.logger = slog.Default()
+	}
+	db.lockWaitWarning = opts.LockWaitWarning
+	db.ops = make(map[*operation]bool)
+	db.usage = make(map[string]*tblUsage)
+	for tblName := range opts.Quotas {
+		db.usage[tblName] = &tblUsage{}
+	}
+	db.generations = make(map[string]uint64)
+	db.checkpointed = make(map[string]uint64)
 
-	err := db.performChecks()
+	db.modes = newFileModes(opts)
+
+	db.fs = opts.FileSystem
+	if db.fs == nil {
+		db.fs = db.modes.fs()
+	}
+
+	// Until the database is fully set up, a failure releases whatever was
+	// opened so far; from then on, Close does.
+	ready := false
+	defer func() {
+		switch {
+		case err == nil:
+		case ready:
+			db.Close()
+		default:
+			db.abortOpen()
+		}
+	}()
+
+	db.engine, err = newEngine(db.path, db.fs, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	db.rwLocks = make(map[string]*sync.RWMutex)
+	opts.EncryptionKey, err = resolveKey(opts.EncryptionKey, opts.EncryptionKeyProvider, opts.KeyTimeout, "encryption")
+	if err != nil {
+		return nil, err
+	}
 
-	db.tagIndexes = make(map[string]map[string][]string)
-	db.fldIndexes = make(map[string]map[string]map[string][]string)
+	opts.FieldKey, err = resolveKey(opts.FieldKey, opts.FieldKeyProvider, opts.KeyTimeout, "field")
+	if err != nil {
+		return nil, err
+	}
 
-	files, _ := ioutil.ReadDir(db.path)
+	// Namespaces are opened with the keys fetched here.
+	db.opts = opts
+	db.opts.EncryptionKeyProvider, db.opts.FieldKeyProvider = nil, nil
 
-	for _, file := range files {
-		if file.IsDir() {
-			if file.Name() != "." && file.Name() != ".." {
-				db.rwLocks[file.Name()] = new(sync.RWMutex)
-			}
+	if opts.EncryptionKey != nil {
+		if opts.Storage == MemoryStorage {
+			return nil, errors.New("ivy: memory storage cannot be encrypted")
+		}
+
+		db.sealer, err = newSealer(opts.EncryptionKey)
+		if err != nil {
+			return nil, err
 		}
+
+		db.engine = &cryptEngine{engine: db.engine, s: db.sealer}
 	}
 
-	for tblName := range db.fieldsToIndex {
-		err := db.initTblIndexes(tblName)
+	if len(opts.EncryptedFields) > 0 {
+		if opts.FieldKey == nil {
+			return nil, errors.New("ivy: encrypted fields need a field key")
+		}
+
+		db.fieldSealer, err = newSealer(opts.FieldKey)
+		if err != nil {
+			return nil, err
+		}
+
+		db.encryptedFields = opts.EncryptedFields
+	}
+
+	db.recordKeys = make(map[string]bool)
+	for _, tblName := range opts.RecordKeys {
+		if len(db.encryptedFields[tblName]) == 0 {
+			return nil, fmt.Errorf("ivy: table %s has record keys and no encrypted fields", tblName)
+		}
+		db.recordKeys[tblName] = true
+	}
+
+	if opts.WriteBehind != nil {
+		db.engine = newWriteBehindEngine(db.engine, *opts.WriteBehind, db.logger)
+	}
+
+	err = db.open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.AccessLog != nil {
+		db.accessLog, err = openAccessLog(*opts.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ready = true
+
+	for _, hook := range opts.Webhooks {
+		db.webhooks = append(db.webhooks, newWebhook(hook, db.logger))
+	}
+
+	if opts.Git != nil && !db.readOnly {
+		db.gitOpts = opts.Git
+
+		err = db.checkGit()
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	err = db.pinTables(opts.PinnedTables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.startJobs(opts.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.startFileWatch(opts.WatchFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.startOutbox(opts.Outbox)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.resumeAlterations()
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// OpenMemDB initializes an ivy database that keeps all tables and indexes in
+// memory, without a data directory. It shares the exact API of a database
+// opened with OpenDB, which makes it handy for unit tests and ephemeral
+// caches. The tables listed in fieldsToIndex are created empty.
+// It returns a pointer to a DB struct and any error encountered.
+func OpenMemDB(fieldsToIndex map[string][]string) (*DB, error) {
+	return OpenDBWithOptions("", fieldsToIndex, Options{Storage: MemoryStorage})
+}
+
 //*****************************************************************************
 // Public DB Methods
 //*****************************************************************************
@@ -79,14 +575,45 @@ func OpenDB(dbPath string, fieldsToIndex map[string][]string) (*DB, error) {
 // record to find. It populates the Record struct attributes with values from
 // the found record. It returns any error encountered.
 func (db *DB) Find(tblName string, rec Record, fileId string) error {
-	db.rwLocks[tblName].RLock()
-	defer db.rwLocks[tblName].RUnlock()
+	return db.FindCtx(context.Background(), tblName, rec, fileId)
+}
+
+// FindCtx is Find with a context. If the context is done once the table is
+// locked, it returns the context's error without reading the record.
+func (db *DB) FindCtx(ctx context.Context, tblName string, rec Record, fileId string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
 
-	err := db.loadRec(tblName, rec, fileId)
+	op, err := db.beginOp(ctx, tblName, fileId, "find")
+	defer func() { db.endOp(op, fileId, err) }()
 	if err != nil {
 		return err
 	}
 
+	if db.tblLock(tblName) == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.metrics.countOp(tblName, "find")
+
+	err = db.loadRec(tblName, rec, fileId)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
 	rec.AfterFind(db, fileId)
 
 	return nil
@@ -96,13 +623,43 @@ func (db *DB) Find(tblName string, rec Record, fileId string) error {
 // It takes a table name.
 // It returns a slice of ids and any error encountered.
 func (db *DB) FindAllIds(tblName string) ([]string, error) {
+	return db.FindAllIdsCtx(context.Background(), tblName)
+}
+
+// FindAllIdsCtx is FindAllIds with a context. If the context is done once
+// the table is locked, it returns the context's error.
+func (db *DB) FindAllIdsCtx(ctx context.Context, tblName string) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "ids")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
 	var ids []string
 
-	db.rwLocks[tblName].RLock()
-	defer db.rwLocks[tblName].RUnlock()
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
 
 	// For every file in the data dir...
-	for _, fileId := range db.fileIdsInDataDir(tblName) {
+	for _, fileId := range fileIds {
 		ids = append(ids, fileId)
 	}
 
@@ -110,47 +667,264 @@ func (db *DB) FindAllIds(tblName string) ([]string, error) {
 }
 
 // FindFirstIdForField returns the first record id that matches the supplied
-// search criteria. It takes a table name, a field name to search on, and a
-// value to search for. It returns a record id and any error encountered.
+// search criteria, as FindAllIdsForField finds them, or an error wrapping
+// ErrNotFound if no record does. It takes a table name, a field name to
+// search on, and a value to search for. It returns a record id and any error
+// encountered.
 func (db *DB) FindFirstIdForField(tblName string, searchField string, searchValue string) (string, error) {
-	results, err := db.FindAllIdsForField(tblName, searchField, searchValue)
+	return db.FindFirstIdForFieldCtx(context.Background(), tblName, searchField, searchValue)
+}
+
+// FindFirstIdForFieldCtx is FindFirstIdForField with a context; see
+// FindAllIdsForFieldCtx.
+func (db *DB) FindFirstIdForFieldCtx(ctx context.Context, tblName string, searchField string, searchValue string) (string, error) {
+	results, err := db.FindAllIdsForFieldCtx(ctx, tblName, searchField, searchValue)
 	if err != nil {
 		return "", err
 	}
 
+	if len(results) == 0 {
+		return "", fmt.Errorf("%w: no %s %q in table %s", ErrNotFound, searchField, searchValue, tblName)
+	}
+
 	return results[0], nil
 }
 
 // FindAllIdsForField returns all record ids that match the supplied search
 // criteria.  It takes a table name, a field name to search on, and a value
 // to search for.  It returns a slice of record ids and any error encountered.
+//
+// Numbers and booleans match the search value they are written as in JSON,
+// so 437 matches "437" and true matches "true", and a search value holding a
+// number matches the same number written differently, such as "437.0".
+// Records without the field or holding null never match. A record holding an
+// array or an object in the field returns a FieldTypeError. Fields of nested
+// objects are named by their path, such as "engine.type", in searches and in
+// the fields to index alike.
 func (db *DB) FindAllIdsForField(tblName string, searchField string, searchValue string) ([]string, error) {
-	var rec map[string]interface{}
+	return db.FindAllIdsForFieldCtx(context.Background(), tblName, searchField, searchValue)
+}
+
+// FindAllIdsForFieldCtx is FindAllIdsForField with a context. A search of a
+// field without an index, which reads every record, stops with the context's
+// error as soon as the context is done.
+func (db *DB) FindAllIdsForFieldCtx(ctx context.Context, tblName string, searchField string, searchValue string) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
 	var ids []string
 
-	db.rwLocks[tblName].RLock()
-	defer db.rwLocks[tblName].RUnlock()
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	db.metrics.countOp(tblName, "query")
 
 	// If we have an index on that field...
-	if ids, ok := db.fldIndexes[tblName][searchField][searchValue]; ok {
-		return ids, nil
+	if fldIndex, ok := db.fldIndex(tblName)[searchField]; ok {
+		db.metrics.countIndexHit(tblName)
+		return indexLookup(fldIndex, searchValue, db.useNumber), nil
+	}
+
+	start := time.Now()
+	defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
 	}
 
 	// Otherwise, for every file in the data dir...
-	for _, fileId := range db.fileIdsInDataDir(tblName) {
-		filename := db.filePath(tblName, fileId)
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := db.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, err
+		}
+
+		value := fieldValue(rec, searchField)
+
+		match, err := fieldMatches(value, searchValue, db.useNumber)
+		if err != nil {
+			return nil, &FieldTypeError{Table: tblName, Id: fileId, Field: searchField, Value: value}
+		}
+		if match {
+			ids = append(ids, fileId)
+		}
+	}
+
+	return ids, nil
+}
+
+// FindAllIdsForFieldContains returns the ids of the records holding an array
+// in a field that contains an element matching a search value, as for
+// FindAllIdsForField, such as the records of planes with "Hellcat" among
+// their "aliases". Records without the field or holding null never match; a
+// record holding anything else but an array returns a FieldTypeError. The
+// elements of an array field are indexed by naming it with a "[]" suffix in
+// the fields to index, such as "aliases[]". It takes a table name, a field
+// name, and a value to search for. It returns a slice of record ids and any
+// error encountered.
+func (db *DB) FindAllIdsForFieldContains(tblName string, searchField string, searchValue string) ([]string, error) {
+	return db.FindAllIdsForFieldContainsCtx(context.Background(), tblName, searchField, searchValue)
+}
+
+// FindAllIdsForFieldContainsCtx is FindAllIdsForFieldContains with a context.
+// A search of a field without an index, which reads every record, stops with
+// the context's error as soon as the context is done.
+func (db *DB) FindAllIdsForFieldContainsCtx(ctx context.Context, tblName string, searchField string, searchValue string) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	var ids []string
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	if fldIndex, ok := db.fldIndex(tblName)[searchField+"[]"]; ok {
+		db.metrics.countIndexHit(tblName)
+		return indexLookup(fldIndex, searchValue, db.useNumber), nil
+	}
+
+	start := time.Now()
+	defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := db.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, err
+		}
+
+		value := fieldValue(rec, searchField)
+		if value == nil {
+			continue
+		}
+
+		elems, ok := value.([]interface{})
+		if !ok {
+			return nil, &FieldTypeError{Table: tblName, Id: fileId, Field: searchField, Value: value}
+		}
+
+		for _, elem := range elems {
+			// Elements that are arrays or objects never match.
+			if match, _ := fieldMatches(elem, searchValue, db.useNumber); match {
+				ids = append(ids, fileId)
+				break
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// FindAllIdsWhereMissing returns the ids of the records lacking a field: those
+// without it, holding null, or holding an empty string, array or object, such
+// as the records written before the field was added that are to be backfilled.
+// Every record is read, as indexes leave such records out. It takes a table
+// name and a field name, which may be a path into nested objects. It returns a
+// slice of record ids and any error encountered.
+func (db *DB) FindAllIdsWhereMissing(tblName string, searchField string) ([]string, error) {
+	return db.FindAllIdsWhereMissingCtx(context.Background(), tblName, searchField)
+}
+
+// FindAllIdsWhereMissingCtx is FindAllIdsWhereMissing with a context. It stops
+// with the context's error as soon as the context is done.
+func (db *DB) FindAllIdsWhereMissingCtx(ctx context.Context, tblName string, searchField string) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	var ids []string
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	start := time.Now()
+	defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		data, err := ioutil.ReadFile(filename)
+		data, err := db.readRec(tblName, fileId)
 		if err != nil {
 			return nil, err
 		}
 
-		err = json.Unmarshal(data, &rec)
+		rec, err := db.decodeFields(data)
 		if err != nil {
 			return nil, err
 		}
 
-		if rec[searchField].(string) == searchValue {
+		if isEmptyValue(fieldValue(rec, searchField)) {
 			ids = append(ids, fileId)
 		}
 	}
@@ -162,11 +936,39 @@ func (db *DB) FindAllIdsForField(tblName string, searchField string, searchValue
 // search tags. It takes a table name, and a slice of tags to search for.
 // It returns a slice of record ids and any error encountered.
 func (db *DB) FindAllIdsForTags(tblName string, searchTags []string) ([]string, error) {
+	return db.FindAllIdsForTagsCtx(context.Background(), tblName, searchTags)
+}
+
+// FindAllIdsForTagsCtx is FindAllIdsForTags with a context. If the context
+// is done once the table is locked, it returns the context's error.
+func (db *DB) FindAllIdsForTagsCtx(ctx context.Context, tblName string, searchTags []string) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
 	var ids []string
 	var possibleMatchingFileIdsMap map[string]int
 
-	db.rwLocks[tblName].RLock()
-	defer db.rwLocks[tblName].RUnlock()
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.metrics.countOp(tblName, "query")
+	db.metrics.countIndexHit(tblName)
 
 	if len(searchTags) != 0 {
 		// Need a map to hold possible file ids for answers whose tags include at
@@ -176,7 +978,7 @@ func (db *DB) FindAllIdsForTags(tblName string, searchTags []string) ([]string,
 		// For each one of the search tags...
 		for _, tag := range searchTags {
 			// If the search tag is in the index...
-			if fileIds, ok := db.tagIndexes[tblName][tag]; ok {
+			if fileIds, ok := db.tagIndex(tblName)[tag]; ok {
 				// Loop through all the file ids that have that tag in the index...
 				for _, fileId := range fileIds {
 					// If we have already added that file id to the map of possible
@@ -211,242 +1013,1014 @@ func (db *DB) FindAllIdsForTags(tblName string, searchTags []string) ([]string,
 		}
 	}
 
-	return ids, nil
+	return ids, nil
+
+}
+
+// Create creates a new record for the specified table.
+// It takes a table name, and a struct representing the record data.
+// It returns the id of the newly created record and any error encountered.
+func (db *DB) Create(tblName string, rec interface{}) (string, error) {
+	return db.CreateCtx(context.Background(), tblName, rec)
+}
+
+// CreateCtx is Create with a context. If the context is done once the table
+// is locked, it returns the context's error without writing anything.
+func (db *DB) CreateCtx(ctx context.Context, tblName string, rec interface{}) (fileId string, err error) {
+	if err := db.enter(); err != nil {
+		return "", err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "create")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return "", err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return "", tableNotFoundErr(tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return "", err
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	fileId, err = db.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	marshalledRec, err := db.codec.Marshal(rec)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = db.writeRec(ctx, tblName, fileId, marshalledRec, false)
+	if err != nil {
+		return "", err
+	}
+
+	return fileId, nil
+}
+
+// Update updates a record for the specified table.
+// It takes a table name, a struct representing the record data, and the record
+// id of the record to be changed.  It returns any error encountered.
+func (db *DB) Update(tblName string, rec interface{}, fileId string) error {
+	return db.UpdateCtx(context.Background(), tblName, rec, fileId)
+}
+
+// UpdateCtx is Update with a context. If the context is done once the table
+// is locked, it returns the context's error without writing anything.
+func (db *DB) UpdateCtx(ctx context.Context, tblName string, rec interface{}, fileId string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "update")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.checkMutable(tblName); err != nil {
+		return err
+	}
+
+	if err := db.checkUnrestricted(tblName); err != nil {
+		return err
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Is fileid valid?
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	marshalledRec, err := db.codec.Marshal(rec)
+
+	if err != nil {
+		return err
+	}
+
+	err = db.writeRec(ctx, tblName, fileId, marshalledRec, true)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete deletes a record for the specified table.
+// It takes a table name and the record id of the record to be deleted..
+// It returns any error encountered.
+func (db *DB) Delete(tblName string, fileId string) error {
+	return db.DeleteCtx(context.Background(), tblName, fileId)
+}
+
+// DeleteCtx is Delete with a context. If the context is done once the table
+// is locked, it returns the context's error without deleting anything.
+func (db *DB) DeleteCtx(ctx context.Context, tblName string, fileId string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "delete")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.checkMutable(tblName); err != nil {
+		return err
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err = db.removeRec(ctx, tblName, fileId)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
+	return nil
+}
+
+// Reindex rebuilds the indexes of a table from its records.
+// It takes a table name.
+// It returns any error encountered.
+func (db *DB) Reindex(tblName string) error {
+	return db.ReindexCtx(context.Background(), tblName)
+}
+
+// ReindexCtx is Reindex with a context. If the context is done before the
+// rebuild is finished, the table keeps its previous indexes and the context's
+// error is returned.
+func (db *DB) ReindexCtx(ctx context.Context, tblName string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "reindex")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	return db.initTblIndexes(ctx, tblName)
+}
+
+// Duplicate copies a record to a new id, such as to use it as a template.
+// It takes a table name and the record id of the record to copy. It returns
+// the record id of the copy and any error encountered.
+func (db *DB) Duplicate(tblName string, fileId string) (string, error) {
+	return db.DuplicateWithOverrides(tblName, fileId, nil)
+}
+
+// DuplicateCtx is Duplicate with a context, which is handed to the authorizer.
+func (db *DB) DuplicateCtx(ctx context.Context, tblName string, fileId string) (string, error) {
+	return db.DuplicateWithOverridesCtx(ctx, tblName, fileId, nil)
+}
+
+// DuplicateWithOverrides copies a record to a new id, as Duplicate does,
+// setting the supplied fields of the copy. Fields of nested objects are named
+// by their path, such as "address.city". The record is read and the copy
+// written while holding the table's lock. It takes a table name, the record
+// id of the record to copy, and a map from field names to new values. It
+// returns the record id of the copy and any error encountered.
+func (db *DB) DuplicateWithOverrides(tblName string, fileId string, overrides map[string]interface{}) (string, error) {
+	return db.DuplicateWithOverridesCtx(context.Background(), tblName, fileId, overrides)
+}
+
+// DuplicateWithOverridesCtx is DuplicateWithOverrides with a context, which
+// is handed to the authorizer.
+func (db *DB) DuplicateWithOverridesCtx(ctx context.Context, tblName string, fileId string, overrides map[string]interface{}) (newId string, err error) {
+	if err := db.enter(); err != nil {
+		return "", err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, newId, "create")
+	defer func() { db.endOp(op, newId, err) }()
+	if err != nil {
+		return "", err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return "", tableNotFoundErr(tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return "", err
+	}
+
+	if err := checkId(fileId); err != nil {
+		return "", err
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	data, err := db.readRec(tblName, fileId)
+	if err != nil {
+		return "", recErr(tblName, fileId, err)
+	}
+
+	// Fields sealed with the data key of the original are opened, so that
+	// writing the copy seals them with a data key of its own.
+	if db.recordKeys[tblName] {
+		data, err = db.openFields(tblName, fileId, data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(overrides) > 0 {
+		var rec map[string]interface{}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+
+		err = dec.Decode(&rec)
+		if err != nil {
+			return "", corruptErr(tblName, fileId, err.Error())
+		}
+
+		for field, value := range overrides {
+			err = setPath(rec, field, value)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		data, err = json.Marshal(rec)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	newId, err = db.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.writeRec(ctx, tblName, newId, data, false)
+	if err != nil {
+		return "", err
+	}
+
+	return newId, nil
+}
+
+// Sync flushes all pending writes to storage. It only has work to do in
+// write-behind mode, or with a write-ahead log, which gets a checkpoint so
+// that recovery does not have to go back further. It returns any error
+// encountered.
+func (db *DB) Sync() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	if db.wal != nil {
+		return db.wal.checkpoint(db.engine.sync)
+	}
+
+	return db.engine.sync()
+}
+
+// Close closes an ivy database. It waits for operations in progress to
+// finish, flushes pending writes and index checkpoints, releases the storage
+// engine, and removes the lock file. Every operation on the database after
+// Close, including another Close, returns ErrClosed. It returns any error
+// encountered while flushing.
+func (db *DB) Close() error {
+	db.stateMu.Lock()
+	if db.closed {
+		db.stateMu.Unlock()
+		return ErrClosed
+	}
+	db.closed = true
+	if db.follower != nil {
+		db.follower.halt()
+	}
+	if db.stopJobs != nil {
+		db.stopJobs()
+	}
+	for db.active > 0 {
+		db.idle.Wait()
+	}
+	db.stateMu.Unlock()
+
+	err := db.closeNamespaces()
+
+	// The access log and the webhooks of a namespace are its database's.
+	if db.namespace == "" {
+		db.closeWebhooks()
+	}
+	db.closeSubscriptions()
+	db.closeWatchers()
+	db.jobsWg.Wait()
+
+	if cerr := db.checkpoint(); err == nil {
+		err = cerr
+	}
+
+	if db.wal != nil {
+		if werr := db.wal.checkpoint(db.engine.sync); err == nil {
+			err = werr
+		}
+	}
+
+	if cerr := db.engine.close(); err == nil {
+		err = cerr
+	}
+
+	if db.wal != nil {
+		if werr := db.wal.close(); err == nil {
+			err = werr
+		}
+	}
+
+	if db.accessLog != nil && db.namespace == "" {
+		if aerr := db.accessLog.close(); err == nil {
+			err = aerr
+		}
+	}
+
+	if db.audit != nil {
+		if aerr := db.audit.close(); err == nil {
+			err = aerr
+		}
+	}
+
+	if lerr := db.releaseLock(); err == nil {
+		err = lerr
+	}
+
+	return err
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// abortOpen releases what OpenDBWithOptions opened before failing to open
+// the database.
+func (db *DB) abortOpen() {
+	if db.accessLog != nil {
+		db.accessLog.close()
+	}
+	if db.wal != nil {
+		db.wal.close()
+	}
+	if db.audit != nil {
+		db.audit.close()
+	}
+	if db.engine != nil {
+		db.engine.close()
+	}
+	db.releaseLock()
+}
+
+// open checks the database, takes the database lock, cleans up after a crash
+// and loads the indexes.
+func (db *DB) open(opts Options) error {
+	err := db.performChecks()
+	if err != nil {
+		return err
+	}
+
+	_, local := db.fs.(osFileSystem)
+
+	if local && opts.Storage != MemoryStorage && !opts.NoLock {
+		err = db.acquireLock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Storage != MemoryStorage {
+		err = db.checkKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	db.rwLocks = make(map[string]*tblMutex)
+
+	db.tagIndexes = make(map[string]map[string][]string)
+	db.fldIndexes = make(map[string]map[string]map[string][]string)
+
+	tblNames, err := db.engine.tableNames()
+	if err != nil {
+		return err
+	}
+
+	for _, tblName := range tblNames {
+		db.rwLocks[tblName] = db.newTblLock(tblName)
+	}
+
+	err = db.checkConfig(opts, tblNames)
+	if err != nil {
+		return err
+	}
+
+	// Only the lock holder may remove temporary files; without the lock they
+	// could belong to writes in progress in another process.
+	if db.lockPath != "" {
+		err = db.removeTempFiles(tblNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	fresh := false
+
+	if opts.AuditChain && !db.readOnly {
+		if !local || opts.Storage == MemoryStorage {
+			return errors.New("ivy: the audit chain requires the local file system")
+		}
+
+		fresh, err = db.openAuditLog()
+		if err != nil {
+			return err
+		}
+	}
+
+	var entries []walEntry
+
+	if opts.WAL != nil {
+		if !local || opts.Storage == MemoryStorage {
+			return errors.New("ivy: the write-ahead log requires the local file system")
+		}
+
+		var truncated int64
+
+		entries, truncated, err = db.openWAL(*opts.WAL)
+		if err != nil {
+			return err
+		}
+
+		// Recover before building the indexes, so that they cover the
+		// recovered records.
+		db.recovery, err = db.recover(entries, truncated)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A new audit log starts from the records as they are after recovery.
+	if fresh {
+		err = db.auditBaseline(tblNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	for tblName := range db.fieldsToIndex {
+		err := db.loadTblIndexes(tblName, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enter registers an operation in progress, so that Close waits for it to
+// finish. It returns ErrClosed if the database is closed or closing. Every
+// successful call must be paired with a call to leave.
+func (db *DB) enter() error {
+	db.stateMu.Lock()
+	defer db.stateMu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	db.active++
+
+	return nil
+}
+
+// leave marks the end of an operation registered with enter.
+func (db *DB) leave() {
+	db.stateMu.Lock()
+	defer db.stateMu.Unlock()
+
+	db.active--
+	if db.active == 0 {
+		db.idle.Broadcast()
+	}
+}
+
+// tblLock returns the lock of a table, or nil if there is no such table. A
+// table created in storage since the database was opened is registered on
+// first use.
+func (db *DB) tblLock(tblName string) *tblMutex {
+	db.tblMu.RLock()
+	rwLock := db.rwLocks[tblName]
+	db.tblMu.RUnlock()
+
+	if rwLock == nil {
+		rwLock = db.discoverTable(tblName)
+	}
 
+	return rwLock
 }
 
-// Create creates a new record for the specified table.
-// It takes a table name, and a struct representing the record data.
-// It returns the id of the newly created record and any error encountered.
-func (db *DB) Create(tblName string, rec interface{}) (string, error) {
-	db.rwLocks[tblName].Lock()
-	defer db.rwLocks[tblName].Unlock()
+// indexFields returns the indexed fields of a table, and whether the table is
+// indexed at all.
+func (db *DB) indexFields(tblName string) ([]string, bool) {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
 
-	fileId, err := db.nextAvailableFileId(tblName)
-	if err != nil {
-		return "", err
+	fldNames, ok := db.fieldsToIndex[tblName]
+
+	return fldNames, ok
+}
+
+// indexedTables returns the names of all indexed tables, in order.
+func (db *DB) indexedTables() []string {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
+
+	tblNames := make([]string, 0, len(db.fieldsToIndex))
+	for tblName := range db.fieldsToIndex {
+		tblNames = append(tblNames, tblName)
 	}
+	sort.Strings(tblNames)
 
-	marshalledRec, err := json.Marshal(rec)
+	return tblNames
+}
 
-	if err != nil {
-		return "", err
+// tagIndex returns the tag index of a table.
+func (db *DB) tagIndex(tblName string) map[string][]string {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
+
+	return db.tagIndexes[tblName]
+}
+
+// fldIndex returns the field indexes of a table.
+func (db *DB) fldIndex(tblName string) map[string]map[string][]string {
+	db.tblMu.RLock()
+	defer db.tblMu.RUnlock()
+
+	return db.fldIndexes[tblName]
+}
+
+// setIndexes replaces the indexes of a table. A nil index is left as it is.
+func (db *DB) setIndexes(tblName string, tagIndex map[string][]string, fldIndexes map[string]map[string][]string) {
+	db.tblMu.Lock()
+	defer db.tblMu.Unlock()
+
+	if tagIndex != nil {
+		db.tagIndexes[tblName] = tagIndex
 	}
+	if fldIndexes != nil {
+		db.fldIndexes[tblName] = fldIndexes
+	}
+}
 
-	filename := db.filePath(tblName, fileId)
+// readRec returns the marshalled record with the supplied id, verifying and
+// stripping its checksum if checksums are enabled. A pinned record is served
+// from memory.
+func (db *DB) readRec(tblName string, fileId string) ([]byte, error) {
+	if data, ok := db.pins.get(tblName, fileId); ok {
+		return data, nil
+	}
 
-	err = ioutil.WriteFile(filename, marshalledRec, 0600)
+	data, err := db.engine.read(tblName, fileId)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = db.initTblIndexes(tblName)
+	db.metrics.countRead(tblName, len(data))
+
+	data, err = db.decodeRec(tblName, fileId, data)
 	if err != nil {
-		return fileId, err
+		return nil, err
 	}
 
-	return fileId, nil
+	db.pins.put(tblName, fileId, data)
+
+	return data, nil
 }
 
-// Update updates a record for the specified table.
-// It takes a table name, a struct representing the record data, and the record
-// id of the record to be changed.  It returns any error encountered.
-func (db *DB) Update(tblName string, rec interface{}, fileId string) error {
-	db.rwLocks[tblName].Lock()
-	defer db.rwLocks[tblName].Unlock()
+// writeRec stores a marshalled record and updates the table's indexes. If
+// replace is true, the previous version of the record is read first so that
+// its index entries can be removed. The record has to fit into the table's
+// quota, counting only the growth of a record it replaces. The caller must
+// hold the table's write lock.
+func (db *DB) writeRec(ctx context.Context, tblName string, fileId string, data []byte, replace bool) error {
+	var oldData []byte
 
-	// Is fileid valid?
-	_, err := strconv.Atoi(fileId)
+	actor, _ := ActorFromContext(ctx)
+
+	data, err := db.sealFields(tblName, fileId, data)
 	if err != nil {
 		return err
 	}
 
-	marshalledRec, err := json.Marshal(rec)
+	if db.maxRecordSize > 0 && len(data) > db.maxRecordSize {
+		return fmt.Errorf("%w: %s record is %d bytes, the limit is %d", ErrRecordTooLarge, tblName, len(data), db.maxRecordSize)
+	}
+
+	var oldRaw []byte
+
+	rebuildIndexes := false
+
+	if replace {
+		oldRaw, err = db.engine.read(tblName, fileId)
+		if err == nil {
+			oldData, err = db.decodeRec(tblName, fileId, oldRaw)
+		}
+		if err != nil {
+			switch {
+			case os.IsNotExist(err):
+			case errors.Is(err, ErrCorrupt):
+				// The old version cannot be trusted to remove its index entries.
+				rebuildIndexes = true
+			default:
+				return err
+			}
+		}
+	}
+
+	if oldRaw != nil {
+		if err := db.checkMutable(tblName); err != nil {
+			return err
+		}
+	}
 
+	data, err = db.stampActor(actor, data, oldData)
 	if err != nil {
 		return err
 	}
 
-	filename := db.filePath(tblName, fileId)
-
-	err = ioutil.WriteFile(filename, marshalledRec, 0600)
+	data, err = db.stabilize(tblName, fileId, data)
 	if err != nil {
 		return err
 	}
 
-	err = db.initTblIndexes(tblName)
+	encoded := db.encodeRec(data)
+
+	err = db.enforceQuota(ctx, tblName, fileId, int64(len(encoded)))
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	tx := txFromContext(ctx)
 
-// Delete deletes a record for the specified table.
-// It takes a table name and the record id of the record to be deleted..
-// It returns any error encountered.
-func (db *DB) Delete(tblName string, fileId string) error {
-	_, err := strconv.Atoi(fileId)
+	seq, err := db.logWrite(tx, tblName, fileId, encoded, oldRaw)
 	if err != nil {
 		return err
 	}
 
-	filename := db.filePath(tblName, fileId)
+	db.trackUsage(tblName, fileId, int64(len(encoded)))
 
-	db.rwLocks[tblName].Lock()
-	defer db.rwLocks[tblName].Unlock()
+	db.bumpGeneration(tblName)
 
-	err = os.Remove(filename)
-	if err != nil {
+	op := "update"
+	if oldRaw == nil {
+		op = "create"
+	}
+
+	db.logger.Debug("ivy: write", "table", tblName, "id", fileId, "op", op, "bytes", len(encoded))
+
+	db.metrics.countOp(tblName, op)
+	db.metrics.countWrite(tblName, len(encoded))
+
+	db.onCommit(tx, func() {
+		db.auditRec(tblName, fileId, op, actor, encoded)
+
+		db.notifyWebhooks(tblName, fileId, op, actor, data)
+		db.notifySubscriptions(tblName, oldData, data)
+		db.notifyWatchers(seq, tblName, fileId, op, data)
+
+		db.commitToGit(tx, tblName, fileId, op, actor)
+	})
+
+	if rebuildIndexes {
+		return db.initTblIndexes(context.Background(), tblName)
+	}
+
+	return db.updateTblIndexes(tblName, fileId, oldData, data)
+}
+
+// removeRec deletes a record and updates the table's indexes. The caller must
+// hold the table's write lock.
+func (db *DB) removeRec(ctx context.Context, tblName string, fileId string) error {
+	if err := db.checkMutable(tblName); err != nil {
 		return err
 	}
 
-	err = db.initTblIndexes(tblName)
+	rebuildIndexes := false
+
+	oldRaw, err := db.engine.read(tblName, fileId)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	oldData, err := db.decodeRec(tblName, fileId, oldRaw)
+	if err != nil {
+		if !errors.Is(err, ErrCorrupt) {
+			return err
+		}
+		rebuildIndexes = true
+	}
 
-// Close closes an ivy database.
-func (db *DB) Close() {
-	for _, rwLock := range db.rwLocks {
-		rwLock.Lock()
-		rwLock.Unlock()
+	tx := txFromContext(ctx)
+
+	seq, err := db.logWrite(tx, tblName, fileId, nil, oldRaw)
+	if err != nil {
+		return err
 	}
-}
 
-//*****************************************************************************
-// Private DB Methods
-//*****************************************************************************
+	actor, _ := ActorFromContext(ctx)
 
-// fileIdsInDataDir returns all file ids in a directory.
-func (db *DB) fileIdsInDataDir(tblName string) []string {
-	var ids []string
+	db.trackUsage(tblName, fileId, -1)
+
+	db.bumpGeneration(tblName)
+
+	db.logger.Debug("ivy: write", "table", tblName, "id", fileId, "op", "delete")
+
+	db.metrics.countOp(tblName, "delete")
+
+	db.onCommit(tx, func() {
+		db.auditRec(tblName, fileId, "delete", actor, nil)
 
-	files, _ := ioutil.ReadDir(db.tblPath(tblName))
-	for _, file := range files {
-		if !file.IsDir() {
-			if path.Ext(file.Name()) == ".json" {
-				ids = append(ids, file.Name()[:len(file.Name())-5])
+		db.notifyWebhooks(tblName, fileId, "delete", actor, nil)
+		db.notifySubscriptions(tblName, oldData, nil)
+		db.notifyWatchers(seq, tblName, fileId, "delete", nil)
+
+		db.commitToGit(tx, tblName, fileId, "delete", actor)
+
+		// The data key goes with the record, unless a transaction created
+		// the record again.
+		if db.recordKeys[tblName] {
+			if _, err := db.engine.read(tblName, fileId); os.IsNotExist(err) {
+				if err := db.dropRecordKey(tblName, fileId); err != nil {
+					db.logger.Error("ivy: data key not destroyed", "table", tblName, "id", fileId, "err", err)
+				}
 			}
 		}
+	})
+
+	if rebuildIndexes {
+		return db.initTblIndexes(context.Background(), tblName)
 	}
 
-	return ids
+	return db.updateTblIndexes(tblName, fileId, oldData, nil)
 }
 
-// filePath returns a file name for a table name and a file id.
-func (db *DB) filePath(tblName string, fileId string) string {
-	return fmt.Sprintf("%v/%v.json", db.tblPath(tblName), fileId)
+// decodeFields decodes a marshalled record into a map, with numbers as
+// float64, or as json.Number if Options.UseNumber is set.
+func (db *DB) decodeFields(data []byte) (map[string]interface{}, error) {
+	var rec map[string]interface{}
+
+	if !db.useNumber {
+		err := json.Unmarshal(data, &rec)
+		return rec, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&rec)
+
+	return rec, err
 }
 
 // loadRec reads a json file into the supplied interface.
 func (db *DB) loadRec(tblName string, rec interface{}, fileId string) error {
-	filename := db.filePath(tblName, fileId)
+	data, err := db.readRec(tblName, fileId)
+	if err != nil {
+		return err
+	}
+
+	return db.unmarshalRec(tblName, fileId, data, rec)
+}
 
-	data, err := ioutil.ReadFile(filename)
+// unmarshalRec unmarshals a marshalled record, as returned by readRec, into
+// the supplied interface, opening its encrypted fields and masking the fields
+// of the read policy.
+func (db *DB) unmarshalRec(tblName string, fileId string, data []byte, rec interface{}) error {
+	data, err := db.plainRec(tblName, fileId, data)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(data, rec)
+	err = db.codec.Unmarshal(data, rec)
+	if _, ok := err.(*json.SyntaxError); ok {
+		return corruptErr(tblName, fileId, err.Error())
+	}
 
 	return err
 }
 
-// initNonTagsIndexes initializes all non-tag indexes for a table.
-func (db *DB) initNonTagsIndexes(tblName string) error {
-	var rec map[string]interface{}
+// plainRec returns a marshalled record, as returned by readRec, as it is
+// handed to callers: its encrypted fields opened and the fields of the read
+// policy masked.
+func (db *DB) plainRec(tblName string, fileId string, data []byte) ([]byte, error) {
+	data, err := db.openFields(tblName, fileId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.restrict(tblName, fileId, data)
+}
+
+// indexKeys returns the keys of a record in the index of a field. A field named
+// with a "[]" suffix, such as "aliases[]", indexes the elements of the array
+// it holds, as the tags are indexed, and one named with an "@time" suffix,
+// such as "created_at@time", indexes the time it holds. Two fields named with
+// an "@geo" suffix, such as "lat,lng@geo", index a location, and a field named
+// with an "@text" suffix, such as "body@text", indexes the words of its text.
+func (db *DB) indexKeys(tblName string, rec map[string]interface{}, fldName string) []string {
+	if path := strings.TrimSuffix(fldName, "[]"); path != fldName {
+		return tagKeys(fieldValue(rec, path))
+	}
+
+	if path := strings.TrimSuffix(fldName, "@text"); path != fldName {
+		return textKeys(db.analyzers[tblName], fieldValue(rec, path))
+	}
+
+	if fields := strings.TrimSuffix(fldName, "@geo"); fields != fldName {
+		if key, ok := geoKey(rec, fields); ok {
+			return []string{key}
+		}
+		return nil
+	}
+
+	if path := strings.TrimSuffix(fldName, "@time"); path != fldName {
+		if key, ok := timeKey(fieldValue(rec, path)); ok {
+			return []string{key}
+		}
+		return nil
+	}
 
-	// Delete all the indexes for this table.
-	for k := range db.fldIndexes[tblName] {
-		delete(db.fldIndexes[tblName], k)
+	if key, ok := fieldKey(fieldValue(rec, fldName)); ok {
+		return []string{key}
 	}
 
-	db.fldIndexes[tblName] = make(map[string]map[string][]string)
+	return nil
+}
+
+// initNonTagsIndexes builds all non-tag indexes for a table. It stops with the
+// context's error as soon as the context is done. It returns the indexes and
+// any error encountered.
+func (db *DB) initNonTagsIndexes(ctx context.Context, tblName string) (map[string]map[string][]string, error) {
+	fldNames, _ := db.indexFields(tblName)
+	fldIndexes := make(map[string]map[string][]string)
 
 	// Reinit all the indexes for this table.
-	for _, fldName := range db.fieldsToIndex[tblName] {
+	for _, fldName := range fldNames {
 		if fldName != "tags" {
-			db.fldIndexes[tblName][fldName] = make(map[string][]string)
+			fldIndexes[fldName] = make(map[string][]string)
 		}
 	}
 
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
 	// For every file in the data dir...
-	for _, fileId := range db.fileIdsInDataDir(tblName) {
-		filename := db.filePath(tblName, fileId)
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		data, err := ioutil.ReadFile(filename)
+		data, err := db.readRec(tblName, fileId)
+		if errors.Is(err, ErrCorrupt) {
+			// Corrupt records are left out of the indexes; Verify reports them.
+			db.logger.Warn("ivy: corrupt record left out of the indexes", "table", tblName, "id", fileId, "err", err)
+			continue
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		err = json.Unmarshal(data, &rec)
+		rec, err := db.decodeFields(data)
 		if err != nil {
-			return err
+			db.logger.Warn("ivy: corrupt record left out of the indexes", "table", tblName, "id", fileId, "err", err)
+			continue
 		}
 
-		for _, fldName := range db.fieldsToIndex[tblName] {
+		for _, fldName := range fldNames {
 			// Skip tags because we index them separately
 			if fldName == "tags" {
 				continue
 			}
 
-			// Convert back into a string.
-			fldValue := rec[fldName].(string)
-
-			// If the field value already exists as a key in the index...
-			if fileIds, ok := db.fldIndexes[tblName][fldName][fldValue]; ok {
-				// Add the file id to the list of ids for that field value, if it is not
-				// already in the list.
-				if !stringInSlice(fileId, fileIds) {
-					db.fldIndexes[tblName][fldName][fldValue] = append(fileIds, fileId)
+			// Records without a value that can be searched for are left out.
+			for _, fldValue := range db.indexKeys(tblName, rec, fldName) {
+				// If the field value already exists as a key in the index...
+				if fileIds, ok := fldIndexes[fldName][fldValue]; ok {
+					// Add the file id to the list of ids for that field value, if it is not
+					// already in the list.
+					if !stringInSlice(fileId, fileIds) {
+						fldIndexes[fldName][fldValue] = append(fileIds, fileId)
+					}
+				} else {
+					// Otherwise, add the field value with associated new file id to the
+					// index.
+					fldIndexes[fldName][fldValue] = []string{fileId}
 				}
-			} else {
-				// Otherwise, add the field value with associated new file id to the
-				// index.
-				db.fldIndexes[tblName][fldName][fldValue] = []string{fileId}
 			}
 		}
 	}
 
-	return nil
+	return fldIndexes, nil
 }
 
-// initTagsIndex initializes all tag indexes for a database.
-func (db *DB) initTagsIndex(tblName string) error {
-	var rec map[string]interface{}
+// initTagsIndex builds the tag index for a table. It stops with the context's
+// error as soon as the context is done. It returns the index and any error
+// encountered.
+func (db *DB) initTagsIndex(ctx context.Context, tblName string) (map[string][]string, error) {
 	tagIndex := make(map[string][]string)
 
-	// Delete all the entries in the index.
-	for k := range db.tagIndexes[tblName] {
-		delete(db.tagIndexes[tblName], k)
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
 	}
 
 	// For every file in the data dir...
-	for _, fileId := range db.fileIdsInDataDir(tblName) {
-		filename := db.filePath(tblName, fileId)
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		data, err := ioutil.ReadFile(filename)
+		data, err := db.readRec(tblName, fileId)
+		if errors.Is(err, ErrCorrupt) {
+			// Corrupt records are left out of the indexes; Verify reports them.
+			db.logger.Warn("ivy: corrupt record left out of the indexes", "table", tblName, "id", fileId, "err", err)
+			continue
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		err = json.Unmarshal(data, &rec)
+		rec, err := db.decodeFields(data)
 		if err != nil {
-			return err
+			db.logger.Warn("ivy: corrupt record left out of the indexes", "table", tblName, "id", fileId, "err", err)
+			continue
 		}
 
-		// Convert back into a slice.
-		tags := rec["tags"].([]interface{})
-
 		// For every tag in the answer...
-		for _, t := range tags {
-			// Convert tag back into a string
-			tag := t.(string)
-
+		for _, tag := range tagKeys(rec["tags"]) {
 			// If the tag already exists as a key in the index...
 			if fileIds, ok := tagIndex[tag]; ok {
 				// Add the file id to the list of ids for that tag, if it is not already
@@ -461,26 +2035,89 @@ func (db *DB) initTagsIndex(tblName string) error {
 		}
 	}
 
-	db.tagIndexes[tblName] = tagIndex
-
-	return nil
+	return tagIndex, nil
 }
 
-// initTblIndexes initializes all indexes for a table.
-func (db *DB) initTblIndexes(tblName string) error {
-	if fldNames, ok := db.fieldsToIndex[tblName]; ok {
-		err := db.initNonTagsIndexes(tblName)
+// initTblIndexes rebuilds all indexes for a table. If the context is done
+// before the rebuild is finished, the previous indexes are kept and the
+// context's error is returned.
+func (db *DB) initTblIndexes(ctx context.Context, tblName string) error {
+	if fldNames, ok := db.indexFields(tblName); ok {
+		start := time.Now()
+
+		fldIndexes, err := db.initNonTagsIndexes(ctx, tblName)
 		if err != nil {
 			return err
 		}
 
+		var tagIndex map[string][]string
+
 		if stringInSlice("tags", fldNames) {
-			db.initTagsIndex(tblName)
+			tagIndex, err = db.initTagsIndex(ctx, tblName)
 			if err != nil {
 				return err
 			}
 		}
+
+		db.setIndexes(tblName, tagIndex, fldIndexes)
+
+		db.metrics.observeRebuild(tblName, time.Since(start))
+
+		db.logger.Debug("ivy: indexes rebuilt", "table", tblName, "fields", fldNames, "duration", time.Since(start))
+	}
+	return nil
+}
+
+// updateTblIndexes updates all indexes for a table after a single record has
+// been created, updated, or deleted, instead of rebuilding them from scratch.
+// It takes the marshalled record before and after the change; either may be
+// nil.
+func (db *DB) updateTblIndexes(tblName string, fileId string, oldData []byte, newData []byte) error {
+	fldNames, ok := db.indexFields(tblName)
+	if !ok {
+		return nil
+	}
+
+	tagIndex, fldIndexes := db.tagIndex(tblName), db.fldIndex(tblName)
+
+	if oldData != nil {
+		rec, err := db.decodeFields(oldData)
+		if err != nil {
+			return err
+		}
+
+		for _, fldName := range fldNames {
+			if fldName == "tags" {
+				for _, tag := range tagKeys(rec["tags"]) {
+					removeIdFromIndex(tagIndex, tag, fileId)
+				}
+			} else {
+				for _, key := range db.indexKeys(tblName, rec, fldName) {
+					removeIdFromIndex(fldIndexes[fldName], key, fileId)
+				}
+			}
+		}
+	}
+
+	if newData != nil {
+		rec, err := db.decodeFields(newData)
+		if err != nil {
+			return err
+		}
+
+		for _, fldName := range fldNames {
+			if fldName == "tags" {
+				for _, tag := range tagKeys(rec["tags"]) {
+					addIdToIndex(tagIndex, tag, fileId)
+				}
+			} else {
+				for _, key := range db.indexKeys(tblName, rec, fldName) {
+					addIdToIndex(fldIndexes[fldName], key, fileId)
+				}
+			}
+		}
 	}
+
 	return nil
 }
 
@@ -490,7 +2127,12 @@ func (db *DB) nextAvailableFileId(tblName string) (string, error) {
 	var fileIds []int
 	var nextFileId string
 
-	for _, f := range db.fileIdsInDataDir(tblName) {
+	ids, err := db.engine.ids(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range ids {
 		fileId, err := strconv.Atoi(f)
 		if err != nil {
 			return "", err
@@ -513,11 +2155,11 @@ func (db *DB) nextAvailableFileId(tblName string) (string, error) {
 
 // performChecks does validation checks on a database config.
 func (db *DB) performChecks() error {
-	if _, err := os.Stat(db.path); os.IsNotExist(err) {
+	if err := db.engine.checkDB(); os.IsNotExist(err) {
 		return err
 	}
 	for tbl := range db.fieldsToIndex {
-		if _, err := os.Stat(db.tblPath(tbl)); os.IsNotExist(err) {
+		if err := db.engine.checkTable(tbl); err != nil {
 			return err
 		}
 	}
@@ -525,15 +2167,137 @@ func (db *DB) performChecks() error {
 	return nil
 }
 
-// tblPath returns the file path for a table directory.
-func (db *DB) tblPath(tblName string) string {
-	return path.Join(db.path, tblName)
-}
-
 //=============================================================================
 // Helper Functions
 //=============================================================================
 
+// addIdToIndex adds a record id to the list of ids for a key in an index, if
+// it is not already in the list.
+func addIdToIndex(index map[string][]string, key string, fileId string) {
+	if fileIds, ok := index[key]; ok {
+		if !stringInSlice(fileId, fileIds) {
+			index[key] = append(fileIds, fileId)
+		}
+	} else {
+		index[key] = []string{fileId}
+	}
+}
+
+// fieldKey returns the index key of a field value: a string itself, or a
+// number or boolean as it is written in JSON. It returns false for null,
+// missing fields, arrays and objects, which are not indexed.
+func fieldKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return numberKey(v.String(), true)
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+
+	return "", false
+}
+
+// fieldMatches answers whether a field value matches a search value, as
+// indexLookup finds it in an index: if its index key is the search value or,
+// for a search value holding a number, the number as written in JSON, with
+// every digit of an integer if exact is set. Null and missing fields match
+// nothing. It returns an error for arrays and objects.
+func fieldMatches(value interface{}, searchValue string, exact bool) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+
+	key, ok := fieldKey(value)
+	if !ok {
+		return false, ErrIncomparable
+	}
+
+	if key == searchValue {
+		return true, nil
+	}
+
+	numKey, ok := numberKey(searchValue, exact)
+
+	return ok && key == numKey, nil
+}
+
+// indexLookup returns the ids of the records whose field matches a search
+// value in the index of the field, keeping every digit of an integer search
+// value if exact is set.
+func indexLookup(fldIndex map[string][]string, searchValue string, exact bool) []string {
+	ids := fldIndex[searchValue]
+
+	// Numbers are indexed as they are written in JSON, which may not be how
+	// they are searched for, such as 437 for "437.0".
+	if key, ok := numberKey(searchValue, exact); ok && key != searchValue && len(fldIndex[key]) > 0 {
+		// The slice of the index is copied rather than appended to.
+		ids = append([]string(nil), ids...)
+
+		for _, fileId := range fldIndex[key] {
+			if !stringInSlice(fileId, ids) {
+				ids = append(ids, fileId)
+			}
+		}
+	}
+
+	return ids
+}
+
+// isEmptyValue reports whether a decoded field value is null, an empty string,
+// an empty array or an empty object.
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+
+	return false
+}
+
+// numberKey returns the index key of the number a string holds, if it holds
+// one. If exact is set, integers keep every digit; other numbers are written
+// as the closest float64.
+func numberKey(s string, exact bool) (string, bool) {
+	if i, ok := new(big.Int).SetString(s, 10); ok && exact {
+		return i.String(), true
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", false
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// removeIdFromIndex removes a record id from the list of ids for a key in an
+// index, dropping the key once no ids are left.
+func removeIdFromIndex(index map[string][]string, key string, fileId string) {
+	var fileIds []string
+
+	for _, id := range index[key] {
+		if id != fileId {
+			fileIds = append(fileIds, id)
+		}
+	}
+
+	if len(fileIds) == 0 {
+		delete(index, key)
+	} else {
+		index[key] = fileIds
+	}
+}
+
 // stringInSlice answers whether a string exists in a slice.
 func stringInSlice(s string, list []string) bool {
 	for _, x := range list {
@@ -543,3 +2307,19 @@ func stringInSlice(s string, list []string) bool {
 	}
 	return false
 }
+
+// tagKeys returns the index keys of the tags of a record, leaving out tags
+// that are not strings, numbers or booleans. It returns nil if the tags are
+// not an array.
+func tagKeys(value interface{}) []string {
+	tags, _ := value.([]interface{})
+
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if key, ok := fieldKey(tag); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,156 @@
+package ivy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ErrClosed is returned by every operation on a database after Close.
+var ErrClosed = errors.New("ivy: database is closed")
+
+// ErrLocked is returned by OpenDB when another live process has the database
+// open.
+var ErrLocked = errors.New("ivy: database is locked by another process")
+
+// ErrNotFound is wrapped by the error returned when a record does not exist,
+// such as by Find and Delete. The error also wraps the error of the storage
+// engine, so errors.Is(err, fs.ErrNotExist) holds as well.
+var ErrNotFound = errors.New("ivy: record not found")
+
+// ErrTableNotFound is wrapped by the error returned when a table does not
+// exist.
+var ErrTableNotFound = errors.New("ivy: table does not exist")
+
+// ErrInvalidID is wrapped by the error returned when a record id is not a
+// number.
+var ErrInvalidID = errors.New("ivy: invalid record id")
+
+// ErrConflict is wrapped by the error returned when something to be created
+// already exists, such as a table.
+var ErrConflict = errors.New("ivy: conflict")
+
+// ErrIncomparable is wrapped by the FieldTypeError returned when a field
+// search meets a field value that cannot be compared with the search value.
+var ErrIncomparable = errors.New("ivy: field value cannot be compared")
+
+// ErrCorrupt is returned when a record fails checksum verification or cannot
+// be decoded at all.
+var ErrCorrupt = errors.New("ivy: record is corrupt")
+
+// ErrRecordTooLarge is returned when a marshalled record is larger than
+// Options.MaxRecordSize.
+var ErrRecordTooLarge = errors.New("ivy: record too large")
+
+// ErrQuotaExceeded is wrapped by the QuotaError returned when a new record
+// does not fit into its table's quota.
+var ErrQuotaExceeded = errors.New("ivy: quota exceeded")
+
+// ErrReplicationGap is returned when a replication follower asks for changes
+// that are no longer in the primary's write-ahead log. The follower has to be
+// seeded again from a backup.
+var ErrReplicationGap = errors.New("ivy: changes are no longer in the write-ahead log")
+
+// ErrFollower is returned by Create, Update and Delete on a database that is
+// following a primary.
+var ErrFollower = errors.New("ivy: database is a replication follower")
+
+// ErrReadOnly is returned by Create, Update and Delete on a database opened
+// read-only.
+var ErrReadOnly = errors.New("ivy: database is read-only")
+
+// ErrImmutable is wrapped by the error returned when a record of an event
+// table would be changed or deleted; see Options.EventTables.
+var ErrImmutable = errors.New("ivy: record is immutable")
+
+// ErrForbidden is wrapped, together with the error of the authorizer, by the
+// error returned when Options.Authorizer refuses an operation.
+var ErrForbidden = errors.New("ivy: operation not permitted")
+
+// ErrWebhookQueueFull is passed to Webhook.OnError for the events dropped
+// because too many were waiting to be delivered.
+var ErrWebhookQueueFull = errors.New("ivy: webhook queue is full")
+
+// ErrWatchLagged is returned by Watcher.Err when a watcher was closed because
+// its receiver fell too far behind the changes.
+var ErrWatchLagged = errors.New("ivy: watcher fell too far behind")
+
+// ErrBadKey is returned by OpenDB when an encrypted database is opened with
+// the wrong key, or without one; see Options.EncryptionKey.
+var ErrBadKey = errors.New("ivy: wrong encryption key")
+
+// ErrTampered is wrapped by the error returned by DB.VerifyAuditChain when the
+// audit log or the records were changed behind the database's back.
+var ErrTampered = errors.New("ivy: audit chain is broken")
+
+// ErrConfigMismatch is wrapped by the error returned by OpenDB when its
+// options disagree with the settings kept in the database's config file; see
+// DBConfig.
+var ErrConfigMismatch = errors.New("ivy: options do not match the database's config")
+
+// Type FieldTypeError is the error returned by FindAllIdsForField and
+// FindFirstIdForField when a record holds an array or an object in the field
+// searched, which cannot be compared with a string, and by
+// FindAllIdsForFieldContains when a record holds anything but an array. It
+// wraps ErrIncomparable.
+type FieldTypeError struct {
+	Table string
+	Id    string
+	Field string
+	Value interface{}
+}
+
+func (e *FieldTypeError) Error() string {
+	return fmt.Sprintf("%v: %s/%s field %s holds %T", ErrIncomparable, e.Table, e.Id, e.Field, e.Value)
+}
+
+func (e *FieldTypeError) Unwrap() error {
+	return ErrIncomparable
+}
+
+// notFoundError is the error returned for a missing record. It wraps
+// ErrNotFound and the error of the storage engine.
+type notFoundError struct {
+	tblName string
+	fileId  string
+	err     error
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%v: %s/%s", ErrNotFound, e.tblName, e.fileId)
+}
+
+func (e *notFoundError) Unwrap() []error {
+	return []error{ErrNotFound, e.err}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// recErr returns err, wrapped in an error satisfying errors.Is(err,
+// ErrNotFound) if it says that a record does not exist.
+func recErr(tblName string, fileId string, err error) error {
+	if os.IsNotExist(err) {
+		return &notFoundError{tblName: tblName, fileId: fileId, err: err}
+	}
+
+	return err
+}
+
+// tableNotFoundErr returns an error wrapping ErrTableNotFound that names the
+// table.
+func tableNotFoundErr(tblName string) error {
+	return fmt.Errorf("%w: %s", ErrTableNotFound, tblName)
+}
+
+// checkId returns an error wrapping ErrInvalidID if a record id is not a
+// number.
+func checkId(fileId string) error {
+	if _, err := strconv.Atoi(fileId); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidID, fileId)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Type Event is a struct holding a record read by DB.EventsSince. Seq is the
+// record's id as a number.
+type Event struct {
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// EventsSince returns the records of a table with ids greater than a sequence
+// number, in id order, such as to read the events of an event table that a
+// consumer has not handled yet; see Options.EventTables. It takes a table
+// name, the sequence number of the last event already read, or zero to read
+// from the start, and the largest number of events to return, where zero
+// means no limit. The events are read as Find reads records, with their
+// encrypted fields opened and the fields of the read policy masked. It
+// returns the events and any error encountered.
+func (db *DB) EventsSince(tblName string, since uint64, limit int) ([]Event, error) {
+	return db.EventsSinceCtx(context.Background(), tblName, since, limit)
+}
+
+// EventsSinceCtx is EventsSince with a context. It stops reading records with
+// the context's error as soon as the context is done.
+func (db *DB) EventsSinceCtx(ctx context.Context, tblName string, since uint64, limit int) (_ []Event, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "find")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+
+	for _, fileId := range fileIds {
+		seq, err := strconv.ParseUint(fileId, 10, 64)
+		if err == nil && seq > since {
+			seqs = append(seqs, seq)
+		}
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	if limit > 0 && len(seqs) > limit {
+		seqs = seqs[:limit]
+	}
+
+	events := make([]Event, 0, len(seqs))
+
+	for _, seq := range seqs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		fileId := strconv.FormatUint(seq, 10)
+
+		db.metrics.countOp(tblName, "find")
+
+		data, err := db.readRec(tblName, fileId)
+		if err == nil {
+			data, err = db.plainRec(tblName, fileId, data)
+		}
+		if err != nil {
+			return nil, recErr(tblName, fileId, err)
+		}
+
+		events = append(events, Event{Seq: seq, Data: data})
+	}
+
+	return events, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkMutable returns an error wrapping ErrImmutable if a table is an event
+// table, whose records cannot be changed or deleted.
+func (db *DB) checkMutable(tblName string) error {
+	if db.eventTables[tblName] {
+		return fmt.Errorf("%w: %s is an event table", ErrImmutable, tblName)
+	}
+
+	return nil
+}
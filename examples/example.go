@@ -35,7 +35,7 @@ func main() {
 	//
 	// Open DB
 	//
-	db, err := ivy.OpenDB("data", fieldsToIndex)
+	db, err := ivy.OpenDB("data", ivy.WithIndexes(fieldsToIndex))
 	if err != nil {
 		fmt.Println("Failed to open database:", err)
 		os.Exit(1)
@@ -0,0 +1,115 @@
+package ivy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type QueryPlan is a struct describing how a query will run, as returned by
+// DB.Explain. Index is "field" if the records that can match are found in a
+// field index, "tags" if they are found in the tag index, and "none" if every
+// record of the table must be read; Field is the indexed field used. Scanned
+// is the number of records that will be read and checked against the
+// condition, out of the Records of the table. Sort is true if the query has
+// an ORDER BY clause, which means every matching record is decoded and held
+// in memory until they are sorted, before LIMIT and OFFSET apply; otherwise
+// only the ids of the matches are kept.
+type QueryPlan struct {
+	Index   string   `json:"index"`
+	Field   string   `json:"field,omitempty"`
+	Scanned int      `json:"scanned"`
+	Records int      `json:"records"`
+	Sort    bool     `json:"sort"`
+	OrderBy []string `json:"order_by,omitempty"`
+}
+
+// String returns a description of the plan on a few lines.
+func (p *QueryPlan) String() string {
+	var b strings.Builder
+
+	switch p.Index {
+	case "none":
+		fmt.Fprintf(&b, "index: none, full table scan\n")
+	case "tags":
+		fmt.Fprintf(&b, "index: tags\n")
+	default:
+		fmt.Fprintf(&b, "index: field %s\n", p.Field)
+	}
+
+	fmt.Fprintf(&b, "records scanned: %d of %d\n", p.Scanned, p.Records)
+
+	if p.Sort {
+		fmt.Fprintf(&b, "sort: in memory by %s, all matches held\n", strings.Join(p.OrderBy, ", "))
+	} else {
+		fmt.Fprintf(&b, "sort: by id\n")
+	}
+
+	return b.String()
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Explain describes how QueryString would run a query, without reading any
+// record, so that queries that fall back to reading the whole table can be
+// spotted. Placeholders are bound to args as in QueryString. It takes a table
+// name, the query and the arguments. It returns the plan of the query and any
+// error encountered.
+func (db *DB) Explain(tblName string, queryStr string, args ...interface{}) (*QueryPlan, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	q, err := parseQuery(queryStr, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.explainQuery(tblName, q)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// explainQuery returns the plan of a parsed query.
+func (db *DB) explainQuery(tblName string, q *query) (*QueryPlan, error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &QueryPlan{Index: "none", Scanned: len(fileIds), Records: len(fileIds), Sort: len(q.order) > 0}
+
+	candidates, fldName := db.indexCandidates(tblName, q.where)
+	switch fldName {
+	case "":
+	case "tags":
+		plan.Index = "tags"
+		plan.Scanned = len(candidates)
+	default:
+		plan.Index = "field"
+		plan.Field = fldName
+		plan.Scanned = len(candidates)
+	}
+
+	for _, order := range q.order {
+		if order.desc {
+			plan.OrderBy = append(plan.OrderBy, order.field+" DESC")
+		} else {
+			plan.OrderBy = append(plan.OrderBy, order.field)
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,531 @@
+package ivy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Type IdCollision decides what ImportTable does with a record whose id is
+// already taken in the table.
+type IdCollision int
+
+const (
+	// RemapOnCollision gives the imported record a new id. It is the
+	// default.
+	RemapOnCollision IdCollision = iota
+
+	// ReplaceOnCollision overwrites the existing record.
+	ReplaceOnCollision
+
+	// SkipOnCollision leaves the existing record alone and does not import
+	// the record.
+	SkipOnCollision
+)
+
+// Type ImportOptions is a struct holding the options of DB.ImportTable.
+type ImportOptions struct {
+	// OnCollision decides what happens to records whose id is taken.
+	OnCollision IdCollision
+
+	// ForeignKeys declares the fields of the imported records that hold ids
+	// of records in other tables, keyed by field name, with the name of the
+	// referenced table as value. The ids in these fields, which may be
+	// strings, numbers or arrays of them, are rewritten using the id map of
+	// the referenced table: IdMaps for other tables, and the map of this
+	// import for references to the table itself.
+	ForeignKeys map[string]string
+
+	// IdMaps holds the id maps returned by earlier imports, keyed by table
+	// name.
+	IdMaps map[string]map[string]string
+}
+
+// exportedRec is a line of a table export.
+type exportedRec struct {
+	Id   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// ExportTable writes every record of a table to w, one JSON object per line
+// holding the record's id and data, in id order, for ImportTable to read back
+// into this or another database. It takes a table name and the writer to
+// write to. It returns any error encountered.
+func (db *DB) ExportTable(tblName string, w io.Writer) error {
+	return db.ExportTableCtx(context.Background(), tblName, w)
+}
+
+// ExportTableCtx is ExportTable with a context, which is handed to the
+// authorizer.
+func (db *DB) ExportTableCtx(ctx context.Context, tblName string, w io.Writer) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "export")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	recs, err := db.readTbl(tblName)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, fileId := range sortedIds(recs) {
+		data, err := db.redact(tblName, fileId, recs[fileId])
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(exportedRec{Id: fileId, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImportTable adds the records of a table export written by ExportTable to a
+// table, which makes it possible to merge two databases. Records keep their
+// ids unless the id is already taken, in which case OnCollision decides what
+// happens. Foreign key fields declared in the options are rewritten to the
+// new ids of the records they refer to. It takes a table name, the reader to
+// read the export from, and the import options. It returns a map from the id
+// of every imported record in the export to its id in the table, and any
+// error encountered.
+func (db *DB) ImportTable(tblName string, r io.Reader, opts ImportOptions) (map[string]string, error) {
+	return db.ImportTableCtx(context.Background(), tblName, r, opts)
+}
+
+// ImportTableCtx is ImportTable with a context, which is handed to the
+// authorizer.
+func (db *DB) ImportTableCtx(ctx context.Context, tblName string, r io.Reader, opts ImportOptions) (_ map[string]string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "import")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	var recs []exportedRec
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec exportedRec
+
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := strconv.Atoi(rec.Id); err != nil {
+			return nil, fmt.Errorf("%w %q in export of %s", ErrInvalidID, rec.Id, tblName)
+		}
+
+		recs = append(recs, rec)
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	existing, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[string]bool, len(existing))
+	next := 0
+
+	for _, fileId := range existing {
+		taken[fileId] = true
+		if n := idNum(fileId); n > next {
+			next = n
+		}
+	}
+	for _, rec := range recs {
+		if n := idNum(rec.Id); n > next {
+			next = n
+		}
+	}
+
+	// Assign every record its id first, so that references between the
+	// imported records can be rewritten.
+	idMap := make(map[string]string, len(recs))
+
+	for _, rec := range recs {
+		switch {
+		case !taken[rec.Id]:
+			idMap[rec.Id] = rec.Id
+		case opts.OnCollision == ReplaceOnCollision:
+			idMap[rec.Id] = rec.Id
+		case opts.OnCollision == RemapOnCollision:
+			next++
+			idMap[rec.Id] = strconv.Itoa(next)
+		}
+	}
+
+	for _, rec := range recs {
+		fileId, ok := idMap[rec.Id]
+		if !ok {
+			continue
+		}
+
+		data := []byte(rec.Data)
+
+		if len(opts.ForeignKeys) > 0 {
+			data, err = remapForeignKeys(data, tblName, idMap, opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = db.writeRec(ctx, tblName, fileId, data, taken[fileId])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return idMap, nil
+}
+
+// ExportJSON writes every table and record of the database to w as a single
+// JSON object, mapping table names to objects that map record ids to records.
+// Tables and records are written in order, one record per line, so that
+// exports of the same data are identical and diff well. The export is
+// streamed, and records are read one at a time, like backups. It takes the
+// writer to write to. It returns any error encountered.
+func (db *DB) ExportJSON(w io.Writer) error {
+	return db.ExportJSONCtx(context.Background(), w)
+}
+
+// ExportJSONCtx is ExportJSON with a context, which is handed to the
+// authorizer.
+func (db *DB) ExportJSONCtx(ctx context.Context, w io.Writer) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	tblNames := db.tableNames()
+
+	ops, err := db.beginOps(ctx, tblNames, "export")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("{")
+
+	for i, tblName := range tblNames {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+
+		err := db.exportTblJSON(bw, tblName)
+		if err != nil {
+			return err
+		}
+	}
+
+	bw.WriteString("\n}\n")
+
+	return bw.Flush()
+}
+
+// ImportJSON reads an export written by ExportJSON into the database, which
+// must already have every table in it. Records keep their ids, replacing
+// records with the same id. The export is read as a stream, so it does not
+// have to fit into memory. It takes the reader to read the export from. It
+// returns any error encountered.
+func (db *DB) ImportJSON(r io.Reader) error {
+	return db.ImportJSONCtx(context.Background(), r)
+}
+
+// ImportJSONCtx is ImportJSON with a context, which is handed to the
+// authorizer.
+func (db *DB) ImportJSONCtx(ctx context.Context, r io.Reader) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(r)
+
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		err = db.importTblJSON(ctx, dec, tok.(string))
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// exportTblJSON writes a table of a JSON export.
+func (db *DB) exportTblJSON(bw *bufio.Writer, tblName string) error {
+	db.tblLock(tblName).RLock()
+	fileIds, err := db.engine.ids(tblName)
+	db.tblLock(tblName).RUnlock()
+	if err != nil {
+		return err
+	}
+
+	name, _ := json.Marshal(tblName)
+
+	bw.WriteString("\n  ")
+	bw.Write(name)
+	bw.WriteString(": {")
+
+	var buf bytes.Buffer
+	first := true
+
+	for _, fileId := range sortedIdList(fileIds) {
+		data, err := db.backupRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			// The record was deleted since the ids were read.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err = db.redact(tblName, fileId, data)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			bw.WriteString(",")
+		}
+		first = false
+
+		id, _ := json.Marshal(fileId)
+
+		bw.WriteString("\n    ")
+		bw.Write(id)
+		bw.WriteString(": ")
+
+		buf.Reset()
+
+		err = json.Compact(&buf, data)
+		if err != nil {
+			return corruptErr(tblName, fileId, err.Error())
+		}
+
+		bw.Write(buf.Bytes())
+	}
+
+	if !first {
+		bw.WriteString("\n  ")
+	}
+
+	_, err = bw.WriteString("}")
+
+	return err
+}
+
+// importTblJSON reads the records of a table from a JSON export.
+func (db *DB) importTblJSON(ctx context.Context, dec *json.Decoder, tblName string) (err error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	op, err := db.beginOp(ctx, tblName, "", "import")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	err = expectDelim(dec, '{')
+	if err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		fileId := tok.(string)
+		if _, err := strconv.Atoi(fileId); err != nil {
+			return fmt.Errorf("%w %q in export of %s", ErrInvalidID, fileId, tblName)
+		}
+
+		var data json.RawMessage
+
+		err = dec.Decode(&data)
+		if err != nil {
+			return err
+		}
+
+		rwLock.Lock()
+		err = db.writeRec(ctx, tblName, fileId, data, true)
+		rwLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// expectDelim reads the next token of a JSON stream, which must be the
+// supplied delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if tok != delim {
+		return fmt.Errorf("ivy: invalid JSON export: expected %v, got %v", delim, tok)
+	}
+
+	return nil
+}
+
+// remapForeignKeys rewrites the foreign key fields of a marshalled record
+// being imported into a table, using the id map of that import for references
+// to the table itself.
+func remapForeignKeys(data []byte, tblName string, idMap map[string]string, opts ImportOptions) ([]byte, error) {
+	var fields map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+
+	for field, refTbl := range opts.ForeignKeys {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+
+		refMap := opts.IdMaps[refTbl]
+		if refTbl == tblName {
+			refMap = idMap
+		}
+
+		newValue, ok := remapId(value, refMap)
+		if ok {
+			fields[field] = newValue
+			changed = true
+		}
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	return json.Marshal(fields)
+}
+
+// remapId returns an id, or an array of ids, with every id found in idMap
+// replaced, and whether anything was replaced. Ids may be strings or numbers.
+func remapId(value interface{}, idMap map[string]string) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if newId, ok := idMap[v]; ok && newId != v {
+			return newId, true
+		}
+	case json.Number:
+		if newId, ok := idMap[v.String()]; ok && newId != v.String() {
+			return json.Number(newId), true
+		}
+	case []interface{}:
+		changed := false
+		for i, elem := range v {
+			if newElem, ok := remapId(elem, idMap); ok {
+				v[i] = newElem
+				changed = true
+			}
+		}
+		return v, changed
+	}
+
+	return value, false
+}
+
+// sortedIds returns the ids of a set of records, in numeric order.
+func sortedIds(recs map[string][]byte) []string {
+	fileIds := make([]string, 0, len(recs))
+	for fileId := range recs {
+		fileIds = append(fileIds, fileId)
+	}
+
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	return fileIds
+}
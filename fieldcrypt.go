@@ -0,0 +1,165 @@
+package ivy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sealedFieldPrefix starts the string stored in place of the value of an
+// encrypted field; see Options.EncryptedFields. The rest of the string is the
+// sealed JSON encoding of the value, in base64. Values sealed with the data
+// key of their record, rather than with the field key, have
+// recordSealedPrefix after it; see Options.RecordKeys.
+const (
+	sealedFieldPrefix  = "ivyenc:"
+	recordSealedPrefix = "rec:"
+)
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// sealFields replaces the values of the encrypted fields of a marshalled
+// record of a table with their sealed strings. Values that are sealed
+// already, such as those of a record restored from a backup, and null values
+// are left as they are.
+func (db *DB) sealFields(tblName string, fileId string, data []byte) ([]byte, error) {
+	fldNames := db.encryptedFields[tblName]
+	if len(fldNames) == 0 {
+		return data, nil
+	}
+
+	var rec map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("ivy: %s record with encrypted fields is not a JSON object: %v", tblName, err)
+	}
+
+	s, prefix := db.fieldSealer, sealedFieldPrefix
+
+	changed := false
+
+	for _, fldName := range fldNames {
+		value, ok := rec[fldName]
+		if !ok || string(value) == "null" || isSealedField(value) {
+			continue
+		}
+
+		if !changed && db.recordKeys[tblName] {
+			var err error
+
+			s, err = db.recordKey(tblName, fileId, true)
+			if err != nil {
+				return nil, err
+			}
+
+			prefix += recordSealedPrefix
+		}
+
+		sealed, err := s.seal(value, fieldContext(fldName))
+		if err != nil {
+			return nil, err
+		}
+
+		rec[fldName], err = json.Marshal(prefix + base64.StdEncoding.EncodeToString(sealed))
+		if err != nil {
+			return nil, err
+		}
+
+		changed = true
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	return json.Marshal(rec)
+}
+
+// openFields replaces the sealed strings of the encrypted fields of a
+// marshalled record of a table with their values. The values sealed with the
+// data key of a record that was shredded are replaced with null. It returns
+// an error wrapping ErrBadKey if a field cannot be decrypted.
+func (db *DB) openFields(tblName string, fileId string, data []byte) ([]byte, error) {
+	fldNames := db.encryptedFields[tblName]
+	if len(fldNames) == 0 {
+		return data, nil
+	}
+
+	var rec map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, corruptErr(tblName, fileId, err.Error())
+	}
+
+	changed := false
+
+	for _, fldName := range fldNames {
+		value, ok := rec[fldName]
+		if !ok || !isSealedField(value) {
+			continue
+		}
+
+		var str string
+		json.Unmarshal(value, &str)
+
+		str = strings.TrimPrefix(str, sealedFieldPrefix)
+
+		s := db.fieldSealer
+
+		if strings.HasPrefix(str, recordSealedPrefix) {
+			str = strings.TrimPrefix(str, recordSealedPrefix)
+
+			var err error
+
+			s, err = db.recordKey(tblName, fileId, false)
+			if err != nil {
+				return nil, err
+			}
+
+			if s == nil {
+				rec[fldName] = json.RawMessage("null")
+				changed = true
+				continue
+			}
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, corruptErr(tblName, fileId, fmt.Sprintf("field %s: %v", fldName, err))
+		}
+
+		plain, err := s.open(sealed, fieldContext(fldName))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s/%s field %s: %v", ErrBadKey, tblName, fileId, fldName, err)
+		}
+
+		rec[fldName] = plain
+		changed = true
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	return json.Marshal(rec)
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// isSealedField reports whether a JSON value is the sealed string of an
+// encrypted field.
+func isSealedField(value json.RawMessage) bool {
+	return strings.HasPrefix(string(value), `"`+sealedFieldPrefix)
+}
+
+// fieldContext returns the context the value of an encrypted field is sealed
+// with. It leaves out the table, so that records keep their values when they
+// are copied to another table.
+func fieldContext(fldName string) string {
+	return "fields/" + fldName
+}
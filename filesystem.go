@@ -0,0 +1,192 @@
+package ivy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tmpMarker is part of the name of every temporary file written by
+// writeFileAtomic.
+const tmpMarker = ".tmp-"
+
+// Type FileSystem is an interface that abstracts all file access done by the
+// database. Paths passed to its methods are built by the Layout from the
+// database path using path/filepath, so implementations that are not backed by
+// the local disk should convert them with filepath.ToSlash. The default
+// implementation uses the local disk; S3FileSystem stores everything in an
+// S3-compatible object store instead.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Remove(name string) error
+	ReadDir(name string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Rename(oldName string, newName string) error
+}
+
+// atomicWriter is implemented by file systems whose WriteFile never leaves a
+// partially written file behind, such as object stores. writeFileAtomic writes
+// to them directly instead of going through a temporary file.
+type atomicWriter interface {
+	atomicWrites() bool
+}
+
+// osFileSystem is the default FileSystem, backed by the local disk. If exact
+// is set, the files and directories it creates get the mode they are created
+// with regardless of the process's umask.
+type osFileSystem struct {
+	exact bool
+}
+
+// fileModes holds the modes the files and directories of a database are
+// created with; see Options.FileMode.
+type fileModes struct {
+	file  os.FileMode
+	dir   os.FileMode
+	exact bool
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (fs osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	err := ioutil.WriteFile(name, data, perm)
+	if err != nil || !fs.exact {
+		return err
+	}
+
+	return os.Chmod(name, perm)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (fs osFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	if !fs.exact {
+		return os.MkdirAll(name, perm)
+	}
+
+	// Only the directories created here are changed, not those that existed.
+	var missing []string
+	for dir := filepath.Clean(name); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		missing = append(missing, dir)
+	}
+
+	err := os.MkdirAll(name, perm)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range missing {
+		if err := os.Chmod(dir, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (osFileSystem) Rename(oldName string, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// newFileModes returns the modes files and directories are created with,
+// 0600 and 0700 unless the options say otherwise.
+func newFileModes(opts Options) fileModes {
+	m := fileModes{file: opts.FileMode, dir: opts.DirMode, exact: opts.ExactModes}
+
+	if m.file == 0 {
+		m.file = 0600
+	}
+	if m.dir == 0 {
+		m.dir = 0700
+	}
+
+	return m
+}
+
+// fs returns the local file system, creating files and directories with
+// their exact modes if m.exact is set.
+func (m fileModes) fs() osFileSystem {
+	return osFileSystem{exact: m.exact}
+}
+
+// mkdirAll creates a directory of the local file system, along with any
+// missing parents, with the directory mode.
+func (m fileModes) mkdirAll(name string) error {
+	return m.fs().MkdirAll(name, m.dir)
+}
+
+// openFile opens a file of the local file system, creating it with the file
+// mode if flag includes os.O_CREATE.
+func (m fileModes) openFile(name string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(name, flag, m.file)
+	if err != nil || !m.exact || flag&os.O_CREATE == 0 {
+		return f, err
+	}
+
+	if err := f.Chmod(m.file); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// writeFileAtomic writes data to a temporary file next to name and then
+// renames it into place, so that name never holds a partially written file,
+// even if the program crashes halfway through.
+func writeFileAtomic(fs FileSystem, name string, data []byte, perm os.FileMode) error {
+	if aw, ok := fs.(atomicWriter); ok && aw.atomicWrites() {
+		return fs.WriteFile(name, data, perm)
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	tmpName := name + tmpMarker + hex.EncodeToString(suffix)
+
+	err := fs.WriteFile(tmpName, data, perm)
+	if err != nil {
+		fs.Remove(tmpName)
+		return err
+	}
+
+	err = fs.Rename(tmpName, name)
+	if err != nil {
+		fs.Remove(tmpName)
+		return err
+	}
+
+	return nil
+}
+
+// isTempFile answers whether a file name belongs to a temporary file written
+// by writeFileAtomic.
+func isTempFile(name string) bool {
+	return strings.Contains(name, tmpMarker)
+}
@@ -0,0 +1,242 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileWatch holds the size and modification time of every record file of
+// the database as last seen, to tell the files edited by other programs; see
+// Options.WatchFiles.
+type fileWatch struct {
+	mu     sync.Mutex
+	stamps map[string]map[string]fileStamp
+}
+
+// fileStamp is the size and modification time of a record file.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// seen records the stamp of a record file written or removed by the database
+// itself, so that the write is not taken for an edit. A zero stamp is a file
+// that was removed.
+func (w *fileWatch) seen(tblName string, fileId string, stamp fileStamp) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stamps[tblName] == nil {
+		w.stamps[tblName] = make(map[string]fileStamp)
+	}
+
+	if stamp == (fileStamp{}) {
+		delete(w.stamps[tblName], fileId)
+	} else {
+		w.stamps[tblName][fileId] = stamp
+	}
+}
+
+// diff replaces the stamps of a table with those found in storage. It
+// returns the ids of the records whose files changed or appeared, and of
+// those whose files are gone.
+func (w *fileWatch) diff(tblName string, stamps map[string]fileStamp) ([]string, []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old, ok := w.stamps[tblName]
+	w.stamps[tblName] = stamps
+
+	// A table seen for the first time only sets the stamps to compare with.
+	if !ok {
+		return nil, nil
+	}
+
+	var changed, removed []string
+
+	for fileId, stamp := range stamps {
+		if prev, ok := old[fileId]; !ok || prev != stamp {
+			changed = append(changed, fileId)
+		}
+	}
+
+	for fileId := range old {
+		if _, ok := stamps[fileId]; !ok {
+			removed = append(removed, fileId)
+		}
+	}
+
+	return sortedIdList(changed), sortedIdList(removed)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// startFileWatch takes the stamps of every record file and starts polling
+// the tables for edits made by other programs, if Options.WatchFiles is set.
+func (db *DB) startFileWatch(interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	if _, ok := db.fileEngine(); !ok || db.opts.WriteBehind != nil {
+		return errors.New("ivy: watching files requires file storage without write-behind")
+	}
+
+	db.files = &fileWatch{stamps: make(map[string]map[string]fileStamp)}
+
+	for _, tblName := range db.tableNames() {
+		if _, _, err := db.pollTbl(tblName); err != nil {
+			return err
+		}
+	}
+
+	db.jobsWg.Add(1)
+
+	go func() {
+		defer db.jobsWg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-db.jobsCtx.Done():
+				return
+			case <-ticker.C:
+				db.pollFiles(db.jobsCtx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// pollFiles looks for the record files of every table edited by other
+// programs since the last poll, and refreshes the database to match them.
+func (db *DB) pollFiles(ctx context.Context) {
+	if err := db.enter(); err != nil {
+		return
+	}
+	defer db.leave()
+
+	for _, tblName := range db.tableNames() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		changed, removed, err := db.pollTbl(tblName)
+		if err != nil {
+			db.logger.Error("ivy: watching files failed", "table", tblName, "err", err)
+			continue
+		}
+
+		if n := len(changed) + len(removed); n > 0 {
+			db.logger.Info("ivy: records edited on disk", "table", tblName, "changed", len(changed), "removed", len(removed))
+		}
+	}
+}
+
+// pollTbl compares the record files of a table with their stamps, and
+// refreshes the indexes, pinned records and quota usage of the records whose
+// files changed. The table is write-locked meanwhile. It returns the ids of
+// the records changed and removed.
+func (db *DB) pollTbl(tblName string) ([]string, []string, error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, nil, nil
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	e, _ := db.fileEngine()
+
+	stamps, err := e.stamps(tblName)
+	if os.IsNotExist(err) {
+		stamps, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed, removed := db.files.diff(tblName, stamps)
+
+	for _, fileId := range removed {
+		db.pins.drop(tblName, fileId)
+		db.unindexRec(tblName, fileId)
+		db.trackUsage(tblName, fileId, -1)
+	}
+
+	for _, fileId := range changed {
+		db.pins.drop(tblName, fileId)
+		db.unindexRec(tblName, fileId)
+
+		data, err := db.readRec(tblName, fileId)
+		if err == nil {
+			db.trackUsage(tblName, fileId, stamps[fileId].size)
+			err = db.updateTblIndexes(tblName, fileId, nil, data)
+		}
+		if err != nil {
+			// An editor may be halfway through saving the file; the
+			// record is indexed once it is saved again.
+			db.logger.Warn("ivy: edited record left out of the indexes", "table", tblName, "id", fileId, "err", err)
+		}
+	}
+
+	if len(changed) > 0 || len(removed) > 0 {
+		db.bumpGeneration(tblName)
+	}
+
+	return changed, removed, nil
+}
+
+// watchWrite records the stamp of a record file the database wrote or
+// removed, if files are watched. The caller must hold the table's write lock.
+func (db *DB) watchWrite(tblName string, fileId string) {
+	if db.files == nil {
+		return
+	}
+
+	e, _ := db.fileEngine()
+
+	stamp, err := e.stampOf(tblName, fileId)
+	if err != nil {
+		stamp = fileStamp{}
+	}
+
+	db.files.seen(tblName, fileId, stamp)
+}
+
+// unindexRec removes a record from every index of its table, without knowing
+// what the record held. The caller must hold the table's write lock.
+func (db *DB) unindexRec(tblName string, fileId string) {
+	if tagIndex := db.tagIndex(tblName); tagIndex != nil {
+		for tag := range tagIndex {
+			removeIdFromIndex(tagIndex, tag, fileId)
+		}
+	}
+
+	for _, fldIndex := range db.fldIndex(tblName) {
+		for key := range fldIndex {
+			removeIdFromIndex(fldIndex, key, fileId)
+		}
+	}
+}
+
+// fileEngine returns the file storage engine beneath any encryption, and
+// whether the database uses one.
+func (db *DB) fileEngine() (*fileEngine, bool) {
+	e := db.engine
+	if c, ok := e.(*cryptEngine); ok {
+		e = c.engine
+	}
+
+	fe, ok := e.(*fileEngine)
+
+	return fe, ok
+}
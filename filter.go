@@ -0,0 +1,418 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type M is a map holding a filter for DB.Filter, or the operators applied to
+// a field of one.
+type M map[string]interface{}
+
+// qlIn holds if a field equals any of the supplied values. The values are
+// given by a Param instead until it is bound.
+type qlIn struct {
+	field  string
+	path   []string
+	values []interface{}
+	param  Param
+}
+
+func (e *qlIn) eval(rec map[string]interface{}) bool {
+	fldValue := pathValue(rec, e.path)
+
+	for _, value := range e.values {
+		if c, ok := compareValues(fldValue, value); ok && c == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// qlExists holds if a record has a field, even one holding null.
+type qlExists struct {
+	field string
+	path  []string
+}
+
+func (e *qlExists) eval(rec map[string]interface{}) bool {
+	for _, name := range e.path[:len(e.path)-1] {
+		obj, ok := rec[name].(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		rec = obj
+	}
+
+	_, ok := rec[e.path[len(e.path)-1]]
+	return ok
+}
+
+// filterOps maps the comparison operators of filters to those of the query
+// language.
+var filterOps = map[string]string{
+	"$eq":  "=",
+	"$ne":  "!=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Filter returns the ids of the records of a table matching a filter, written
+// as a map in the style of MongoDB:
+//
+//	db.Filter("planes", ivy.M{
+//		"speed":      ivy.M{"$gte": 300},
+//		"enginetype": ivy.M{"$in": []string{"radial", "inline"}},
+//	})
+//
+// Every field of the filter, which may be a path into nested objects such as
+// "maker.country", has to match. A field matches a plain value if it equals
+// it, or a map of operators if it meets all of them: $eq, $ne, $gt, $gte,
+// $lt, $lte, $in and $nin with a slice of values, $exists with a bool, and
+// $not with a map of operators. "$and" and "$or" take a slice of filters.
+// Values are strings, numbers, bools or nil, and compare as in QueryString,
+// as does a missing field; a Param is only allowed in DB.Prepare. A field
+// indexed and compared for equality, or with $in, is answered from the index.
+// It takes a table name and the filter. It returns a slice of record ids, in
+// id order, and any error encountered.
+func (db *DB) Filter(tblName string, filter M) ([]string, error) {
+	return db.FilterCtx(context.Background(), tblName, filter)
+}
+
+// FilterCtx is Filter with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (db *DB) FilterCtx(ctx context.Context, tblName string, filter M) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "filter")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := filterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.runQuery(ctx, tblName, &query{where: where, limit: -1})
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// filterExpr returns the condition of a filter that may not hold a Param,
+// which is nil if the filter is empty.
+func filterExpr(filter M) (qlExpr, error) {
+	where, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return bindExpr(where, func(param Param) (interface{}, error) {
+		return nil, fmt.Errorf("ivy: parameter %q is not bound", string(param))
+	})
+}
+
+// compileFilter returns the condition of a filter, which is nil if the filter
+// is empty. Fields are taken in sorted order, so that the same filter always
+// compiles to the same condition.
+func compileFilter(filter map[string]interface{}) (qlExpr, error) {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var where qlExpr
+
+	for _, key := range keys {
+		var expr qlExpr
+		var err error
+
+		switch key {
+		case "$and", "$or":
+			expr, err = compileFilterList(key, filter[key])
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, fmt.Errorf("ivy: invalid filter: unknown operator %q", key)
+			}
+
+			expr, err = compileFilterField(key, filter[key])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		where = andExpr(where, expr)
+	}
+
+	return where, nil
+}
+
+// compileFilterList returns the condition of the filters of an $and or $or.
+func compileFilterList(key string, value interface{}) (qlExpr, error) {
+	filters, ok := filterList(value)
+	if !ok || len(filters) == 0 {
+		return nil, fmt.Errorf("ivy: invalid filter: %s takes a non-empty slice of filters", key)
+	}
+
+	var where qlExpr
+
+	for _, filter := range filters {
+		expr, err := compileFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case expr == nil && key == "$or":
+			// An empty filter matches every record.
+			return nil, nil
+		case expr == nil:
+		case where == nil:
+			where = expr
+		case key == "$and":
+			where = &qlAnd{left: where, right: expr}
+		default:
+			where = &qlOr{left: where, right: expr}
+		}
+	}
+
+	return where, nil
+}
+
+// compileFilterField returns the condition of a field of a filter, which holds
+// a plain value or a map of operators.
+func compileFilterField(field string, value interface{}) (qlExpr, error) {
+	ops, ok := filterMap(value)
+	if !ok {
+		v, err := filterValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return newCompare(field, "=", v), nil
+	}
+
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var where qlExpr
+
+	for _, name := range names {
+		var expr qlExpr
+
+		switch name {
+		case "$in", "$nin":
+			in := &qlIn{field: field, path: strings.Split(field, ".")}
+
+			if param, ok := ops[name].(Param); ok {
+				in.param = param
+			} else {
+				values, err := filterValues(field, name, ops[name])
+				if err != nil {
+					return nil, err
+				}
+				in.values = values
+			}
+
+			expr = in
+			if name == "$nin" {
+				expr = &qlNot{expr: expr}
+			}
+		case "$exists":
+			exists, ok := ops[name].(bool)
+			if !ok {
+				return nil, fmt.Errorf("ivy: invalid filter: $exists of %s takes a bool", field)
+			}
+
+			expr = &qlExists{field: field, path: strings.Split(field, ".")}
+			if !exists {
+				expr = &qlNot{expr: expr}
+			}
+		case "$not":
+			if _, ok := filterMap(ops[name]); !ok {
+				return nil, fmt.Errorf("ivy: invalid filter: $not of %s takes a map of operators", field)
+			}
+
+			not, err := compileFilterField(field, ops[name])
+			if err != nil {
+				return nil, err
+			}
+
+			expr = &qlNot{expr: not}
+		default:
+			op, ok := filterOps[name]
+			if !ok {
+				return nil, fmt.Errorf("ivy: invalid filter: unknown operator %q for %s", name, field)
+			}
+
+			v, err := filterValue(field, ops[name])
+			if err != nil {
+				return nil, err
+			}
+
+			expr = newCompare(field, op, v)
+		}
+
+		where = andExpr(where, expr)
+	}
+
+	if where == nil {
+		return nil, fmt.Errorf("ivy: invalid filter: no operators for %s", field)
+	}
+
+	return where, nil
+}
+
+// andExpr joins two conditions with AND, either of which may be nil.
+func andExpr(left qlExpr, right qlExpr) qlExpr {
+	if left == nil {
+		return right
+	}
+
+	return &qlAnd{left: left, right: right}
+}
+
+// filterList returns the filters of a slice, such as a []M, and whether the
+// value is a slice of filters.
+func filterList(value interface{}) ([]map[string]interface{}, bool) {
+	var items []interface{}
+
+	switch v := value.(type) {
+	case []M:
+		for _, m := range v {
+			items = append(items, m)
+		}
+	case []map[string]interface{}:
+		for _, m := range v {
+			items = append(items, m)
+		}
+	case []interface{}:
+		items = v
+	default:
+		return nil, false
+	}
+
+	filters := make([]map[string]interface{}, len(items))
+
+	for i, item := range items {
+		switch m := item.(type) {
+		case M:
+			filters[i] = m
+		case map[string]interface{}:
+			filters[i] = m
+		default:
+			return nil, false
+		}
+	}
+
+	return filters, true
+}
+
+// filterMap returns a map of operators, and whether the value is one. A map
+// with keys that are not operators is not.
+func filterMap(value interface{}) (map[string]interface{}, bool) {
+	var m map[string]interface{}
+
+	switch v := value.(type) {
+	case M:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return nil, false
+	}
+
+	for key := range m {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+
+	return m, true
+}
+
+// filterValue returns a value of a filter as a value of the query language:
+// nil, a bool, a json.Number or a string. A Param is returned as it is, to
+// be bound later.
+func filterValue(field string, value interface{}) (interface{}, error) {
+	if param, ok := value.(Param); ok {
+		return param, nil
+	}
+
+	v, err := decodeFilterValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: invalid filter: value of %s: %v", field, err)
+	}
+
+	switch v.(type) {
+	case nil, bool, json.Number, string:
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("ivy: invalid filter: value of %s is not a string, number, bool or nil", field)
+}
+
+// filterValues returns the values of an $in or $nin, which hold a slice of
+// any type.
+func filterValues(field string, name string, value interface{}) ([]interface{}, error) {
+	v, err := decodeFilterValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: invalid filter: %s of %s: %v", name, field, err)
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ivy: invalid filter: %s of %s takes a slice", name, field)
+	}
+
+	for i, item := range items {
+		items[i], err = filterValue(field, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// decodeFilterValue returns a Go value as it reads when stored in a record,
+// with numbers as json.Number, so that values of any Go type, such as an int
+// or a []string, can be given in a filter.
+func decodeFilterValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
@@ -0,0 +1,159 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type FixtureOptions is a struct holding the options of DB.LoadFixtures.
+type FixtureOptions struct {
+	// Truncate deletes every record of a table before its fixtures are
+	// loaded.
+	Truncate bool
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// LoadFixtures loads seed records, for tests and demo data, from a directory
+// holding a file named after each table it fills, such as "planes.json". A
+// file holds a JSON object mapping record ids to records, so that the records
+// get the same ids on every load, replacing records with those ids. Every
+// file is read and checked before any record is written. YAML fixtures are
+// not supported. It takes the path of the fixtures directory and the fixture
+// options. It returns any error encountered.
+func (db *DB) LoadFixtures(dir string, opts FixtureOptions) error {
+	return db.LoadFixturesCtx(context.Background(), dir, opts)
+}
+
+// LoadFixturesCtx is LoadFixtures with a context, which is handed to the
+// authorizer.
+func (db *DB) LoadFixturesCtx(ctx context.Context, dir string, opts FixtureOptions) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	fixtures := make(map[string]map[string]json.RawMessage)
+
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		tblName := strings.TrimSuffix(f.Name(), ext)
+
+		switch {
+		case f.IsDir() || strings.HasPrefix(f.Name(), "."):
+			continue
+		case ext == ".yaml" || ext == ".yml":
+			return fmt.Errorf("ivy: cannot load fixtures %s: YAML fixtures are not supported", f.Name())
+		case ext != ".json":
+			continue
+		}
+
+		if db.tblLock(tblName) == nil {
+			return fmt.Errorf("ivy: cannot load fixtures %s: table %s does not exist", f.Name(), tblName)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+
+		var recs map[string]json.RawMessage
+
+		err = json.Unmarshal(data, &recs)
+		if err != nil {
+			return fmt.Errorf("ivy: cannot load fixtures %s: %v", f.Name(), err)
+		}
+
+		for fileId, rec := range recs {
+			if n, err := strconv.Atoi(fileId); err != nil || n <= 0 {
+				return fmt.Errorf("ivy: cannot load fixtures %s: %w %q", f.Name(), ErrInvalidID, fileId)
+			}
+			if len(rec) == 0 || rec[0] != '{' {
+				return fmt.Errorf("ivy: cannot load fixtures %s: record %s is not an object", f.Name(), fileId)
+			}
+		}
+
+		fixtures[tblName] = recs
+	}
+
+	tblNames := make([]string, 0, len(fixtures))
+	for tblName := range fixtures {
+		tblNames = append(tblNames, tblName)
+	}
+	sort.Strings(tblNames)
+
+	ops, err := db.beginOps(ctx, tblNames, "import")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	for _, tblName := range tblNames {
+		err = db.loadTblFixtures(ctx, tblName, fixtures[tblName], opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// loadTblFixtures writes the fixtures of a table.
+func (db *DB) loadTblFixtures(ctx context.Context, tblName string, recs map[string]json.RawMessage, opts FixtureOptions) error {
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(fileIds))
+
+	for _, fileId := range fileIds {
+		if opts.Truncate {
+			err = db.removeRec(ctx, tblName, fileId)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		existing[fileId] = true
+	}
+
+	newIds := make([]string, 0, len(recs))
+	for fileId := range recs {
+		newIds = append(newIds, fileId)
+	}
+
+	for _, fileId := range sortedIdList(newIds) {
+		err = db.writeRec(ctx, tblName, fileId, recs[fileId], existing[fileId])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
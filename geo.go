@@ -0,0 +1,284 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371008.8
+
+// Type Bounds is a struct holding a bounding box of latitudes and longitudes
+// in degrees, as searched by DB.FindAllIdsInBounds. A box with MinLng greater
+// than MaxLng crosses the antimeridian.
+type Bounds struct {
+	MinLat float64
+	MinLng float64
+	MaxLat float64
+	MaxLng float64
+}
+
+// Contains reports whether a point lies in the box, edges included.
+func (b Bounds) Contains(lat float64, lng float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+
+	if b.MinLng <= b.MaxLng {
+		return lng >= b.MinLng && lng <= b.MaxLng
+	}
+
+	return lng >= b.MinLng || lng <= b.MaxLng
+}
+
+// geoPoint is the location of a record.
+type geoPoint struct {
+	fileId   string
+	lat, lng float64
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// FindAllIdsInBounds returns the ids of the records located in a bounding box,
+// in id order. A record is located by two fields holding its latitude and its
+// longitude in degrees; records without both are left out. The location is
+// indexed by naming both fields, with a comma, and an "@geo" suffix in the
+// fields to index, such as "lat,lng@geo", so that records are found from the
+// index without decoding any of them. It takes a table name, the latitude and
+// longitude fields and the box. It returns a slice of record ids and any error
+// encountered.
+func (db *DB) FindAllIdsInBounds(tblName string, latField string, lngField string, box Bounds) ([]string, error) {
+	return db.FindAllIdsInBoundsCtx(context.Background(), tblName, latField, lngField, box)
+}
+
+// FindAllIdsInBoundsCtx is FindAllIdsInBounds with a context. A search without
+// an index, which reads every record, stops with the context's error as soon
+// as the context is done.
+func (db *DB) FindAllIdsInBoundsCtx(ctx context.Context, tblName string, latField string, lngField string, box Bounds) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := db.geoPoints(ctx, tblName, latField, lngField)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, p := range points {
+		if box.Contains(p.lat, p.lng) {
+			ids = append(ids, p.fileId)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return idNum(ids[i]) < idNum(ids[j]) })
+
+	return ids, nil
+}
+
+// FindNearestIds returns the ids of the n records located nearest to a point,
+// nearest first, located and indexed as for FindAllIdsInBounds. Distances are
+// measured along the surface of the Earth. It takes a table name, the
+// latitude and longitude fields, the point and the number of records. It
+// returns a slice of record ids and any error encountered.
+func (db *DB) FindNearestIds(tblName string, latField string, lngField string, lat float64, lng float64, n int) ([]string, error) {
+	return db.FindNearestIdsCtx(context.Background(), tblName, latField, lngField, lat, lng, n)
+}
+
+// FindNearestIdsCtx is FindNearestIds with a context. A search without an
+// index, which reads every record, stops with the context's error as soon as
+// the context is done.
+func (db *DB) FindNearestIdsCtx(ctx context.Context, tblName string, latField string, lngField string, lat float64, lng float64, n int) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := db.geoPoints(ctx, tblName, latField, lngField)
+	if err != nil {
+		return nil, err
+	}
+
+	dists := make([]float64, len(points))
+	for i, p := range points {
+		dists[i] = geoDistance(lat, lng, p.lat, p.lng)
+	}
+
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if dists[a] != dists[b] {
+			return dists[a] < dists[b]
+		}
+		return idNum(points[a].fileId) < idNum(points[b].fileId)
+	})
+
+	if n < len(order) {
+		order = order[:n]
+	}
+
+	ids := make([]string, len(order))
+	for i, k := range order {
+		ids[i] = points[k].fileId
+	}
+
+	return ids, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// geoPoints returns the locations of the records of a table, read from the
+// table's geo index of the fields if it has one, or from every record.
+func (db *DB) geoPoints(ctx context.Context, tblName string, latField string, lngField string) ([]geoPoint, error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	var points []geoPoint
+
+	if fldIndex, ok := db.fldIndex(tblName)[latField+","+lngField+"@geo"]; ok {
+		db.metrics.countIndexHit(tblName)
+
+		for key, fileIds := range fldIndex {
+			lat, lng, err := parseGeoKey(key)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, fileId := range fileIds {
+				points = append(points, geoPoint{fileId: fileId, lat: lat, lng: lng})
+			}
+		}
+
+		return points, nil
+	}
+
+	start := time.Now()
+	defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := db.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, err
+		}
+
+		lat, lng, ok := geoValue(rec, latField, lngField)
+		if ok {
+			points = append(points, geoPoint{fileId: fileId, lat: lat, lng: lng})
+		}
+	}
+
+	return points, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// geoDistance returns the great-circle distance in meters between two points.
+func geoDistance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	rlat1, rlat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dlat := rlat2 - rlat1
+	dlng := (lng2 - lng1) * math.Pi / 180
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dlng/2)*math.Sin(dlng/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// geoKey returns the key of a geo index for the location of a record held in
+// the fields named by a geo index, such as "lat,lng", and whether the record
+// has a location.
+func geoKey(rec map[string]interface{}, fields string) (string, bool) {
+	latField, lngField, ok := strings.Cut(fields, ",")
+	if !ok {
+		return "", false
+	}
+
+	lat, lng, ok := geoValue(rec, latField, lngField)
+	if !ok {
+		return "", false
+	}
+
+	return strconv.FormatFloat(lat, 'g', -1, 64) + "," + strconv.FormatFloat(lng, 'g', -1, 64), true
+}
+
+// geoValue returns the latitude and longitude held in two fields of a record,
+// and whether both are numbers within range.
+func geoValue(rec map[string]interface{}, latField string, lngField string) (float64, float64, bool) {
+	lat, ok := numberFloat(fieldValue(rec, latField))
+	if !ok || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+
+	lng, ok := numberFloat(fieldValue(rec, lngField))
+	if !ok || lng < -180 || lng > 180 {
+		return 0, 0, false
+	}
+
+	return lat, lng, true
+}
+
+// parseGeoKey returns the latitude and longitude of a key of a geo index.
+func parseGeoKey(key string) (float64, float64, error) {
+	latStr, lngStr, _ := strings.Cut(key, ",")
+
+	lat, err := strconv.ParseFloat(latStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ivy: invalid geo index key %q", key)
+	}
+
+	lng, err := strconv.ParseFloat(lngStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ivy: invalid geo index key %q", key)
+	}
+
+	return lat, lng, nil
+}
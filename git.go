@@ -0,0 +1,176 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Type GitOptions is a struct holding the settings of Options.Git. Name and
+// Email are the author and committer of the commits; if they are empty, those
+// of the git configuration are used.
+type GitOptions struct {
+	Name  string
+	Email string
+}
+
+// gitChange is a change to a record to commit.
+type gitChange struct {
+	tblName string
+	fileId  string
+	op      string
+	actor   string
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkGit returns an error if the database cannot commit its changes to
+// git, as its directory is not in a git work tree or git is missing.
+func (db *DB) checkGit() error {
+	if _, ok := db.fileEngine(); !ok || db.opts.WriteBehind != nil {
+		return errors.New("ivy: git commits require file storage without write-behind")
+	}
+
+	out, err := db.git("rev-parse", "--is-inside-work-tree")
+	if err != nil || strings.TrimSpace(string(out)) != "true" {
+		return fmt.Errorf("ivy: %s is not in a git work tree: %v", db.path, err)
+	}
+
+	return nil
+}
+
+// commitToGit commits a change to a record to git, or adds it to the changes
+// of its transaction, which are committed together. The caller must hold the
+// table's write lock, so that the files committed are those of the change.
+func (db *DB) commitToGit(tx *Tx, tblName string, fileId string, op string, actor string) {
+	if db.gitOpts == nil {
+		return
+	}
+
+	change := gitChange{tblName: tblName, fileId: fileId, op: op, actor: actor}
+
+	if tx != nil {
+		tx.gitChanges = append(tx.gitChanges, change)
+		return
+	}
+
+	db.gitCommit([]gitChange{change})
+}
+
+// gitCommit stages the table directories of changes to records and commits
+// them with a message listing the changes. A failure is only logged, as the
+// changes are already made.
+func (db *DB) gitCommit(changes []gitChange) {
+	if db.gitOpts == nil || len(changes) == 0 {
+		return
+	}
+
+	db.gitMu.Lock()
+	defer db.gitMu.Unlock()
+
+	e, _ := db.fileEngine()
+
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, ch := range changes {
+		if !seen[ch.tblName] {
+			seen[ch.tblName] = true
+			paths = append(paths, e.layout.TablePath(db.path, ch.tblName))
+		}
+	}
+	sort.Strings(paths)
+
+	args := append([]string{"add", "-A", "--"}, paths...)
+	if _, err := db.git(args...); err != nil {
+		db.logger.Error("ivy: git add failed", "err", err)
+		return
+	}
+
+	// Nothing to commit, such as when a record was written unchanged.
+	args = append([]string{"diff", "--cached", "--quiet", "--"}, paths...)
+	if _, err := db.git(args...); err == nil {
+		return
+	}
+
+	args = append([]string{"commit", "-q", "-m", gitMessage(changes), "--"}, paths...)
+	if _, err := db.git(args...); err != nil {
+		db.logger.Error("ivy: git commit failed", "err", err)
+		return
+	}
+
+	db.logger.Debug("ivy: changes committed to git", "changes", len(changes))
+}
+
+// git runs a git command in the database directory. It returns the output of
+// the command and any error encountered, including its error output.
+func (db *DB) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = db.path
+
+	cmd.Env = os.Environ()
+	if name := db.gitOpts.Name; name != "" {
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_NAME="+name, "GIT_COMMITTER_NAME="+name)
+	}
+	if email := db.gitOpts.Email; email != "" {
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_EMAIL="+email, "GIT_COMMITTER_EMAIL="+email)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, err
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// gitMessage returns the message of a commit of changes to records. A single
+// change gets a summary line and a line per detail, such as
+//
+//	ivy: update planes/3
+//
+//	Table: planes
+//	Id: 3
+//	Op: update
+//	Actor: bob
+//
+// and the changes of a transaction a summary line and a line per change.
+func gitMessage(changes []gitChange) string {
+	var b strings.Builder
+
+	if len(changes) == 1 {
+		ch := changes[0]
+
+		fmt.Fprintf(&b, "ivy: %s %s/%s\n\nTable: %s\nId: %s\nOp: %s\n", ch.op, ch.tblName, ch.fileId, ch.tblName, ch.fileId, ch.op)
+		if ch.actor != "" {
+			fmt.Fprintf(&b, "Actor: %s\n", ch.actor)
+		}
+
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "ivy: transaction of %d changes\n\n", len(changes))
+
+	for _, ch := range changes {
+		fmt.Fprintf(&b, "%s %s/%s", ch.op, ch.tblName, ch.fileId)
+		if ch.actor != "" {
+			fmt.Fprintf(&b, " by %s", ch.actor)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
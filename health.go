@@ -0,0 +1,213 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// healthProbeName is the name of the file, inside the metadata directory,
+// that Health writes to check that the database directory is writable.
+const healthProbeName = "health-probe"
+
+// walBacklogSegments is the number of segments of write-ahead log written
+// since the last checkpoint above which Health reports the log as backed up.
+const walBacklogSegments = 4
+
+// Type Health is a struct holding the result of the checks run by DB.Health.
+// Healthy is true if every check passed.
+type Health struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// Type HealthCheck is a struct holding the result of one of the checks run by
+// DB.Health: its name, whether it passed and, for a check that failed or has
+// something worth knowing, such as a database being read-only, a message.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Health checks that the database is in working order: that it is open, that
+// its directory can be reached and written to, unless it is a follower or in
+// memory, that the indexes of every table are loaded, that the write-ahead
+// log, if any, has been checkpointed within its last few segments, and that
+// no table is full with a quota that rejects new records. Checks not yet run
+// when the context is done fail with the context's error. It takes a context.
+// It returns the result of the checks.
+func (db *DB) Health(ctx context.Context) *Health {
+	h := &Health{Healthy: true}
+
+	add := func(name string, err error, msg string) {
+		check := HealthCheck{Name: name, OK: err == nil, Message: msg}
+		if err != nil {
+			check.Message = err.Error()
+			h.Healthy = false
+		}
+
+		h.Checks = append(h.Checks, check)
+	}
+
+	if err := db.enter(); err != nil {
+		add("open", err, "")
+		return h
+	}
+	defer db.leave()
+
+	add("open", nil, "")
+
+	checks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"storage", db.checkStorage},
+		{"indexes", db.checkIndexes},
+		{"wal", db.checkWAL},
+		{"quotas", db.checkQuotas},
+	}
+
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			add(check.name, err, "")
+			continue
+		}
+
+		msg, err := check.run()
+		add(check.name, err, msg)
+	}
+
+	return h
+}
+
+// HealthHandler returns an HTTP handler serving the result of Health as JSON,
+// with status 200 if the database is healthy and 503 otherwise, to be wired
+// into a service's /healthz endpoint.
+func (db *DB) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := db.Health(r.Context())
+
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(h)
+	})
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// checkStorage checks that the database directory can be reached and, unless
+// the database is a follower or read-only, written to.
+func (db *DB) checkStorage() (string, error) {
+	if db.path == "" {
+		return "in memory", nil
+	}
+
+	info, err := db.fs.Stat(db.path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("ivy: %s is not a directory", db.path)
+	}
+
+	if err := db.checkWritable(); errors.Is(err, ErrReadOnly) {
+		return "read-only", nil
+	} else if err != nil {
+		return "read-only follower", nil
+	}
+
+	err = db.fs.MkdirAll(db.metaPath(), db.modes.dir)
+	if err != nil {
+		return "", err
+	}
+
+	probe := db.metaPath(healthProbeName)
+
+	err = db.fs.WriteFile(probe, []byte("ok"), db.modes.file)
+	if err != nil {
+		return "", err
+	}
+
+	return "", db.fs.Remove(probe)
+}
+
+// checkIndexes checks that the indexes of every indexed table are loaded.
+func (db *DB) checkIndexes() (string, error) {
+	for _, tblName := range db.indexedTables() {
+		fldNames, _ := db.indexFields(tblName)
+
+		if db.fldIndex(tblName) == nil || (stringInSlice("tags", fldNames) && db.tagIndex(tblName) == nil) {
+			return "", fmt.Errorf("ivy: indexes of table %s are not loaded", tblName)
+		}
+	}
+
+	return "", nil
+}
+
+// checkWAL checks that the write-ahead log is not backed up, which would make
+// recovery slow and the log grow without bound.
+func (db *DB) checkWAL() (string, error) {
+	if db.wal == nil {
+		return "no write-ahead log", nil
+	}
+
+	backlog := db.wal.backlog()
+	msg := fmt.Sprintf("%d bytes since the last checkpoint", backlog)
+
+	if backlog > walBacklogSegments*db.wal.segmentSize {
+		return "", fmt.Errorf("ivy: write-ahead log backed up: %s", msg)
+	}
+
+	return msg, nil
+}
+
+// checkQuotas checks that no table with a quota that rejects new records is
+// full.
+func (db *DB) checkQuotas() (string, error) {
+	for _, tblName := range db.tableNames() {
+		quota, ok := db.quotas[tblName]
+		if !ok || quota.Eviction == EvictOldest {
+			continue
+		}
+
+		st, err := db.tblQuotaUsage(tblName)
+		if err != nil {
+			return "", err
+		}
+
+		if (quota.MaxRecords > 0 && st.records >= quota.MaxRecords) ||
+			(quota.MaxBytes > 0 && st.bytes >= quota.MaxBytes) {
+			return "", &QuotaError{Table: tblName, Quota: quota, Records: st.records, Bytes: st.bytes}
+		}
+	}
+
+	return "", nil
+}
+
+// tblQuotaUsage returns the number of records of a table and their size,
+// from its quota usage if it has been computed, or else from storage.
+func (db *DB) tblQuotaUsage(tblName string) (tblStat, error) {
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	if usage := db.usage[tblName]; usage != nil && usage.sizes != nil {
+		return tblStat{records: len(usage.sizes), bytes: usage.bytes}, nil
+	}
+
+	return db.engine.stat(tblName)
+}
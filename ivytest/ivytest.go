@@ -0,0 +1,108 @@
+// Package ivytest provides helpers for tests of code using an ivy database.
+//
+// Every helper opens a database of its own, in a temporary directory removed
+// when the test ends, so that tests do not have to share a data directory or
+// clean it up by hand:
+//
+//	func TestPlanes(t *testing.T) {
+//		db := ivytest.NewTempDBWithFixtures(t, map[string][]string{"planes": {"tags"}}, "testdata/fixtures")
+//		...
+//	}
+package ivytest
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// NewTempDB opens a database in a new temporary directory holding an empty
+// table for every key of fieldsToIndex, indexed on its fields. The database
+// is closed and the directory removed when the test ends. It fails the test
+// if the database cannot be opened. It takes the test, the tables and their
+// indexes, and any further options. It returns the database.
+func NewTempDB(t testing.TB, fieldsToIndex map[string][]string, options ...ivy.Option) *ivy.DB {
+	t.Helper()
+
+	return openTempDB(t, fieldsToIndex, "", options)
+}
+
+// NewTempDBWithFixtures is NewTempDB followed by DB.LoadFixtures with the
+// fixtures in dir. A table is created for every fixture file, whether it is
+// in fieldsToIndex or not. It takes the test, the tables and their indexes,
+// the fixtures directory and any further options. It returns the database.
+func NewTempDBWithFixtures(t testing.TB, fieldsToIndex map[string][]string, dir string, options ...ivy.Option) *ivy.DB {
+	t.Helper()
+
+	return openTempDB(t, fieldsToIndex, dir, options)
+}
+
+// NewMemDB opens an in-memory database with a table for every key of
+// fieldsToIndex, indexed on its fields. The database is closed when the test
+// ends. It takes the test and the tables and their indexes. It returns the
+// database.
+func NewMemDB(t testing.TB, fieldsToIndex map[string][]string) *ivy.DB {
+	t.Helper()
+
+	db, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("ivytest: OpenMemDB failed:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// openTempDB opens a database in a new temporary directory, loading the
+// fixtures in fixturesDir if it is not empty.
+func openTempDB(t testing.TB, fieldsToIndex map[string][]string, fixturesDir string, options []ivy.Option) *ivy.DB {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	tblNames := make([]string, 0, len(fieldsToIndex))
+	for tblName := range fieldsToIndex {
+		tblNames = append(tblNames, tblName)
+	}
+
+	if fixturesDir != "" {
+		files, err := ioutil.ReadDir(fixturesDir)
+		if err != nil {
+			t.Fatal("ivytest: cannot read fixtures:", err)
+		}
+
+		for _, f := range files {
+			if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") && filepath.Ext(f.Name()) == ".json" {
+				tblNames = append(tblNames, strings.TrimSuffix(f.Name(), ".json"))
+			}
+		}
+	}
+
+	for _, tblName := range tblNames {
+		if err := os.MkdirAll(filepath.Join(dir, tblName), 0700); err != nil {
+			t.Fatal("ivytest: cannot create table:", err)
+		}
+	}
+
+	db, err := ivy.OpenDB(dir, append([]ivy.Option{ivy.WithIndexes(fieldsToIndex)}, options...)...)
+	if err != nil {
+		t.Fatal("ivytest: OpenDB failed:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if fixturesDir != "" {
+		err = db.LoadFixtures(fixturesDir, ivy.FixtureOptions{})
+		if err != nil {
+			t.Fatal("ivytest: LoadFixtures failed:", err)
+		}
+	}
+
+	return db
+}
@@ -0,0 +1,168 @@
+package ivy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type KeyProvider is an interface for fetching an encryption key when a
+// database is opened, set in Options.EncryptionKeyProvider or
+// Options.FieldKeyProvider, so that production deployments can keep their
+// keys in the environment or a key management service rather than passing
+// them to OpenDB. Key returns a 16, 24 or 32 byte AES key.
+type KeyProvider interface {
+	Key(ctx context.Context) ([]byte, error)
+}
+
+// Type KeyFunc is a function fetching an encryption key, such as from AWS
+// KMS or HashiCorp Vault, that implements KeyProvider. Wrap it with
+// NewKeyCache to fetch the key only once in a while.
+type KeyFunc func(ctx context.Context) ([]byte, error)
+
+// Key calls f.
+func (f KeyFunc) Key(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// Type KeyCache is a struct holding a KeyProvider that remembers the key of
+// another provider for a while, so that opening many databases, or opening
+// one often, does not call a key management service every time. It is safe
+// for concurrent use.
+type KeyCache struct {
+	p   KeyProvider
+	ttl time.Duration
+
+	mu      sync.Mutex
+	key     []byte
+	fetched time.Time
+}
+
+// staticKey is the KeyProvider returned by StaticKey.
+type staticKey []byte
+
+// envKey is the KeyProvider returned by EnvKey.
+type envKey string
+
+// defaultKeyTimeout is the default of Options.KeyTimeout.
+const defaultKeyTimeout = 30 * time.Second
+
+// NewKeyCache returns a provider that fetches the key from p the first time
+// it is asked for, and again when it is asked for more than ttl after that.
+// A ttl of zero or less keeps the key until Refresh is called. If fetching
+// the key again fails, the cached key is returned until a later fetch
+// succeeds, so that an outage of the key management service does not keep
+// databases from opening.
+func NewKeyCache(p KeyProvider, ttl time.Duration) *KeyCache {
+	return &KeyCache{p: p, ttl: ttl}
+}
+
+// Key returns the cached key, fetching it first if there is none or it is
+// older than the cache's ttl.
+func (c *KeyCache) Key(ctx context.Context) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.key != nil && (c.ttl <= 0 || time.Since(c.fetched) < c.ttl) {
+		return c.key, nil
+	}
+
+	key, err := c.p.Key(ctx)
+	if err != nil {
+		if c.key != nil {
+			return c.key, nil
+		}
+		return nil, err
+	}
+
+	c.key, c.fetched = key, time.Now()
+
+	return key, nil
+}
+
+// Refresh fetches the key again at once, such as after the key was rotated
+// in the key management service. It returns any error encountered, keeping
+// the cached key if there is one.
+func (c *KeyCache) Refresh(ctx context.Context) error {
+	key, err := c.p.Key(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.key, c.fetched = key, time.Now()
+	c.mu.Unlock()
+
+	return nil
+}
+
+// StaticKey returns a provider of a key known in advance, such as one read
+// from a file.
+func StaticKey(key []byte) KeyProvider {
+	return staticKey(key)
+}
+
+// Key returns the key.
+func (k staticKey) Key(ctx context.Context) ([]byte, error) {
+	return k, nil
+}
+
+// EnvKey returns a provider of the key held, base64 encoded, in the
+// environment variable name, as container orchestrators pass secrets.
+func EnvKey(name string) KeyProvider {
+	return envKey(name)
+}
+
+// Key decodes the key held in the environment variable.
+func (k envKey) Key(ctx context.Context) ([]byte, error) {
+	value, ok := os.LookupEnv(string(k))
+	if !ok || value == "" {
+		return nil, fmt.Errorf("ivy: environment variable %s holds no key", string(k))
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("ivy: environment variable %s does not hold a base64 key: %v", string(k), err)
+	}
+
+	return key, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// resolveKey returns key, or the key of p if key is nil, giving up on p after
+// timeout, or defaultKeyTimeout if it is zero or less. It returns an error if
+// both are set, naming the key as what.
+func resolveKey(key []byte, p KeyProvider, timeout time.Duration, what string) ([]byte, error) {
+	if p == nil {
+		return key, nil
+	}
+
+	if key != nil {
+		return nil, fmt.Errorf("ivy: both a %s key and a %s key provider are set", what, what)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultKeyTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	key, err := p.Key(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: fetching the %s key: %w", what, err)
+	}
+	if key == nil {
+		return nil, errors.New("ivy: the " + what + " key provider returned no key")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,98 @@
+package ivy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+)
+
+// Type Layout is an interface that decides where tables and records live
+// inside the database directory. All path construction goes through the
+// layout, so storage layouts such as sharded directories or custom file naming
+// can be plugged in with Options.Layout. Paths are built with path/filepath.
+type Layout interface {
+	// TablePath returns the path of a table. FileStorage uses it as the table
+	// directory; PackedStorage adds an extension to get the table data file.
+	TablePath(dbPath string, tblName string) string
+
+	// RecordPath returns the path of the file holding a record. data is the
+	// marshalled record, or nil if only the id is known. A layout whose file
+	// names depend on the record contents returns "" when data is nil, and the
+	// record is then located by listing the table directory.
+	RecordPath(dbPath string, tblName string, fileId string, data []byte) string
+
+	// RecordId returns the id of the record stored in a file found while
+	// listing a table directory, and whether the file holds a record at all.
+	RecordId(dbPath string, tblName string, filePath string) (string, bool)
+}
+
+// Type DefaultLayout is the default Layout: every table is a directory of the
+// database directory and every record is a file of its table directory, named
+// according to the FileNaming of its table.
+type DefaultLayout struct {
+	// Naming configures the record file names of each table. Tables that are
+	// not listed use "<id>.json".
+	Naming map[string]FileNaming
+}
+
+// TablePath returns the path of a table directory.
+func (l *DefaultLayout) TablePath(dbPath string, tblName string) string {
+	return filepath.Join(dbPath, tblName)
+}
+
+// RecordPath returns the path of a record file.
+func (l *DefaultLayout) RecordPath(dbPath string, tblName string, fileId string, data []byte) string {
+	naming := l.naming(tblName)
+	if naming.SlugField != "" && data == nil {
+		return ""
+	}
+
+	return filepath.Join(l.TablePath(dbPath, tblName), naming.fileName(fileId, data))
+}
+
+// RecordId returns the id of the record stored in a file.
+func (l *DefaultLayout) RecordId(dbPath string, tblName string, filePath string) (string, bool) {
+	return l.naming(tblName).parseFileName(filepath.Base(filePath))
+}
+
+// naming returns the FileNaming of a table.
+func (l *DefaultLayout) naming(tblName string) FileNaming {
+	if l == nil {
+		return FileNaming{}
+	}
+	return l.Naming[tblName]
+}
+
+// Type ShardedLayout is a Layout that spreads the records of every table over
+// a fixed number of subdirectories of the table directory, e.g.
+// planes/0a/123.json, which keeps directories small for very large tables.
+// Records are assigned to shards by a hash of their id.
+type ShardedLayout struct {
+	DefaultLayout
+
+	// Shards is the number of subdirectories per table. It defaults to 256.
+	Shards int
+}
+
+// RecordPath returns the path of a record file inside its shard directory.
+func (l *ShardedLayout) RecordPath(dbPath string, tblName string, fileId string, data []byte) string {
+	naming := l.naming(tblName)
+	if naming.SlugField != "" && data == nil {
+		return ""
+	}
+
+	return filepath.Join(l.TablePath(dbPath, tblName), l.shard(fileId), naming.fileName(fileId, data))
+}
+
+// shard returns the name of the shard directory of a record.
+func (l *ShardedLayout) shard(fileId string) string {
+	shards := l.Shards
+	if shards <= 0 {
+		shards = 256
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(fileId))
+
+	return fmt.Sprintf("%02x", h.Sum32()%uint32(shards))
+}
@@ -0,0 +1,187 @@
+package ivy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lockFileName is the name of the lock file inside the metadata directory.
+const lockFileName = "lock"
+
+// lockGracePeriod is how long an unreadable lock file is assumed to be in the
+// middle of being written by its owner rather than left behind by a crash.
+const lockGracePeriod = 5 * time.Second
+
+// heldLocks holds the absolute paths of the lock files held by this process,
+// so that a lock file naming this process can be told apart from one left
+// behind by an earlier process that happened to have the same PID.
+var heldLocks = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// lockOwner is the content of a lock file.
+type lockOwner struct {
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// acquireLock creates the lock file of the database. A lock file left behind
+// by a process that is no longer running is removed and replaced. It returns
+// an error wrapping ErrLocked if another live process holds the lock. Lock
+// files of other hosts are always assumed to be live.
+func (db *DB) acquireLock() error {
+	lockPath, err := filepath.Abs(db.metaPath(lockFileName))
+	if err != nil {
+		return err
+	}
+
+	err = db.modes.mkdirAll(filepath.Dir(lockPath))
+	if err != nil {
+		return err
+	}
+
+	owner := lockOwner{PID: os.Getpid(), Acquired: time.Now().UTC()}
+	owner.Host, _ = os.Hostname()
+
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := db.modes.openFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return err
+			}
+
+			heldLocks.paths[lockPath] = true
+			db.lockPath = lockPath
+
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		if other, live := lockIsLive(lockPath, owner.Host); live {
+			if other == nil {
+				return fmt.Errorf("%w: %s", ErrLocked, db.path)
+			}
+			return fmt.Errorf("%w: %s is held by process %d on %s", ErrLocked, db.path, other.PID, other.Host)
+		}
+
+		db.logger.Info("ivy: removing stale lock file", "path", lockPath)
+
+		err = os.Remove(lockPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrLocked, db.path)
+}
+
+// releaseLock removes the lock file of the database, if it holds it, along
+// with the metadata directory if nothing else is in it.
+func (db *DB) releaseLock() error {
+	if db.lockPath == "" {
+		return nil
+	}
+
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+
+	delete(heldLocks.paths, db.lockPath)
+
+	err := os.Remove(db.lockPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Don't leave an empty metadata directory behind.
+	os.Remove(filepath.Dir(db.lockPath))
+
+	db.lockPath = ""
+
+	return nil
+}
+
+// removeTempFiles removes the temporary files left behind by writes that were
+// interrupted by a crash, both in the tables and in the metadata directory.
+func (db *DB) removeTempFiles(tblNames []string) error {
+	for _, tblName := range tblNames {
+		removed, err := db.engine.removeTemp(tblName)
+		if err != nil {
+			return err
+		}
+
+		if len(removed) > 0 {
+			db.logger.Info("ivy: removed files of interrupted writes", "table", tblName, "files", len(removed))
+		}
+	}
+
+	return filepath.Walk(db.metaPath(), func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && isTempFile(info.Name()) {
+			return os.Remove(filePath)
+		}
+		return nil
+	})
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// lockIsLive reads a lock file and answers whether its owner may still be
+// running. It returns the owner, or nil if the lock file could not be read.
+// The caller must hold heldLocks.
+func lockIsLive(lockPath string, host string) (*lockOwner, bool) {
+	data, err := ioutil.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+
+	var owner lockOwner
+
+	if err != nil || json.Unmarshal(data, &owner) != nil || owner.PID <= 0 {
+		// The owner may not have finished writing it yet.
+		info, err := os.Stat(lockPath)
+		return nil, err == nil && time.Since(info.ModTime()) < lockGracePeriod
+	}
+
+	if owner.Host != host {
+		return &owner, true
+	}
+
+	if owner.PID == os.Getpid() {
+		return &owner, heldLocks.paths[lockPath]
+	}
+
+	return &owner, processAlive(owner.PID)
+}
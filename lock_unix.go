@@ -0,0 +1,11 @@
+//go:build unix
+
+package ivy
+
+import "syscall"
+
+// processAlive answers whether a process with the supplied PID is running.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,248 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Type LockStats is a struct describing the lock of a table, as part of its
+// TableStats. Acquisitions counts how often the lock was taken since the
+// database was opened, and Contended how often that meant waiting for
+// another holder; WaitTime is the total time spent waiting and MaxWait the
+// longest wait. Waiting is the number of operations waiting for the lock
+// now. Held is "write" or "read" if the lock is held, with Readers the
+// number of readers, and HeldSince the time it has been held since without
+// interruption.
+type LockStats struct {
+	Acquisitions uint64        `json:"acquisitions"`
+	Contended    uint64        `json:"contended"`
+	WaitTime     time.Duration `json:"wait_time"`
+	MaxWait      time.Duration `json:"max_wait"`
+	Waiting      int           `json:"waiting"`
+	Held         string        `json:"held,omitempty"`
+	Readers      int           `json:"readers,omitempty"`
+	HeldSince    time.Time     `json:"held_since,omitempty"`
+}
+
+// Type Operation is a struct describing an operation in progress, as part of
+// the TableStats of its table: what it is, as named in the access log, the
+// record it is about, if any, when it started and how long it has run.
+type Operation struct {
+	Op       string        `json:"op"`
+	Id       string        `json:"id,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// tblMutex is the lock of a table. It is a sync.RWMutex that keeps track of
+// how long it is waited for and by whom it is held, and logs waits longer
+// than warnAfter, if set.
+type tblMutex struct {
+	mu        sync.RWMutex
+	tblName   string
+	warnAfter time.Duration
+	logger    *slog.Logger
+
+	stateMu sync.Mutex
+	stats   LockStats
+}
+
+// operation is an operation in progress.
+type operation struct {
+	tblName string
+	fileId  string
+	op      string
+	actor   string
+	started time.Time
+}
+
+// newTblLock returns a new lock for a table.
+func (db *DB) newTblLock(tblName string) *tblMutex {
+	return &tblMutex{tblName: tblName, warnAfter: db.lockWaitWarning, logger: db.logger}
+}
+
+// Lock locks the table for writing.
+func (l *tblMutex) Lock() {
+	if !l.mu.TryLock() {
+		l.wait("write", l.mu.Lock)
+	}
+
+	l.stateMu.Lock()
+	l.stats.Acquisitions++
+	l.stats.Held = "write"
+	l.stats.HeldSince = time.Now()
+	l.stateMu.Unlock()
+}
+
+// Unlock unlocks the table for writing.
+func (l *tblMutex) Unlock() {
+	l.stateMu.Lock()
+	l.stats.Held = ""
+	l.stats.HeldSince = time.Time{}
+	l.stateMu.Unlock()
+
+	l.mu.Unlock()
+}
+
+// RLock locks the table for reading.
+func (l *tblMutex) RLock() {
+	if !l.mu.TryRLock() {
+		l.wait("read", l.mu.RLock)
+	}
+
+	l.readLocked()
+}
+
+// TryRLock locks the table for reading if it can do so without waiting. It
+// returns whether it did.
+func (l *tblMutex) TryRLock() bool {
+	if !l.mu.TryRLock() {
+		return false
+	}
+
+	l.readLocked()
+
+	return true
+}
+
+// RUnlock unlocks the table for reading.
+func (l *tblMutex) RUnlock() {
+	l.stateMu.Lock()
+	l.stats.Readers--
+	if l.stats.Readers == 0 {
+		l.stats.Held = ""
+		l.stats.HeldSince = time.Time{}
+	}
+	l.stateMu.Unlock()
+
+	l.mu.RUnlock()
+}
+
+// readLocked records that the table was locked for reading.
+func (l *tblMutex) readLocked() {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+
+	l.stats.Acquisitions++
+	l.stats.Readers++
+	if l.stats.Readers == 1 {
+		l.stats.Held = "read"
+		l.stats.HeldSince = time.Now()
+	}
+}
+
+// wait takes the lock with the supplied function, which blocks, counting the
+// time spent waiting.
+func (l *tblMutex) wait(mode string, lock func()) {
+	l.stateMu.Lock()
+	l.stats.Waiting++
+	l.stateMu.Unlock()
+
+	start := time.Now()
+	lock()
+	waited := time.Since(start)
+
+	l.stateMu.Lock()
+	l.stats.Waiting--
+	l.stats.Contended++
+	l.stats.WaitTime += waited
+	if waited > l.stats.MaxWait {
+		l.stats.MaxWait = waited
+	}
+	l.stateMu.Unlock()
+
+	if l.warnAfter > 0 && waited > l.warnAfter {
+		l.logger.Warn("ivy: slow table lock", "table", l.tblName, "mode", mode, "wait", waited)
+	}
+}
+
+// snapshot returns the stats of the lock.
+func (l *tblMutex) snapshot() LockStats {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+
+	return l.stats
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// beginOp registers an operation in progress and asks the authorizer whether
+// it may go ahead. The operation is registered even if it may not, so that
+// endOp records the refusal in the access log.
+func (db *DB) beginOp(ctx context.Context, tblName string, fileId string, op string) (*operation, error) {
+	o := &operation{tblName: tblName, fileId: fileId, op: op, started: time.Now()}
+	o.actor, _ = ActorFromContext(ctx)
+
+	db.opsMu.Lock()
+	db.ops[o] = true
+	db.opsMu.Unlock()
+
+	if db.authorizer != nil {
+		if err := db.authorizer(ctx, tblName, op, fileId); err != nil {
+			return o, fmt.Errorf("%w: %s %s: %w", ErrForbidden, op, tblName, err)
+		}
+	}
+
+	return o, nil
+}
+
+// endOp unregisters an operation that returned err and records it in the
+// access log. The id of the record is passed again, as operations such as
+// Create only know it at the end.
+func (db *DB) endOp(o *operation, fileId string, err error) {
+	db.opsMu.Lock()
+	delete(db.ops, o)
+	db.opsMu.Unlock()
+
+	db.logAccess(o.tblName, fileId, o.op, o.actor, o.started, err)
+}
+
+// beginOps registers an operation reading or writing several tables, such as
+// a backup of the whole database, as beginOp does for every one of them. It
+// stops at the first table the authorizer refuses. The operations registered
+// so far are returned either way.
+func (db *DB) beginOps(ctx context.Context, tblNames []string, op string) ([]*operation, error) {
+	ops := make([]*operation, 0, len(tblNames))
+
+	for _, tblName := range tblNames {
+		o, err := db.beginOp(ctx, tblName, "", op)
+		ops = append(ops, o)
+		if err != nil {
+			return ops, err
+		}
+	}
+
+	return ops, nil
+}
+
+// endOps unregisters operations registered by beginOps that returned err.
+func (db *DB) endOps(ops []*operation, err error) {
+	for _, o := range ops {
+		db.endOp(o, "", err)
+	}
+}
+
+// tblOps returns the operations in progress on a table, longest-running
+// first.
+func (db *DB) tblOps(tblName string) []Operation {
+	now := time.Now()
+	ops := []Operation{}
+
+	db.opsMu.Lock()
+	for o := range db.ops {
+		if o.tblName == tblName {
+			ops = append(ops, Operation{Op: o.op, Id: o.fileId, Started: o.started, Duration: now.Sub(o.started)})
+		}
+	}
+	db.opsMu.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Started.Before(ops[j].Started) })
+
+	return ops
+}
@@ -0,0 +1,321 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Type ManagerOptions is a struct holding the settings of a Manager.
+type ManagerOptions struct {
+	// Options are the options every database is opened with, such as
+	// WithIndexes or WithLogger.
+	Options []Option
+
+	// MaxOpen limits how many databases are open at once. When the limit is
+	// reached, the database released longest ago is closed to make room, and
+	// if every open database is in use, Acquire waits for one to be
+	// released. Zero means no limit.
+	MaxOpen int
+
+	// IdleTimeout closes the databases that were not used for as long, so
+	// that tenants that went quiet do not keep their files open. Zero keeps
+	// them open until they are evicted or the manager is closed.
+	IdleTimeout time.Duration
+}
+
+// Type Manager is a struct holding a pool of open databases, one per data
+// directory, such as one per tenant or project of an application, that are
+// opened with the same options when first acquired and closed when idle or
+// when too many are open. Acquire a database for as long as it is used and
+// release it afterwards, rather than closing it. A Manager is safe for
+// concurrent use.
+type Manager struct {
+	opts ManagerOptions
+
+	mu     sync.Mutex
+	dbs    map[string]*managedDB
+	byDB   map[*DB]*managedDB
+	wake   chan struct{}
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// managedDB is a database of a Manager. ready is closed once the database is
+// opened, or failed to open.
+type managedDB struct {
+	path     string
+	db       *DB
+	refs     int
+	lastUsed time.Time
+	ready    chan struct{}
+}
+
+// NewManager returns a manager of databases opened with the supplied
+// options. Close it when done with it.
+func NewManager(opts ManagerOptions) *Manager {
+	m := &Manager{
+		opts: opts,
+		dbs:  make(map[string]*managedDB),
+		byDB: make(map[*DB]*managedDB),
+		wake: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+
+	if opts.IdleTimeout > 0 {
+		m.wg.Add(1)
+		go m.closeIdle()
+	}
+
+	return m
+}
+
+// Acquire returns the database of a data directory, opening it if it is not
+// open yet. Every database acquired has to be released with Release. If
+// MaxOpen databases are open and in use, Acquire waits for one to be
+// released until the context is done. It takes a context and the path of the
+// database directory. It returns the database and any error encountered.
+func (m *Manager) Acquire(ctx context.Context, dbPath string) (*DB, error) {
+	path, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+
+	for {
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+
+		e := m.dbs[path]
+
+		if e != nil && e.db == nil {
+			// Another caller is opening the database.
+			m.mu.Unlock()
+
+			select {
+			case <-e.ready:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			m.mu.Lock()
+			continue
+		}
+
+		if e != nil && e.db.isClosed() {
+			// The database was closed behind the manager's back.
+			m.remove(e)
+			e = nil
+		}
+
+		if e != nil {
+			e.refs++
+			m.mu.Unlock()
+			return e.db, nil
+		}
+
+		if m.opts.MaxOpen > 0 && len(m.dbs) >= m.opts.MaxOpen && !m.evict() {
+			wake := m.wake
+			m.mu.Unlock()
+
+			select {
+			case <-wake:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			m.mu.Lock()
+			continue
+		}
+
+		break
+	}
+
+	e := &managedDB{path: path, ready: make(chan struct{})}
+	m.dbs[path] = e
+	m.mu.Unlock()
+
+	db, err := OpenDB(path, m.opts.Options...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	close(e.ready)
+
+	if err != nil {
+		m.remove(e)
+		return nil, err
+	}
+
+	if m.closed {
+		db.Close()
+		m.remove(e)
+		return nil, ErrClosed
+	}
+
+	e.db = db
+	e.refs = 1
+	m.byDB[db] = e
+
+	return db, nil
+}
+
+// Release hands back a database returned by Acquire. The database stays
+// open, to be acquired again, until it is evicted, idle for too long or the
+// manager is closed.
+func (m *Manager) Release(db *DB) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.byDB[db]
+	if !ok || e.refs == 0 {
+		return
+	}
+
+	e.refs--
+	e.lastUsed = time.Now()
+
+	m.broadcast()
+}
+
+// Do acquires the database of a data directory, calls fn with it and
+// releases it. It takes a context, the path of the database directory and
+// the function. It returns the error of Acquire or fn.
+func (m *Manager) Do(ctx context.Context, dbPath string, fn func(db *DB) error) error {
+	db, err := m.Acquire(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer m.Release(db)
+
+	return fn(db)
+}
+
+// Open returns the paths of the databases that are open, in no particular
+// order.
+func (m *Manager) Open() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths := make([]string, 0, len(m.dbs))
+	for path, e := range m.dbs {
+		if e.db != nil {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// Close closes every open database, whether it is in use or not, and makes
+// Acquire return ErrClosed. It returns the first error encountered.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	close(m.stop)
+	m.broadcast()
+
+	var dbs []*DB
+	for _, e := range m.dbs {
+		if e.db != nil {
+			dbs = append(dbs, e.db)
+			m.remove(e)
+		}
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+
+	var err error
+
+	for _, db := range dbs {
+		if cerr := db.Close(); err == nil && !errors.Is(cerr, ErrClosed) {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// evict closes the open database released longest ago that is not in use.
+// It reports whether there was one. The caller must hold m.mu.
+func (m *Manager) evict() bool {
+	var oldest *managedDB
+
+	for _, e := range m.dbs {
+		if e.db != nil && e.refs == 0 && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+			oldest = e
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	m.remove(oldest)
+	oldest.db.Close()
+
+	return true
+}
+
+// closeIdle closes the databases that were not used for longer than the idle
+// timeout, until the manager is closed.
+func (m *Manager) closeIdle() {
+	defer m.wg.Done()
+
+	interval := m.opts.IdleTimeout / 2
+	if interval <= 0 {
+		interval = m.opts.IdleTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		for _, e := range m.dbs {
+			if e.db != nil && e.refs == 0 && time.Since(e.lastUsed) > m.opts.IdleTimeout {
+				m.remove(e)
+				e.db.Close()
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// remove forgets a database, waking the callers waiting for room. The caller
+// must hold m.mu.
+func (m *Manager) remove(e *managedDB) {
+	if m.dbs[e.path] == e {
+		delete(m.dbs, e.path)
+	}
+	if e.db != nil {
+		delete(m.byDB, e.db)
+	}
+
+	m.broadcast()
+}
+
+// broadcast wakes every caller waiting for a database to be released. The
+// caller must hold m.mu.
+func (m *Manager) broadcast() {
+	close(m.wake)
+	m.wake = make(chan struct{})
+}
@@ -0,0 +1,147 @@
+package ivy
+
+import (
+	"sort"
+	"sync"
+)
+
+// memEngine keeps all tables in memory.
+type memEngine struct {
+	mu     sync.RWMutex
+	tables map[string]map[string][]byte
+}
+
+// newMemEngine returns an empty in-memory storage engine.
+func newMemEngine() *memEngine {
+	return &memEngine{tables: make(map[string]map[string][]byte)}
+}
+
+func (e *memEngine) checkDB() error {
+	return nil
+}
+
+func (e *memEngine) tableNames() ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var names []string
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// checkTable creates a table if it does not exist yet.
+func (e *memEngine) checkTable(tblName string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.tables[tblName]; !ok {
+		e.tables[tblName] = make(map[string][]byte)
+	}
+
+	return nil
+}
+
+func (e *memEngine) createTable(tblName string) error {
+	return e.checkTable(tblName)
+}
+
+func (e *memEngine) ids(tblName string) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	tbl, ok := e.tables[tblName]
+	if !ok {
+		return nil, notExist("open", tblName, "")
+	}
+
+	ids := make([]string, 0, len(tbl))
+	for id := range tbl {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (e *memEngine) read(tblName string, fileId string) ([]byte, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	data, ok := e.tables[tblName][fileId]
+	if !ok {
+		return nil, notExist("open", tblName, fileId)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+func (e *memEngine) write(tblName string, fileId string, data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tbl, ok := e.tables[tblName]
+	if !ok {
+		return notExist("open", tblName, fileId)
+	}
+
+	tbl[fileId] = append([]byte(nil), data...)
+
+	return nil
+}
+
+func (e *memEngine) remove(tblName string, fileId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.tables[tblName][fileId]; !ok {
+		return notExist("remove", tblName, fileId)
+	}
+
+	delete(e.tables[tblName], fileId)
+
+	return nil
+}
+
+// removeTemp has nothing to do; the memory engine never writes temporary files.
+func (e *memEngine) removeTemp(tblName string) ([]string, error) {
+	return nil, nil
+}
+
+// fingerprint returns "", since in-memory tables are never checkpointed.
+func (e *memEngine) fingerprint(tblName string) (string, error) {
+	return "", nil
+}
+
+func (e *memEngine) stat(tblName string) (tblStat, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	tbl, ok := e.tables[tblName]
+	if !ok {
+		return tblStat{}, notExist("open", tblName, "")
+	}
+
+	st := tblStat{records: len(tbl)}
+	for _, data := range tbl {
+		st.bytes += int64(len(data))
+	}
+
+	return st, nil
+}
+
+// compact does nothing, as removed records leave no space behind.
+func (e *memEngine) compact(tblName string) error {
+	return nil
+}
+
+func (e *memEngine) sync() error {
+	return nil
+}
+
+func (e *memEngine) close() error {
+	return nil
+}
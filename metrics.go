@@ -0,0 +1,305 @@
+package ivy
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricBounds are the upper bounds, in seconds, of the buckets of the
+// duration histograms.
+var metricBounds = []float64{0.0001, 0.001, 0.01, 0.1, 1, 10}
+
+// Type Metrics is a struct holding a snapshot of the metrics of a database,
+// as returned by DB.Metrics. Tables holds the metrics of every table that has
+// seen any activity since the database was opened.
+type Metrics struct {
+	Tables map[string]TableMetrics `json:"tables"`
+}
+
+// Type TableMetrics is a struct holding the metrics of a table. Ops counts
+// the operations by kind: find, query, create, update and delete. Queries
+// answered from an index count as IndexHits, the others as Scans, which read
+// every record of the table; their ratio is the hit rate of the indexes.
+// BytesRead and BytesWritten count the bytes of the stored records.
+type TableMetrics struct {
+	Ops           map[string]uint64 `json:"ops"`
+	BytesRead     uint64            `json:"bytes_read"`
+	BytesWritten  uint64            `json:"bytes_written"`
+	IndexHits     uint64            `json:"index_hits"`
+	Scans         uint64            `json:"scans"`
+	ScanTime      Histogram         `json:"scan_time"`
+	IndexRebuilds Histogram         `json:"index_rebuilds"`
+}
+
+// Type Histogram is a struct holding the distribution of a duration. Counts
+// holds, for every bound of Bounds, in seconds, the number of observations
+// that took at most that long. Count is the number of observations and Sum
+// their total, in seconds.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Count  uint64    `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+// metrics holds the counters of a database, and when each table was last
+// written.
+type metrics struct {
+	mu         sync.Mutex
+	tables     map[string]*TableMetrics
+	lastWrites map[string]time.Time
+}
+
+// tbl returns the metrics of a table, adding them if necessary. The caller
+// must hold m.mu.
+func (m *metrics) tbl(tblName string) *TableMetrics {
+	if m.tables == nil {
+		m.tables = make(map[string]*TableMetrics)
+	}
+
+	tm, ok := m.tables[tblName]
+	if !ok {
+		tm = &TableMetrics{Ops: make(map[string]uint64)}
+		m.tables[tblName] = tm
+	}
+
+	return tm
+}
+
+// countOp counts an operation on a table.
+func (m *metrics) countOp(tblName string, op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tbl(tblName).Ops[op]++
+
+	if op == "create" || op == "update" || op == "delete" {
+		if m.lastWrites == nil {
+			m.lastWrites = make(map[string]time.Time)
+		}
+		m.lastWrites[tblName] = time.Now()
+	}
+}
+
+// countRead counts the bytes of a record read from a table.
+func (m *metrics) countRead(tblName string, n int) {
+	m.mu.Lock()
+	m.tbl(tblName).BytesRead += uint64(n)
+	m.mu.Unlock()
+}
+
+// countWrite counts the bytes of a record written to a table.
+func (m *metrics) countWrite(tblName string, n int) {
+	m.mu.Lock()
+	m.tbl(tblName).BytesWritten += uint64(n)
+	m.mu.Unlock()
+}
+
+// countIndexHit counts a query answered from an index.
+func (m *metrics) countIndexHit(tblName string) {
+	m.mu.Lock()
+	m.tbl(tblName).IndexHits++
+	m.mu.Unlock()
+}
+
+// observeScan counts a query that read every record of a table.
+func (m *metrics) observeScan(tblName string, d time.Duration) {
+	m.mu.Lock()
+	tm := m.tbl(tblName)
+	tm.Scans++
+	tm.ScanTime.observe(d)
+	m.mu.Unlock()
+}
+
+// observeRebuild records how long rebuilding the indexes of a table took.
+func (m *metrics) observeRebuild(tblName string, d time.Duration) {
+	m.mu.Lock()
+	m.tbl(tblName).IndexRebuilds.observe(d)
+	m.mu.Unlock()
+}
+
+// hitRate returns the share of the queries of a table answered from an index,
+// and when the table was last written, if it was since the database was
+// opened.
+func (m *metrics) hitRate(tblName string) (float64, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rate float64
+
+	if tm, ok := m.tables[tblName]; ok && tm.IndexHits+tm.Scans > 0 {
+		rate = float64(tm.IndexHits) / float64(tm.IndexHits+tm.Scans)
+	}
+
+	return rate, m.lastWrites[tblName]
+}
+
+// snapshot returns a copy of the metrics.
+func (m *metrics) snapshot() *Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := &Metrics{Tables: make(map[string]TableMetrics)}
+
+	for tblName, tm := range m.tables {
+		c := *tm
+
+		c.Ops = make(map[string]uint64, len(tm.Ops))
+		for op, n := range tm.Ops {
+			c.Ops[op] = n
+		}
+
+		c.ScanTime = tm.ScanTime.copy()
+		c.IndexRebuilds = tm.IndexRebuilds.copy()
+
+		snap.Tables[tblName] = c
+	}
+
+	return snap
+}
+
+// observe adds a duration to a histogram.
+func (h *Histogram) observe(d time.Duration) {
+	if h.Bounds == nil {
+		h.Bounds = metricBounds
+		h.Counts = make([]uint64, len(metricBounds))
+	}
+
+	secs := d.Seconds()
+
+	for i, bound := range h.Bounds {
+		if secs <= bound {
+			h.Counts[i]++
+		}
+	}
+
+	h.Count++
+	h.Sum += secs
+}
+
+// copy returns a copy of a histogram that does not share its counts.
+func (h Histogram) copy() Histogram {
+	if h.Bounds == nil {
+		h.Bounds = metricBounds
+		h.Counts = make([]uint64, len(metricBounds))
+		return h
+	}
+
+	h.Counts = append([]uint64(nil), h.Counts...)
+
+	return h
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Metrics returns a snapshot of the metrics the database has collected since
+// it was opened.
+func (db *DB) Metrics() *Metrics {
+	return db.metrics.snapshot()
+}
+
+// PublishExpvar publishes the metrics of the database as an expvar variable
+// with the supplied name, so that they are served as JSON by the /debug/vars
+// handler of the expvar package. Like expvar.Publish, it panics if the name
+// is already in use.
+func (db *DB) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return db.Metrics()
+	}))
+}
+
+// MetricsHandler returns an HTTP handler serving the metrics of the database
+// in the Prometheus text format, for a Prometheus server to scrape. Every
+// metric is named with an "ivy_" prefix and labelled with its table.
+func (db *DB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, db.Metrics())
+		bw.Flush()
+	})
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// writePrometheus writes metrics in the Prometheus text format.
+func writePrometheus(w *bufio.Writer, m *Metrics) {
+	tblNames := make([]string, 0, len(m.Tables))
+	for tblName := range m.Tables {
+		tblNames = append(tblNames, tblName)
+	}
+	sort.Strings(tblNames)
+
+	counter := func(name string, help string, value func(tm TableMetrics) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, tblName := range tblNames {
+			fmt.Fprintf(w, "%s{table=%s} %d\n", name, promLabel(tblName), value(m.Tables[tblName]))
+		}
+	}
+
+	histogram := func(name string, help string, value func(tm TableMetrics) Histogram) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, tblName := range tblNames {
+			h := value(m.Tables[tblName])
+			label := promLabel(tblName)
+
+			for i, bound := range h.Bounds {
+				fmt.Fprintf(w, "%s_bucket{table=%s,le=\"%s\"} %d\n", name, label, promFloat(bound), h.Counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{table=%s,le=\"+Inf\"} %d\n", name, label, h.Count)
+			fmt.Fprintf(w, "%s_sum{table=%s} %s\n", name, label, promFloat(h.Sum))
+			fmt.Fprintf(w, "%s_count{table=%s} %d\n", name, label, h.Count)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP ivy_operations_total Operations by table and kind.\n# TYPE ivy_operations_total counter\n")
+	for _, tblName := range tblNames {
+		tm := m.Tables[tblName]
+
+		ops := make([]string, 0, len(tm.Ops))
+		for op := range tm.Ops {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+
+		for _, op := range ops {
+			fmt.Fprintf(w, "ivy_operations_total{table=%s,op=%s} %d\n", promLabel(tblName), promLabel(op), tm.Ops[op])
+		}
+	}
+
+	counter("ivy_read_bytes_total", "Bytes of records read.",
+		func(tm TableMetrics) uint64 { return tm.BytesRead })
+	counter("ivy_written_bytes_total", "Bytes of records written.",
+		func(tm TableMetrics) uint64 { return tm.BytesWritten })
+	counter("ivy_index_hits_total", "Queries answered from an index.",
+		func(tm TableMetrics) uint64 { return tm.IndexHits })
+	counter("ivy_scans_total", "Queries that read every record of a table.",
+		func(tm TableMetrics) uint64 { return tm.Scans })
+	histogram("ivy_scan_duration_seconds", "Duration of table scans.",
+		func(tm TableMetrics) Histogram { return tm.ScanTime })
+	histogram("ivy_index_rebuild_duration_seconds", "Duration of index rebuilds.",
+		func(tm TableMetrics) Histogram { return tm.IndexRebuilds })
+}
+
+// promLabel quotes a Prometheus label value.
+func promLabel(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// promFloat formats a Prometheus sample value.
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package ivy
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported answers whether memory-mapped reads are available on this
+// platform.
+const mmapSupported = false
+
+// mmapFile always fails on platforms without mmap support.
+func mmapFile(f *os.File, length int) ([]byte, error) {
+	return nil, errors.New("ivy: mmap is not supported on this platform")
+}
+
+// munmapFile is a no-op on platforms without mmap support.
+func munmapFile(mapping []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package ivy
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapSupported answers whether memory-mapped reads are available on this
+// platform.
+const mmapSupported = true
+
+// mmapFile maps length bytes of a file read-only into memory. The mapping may
+// extend past the end of the file, as long as the caller never reads beyond
+// the current file size.
+func mmapFile(f *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile releases a mapping returned by mmapFile.
+func munmapFile(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
@@ -0,0 +1,421 @@
+package ivy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type MongoImportOptions is a struct holding the options of DB.ImportMongo.
+type MongoImportOptions struct {
+	// IdField names the field the converted _id of a document is kept in,
+	// such as for other collections referring to it by ObjectId. It defaults
+	// to "_id"; "-" leaves it out.
+	IdField string
+}
+
+// Type MongoDocError is a struct describing a document of a MongoDB export
+// that could not be imported. Doc counts the documents from 1.
+type MongoDocError struct {
+	Doc int
+	Err error
+}
+
+func (e *MongoDocError) Error() string {
+	return fmt.Sprintf("ivy: MongoDB document %d: %v", e.Doc, e.Err)
+}
+
+func (e *MongoDocError) Unwrap() error {
+	return e.Err
+}
+
+// Type MongoImportReport is a struct describing the result of ImportMongo.
+// Ids holds the ids of the created records, in the order of the documents,
+// and Remapped the id given to every document whose _id could not be kept,
+// keyed by the converted _id, such as the hex string of an ObjectId. Errors
+// holds the documents that were not imported.
+type MongoImportReport struct {
+	Ids      []string
+	Remapped map[string]string
+	Errors   []*MongoDocError
+}
+
+// mongoDoc is a document of a MongoDB export, converted to plain JSON values.
+type mongoDoc struct {
+	num int
+	id  interface{}
+	rec map[string]interface{}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// ImportMongo creates a record for every document of a collection exported
+// by mongoexport, in MongoDB Extended JSON, canonical or relaxed, as one
+// document per line or as a JSON array. The table is created, unindexed, if
+// it does not exist, so that a database is migrated by importing every
+// collection into a table of its own. The type wrappers of Extended JSON are
+// converted to plain values: ObjectIds, UUIDs and binary data to strings,
+// dates to RFC 3339 strings in UTC, and numbers to numbers. If the _id of
+// every document is a positive integer and no record has it yet, the
+// records keep them as their ids; otherwise, such as for ObjectIds, every
+// document gets the next free id, in the order of the export, and the
+// report maps the _ids to them. All records are created in one go, holding
+// the table's lock. A document that cannot be converted or stored is
+// reported and skipped. It takes a table name, the reader to read the export
+// from, and the options. It returns a report of the import and any error
+// that stopped it.
+func (db *DB) ImportMongo(tblName string, r io.Reader, opts MongoImportOptions) (*MongoImportReport, error) {
+	return db.ImportMongoCtx(context.Background(), tblName, r, opts)
+}
+
+// ImportMongoCtx is ImportMongo with a context, which is handed to the
+// authorizer.
+func (db *DB) ImportMongoCtx(ctx context.Context, tblName string, r io.Reader, opts MongoImportOptions) (_ *MongoImportReport, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "import")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if opts.IdField == "" {
+		opts.IdField = "_id"
+	}
+
+	report := &MongoImportReport{Remapped: make(map[string]string)}
+
+	docs, err := readMongoDocs(r, report)
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		rwLock, err = db.addTable(tblName, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rwLock.Lock()
+	}
+	defer rwLock.Unlock()
+
+	keep, err := db.mongoIdsFree(tblName, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	nextId, err := db.nextAvailableFileId(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	next := idNum(nextId)
+
+	for _, doc := range docs {
+		fileId := strconv.Itoa(next)
+		if keep {
+			fileId = mongoId(doc.id)
+		}
+
+		if opts.IdField != "-" && doc.id != nil {
+			doc.rec[opts.IdField] = doc.id
+		}
+
+		data, err := json.Marshal(doc.rec)
+		if err == nil {
+			err = db.writeRec(ctx, tblName, fileId, data, false)
+		}
+		if errors.Is(err, ErrRecordTooLarge) || errors.Is(err, ErrQuotaExceeded) {
+			report.Errors = append(report.Errors, &MongoDocError{Doc: doc.num, Err: err})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if !keep {
+			if doc.id != nil {
+				report.Remapped[mongoId(doc.id)] = fileId
+			}
+			next++
+		}
+
+		report.Ids = append(report.Ids, fileId)
+	}
+
+	db.logger.Info("ivy: MongoDB collection imported", "table", tblName, "records", len(report.Ids), "errors", len(report.Errors))
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// mongoIdsFree reports whether the _ids of the documents can be kept as the
+// ids of their records: every one a distinct positive integer not taken by a
+// record of the table. The caller must hold the table's lock.
+func (db *DB) mongoIdsFree(tblName string, docs []mongoDoc) (bool, error) {
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return false, err
+	}
+
+	taken := make(map[string]bool, len(fileIds)+len(docs))
+	for _, fileId := range fileIds {
+		taken[fileId] = true
+	}
+
+	for _, doc := range docs {
+		n, ok := doc.id.(json.Number)
+		if !ok {
+			return false, nil
+		}
+
+		i, err := strconv.Atoi(n.String())
+		if err != nil || i <= 0 || strconv.Itoa(i) != n.String() || taken[n.String()] {
+			return false, nil
+		}
+
+		taken[n.String()] = true
+	}
+
+	return true, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// readMongoDocs reads and converts the documents of a MongoDB export. The
+// documents that cannot be converted are added to the errors of the report.
+func readMongoDocs(r io.Reader, report *MongoImportReport) ([]mongoDoc, error) {
+	br := bufio.NewReader(r)
+
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+
+	array := false
+
+	// A JSON array, as written by mongoexport --jsonArray, rather than a
+	// document per line.
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+
+		if b[0] == '[' {
+			array = true
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+
+		break
+	}
+
+	var docs []mongoDoc
+
+	for num := 1; ; num++ {
+		if array && !dec.More() {
+			break
+		}
+
+		var raw map[string]interface{}
+
+		err := dec.Decode(&raw)
+		if err == io.EOF && !array {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ivy: MongoDB document %d: %v", num, err)
+		}
+
+		value, err := mongoValue(raw)
+		if err != nil {
+			report.Errors = append(report.Errors, &MongoDocError{Doc: num, Err: err})
+			continue
+		}
+
+		rec, ok := value.(map[string]interface{})
+		if !ok {
+			report.Errors = append(report.Errors, &MongoDocError{Doc: num, Err: errors.New("not a document")})
+			continue
+		}
+
+		id := rec["_id"]
+		delete(rec, "_id")
+
+		docs = append(docs, mongoDoc{num: num, id: id, rec: rec})
+	}
+
+	return docs, nil
+}
+
+// mongoValue converts a value of MongoDB Extended JSON to a plain JSON value,
+// replacing the type wrappers, such as {"$oid": "..."}, in objects and arrays
+// at any depth.
+func mongoValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			value, err := mongoValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+
+		return v, nil
+	case map[string]interface{}:
+		if value, ok, err := mongoWrapper(v); ok || err != nil {
+			return value, err
+		}
+
+		for key, elem := range v {
+			value, err := mongoValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = value
+		}
+
+		return v, nil
+	}
+
+	return v, nil
+}
+
+// mongoWrapper converts an object that is a type wrapper of MongoDB Extended
+// JSON. It reports whether the object was one.
+func mongoWrapper(obj map[string]interface{}) (interface{}, bool, error) {
+	if len(obj) == 0 || len(obj) > 2 {
+		return nil, false, nil
+	}
+
+	str := func(key string) string {
+		s, _ := obj[key].(string)
+		return s
+	}
+
+	switch {
+	case len(obj) == 1 && obj["$oid"] != nil:
+		return str("$oid"), true, nil
+	case len(obj) == 1 && (obj["$numberInt"] != nil || obj["$numberLong"] != nil || obj["$numberDecimal"] != nil):
+		for _, key := range []string{"$numberInt", "$numberLong", "$numberDecimal"} {
+			if s := str(key); s != "" {
+				if _, err := strconv.ParseFloat(s, 64); err != nil {
+					return nil, true, fmt.Errorf("invalid %s %q", key, s)
+				}
+				return json.Number(s), true, nil
+			}
+		}
+	case len(obj) == 1 && obj["$numberDouble"] != nil:
+		s := str("$numberDouble")
+		if _, err := strconv.ParseFloat(s, 64); err != nil || s == "NaN" || strings.HasSuffix(s, "Infinity") {
+			// JSON has no infinities or NaN.
+			return s, true, nil
+		}
+		return json.Number(s), true, nil
+	case len(obj) == 1 && obj["$date"] != nil:
+		t, err := mongoDate(obj["$date"])
+		if err != nil {
+			return nil, true, err
+		}
+		return t.UTC().Format(time.RFC3339Nano), true, nil
+	case len(obj) == 1 && obj["$binary"] != nil:
+		if b, ok := obj["$binary"].(map[string]interface{}); ok {
+			s, _ := b["base64"].(string)
+			return s, true, nil
+		}
+		return str("$binary"), true, nil
+	case len(obj) == 2 && obj["$binary"] != nil && obj["$type"] != nil:
+		return str("$binary"), true, nil
+	case len(obj) == 1 && obj["$uuid"] != nil:
+		return str("$uuid"), true, nil
+	case len(obj) == 1 && obj["$symbol"] != nil:
+		return str("$symbol"), true, nil
+	case len(obj) == 1 && obj["$code"] != nil:
+		return str("$code"), true, nil
+	case len(obj) == 1 && obj["$regularExpression"] != nil:
+		re, _ := obj["$regularExpression"].(map[string]interface{})
+		pattern, _ := re["pattern"].(string)
+		options, _ := re["options"].(string)
+		return "/" + pattern + "/" + options, true, nil
+	case len(obj) == 2 && obj["$regex"] != nil && obj["$options"] != nil:
+		return "/" + str("$regex") + "/" + str("$options"), true, nil
+	case len(obj) == 1 && obj["$timestamp"] != nil:
+		ts, _ := obj["$timestamp"].(map[string]interface{})
+		if t, ok := ts["t"].(json.Number); ok {
+			return t, true, nil
+		}
+		return nil, true, errors.New("invalid $timestamp")
+	case len(obj) == 1 && (obj["$minKey"] != nil || obj["$maxKey"] != nil || obj["$undefined"] != nil):
+		return nil, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// mongoId returns the converted _id of a document as a string: strings as
+// they are, and other values as JSON.
+func mongoId(id interface{}) string {
+	if s, ok := id.(string); ok {
+		return s
+	}
+
+	data, _ := json.Marshal(id)
+
+	return string(data)
+}
+
+// mongoDate returns the time of the value of a $date wrapper: an ISO-8601
+// string, or milliseconds since the epoch, as a number or a $numberLong.
+func mongoDate(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999Z0700"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	case json.Number:
+		if ms, err := v.Int64(); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+	case map[string]interface{}:
+		if s, ok := v["$numberLong"].(string); ok {
+			if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return time.UnixMilli(ms), nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid $date %v", v)
+}
@@ -0,0 +1,157 @@
+package ivy
+
+import (
+	"sync"
+)
+
+// versionStore keeps the versions of records replaced since the readers
+// that are still open started, for multiversion concurrency control; see
+// Options.MVCC. Every write is given a version number, and a transaction the
+// same one for all its writes. A reader started at version r sees every
+// write up to r and none after: a record is read from the first of its old
+// versions that was replaced after r, or else from the storage engine.
+type versionStore struct {
+	mu      sync.Mutex
+	next    uint64
+	current uint64
+	hist    map[string]map[string][]recVersion
+	readers map[uint64]int
+}
+
+// recVersion is a version of a record, valid until the write with version
+// until replaced it. A nil data is a record that did not exist.
+type recVersion struct {
+	until uint64
+	data  []byte
+}
+
+// newVersionStore returns an empty version store.
+func newVersionStore() *versionStore {
+	return &versionStore{
+		hist:    make(map[string]map[string][]recVersion),
+		readers: make(map[uint64]int),
+	}
+}
+
+// begin returns the version of a new write or transaction, to publish when
+// it is done.
+func (v *versionStore) begin() uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.next++
+
+	return v.next
+}
+
+// publish makes a version visible to the readers started from now on.
+func (v *versionStore) publish(version uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if version > v.current {
+		v.current = version
+	}
+}
+
+// save keeps the stored version of a record about to be replaced by a write
+// with the supplied version. old is nil if the record is new.
+func (v *versionStore) save(tblName string, fileId string, old []byte, version uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.hist[tblName] == nil {
+		v.hist[tblName] = make(map[string][]recVersion)
+	}
+
+	if old != nil {
+		old = append([]byte(nil), old...)
+	}
+
+	v.hist[tblName][fileId] = append(v.hist[tblName][fileId], recVersion{until: version, data: old})
+}
+
+// pin starts a reader at the current version, which it returns. The versions
+// the reader may need are kept until unpin is called.
+func (v *versionStore) pin() uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.readers[v.current]++
+
+	return v.current
+}
+
+// unpin ends a reader started by pin.
+func (v *versionStore) unpin(version uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.readers[version]--; v.readers[version] <= 0 {
+		delete(v.readers, version)
+	}
+}
+
+// at returns the stored version of a record a reader started at a version
+// sees, and whether the record changed since, as otherwise the reader sees
+// the record of the storage engine. A nil version is a record that did not
+// exist.
+func (v *versionStore) at(tblName string, fileId string, version uint64) ([]byte, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, rv := range v.hist[tblName][fileId] {
+		if rv.until > version {
+			return rv.data, true
+		}
+	}
+
+	return nil, false
+}
+
+// ids returns the ids of the records of a table with old versions.
+func (v *versionStore) ids(tblName string) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fileIds := make([]string, 0, len(v.hist[tblName]))
+	for fileId := range v.hist[tblName] {
+		fileIds = append(fileIds, fileId)
+	}
+
+	return fileIds
+}
+
+// collect drops the old versions of the records of a table that no reader
+// can see anymore. It returns the number of versions dropped.
+func (v *versionStore) collect(tblName string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Readers started from now on start at the current version.
+	oldest := v.current
+	for version := range v.readers {
+		if version < oldest {
+			oldest = version
+		}
+	}
+
+	n := 0
+
+	for fileId, versions := range v.hist[tblName] {
+		i := 0
+		for i < len(versions) && versions[i].until <= oldest {
+			i++
+		}
+
+		n += i
+
+		if i == len(versions) {
+			delete(v.hist[tblName], fileId)
+		} else {
+			v.hist[tblName][fileId] = versions[i:]
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,219 @@
+package ivy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// namespacesDir is the directory of the database directory holding the
+// directories of its namespaces. It is hidden, so that it is not taken for a
+// table.
+const namespacesDir = ".namespaces"
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Namespace returns the namespace of a tenant, such as a customer of a SaaS
+// application: a database of its own, in a subdirectory of the database
+// directory, with its own tables, indexes and locks, so that the data of one
+// tenant never mixes with another's. The namespace is opened the first time
+// it is asked for, with the options the database was opened with, and its
+// tables are indexed like the database's. It shares the database's logger,
+// access log and webhooks, whose entries and events name the namespace, but
+// runs no jobs of its own. Closing the database closes its namespaces. A
+// namespace closed on its own is opened again when it is next asked for. It
+// takes the name of the namespace, which may not contain path separators or
+// start with a dot. It returns the namespace and any error encountered.
+func (db *DB) Namespace(name string) (*DB, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	if err := checkNamespace(name); err != nil {
+		return nil, err
+	}
+
+	db.nsMu.Lock()
+	defer db.nsMu.Unlock()
+
+	if ns, ok := db.namespaces[name]; ok && !ns.isClosed() {
+		return ns, nil
+	}
+
+	ns, err := db.openNamespace(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.namespaces == nil {
+		db.namespaces = make(map[string]*DB)
+	}
+	db.namespaces[name] = ns
+
+	return ns, nil
+}
+
+// Namespaces returns the names of the namespaces of the database, in order,
+// including those that were not opened since the database was. It returns
+// any error encountered.
+func (db *DB) Namespaces() ([]string, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	names := make(map[string]bool)
+
+	db.nsMu.Lock()
+	for name := range db.namespaces {
+		names[name] = true
+	}
+	db.nsMu.Unlock()
+
+	if db.path != "" {
+		files, err := db.fs.ReadDir(filepath.Join(db.path, namespacesDir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() && !isHidden(file.Name()) {
+				names[file.Name()] = true
+			}
+		}
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// openNamespace opens a namespace, creating its directory and the tables the
+// database indexes if necessary. The caller must hold db.nsMu.
+func (db *DB) openNamespace(name string) (*DB, error) {
+	opts := db.opts
+	opts.Jobs = nil
+	opts.AccessLog = nil
+	opts.Webhooks = nil
+	opts.Logger = db.logger.With("namespace", db.namespacePath(name))
+
+	if opts.WAL != nil && opts.WAL.ArchiveDir != "" {
+		walOpts := *opts.WAL
+		walOpts.ArchiveDir = filepath.Join(walOpts.ArchiveDir, name)
+		opts.WAL = &walOpts
+	}
+
+	db.tblMu.RLock()
+	fieldsToIndex := make(map[string][]string, len(db.fieldsToIndex))
+	for tblName, fldNames := range db.fieldsToIndex {
+		fieldsToIndex[tblName] = fldNames
+	}
+	db.tblMu.RUnlock()
+
+	path := ""
+
+	if db.path != "" {
+		path = filepath.Join(db.path, namespacesDir, name)
+
+		if !db.readOnly {
+			err := db.fs.MkdirAll(path, db.modes.dir)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !db.readOnly && opts.Storage == FileStorage {
+			layout := opts.Layout
+			if layout == nil {
+				layout = &DefaultLayout{}
+			}
+
+			for tblName := range fieldsToIndex {
+				err := db.fs.MkdirAll(layout.TablePath(path, tblName), db.modes.dir)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	ns, err := OpenDBWithOptions(path, fieldsToIndex, opts)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: namespace %s: %w", name, err)
+	}
+
+	ns.namespace = db.namespacePath(name)
+	ns.accessLog = db.accessLog
+	ns.webhooks = db.webhooks
+
+	return ns, nil
+}
+
+// closeNamespaces closes the namespaces opened by the database. It returns
+// the first error encountered.
+func (db *DB) closeNamespaces() error {
+	db.nsMu.Lock()
+	namespaces := db.namespaces
+	db.namespaces = nil
+	db.nsMu.Unlock()
+
+	var err error
+
+	for _, ns := range namespaces {
+		if ns.isClosed() {
+			continue
+		}
+
+		if cerr := ns.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// namespacePath returns the name of a namespace of the database as it is
+// shown in logs and events, including the namespaces the database is nested
+// in.
+func (db *DB) namespacePath(name string) string {
+	if db.namespace == "" {
+		return name
+	}
+
+	return db.namespace + "/" + name
+}
+
+// isClosed reports whether the database is closed or closing.
+func (db *DB) isClosed() bool {
+	db.stateMu.Lock()
+	defer db.stateMu.Unlock()
+
+	return db.closed
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// checkNamespace returns an error if a namespace name cannot be used as the
+// name of a directory.
+func checkNamespace(name string) error {
+	if name == "" || isHidden(name) || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return fmt.Errorf("ivy: invalid namespace name %q", name)
+	}
+
+	return nil
+}
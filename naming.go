@@ -0,0 +1,121 @@
+package ivy
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// defaultExt is the extension of record files when none is configured.
+const defaultExt = ".json"
+
+// maxSlugLen is the maximum length of the slug part of a file name.
+const maxSlugLen = 40
+
+// Type FileNaming configures how the record files of a table are named by
+// DefaultLayout and ShardedLayout. The zero value gives the default scheme, "<id>.json".
+// Record ids passed to and returned from the DB methods are never padded and
+// never include the slug.
+type FileNaming struct {
+	// Ext is the file name extension, including the leading dot. It defaults
+	// to ".json".
+	Ext string
+
+	// Pad zero-pads ids to at least this many digits (000123.json), so that
+	// directory listings sort naturally.
+	Pad int
+
+	// SlugField names a string field whose value is appended to the id in a
+	// human-readable form (000123-p-51d.json). When the field changes, the
+	// record file is renamed.
+	SlugField string
+}
+
+// ext returns the configured extension or the default one.
+func (n FileNaming) ext() string {
+	if n.Ext == "" {
+		return defaultExt
+	}
+	return n.Ext
+}
+
+// fileName returns the name of the file holding a record. The marshalled
+// record is only needed if the naming scheme uses a slug.
+func (n FileNaming) fileName(fileId string, data []byte) string {
+	name := fileId
+	if len(name) < n.Pad {
+		name = strings.Repeat("0", n.Pad-len(name)) + name
+	}
+
+	if n.SlugField != "" && data != nil {
+		var rec map[string]interface{}
+		if json.Unmarshal(data, &rec) == nil {
+			if value, ok := rec[n.SlugField].(string); ok {
+				if slug := slugify(value); slug != "" {
+					name += "-" + slug
+				}
+			}
+		}
+	}
+
+	return name + n.ext()
+}
+
+// parseFileName returns the record id encoded in a file name, and whether the
+// file name follows the naming scheme at all.
+func (n FileNaming) parseFileName(name string) (string, bool) {
+	ext := n.ext()
+	if !strings.HasSuffix(name, ext) || len(name) == len(ext) {
+		return "", false
+	}
+
+	fileId := name[:len(name)-len(ext)]
+
+	if n.SlugField != "" {
+		if i := strings.IndexByte(fileId, '-'); i >= 0 {
+			fileId = fileId[:i]
+		}
+	}
+
+	if n.Pad > 0 {
+		fileId = strings.TrimLeft(fileId, "0")
+		if fileId == "" {
+			fileId = "0"
+		}
+	}
+
+	if fileId == "" || isHidden(fileId) {
+		return "", false
+	}
+
+	return fileId, true
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// slugify turns a string into a lowercase, dash separated slug containing
+// only letters and digits.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+
+		if b.Len() >= maxSlugLen {
+			break
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package ivy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Type Option is a function configuring a database opened by OpenDB, such as
+// WithIndexes or WithLogger. Options are applied in order, so a later option
+// overrides an earlier one setting the same thing.
+type Option func(*openConfig)
+
+// openConfig is what the options of OpenDB configure.
+type openConfig struct {
+	fieldsToIndex map[string][]string
+	opts          Options
+}
+
+// Type Codec is an interface for marshalling records to the data of the
+// database and back, such as to use a faster JSON encoder than the standard
+// library's. Queries and indexes read the stored data as JSON, so Marshal has
+// to return a JSON object.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Type JSONCodec is a struct implementing Codec with the encoding/json
+// package. It is the default codec.
+type JSONCodec struct{}
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// WithIndexes indexes tables on fields, keyed by table name; "tags" indexes
+// the tags of a table. It may be given more than once, adding to the fields
+// of the earlier ones.
+func WithIndexes(fieldsToIndex map[string][]string) Option {
+	return func(c *openConfig) {
+		if c.fieldsToIndex == nil {
+			c.fieldsToIndex = make(map[string][]string)
+		}
+
+		for tblName, fldNames := range fieldsToIndex {
+			c.fieldsToIndex[tblName] = append(c.fieldsToIndex[tblName], fldNames...)
+		}
+	}
+}
+
+// WithCodec marshals records with the supplied codec instead of JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(c *openConfig) {
+		c.opts.Codec = codec
+	}
+}
+
+// WithReadOnly opens the database read-only, so that Create, Update and
+// Delete return ErrReadOnly.
+func WithReadOnly() Option {
+	return func(c *openConfig) {
+		c.opts.ReadOnly = true
+	}
+}
+
+// WithUseNumber decodes numbers as json.Number when searching, indexing and
+// querying records; see Options.UseNumber.
+func WithUseNumber() Option {
+	return func(c *openConfig) {
+		c.opts.UseNumber = true
+	}
+}
+
+// WithAnalyzer splits the text of the text searches and text indexes of a
+// table into terms with analyzer; see Options.Analyzers.
+func WithAnalyzer(tblName string, analyzer Analyzer) Option {
+	return func(c *openConfig) {
+		if c.opts.Analyzers == nil {
+			c.opts.Analyzers = make(map[string]Analyzer)
+		}
+
+		c.opts.Analyzers[tblName] = analyzer
+	}
+}
+
+// WithEventTable makes a table an append-only table of immutable events; see
+// Options.EventTables.
+func WithEventTable(tblName string) Option {
+	return func(c *openConfig) {
+		c.opts.EventTables = append(c.opts.EventTables, tblName)
+	}
+}
+
+// WithLogger sends the structured events of the database to logger; see
+// Options.Logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *openConfig) {
+		c.opts.Logger = logger
+	}
+}
+
+// WithStorage selects the storage engine.
+func WithStorage(storage Storage) Option {
+	return func(c *openConfig) {
+		c.opts.Storage = storage
+	}
+}
+
+// WithChecksums stores a checksum with every record; see Options.Checksums.
+func WithChecksums() Option {
+	return func(c *openConfig) {
+		c.opts.Checksums = true
+	}
+}
+
+// WithEncryptionKey encrypts the database with key; see
+// Options.EncryptionKey.
+func WithEncryptionKey(key []byte) Option {
+	return func(c *openConfig) {
+		c.opts.EncryptionKey = key
+	}
+}
+
+// WithEncryptionKeyProvider encrypts the database with the key fetched from
+// p; see Options.EncryptionKeyProvider.
+func WithEncryptionKeyProvider(p KeyProvider) Option {
+	return func(c *openConfig) {
+		c.opts.EncryptionKeyProvider = p
+	}
+}
+
+// WithEncryptedFields encrypts fields of a table with the key set by
+// WithFieldKey; see Options.EncryptedFields.
+func WithEncryptedFields(tblName string, fldNames ...string) Option {
+	return func(c *openConfig) {
+		if c.opts.EncryptedFields == nil {
+			c.opts.EncryptedFields = make(map[string][]string)
+		}
+
+		c.opts.EncryptedFields[tblName] = append(c.opts.EncryptedFields[tblName], fldNames...)
+	}
+}
+
+// WithFieldKey sets the key of the encrypted fields; see Options.FieldKey.
+func WithFieldKey(key []byte) Option {
+	return func(c *openConfig) {
+		c.opts.FieldKey = key
+	}
+}
+
+// WithFieldKeyProvider fetches the key of the encrypted fields from p; see
+// Options.FieldKeyProvider.
+func WithFieldKeyProvider(p KeyProvider) Option {
+	return func(c *openConfig) {
+		c.opts.FieldKeyProvider = p
+	}
+}
+
+// WithKeyTimeout bounds how long fetching a key from a key provider may
+// take; see Options.KeyTimeout.
+func WithKeyTimeout(d time.Duration) Option {
+	return func(c *openConfig) {
+		c.opts.KeyTimeout = d
+	}
+}
+
+// WithRecordKeys seals the encrypted fields of every record of a table with
+// a data key of its own; see Options.RecordKeys.
+func WithRecordKeys(tblName string) Option {
+	return func(c *openConfig) {
+		c.opts.RecordKeys = append(c.opts.RecordKeys, tblName)
+	}
+}
+
+// WithReadPolicy masks fields of a table's records read by a restricted
+// database; see Options.ReadPolicies.
+func WithReadPolicy(tblName string, policy ReadPolicy) Option {
+	return func(c *openConfig) {
+		if c.opts.ReadPolicies == nil {
+			c.opts.ReadPolicies = make(map[string]ReadPolicy)
+		}
+
+		c.opts.ReadPolicies[tblName] = policy
+	}
+}
+
+// WithRestricted applies the read policies; see Options.Restricted.
+func WithRestricted() Option {
+	return func(c *openConfig) {
+		c.opts.Restricted = true
+	}
+}
+
+// WithAuthorizer asks authorizer whether every operation may go ahead; see
+// Options.Authorizer.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(c *openConfig) {
+		c.opts.Authorizer = authorizer
+	}
+}
+
+// WithActorStamps stamps records with the actor of their writes; see
+// Options.ActorStamps.
+func WithActorStamps() Option {
+	return func(c *openConfig) {
+		c.opts.ActorStamps = true
+	}
+}
+
+// WithAuditChain keeps a tamper-evident audit log of every change; see
+// Options.AuditChain.
+func WithAuditChain() Option {
+	return func(c *openConfig) {
+		c.opts.AuditChain = true
+	}
+}
+
+// WithFileModes creates files and directories with the supplied modes; see
+// Options.FileMode and Options.DirMode.
+func WithFileModes(fileMode os.FileMode, dirMode os.FileMode) Option {
+	return func(c *openConfig) {
+		c.opts.FileMode = fileMode
+		c.opts.DirMode = dirMode
+	}
+}
+
+// WithExactModes creates files and directories with their modes regardless
+// of the umask; see Options.ExactModes.
+func WithExactModes() Option {
+	return func(c *openConfig) {
+		c.opts.ExactModes = true
+	}
+}
+
+// WithMVCC keeps the versions of records that open snapshots may read; see
+// Options.MVCC.
+func WithMVCC() Option {
+	return func(c *openConfig) {
+		c.opts.MVCC = true
+	}
+}
+
+// WithWatchFiles polls the record files for edits made by other programs at
+// the supplied interval; see Options.WatchFiles.
+func WithWatchFiles(interval time.Duration) Option {
+	return func(c *openConfig) {
+		c.opts.WatchFiles = interval
+	}
+}
+
+// WithGit commits every change to a record to the git repository the
+// database directory is in; see Options.Git.
+func WithGit(opts GitOptions) Option {
+	return func(c *openConfig) {
+		c.opts.Git = &opts
+	}
+}
+
+// WithStableOutput writes records in a canonical form, pretty-printed with
+// the supplied indent unless it is empty; see Options.StableOutput.
+func WithStableOutput(indent string) Option {
+	return func(c *openConfig) {
+		c.opts.StableOutput = &StableOutputOptions{Indent: indent}
+	}
+}
+
+// WithOutbox keeps the events staged by Tx.Emit in an outbox table and
+// publishes them with the supplied publisher; see Options.Outbox.
+func WithOutbox(publish OutboxPublisher) Option {
+	return func(c *openConfig) {
+		c.opts.Outbox = &OutboxOptions{Publish: publish}
+	}
+}
+
+// WithReconfigure replaces the settings kept in the database's config file
+// with those of the options; see Options.Reconfigure.
+func WithReconfigure() Option {
+	return func(c *openConfig) {
+		c.opts.Reconfigure = true
+	}
+}
+
+// WithPinnedTables keeps every record of the supplied tables in memory; see
+// DB.PinTable.
+func WithPinnedTables(tblNames ...string) Option {
+	return func(c *openConfig) {
+		c.opts.PinnedTables = append(c.opts.PinnedTables, tblNames...)
+	}
+}
+
+// WithWAL turns on the write-ahead log; see Options.WAL.
+func WithWAL(walOpts WALOptions) Option {
+	return func(c *openConfig) {
+		c.opts.WAL = &walOpts
+	}
+}
+
+// WithWriteBehind turns on write-behind mode; see Options.WriteBehind.
+func WithWriteBehind(wbOpts WriteBehindOptions) Option {
+	return func(c *openConfig) {
+		c.opts.WriteBehind = &wbOpts
+	}
+}
+
+// WithOptions replaces all the options set so far, other than the indexes,
+// with opts. It gives OpenDB the settings that have no option of their own.
+func WithOptions(opts Options) Option {
+	return func(c *openConfig) {
+		c.opts = opts
+	}
+}
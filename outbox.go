@@ -0,0 +1,282 @@
+package ivy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultOutboxTable is the default of OutboxOptions.Table.
+const defaultOutboxTable = "outbox"
+
+// Type OutboxEvent is a struct holding an event staged by Tx.Emit, such as a
+// message for a Kafka topic, kept in the outbox table until it is published.
+type OutboxEvent struct {
+	// Id identifies the event. It is set by Emit and stays the same if the
+	// event is published again, so that consumers can drop duplicates.
+	Id string `json:"id"`
+
+	// Topic names where the event goes, such as a Kafka topic.
+	Topic string `json:"topic"`
+
+	// Key is an optional key of the event, such as a Kafka message key.
+	Key string `json:"key,omitempty"`
+
+	// Payload is the JSON body of the event.
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Time is when the event was staged. Emit sets it if it is zero.
+	Time time.Time `json:"time"`
+}
+
+// Type OutboxPublisher is a function publishing an event of the outbox, set
+// in OutboxOptions.Publish, such as by producing a Kafka message or posting
+// to a webhook. Returning an error leaves the event in the outbox, to be
+// published again later, before any event staged after it.
+type OutboxPublisher func(ctx context.Context, event OutboxEvent) error
+
+// Type OutboxOptions is a struct holding the settings of Options.Outbox.
+type OutboxOptions struct {
+	// Table is the table the events are kept in. It is created if it does
+	// not exist, and defaults to "outbox".
+	Table string
+
+	// Publish publishes the events. Without it, events stay in the outbox
+	// table, for a Watcher of the table or another process to deliver.
+	Publish OutboxPublisher
+
+	// Interval is how often events that failed to publish are tried again.
+	// It defaults to a second.
+	Interval time.Duration
+}
+
+// outbox holds the settings of the outbox and wakes up its relay.
+type outbox struct {
+	opts OutboxOptions
+	wake chan struct{}
+}
+
+// Emit stages an event in the outbox, as part of the transaction, so that
+// it is published once the transaction commits and never if it is rolled
+// back; see Options.Outbox. The outbox table is locked until the
+// transaction is over. It takes the event, which needs a topic. It returns
+// the id given to the event and any error encountered.
+func (tx *Tx) Emit(event OutboxEvent) (id string, err error) {
+	db := tx.db
+
+	if db.outbox == nil {
+		return "", errors.New("ivy: emitting events requires Options.Outbox")
+	}
+
+	tblName := db.outbox.opts.Table
+
+	op, err := db.beginOp(tx.ctx, tblName, "", "emit")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	if tx.done {
+		return "", ErrClosed
+	}
+
+	if event.Topic == "" {
+		return "", tx.fail(errors.New("ivy: an outbox event needs a topic"))
+	}
+
+	if db.tblLock(tblName) == nil {
+		return "", tx.fail(tableNotFoundErr(tblName))
+	}
+
+	// Other transactions only wait for the outbox once they emit, after
+	// locking their own tables, which Transact keeps the outbox out of.
+	if !tx.outboxLocked {
+		db.tblLock(tblName).Lock()
+		tx.outboxLocked = true
+	}
+
+	id, err = newEventId()
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	event.Id = id
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	fileId, err := db.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	err = db.writeRec(tx.ctx, tblName, fileId, data, false)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	db.onCommit(tx, db.outbox.notify)
+
+	return id, nil
+}
+
+// notify wakes up the relay of the outbox.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+		// A wake-up is pending already.
+	}
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// startOutbox creates the outbox table and starts the relay publishing its
+// events, if Options.Outbox is set.
+func (db *DB) startOutbox(opts *OutboxOptions) error {
+	if opts == nil {
+		return nil
+	}
+
+	db.outbox = &outbox{opts: *opts, wake: make(chan struct{}, 1)}
+
+	if db.outbox.opts.Table == "" {
+		db.outbox.opts.Table = defaultOutboxTable
+	}
+	if db.outbox.opts.Interval <= 0 {
+		db.outbox.opts.Interval = time.Second
+	}
+
+	tblName := db.outbox.opts.Table
+
+	if db.tblLock(tblName) == nil && !db.readOnly {
+		rwLock, err := db.addTable(tblName, nil)
+		if err != nil {
+			return err
+		}
+		rwLock.Unlock()
+	}
+
+	if db.outbox.opts.Publish == nil || db.readOnly {
+		return nil
+	}
+
+	db.jobsWg.Add(1)
+
+	go func() {
+		defer db.jobsWg.Done()
+
+		ticker := time.NewTicker(db.outbox.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			db.relayOutbox(db.jobsCtx)
+
+			select {
+			case <-db.jobsCtx.Done():
+				return
+			case <-db.outbox.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// relayOutbox publishes the events of the outbox in the order they were
+// staged, removing every event once it is published, until the outbox is
+// empty or an event fails to publish. An event published again after a
+// crash or a failed removal keeps its id.
+func (db *DB) relayOutbox(ctx context.Context) {
+	if err := db.enter(); err != nil {
+		return
+	}
+	defer db.leave()
+
+	// A follower gets the events of its primary, which publishes them.
+	if db.checkWritable() != nil {
+		return
+	}
+
+	tblName := db.outbox.opts.Table
+
+	for ctx.Err() == nil {
+		fileId, event, err := db.nextOutboxEvent(tblName)
+		if err != nil {
+			db.logger.Error("ivy: reading the outbox failed", "table", tblName, "err", err)
+			return
+		}
+		if fileId == "" {
+			return
+		}
+
+		err = db.outbox.opts.Publish(ctx, *event)
+		if err != nil {
+			db.logger.Warn("ivy: publishing an event failed", "topic", event.Topic, "event", event.Id, "err", err)
+			return
+		}
+
+		db.tblLock(tblName).Lock()
+		err = db.removeRec(context.Background(), tblName, fileId)
+		db.tblLock(tblName).Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			db.logger.Error("ivy: removing a published event failed", "event", event.Id, "err", err)
+			return
+		}
+	}
+}
+
+// nextOutboxEvent returns the record id and the event of the oldest event of
+// the outbox, or an empty id if the outbox is empty.
+func (db *DB) nextOutboxEvent(tblName string) (string, *OutboxEvent, error) {
+	db.tblLock(tblName).RLock()
+	defer db.tblLock(tblName).RUnlock()
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil || len(fileIds) == 0 {
+		return "", nil, err
+	}
+
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	data, err := db.readRec(tblName, fileIds[0])
+	if err != nil {
+		return "", nil, err
+	}
+
+	event := &OutboxEvent{}
+
+	err = json.Unmarshal(data, event)
+	if err != nil {
+		return "", nil, corruptErr(tblName, fileIds[0], err.Error())
+	}
+
+	return fileIds[0], event, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// newEventId returns a random id for an outbox event.
+func newEventId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,799 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A packed table file starts with packedMagic, followed by a sequence of
+// slots. Every slot has a fixed size header followed by capacity bytes of
+// payload. The payload of a live slot holds the record id followed by the
+// marshalled record. A live slot is never overwritten: every write goes to a
+// free (or new) slot, and the slot holding the previous version of the record
+// is only marked free once the new one has been written. Every live slot is
+// stamped with a sequence number higher than any before it, so that if a
+// crash leaves two live slots with the same id, the one with the highest
+// sequence number wins. Reading the slot headers when the file is opened
+// rebuilds the offset/length index of every record, as well as the list of
+// free slots.
+//
+// Slot header layout (little endian):
+//
+//	capacity uint32  size of the payload area
+//	flags    uint8   slotLive or slotFree
+//	idLen    uint16  length of the record id
+//	dataLen  uint32  length of the marshalled record
+//	seq      uint64  sequence number of the write
+const (
+	packedMagic      = "IVYPACK1"
+	packedExt        = ".ivy"
+	compactExt       = ".compact"
+	slotHeaderSize   = 19
+	slotFree         = 0
+	slotLive         = 1
+	minSplitCapacity = 64
+	minMappingSize   = 1 << 16
+)
+
+// packedSlot describes the position of a slot in a packed table file.
+type packedSlot struct {
+	offset   int64
+	capacity uint32
+	idLen    uint16
+	dataLen  uint32
+	seq      uint64
+}
+
+// packedEngine stores each table as one data file.
+type packedEngine struct {
+	path   string
+	layout Layout
+	mmap   bool
+	modes  fileModes
+
+	mu     sync.Mutex
+	tables map[string]*packedTable
+}
+
+// newPackedEngine returns a packed storage engine for a database directory.
+// If mmap is true, table files are memory-mapped for reading. Table files are
+// created with the supplied modes.
+func newPackedEngine(dbPath string, layout Layout, mmap bool, modes fileModes) *packedEngine {
+	return &packedEngine{path: dbPath, layout: layout, mmap: mmap && mmapSupported, modes: modes, tables: make(map[string]*packedTable)}
+}
+
+func (e *packedEngine) checkDB() error {
+	_, err := os.Stat(e.path)
+	return err
+}
+
+func (e *packedEngine) tableNames() ([]string, error) {
+	var names []string
+
+	files, err := ioutil.ReadDir(e.path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && !isHidden(file.Name()) && filepath.Ext(file.Name()) == packedExt {
+			names = append(names, strings.TrimSuffix(file.Name(), packedExt))
+		}
+	}
+
+	return names, nil
+}
+
+// checkTable opens the data file of a table, creating it if necessary.
+func (e *packedEngine) checkTable(tblName string) error {
+	_, err := e.table(tblName)
+	return err
+}
+
+func (e *packedEngine) createTable(tblName string) error {
+	return e.checkTable(tblName)
+}
+
+func (e *packedEngine) ids(tblName string) ([]string, error) {
+	t, err := e.table(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.ids(), nil
+}
+
+func (e *packedEngine) read(tblName string, fileId string) ([]byte, error) {
+	t, err := e.table(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.read(tblName, fileId)
+}
+
+func (e *packedEngine) write(tblName string, fileId string, data []byte) error {
+	t, err := e.table(tblName)
+	if err != nil {
+		return err
+	}
+
+	return t.write(fileId, data)
+}
+
+func (e *packedEngine) remove(tblName string, fileId string) error {
+	t, err := e.table(tblName)
+	if err != nil {
+		return err
+	}
+
+	return t.remove(tblName, fileId)
+}
+
+// removeTemp deletes the new table file of a compaction interrupted by a
+// crash, which is the only temporary file the packed engine writes.
+func (e *packedEngine) removeTemp(tblName string) ([]string, error) {
+	tmpPath := e.layout.TablePath(e.path, tblName) + packedExt + compactExt
+
+	err := os.Remove(tmpPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{tmpPath}, nil
+}
+
+// fingerprint is based on the size and modification time of the table file.
+func (e *packedEngine) fingerprint(tblName string) (string, error) {
+	info, err := os.Stat(e.layout.TablePath(e.path, tblName) + packedExt)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v-%v", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func (e *packedEngine) stat(tblName string) (tblStat, error) {
+	t, err := e.table(tblName)
+	if err != nil {
+		return tblStat{}, err
+	}
+
+	info, err := os.Stat(e.layout.TablePath(e.path, tblName) + packedExt)
+	if err != nil {
+		return tblStat{}, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return tblStat{records: len(t.slots), bytes: t.size, modTime: info.ModTime()}, nil
+}
+
+// compact rewrites the data file of a table without its free slots.
+func (e *packedEngine) compact(tblName string) error {
+	t, err := e.table(tblName)
+	if err != nil {
+		return err
+	}
+
+	return t.compact(e.layout.TablePath(e.path, tblName) + packedExt)
+}
+
+func (e *packedEngine) sync() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, t := range e.tables {
+		if err := t.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *packedEngine) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var firstErr error
+
+	for name, t := range e.tables {
+		if err := t.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(e.tables, name)
+	}
+
+	return firstErr
+}
+
+// table returns the open data file of a table, opening it on first use.
+func (e *packedEngine) table(tblName string) (*packedTable, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if t, ok := e.tables[tblName]; ok {
+		return t, nil
+	}
+
+	t, err := openPackedTable(e.layout.TablePath(e.path, tblName)+packedExt, e.mmap, e.modes)
+	if err != nil {
+		return nil, err
+	}
+
+	e.tables[tblName] = t
+
+	return t, nil
+}
+
+//*****************************************************************************
+// packedTable
+//*****************************************************************************
+
+// packedTable is an open packed table file.
+//
+// If the table is memory-mapped, reads copy record bytes out of the mapping
+// instead of issuing read syscalls. Records are always copied, so decoded
+// structs never alias the mapping and stay valid after it is remapped or
+// released.
+type packedTable struct {
+	mu      sync.RWMutex
+	file    *os.File
+	size    int64
+	slots   map[string]*packedSlot
+	free    []*packedSlot
+	seq     uint64
+	mmap    bool
+	mapping []byte
+	modes   fileModes
+
+	// changes counts the writes and removals, so that a compaction can tell
+	// whether the table changed while it was copying the records.
+	changes   uint64
+	compactMu sync.Mutex
+}
+
+// openPackedTable opens (or creates) a packed table file and reads its slot
+// headers.
+func openPackedTable(filename string, mmap bool, modes fileModes) (*packedTable, error) {
+	file, err := modes.openFile(filename, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &packedTable{file: file, slots: make(map[string]*packedSlot), mmap: mmap, modes: modes}
+
+	err = t.load()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ivy: %v: %v", filename, err)
+	}
+
+	err = t.remap()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ivy: %v: %v", filename, err)
+	}
+
+	return t, nil
+}
+
+// close releases the mapping and closes the table file.
+func (t *packedTable) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mapping != nil {
+		munmapFile(t.mapping)
+		t.mapping = nil
+	}
+
+	return t.file.Close()
+}
+
+// remap memory-maps the table file if the current mapping no longer covers it.
+// The mapping is made larger than the file so that appending records does not
+// require remapping every time.
+func (t *packedTable) remap() error {
+	if !t.mmap || int64(len(t.mapping)) >= t.size {
+		return nil
+	}
+
+	if t.mapping != nil {
+		if err := munmapFile(t.mapping); err != nil {
+			return err
+		}
+		t.mapping = nil
+	}
+
+	length := t.size * 2
+	if length < minMappingSize {
+		length = minMappingSize
+	}
+
+	mapping, err := mmapFile(t.file, int(length))
+	if err != nil {
+		return err
+	}
+
+	t.mapping = mapping
+
+	return nil
+}
+
+// load builds the slot index and the free list from the slot headers.
+func (t *packedTable) load() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		_, err = t.file.WriteAt([]byte(packedMagic), 0)
+		t.size = int64(len(packedMagic))
+		return err
+	}
+
+	magic := make([]byte, len(packedMagic))
+	if _, err := t.file.ReadAt(magic, 0); err != nil || string(magic) != packedMagic {
+		return fmt.Errorf("not a packed table file")
+	}
+
+	offset := int64(len(packedMagic))
+	header := make([]byte, slotHeaderSize)
+
+	for offset < info.Size() {
+		if _, err := t.file.ReadAt(header, offset); err != nil {
+			break
+		}
+
+		slot := &packedSlot{
+			offset:   offset,
+			capacity: binary.LittleEndian.Uint32(header[0:4]),
+			idLen:    binary.LittleEndian.Uint16(header[5:7]),
+			dataLen:  binary.LittleEndian.Uint32(header[7:11]),
+			seq:      binary.LittleEndian.Uint64(header[11:19]),
+		}
+
+		end := offset + slotHeaderSize + int64(slot.capacity)
+		if end > info.Size() {
+			break
+		}
+
+		if header[4] == slotLive {
+			id := make([]byte, slot.idLen)
+			if _, err := t.file.ReadAt(id, offset+slotHeaderSize); err != nil {
+				return err
+			}
+
+			if slot.seq > t.seq {
+				t.seq = slot.seq
+			}
+
+			// A crash between writing a record to a new slot and freeing
+			// its old slot leaves both live. The older one is freed now.
+			if other, ok := t.slots[string(id)]; ok {
+				stale := slot
+				if other.seq < slot.seq {
+					stale = other
+					t.slots[string(id)] = slot
+				}
+				if err := t.freeSlot(stale); err != nil {
+					return err
+				}
+			} else {
+				t.slots[string(id)] = slot
+			}
+		} else {
+			t.addFree(slot)
+		}
+
+		offset = end
+	}
+
+	// Anything after the last complete slot is left over from an interrupted
+	// append and can be dropped.
+	if offset < info.Size() {
+		if err := t.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+
+	t.size = offset
+
+	return nil
+}
+
+// ids returns all record ids in the table.
+func (t *packedTable) ids() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]string, 0, len(t.slots))
+	for id := range t.slots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// read returns the marshalled record stored under fileId.
+func (t *packedTable) read(tblName string, fileId string) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	slot, ok := t.slots[fileId]
+	if !ok {
+		return nil, notExist("open", tblName, fileId)
+	}
+
+	start := slot.offset + slotHeaderSize + int64(slot.idLen)
+	end := start + int64(slot.dataLen)
+	data := make([]byte, slot.dataLen)
+
+	// The mapping may extend past the end of the file, so only use it for
+	// slots that lie within the file.
+	if t.mapping != nil && end <= t.size && end <= int64(len(t.mapping)) {
+		copy(data, t.mapping[start:end])
+		return data, nil
+	}
+
+	_, err := t.file.ReadAt(data, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// write stores a marshalled record under fileId. The record is always
+// written to another slot than the one holding its previous version, which is
+// only freed afterwards, so that a crash in the middle of the write leaves the
+// previous version intact.
+func (t *packedTable) write(fileId string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	need := uint32(len(fileId) + len(data))
+
+	slot, err := t.allocate(need)
+	if err != nil {
+		return err
+	}
+
+	t.seq++
+
+	slot.idLen = uint16(len(fileId))
+	slot.dataLen = uint32(len(data))
+	slot.seq = t.seq
+
+	err = t.writeSlot(slot, fileId, data)
+	if err != nil {
+		return err
+	}
+
+	t.changes++
+
+	if old, ok := t.slots[fileId]; ok {
+		if err := t.freeSlot(old); err != nil {
+			return err
+		}
+	}
+
+	t.slots[fileId] = slot
+
+	return t.remap()
+}
+
+// remove marks the slot holding fileId as free.
+func (t *packedTable) remove(tblName string, fileId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slot, ok := t.slots[fileId]
+	if !ok {
+		return notExist("remove", tblName, fileId)
+	}
+
+	err := t.freeSlot(slot)
+	if err != nil {
+		return err
+	}
+
+	t.changes++
+	delete(t.slots, fileId)
+
+	return nil
+}
+
+// compact copies the live records of the table to a new file at filename plus
+// compactExt, packed one after the other, and then renames it over the table
+// file. The records are copied without holding the table's lock, so readers
+// and writers are only held up while the new file replaces the old one. If
+// the table changed while the records were copied, they are copied again
+// while holding the lock.
+func (t *packedTable) compact(filename string) error {
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	tmpPath := filename + compactExt
+
+	t.mu.RLock()
+	if len(t.free) == 0 {
+		t.mu.RUnlock()
+		return nil
+	}
+	changes := t.changes
+	live := t.liveSlots()
+	t.mu.RUnlock()
+
+	tmp, slots, size, err := t.copyLive(tmpPath, live)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.changes != changes {
+		tmp.Close()
+
+		tmp, slots, size, err = t.copyLive(tmpPath, t.liveSlots())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(tmpPath, filename)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Make the rename itself durable, or a crash could bring back the old
+	// file after records were written to the new one.
+	err = syncDir(filepath.Dir(filename))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if t.mapping != nil {
+		munmapFile(t.mapping)
+		t.mapping = nil
+	}
+
+	t.file.Close()
+
+	t.file = tmp
+	t.slots = slots
+	t.free = nil
+	t.size = size
+
+	return t.remap()
+}
+
+// liveSlots returns a copy of the slots of the live records. The caller must
+// hold the table's read lock.
+func (t *packedTable) liveSlots() map[string]packedSlot {
+	live := make(map[string]packedSlot, len(t.slots))
+	for id, slot := range t.slots {
+		live[id] = *slot
+	}
+
+	return live
+}
+
+// copyLive writes the records held in the supplied slots of the table file to
+// a new, synced file at tmpPath, in id order. It returns the open file, its
+// slots and its size.
+func (t *packedTable) copyLive(tmpPath string, live map[string]packedSlot) (*os.File, map[string]*packedSlot, int64, error) {
+	tmp, err := t.modes.openFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	fail := func(err error) (*os.File, map[string]*packedSlot, int64, error) {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return nil, nil, 0, err
+	}
+
+	ids := make([]string, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	buf.WriteString(packedMagic)
+
+	slots := make(map[string]*packedSlot, len(ids))
+	size := int64(len(packedMagic))
+
+	for _, id := range ids {
+		old := live[id]
+
+		payload := make([]byte, int(old.idLen)+int(old.dataLen))
+		if _, err := t.file.ReadAt(payload, old.offset+slotHeaderSize); err != nil && err != io.EOF {
+			return fail(err)
+		}
+
+		need := uint32(len(payload))
+		capacity := (need + need/8 + 15) &^ 15
+
+		slot := &packedSlot{offset: size, capacity: capacity, idLen: old.idLen, dataLen: old.dataLen, seq: old.seq}
+
+		buf.Write(slotHeader(slot, slotLive))
+		buf.Write(payload)
+		buf.Write(make([]byte, capacity-need))
+
+		slots[id] = slot
+		size += slotHeaderSize + int64(capacity)
+
+		if buf.Len() >= 1<<20 {
+			if _, err := tmp.Write(buf.Bytes()); err != nil {
+				return fail(err)
+			}
+			buf.Reset()
+		}
+	}
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return fail(err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+
+	return tmp, slots, size, nil
+}
+
+// allocate returns a slot with room for need payload bytes, reusing the first
+// free slot that is big enough, or appending a new slot to the file.
+func (t *packedTable) allocate(need uint32) (*packedSlot, error) {
+	for i, slot := range t.free {
+		if slot.capacity < need {
+			continue
+		}
+
+		t.free = append(t.free[:i], t.free[i+1:]...)
+
+		// Split off the unused tail of a large free slot so that it can be
+		// reused by other records.
+		if slot.capacity-need >= slotHeaderSize+minSplitCapacity {
+			rest := &packedSlot{
+				offset:   slot.offset + slotHeaderSize + int64(need),
+				capacity: slot.capacity - need - slotHeaderSize,
+			}
+			if err := t.writeHeader(rest, slotFree); err != nil {
+				return nil, err
+			}
+			t.addFree(rest)
+
+			slot.capacity = need
+		}
+
+		return slot, nil
+	}
+
+	// Leave some headroom so that, once freed, the slot can take a slightly
+	// larger version of the record.
+	capacity := need + need/8
+	capacity = (capacity + 15) &^ 15
+
+	slot := &packedSlot{offset: t.size, capacity: capacity}
+	t.size += slotHeaderSize + int64(capacity)
+
+	return slot, nil
+}
+
+// freeSlot marks a slot as free and adds it to the free list.
+func (t *packedTable) freeSlot(slot *packedSlot) error {
+	err := t.writeHeader(slot, slotFree)
+	if err != nil {
+		return err
+	}
+
+	slot.idLen = 0
+	slot.dataLen = 0
+	slot.seq = 0
+	t.addFree(slot)
+
+	return nil
+}
+
+// addFree adds a slot to the free list, merging it with an adjacent free slot
+// that directly follows it.
+func (t *packedTable) addFree(slot *packedSlot) {
+	for i, other := range t.free {
+		if other.offset+slotHeaderSize+int64(other.capacity) == slot.offset {
+			other.capacity += slotHeaderSize + slot.capacity
+			t.writeHeader(other, slotFree)
+			return
+		}
+		if slot.offset+slotHeaderSize+int64(slot.capacity) == other.offset {
+			slot.capacity += slotHeaderSize + other.capacity
+			t.free[i] = slot
+			t.writeHeader(slot, slotFree)
+			return
+		}
+	}
+
+	t.free = append(t.free, slot)
+}
+
+// writeSlot writes the payload and then the header of a live slot.
+func (t *packedTable) writeSlot(slot *packedSlot, fileId string, data []byte) error {
+	var payload bytes.Buffer
+	payload.WriteString(fileId)
+	payload.Write(data)
+
+	_, err := t.file.WriteAt(payload.Bytes(), slot.offset+slotHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	// Pad a freshly appended slot to its full capacity so that the file never
+	// ends in the middle of a slot.
+	end := slot.offset + slotHeaderSize + int64(slot.capacity)
+	if info, err := t.file.Stat(); err == nil && info.Size() < end {
+		if err := t.file.Truncate(end); err != nil {
+			return err
+		}
+	}
+
+	return t.writeHeader(slot, slotLive)
+}
+
+// writeHeader writes the header of a slot.
+func (t *packedTable) writeHeader(slot *packedSlot, flags byte) error {
+	_, err := t.file.WriteAt(slotHeader(slot, flags), slot.offset)
+
+	return err
+}
+
+// slotHeader returns the header of a slot.
+func slotHeader(slot *packedSlot, flags byte) []byte {
+	header := make([]byte, slotHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], slot.capacity)
+	header[4] = flags
+	binary.LittleEndian.PutUint16(header[5:7], slot.idLen)
+	binary.LittleEndian.PutUint32(header[7:11], slot.dataLen)
+	binary.LittleEndian.PutUint64(header[11:19], slot.seq)
+
+	return header
+}
+
+// syncDir flushes the entries of a directory, such as a file renamed into
+// it, to disk. Windows cannot sync directories, and does not need to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,269 @@
+package ivy
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// pinSet holds the records pinned in memory, decoded, keyed by table name and
+// id; see DB.Pin and DB.PinTable. A record pinned by id, or belonging to a
+// pinned table, is stored when it is first read or written, and replaced by
+// every write.
+type pinSet struct {
+	mu     sync.RWMutex
+	tables map[string]bool
+	ids    map[string]map[string]bool
+	recs   map[string]map[string][]byte
+}
+
+// pinned reports whether a record is pinned.
+func (p *pinSet) pinned(tblName string, fileId string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.tables[tblName] || p.ids[tblName][fileId]
+}
+
+// get returns a copy of a pinned record, and whether it is in memory.
+func (p *pinSet) get(tblName string, fileId string) ([]byte, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	data, ok := p.recs[tblName][fileId]
+	if !ok {
+		return nil, false
+	}
+
+	return append([]byte(nil), data...), true
+}
+
+// put stores a record if it is pinned.
+func (p *pinSet) put(tblName string, fileId string, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.tables[tblName] && !p.ids[tblName][fileId] {
+		return
+	}
+
+	if p.recs == nil {
+		p.recs = make(map[string]map[string][]byte)
+	}
+	if p.recs[tblName] == nil {
+		p.recs[tblName] = make(map[string][]byte)
+	}
+
+	p.recs[tblName][fileId] = append([]byte(nil), data...)
+}
+
+// drop removes a record from memory, leaving it pinned.
+func (p *pinSet) drop(tblName string, fileId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.recs[tblName], fileId)
+}
+
+// forget removes the records of a table from memory, leaving them pinned, so
+// that they are read again.
+func (p *pinSet) forget(tblName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.recs, tblName)
+}
+
+// pin pins the records of a table with the supplied ids, or the whole table
+// if there are none.
+func (p *pinSet) pin(tblName string, fileIds []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(fileIds) == 0 {
+		if p.tables == nil {
+			p.tables = make(map[string]bool)
+		}
+		p.tables[tblName] = true
+		return
+	}
+
+	if p.ids == nil {
+		p.ids = make(map[string]map[string]bool)
+	}
+	if p.ids[tblName] == nil {
+		p.ids[tblName] = make(map[string]bool)
+	}
+
+	for _, fileId := range fileIds {
+		p.ids[tblName][fileId] = true
+	}
+}
+
+// unpin unpins the records of a table with the supplied ids, or every record
+// of the table, pinned whole or by id, if there are none, and removes them
+// from memory.
+func (p *pinSet) unpin(tblName string, fileIds []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(fileIds) == 0 {
+		delete(p.tables, tblName)
+		delete(p.ids, tblName)
+		delete(p.recs, tblName)
+		return
+	}
+
+	for _, fileId := range fileIds {
+		delete(p.ids[tblName], fileId)
+
+		if !p.tables[tblName] {
+			delete(p.recs[tblName], fileId)
+		}
+	}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// PinTable keeps every record of a table in memory, decoded, so that reading
+// one never touches the disk; the records are replaced in memory by every
+// write. It is meant for small lookup tables consulted on every request, such
+// as settings, since the whole table is held in memory for as long as the
+// database is open. Pinned tables can also be set with Options.PinnedTables.
+// It takes a table name. It loads the records at once and returns any error
+// encountered.
+func (db *DB) PinTable(tblName string) error {
+	return db.PinTableCtx(context.Background(), tblName)
+}
+
+// PinTableCtx is PinTable with a context, which is handed to the authorizer.
+func (db *DB) PinTableCtx(ctx context.Context, tblName string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "pin")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	return db.pinRecs(tblName, nil)
+}
+
+// Pin keeps the records of a table with the supplied ids in memory, as
+// PinTable does for a whole table. An id without a record yet is pinned when
+// the record is created. It takes a table name and the ids. It loads the
+// records at once and returns any error encountered.
+func (db *DB) Pin(tblName string, fileIds ...string) error {
+	return db.PinCtx(context.Background(), tblName, fileIds...)
+}
+
+// PinCtx is Pin with a context, which is handed to the authorizer.
+func (db *DB) PinCtx(ctx context.Context, tblName string, fileIds ...string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "pin")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	for _, fileId := range fileIds {
+		if err := checkId(fileId); err != nil {
+			return err
+		}
+	}
+
+	if len(fileIds) == 0 {
+		return nil
+	}
+
+	return db.pinRecs(tblName, fileIds)
+}
+
+// Unpin releases the memory of pinned records, which are then read from disk
+// again. It takes a table name and the ids of the records to unpin; without
+// ids, every record of the table is unpinned, whether it was pinned by Pin
+// or PinTable. It returns any error encountered.
+func (db *DB) Unpin(tblName string, fileIds ...string) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	db.pins.unpin(tblName, fileIds)
+
+	return nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// pinRecs pins the records of a table with the supplied ids, or the whole
+// table if there are none, and reads them into memory.
+func (db *DB) pinRecs(tblName string, fileIds []string) error {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.pins.pin(tblName, fileIds)
+
+	if len(fileIds) == 0 {
+		var err error
+		if fileIds, err = db.engine.ids(tblName); err != nil {
+			return err
+		}
+	}
+
+	// Reading a pinned record puts it in memory.
+	for _, fileId := range fileIds {
+		_, err := db.readRec(tblName, fileId)
+		if err != nil && !os.IsNotExist(err) {
+			return recErr(tblName, fileId, err)
+		}
+	}
+
+	return nil
+}
+
+// refreshPin replaces a pinned record in memory after it was written, with
+// its encoded data, or removed, with nil data.
+func (db *DB) refreshPin(tblName string, fileId string, data []byte) {
+	if data == nil || !db.pins.pinned(tblName, fileId) {
+		db.pins.drop(tblName, fileId)
+		return
+	}
+
+	decoded, err := db.decodeRec(tblName, fileId, data)
+	if err != nil {
+		// It is read from disk again.
+		db.pins.drop(tblName, fileId)
+		return
+	}
+
+	db.pins.put(tblName, fileId, decoded)
+}
+
+// pinTables pins the tables of Options.PinnedTables when the database is
+// opened.
+func (db *DB) pinTables(tblNames []string) error {
+	for _, tblName := range tblNames {
+		if err := db.pinRecs(tblName, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
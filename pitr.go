@@ -0,0 +1,150 @@
+package ivy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// RestoreToTime recovers a database as it was at a moment in time, such as
+// just before a bad bulk update: a full backup is unpacked into a new
+// database directory, and the changes committed after the backup and no later
+// than the supplied time are replayed from the write-ahead log archive kept
+// with WALOptions.ArchiveDir. The backup must have been taken from the
+// database with its write-ahead log turned on, and the archive must hold
+// every segment written since. It takes a reader to read the backup archive
+// from, the archive directory, the time to recover to, the path of the
+// database directory, which must not exist or be empty, and the options the
+// database is opened with, such as WithEncryptionKey for an encrypted
+// database. It returns any error encountered.
+func RestoreToTime(backup io.Reader, walDir string, t time.Time, dbPath string, options ...Option) error {
+	var c openConfig
+
+	for _, option := range options {
+		option(&c)
+	}
+
+	opts := c.opts
+	opts.NoLock = true
+
+	var s *sealer
+
+	key, err := resolveKey(opts.EncryptionKey, opts.EncryptionKeyProvider, opts.KeyTimeout, "encryption")
+	if err != nil {
+		return err
+	}
+
+	// The database is opened with the key fetched here, rather than fetching
+	// it again.
+	opts.EncryptionKey, opts.EncryptionKeyProvider = key, nil
+
+	if key != nil {
+		s, err = newSealer(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return restoreBackup(backup, dbPath, func(tmpPath string, manifest *BackupManifest) error {
+		if manifest == nil {
+			return errors.New("ivy: backup archive has no manifest")
+		}
+		if t.Before(manifest.Created) {
+			return fmt.Errorf("ivy: cannot restore to %v, before the backup was taken at %v", t, manifest.Created)
+		}
+
+		changes, err := archivedChanges(walDir, manifest.LSN, t, s)
+		if err != nil {
+			return err
+		}
+
+		db, err := OpenDBWithOptions(tmpPath, nil, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range changes {
+			data := e.Data
+			if e.Op == walDelete {
+				data = nil
+			}
+
+			err = db.restoreRec(e.Table, e.Id, data)
+			if err != nil {
+				db.Close()
+				return err
+			}
+		}
+
+		return db.Close()
+	})
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// archivedChanges returns, in order, the changes in a write-ahead log archive
+// after the supplied sequence number that were committed no later than t,
+// decrypting them with s.
+func archivedChanges(walDir string, since uint64, t time.Time, s *sealer) ([]walEntry, error) {
+	w := &wal{dir: walDir}
+
+	starts, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("ivy: no write-ahead log segments in %s", filepath.Clean(walDir))
+	}
+
+	var entries []walEntry
+	next := since + 1
+
+	for _, start := range starts {
+		segEntries, _, _, err := readWALSegment(w.segmentPath(start), s)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(segEntries) == 0 || segEntries[len(segEntries)-1].LSN < next {
+			continue
+		}
+
+		if start > next {
+			return nil, fmt.Errorf("ivy: write-ahead log archive %s is missing the entries from %d to %d", walDir, next, start-1)
+		}
+
+		for _, e := range segEntries {
+			if e.LSN >= next {
+				entries = append(entries, e)
+				next = e.LSN + 1
+			}
+		}
+	}
+
+	committed := make(map[uint64]bool)
+	aborted := make(map[uint64]bool)
+
+	for _, e := range entries {
+		switch {
+		case e.Op == walAbort:
+			aborted[e.Tx] = true
+		case (e.Commit || e.Op == walCommit) && !e.Time.After(t):
+			committed[e.Tx] = true
+		}
+	}
+
+	var changes []walEntry
+
+	for _, e := range entries {
+		if (e.Op == walPut || e.Op == walDelete) && committed[e.Tx] && !aborted[e.Tx] {
+			changes = append(changes, e)
+		}
+	}
+
+	return changes, nil
+}
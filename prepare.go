@@ -0,0 +1,209 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+)
+
+// Type Param is a string naming a parameter of a prepared query, standing for
+// a value of the filter that is only given when the query is executed, such
+// as ivy.M{"speed": ivy.M{"$gte": ivy.Param("min")}}. It may also stand for
+// the whole slice of an $in or $nin.
+type Param string
+
+// Type PreparedQuery is a struct holding a filter compiled by DB.Prepare, to
+// be executed any number of times, from any number of goroutines, with
+// different parameters.
+type PreparedQuery struct {
+	db      *DB
+	tblName string
+	where   qlExpr
+	index   string
+}
+
+// Index returns the indexed field that executions of the query look up, or
+// "" if they read the whole table. The index was resolved when the query was
+// prepared; a parameter bound to nil, which cannot be looked up, falls back
+// to the next indexed field of the filter, if any.
+func (q *PreparedQuery) Index() string {
+	return q.index
+}
+
+// Execute runs the prepared query with its parameters bound to the values of
+// params, keyed by the names of the parameters. Values are converted as in
+// DB.Filter. It takes the values of the parameters. It returns a slice of
+// record ids, in id order, and any error encountered, such as a parameter
+// missing from params.
+func (q *PreparedQuery) Execute(params M) ([]string, error) {
+	return q.ExecuteCtx(context.Background(), params)
+}
+
+// ExecuteCtx is Execute with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (q *PreparedQuery) ExecuteCtx(ctx context.Context, params M) (_ []string, err error) {
+	db := q.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, q.tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := bindExpr(q.where, func(param Param) (interface{}, error) {
+		value, ok := params[string(param)]
+		if !ok {
+			return nil, fmt.Errorf("ivy: parameter %q is not bound", string(param))
+		}
+
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.runQuery(ctx, q.tblName, &query{where: where, limit: -1})
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Prepare compiles a filter of a table, written as for Filter, into a query to
+// be executed repeatedly, so that a query run many times with different values
+// is only compiled once. Values given by a Param are bound on every execution.
+// The fields are split into the names of nested objects, and the indexed field
+// to look up is resolved, once, here. It takes a table name and the filter. It
+// returns the prepared query and any error encountered.
+func (db *DB) Prepare(tblName string, filter M) (*PreparedQuery, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	where, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedQuery{db: db, tblName: tblName, where: where, index: db.indexableField(tblName, where)}, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// indexableField returns the first indexed field that a condition compares for
+// equality or with $in, before any value is bound, or "" if there is none.
+func (db *DB) indexableField(tblName string, where qlExpr) string {
+	fldIndexes := db.fldIndex(tblName)
+
+	for _, expr := range conjuncts(where) {
+		var field string
+
+		switch e := expr.(type) {
+		case *qlCompare:
+			if e.op == "=" {
+				field = e.field
+			}
+		case *qlIn:
+			field = e.field
+		}
+
+		if _, ok := fldIndexes[field]; ok && field != "" {
+			return field
+		}
+	}
+
+	return ""
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// bindExpr returns a condition with the values given by a Param replaced by
+// those returned by bind. The condition itself is left as it is, so that it
+// can be bound again.
+func bindExpr(expr qlExpr, bind func(Param) (interface{}, error)) (qlExpr, error) {
+	switch e := expr.(type) {
+	case *qlAnd:
+		left, err := bindExpr(e.left, bind)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := bindExpr(e.right, bind)
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlAnd{left: left, right: right}, nil
+	case *qlOr:
+		left, err := bindExpr(e.left, bind)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := bindExpr(e.right, bind)
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlOr{left: left, right: right}, nil
+	case *qlNot:
+		not, err := bindExpr(e.expr, bind)
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlNot{expr: not}, nil
+	case *qlCompare:
+		param, ok := e.value.(Param)
+		if !ok {
+			return e, nil
+		}
+
+		value, err := bind(param)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := value.(Param); ok {
+			return nil, fmt.Errorf("ivy: parameter %q is bound to another parameter", string(param))
+		}
+
+		value, err = filterValue(e.field, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlCompare{field: e.field, path: e.path, op: e.op, value: value}, nil
+	case *qlIn:
+		if e.param == "" {
+			return e, nil
+		}
+
+		value, err := bind(e.param)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := filterValues(e.field, "$in", value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlIn{field: e.field, path: e.path, values: values}, nil
+	}
+
+	return expr, nil
+}
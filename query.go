@@ -0,0 +1,995 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// qlKind is the kind of a token of the query language.
+type qlKind int
+
+const (
+	qlEOF qlKind = iota
+	qlIdent
+	qlString
+	qlNumber
+	qlOp
+	qlLParen
+	qlRParen
+	qlComma
+	qlPlaceholder
+)
+
+// qlToken is a token of the query language, with its position in the query.
+type qlToken struct {
+	kind qlKind
+	text string
+	pos  int
+}
+
+// qlExpr is a condition of a query.
+type qlExpr interface {
+	eval(rec map[string]interface{}) bool
+}
+
+// qlAnd holds if both of its conditions hold.
+type qlAnd struct {
+	left, right qlExpr
+}
+
+// qlOr holds if either of its conditions holds.
+type qlOr struct {
+	left, right qlExpr
+}
+
+// qlNot holds if its condition does not.
+type qlNot struct {
+	expr qlExpr
+}
+
+// qlCompare compares a field of a record with a value, which is nil, a bool,
+// a json.Number or a string, or a Param until it is bound. A missing field
+// compares as null. The path is the field split into the names of nested
+// objects, so that it is split once rather than for every record.
+type qlCompare struct {
+	field string
+	path  []string
+	op    string
+	value interface{}
+}
+
+// qlOrder is a field of an ORDER BY clause.
+type qlOrder struct {
+	field string
+	desc  bool
+}
+
+// query is a parsed query of the query language, with the number of its ?
+// placeholders and the limits on running it.
+type query struct {
+	where  qlExpr
+	order  []qlOrder
+	limit  int
+	offset int
+	params int
+	limits QueryLimits
+}
+
+// Type QueryLimits is a struct capping the work of a query run by
+// DB.QueryStringWithLimits, so that queries from untrusted input cannot read
+// whole tables. A zero field sets no limit. MaxScanned is the number of
+// records read; MaxResults is the number of matches found, after which the
+// query stops reading, before any ORDER BY sorts them or OFFSET skips them;
+// Timeout is the time the query may spend reading records.
+type QueryLimits struct {
+	MaxScanned int
+	MaxResults int
+	Timeout    time.Duration
+}
+
+// Type QueryResult is a struct holding the result of DB.QueryStringWithLimits.
+// Ids are the ids of the matching records, as returned by QueryString.
+// Scanned is the number of records read. Truncated is true if the query was
+// stopped by one of its limits, in which case Ids hold the matches among the
+// records read so far, in the order of the query.
+type QueryResult struct {
+	Ids       []string `json:"ids"`
+	Scanned   int      `json:"scanned"`
+	Truncated bool     `json:"truncated"`
+}
+
+// qlParser parses a query.
+type qlParser struct {
+	src    string
+	tokens []qlToken
+	pos    int
+	params int
+}
+
+func (e *qlAnd) eval(rec map[string]interface{}) bool {
+	return e.left.eval(rec) && e.right.eval(rec)
+}
+
+func (e *qlOr) eval(rec map[string]interface{}) bool {
+	return e.left.eval(rec) || e.right.eval(rec)
+}
+
+func (e *qlNot) eval(rec map[string]interface{}) bool {
+	return !e.expr.eval(rec)
+}
+
+func (e *qlCompare) eval(rec map[string]interface{}) bool {
+	c, ok := compareValues(pathValue(rec, e.path), e.value)
+	if !ok {
+		return e.op == "!="
+	}
+
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+// parse parses a whole query.
+func (p *qlParser) parse() (*query, error) {
+	q := &query{limit: -1}
+
+	if !p.keyword("ORDER") && !p.keyword("LIMIT") && p.peek().kind != qlEOF {
+		where, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		q.where = where
+	}
+
+	if p.keyword("ORDER") {
+		p.next()
+		if !p.keyword("BY") {
+			return nil, p.errorf("expected BY")
+		}
+		p.next()
+
+		for {
+			tok := p.next()
+			if tok.kind != qlIdent {
+				return nil, p.errorAt(tok, "expected a field name")
+			}
+
+			order := qlOrder{field: tok.text}
+
+			if p.keyword("DESC") {
+				p.next()
+				order.desc = true
+			} else if p.keyword("ASC") {
+				p.next()
+			}
+
+			q.order = append(q.order, order)
+
+			if p.peek().kind != qlComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		p.next()
+
+		n, err := p.count()
+		if err != nil {
+			return nil, err
+		}
+		q.limit = n
+
+		if p.keyword("OFFSET") {
+			p.next()
+
+			q.offset, err = p.count()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if tok := p.peek(); tok.kind != qlEOF {
+		return nil, p.errorAt(tok, "unexpected "+strconv.Quote(tok.text))
+	}
+
+	q.params = p.params
+
+	return q, nil
+}
+
+// parseOr parses conditions joined by OR.
+func (p *qlParser) parseOr() (qlExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &qlOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses conditions joined by AND.
+func (p *qlParser) parseAnd() (qlExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		p.next()
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &qlAnd{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseNot parses a condition, which may be negated with NOT.
+func (p *qlParser) parseNot() (qlExpr, error) {
+	if p.keyword("NOT") {
+		p.next()
+
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &qlNot{expr: expr}, nil
+	}
+
+	tok := p.next()
+
+	switch tok.kind {
+	case qlLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok := p.next(); tok.kind != qlRParen {
+			return nil, p.errorAt(tok, "expected )")
+		}
+
+		return expr, nil
+	case qlIdent:
+		op := p.next()
+		if op.kind != qlOp {
+			return nil, p.errorAt(op, "expected a comparison operator")
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		return newCompare(tok.text, op.text, value), nil
+	}
+
+	return nil, p.errorAt(tok, "expected a condition")
+}
+
+// value parses a literal value.
+func (p *qlParser) value() (interface{}, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case qlString:
+		return tok.text, nil
+	case qlNumber:
+		// Numbers are kept as written, so that they compare exactly with
+		// records decoded with json.Number.
+		return json.Number(tok.text), nil
+	case qlPlaceholder:
+		// Placeholders are named by their position, from 1.
+		p.params++
+		return Param(strconv.Itoa(p.params)), nil
+	case qlIdent:
+		switch strings.ToUpper(tok.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		}
+	}
+
+	return nil, p.errorAt(tok, "expected a value")
+}
+
+// count parses a non-negative integer.
+func (p *qlParser) count() (int, error) {
+	tok := p.next()
+
+	n, err := strconv.Atoi(tok.text)
+	if tok.kind != qlNumber || err != nil || n < 0 {
+		return 0, p.errorAt(tok, "expected a non-negative integer")
+	}
+
+	return n, nil
+}
+
+// keyword reports whether the next token is the supplied keyword.
+func (p *qlParser) keyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == qlIdent && strings.EqualFold(tok.text, word)
+}
+
+// peek returns the next token without consuming it.
+func (p *qlParser) peek() qlToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token.
+func (p *qlParser) next() qlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != qlEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// errorf returns a syntax error at the next token.
+func (p *qlParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.peek(), fmt.Sprintf(format, args...))
+}
+
+// errorAt returns a syntax error at a token.
+func (p *qlParser) errorAt(tok qlToken, msg string) error {
+	if tok.kind == qlEOF {
+		msg += " at end of query"
+	} else {
+		msg += fmt.Sprintf(" at position %d", tok.pos+1)
+	}
+
+	return fmt.Errorf("ivy: invalid query %q: %s", p.src, msg)
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// QueryString returns the ids of the records of a table matching a query
+// written in ivy's query language, so that queries can come from config files
+// or user input. A query is made of a condition, an ORDER BY clause and a
+// LIMIT clause, all optional:
+//
+//	enginetype = 'radial' AND speed > 300 ORDER BY speed DESC LIMIT 5
+//
+// Conditions compare a field, which may be a path into nested objects such as
+// address.city, with a string in single quotes, a number, TRUE, FALSE or NULL,
+// using =, !=, <>, <, <=, > or >=, and are combined with AND, OR, NOT and
+// parentheses. Field names that are not plain words go in double quotes. A
+// missing field equals NULL. Values of different types are never equal, and
+// compare neither less nor greater. Results are in id order unless ORDER BY
+// says otherwise; LIMIT may be followed by OFFSET. Keywords are not case
+// sensitive. A condition comparing an indexed field for equality with a string
+// is answered from the index; Explain tells whether a query is.
+//
+// A value may be given by a ? placeholder instead, bound in order to the
+// arguments following the query, so that values from user input need no
+// quoting:
+//
+//	db.QueryString("planes", "name = ? AND speed > ?", name, 300)
+//
+// Arguments are strings, numbers, bools or nil, or any other value that
+// marshals to one of them as JSON, such as a time.Time. There must be as many
+// arguments as placeholders. It takes a table name, the query and the
+// arguments. It returns a slice of record ids and any error encountered.
+func (db *DB) QueryString(tblName string, queryStr string, args ...interface{}) ([]string, error) {
+	return db.QueryStringCtx(context.Background(), tblName, queryStr, args...)
+}
+
+// QueryStringCtx is QueryString with a context. It stops reading records
+// with the context's error as soon as the context is done.
+func (db *DB) QueryStringCtx(ctx context.Context, tblName string, queryStr string, args ...interface{}) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(queryStr, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.runQuery(ctx, tblName, q)
+}
+
+// QueryStringWithLimits is QueryString with limits on the records it reads,
+// the matches it finds and the time it takes. Records are read in id order,
+// and reading stops at the first limit reached, returning the matches found
+// so far, marked as truncated, rather than an error. It takes a table name,
+// the query, the limits and the arguments of the query's placeholders. It
+// returns the result and any error encountered.
+func (db *DB) QueryStringWithLimits(tblName string, queryStr string, limits QueryLimits, args ...interface{}) (*QueryResult, error) {
+	return db.QueryStringWithLimitsCtx(context.Background(), tblName, queryStr, limits, args...)
+}
+
+// QueryStringWithLimitsCtx is QueryStringWithLimits with a context. It stops
+// reading records with the context's error as soon as the context is done;
+// only the limits' own Timeout returns a truncated result instead.
+func (db *DB) QueryStringWithLimitsCtx(ctx context.Context, tblName string, queryStr string, limits QueryLimits, args ...interface{}) (_ *QueryResult, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(queryStr, args)
+	if err != nil {
+		return nil, err
+	}
+	q.limits = limits
+
+	return db.runLimitedQuery(ctx, tblName, q)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// runQuery returns the ids of the records of a table matching a query. It
+// stops with the context's error as soon as the context is done.
+func (db *DB) runQuery(ctx context.Context, tblName string, q *query) ([]string, error) {
+	res, err := db.runLimitedQuery(ctx, tblName, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Ids, nil
+}
+
+// runLimitedQuery returns the result of a query within its limits. It stops
+// with the context's error as soon as the context is done.
+func (db *DB) runLimitedQuery(ctx context.Context, tblName string, q *query) (*QueryResult, error) {
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	fileIds, fldName := db.indexCandidates(tblName, q.where)
+	if fldName != "" {
+		db.metrics.countIndexHit(tblName)
+	} else {
+		start := time.Now()
+		defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+		var err error
+
+		fileIds, err = db.engine.ids(tblName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db.evalQuery(ctx, tblName, q, fileIds, db.readRec)
+}
+
+// evalQuery returns the result of a query over the candidate records of a
+// table, read by read, within the query's limits. The caller must hold the
+// table's read lock.
+func (db *DB) evalQuery(ctx context.Context, tblName string, q *query, fileIds []string, read func(tblName string, fileId string) ([]byte, error)) (*QueryResult, error) {
+	limits := q.limits
+	limited := limits.MaxScanned > 0 || limits.MaxResults > 0 || limits.Timeout > 0
+
+	if limited {
+		// Records are read in id order, so that a truncated result holds the
+		// first matches. The ids may be those of an index, which are copied
+		// rather than sorted in place.
+		fileIds = append([]string(nil), fileIds...)
+		sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+	}
+
+	var deadline time.Time
+	if limits.Timeout > 0 {
+		deadline = time.Now().Add(limits.Timeout)
+	}
+
+	type match struct {
+		fileId string
+		rec    map[string]interface{}
+	}
+
+	var matches []match
+
+	res := &QueryResult{}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if limited && ((limits.MaxScanned > 0 && res.Scanned >= limits.MaxScanned) ||
+			(limits.MaxResults > 0 && len(matches) >= limits.MaxResults) ||
+			(!deadline.IsZero() && time.Now().After(deadline))) {
+			res.Truncated = true
+			break
+		}
+
+		res.Scanned++
+
+		data, err := read(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, corruptErr(tblName, fileId, err.Error())
+		}
+
+		if q.where == nil || q.where.eval(rec) {
+			// Records are only kept when ORDER BY needs them for sorting.
+			if len(q.order) == 0 {
+				rec = nil
+			}
+
+			matches = append(matches, match{fileId: fileId, rec: rec})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, order := range q.order {
+			c := orderValues(fieldValue(matches[i].rec, order.field), fieldValue(matches[j].rec, order.field))
+			if c != 0 {
+				return (c < 0) != order.desc
+			}
+		}
+
+		return idNum(matches[i].fileId) < idNum(matches[j].fileId)
+	})
+
+	if q.offset >= len(matches) {
+		res.Ids = []string{}
+		return res, nil
+	}
+	matches = matches[q.offset:]
+
+	if q.limit >= 0 && q.limit < len(matches) {
+		matches = matches[:q.limit]
+	}
+
+	res.Ids = make([]string, len(matches))
+	for i, m := range matches {
+		res.Ids[i] = m.fileId
+	}
+
+	return res, nil
+}
+
+// indexCandidates returns the ids of the only records that can match a
+// condition, found in an index, and the field of the index used, which is
+// empty if no index could be used. The caller must hold the table's read lock.
+func (db *DB) indexCandidates(tblName string, where qlExpr) ([]string, string) {
+	fldIndexes := db.fldIndex(tblName)
+	fldNames, _ := db.indexFields(tblName)
+
+	for _, expr := range conjuncts(where) {
+		if tags, ok := expr.(*qlTags); ok && len(tags.tags) > 0 && stringInSlice("tags", fldNames) {
+			// Every match has the first tag, and the condition checks the others.
+			return db.tagIndex(tblName)[tags.tags[0]], "tags"
+		}
+
+		if in, ok := expr.(*qlIn); ok {
+			if index, ok := fldIndexes[in.field]; ok {
+				if fileIds, ok := db.indexUnion(index, in.values); ok {
+					return fileIds, in.field
+				}
+			}
+			continue
+		}
+
+		cmp, ok := expr.(*qlCompare)
+		if !ok || cmp.op != "=" {
+			continue
+		}
+
+		key, ok := db.indexKey(cmp.value)
+		if !ok {
+			continue
+		}
+
+		if index, ok := fldIndexes[cmp.field]; ok {
+			return index[key], cmp.field
+		}
+	}
+
+	return nil, ""
+}
+
+// indexKey returns the key of a field index for a value of the query
+// language, and whether the value can be looked up in an index.
+func (db *DB) indexKey(value interface{}) (string, bool) {
+	if n, ok := value.(json.Number); ok && !db.useNumber {
+		// Records are decoded with float64 numbers, and so are the keys of
+		// the indexes.
+		f, _ := n.Float64()
+		value = f
+	}
+
+	return fieldKey(value)
+}
+
+// indexUnion returns the ids of the records holding any of the supplied values
+// in a field index, without duplicates, and whether every value could be
+// looked up in the index.
+func (db *DB) indexUnion(index map[string][]string, values []interface{}) ([]string, bool) {
+	var fileIds []string
+	seen := make(map[string]bool)
+
+	for _, value := range values {
+		key, ok := db.indexKey(value)
+		if !ok {
+			return nil, false
+		}
+
+		for _, fileId := range index[key] {
+			if !seen[fileId] {
+				seen[fileId] = true
+				fileIds = append(fileIds, fileId)
+			}
+		}
+	}
+
+	return fileIds, true
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// parseQuery parses a query of the query language, binding its placeholders
+// to args.
+func parseQuery(src string, args []interface{}) (*query, error) {
+	tokens, err := lexQuery(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &qlParser{src: src, tokens: tokens}
+
+	q, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != q.params {
+		return nil, fmt.Errorf("ivy: invalid query %q: %d placeholders but %d arguments", src, q.params, len(args))
+	}
+
+	if q.params > 0 {
+		q.where, err = bindExpr(q.where, func(param Param) (interface{}, error) {
+			i, _ := strconv.Atoi(string(param))
+
+			if _, err := filterValue(string(param), args[i-1]); err != nil {
+				return nil, fmt.Errorf("ivy: invalid query %q: argument %d is not a string, number, bool or nil", src, i)
+			}
+
+			return args[i-1], nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// lexQuery splits a query into tokens, ending with a qlEOF token.
+func lexQuery(src string) ([]qlToken, error) {
+	var tokens []qlToken
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, qlToken{kind: qlLParen, text: "(", pos: start})
+			i++
+		case c == ')':
+			tokens = append(tokens, qlToken{kind: qlRParen, text: ")", pos: start})
+			i++
+		case c == ',':
+			tokens = append(tokens, qlToken{kind: qlComma, text: ",", pos: start})
+			i++
+		case c == '?':
+			tokens = append(tokens, qlToken{kind: qlPlaceholder, text: "?", pos: start})
+			i++
+		case c == '\'' || c == '"':
+			// Quotes are escaped by doubling them.
+			var text strings.Builder
+
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("ivy: invalid query %q: unterminated quote at position %d", src, start+1)
+				}
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						text.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteByte(src[i])
+				i++
+			}
+
+			kind := qlString
+			if c == '"' {
+				kind = qlIdent
+			}
+
+			tokens = append(tokens, qlToken{kind: kind, text: text.String(), pos: start})
+		case strings.IndexByte("=!<>", c) >= 0:
+			op := string(c)
+			if i+1 < len(src) && (src[i+1] == '=' || (c == '<' && src[i+1] == '>')) {
+				op = src[i : i+2]
+			}
+			i += len(op)
+
+			switch op {
+			case "!":
+				return nil, fmt.Errorf("ivy: invalid query %q: unexpected ! at position %d", src, start+1)
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+
+			tokens = append(tokens, qlToken{kind: qlOp, text: op, pos: start})
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			i++
+			for i < len(src) && (strings.IndexByte("0123456789.eE", src[i]) >= 0 ||
+				((src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E'))) {
+				i++
+			}
+
+			if _, err := strconv.ParseFloat(src[start:i], 64); err != nil {
+				return nil, fmt.Errorf("ivy: invalid query %q: invalid number %q at position %d", src, src[start:i], start+1)
+			}
+
+			tokens = append(tokens, qlToken{kind: qlNumber, text: src[start:i], pos: start})
+		case c == '_' || unicode.IsLetter(rune(c)) || c >= 0x80:
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || src[i] == '-' || src[i] >= 0x80 ||
+				unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+
+			tokens = append(tokens, qlToken{kind: qlIdent, text: src[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("ivy: invalid query %q: unexpected %q at position %d", src, c, start+1)
+		}
+	}
+
+	return append(tokens, qlToken{kind: qlEOF, pos: len(src)}), nil
+}
+
+// conjuncts returns the conditions joined by AND at the top of a condition.
+func conjuncts(expr qlExpr) []qlExpr {
+	if and, ok := expr.(*qlAnd); ok {
+		return append(conjuncts(and.left), conjuncts(and.right)...)
+	}
+
+	if expr == nil {
+		return nil
+	}
+
+	return []qlExpr{expr}
+}
+
+// newCompare returns a condition comparing a field with a value.
+func newCompare(field string, op string, value interface{}) *qlCompare {
+	return &qlCompare{field: field, path: strings.Split(field, "."), op: op, value: value}
+}
+
+// fieldValue returns the value of a field of a decoded record, following a
+// path into nested objects. Missing fields are returned as nil.
+func fieldValue(rec map[string]interface{}, path string) interface{} {
+	return pathValue(rec, strings.Split(path, "."))
+}
+
+// pathValue is fieldValue with the path split into the names of nested
+// objects.
+func pathValue(rec map[string]interface{}, names []string) interface{} {
+	for _, name := range names[:len(names)-1] {
+		obj, ok := rec[name].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		rec = obj
+	}
+
+	return rec[names[len(names)-1]]
+}
+
+// compareValues compares two values of the same type, returning -1, 0 or 1,
+// and whether they could be compared.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case nil:
+		return 0, b == nil
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case float64, json.Number:
+		return compareNumbers(x, b)
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+
+	return 0, false
+}
+
+// compareNumbers compares two numbers, each a float64 or a json.Number,
+// returning -1, 0 or 1, and whether b is a number. Two json.Numbers are
+// compared exactly, without converting them to float64.
+func compareNumbers(a interface{}, b interface{}) (int, bool) {
+	x, xExact := a.(json.Number)
+	y, yExact := b.(json.Number)
+
+	if xExact && yExact {
+		i, ierr := x.Int64()
+		j, jerr := y.Int64()
+		if ierr == nil && jerr == nil {
+			switch {
+			case i < j:
+				return -1, true
+			case i > j:
+				return 1, true
+			}
+			return 0, true
+		}
+
+		r, rok := new(big.Rat).SetString(x.String())
+		s, sok := new(big.Rat).SetString(y.String())
+		if rok && sok {
+			return r.Cmp(s), true
+		}
+	}
+
+	f, ok := numberFloat(a)
+	if !ok {
+		return 0, false
+	}
+
+	g, ok := numberFloat(b)
+	if !ok {
+		return 0, false
+	}
+
+	switch {
+	case f < g:
+		return -1, true
+	case f > g:
+		return 1, true
+	}
+	return 0, true
+}
+
+// numberFloat returns a float64 or json.Number as a float64, and whether it
+// is a number.
+func numberFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// orderValues compares two values for ORDER BY. Values of different types
+// order as null, booleans, numbers, strings and then arrays and objects.
+func orderValues(a interface{}, b interface{}) int {
+	ra, rb := orderRank(a), orderRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+
+	return bytes.Compare(ja, jb)
+}
+
+// orderRank returns the rank of the type of a value in ORDER BY.
+func orderRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64, json.Number:
+		return 2
+	case string:
+		return 3
+	}
+
+	return 4
+}
@@ -0,0 +1,189 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Type EvictionPolicy decides what a write does when a table is full.
+type EvictionPolicy int
+
+const (
+	// RejectWhenFull makes the write fail with a QuotaError. It is the
+	// default.
+	RejectWhenFull EvictionPolicy = iota
+
+	// EvictOldest makes the write delete the oldest other records of the
+	// table, those with the lowest ids, until the record fits.
+	EvictOldest
+)
+
+// Type Quota is a struct holding the limits of a table. A zero limit means
+// no limit. MaxBytes counts the marshalled size of the records, not the
+// space they take up on disk. Quotas are enforced whenever a record is
+// written: a new record has to fit in, and an updated one may only grow by
+// as many bytes as are left.
+type Quota struct {
+	MaxRecords int
+	MaxBytes   int64
+	Eviction   EvictionPolicy
+}
+
+// Type QuotaError is the error returned by Create or Update when a record
+// does not fit into its table's quota. It wraps ErrQuotaExceeded.
+type QuotaError struct {
+	Table   string
+	Quota   Quota
+	Records int
+	Bytes   int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v: table %s holds %d records and %d bytes (limits %d records, %d bytes)",
+		ErrQuotaExceeded, e.Table, e.Records, e.Bytes, e.Quota.MaxRecords, e.Quota.MaxBytes)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// tblUsage tracks the size of every record of a table with a quota. A nil
+// sizes map means the usage has not been computed yet.
+type tblUsage struct {
+	sizes map[string]int64
+	bytes int64
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// enforceQuota makes room for a record of the supplied size, evicting other
+// records or returning a QuotaError as the table's quota demands. If the
+// record replaces one already in the table, only the difference in size
+// counts. Evictions are part of the transaction of the write, if any, so
+// that they are undone with it. The caller must hold the table's write lock.
+func (db *DB) enforceQuota(ctx context.Context, tblName string, fileId string, size int64) error {
+	quota, ok := db.quotas[tblName]
+	if !ok {
+		return nil
+	}
+
+	usage, err := db.tblUsage(tblName)
+	if err != nil {
+		return err
+	}
+
+	full := func() bool {
+		records, bytes := len(usage.sizes), usage.bytes+size
+		if oldSize, ok := usage.sizes[fileId]; ok {
+			bytes -= oldSize
+		} else {
+			records++
+		}
+
+		return (quota.MaxRecords > 0 && records > quota.MaxRecords) ||
+			(quota.MaxBytes > 0 && bytes > quota.MaxBytes)
+	}
+
+	for full() {
+		oldest := oldestId(usage.sizes, fileId)
+
+		if quota.Eviction != EvictOldest || oldest == "" {
+			return &QuotaError{Table: tblName, Quota: quota, Records: len(usage.sizes), Bytes: usage.bytes}
+		}
+
+		err = db.removeRec(ctx, tblName, oldest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tblUsage returns the usage of a table, computing it first if necessary.
+// The caller must hold the table's write lock.
+func (db *DB) tblUsage(tblName string) (*tblUsage, error) {
+	usage := db.usage[tblName]
+	if usage.sizes != nil {
+		return usage, nil
+	}
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(fileIds))
+	var bytes int64
+
+	for _, fileId := range fileIds {
+		data, err := db.engine.read(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		sizes[fileId] = int64(len(data))
+		bytes += int64(len(data))
+	}
+
+	usage.sizes = sizes
+	usage.bytes = bytes
+
+	return usage, nil
+}
+
+// trackUsage records the new size of a record in the usage of its table, if
+// the table has a quota and its usage has been computed. A negative size
+// means the record was removed. The caller must hold the table's write lock.
+func (db *DB) trackUsage(tblName string, fileId string, size int64) {
+	usage, ok := db.usage[tblName]
+	if !ok || usage.sizes == nil {
+		return
+	}
+
+	usage.bytes -= usage.sizes[fileId]
+	delete(usage.sizes, fileId)
+
+	if size >= 0 {
+		usage.sizes[fileId] = size
+		usage.bytes += size
+	}
+}
+
+// resetUsage forgets the usage of a table, so that it is computed again when
+// next needed. The caller must hold the table's write lock.
+func (db *DB) resetUsage(tblName string) {
+	if usage, ok := db.usage[tblName]; ok {
+		usage.sizes = nil
+		usage.bytes = 0
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// oldestId returns the lowest of the record ids that are the keys of sizes,
+// other than except.
+func oldestId(sizes map[string]int64, except string) string {
+	oldest, oldestNum := "", 0
+
+	for fileId := range sizes {
+		if fileId == except {
+			continue
+		}
+
+		num, err := strconv.Atoi(fileId)
+		if err != nil {
+			continue
+		}
+		if oldest == "" || num < oldestNum {
+			oldest, oldestNum = fileId, num
+		}
+	}
+
+	return oldest
+}
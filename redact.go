@@ -0,0 +1,212 @@
+package ivy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Type ExportPolicy is a struct listing the fields of a table's records that
+// are redacted whenever records leave the database through an export or a
+// backup: ExportTable, ExportJSON, ExportCSV, Stream, ExportToSQLite, Backup
+// and BackupIncremental. Fields of nested objects are named by their path,
+// such as "address.email". Replication, sync and the write-ahead log are not
+// affected, so a database restored to a point in time may hold the original
+// values of records changed after the backup.
+type ExportPolicy struct {
+	// Drop lists the fields left out of exported records.
+	Drop []string
+
+	// Hash lists the fields replaced by the hex-encoded SHA-256 hash of their
+	// value, so that records can still be matched on them. Strings are hashed
+	// as they are, other values as JSON.
+	Hash []string
+
+	// Salt is prepended to values before hashing them, so that hashes of
+	// guessable values, such as email addresses, cannot be looked up.
+	Salt string
+}
+
+// Type ReadPolicy is a struct listing the fields of a table's records that
+// are masked whenever a database opened with Options.Restricted reads them:
+// by Find, Select, EventsSince and every export and backup. Watch and
+// Changes, whose changes hold the records as written, refuse the tables of a
+// read policy. Fields of nested objects are named by their path, such as
+// "card.number". Searches and queries still match the original values, so a
+// restricted database can tell whether a record holds a value by searching
+// for it.
+type ReadPolicy struct {
+	// Drop lists the fields left out of the records read.
+	Drop []string
+
+	// Mask maps fields to the number of their last characters left showing,
+	// such as 4 for card numbers; the other characters are replaced by "*".
+	// Values no longer than that are masked entirely. Values that are not
+	// strings are masked as their JSON.
+	Mask map[string]int
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// restrict applies the read policy of a table to a marshalled record if the
+// database is restricted.
+func (db *DB) restrict(tblName string, fileId string, data []byte) ([]byte, error) {
+	if !db.restricted {
+		return data, nil
+	}
+
+	policy, ok := db.readPolicies[tblName]
+	if !ok || len(policy.Drop)+len(policy.Mask) == 0 {
+		return data, nil
+	}
+
+	var rec map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&rec)
+	if err != nil {
+		return nil, corruptErr(tblName, fileId, err.Error())
+	}
+
+	for _, field := range policy.Drop {
+		obj, name := pathParent(rec, field)
+		if obj != nil {
+			delete(obj, name)
+		}
+	}
+
+	for field, show := range policy.Mask {
+		obj, name := pathParent(rec, field)
+		if obj == nil {
+			continue
+		}
+
+		value, ok := obj[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		text, ok := value.(string)
+		if !ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			text = string(encoded)
+		}
+
+		obj[name] = maskText(text, show)
+	}
+
+	return json.Marshal(rec)
+}
+
+// checkUnrestricted returns an error wrapping ErrReadOnly if the database is
+// restricted and the records of a table are masked when read, as records
+// read masked would be written back masked.
+func (db *DB) checkUnrestricted(tblName string) error {
+	if _, ok := db.readPolicies[tblName]; ok && db.restricted {
+		return fmt.Errorf("%w: %s records are masked", ErrReadOnly, tblName)
+	}
+
+	return nil
+}
+
+// redact applies the read policy and the export policy of a table to a
+// marshalled record.
+func (db *DB) redact(tblName string, fileId string, data []byte) ([]byte, error) {
+	data, err := db.restrict(tblName, fileId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, ok := db.exportPolicies[tblName]
+	if !ok || len(policy.Drop)+len(policy.Hash) == 0 {
+		return data, nil
+	}
+
+	var rec map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err = dec.Decode(&rec)
+	if err != nil {
+		return nil, corruptErr(tblName, fileId, err.Error())
+	}
+
+	for _, field := range policy.Drop {
+		obj, name := pathParent(rec, field)
+		if obj != nil {
+			delete(obj, name)
+		}
+	}
+
+	for _, field := range policy.Hash {
+		obj, name := pathParent(rec, field)
+		if obj == nil {
+			continue
+		}
+
+		value, ok := obj[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		text, ok := value.(string)
+		if !ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			text = string(encoded)
+		}
+
+		sum := sha256.Sum256([]byte(policy.Salt + text))
+		obj[name] = hex.EncodeToString(sum[:])
+	}
+
+	return json.Marshal(rec)
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// maskText replaces all but the last show characters of text with "*", or
+// all of them if text is no longer than show.
+func maskText(text string, show int) string {
+	runes := []rune(text)
+
+	keep := 0
+	if show > 0 && len(runes) > show {
+		keep = show
+	}
+
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// pathParent returns the object holding the field named by a path, and the
+// field's name in it. It returns a nil object if the path leads through a
+// value that is not an object.
+func pathParent(rec map[string]interface{}, path string) (map[string]interface{}, string) {
+	names := strings.Split(path, ".")
+
+	for _, name := range names[:len(names)-1] {
+		obj, ok := rec[name].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+
+		rec = obj
+	}
+
+	return rec, names[len(names)-1]
+}
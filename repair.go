@@ -0,0 +1,272 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Type RepairOptions is a struct holding the options of DB.Repair.
+type RepairOptions struct {
+	// DryRun reports what would be done without changing anything.
+	DryRun bool
+
+	// Recover attempts to salvage truncated records by closing any open
+	// strings, arrays and objects, dropping a trailing incomplete field if
+	// necessary. Records that cannot be salvaged are quarantined.
+	Recover bool
+}
+
+// Type RepairReport is a struct describing what DB.Repair found and did.
+// Checked is the number of records read. Recovered holds the ids of the
+// records that were salvaged and rewritten, Quarantined the ids of the records
+// that were moved out of the table, and TempFiles the paths of the orphaned
+// temporary files that were removed.
+type RepairReport struct {
+	Checked     int
+	Recovered   []string
+	Quarantined []string
+	TempFiles   []string
+}
+
+// maxRecoverAttempts limits how many truncation points recoverJSON tries.
+const maxRecoverAttempts = 100
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Repair checks a table for records that are corrupt, truncated or not valid
+// json, and for temporary files left behind by interrupted writes. Corrupt
+// records are salvaged if RepairOptions.Recover is set and possible, and
+// otherwise moved to the .ivy/quarantine directory of the database, out of
+// the way of queries. Finally the table's indexes are rebuilt. In-memory
+// databases have nowhere to quarantine records to, so corrupt records are
+// simply deleted. It takes a table name and the repair options. It returns a
+// report of what was found and done, and any error encountered.
+func (db *DB) Repair(tblName string, opts RepairOptions) (*RepairReport, error) {
+	return db.RepairCtx(context.Background(), tblName, opts)
+}
+
+// RepairCtx is Repair with a context, which is handed to the authorizer.
+func (db *DB) RepairCtx(ctx context.Context, tblName string, opts RepairOptions) (_ *RepairReport, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "repair")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	report := &RepairReport{}
+
+	// Flush pending writes, so that the repair sees what the callers saw.
+	err = db.engine.sync()
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun {
+		report.TempFiles, err = db.engine.removeTemp(tblName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		raw, err := db.engine.read(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Checked++
+
+		data, err := db.decodeRec(tblName, fileId, raw)
+		if err == nil && isJSONObject(data) {
+			continue
+		}
+
+		if opts.Recover && !errors.Is(err, ErrCorrupt) {
+			if recovered := recoverJSON(data); recovered != nil {
+				report.Recovered = append(report.Recovered, fileId)
+
+				if !opts.DryRun {
+					encoded := db.encodeRec(recovered)
+
+					err = db.engine.write(tblName, fileId, encoded)
+					if err != nil {
+						return nil, err
+					}
+
+					db.auditRec(tblName, fileId, auditRepair, "", encoded)
+				}
+				continue
+			}
+		}
+
+		report.Quarantined = append(report.Quarantined, fileId)
+
+		if !opts.DryRun {
+			err = db.quarantine(tblName, fileId, raw)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	db.bumpGeneration(tblName)
+	db.resetUsage(tblName)
+	db.pins.forget(tblName)
+
+	err = db.initTblIndexes(context.Background(), tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// quarantine moves a record into the quarantine directory.
+func (db *DB) quarantine(tblName string, fileId string, raw []byte) error {
+	if db.path != "" {
+		err := db.fs.MkdirAll(db.quarantinePath(tblName), db.modes.dir)
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(db.fs, db.quarantinePath(tblName, fileId+".json"), raw, db.modes.file)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := db.engine.remove(tblName, fileId)
+	if err != nil {
+		return err
+	}
+
+	db.auditRec(tblName, fileId, auditQuarantine, "", nil)
+
+	return nil
+}
+
+// quarantinePath returns the path of a table's quarantine directory, or of a
+// file inside it.
+func (db *DB) quarantinePath(tblName string, elem ...string) string {
+	return db.metaPath(append([]string{"quarantine", tblName}, elem...)...)
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// isJSONObject answers whether data is a valid json object.
+func isJSONObject(data []byte) bool {
+	var rec map[string]interface{}
+	return json.Unmarshal(data, &rec) == nil
+}
+
+// recoverJSON attempts to turn a truncated json object back into a valid one,
+// first by closing whatever was left open, and then by cutting it back to
+// each preceding comma in turn. It returns nil if that fails.
+func recoverJSON(data []byte) []byte {
+	var stack []byte
+	var cuts []int
+	var closers [][]byte
+
+	inString, escaped := false, false
+
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			stack = append(stack, c)
+		case '}', ']':
+			if len(stack) == 0 {
+				return nil
+			}
+			stack = stack[:len(stack)-1]
+		case ',':
+			cuts = append(cuts, i)
+			closers = append(closers, closing(stack))
+		}
+	}
+
+	if len(stack) == 0 || stack[0] != '{' {
+		return nil
+	}
+
+	candidate := append([]byte(nil), data...)
+	if inString {
+		if escaped {
+			candidate = candidate[:len(candidate)-1]
+		}
+		candidate = append(candidate, '"')
+	}
+	candidate = append(candidate, closing(stack)...)
+
+	if isJSONObject(candidate) {
+		return candidate
+	}
+
+	for n := len(cuts) - 1; n >= 0 && len(cuts)-n <= maxRecoverAttempts; n-- {
+		candidate = append(append([]byte(nil), data[:cuts[n]]...), closers[n]...)
+		if isJSONObject(candidate) {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+// closing returns the characters that close a stack of open arrays and
+// objects.
+func closing(stack []byte) []byte {
+	closers := make([]byte, 0, len(stack))
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			closers = append(closers, '}')
+		} else {
+			closers = append(closers, ']')
+		}
+	}
+
+	return closers
+}
@@ -0,0 +1,623 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicationPositionName is the name of the file in the metadata directory
+// holding the position of a replication follower.
+const replicationPositionName = "replication.json"
+
+// Type Change is a struct describing a committed change of a record, as
+// shipped from a primary database to its replication followers. LSN is the
+// position of the change in the primary's write-ahead log. Data holds the new
+// version of the record, or is nil if the record was deleted.
+type Change struct {
+	LSN   uint64          `json:"lsn"`
+	Time  time.Time       `json:"time"`
+	Table string          `json:"table"`
+	Id    string          `json:"id"`
+	Data  json.RawMessage `json:"data,omitempty"`
+
+	op string
+}
+
+// Type ReplicationSource is an interface for reading the changes of a primary
+// database. Changes returns, in order, at most limit committed changes with a
+// sequence number greater than since, and ErrReplicationGap if some of them
+// are no longer available. *DB implements it for a primary in the same
+// process, and HTTPReplicationSource for one served by ReplicationHandler.
+type ReplicationSource interface {
+	Changes(since uint64, limit int) ([]Change, error)
+}
+
+// Type HTTPReplicationSource is a ReplicationSource that reads changes from a
+// primary database served by ReplicationHandler at URL.
+type HTTPReplicationSource struct {
+	// URL is the address the primary's ReplicationHandler is served at.
+	URL string
+
+	// Header holds extra request headers, such as Authorization.
+	Header http.Header
+
+	// Client is the HTTP client used for requests. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Changes fetches changes from the primary.
+func (s *HTTPReplicationSource) Changes(since uint64, limit int) ([]Change, error) {
+	query := url.Values{}
+	query.Set("since", strconv.FormatUint(since, 10))
+	query.Set("limit", strconv.Itoa(limit))
+
+	sep := "?"
+	if strings.Contains(s.URL, "?") {
+		sep = "&"
+	}
+
+	req, err := http.NewRequest("GET", s.URL+sep+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, ErrReplicationGap
+	}
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ivy: fetching changes failed: %v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var changes []Change
+
+	err = json.NewDecoder(resp.Body).Decode(&changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// Type FollowOptions is a struct holding the options of DB.Follow.
+type FollowOptions struct {
+	// From is the sequence number of the last change already in the
+	// follower, such as the LSN in the manifest of the backup the follower
+	// was restored from. If it is zero, the follower resumes from where it
+	// stopped last time, or starts from the beginning of the primary's log.
+	From uint64
+
+	// Interval is how long the follower waits for new changes after catching
+	// up with the primary. It defaults to one second.
+	Interval time.Duration
+
+	// BatchSize is the largest number of changes fetched at a time. It
+	// defaults to 1000.
+	BatchSize int
+}
+
+// Type Follower is a struct representing a database following a primary. It
+// is returned by DB.Follow.
+type Follower struct {
+	db   *DB
+	src  ReplicationSource
+	opts FollowOptions
+
+	pullMu sync.Mutex
+
+	mu  sync.Mutex
+	lsn uint64
+	err error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Position returns the sequence number of the last change applied by the
+// follower.
+func (f *Follower) Position() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lsn
+}
+
+// Err returns the error of the last attempt to fetch and apply changes, or
+// nil if it succeeded. The follower keeps trying after an error, except for
+// ErrReplicationGap, which it cannot recover from.
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.err
+}
+
+// Sync fetches and applies changes until the follower has caught up with the
+// primary, without waiting for the next poll. It returns any error
+// encountered.
+func (f *Follower) Sync() error {
+	f.pullMu.Lock()
+	defer f.pullMu.Unlock()
+
+	for {
+		n, err := f.pull()
+
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+
+		if err != nil || n < f.opts.BatchSize {
+			return err
+		}
+	}
+}
+
+// Stop stops following the primary and waits for changes being applied to
+// finish. The database then accepts writes again, which promotes it to a
+// primary on failover.
+func (f *Follower) Stop() {
+	f.halt()
+	<-f.done
+
+	f.db.stateMu.Lock()
+	if f.db.follower == f {
+		f.db.follower = nil
+	}
+	f.db.stateMu.Unlock()
+}
+
+// run polls the primary until the follower is stopped.
+func (f *Follower) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := f.Sync()
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			f.db.logger.Warn("ivy: replication failed", "position", f.Position(), "err", err)
+		}
+		if errors.Is(err, ErrReplicationGap) {
+			return
+		}
+
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pull fetches one batch of changes and applies it. It returns the number of
+// changes fetched and any error encountered.
+func (f *Follower) pull() (int, error) {
+	changes, err := f.src.Changes(f.Position(), f.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	lsn, err := f.db.applyChanges(changes)
+
+	if lsn > 0 {
+		f.mu.Lock()
+		f.lsn = lsn
+		f.mu.Unlock()
+
+		if perr := f.db.writeReplicationPosition(lsn); err == nil {
+			err = perr
+		}
+	}
+
+	return len(changes), err
+}
+
+// halt tells the polling goroutine to stop, without waiting for it.
+func (f *Follower) halt() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Changes returns the committed changes made to the database after the
+// supplied sequence number, in order, so that replication followers can apply
+// them. It requires the write-ahead log. Changes are only available for as
+// long as they are in the log, so a primary with followers that may fall
+// behind should set WALOptions.KeepSegments. It takes the sequence number of
+// the last change already seen and the largest number of changes to return,
+// where zero means no limit. A restricted database with read policies
+// returns ErrReadOnly, as the changes hold the records unmasked. It returns
+// the changes and any error encountered, which is ErrReplicationGap if some
+// of the changes are no longer in the log.
+func (db *DB) Changes(since uint64, limit int) ([]Change, error) {
+	return db.ChangesCtx(context.Background(), since, limit)
+}
+
+// ChangesCtx is Changes with a context, which the authorizer is asked with
+// about every table.
+func (db *DB) ChangesCtx(ctx context.Context, since uint64, limit int) (_ []Change, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	tblNames := db.tableNames()
+
+	ops, err := db.beginOps(ctx, tblNames, "replicate")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	// The changes carry the records as written, unmasked.
+	for _, tblName := range tblNames {
+		if err := db.checkUnrestricted(tblName); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.readChanges(since, limit)
+}
+
+// ReplicationHandler returns an HTTP handler that serves the database's
+// changes to followers using an HTTPReplicationSource. It answers GET
+// requests with the changes after the sequence number in the since query
+// parameter, at most limit of them, as a JSON array. If the changes are no
+// longer in the write-ahead log, it answers 410 Gone. Wrapped with
+// TokenAuth.Wrap, it only serves RoleAdmin tokens.
+func (db *DB) ReplicationHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorize(w, r, "", false) {
+			return
+		}
+
+		var since uint64
+		var limit int
+		var err error
+
+		if v := r.URL.Query().Get("since"); v != "" {
+			since, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if v := r.URL.Query().Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		changes, err := db.ChangesCtx(r.Context(), since, limit)
+		if errors.Is(err, ErrForbidden) || errors.Is(err, ErrReadOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, ErrReplicationGap) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if changes == nil {
+			changes = []Change{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	})
+}
+
+// Follow makes the database a replication follower of a primary: a goroutine
+// fetches the primary's changes and applies them, keeping the database in
+// sync for read scaling and failover. A follower is usually seeded by
+// restoring a backup of the primary, passing the LSN of the backup manifest
+// as FollowOptions.From. The tables of the primary must exist in the
+// follower. While following, Create, Update and Delete return ErrFollower;
+// the position reached is saved in the database's .ivy directory so that
+// following can resume after a restart. It takes the source of the changes
+// and the follow options. It returns the follower and any error encountered.
+func (db *DB) Follow(src ReplicationSource, opts FollowOptions) (*Follower, error) {
+	return db.FollowCtx(context.Background(), src, opts)
+}
+
+// FollowCtx is Follow with a context, which is handed to the authorizer.
+func (db *DB) FollowCtx(ctx context.Context, src ReplicationSource, opts FollowOptions) (*Follower, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	// The follower applies changes to every table; only the permission to
+	// start following is checked.
+	ops, err := db.beginOps(ctx, db.tableNames(), "follow")
+	db.endOps(ops, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	lsn := opts.From
+	if lsn == 0 {
+		var err error
+
+		lsn, err = db.readReplicationPosition()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f := &Follower{
+		db:   db,
+		src:  src,
+		opts: opts,
+		lsn:  lsn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	db.stateMu.Lock()
+	if db.follower != nil {
+		db.stateMu.Unlock()
+		return nil, errors.New("ivy: database is already following a primary")
+	}
+	db.follower = f
+	db.stateMu.Unlock()
+
+	go f.run()
+
+	return f, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// readChanges reads the changes after since from the write-ahead log, as
+// Changes returns them.
+func (db *DB) readChanges(since uint64, limit int) ([]Change, error) {
+	if db.wal == nil {
+		return nil, errors.New("ivy: replication requires the write-ahead log")
+	}
+
+	horizon := db.wal.horizon()
+	if since+1 >= horizon {
+		return nil, nil
+	}
+
+	starts, err := db.wal.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	first := -1
+	for i, start := range starts {
+		if start <= since+1 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil, ErrReplicationGap
+	}
+
+	var entries []walEntry
+
+	for _, start := range starts[first:] {
+		if start >= horizon {
+			break
+		}
+
+		segEntries, _, _, err := readWALSegment(db.wal.segmentPath(start), db.sealer)
+		if os.IsNotExist(err) {
+			// The segment was removed by a checkpoint since it was listed.
+			return nil, ErrReplicationGap
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, segEntries...)
+	}
+
+	aborted := make(map[uint64]bool)
+	for _, e := range entries {
+		if e.Op == walAbort {
+			aborted[e.Tx] = true
+		}
+	}
+
+	var changes []Change
+
+	for _, e := range entries {
+		if e.LSN <= since || e.LSN >= horizon || aborted[e.Tx] {
+			continue
+		}
+		if e.Op != walPut && e.Op != walDelete {
+			continue
+		}
+
+		change := Change{LSN: e.LSN, Time: e.Time, Table: e.Table, Id: e.Id, op: "delete"}
+
+		if e.Op == walPut {
+			change.Data, err = db.decodeRec(e.Table, e.Id, e.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			change.op = "update"
+			if e.Old == nil {
+				change.op = "create"
+			}
+		}
+
+		changes = append(changes, change)
+
+		if limit > 0 && len(changes) == limit {
+			break
+		}
+	}
+
+	return changes, nil
+}
+
+// checkWritable returns ErrFollower if the database is following a primary,
+// and ErrReadOnly if it was opened read-only.
+func (db *DB) checkWritable() error {
+	db.stateMu.Lock()
+	defer db.stateMu.Unlock()
+
+	if db.follower != nil {
+		return ErrFollower
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// applyChanges applies changes fetched from a primary, in order. It returns
+// the sequence number of the last change applied and any error encountered.
+func (db *DB) applyChanges(changes []Change) (uint64, error) {
+	if err := db.enter(); err != nil {
+		return 0, err
+	}
+	defer db.leave()
+
+	var lsn uint64
+
+	for _, change := range changes {
+		err := db.applyChange(change)
+		if err != nil {
+			return lsn, err
+		}
+
+		lsn = change.LSN
+	}
+
+	return lsn, nil
+}
+
+// applyChange applies a single change fetched from a primary.
+func (db *DB) applyChange(change Change) error {
+	rwLock := db.tblLock(change.Table)
+	if rwLock == nil {
+		return fmt.Errorf("ivy: table %s does not exist in the follower", change.Table)
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	if change.Data == nil {
+		err := db.removeRec(context.Background(), change.Table, change.Id)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return db.writeRec(context.Background(), change.Table, change.Id, change.Data, true)
+}
+
+// readReplicationPosition returns the saved position of a follower, or zero
+// if there is none.
+func (db *DB) readReplicationPosition() (uint64, error) {
+	if db.path == "" {
+		return 0, nil
+	}
+
+	data, err := db.fs.ReadFile(db.metaPath(replicationPositionName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var pos struct {
+		LSN uint64 `json:"lsn"`
+	}
+
+	err = json.Unmarshal(data, &pos)
+	if err != nil {
+		return 0, err
+	}
+
+	return pos.LSN, nil
+}
+
+// writeReplicationPosition saves the position of a follower.
+func (db *DB) writeReplicationPosition(lsn uint64) error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(struct {
+		LSN uint64 `json:"lsn"`
+	}{lsn})
+	if err != nil {
+		return err
+	}
+
+	err = db.fs.MkdirAll(db.metaPath(), db.modes.dir)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.fs, db.metaPath(replicationPositionName), data, db.modes.file)
+}
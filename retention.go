@@ -0,0 +1,300 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Type RetentionPolicy is a struct describing how long the records of a
+// table are kept, by the age of a time field such as "created_at": such as
+// moving the records older than 180 days to an archive directory and
+// deleting them after 2 years. See DB.ApplyRetention and RetentionJob.
+type RetentionPolicy struct {
+	// Table is the table the policy applies to.
+	Table string
+
+	// Field is the field holding the time the age of a record is counted
+	// from, an RFC 3339 string or an integer Unix timestamp in seconds, as
+	// for FindAllIdsForFieldBetweenTimes. Records without a time in the
+	// field are kept. Indexing the field by its times, such as
+	// "created_at@time", spares reading every record.
+	Field string
+
+	// ArchiveAfter moves the records older than this to Dest; see
+	// DB.Archive. Zero keeps them in the table.
+	ArchiveAfter time.Duration
+
+	// DeleteAfter deletes the records older than this, from the table and
+	// from Dest. Zero keeps them forever.
+	DeleteAfter time.Duration
+
+	// Dest is the archive directory, needed by ArchiveAfter.
+	Dest string
+
+	// DryRun reports what the policy would do without doing it, so that a
+	// policy can be scheduled and its reports checked in the logs before
+	// it is enforced.
+	DryRun bool
+}
+
+// Type RetentionReport is a struct holding what a retention policy did, or
+// would do in a dry run, to a table.
+type RetentionReport struct {
+	Table  string
+	DryRun bool
+
+	// Archived holds the ids of the records moved to the archive directory.
+	Archived []string
+
+	// Deleted holds the ids of the records deleted from the table.
+	Deleted []string
+
+	// Pruned holds the ids of the records deleted from the archive
+	// directory.
+	Pruned []string
+}
+
+// RetentionJob returns a Job applying the supplied retention policies every
+// interval; see DB.ApplyRetention. Every report that is not empty is logged,
+// dry runs included.
+func RetentionJob(interval time.Duration, policies ...RetentionPolicy) Job {
+	return Job{Name: "retention", Interval: interval, Run: func(ctx context.Context, db *DB) error {
+		for _, policy := range policies {
+			report, err := db.ApplyRetentionCtx(ctx, policy)
+			if err != nil {
+				return err
+			}
+
+			if len(report.Archived)+len(report.Deleted)+len(report.Pruned) > 0 {
+				db.logger.Info("ivy: retention", "table", report.Table, "dry_run", report.DryRun,
+					"archived", len(report.Archived), "deleted", len(report.Deleted), "pruned", len(report.Pruned))
+			}
+		}
+
+		return nil
+	}}
+}
+
+// validate checks that a policy can be applied.
+func (p RetentionPolicy) validate() error {
+	switch {
+	case p.Table == "" || p.Field == "":
+		return errors.New("ivy: a retention policy needs a table and a time field")
+	case p.ArchiveAfter <= 0 && p.DeleteAfter <= 0:
+		return fmt.Errorf("ivy: retention policy of %s needs ArchiveAfter or DeleteAfter", p.Table)
+	case p.ArchiveAfter > 0 && p.Dest == "":
+		return fmt.Errorf("ivy: retention policy of %s needs an archive directory", p.Table)
+	case p.ArchiveAfter > 0 && p.DeleteAfter > 0 && p.DeleteAfter <= p.ArchiveAfter:
+		return fmt.Errorf("ivy: retention policy of %s deletes records before archiving them", p.Table)
+	}
+
+	return nil
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// ApplyRetention applies a retention policy to its table now. The records
+// older than DeleteAfter are deleted, from the table and from the archive
+// directory, and the other records older than ArchiveAfter are archived, as
+// by Archive. Deletes are reported to webhooks, watchers and the audit log as
+// any delete is. It takes the policy. It returns a report of the records
+// archived and deleted, or that would be if the policy is a dry run, and any
+// error encountered.
+func (db *DB) ApplyRetention(policy RetentionPolicy) (RetentionReport, error) {
+	return db.ApplyRetentionCtx(context.Background(), policy)
+}
+
+// ApplyRetentionCtx is ApplyRetention with a context, which is handed to the
+// authorizer and stops the search for old records when it is done.
+func (db *DB) ApplyRetentionCtx(ctx context.Context, policy RetentionPolicy) (report RetentionReport, err error) {
+	report = RetentionReport{Table: policy.Table, DryRun: policy.DryRun}
+
+	if err := db.enter(); err != nil {
+		return report, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, policy.Table, "", "retention")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return report, err
+	}
+
+	if err := policy.validate(); err != nil {
+		return report, err
+	}
+
+	if !policy.DryRun {
+		if err := db.checkWritable(); err != nil {
+			return report, err
+		}
+	}
+
+	if policy.ArchiveAfter > 0 {
+		// The archive would hold the fields the read policy masks.
+		if err := db.checkUnrestricted(policy.Table); err != nil {
+			return report, err
+		}
+	}
+
+	now := time.Now()
+
+	var deleteBefore, archiveBefore time.Time
+	if policy.DeleteAfter > 0 {
+		deleteBefore = now.Add(-policy.DeleteAfter)
+	}
+	if policy.ArchiveAfter > 0 {
+		archiveBefore = now.Add(-policy.ArchiveAfter)
+	}
+
+	toDelete, toArchive, err := db.retentionCandidates(ctx, policy, deleteBefore, archiveBefore)
+	if err != nil {
+		return report, err
+	}
+
+	if policy.DryRun {
+		report.Deleted = toDelete
+		report.Archived = toArchive
+	} else {
+		report.Deleted, err = db.archiveRecs(ctx, policy.Table, toDelete, olderThan(policy.Field, deleteBefore), "")
+		if err != nil {
+			return report, err
+		}
+
+		report.Archived, err = db.archiveRecs(ctx, policy.Table, toArchive, olderThan(policy.Field, archiveBefore), policy.Dest)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if policy.DeleteAfter > 0 && policy.Dest != "" {
+		report.Pruned, err = db.pruneArchive(policy, deleteBefore)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// retentionCandidates returns the ids of the records of a policy's table to
+// delete, older than deleteBefore, and to archive, older than archiveBefore
+// but not deleteBefore. A zero time selects no records.
+func (db *DB) retentionCandidates(ctx context.Context, policy RetentionPolicy, deleteBefore time.Time, archiveBefore time.Time) (toDelete []string, toArchive []string, err error) {
+	rwLock := db.tblLock(policy.Table)
+	if rwLock == nil {
+		return nil, nil, tableNotFoundErr(policy.Table)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	if !deleteBefore.IsZero() {
+		toDelete, err = db.idsBetweenTimes(ctx, policy.Table, policy.Field, time.Time{}, deleteBefore)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !archiveBefore.IsZero() {
+		toArchive, err = db.idsBetweenTimes(ctx, policy.Table, policy.Field, deleteBefore, archiveBefore)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return toDelete, toArchive, nil
+}
+
+// pruneArchive deletes the records of a policy's table older than before
+// from its archive directory, rewriting the segments holding them, unless
+// the policy is a dry run. It returns the ids of the records deleted.
+func (db *DB) pruneArchive(policy RetentionPolicy, before time.Time) ([]string, error) {
+	rwLock := db.tblLock(policy.Table)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(policy.Table)
+	}
+
+	// The lock keeps an Archive of the table from writing a segment at the
+	// same time.
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	paths, err := archiveSegments(filepath.Join(policy.Dest, policy.Table))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expired := olderThan(policy.Field, before)
+	pruned := make(map[string]bool)
+
+	for _, path := range paths {
+		recs, err := readArchiveSegment(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []archivedRec
+
+		for _, rec := range recs {
+			fields, err := db.decodeFields(rec.Data)
+			if err != nil {
+				return nil, corruptErr(policy.Table, rec.Id, err.Error())
+			}
+
+			if expired(fields) {
+				pruned[rec.Id] = true
+			} else {
+				kept = append(kept, rec)
+			}
+		}
+
+		switch {
+		case policy.DryRun || len(kept) == len(recs):
+		case len(kept) == 0:
+			err = os.Remove(path)
+		default:
+			err = db.writeArchiveFile(path, kept)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fileIds := make([]string, 0, len(pruned))
+	for fileId := range pruned {
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	return fileIds, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// olderThan returns a function reporting whether a record holds a time
+// before the supplied one in a field.
+func olderThan(field string, before time.Time) func(rec map[string]interface{}) bool {
+	beforeKey := before.UTC().Format(timeKeyLayout)
+
+	return func(rec map[string]interface{}) bool {
+		key, ok := timeKey(fieldValue(rec, field))
+		return ok && key < beforeKey
+	}
+}
@@ -0,0 +1,728 @@
+package ivy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type S3FileSystem is a FileSystem that keeps the database in a bucket of an
+// S3-compatible object store (AWS S3, MinIO, R2, etc.), so programs without a
+// persistent disk can still use ivy. Tables are key prefixes and records are
+// objects. Requests are signed with AWS Signature Version 4 and use path-style
+// addressing.
+//
+// Because ivy reads every record of an indexed table when it builds indexes,
+// S3FileSystem can cache object contents in a local directory (CacheDir).
+// Cached objects are validated against the ETags returned by the latest
+// directory listing, so rebuilding indexes only downloads records that changed.
+type S3FileSystem struct {
+	// Endpoint is the base URL of the object store, e.g.
+	// "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+	// Region is the signing region, e.g. "us-east-1".
+	Region string
+	// Bucket is the bucket holding the database.
+	Bucket string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// CacheDir is an optional local directory used to cache object contents.
+	CacheDir string
+
+	// Client is the HTTP client used for requests. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+//*****************************************************************************
+// FileSystem Methods
+//*****************************************************************************
+
+// ReadFile returns the contents of the object stored under name.
+func (s *S3FileSystem) ReadFile(name string) ([]byte, error) {
+	key := s3Key(name)
+
+	cached, cachedETag := s.readCache(key)
+	if cached != nil && cachedETag == s.latestETag(key) {
+		return cached, nil
+	}
+
+	header := make(http.Header)
+	if cached != nil {
+		header.Set("If-None-Match", cachedETag)
+	}
+
+	resp, err := s.do("GET", key, nil, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		s.setETag(key, cachedETag)
+		return cached, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case resp.StatusCode/100 != 2:
+		return nil, s3Error("GET", key, resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := resp.Header.Get("ETag")
+	s.setETag(key, etag)
+	s.writeCache(key, etag, data)
+
+	return data, nil
+}
+
+// WriteFile stores data as the object name. The permission bits are ignored.
+func (s *S3FileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	key := s3Key(name)
+
+	resp, err := s.do("PUT", key, nil, data, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return s3Error("PUT", key, resp)
+	}
+
+	etag := resp.Header.Get("ETag")
+	s.setETag(key, etag)
+	s.writeCache(key, etag, data)
+
+	return nil
+}
+
+// Remove deletes the object name. Like os.Remove, it returns an error
+// satisfying os.IsNotExist if the object does not exist.
+func (s *S3FileSystem) Remove(name string) error {
+	key := s3Key(name)
+
+	resp, err := s.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	resp, err = s.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return s3Error("DELETE", key, resp)
+	}
+
+	s.setETag(key, "")
+	s.removeCache(key)
+
+	return nil
+}
+
+// ReadDir lists the objects and common prefixes directly below name, sorted
+// by name.
+func (s *S3FileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := s3Key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var infos []os.FileInfo
+	found := prefix == ""
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("delimiter", "/")
+
+	for {
+		result, err := s.list(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			found = true
+
+			// Skip the directory marker written by MkdirAll.
+			if obj.Key == prefix {
+				continue
+			}
+
+			s.setETag(obj.Key, obj.ETag)
+			infos = append(infos, &s3FileInfo{name: path.Base(obj.Key), size: obj.Size, modTime: obj.LastModified})
+		}
+
+		for _, p := range result.CommonPrefixes {
+			found = true
+			infos = append(infos, &s3FileInfo{name: path.Base(p.Prefix), dir: true})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return infos, nil
+}
+
+// Stat returns a FileInfo describing the object or prefix name.
+func (s *S3FileSystem) Stat(name string) (os.FileInfo, error) {
+	key := s3Key(name)
+	if key == "" {
+		return &s3FileInfo{name: "/", dir: true}, nil
+	}
+
+	resp, err := s.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &s3FileInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return nil, s3Error("HEAD", key, resp)
+	}
+
+	// There is no object with that key, but it may be a prefix.
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", key+"/")
+	query.Set("max-keys", "1")
+
+	result, err := s.list(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Contents) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return &s3FileInfo{name: path.Base(key), dir: true}, nil
+}
+
+// MkdirAll writes an empty directory marker object so that an empty table
+// prefix still exists.
+func (s *S3FileSystem) MkdirAll(name string, perm os.FileMode) error {
+	key := s3Key(name)
+	if key == "" {
+		return nil
+	}
+
+	resp, err := s.do("PUT", key+"/", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return s3Error("PUT", key+"/", resp)
+	}
+
+	return nil
+}
+
+// Rename copies the object oldName to newName with a server-side copy and then
+// deletes oldName. Single object writes are already atomic in S3, so ivy never
+// relies on Rename being atomic here.
+func (s *S3FileSystem) Rename(oldName string, newName string) error {
+	oldKey := s3Key(oldName)
+	newKey := s3Key(newName)
+
+	header := make(http.Header)
+	header.Set("x-amz-copy-source", "/"+s3Escape(s.Bucket, true)+"/"+s3Escape(oldKey, false))
+
+	resp, err := s.do("PUT", newKey, nil, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode/100 != 2 {
+		return s3Error("PUT", newKey, resp)
+	}
+
+	// The copy's ETag is in the response body; forget the cached one.
+	s.setETag(newKey, "")
+	s.removeCache(newKey)
+
+	return s.Remove(oldName)
+}
+
+//*****************************************************************************
+// Uploader Methods
+//*****************************************************************************
+
+// Upload stores everything read from r as the object name, so that an
+// S3FileSystem can be used as the destination of DB.BackupTo. Large uploads
+// are streamed as a multipart upload, holding only one part in memory at a
+// time.
+func (s *S3FileSystem) Upload(name string, r io.Reader) error {
+	key := s3Key(name)
+
+	part := make([]byte, s3PartSize)
+
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.WriteFile(name, part[:n], 0600)
+	}
+	if err != nil {
+		return err
+	}
+
+	uploadId, err := s.createMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.uploadParts(key, uploadId, part, r)
+	if err != nil {
+		s.abortMultipartUpload(key, uploadId)
+		return err
+	}
+
+	return nil
+}
+
+//*****************************************************************************
+// Private S3FileSystem Methods
+//*****************************************************************************
+
+// s3PartSize is the size of the parts of a multipart upload, the minimum S3
+// accepts.
+const s3PartSize = 5 << 20
+
+// s3CompletedPart is a part listed in a CompleteMultipartUpload request.
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// createMultipartUpload starts a multipart upload and returns its id.
+func (s *S3FileSystem) createMultipartUpload(key string) (string, error) {
+	resp, err := s.do("POST", key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", s3Error("POST", key, resp)
+	}
+
+	var result struct {
+		UploadId string `xml:"UploadId"`
+	}
+
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+
+	return result.UploadId, nil
+}
+
+// uploadParts uploads the first part, which has already been read, and the
+// rest of r as the parts of a multipart upload, and completes it.
+func (s *S3FileSystem) uploadParts(key string, uploadId string, first []byte, r io.Reader) error {
+	var parts []s3CompletedPart
+
+	part := first
+
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {uploadId}}
+
+		resp, err := s.do("PUT", key, query, part, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return s3Error("PUT", key, resp)
+		}
+
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		part = first[:cap(first)]
+		n, err := io.ReadFull(r, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		part = part[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do("POST", key, url.Values{"uploadId": {uploadId}}, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return s3Error("POST", key, resp)
+	}
+
+	s.setETag(key, "")
+	s.removeCache(key)
+
+	return nil
+}
+
+// abortMultipartUpload cancels a multipart upload, freeing its parts.
+func (s *S3FileSystem) abortMultipartUpload(key string, uploadId string) {
+	resp, err := s.do("DELETE", key, url.Values{"uploadId": {uploadId}}, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// atomicWrites reports that a PUT replaces an object all at once, so records
+// can be written in place.
+func (s *S3FileSystem) atomicWrites() bool {
+	return true
+}
+
+// s3ListResult is the response body of a ListObjectsV2 request.
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		ETag         string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list runs a ListObjectsV2 request against the bucket.
+func (s *S3FileSystem) list(query url.Values) (*s3ListResult, error) {
+	resp, err := s.do("GET", "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, s3Error("GET", "?list-type=2", resp)
+	}
+
+	result := new(s3ListResult)
+
+	err = xml.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// do sends a signed request for a key in the bucket.
+func (s *S3FileSystem) do(method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	canonicalURI := "/" + s3Escape(s.Bucket, true)
+	if key != "" {
+		canonicalURI += "/" + s3Escape(key, false)
+	}
+
+	canonicalQuery := s3CanonicalQuery(query)
+
+	rawURL := strings.TrimRight(s.Endpoint, "/") + canonicalURI
+	if canonicalQuery != "" {
+		rawURL += "?" + canonicalQuery
+	}
+
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	s.sign(req, canonicalURI, canonicalQuery, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3FileSystem) sign(req *http.Request, canonicalURI string, canonicalQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// latestETag returns the last ETag seen for key, from a listing or a write.
+func (s *S3FileSystem) latestETag(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.etags[key]
+}
+
+// setETag records the last ETag seen for key.
+func (s *S3FileSystem) setETag(key string, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.etags == nil {
+		s.etags = make(map[string]string)
+	}
+
+	if etag == "" {
+		delete(s.etags, key)
+	} else {
+		s.etags[key] = etag
+	}
+}
+
+// cachePath returns the local cache file name for key.
+func (s *S3FileSystem) cachePath(key string) string {
+	return filepath.Join(s.CacheDir, sha256Hex([]byte(key)))
+}
+
+// readCache returns the cached contents and ETag of key, or nil if key is not
+// cached.
+func (s *S3FileSystem) readCache(key string) ([]byte, string) {
+	if s.CacheDir == "" {
+		return nil, ""
+	}
+
+	etag, err := ioutil.ReadFile(s.cachePath(key) + ".etag")
+	if err != nil {
+		return nil, ""
+	}
+
+	data, err := ioutil.ReadFile(s.cachePath(key))
+	if err != nil {
+		return nil, ""
+	}
+
+	return data, string(etag)
+}
+
+// writeCache stores the contents and ETag of key in the local cache. Cache
+// failures are not fatal; the object is simply fetched again next time.
+func (s *S3FileSystem) writeCache(key string, etag string, data []byte) {
+	if s.CacheDir == "" || etag == "" {
+		return
+	}
+
+	if err := os.MkdirAll(s.CacheDir, 0700); err != nil {
+		return
+	}
+
+	if err := ioutil.WriteFile(s.cachePath(key), data, 0600); err != nil {
+		return
+	}
+
+	ioutil.WriteFile(s.cachePath(key)+".etag", []byte(etag), 0600)
+}
+
+// removeCache drops key from the local cache.
+func (s *S3FileSystem) removeCache(key string) {
+	if s.CacheDir == "" {
+		return
+	}
+
+	os.Remove(s.cachePath(key) + ".etag")
+	os.Remove(s.cachePath(key))
+}
+
+//*****************************************************************************
+// s3FileInfo
+//*****************************************************************************
+
+// s3FileInfo implements os.FileInfo for objects and prefixes.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi *s3FileInfo) Sys() interface{}   { return nil }
+
+func (fi *s3FileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0700
+	}
+	return 0600
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// s3Key converts a file system path into an object key.
+func s3Key(name string) string {
+	key := strings.Trim(path.Clean(filepath.ToSlash(name)), "/")
+	if key == "." {
+		return ""
+	}
+	return key
+}
+
+// s3Escape URI-encodes s the way Signature Version 4 expects.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name.
+func s3CanonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// s3Error builds an error from an unexpected response.
+func s3Error(method string, key string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("ivy: s3 %v %v: %v: %s", method, key, resp.Status, bytes.TrimSpace(body))
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
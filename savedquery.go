@@ -0,0 +1,182 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// savedQueriesTable is the name of the table holding the saved queries. It is
+// created by the first SaveQuery.
+const savedQueriesTable = "_queries"
+
+// Type SavedQuery is a struct holding a query of the query language saved in
+// the database under a name by DB.SaveQuery, such as a report used by several
+// programs. The query may hold "?" placeholders, whose arguments are passed
+// to DB.RunSaved.
+type SavedQuery struct {
+	Name        string `json:"name"`
+	Table       string `json:"table"`
+	Query       string `json:"query"`
+	Description string `json:"description,omitempty"`
+
+	id string
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// SaveQuery saves a query under a name, replacing any query saved under that
+// name before. Saved queries are records of the reserved table "_queries", so
+// they are backed up, exported and replicated with the rest of the database,
+// and are run by name with RunSaved, the CLI's run command or the admin API.
+// The query is checked for syntax errors before it is saved. It takes the
+// name and the query, whose Name is ignored. It returns any error
+// encountered.
+func (db *DB) SaveQuery(name string, spec SavedQuery) error {
+	if name == "" {
+		return errors.New("ivy: a saved query needs a name")
+	}
+
+	if spec.Table == "" {
+		return fmt.Errorf("ivy: saved query %s needs a table", name)
+	}
+
+	tokens, err := lexQuery(spec.Query)
+	if err != nil {
+		return err
+	}
+
+	p := &qlParser{src: spec.Query, tokens: tokens}
+	if _, err := p.parse(); err != nil {
+		return err
+	}
+
+	spec.Name = name
+
+	db.queriesMu.Lock()
+	defer db.queriesMu.Unlock()
+
+	queries, err := db.SavedQueries()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range queries {
+		if q.Name == name {
+			return db.Update(savedQueriesTable, spec, q.id)
+		}
+	}
+
+	if db.tblLock(savedQueriesTable) == nil {
+		err = db.RegisterTable(savedQueriesTable, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Create(savedQueriesTable, spec)
+
+	return err
+}
+
+// SavedQueries returns the saved queries, in order of their names. It returns
+// any error encountered.
+func (db *DB) SavedQueries() ([]SavedQuery, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	queries := []SavedQuery{}
+
+	rwLock := db.tblLock(savedQueriesTable)
+	if rwLock == nil {
+		return queries, nil
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	fileIds, err := db.engine.ids(savedQueriesTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		data, err := db.readRec(savedQueriesTable, fileId)
+		if err != nil {
+			return nil, recErr(savedQueriesTable, fileId, err)
+		}
+
+		var q SavedQuery
+
+		err = db.codec.Unmarshal(data, &q)
+		if err != nil {
+			return nil, corruptErr(savedQueriesTable, fileId, err.Error())
+		}
+
+		q.id = fileId
+		queries = append(queries, q)
+	}
+
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+
+	return queries, nil
+}
+
+// DeleteSavedQuery deletes the query saved under a name. It takes the name.
+// It returns any error encountered, wrapping ErrNotFound if no query is saved
+// under the name.
+func (db *DB) DeleteSavedQuery(name string) error {
+	db.queriesMu.Lock()
+	defer db.queriesMu.Unlock()
+
+	q, err := db.savedQuery(name)
+	if err != nil {
+		return err
+	}
+
+	return db.Delete(savedQueriesTable, q.id)
+}
+
+// RunSaved runs the query saved under a name; see QueryString. It takes the
+// name and the arguments of the query's placeholders. It returns a slice of
+// record ids and any error encountered, wrapping ErrNotFound if no query is
+// saved under the name.
+func (db *DB) RunSaved(name string, args ...interface{}) ([]string, error) {
+	return db.RunSavedCtx(context.Background(), name, args...)
+}
+
+// RunSavedCtx is RunSaved with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (db *DB) RunSavedCtx(ctx context.Context, name string, args ...interface{}) ([]string, error) {
+	q, err := db.savedQuery(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.QueryStringCtx(ctx, q.Table, q.Query, args...)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// savedQuery returns the query saved under a name.
+func (db *DB) savedQuery(name string) (*SavedQuery, error) {
+	queries, err := db.SavedQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range queries {
+		if q.Name == name {
+			return &q, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no query saved as %s", ErrNotFound, name)
+}
@@ -0,0 +1,206 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Type Job is a struct describing a maintenance task that the database runs
+// periodically; see Options.Jobs. A job never overlaps with itself: a run
+// that is due while the previous one is still going is skipped.
+type Job struct {
+	// Name identifies the job in logs and to RunJob.
+	Name string
+
+	// Interval is the time between the end of one run and the start of the
+	// next.
+	Interval time.Duration
+
+	// Jitter, if positive, adds a random delay of up to Jitter to every
+	// interval, so that the jobs of many processes do not all run at once.
+	Jitter time.Duration
+
+	// Run does the work. Its context is cancelled when the database is
+	// closed; the database waits for the run to return.
+	Run func(ctx context.Context, db *DB) error
+}
+
+// scheduledJob is a job registered with the scheduler.
+type scheduledJob struct {
+	job     Job
+	running sync.Mutex
+}
+
+// CheckpointJob returns a Job writing the index checkpoints every interval;
+// see DB.Checkpoint.
+func CheckpointJob(interval time.Duration) Job {
+	return Job{Name: "checkpoint", Interval: interval, Run: func(ctx context.Context, db *DB) error {
+		return db.Checkpoint()
+	}}
+}
+
+// VerifyJob returns a Job checking the records of the supplied tables
+// against their checksums every interval; see DB.Verify. A run that finds
+// corrupt records fails, so that they are logged.
+func VerifyJob(interval time.Duration, tblNames ...string) Job {
+	return Job{Name: "verify", Interval: interval, Run: func(ctx context.Context, db *DB) error {
+		for _, tblName := range tblNames {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			report, err := db.Verify(tblName)
+			if err != nil {
+				return err
+			}
+
+			if len(report.Corrupt) > 0 {
+				return fmt.Errorf("%w: %s records %v", ErrCorrupt, tblName, report.Corrupt)
+			}
+		}
+
+		return nil
+	}}
+}
+
+// BackupJob returns a Job uploading a backup every interval; see
+// DB.BackupTo. Every backup is named by the prefix followed by the UTC time
+// it was taken and ".tar", such as "nightly-20240501T020000Z.tar".
+func BackupJob(interval time.Duration, u Uploader, prefix string, opts UploadOptions) Job {
+	return Job{Name: "backup", Interval: interval, Run: func(ctx context.Context, db *DB) error {
+		return db.BackupTo(u, prefix+time.Now().UTC().Format("20060102T150405Z")+".tar", opts)
+	}}
+}
+
+// CompactJob returns a Job compacting the supplied tables, or every table if
+// none are supplied, every interval; see DB.Compact. Tables without space to
+// reclaim are left as they are.
+func CompactJob(interval time.Duration, tblNames ...string) Job {
+	return Job{Name: "compact", Interval: interval, Run: func(ctx context.Context, db *DB) error {
+		names := tblNames
+		if len(names) == 0 {
+			var err error
+			if names, err = db.TableNames(); err != nil {
+				return err
+			}
+		}
+
+		for _, tblName := range names {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if _, err := db.Compact(tblName); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// RunJob runs a job of Options.Jobs now, rather than waiting for it to be
+// due. It takes the name of the job. It returns the job's error, or an error
+// wrapping ErrConflict if the job is running already, or wrapping
+// ErrNotFound if there is no such job.
+func (db *DB) RunJob(name string) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	sj := db.jobs[name]
+	if sj == nil {
+		return fmt.Errorf("%w: no job named %s", ErrNotFound, name)
+	}
+
+	if !sj.running.TryLock() {
+		return fmt.Errorf("%w: job %s is running", ErrConflict, name)
+	}
+	defer sj.running.Unlock()
+
+	return sj.job.Run(db.jobsCtx, db)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// startJobs registers the jobs and starts running them.
+func (db *DB) startJobs(jobs []Job) error {
+	db.jobs = make(map[string]*scheduledJob)
+
+	for _, job := range jobs {
+		switch {
+		case job.Name == "":
+			return errors.New("ivy: a job needs a name")
+		case db.jobs[job.Name] != nil:
+			return fmt.Errorf("ivy: two jobs are named %s", job.Name)
+		case job.Interval <= 0 || job.Run == nil:
+			return fmt.Errorf("ivy: job %s needs an interval and a function to run", job.Name)
+		}
+
+		db.jobs[job.Name] = &scheduledJob{job: job}
+	}
+
+	db.jobsCtx, db.stopJobs = context.WithCancel(context.Background())
+
+	for _, sj := range db.jobs {
+		db.jobsWg.Add(1)
+		go db.runJob(sj)
+	}
+
+	return nil
+}
+
+// runJob runs a job every time it is due, until the database is closed.
+func (db *DB) runJob(sj *scheduledJob) {
+	defer db.jobsWg.Done()
+
+	for {
+		wait := sj.job.Interval
+		if sj.job.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(sj.job.Jitter)))
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-db.jobsCtx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !sj.running.TryLock() {
+			db.logger.Warn("ivy: skipping a job that is still running", "job", sj.job.Name)
+			continue
+		}
+
+		if err := db.enter(); err != nil {
+			sj.running.Unlock()
+			return
+		}
+
+		start := time.Now()
+
+		err := sj.job.Run(db.jobsCtx, db)
+
+		db.leave()
+		sj.running.Unlock()
+
+		if err != nil && db.jobsCtx.Err() == nil {
+			db.logger.Warn("ivy: job failed", "job", sj.job.Name, "err", err)
+		} else {
+			db.logger.Debug("ivy: job done", "job", sj.job.Name, "duration", time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Type Selection is a struct reading only some fields of records, as returned
+// by DB.Select, so that records holding large fields can be listed without
+// decoding them.
+type Selection struct {
+	db     *DB
+	fields []string
+}
+
+// Find loads the selected fields of the record corresponding to a supplied id
+// into rec, which is a pointer to a struct, such as a partial struct holding
+// just those fields, or to a map. The other fields are left as they are. If
+// rec is a Record, its AfterFind method is called. It takes a table name, the
+// pointer to load, and an id specifying the record to find. It returns any
+// error encountered.
+func (s *Selection) Find(tblName string, rec interface{}, fileId string) error {
+	return s.FindCtx(context.Background(), tblName, rec, fileId)
+}
+
+// FindCtx is Find with a context. If the context is done once the table is
+// locked, it returns the context's error without reading the record.
+func (s *Selection) FindCtx(ctx context.Context, tblName string, rec interface{}, fileId string) (err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "find")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.metrics.countOp(tblName, "find")
+
+	data, err := s.readRec(tblName, fileId)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
+	err = db.codec.Unmarshal(data, rec)
+	if err != nil {
+		return err
+	}
+
+	if r, ok := rec.(Record); ok {
+		r.AfterFind(db, fileId)
+	}
+
+	return nil
+}
+
+// FindMany returns the selected fields of the records with the supplied ids,
+// such as the result of QueryString, as maps in the order of the ids. A
+// selected field missing from a record is missing from its map. It takes a
+// table name and the ids of the records. It returns the maps and any error
+// encountered, such as a record that does not exist.
+func (s *Selection) FindMany(tblName string, fileIds []string) ([]map[string]interface{}, error) {
+	return s.FindManyCtx(context.Background(), tblName, fileIds)
+}
+
+// FindManyCtx is FindMany with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (s *Selection) FindManyCtx(ctx context.Context, tblName string, fileIds []string) (_ []map[string]interface{}, err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "find")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	recs := make([]map[string]interface{}, 0, len(fileIds))
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := checkId(fileId); err != nil {
+			return nil, err
+		}
+
+		db.metrics.countOp(tblName, "find")
+
+		data, err := s.readRec(tblName, fileId)
+		if err != nil {
+			return nil, recErr(tblName, fileId, err)
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, corruptErr(tblName, fileId, err.Error())
+		}
+
+		recs = append(recs, rec)
+	}
+
+	return recs, nil
+}
+
+// Query returns the selected fields of the records matching a query of the
+// query language, as maps in the order of the query's results; see
+// DB.QueryString. It takes a table name, the query and the arguments of its
+// placeholders. It returns the maps and any error encountered.
+func (s *Selection) Query(tblName string, queryStr string, args ...interface{}) ([]map[string]interface{}, error) {
+	return s.QueryCtx(context.Background(), tblName, queryStr, args...)
+}
+
+// QueryCtx is Query with a context. It stops reading records with the
+// context's error as soon as the context is done.
+func (s *Selection) QueryCtx(ctx context.Context, tblName string, queryStr string, args ...interface{}) ([]map[string]interface{}, error) {
+	fileIds, err := s.db.QueryStringCtx(ctx, tblName, queryStr, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindManyCtx(ctx, tblName, fileIds)
+}
+
+// readRec reads a record and returns a JSON object holding just its selected
+// fields. The caller must hold the table's read lock.
+func (s *Selection) readRec(tblName string, fileId string) ([]byte, error) {
+	data, err := s.db.readRec(tblName, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = projectFields(data, s.fields)
+	if err != nil {
+		return nil, corruptErr(tblName, fileId, err.Error())
+	}
+
+	data, err = s.db.openFields(tblName, fileId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.db.restrict(tblName, fileId, data)
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Select returns a Selection reading only the supplied fields of records,
+// which may be paths into nested objects such as "maker.country":
+//
+//	var plane struct{ Name string; Speed int }
+//	err := db.Select("name", "speed").Find("planes", &plane, "1")
+//
+// The values of the other fields are skipped over without being decoded. It
+// takes the names of the fields. It returns the selection.
+func (db *DB) Select(fields ...string) *Selection {
+	return &Selection{db: db, fields: fields}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// projectFields returns a JSON object holding just the supplied fields of a
+// marshalled record, keeping the objects that lead to nested fields.
+func projectFields(data []byte, fields []string) ([]byte, error) {
+	var rec map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+
+	for _, field := range fields {
+		projectPath(rec, out, strings.Split(field, "."))
+	}
+
+	return json.Marshal(out)
+}
+
+// projectPath copies the field named by a path from a record to a projected
+// record. Objects along the path are decoded only one level at a time.
+func projectPath(rec map[string]json.RawMessage, out map[string]interface{}, names []string) {
+	raw, ok := rec[names[0]]
+	if !ok {
+		return
+	}
+
+	if len(names) == 1 {
+		out[names[0]] = raw
+		return
+	}
+
+	if _, ok := out[names[0]].(json.RawMessage); ok {
+		// The whole object has been selected already.
+		return
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return
+	}
+
+	sub, ok := out[names[0]].(map[string]interface{})
+	if !ok {
+		sub = make(map[string]interface{})
+		out[names[0]] = sub
+	}
+
+	projectPath(obj, sub, names[1:])
+}
@@ -0,0 +1,168 @@
+package ivy
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+// recordKeySize is the size of the AES key generated for every record of a
+// table listed in Options.RecordKeys.
+const recordKeySize = 32
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Shred erases the encrypted fields of a record for good by destroying the
+// data key they are sealed with; see Options.RecordKeys. The record itself is
+// left in place, with its encrypted fields reading as null, and so are its
+// copies in backups, exports and the write-ahead log, whose encrypted fields
+// can no longer be decrypted either, so that personal data can be erased
+// without rewriting any archive. Writing the record again seals its fields
+// with a new data key. Delete destroys the data key of the record it deletes
+// as well, so that a record created later with the same id gets a new one. It takes a table name and the record id. It returns
+// any error encountered.
+func (db *DB) Shred(tblName string, fileId string) error {
+	return db.ShredCtx(context.Background(), tblName, fileId)
+}
+
+// ShredCtx is Shred with a context, which is handed to the authorizer.
+func (db *DB) ShredCtx(ctx context.Context, tblName string, fileId string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "shred")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	if !db.recordKeys[tblName] {
+		return fmt.Errorf("ivy: table %s has no record keys to shred", tblName)
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	return db.dropRecordKey(tblName, fileId)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// recordKey returns the sealer of the data key of a record, creating the key
+// if create is true and the record has none. It returns nil if the record has
+// no key, because it was shredded or never had encrypted fields.
+//
+// The data keys are sealed with the field key and kept one per file in the
+// keys directory of the metadata directory, which backups leave out. A memory
+// database keeps them in memory.
+func (db *DB) recordKey(tblName string, fileId string, create bool) (*sealer, error) {
+	db.recKeysMu.Lock()
+	defer db.recKeysMu.Unlock()
+
+	name := tblName + "/" + fileId
+
+	if s, ok := db.recKeys[name]; ok {
+		return s, nil
+	}
+
+	var key []byte
+
+	if db.path != "" {
+		wrapped, err := db.fs.ReadFile(db.recordKeyPath(tblName, fileId))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err == nil {
+			key, err = db.fieldSealer.open(wrapped, "keys/"+name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: data key of %s: %v", ErrBadKey, name, err)
+			}
+		}
+	}
+
+	if key == nil {
+		if !create {
+			return nil, nil
+		}
+
+		key = make([]byte, recordKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		if db.path != "" {
+			wrapped, err := db.fieldSealer.seal(key, "keys/"+name)
+			if err != nil {
+				return nil, err
+			}
+
+			err = db.fs.MkdirAll(db.metaPath("keys", tblName), db.modes.dir)
+			if err != nil {
+				return nil, err
+			}
+
+			err = writeFileAtomic(db.fs, db.recordKeyPath(tblName, fileId), wrapped, db.modes.file)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	s, err := newSealer(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.recKeys == nil {
+		db.recKeys = make(map[string]*sealer)
+	}
+	db.recKeys[name] = s
+
+	return s, nil
+}
+
+// dropRecordKey destroys the data key of a record, if it has one.
+func (db *DB) dropRecordKey(tblName string, fileId string) error {
+	db.recKeysMu.Lock()
+	defer db.recKeysMu.Unlock()
+
+	delete(db.recKeys, tblName+"/"+fileId)
+
+	if db.path == "" {
+		return nil
+	}
+
+	err := db.fs.Remove(db.recordKeyPath(tblName, fileId))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// recordKeyPath returns the path of the file holding the data key of a
+// record.
+func (db *DB) recordKeyPath(tblName string, fileId string) string {
+	return db.metaPath("keys", tblName, fileId+".key")
+}
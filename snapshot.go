@@ -0,0 +1,532 @@
+package ivy
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Type Snapshot is a struct holding a read-only view of a database as it was
+// when DB.Snapshot was called, so that a report reading the database in
+// several steps sees a coherent state of it. Writes to the database go on as
+// usual; the records they replace or delete are copied into every open
+// snapshot first, so a snapshot costs memory in proportion to what changed
+// since it was taken, until it is closed. Tables created since the snapshot
+// was taken are not part of it. With Options.MVCC, a snapshot reads the
+// versions the database keeps instead, and never sees part of a transaction.
+// A Snapshot is safe for concurrent use.
+type Snapshot struct {
+	db *DB
+
+	// ids and fldIndexes are the ids and the field indexes of the tables
+	// when the snapshot was taken, without MVCC.
+	ids        map[string]map[string]bool
+	fldIndexes map[string]map[string]map[string][]string
+
+	// mvcc is set if the snapshot reads the versions of the database as of
+	// version.
+	mvcc    bool
+	version uint64
+
+	mu     sync.Mutex
+	saved  map[string]map[string][]byte
+	closed bool
+}
+
+// Find loads up a Record struct with the record corresponding to a supplied
+// id, as it was when the snapshot was taken; see DB.Find. It returns any
+// error encountered.
+func (s *Snapshot) Find(tblName string, rec Record, fileId string) error {
+	return s.FindCtx(context.Background(), tblName, rec, fileId)
+}
+
+// FindCtx is Find with a context, which is handed to the authorizer.
+func (s *Snapshot) FindCtx(ctx context.Context, tblName string, rec Record, fileId string) (err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, fileId, "find")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	rwLock, err := s.lock(tblName)
+	if err != nil {
+		return err
+	}
+	defer rwLock.RUnlock()
+
+	data, err := s.readRec(tblName, fileId)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
+	err = db.unmarshalRec(tblName, fileId, data, rec)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
+	rec.AfterFind(db, fileId)
+
+	return nil
+}
+
+// FindAllIds returns the ids of the records of a table when the snapshot was
+// taken, in id order. It takes a table name. It returns a slice of ids and
+// any error encountered.
+func (s *Snapshot) FindAllIds(tblName string) ([]string, error) {
+	return s.FindAllIdsCtx(context.Background(), tblName)
+}
+
+// FindAllIdsCtx is FindAllIds with a context, which is handed to the
+// authorizer.
+func (s *Snapshot) FindAllIdsCtx(ctx context.Context, tblName string) (_ []string, err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "ids")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock, err := s.lock(tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rwLock.RUnlock()
+
+	return s.tblIds(tblName)
+}
+
+// FindAllIdsForField returns the ids of the records of a table that held a
+// value in a field when the snapshot was taken; see DB.FindAllIdsForField.
+// It takes a table name, a field name and the value. It returns a slice of
+// ids and any error encountered.
+func (s *Snapshot) FindAllIdsForField(tblName string, searchField string, searchValue string) ([]string, error) {
+	return s.FindAllIdsForFieldCtx(context.Background(), tblName, searchField, searchValue)
+}
+
+// FindAllIdsForFieldCtx is FindAllIdsForField with a context, which is handed
+// to the authorizer.
+func (s *Snapshot) FindAllIdsForFieldCtx(ctx context.Context, tblName string, searchField string, searchValue string) (_ []string, err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock, err := s.lock(tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rwLock.RUnlock()
+
+	if fldIndex, ok := s.fldIndexes[tblName][searchField]; ok {
+		return indexLookup(fldIndex, searchValue, db.useNumber), nil
+	}
+
+	fileIds, err := s.tblIds(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, fileId := range fileIds {
+		data, err := s.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, err
+		}
+
+		value := fieldValue(rec, searchField)
+
+		match, err := fieldMatches(value, searchValue, db.useNumber)
+		if err != nil {
+			return nil, &FieldTypeError{Table: tblName, Id: fileId, Field: searchField, Value: value}
+		}
+		if match {
+			ids = append(ids, fileId)
+		}
+	}
+
+	return ids, nil
+}
+
+// Filter returns the ids of the records of a table that matched a filter
+// when the snapshot was taken, in id order; see DB.Filter. It takes a table
+// name and the filter. It returns a slice of ids and any error encountered.
+func (s *Snapshot) Filter(tblName string, filter M) ([]string, error) {
+	return s.FilterCtx(context.Background(), tblName, filter)
+}
+
+// FilterCtx is Filter with a context, which is handed to the authorizer and
+// stops reading records when it is done.
+func (s *Snapshot) FilterCtx(ctx context.Context, tblName string, filter M) (_ []string, err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "filter")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := filterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.runQuery(ctx, tblName, &query{where: where, limit: -1})
+}
+
+// QueryString returns the ids of the records of a table that matched a query
+// when the snapshot was taken; see DB.QueryString. It takes a table name, the
+// query and the arguments of its placeholders. It returns a slice of ids and
+// any error encountered.
+func (s *Snapshot) QueryString(tblName string, queryStr string, args ...interface{}) ([]string, error) {
+	return s.QueryStringCtx(context.Background(), tblName, queryStr, args...)
+}
+
+// QueryStringCtx is QueryString with a context, which is handed to the
+// authorizer and stops reading records when it is done.
+func (s *Snapshot) QueryStringCtx(ctx context.Context, tblName string, queryStr string, args ...interface{}) (_ []string, err error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(queryStr, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.runQuery(ctx, tblName, q)
+}
+
+// TableNames returns the names of the tables of the snapshot, in order.
+func (s *Snapshot) TableNames() []string {
+	if s.mvcc {
+		return s.db.tableNames()
+	}
+
+	tblNames := make([]string, 0, len(s.ids))
+	for tblName := range s.ids {
+		tblNames = append(tblNames, tblName)
+	}
+	sort.Strings(tblNames)
+
+	return tblNames
+}
+
+// Close releases the snapshot, and the copies of the records changed since it
+// was taken. The snapshot cannot be read afterwards.
+func (s *Snapshot) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.saved = nil
+	s.mu.Unlock()
+
+	if s.mvcc {
+		s.db.versions.unpin(s.version)
+		return nil
+	}
+
+	s.db.snapsMu.Lock()
+	delete(s.db.snaps, s)
+	s.db.snapsMu.Unlock()
+
+	return nil
+}
+
+// lock read-locks a table of the snapshot. It returns the lock, to unlock,
+// and an error if the snapshot is closed or has no such table.
+func (s *Snapshot) lock(tblName string) (*tblMutex, error) {
+	if _, ok := s.ids[tblName]; !ok && !s.mvcc {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock := s.db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+
+	if closed {
+		rwLock.RUnlock()
+		return nil, ErrClosed
+	}
+
+	return rwLock, nil
+}
+
+// runQuery returns the ids of the records of a table of the snapshot
+// matching a query. Every record is read, as the indexes of the database
+// may have changed since.
+func (s *Snapshot) runQuery(ctx context.Context, tblName string, q *query) ([]string, error) {
+	rwLock, err := s.lock(tblName)
+	if err != nil {
+		return nil, err
+	}
+	defer rwLock.RUnlock()
+
+	fileIds, err := s.tblIds(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.evalQuery(ctx, tblName, q, fileIds, s.readRec)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Ids, nil
+}
+
+// tblIds returns the ids of a table of the snapshot, in id order. The caller
+// must hold the table's read lock.
+func (s *Snapshot) tblIds(tblName string) ([]string, error) {
+	var fileIds []string
+
+	if s.mvcc {
+		current, err := s.db.engine.ids(tblName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fileId := range current {
+			if data, ok := s.db.versions.at(tblName, fileId, s.version); !ok || data != nil {
+				fileIds = append(fileIds, fileId)
+			}
+		}
+
+		// The records deleted since the snapshot was taken only have old
+		// versions left.
+		stored := make(map[string]bool, len(current))
+		for _, fileId := range current {
+			stored[fileId] = true
+		}
+
+		for _, fileId := range s.db.versions.ids(tblName) {
+			if stored[fileId] {
+				continue
+			}
+			if data, ok := s.db.versions.at(tblName, fileId, s.version); ok && data != nil {
+				fileIds = append(fileIds, fileId)
+			}
+		}
+	} else {
+		for fileId := range s.ids[tblName] {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	return fileIds, nil
+}
+
+// readRec returns a marshalled record as it was when the snapshot was taken:
+// the copy saved when it was changed since, or else the record of the
+// database. The caller must hold the table's read lock, so that the record
+// cannot change in between.
+func (s *Snapshot) readRec(tblName string, fileId string) ([]byte, error) {
+	if s.mvcc {
+		data, ok := s.db.versions.at(tblName, fileId, s.version)
+		if !ok {
+			return s.db.readRec(tblName, fileId)
+		}
+		if data == nil {
+			return nil, &os.PathError{Op: "read", Path: tblName + "/" + fileId, Err: os.ErrNotExist}
+		}
+
+		return s.db.decodeRec(tblName, fileId, data)
+	}
+
+	if !s.ids[tblName][fileId] {
+		return nil, &os.PathError{Op: "read", Path: tblName + "/" + fileId, Err: os.ErrNotExist}
+	}
+
+	s.mu.Lock()
+	raw, ok := s.saved[tblName][fileId]
+	s.mu.Unlock()
+
+	if ok {
+		return s.db.decodeRec(tblName, fileId, raw)
+	}
+
+	return s.db.readRec(tblName, fileId)
+}
+
+// save keeps the stored version of a record about to be changed, unless it
+// is not part of the snapshot or was saved already.
+func (s *Snapshot) save(tblName string, fileId string, old []byte) {
+	if !s.ids[tblName][fileId] {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if _, ok := s.saved[tblName][fileId]; ok {
+		return
+	}
+
+	if s.saved[tblName] == nil {
+		s.saved[tblName] = make(map[string][]byte)
+	}
+
+	s.saved[tblName][fileId] = append([]byte(nil), old...)
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Snapshot returns a read-only view of the database as it is now, whose
+// results are not affected by later writes; see Snapshot. Every table is
+// read-locked while the snapshot is taken, to read its ids and copy its
+// field indexes, unless Options.MVCC is set. Close the snapshot when done
+// with it. It returns the snapshot and any error encountered.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	if db.versions != nil {
+		return &Snapshot{db: db, mvcc: true, version: db.versions.pin()}, nil
+	}
+
+	s := &Snapshot{
+		db:         db,
+		ids:        make(map[string]map[string]bool),
+		fldIndexes: make(map[string]map[string]map[string][]string),
+		saved:      make(map[string]map[string][]byte),
+	}
+
+	// Unindexed tables are part of the snapshot as well, so every table is
+	// taken rather than those of the indexes.
+	tblNames := db.tableNames()
+
+	// The tables are locked all at once, in order, so that the snapshot is
+	// coherent across them.
+	for _, tblName := range tblNames {
+		rwLock := db.tblLock(tblName)
+		rwLock.RLock()
+		defer rwLock.RUnlock()
+	}
+
+	for _, tblName := range tblNames {
+		fileIds, err := db.engine.ids(tblName)
+		if err != nil {
+			return nil, err
+		}
+
+		s.ids[tblName] = make(map[string]bool, len(fileIds))
+		for _, fileId := range fileIds {
+			s.ids[tblName][fileId] = true
+		}
+
+		s.fldIndexes[tblName] = copyFldIndexes(db.fldIndex(tblName))
+	}
+
+	db.snapsMu.Lock()
+	if db.snaps == nil {
+		db.snaps = make(map[*Snapshot]bool)
+	}
+	db.snaps[s] = true
+	db.snapsMu.Unlock()
+
+	return s, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// saveForSnapshots keeps the stored version of a record about to be changed
+// in every open snapshot. old is nil if the record is new. The caller must
+// hold the table's write lock.
+func (db *DB) saveForSnapshots(tblName string, fileId string, old []byte) {
+	if old == nil {
+		return
+	}
+
+	db.snapsMu.Lock()
+	defer db.snapsMu.Unlock()
+
+	for s := range db.snaps {
+		s.save(tblName, fileId, old)
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// copyFldIndexes returns a deep copy of the field indexes of a table.
+func copyFldIndexes(fldIndexes map[string]map[string][]string) map[string]map[string][]string {
+	cp := make(map[string]map[string][]string, len(fldIndexes))
+
+	for fldName, fldIndex := range fldIndexes {
+		cp[fldName] = make(map[string][]string, len(fldIndex))
+
+		for key, fileIds := range fldIndex {
+			cp[fldName][key] = append([]string(nil), fileIds...)
+		}
+	}
+
+	return cp
+}
@@ -0,0 +1,1205 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type SQLiteTable is a struct describing how a SQLite table is imported by
+// ImportFromSQLite.
+type SQLiteTable struct {
+	// Table is the name of the ivy table the rows become records of. It
+	// defaults to the name of the SQLite table.
+	Table string
+
+	// TagsColumn names a column holding a list of tags separated by commas
+	// or semicolons, or a JSON array as written by ExportToSQLite, which is
+	// imported as the tags field of the records.
+	TagsColumn string
+}
+
+// sqliteMagic starts every SQLite database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqlitePageSize is the page size of SQLite files written by ExportToSQLite.
+const sqlitePageSize = 4096
+
+// SQLite b-tree page types.
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+)
+
+// sqliteFile is a SQLite database file read into memory. Only what is needed
+// to read the rows of ordinary tables is supported.
+type sqliteFile struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+// sqliteTable is a table described by the schema of a SQLite database.
+type sqliteTable struct {
+	name     string
+	rootPage int
+	columns  []string
+
+	// rowidColumn is the index of the INTEGER PRIMARY KEY column, which is
+	// an alias of the rowid, or -1 if there is none.
+	rowidColumn int
+}
+
+// sqliteRow is a row of a SQLite table.
+type sqliteRow struct {
+	rowid  int64
+	values []interface{}
+}
+
+// ImportFromSQLite turns the tables of a SQLite database file into ivy tables,
+// to move an application off SQLite: every row becomes a record, with the
+// rowid as its id and a field for every column. Integers and reals become
+// numbers, text becomes strings, blobs become base64 strings and NULLs become
+// nulls. The file is read directly, without SQLite itself, which limits the
+// import to ordinary tables with rowids in a UTF-8 database. It takes the path
+// of the SQLite file, the path of the ivy database directory, which is created
+// if necessary, and a mapping from the SQLite tables to import to the way they
+// are imported. If the mapping is nil, every table is imported as it is. The
+// ivy tables must not exist yet, or be empty. It returns any error
+// encountered.
+func ImportFromSQLite(sqlitePath string, dbPath string, mapping map[string]SQLiteTable) error {
+	f, err := openSQLite(sqlitePath)
+	if err != nil {
+		return err
+	}
+
+	tables, err := f.tables()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+
+	var imports []*sqliteTable
+
+	for _, tbl := range tables {
+		found[tbl.name] = true
+
+		if _, ok := mapping[tbl.name]; ok || mapping == nil {
+			imports = append(imports, tbl)
+		}
+	}
+
+	for name := range mapping {
+		if !found[name] {
+			return fmt.Errorf("ivy: SQLite table %s does not exist or cannot be imported", name)
+		}
+	}
+
+	for _, tbl := range imports {
+		tblName := tbl.name
+		if m := mapping[tbl.name]; m.Table != "" {
+			tblName = m.Table
+		}
+
+		tblPath := filepath.Join(dbPath, tblName)
+
+		files, err := ioutil.ReadDir(tblPath)
+		if err == nil && len(files) > 0 {
+			return fmt.Errorf("ivy: cannot import into table %s: it is not empty", tblName)
+		}
+
+		err = os.MkdirAll(tblPath, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+
+	for _, tbl := range imports {
+		err = db.importSQLiteTable(f, tbl, mapping[tbl.name])
+		if err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	return db.Close()
+}
+
+// openSQLite reads a SQLite database file.
+func openSQLite(path string) (*sqliteFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 100 || string(data[:16]) != sqliteMagic {
+		return nil, fmt.Errorf("ivy: %s is not a SQLite database", path)
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	if enc := binary.BigEndian.Uint32(data[56:60]); enc > 1 {
+		return nil, fmt.Errorf("ivy: %s uses a UTF-16 text encoding, which is not supported", path)
+	}
+
+	if pageSize < 512 || len(data)%pageSize != 0 {
+		return nil, fmt.Errorf("ivy: %s is not a valid SQLite database", path)
+	}
+
+	return &sqliteFile{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// tables returns the ordinary tables of the database, leaving out internal
+// tables, tables without rowids and virtual tables.
+func (f *sqliteFile) tables() ([]*sqliteTable, error) {
+	var tables []*sqliteTable
+
+	err := f.scan(1, func(row sqliteRow) error {
+		if len(row.values) < 5 {
+			return errors.New("ivy: invalid SQLite schema")
+		}
+
+		typ, _ := row.values[0].(string)
+		name, _ := row.values[1].(string)
+		rootPage, _ := row.values[3].(int64)
+		sql, _ := row.values[4].(string)
+
+		if typ != "table" || rootPage == 0 || strings.HasPrefix(name, "sqlite_") {
+			return nil
+		}
+
+		columns, rowidColumn, ok := parseCreateTable(sql)
+		if !ok {
+			return nil
+		}
+
+		tables = append(tables, &sqliteTable{name: name, rootPage: int(rootPage), columns: columns, rowidColumn: rowidColumn})
+
+		return nil
+	})
+
+	return tables, err
+}
+
+// scan calls fn with every row of the table b-tree with the supplied root
+// page, in rowid order.
+func (f *sqliteFile) scan(pageNum int, fn func(row sqliteRow) error) error {
+	page, hdr, err := f.page(pageNum)
+	if err != nil {
+		return err
+	}
+
+	typ := page[hdr]
+	cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+
+	ptrs := hdr + 8
+	if typ == sqliteInteriorTable {
+		ptrs = hdr + 12
+	}
+
+	if ptrs+2*cells > len(page) {
+		return errors.New("ivy: corrupt SQLite page")
+	}
+
+	for i := 0; i < cells; i++ {
+		off := int(binary.BigEndian.Uint16(page[ptrs+2*i:]))
+		if off+4 > len(page) {
+			return errors.New("ivy: corrupt SQLite page")
+		}
+
+		switch typ {
+		case sqliteInteriorTable:
+			err = f.scan(int(binary.BigEndian.Uint32(page[off:])), fn)
+
+		case sqliteLeafTable:
+			var row sqliteRow
+
+			row, err = f.leafCell(page, off)
+			if err == nil {
+				err = fn(row)
+			}
+
+		default:
+			return fmt.Errorf("ivy: unsupported SQLite page type %d", typ)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if typ == sqliteInteriorTable {
+		return f.scan(int(binary.BigEndian.Uint32(page[hdr+8:])), fn)
+	}
+
+	return nil
+}
+
+// page returns a page of the database and the offset of its b-tree header,
+// which follows the file header on the first page.
+func (f *sqliteFile) page(pageNum int) ([]byte, int, error) {
+	start := (pageNum - 1) * f.pageSize
+	if pageNum < 1 || start+f.pageSize > len(f.data) {
+		return nil, 0, fmt.Errorf("ivy: SQLite page %d out of range", pageNum)
+	}
+
+	page := f.data[start : start+f.pageSize]
+
+	hdr := 0
+	if pageNum == 1 {
+		hdr = 100
+	}
+
+	return page, hdr, nil
+}
+
+// leafCell decodes the row held by a cell of a table leaf page, following its
+// overflow pages.
+func (f *sqliteFile) leafCell(page []byte, off int) (sqliteRow, error) {
+	size, n := sqliteVarint(page[off:])
+	off += n
+
+	rowid, n := sqliteVarint(page[off:])
+	off += n
+
+	payloadSize := int(size)
+
+	// The part of the payload stored on the page itself.
+	local := payloadSize
+	maxLocal := f.usable - 35
+
+	if payloadSize > maxLocal {
+		minLocal := (f.usable-12)*32/255 - 23
+		local = minLocal + (payloadSize-minLocal)%(f.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	if off+local > len(page) {
+		return sqliteRow{}, errors.New("ivy: corrupt SQLite cell")
+	}
+
+	payload := append([]byte(nil), page[off:off+local]...)
+
+	if local < payloadSize {
+		if off+local+4 > len(page) {
+			return sqliteRow{}, errors.New("ivy: corrupt SQLite cell")
+		}
+
+		next := int(binary.BigEndian.Uint32(page[off+local:]))
+
+		for len(payload) < payloadSize {
+			overflow, _, err := f.page(next)
+			if err != nil {
+				return sqliteRow{}, err
+			}
+
+			chunk := f.usable - 4
+			if rest := payloadSize - len(payload); chunk > rest {
+				chunk = rest
+			}
+
+			payload = append(payload, overflow[4:4+chunk]...)
+			next = int(binary.BigEndian.Uint32(overflow))
+		}
+	}
+
+	values, err := decodeSQLiteRecord(payload)
+	if err != nil {
+		return sqliteRow{}, err
+	}
+
+	return sqliteRow{rowid: int64(rowid), values: values}, nil
+}
+
+// sqliteWriter assembles the pages of a new SQLite database file. Page 1,
+// which holds the schema, is reserved from the start.
+type sqliteWriter struct {
+	pages [][]byte
+}
+
+// sqliteChild is a page of a b-tree being built, with the largest rowid it
+// holds.
+type sqliteChild struct {
+	page   int
+	maxKey int64
+}
+
+// sqliteCell is a cell of a table leaf page, with the rowid it holds.
+type sqliteCell struct {
+	rowid int64
+	data  []byte
+}
+
+// newSQLiteWriter returns a writer for a database with the default page
+// size.
+func newSQLiteWriter() *sqliteWriter {
+	return &sqliteWriter{pages: [][]byte{make([]byte, sqlitePageSize)}}
+}
+
+// addPage adds an empty page, returning its page number.
+func (w *sqliteWriter) addPage() int {
+	w.pages = append(w.pages, make([]byte, sqlitePageSize))
+	return len(w.pages)
+}
+
+// writeTable writes a table b-tree holding the supplied rows, which must be
+// in rowid order. It returns the root page, which is page 1 if schema is
+// true.
+func (w *sqliteWriter) writeTable(rows []sqliteRow, schema bool) int {
+	cells := make([]sqliteCell, len(rows))
+	for i, row := range rows {
+		cells[i] = sqliteCell{rowid: row.rowid, data: w.leafCell(row)}
+	}
+
+	rootSpace := sqlitePageSize - 8
+	if schema {
+		rootSpace -= 100
+	}
+
+	// A table that fits on its root page needs no interior pages.
+	if cellsSize(cells) <= rootSpace {
+		root := 1
+		if !schema {
+			root = w.addPage()
+		}
+		w.writeLeaf(root, cells)
+		return root
+	}
+
+	var children []sqliteChild
+
+	for len(cells) > 0 {
+		n, space := 0, sqlitePageSize-8
+		for n < len(cells) && len(cells[n].data)+2 <= space {
+			space -= len(cells[n].data) + 2
+			n++
+		}
+
+		page := w.addPage()
+		w.writeLeaf(page, cells[:n])
+		children = append(children, sqliteChild{page: page, maxKey: cells[n-1].rowid})
+		cells = cells[n:]
+	}
+
+	return w.writeInterior(children, schema)
+}
+
+// writeInterior writes the interior pages above a level of a b-tree. It
+// returns the root page, which is page 1 if schema is true.
+func (w *sqliteWriter) writeInterior(children []sqliteChild, schema bool) int {
+	rootSpace := sqlitePageSize - 12
+	if schema {
+		rootSpace -= 100
+	}
+
+	if interiorSize(children) <= rootSpace {
+		root := 1
+		if !schema {
+			root = w.addPage()
+		}
+		w.writeInteriorPage(root, children)
+		return root
+	}
+
+	var parents []sqliteChild
+
+	for len(children) > 0 {
+		// Every child but the last takes a cell; the last one is the page's
+		// right-most pointer. Each page needs at least two children.
+		n, space := 1, sqlitePageSize-12
+		for n < len(children) && (n < 2 || interiorCellSize(children[n-1])+2 <= space) {
+			space -= interiorCellSize(children[n-1]) + 2
+			n++
+		}
+		if len(children)-n == 1 {
+			n--
+		}
+
+		page := w.addPage()
+		w.writeInteriorPage(page, children[:n])
+		parents = append(parents, sqliteChild{page: page, maxKey: children[n-1].maxKey})
+		children = children[n:]
+	}
+
+	return w.writeInterior(parents, schema)
+}
+
+// writeLeaf fills a table leaf page with cells.
+func (w *sqliteWriter) writeLeaf(pageNum int, cells []sqliteCell) {
+	page, hdr := w.pages[pageNum-1], 0
+	if pageNum == 1 {
+		hdr = 100
+	}
+
+	page[hdr] = sqliteLeafTable
+	binary.BigEndian.PutUint16(page[hdr+3:], uint16(len(cells)))
+
+	end := len(page)
+	for i, cell := range cells {
+		end -= len(cell.data)
+		copy(page[end:], cell.data)
+		binary.BigEndian.PutUint16(page[hdr+8+2*i:], uint16(end))
+	}
+
+	binary.BigEndian.PutUint16(page[hdr+5:], uint16(end))
+}
+
+// writeInteriorPage fills a table interior page with pointers to its
+// children.
+func (w *sqliteWriter) writeInteriorPage(pageNum int, children []sqliteChild) {
+	page, hdr := w.pages[pageNum-1], 0
+	if pageNum == 1 {
+		hdr = 100
+	}
+
+	last := children[len(children)-1]
+	children = children[:len(children)-1]
+
+	page[hdr] = sqliteInteriorTable
+	binary.BigEndian.PutUint16(page[hdr+3:], uint16(len(children)))
+	binary.BigEndian.PutUint32(page[hdr+8:], uint32(last.page))
+
+	end := len(page)
+	for i, child := range children {
+		cell := make([]byte, 4, interiorCellSize(child))
+		binary.BigEndian.PutUint32(cell, uint32(child.page))
+		cell = appendSQLiteVarint(cell, uint64(child.maxKey))
+
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[hdr+12+2*i:], uint16(end))
+	}
+
+	binary.BigEndian.PutUint16(page[hdr+5:], uint16(end))
+}
+
+// leafCell encodes a row as a cell of a table leaf page, moving the part of
+// it that does not fit onto overflow pages.
+func (w *sqliteWriter) leafCell(row sqliteRow) []byte {
+	payload := encodeSQLiteRecord(row.values)
+
+	usable := sqlitePageSize
+	local := len(payload)
+	maxLocal := usable - 35
+
+	if local > maxLocal {
+		minLocal := (usable-12)*32/255 - 23
+		local = minLocal + (len(payload)-minLocal)%(usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	cell := appendSQLiteVarint(nil, uint64(len(payload)))
+	cell = appendSQLiteVarint(cell, uint64(row.rowid))
+	cell = append(cell, payload[:local]...)
+
+	if local == len(payload) {
+		return cell
+	}
+
+	rest := payload[local:]
+	first := w.addPage()
+	cell = binary.BigEndian.AppendUint32(cell, uint32(first))
+
+	for page := first; ; {
+		n := copy(w.pages[page-1][4:], rest)
+		rest = rest[n:]
+		if len(rest) == 0 {
+			break
+		}
+
+		next := w.addPage()
+		binary.BigEndian.PutUint32(w.pages[page-1], uint32(next))
+		page = next
+	}
+
+	return cell
+}
+
+// bytes returns the database file, filling in its header.
+func (w *sqliteWriter) bytes() []byte {
+	hdr := w.pages[0]
+
+	copy(hdr, sqliteMagic)
+	binary.BigEndian.PutUint16(hdr[16:], uint16(sqlitePageSize))
+	hdr[18], hdr[19] = 1, 1
+	hdr[21], hdr[22], hdr[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(hdr[24:], 1)
+	binary.BigEndian.PutUint32(hdr[28:], uint32(len(w.pages)))
+	binary.BigEndian.PutUint32(hdr[40:], 1)
+	binary.BigEndian.PutUint32(hdr[44:], 4)
+	binary.BigEndian.PutUint32(hdr[56:], 1)
+	binary.BigEndian.PutUint32(hdr[92:], 1)
+	binary.BigEndian.PutUint32(hdr[96:], 3008000)
+
+	data := make([]byte, 0, len(w.pages)*sqlitePageSize)
+	for _, page := range w.pages {
+		data = append(data, page...)
+	}
+
+	return data
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// ExportToSQLite writes the database to a new SQLite database file, so that
+// its data can be queried with SQL. Every table becomes a SQLite table with
+// an id column, the rowid, holding the record ids, and a column for every
+// top-level field found in the records. Column types are inferred from the
+// values: INTEGER for integers and booleans, REAL for other numbers and TEXT
+// for strings, with arrays and objects stored as JSON text. The file is
+// written directly, without SQLite itself, and only once it is complete. It
+// takes the path of the SQLite file, which must not exist. It returns any
+// error encountered.
+func (db *DB) ExportToSQLite(path string) error {
+	return db.ExportToSQLiteCtx(context.Background(), path)
+}
+
+// ExportToSQLiteCtx is ExportToSQLite with a context, which is handed to the
+// authorizer.
+func (db *DB) ExportToSQLiteCtx(ctx context.Context, path string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	tblNames := db.tableNames()
+
+	ops, err := db.beginOps(ctx, tblNames, "export")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return fmt.Errorf("ivy: cannot export to %s: file exists", path)
+	}
+
+	w := newSQLiteWriter()
+
+	var schema []sqliteRow
+
+	for _, tblName := range tblNames {
+		if strings.HasPrefix(strings.ToLower(tblName), "sqlite_") {
+			return fmt.Errorf("ivy: cannot export table %s: the name is reserved by SQLite", tblName)
+		}
+
+		rows, sql, err := db.sqliteRows(tblName)
+		if err != nil {
+			return err
+		}
+
+		root := w.writeTable(rows, false)
+
+		schema = append(schema, sqliteRow{
+			rowid:  int64(len(schema) + 1),
+			values: []interface{}{"table", tblName, tblName, int64(root), sql},
+		})
+	}
+
+	w.writeTable(schema, true)
+
+	return writeFileAtomic(osFileSystem{}, path, w.bytes(), 0644)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// sqliteRows returns the records of a table as SQLite rows, and the CREATE
+// TABLE statement of a table holding them.
+func (db *DB) sqliteRows(tblName string) ([]sqliteRow, string, error) {
+	db.tblLock(tblName).RLock()
+	recs, err := db.readTbl(tblName)
+	db.tblLock(tblName).RUnlock()
+	if err != nil {
+		return nil, "", err
+	}
+
+	fileIds := sortedIds(recs)
+	decoded := make([]map[string]interface{}, len(fileIds))
+	kinds := make(map[string]map[string]bool)
+
+	for i, fileId := range fileIds {
+		data, err := db.redact(tblName, fileId, recs[fileId])
+		if err != nil {
+			return nil, "", err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+
+		err = dec.Decode(&decoded[i])
+		if err != nil {
+			return nil, "", corruptErr(tblName, fileId, err.Error())
+		}
+
+		for field, value := range decoded[i] {
+			if kinds[field] == nil {
+				kinds[field] = make(map[string]bool)
+			}
+			kinds[field][sqliteKind(value)] = true
+		}
+	}
+
+	fields := make([]string, 0, len(kinds))
+	for field := range kinds {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	idColumn := "id"
+	for kinds[idColumn] != nil {
+		idColumn = "_" + idColumn
+	}
+
+	defs := []string{quoteSQLName(idColumn) + " INTEGER PRIMARY KEY"}
+	for _, field := range fields {
+		defs = append(defs, strings.TrimSpace(quoteSQLName(field)+" "+sqliteColumnType(kinds[field])))
+	}
+
+	sql := "CREATE TABLE " + quoteSQLName(tblName) + " (" + strings.Join(defs, ", ") + ")"
+
+	rows := make([]sqliteRow, len(fileIds))
+
+	for i, fileId := range fileIds {
+		rowid, err := strconv.ParseInt(fileId, 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// The id column is an alias of the rowid, which SQLite stores as a
+		// NULL in the record.
+		values := make([]interface{}, len(fields)+1)
+		for j, field := range fields {
+			values[j+1], err = sqliteValue(decoded[i][field])
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		rows[i] = sqliteRow{rowid: rowid, values: values}
+	}
+
+	return rows, sql, nil
+}
+
+// importSQLiteTable writes the rows of a SQLite table as records.
+func (db *DB) importSQLiteTable(f *sqliteFile, tbl *sqliteTable, m SQLiteTable) error {
+	tblName := tbl.name
+	if m.Table != "" {
+		tblName = m.Table
+	}
+
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	return f.scan(tbl.rootPage, func(row sqliteRow) error {
+		if row.rowid <= 0 {
+			return fmt.Errorf("ivy: cannot import row %d of %s: ivy ids must be positive", row.rowid, tbl.name)
+		}
+
+		rec := make(map[string]interface{}, len(tbl.columns))
+
+		for i, column := range tbl.columns {
+			var value interface{}
+
+			switch {
+			case i == tbl.rowidColumn:
+				value = row.rowid
+			case i < len(row.values):
+				value = row.values[i]
+			}
+
+			if column == m.TagsColumn {
+				text, _ := value.(string)
+
+				tags := []string{}
+
+				// Lists written by ExportToSQLite are JSON arrays.
+				if strings.HasPrefix(text, "[") && json.Unmarshal([]byte(text), &tags) == nil {
+					rec["tags"] = tags
+					continue
+				}
+
+				for _, tag := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' }) {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						tags = append(tags, tag)
+					}
+				}
+
+				rec["tags"] = tags
+				continue
+			}
+
+			rec[column] = value
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("ivy: cannot import row %d of %s: %v", row.rowid, tbl.name, err)
+		}
+
+		return db.writeRec(context.Background(), tblName, strconv.FormatInt(row.rowid, 10), data, false)
+	})
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// decodeSQLiteRecord decodes the values of a row in the SQLite record format.
+func decodeSQLiteRecord(payload []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(payload)
+	if n == 0 || int(hdrSize) > len(payload) {
+		return nil, errors.New("ivy: corrupt SQLite record")
+	}
+
+	var serialTypes []uint64
+
+	for pos := n; pos < int(hdrSize); {
+		typ, n := sqliteVarint(payload[pos:hdrSize])
+		if n == 0 {
+			return nil, errors.New("ivy: corrupt SQLite record")
+		}
+		serialTypes = append(serialTypes, typ)
+		pos += n
+	}
+
+	values := make([]interface{}, len(serialTypes))
+	body := payload[hdrSize:]
+
+	for i, typ := range serialTypes {
+		size := sqliteSerialSize(typ)
+		if size > len(body) {
+			return nil, errors.New("ivy: corrupt SQLite record")
+		}
+
+		v := body[:size]
+		body = body[size:]
+
+		switch {
+		case typ == 0:
+			values[i] = nil
+		case typ >= 1 && typ <= 6:
+			values[i] = sqliteInt(v)
+		case typ == 7:
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(v))
+		case typ == 8:
+			values[i] = int64(0)
+		case typ == 9:
+			values[i] = int64(1)
+		case typ >= 12 && typ%2 == 0:
+			values[i] = append([]byte(nil), v...)
+		case typ >= 13:
+			values[i] = string(v)
+		default:
+			return nil, fmt.Errorf("ivy: unsupported SQLite serial type %d", typ)
+		}
+	}
+
+	return values, nil
+}
+
+// encodeSQLiteRecord encodes the values of a row in the SQLite record format.
+// Values are nil, int64, float64, string or []byte.
+func encodeSQLiteRecord(values []interface{}) []byte {
+	var types, body []byte
+
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = append(types, 8)
+			case v == 1:
+				types = append(types, 9)
+			default:
+				typ, size := sqliteIntType(v)
+				types = append(types, typ)
+				for i := size - 1; i >= 0; i-- {
+					body = append(body, byte(v>>(8*uint(i))))
+				}
+			}
+		case float64:
+			types = append(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = appendSQLiteVarint(types, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			types = appendSQLiteVarint(types, uint64(12+2*len(v)))
+			body = append(body, v...)
+		}
+	}
+
+	// The header size includes the varint holding it.
+	hdrSize := len(types) + 1
+	for len(appendSQLiteVarint(nil, uint64(hdrSize))) != hdrSize-len(types) {
+		hdrSize++
+	}
+
+	record := appendSQLiteVarint(nil, uint64(hdrSize))
+	record = append(record, types...)
+
+	return append(record, body...)
+}
+
+// sqliteIntType returns the serial type and size of the smallest integer
+// serial type holding v.
+func sqliteIntType(v int64) (byte, int) {
+	switch {
+	case v >= -1<<7 && v < 1<<7:
+		return 1, 1
+	case v >= -1<<15 && v < 1<<15:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= -1<<31 && v < 1<<31:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	}
+
+	return 6, 8
+}
+
+// appendSQLiteVarint appends a SQLite variable-length integer to data.
+func appendSQLiteVarint(data []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(data, buf[:]...)
+	}
+
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		b := buf[i]
+		if i > 0 {
+			b |= 0x80
+		}
+		data = append(data, b)
+	}
+
+	return data
+}
+
+// cellsSize returns the space a set of cells takes up on a page, including
+// their cell pointers.
+func cellsSize(cells []sqliteCell) int {
+	size := 0
+	for _, cell := range cells {
+		size += len(cell.data) + 2
+	}
+	return size
+}
+
+// interiorSize returns the space the cells pointing to a set of children take
+// up on an interior page, including their cell pointers. The last child is
+// the right-most pointer in the page header.
+func interiorSize(children []sqliteChild) int {
+	size := 0
+	for _, child := range children[:len(children)-1] {
+		size += interiorCellSize(child) + 2
+	}
+	return size
+}
+
+// interiorCellSize returns the size of the interior cell pointing to a child.
+func interiorCellSize(child sqliteChild) int {
+	return 4 + len(appendSQLiteVarint(nil, uint64(child.maxKey)))
+}
+
+// sqliteKind returns the kind of a decoded JSON value, for inferring column
+// types.
+func sqliteKind(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "integer"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "real"
+	case string:
+		return "text"
+	}
+
+	return "json"
+}
+
+// sqliteColumnType returns the declared type of a column holding values of
+// the supplied kinds.
+func sqliteColumnType(kinds map[string]bool) string {
+	switch {
+	case kinds["text"] || kinds["json"]:
+		if kinds["integer"] || kinds["real"] {
+			// Mixed values keep their own types.
+			return ""
+		}
+		return "TEXT"
+	case kinds["real"]:
+		return "REAL"
+	case kinds["integer"]:
+		return "INTEGER"
+	}
+
+	return ""
+}
+
+// sqliteValue converts a decoded JSON value to a SQLite value.
+func sqliteValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case string:
+		return v, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// quoteSQLName quotes a table or column name for SQL.
+func quoteSQLName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteSerialSize returns the size of a value with the supplied serial type.
+func sqliteSerialSize(typ uint64) int {
+	switch {
+	case typ <= 4:
+		return [...]int{0, 1, 2, 3, 4}[typ]
+	case typ == 5:
+		return 6
+	case typ == 6, typ == 7:
+		return 8
+	case typ < 12:
+		return 0
+	}
+
+	return int(typ-12) / 2
+}
+
+// sqliteInt decodes a big-endian two's complement integer of 1 to 8 bytes.
+func sqliteInt(v []byte) int64 {
+	var n int64
+	if len(v) > 0 && v[0]&0x80 != 0 {
+		n = -1
+	}
+
+	for _, b := range v {
+		n = n<<8 | int64(b)
+	}
+
+	return n
+}
+
+// sqliteVarint decodes a SQLite variable-length integer. It returns the
+// integer and the number of bytes read, which is zero if data is too short.
+func sqliteVarint(data []byte) (uint64, int) {
+	var v uint64
+
+	for i := 0; i < 9; i++ {
+		if i >= len(data) {
+			return 0, 0
+		}
+
+		if i == 8 {
+			return v<<8 | uint64(data[i]), 9
+		}
+
+		v = v<<7 | uint64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+
+	return v, 9
+}
+
+// parseCreateTable returns the column names declared by a CREATE TABLE
+// statement, and the index of the column that is an alias of the rowid, or -1.
+// It reports false for statements it does not understand and for tables
+// without rowids.
+func parseCreateTable(sql string) ([]string, int, bool) {
+	open := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil, -1, false
+	}
+
+	if strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT") {
+		return nil, -1, false
+	}
+
+	var columns []string
+	rowidColumn := -1
+
+	for _, def := range splitSQLList(sql[open+1 : end]) {
+		tokens := sqlTokens(def)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(tokens[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+
+		upper := strings.ToUpper(strings.Join(tokens[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER PRIMARY KEY") && !strings.Contains(upper, "DESC") {
+			rowidColumn = len(columns)
+		}
+
+		columns = append(columns, unquoteSQLName(tokens[0]))
+	}
+
+	return columns, rowidColumn, true
+}
+
+// splitSQLList splits a list at the commas that are not inside parentheses or
+// quotes.
+func splitSQLList(s string) []string {
+	var parts []string
+	var quote byte
+
+	depth, start := 0, 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// sqlTokens splits a column definition into words, keeping quoted names
+// whole.
+func sqlTokens(s string) []string {
+	var tokens []string
+
+	s = strings.TrimSpace(s)
+
+	for len(s) > 0 {
+		var end int
+
+		switch s[0] {
+		case '"', '`', '\'', '[':
+			closing := s[0]
+			if closing == '[' {
+				closing = ']'
+			}
+			end = strings.IndexByte(s[1:], closing) + 2
+			if end < 2 {
+				end = len(s)
+			}
+		default:
+			end = strings.IndexAny(s, " \t\r\n(")
+			if end == 0 {
+				end = 1
+			}
+			if end < 0 {
+				end = len(s)
+			}
+		}
+
+		tokens = append(tokens, s[:end])
+		s = strings.TrimSpace(s[end:])
+	}
+
+	return tokens
+}
+
+// unquoteSQLName removes the quotes around a name.
+func unquoteSQLName(name string) string {
+	if len(name) >= 2 {
+		switch name[0] {
+		case '"', '`', '\'':
+			if name[len(name)-1] == name[0] {
+				q := name[:1]
+				return strings.ReplaceAll(name[1:len(name)-1], q+q, q)
+			}
+		case '[':
+			if name[len(name)-1] == ']' {
+				return name[1 : len(name)-1]
+			}
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,51 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Type StableOutputOptions is a struct holding the settings of
+// Options.StableOutput. Indent, if not empty, pretty-prints the records,
+// every field on a line of its own, indented by Indent per level.
+type StableOutputOptions struct {
+	Indent string
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// stabilize re-serializes a marshalled record in the canonical form of
+// Options.StableOutput: object keys sorted, numbers as they were written,
+// HTML characters unescaped, indented with the configured indent and ending
+// with a newline. Records are returned unchanged if the option is not set.
+func (db *DB) stabilize(tblName string, fileId string, data []byte) ([]byte, error) {
+	if db.stableOutput == nil {
+		return data, nil
+	}
+
+	var rec interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&rec)
+	if err != nil {
+		return nil, fmt.Errorf("ivy: %s record %s is not JSON: %v", tblName, fileId, err)
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", db.stableOutput.Indent)
+
+	err = enc.Encode(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+package ivy
+
+import (
+	"time"
+)
+
+// Type Stats is a struct holding the statistics of a database, as returned
+// by DB.Stats, keyed by table name.
+type Stats struct {
+	Tables map[string]TableStats `json:"tables"`
+}
+
+// Type TableStats is a struct holding the statistics of a table. Bytes is the
+// size of its records in storage. IndexHitRate is the share of the queries
+// since the database was opened that were answered from an index rather than
+// by reading every record, or zero if there were none. LastWrite is when the
+// table last changed, as far as storage or the writes since the database was
+// opened tell; it is zero if neither does, such as for an in-memory table
+// that was not written. Lock describes the lock of the table, and Operations
+// lists the operations on the table in progress, longest-running first, to
+// find out what holds up a table.
+type TableStats struct {
+	Records      int          `json:"records"`
+	Bytes        int64        `json:"bytes"`
+	Indexes      []IndexStats `json:"indexes"`
+	IndexHitRate float64      `json:"index_hit_rate"`
+	LastWrite    time.Time    `json:"last_write"`
+	Lock         LockStats    `json:"lock"`
+	Operations   []Operation  `json:"operations"`
+}
+
+// Type IndexStats is a struct describing an index of a table: the field it
+// indexes, the number of distinct values in it and the number of ids listed
+// under them.
+type IndexStats struct {
+	Field   string `json:"field"`
+	Values  int    `json:"values"`
+	Entries int    `json:"entries"`
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Stats returns the statistics of every table. They are computed from the
+// indexes, the metrics, the table locks and what storage knows about the
+// tables, such as file sizes, without reading any record. Stats does not wait for
+// tables locked for writing, so that it can report what holds them up; their
+// Records, Bytes and Indexes are left zero. It returns the statistics and any error
+// encountered.
+func (db *DB) Stats() (*Stats, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	stats := &Stats{Tables: make(map[string]TableStats)}
+
+	for _, tblName := range db.tableNames() {
+		ts, err := db.tblStats(tblName)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Tables[tblName] = *ts
+	}
+
+	return stats, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// tblStats computes the statistics of a table.
+func (db *DB) tblStats(tblName string) (*TableStats, error) {
+	// The lock and the operations are looked at before taking the lock, which
+	// may be held up by them.
+	lock := db.tblLock(tblName).snapshot()
+	ops := db.tblOps(tblName)
+
+	ts := &TableStats{Indexes: []IndexStats{}, Lock: lock, Operations: ops}
+	ts.IndexHitRate, ts.LastWrite = db.metrics.hitRate(tblName)
+
+	if !db.tblLock(tblName).TryRLock() {
+		return ts, nil
+	}
+	defer db.tblLock(tblName).RUnlock()
+
+	st, err := db.engine.stat(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.Records, ts.Bytes = st.records, st.bytes
+	if st.modTime.After(ts.LastWrite) {
+		ts.LastWrite = st.modTime
+	}
+
+	fldNames, _ := db.indexFields(tblName)
+
+	for _, fldName := range fldNames {
+		index := db.fldIndex(tblName)[fldName]
+		if fldName == "tags" {
+			index = db.tagIndex(tblName)
+		}
+
+		is := IndexStats{Field: fldName, Values: len(index)}
+		for _, ids := range index {
+			is.Entries += len(ids)
+		}
+
+		ts.Indexes = append(ts.Indexes, is)
+	}
+
+	return ts, nil
+}
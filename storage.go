@@ -0,0 +1,446 @@
+package ivy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Type Storage selects the storage engine used to hold table records.
+type Storage int
+
+const (
+	// FileStorage stores every record as its own JSON file inside a table
+	// directory. It is the default.
+	FileStorage Storage = iota
+
+	// PackedStorage stores each table as one data file with an embedded
+	// offset/length index and free-space management. It avoids wasting a disk
+	// block and an inode per record, at the cost of records no longer being
+	// individually editable JSON files. It requires the local file system.
+	PackedStorage
+
+	// MemoryStorage keeps all tables in memory, without touching any files.
+	// Everything is lost when the program exits. See OpenMemDB.
+	MemoryStorage
+)
+
+// engine is the interface implemented by the storage engines. The DB takes
+// care of locking, so engines only have to be safe for concurrent reads.
+type engine interface {
+	// checkDB returns an error if the database itself cannot be used.
+	checkDB() error
+	// tableNames returns the names of all tables found in the database.
+	tableNames() ([]string, error)
+	// checkTable returns an error if a table cannot be used.
+	checkTable(tblName string) error
+	// createTable creates an empty table.
+	createTable(tblName string) error
+	// ids returns all record ids of a table.
+	ids(tblName string) ([]string, error)
+	// read returns the marshalled record with the supplied id. It returns an
+	// error satisfying os.IsNotExist if there is no such record.
+	read(tblName string, fileId string) ([]byte, error)
+	// write stores a marshalled record, replacing any previous version.
+	write(tblName string, fileId string, data []byte) error
+	// remove deletes a record. It returns an error satisfying os.IsNotExist if
+	// there is no such record.
+	remove(tblName string, fileId string) error
+	// removeTemp deletes the temporary files left behind by writes that were
+	// interrupted by a crash, and returns their paths.
+	removeTemp(tblName string) ([]string, error)
+	// fingerprint returns a string that changes whenever any record of a
+	// table changes, computed without reading the records themselves. It
+	// returns "" if the engine cannot compute one.
+	fingerprint(tblName string) (string, error)
+	// stat returns the number of records of a table, the bytes they take up
+	// in storage and when the table last changed, if known, computed without
+	// reading the records themselves.
+	stat(tblName string) (tblStat, error)
+	// compact reclaims the space left behind by deleted and moved records.
+	compact(tblName string) error
+	// sync makes sure that all writes have reached stable storage.
+	sync() error
+	// close releases any resources held by the engine.
+	close() error
+}
+
+// tblStat describes the storage of a table.
+type tblStat struct {
+	records int
+	bytes   int64
+	modTime time.Time
+}
+
+// newEngine returns the storage engine selected by opts.
+func newEngine(dbPath string, fs FileSystem, opts Options) (engine, error) {
+	layout := opts.Layout
+	if layout == nil {
+		layout = &DefaultLayout{}
+	}
+
+	switch opts.Storage {
+	case FileStorage:
+		if opts.MmapReads {
+			return nil, fmt.Errorf("ivy: mmap reads require packed storage")
+		}
+		return &fileEngine{path: dbPath, fs: fs, layout: layout, modes: newFileModes(opts)}, nil
+	case PackedStorage:
+		if opts.FileSystem != nil {
+			return nil, fmt.Errorf("ivy: packed storage requires the local file system")
+		}
+		return newPackedEngine(dbPath, layout, opts.MmapReads, newFileModes(opts)), nil
+	case MemoryStorage:
+		if opts.FileSystem != nil || opts.MmapReads {
+			return nil, fmt.Errorf("ivy: memory storage does not use files")
+		}
+		return newMemEngine(), nil
+	}
+
+	return nil, fmt.Errorf("ivy: unknown storage engine %v", opts.Storage)
+}
+
+//*****************************************************************************
+// fileEngine
+//*****************************************************************************
+
+// fileEngine stores every record in its own file. Where tables and records
+// live is decided by the layout.
+type fileEngine struct {
+	path   string
+	fs     FileSystem
+	layout Layout
+	modes  fileModes
+
+	mu    sync.Mutex
+	paths map[string]map[string]string
+}
+
+func (e *fileEngine) checkDB() error {
+	_, err := e.fs.Stat(e.path)
+	return err
+}
+
+func (e *fileEngine) tableNames() ([]string, error) {
+	var names []string
+
+	files, err := e.fs.ReadDir(e.path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() && !isHidden(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (e *fileEngine) checkTable(tblName string) error {
+	_, err := e.fs.Stat(e.layout.TablePath(e.path, tblName))
+	return err
+}
+
+func (e *fileEngine) createTable(tblName string) error {
+	return e.fs.MkdirAll(e.layout.TablePath(e.path, tblName), e.modes.dir)
+}
+
+func (e *fileEngine) ids(tblName string) ([]string, error) {
+	paths, err := e.scan(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(paths))
+	for fileId := range paths {
+		ids = append(ids, fileId)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (e *fileEngine) read(tblName string, fileId string) ([]byte, error) {
+	filePath, err := e.existingPath(tblName, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.fs.ReadFile(filePath)
+}
+
+func (e *fileEngine) write(tblName string, fileId string, data []byte) error {
+	filePath := e.layout.RecordPath(e.path, tblName, fileId, data)
+
+	// Layouts may put records in subdirectories of the table directory.
+	if dir := filepath.Dir(filePath); dir != e.layout.TablePath(e.path, tblName) {
+		if err := e.fs.MkdirAll(dir, e.modes.dir); err != nil {
+			return err
+		}
+	}
+
+	err := writeFileAtomic(e.fs, filePath, data, e.modes.file)
+	if err != nil {
+		return err
+	}
+
+	if e.layout.RecordPath(e.path, tblName, fileId, nil) != "" {
+		return nil
+	}
+
+	// The file name depends on the record contents and may have changed, in
+	// which case the old file has to go.
+	oldPath, err := e.existingPath(tblName, fileId)
+	if err == nil && oldPath != filePath {
+		err = e.fs.Remove(oldPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	e.setPath(tblName, fileId, filePath)
+
+	return nil
+}
+
+func (e *fileEngine) remove(tblName string, fileId string) error {
+	filePath, err := e.existingPath(tblName, fileId)
+	if err != nil {
+		return err
+	}
+
+	err = e.fs.Remove(filePath)
+	if err != nil {
+		return err
+	}
+
+	e.setPath(tblName, fileId, "")
+
+	return nil
+}
+
+func (e *fileEngine) removeTemp(tblName string) ([]string, error) {
+	var tmpPaths []string
+
+	err := e.walkInfo(e.layout.TablePath(e.path, tblName), func(filePath string, info os.FileInfo) {
+		if isTempFile(info.Name()) {
+			tmpPaths = append(tmpPaths, filePath)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tmpPath := range tmpPaths {
+		err := e.fs.Remove(tmpPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return tmpPaths, nil
+}
+
+// fingerprint hashes the path, size and modification time of every record
+// file of a table.
+func (e *fileEngine) fingerprint(tblName string) (string, error) {
+	h := sha256.New()
+
+	err := e.walkInfo(e.layout.TablePath(e.path, tblName), func(filePath string, info os.FileInfo) {
+		if _, ok := e.layout.RecordId(e.path, tblName, filePath); ok {
+			fmt.Fprintf(h, "%v\x00%v\x00%v\n", filePath, info.Size(), info.ModTime().UnixNano())
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (e *fileEngine) stat(tblName string) (tblStat, error) {
+	var st tblStat
+
+	err := e.walkInfo(e.layout.TablePath(e.path, tblName), func(filePath string, info os.FileInfo) {
+		if _, ok := e.layout.RecordId(e.path, tblName, filePath); ok {
+			st.records++
+			st.bytes += info.Size()
+			if info.ModTime().After(st.modTime) {
+				st.modTime = info.ModTime()
+			}
+		}
+	})
+
+	return st, err
+}
+
+// compact does nothing, as every record has a file of its own, whose space is
+// reclaimed when it is removed.
+func (e *fileEngine) compact(tblName string) error {
+	return nil
+}
+
+func (e *fileEngine) sync() error {
+	return nil
+}
+
+func (e *fileEngine) close() error {
+	return nil
+}
+
+// scan lists a table directory, including any non-hidden subdirectories, and
+// returns the path of every record file, keyed by record id.
+func (e *fileEngine) scan(tblName string) (map[string]string, error) {
+	paths := make(map[string]string)
+
+	err := e.walkInfo(e.layout.TablePath(e.path, tblName), func(filePath string, info os.FileInfo) {
+		if fileId, ok := e.layout.RecordId(e.path, tblName, filePath); ok {
+			paths[fileId] = filePath
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	if e.paths == nil {
+		e.paths = make(map[string]map[string]string)
+	}
+	e.paths[tblName] = paths
+	e.mu.Unlock()
+
+	return paths, nil
+}
+
+// stamps lists a table directory, as scan does, and returns the stamp of
+// every record file, keyed by record id.
+func (e *fileEngine) stamps(tblName string) (map[string]fileStamp, error) {
+	paths := make(map[string]string)
+	stamps := make(map[string]fileStamp)
+
+	err := e.walkInfo(e.layout.TablePath(e.path, tblName), func(filePath string, info os.FileInfo) {
+		if fileId, ok := e.layout.RecordId(e.path, tblName, filePath); ok {
+			paths[fileId] = filePath
+			stamps[fileId] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	if e.paths == nil {
+		e.paths = make(map[string]map[string]string)
+	}
+	e.paths[tblName] = paths
+	e.mu.Unlock()
+
+	return stamps, nil
+}
+
+// stampOf returns the stamp of the file of a record.
+func (e *fileEngine) stampOf(tblName string, fileId string) (fileStamp, error) {
+	filePath, err := e.existingPath(tblName, fileId)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	info, err := e.fs.Stat(filePath)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// walkInfo calls fn for every file in dir and its non-hidden subdirectories.
+func (e *fileEngine) walkInfo(dir string, fn func(filePath string, info os.FileInfo)) error {
+	files, err := e.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filePath := filepath.Join(dir, file.Name())
+
+		if file.IsDir() {
+			if !isHidden(file.Name()) {
+				if err := e.walkInfo(filePath, fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		fn(filePath, file)
+	}
+
+	return nil
+}
+
+// existingPath returns the path of an existing record file. Most layouts can
+// compute it from the id alone; otherwise it is looked up in the paths found
+// by the last scan, rescanning the table if the record is not there.
+func (e *fileEngine) existingPath(tblName string, fileId string) (string, error) {
+	if filePath := e.layout.RecordPath(e.path, tblName, fileId, nil); filePath != "" {
+		return filePath, nil
+	}
+
+	e.mu.Lock()
+	filePath, ok := e.paths[tblName][fileId]
+	e.mu.Unlock()
+
+	if ok {
+		return filePath, nil
+	}
+
+	paths, err := e.scan(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	if filePath, ok := paths[fileId]; ok {
+		return filePath, nil
+	}
+
+	return "", notExist("open", tblName, fileId)
+}
+
+// setPath updates the known path of a record file. An empty path forgets the
+// record.
+func (e *fileEngine) setPath(tblName string, fileId string, filePath string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.paths[tblName] == nil {
+		return
+	}
+
+	if filePath == "" {
+		delete(e.paths[tblName], fileId)
+	} else {
+		e.paths[tblName][fileId] = filePath
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// isHidden answers whether a file name is hidden. Hidden entries in the
+// database directory are never treated as tables.
+func isHidden(name string) bool {
+	return len(name) > 0 && name[0] == '.'
+}
+
+// notExist returns an error satisfying os.IsNotExist for a missing record.
+func notExist(op string, tblName string, fileId string) error {
+	return &os.PathError{Op: op, Path: tblName + "/" + fileId, Err: os.ErrNotExist}
+}
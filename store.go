@@ -0,0 +1,39 @@
+package ivy
+
+import "context"
+
+// Type Store is an interface holding the record operations of a database:
+// reading, querying, creating, updating and deleting records. *DB satisfies
+// it. Application code can depend on a Store instead of a *DB, so that its
+// unit tests can pass a fake that never touches the file system.
+type Store interface {
+	Find(tblName string, rec Record, fileId string) error
+	FindCtx(ctx context.Context, tblName string, rec Record, fileId string) error
+	FindAllIds(tblName string) ([]string, error)
+	FindAllIdsCtx(ctx context.Context, tblName string) ([]string, error)
+	FindFirstIdForField(tblName string, searchField string, searchValue string) (string, error)
+	FindFirstIdForFieldCtx(ctx context.Context, tblName string, searchField string, searchValue string) (string, error)
+	FindAllIdsForField(tblName string, searchField string, searchValue string) ([]string, error)
+	FindAllIdsForFieldCtx(ctx context.Context, tblName string, searchField string, searchValue string) ([]string, error)
+	FindAllIdsForTags(tblName string, searchTags []string) ([]string, error)
+	FindAllIdsForTagsCtx(ctx context.Context, tblName string, searchTags []string) ([]string, error)
+	QueryString(tblName string, queryStr string, args ...interface{}) ([]string, error)
+	QueryStringCtx(ctx context.Context, tblName string, queryStr string, args ...interface{}) ([]string, error)
+
+	Create(tblName string, rec interface{}) (string, error)
+	CreateCtx(ctx context.Context, tblName string, rec interface{}) (string, error)
+	Update(tblName string, rec interface{}, fileId string) error
+	UpdateCtx(ctx context.Context, tblName string, rec interface{}, fileId string) error
+	Delete(tblName string, fileId string) error
+	DeleteCtx(ctx context.Context, tblName string, fileId string) error
+	Duplicate(tblName string, fileId string) (string, error)
+	DuplicateCtx(ctx context.Context, tblName string, fileId string) (string, error)
+	DuplicateWithOverrides(tblName string, fileId string, overrides map[string]interface{}) (string, error)
+	DuplicateWithOverridesCtx(ctx context.Context, tblName string, fileId string, overrides map[string]interface{}) (string, error)
+
+	TableNames() ([]string, error)
+	Close() error
+}
+
+// *DB has to satisfy Store.
+var _ Store = (*DB)(nil)
@@ -0,0 +1,219 @@
+package ivy
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Type StreamFormat is the format DB.Stream writes records in.
+type StreamFormat int
+
+const (
+	// StreamJSON writes a JSON array of objects holding the id and data of
+	// every record.
+	StreamJSON StreamFormat = iota
+
+	// StreamNDJSON writes one JSON object per line holding the id and data
+	// of a record, the format of ExportTable, which ImportTable reads.
+	StreamNDJSON
+
+	// StreamCSV writes CSV with a header row, laid out as ExportCSV does.
+	StreamCSV
+)
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Stream writes the records with the supplied ids, such as the result of
+// FindAllIdsForField, to w in the supplied format, in the given order. Records
+// are read and written one at a time, holding the table's read lock only while
+// reading each of them, so that large results can be served to a download
+// without loading them into memory. Records deleted since their ids were found
+// are left out. For CSV, the fields name the exported columns; if there are
+// none, the records are read twice, first to find all of their fields. It
+// takes a table name, the ids of the records, the writer to write to, the
+// format, and the CSV fields. It returns any error encountered.
+func (db *DB) Stream(tblName string, fileIds []string, w io.Writer, format StreamFormat, fields ...string) error {
+	return db.StreamCtx(context.Background(), tblName, fileIds, w, format, fields...)
+}
+
+// StreamCtx is Stream with a context. It stops with the context's error as
+// soon as the context is done, such as when the client of a download goes
+// away; what was written so far is not flushed.
+func (db *DB) StreamCtx(ctx context.Context, tblName string, fileIds []string, w io.Writer, format StreamFormat, fields ...string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "stream")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return tableNotFoundErr(tblName)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case StreamJSON, StreamNDJSON:
+		err = db.streamJSON(ctx, tblName, fileIds, bw, format == StreamJSON)
+	case StreamCSV:
+		err = db.streamCSV(ctx, tblName, fileIds, bw, fields)
+	default:
+		err = fmt.Errorf("ivy: unknown stream format %d", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// streamJSON writes records as a JSON array, or as JSON lines if array is
+// false.
+func (db *DB) streamJSON(ctx context.Context, tblName string, fileIds []string, bw *bufio.Writer, array bool) error {
+	if array {
+		bw.WriteString("[")
+	}
+
+	first := true
+
+	err := db.eachRec(ctx, tblName, fileIds, func(fileId string, data []byte) error {
+		line, err := json.Marshal(exportedRec{Id: fileId, Data: data})
+		if err != nil {
+			return corruptErr(tblName, fileId, err.Error())
+		}
+
+		if array {
+			if !first {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n")
+		}
+		first = false
+
+		bw.Write(line)
+
+		if !array {
+			bw.WriteString("\n")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if array {
+		if !first {
+			bw.WriteString("\n")
+		}
+		bw.WriteString("]\n")
+	}
+
+	return nil
+}
+
+// streamCSV writes records as CSV.
+func (db *DB) streamCSV(ctx context.Context, tblName string, fileIds []string, bw *bufio.Writer, fields []string) error {
+	if len(fields) == 0 {
+		seen := make(map[string]bool)
+
+		err := db.eachRec(ctx, tblName, fileIds, func(fileId string, data []byte) error {
+			row, err := flattenRec(data)
+			if err != nil {
+				return corruptErr(tblName, fileId, err.Error())
+			}
+
+			for field := range row {
+				if !seen[field] {
+					seen[field] = true
+					fields = append(fields, field)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sort.Strings(fields)
+	}
+
+	cw := csv.NewWriter(bw)
+
+	err := cw.Write(append([]string{"id"}, fields...))
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(fields)+1)
+
+	err = db.eachRec(ctx, tblName, fileIds, func(fileId string, data []byte) error {
+		row, err := flattenRec(data)
+		if err != nil {
+			return corruptErr(tblName, fileId, err.Error())
+		}
+
+		record[0] = fileId
+		for i, field := range fields {
+			record[i+1] = row[field]
+		}
+
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// eachRec calls fn with every record with the supplied ids, reading them one
+// at a time and skipping records that no longer exist. It stops with the
+// context's error as soon as the context is done.
+func (db *DB) eachRec(ctx context.Context, tblName string, fileIds []string, fn func(fileId string, data []byte) error) error {
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := db.backupRec(tblName, fileId)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err = db.redact(tblName, fileId, data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(fileId, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package ivy
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Type QueryUpdate is a struct holding a result of a query sent by a
+// Subscription: the ids of the matching records, in the query's order, and
+// the ids that started and stopped matching since the previous update.
+type QueryUpdate struct {
+	Ids     []string
+	Added   []string
+	Removed []string
+}
+
+// Type Subscription is a struct holding a live query returned by
+// DB.Subscribe. C receives the query's result whenever writes change which
+// records match. A receiver that falls behind misses the intermediate
+// results, never the latest one; the Added and Removed ids of an update are
+// always relative to the update received before it. C is closed when the
+// subscription or the database is closed.
+type Subscription struct {
+	C <-chan QueryUpdate
+
+	db      *DB
+	ctx     context.Context
+	tblName string
+	q       *query
+	c       chan QueryUpdate
+	changed chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.db.subsMu.Lock()
+		delete(s.db.subs, s)
+		s.db.subsMu.Unlock()
+	})
+}
+
+// run runs the query whenever a write may have changed its result, sending
+// every new result on C.
+func (s *Subscription) run(ids []string) {
+	defer close(s.c)
+
+	var delivered []string
+	sent := false
+
+	next := QueryUpdate{Ids: ids, Added: ids}
+	out := s.c
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case out <- next:
+			delivered = next.Ids
+			sent = true
+			out = nil
+		case <-s.changed:
+			ids, err := s.result()
+			if err != nil {
+				// The database is closed, or the table is gone.
+				s.Close()
+				return
+			}
+
+			if sent && equalStrings(ids, delivered) {
+				out = nil
+				continue
+			}
+
+			next = queryUpdate(delivered, ids)
+			out = s.c
+		}
+	}
+}
+
+// result runs the query, returning its ids in id order unless the query
+// orders them.
+func (s *Subscription) result() ([]string, error) {
+	db := s.db
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	ids, err := db.runQuery(s.ctx, s.tblName, s.q)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.q.order) == 0 {
+		sort.Slice(ids, func(i, j int) bool { return idNum(ids[i]) < idNum(ids[j]) })
+	}
+
+	return ids, nil
+}
+
+// affected reports whether a write may change the result of the query: if
+// the record matched the query before the write or matches it after.
+func (s *Subscription) affected(oldData []byte, data []byte) bool {
+	if s.q.where == nil {
+		return true
+	}
+
+	for _, d := range [][]byte{oldData, data} {
+		if d == nil {
+			continue
+		}
+
+		rec, err := s.db.decodeFields(d)
+		if err != nil || s.q.where.eval(rec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Subscribe starts a live query: the returned Subscription sends the result
+// of a query of the query language at once, and again whenever a write to
+// the table changes it, so that a dashboard can follow a table without
+// polling. Writes to records that match the query neither before nor after
+// do not run it again. Results are in id order unless the query has an
+// ORDER BY. Close the subscription when done with it. It takes a table name,
+// the query and the arguments of its placeholders. It returns the
+// subscription and any error encountered.
+func (db *DB) Subscribe(tblName string, queryStr string, args ...interface{}) (*Subscription, error) {
+	return db.SubscribeCtx(context.Background(), tblName, queryStr, args...)
+}
+
+// SubscribeCtx is Subscribe with a context. The query runs with the context
+// every time, and the subscription is closed once the context is done.
+func (db *DB) SubscribeCtx(ctx context.Context, tblName string, queryStr string, args ...interface{}) (_ *Subscription, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "subscribe")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	q, err := parseQuery(queryStr, args)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan QueryUpdate)
+
+	s := &Subscription{
+		C:       c,
+		db:      db,
+		ctx:     ctx,
+		tblName: tblName,
+		q:       q,
+		c:       c,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	// Registering before the first run keeps writes made meanwhile from
+	// being missed.
+	db.subsMu.Lock()
+	if db.subs == nil {
+		db.subs = make(map[*Subscription]bool)
+	}
+	db.subs[s] = true
+	db.subsMu.Unlock()
+
+	ids, err := s.result()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	go s.run(ids)
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Close()
+			case <-s.done:
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// notifySubscriptions tells the subscriptions to a table that a record
+// changed from oldData to data, either of which is nil if the record did not
+// exist. The caller must hold the table's write lock.
+func (db *DB) notifySubscriptions(tblName string, oldData []byte, data []byte) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+
+	for s := range db.subs {
+		if s.tblName != tblName || !s.affected(oldData, data) {
+			continue
+		}
+
+		select {
+		case s.changed <- struct{}{}:
+		default:
+			// A run is pending already.
+		}
+	}
+}
+
+// closeSubscriptions closes every subscription.
+func (db *DB) closeSubscriptions() {
+	db.subsMu.Lock()
+	subs := make([]*Subscription, 0, len(db.subs))
+	for s := range db.subs {
+		subs = append(subs, s)
+	}
+	db.subsMu.Unlock()
+
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// queryUpdate returns the update from one result of a query to the next.
+func queryUpdate(prev []string, ids []string) QueryUpdate {
+	update := QueryUpdate{Ids: ids}
+
+	prevSet := make(map[string]bool, len(prev))
+	for _, fileId := range prev {
+		prevSet[fileId] = true
+	}
+
+	idSet := make(map[string]bool, len(ids))
+	for _, fileId := range ids {
+		idSet[fileId] = true
+
+		if !prevSet[fileId] {
+			update.Added = append(update.Added, fileId)
+		}
+	}
+
+	for _, fileId := range prev {
+		if !idSet[fileId] {
+			update.Removed = append(update.Removed, fileId)
+		}
+	}
+
+	return update
+}
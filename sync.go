@@ -0,0 +1,463 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syncMu serializes syncs, so that two syncs between the same databases in
+// opposite directions cannot deadlock on their table locks.
+var syncMu sync.Mutex
+
+// Type Conflict is a struct describing a record that was changed in both
+// databases since their last sync. Local and Remote hold the two versions of
+// the record; a nil version means the record was deleted.
+type Conflict struct {
+	Table  string
+	Id     string
+	Local  json.RawMessage
+	Remote json.RawMessage
+}
+
+// Type SyncOptions is a struct holding the options of DB.SyncWith.
+type SyncOptions struct {
+	// Peer names the other database, so that the state of the last sync with
+	// it can be found. It defaults to the other database's path, and is
+	// required if that database is in memory.
+	Peer string
+
+	// TimestampField is the record field compared by the default
+	// last-write-wins conflict resolution. It holds either an RFC 3339 time
+	// or Unix seconds. It defaults to "updated_at".
+	TimestampField string
+
+	// Resolve, if set, resolves conflicts instead of last-write-wins. It
+	// returns the version of the record to keep in both databases, or nil to
+	// delete it.
+	Resolve func(c Conflict) (json.RawMessage, error)
+}
+
+// Type SyncReport is a struct describing what DB.SyncWith did. Pulled and
+// Pushed are the number of changes copied from and to the other database,
+// Conflicts the number of records changed on both sides, and Renumbered the
+// number of records created with the same id on both sides, which were kept
+// by giving the other database's record a new id.
+type SyncReport struct {
+	Pulled     int
+	Pushed     int
+	Conflicts  int
+	Renumbered int
+}
+
+// syncBase maps every table, and every record id in the table, to the
+// checksum of the record at the end of the last sync.
+type syncBase map[string]map[string]string
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// SyncWith exchanges changes with another database, such as a copy on a
+// laptop that was offline, so that both end up holding the same records.
+// Records changed on one side since the last sync between the two are copied
+// to the other. Records changed on both sides are conflicts, which are
+// resolved by the Resolve callback if one is set, and otherwise by keeping the
+// version with the later timestamp, where a change wins over a deletion.
+// Records created on both sides with the same id are both kept, the other
+// database's one under a new id. Only tables present in both databases are
+// synced. The state of the sync is saved in the database's .ivy directory,
+// for the next sync with the same peer. It takes the other database and the
+// sync options. It returns a report of the sync and any error encountered.
+func (db *DB) SyncWith(peer *DB, opts SyncOptions) (*SyncReport, error) {
+	return db.SyncWithCtx(context.Background(), peer, opts)
+}
+
+// SyncWithCtx is SyncWith with a context, which is handed to the authorizer.
+func (db *DB) SyncWithCtx(ctx context.Context, peer *DB, opts SyncOptions) (_ *SyncReport, err error) {
+	if peer == db {
+		return nil, errors.New("ivy: cannot sync a database with itself")
+	}
+
+	for _, d := range []*DB{db, peer} {
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+
+		if err := d.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	var tblNames []string
+	for _, tblName := range db.tableNames() {
+		if peer.tblLock(tblName) != nil {
+			tblNames = append(tblNames, tblName)
+		}
+	}
+
+	// Both databases are read and written, so both authorizers are asked.
+	for _, d := range []*DB{db, peer} {
+		var ops []*operation
+		ops, err = d.beginOps(ctx, tblNames, "sync")
+		defer func(d *DB, ops []*operation) { d.endOps(ops, err) }(d, ops)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	name := opts.Peer
+	if name == "" {
+		if peer.path == "" {
+			return nil, errors.New("ivy: SyncOptions.Peer is required to sync with an in-memory database")
+		}
+		name = peer.path
+	}
+
+	if opts.Resolve == nil {
+		opts.Resolve = lastWriteWins(opts.TimestampField)
+	}
+
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	base, err := db.readSyncBase(name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{}
+	newBase := make(syncBase)
+
+	for _, tblName := range tblNames {
+		newBase[tblName], err = db.syncTbl(peer, tblName, base[tblName], opts, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = db.writeSyncBase(name, newBase)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// syncTbl syncs a table with the same table of another database. It returns
+// the checksums of the records both databases hold afterwards.
+func (db *DB) syncTbl(peer *DB, tblName string, base map[string]string, opts SyncOptions, report *SyncReport) (map[string]string, error) {
+	db.tblLock(tblName).Lock()
+	defer db.tblLock(tblName).Unlock()
+
+	peer.tblLock(tblName).Lock()
+	defer peer.tblLock(tblName).Unlock()
+
+	local, err := db.readTbl(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := peer.readTbl(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string)
+
+	for _, fileId := range unionIds(local, remote) {
+		l, r := local[fileId], remote[fileId]
+		ls, rs := recSum(l), recSum(r)
+		bs := base[fileId]
+
+		// The checksum of the record both databases hold afterwards.
+		final := ls
+
+		switch {
+		case ls == rs:
+			// Unchanged, or changed the same way on both sides.
+
+		case ls == bs:
+			err = db.syncRec(tblName, fileId, r)
+			final = rs
+			report.Pulled++
+
+		case rs == bs:
+			err = peer.syncRec(tblName, fileId, l)
+			report.Pushed++
+
+		case bs == "" && l != nil && r != nil:
+			// Both sides created a record with the same id.
+			var newId string
+
+			newId, err = db.renumberRec(peer, tblName, fileId, l, r)
+			sums[newId] = rs
+			report.Renumbered++
+
+		default:
+			var data json.RawMessage
+
+			data, err = opts.Resolve(Conflict{Table: tblName, Id: fileId, Local: l, Remote: r})
+			final = recSum(data)
+			if err == nil && final != ls {
+				err = db.syncRec(tblName, fileId, data)
+			}
+			if err == nil && final != rs {
+				err = peer.syncRec(tblName, fileId, data)
+			}
+			report.Conflicts++
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if final != "" {
+			sums[fileId] = final
+		}
+	}
+
+	return sums, nil
+}
+
+// renumberRec keeps both versions of a record created with the same id in
+// two databases: the peer's version moves to a new id in both, and the local
+// version takes the id in the peer. It returns the new id.
+func (db *DB) renumberRec(peer *DB, tblName string, fileId string, local []byte, remote []byte) (string, error) {
+	newId, err := db.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	peerId, err := peer.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", err
+	}
+
+	if idNum(peerId) > idNum(newId) {
+		newId = peerId
+	}
+
+	for _, write := range []struct {
+		db     *DB
+		fileId string
+		data   []byte
+	}{
+		{db, newId, remote},
+		{peer, newId, remote},
+		{peer, fileId, local},
+	} {
+		err = write.db.syncRec(tblName, write.fileId, write.data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return newId, nil
+}
+
+// readTbl returns every record of a table, keyed by id. The caller must hold
+// the table's lock.
+func (db *DB) readTbl(tblName string) (map[string][]byte, error) {
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make(map[string][]byte, len(fileIds))
+
+	for _, fileId := range fileIds {
+		data, err := db.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		recs[fileId] = data
+	}
+
+	return recs, nil
+}
+
+// syncRec stores a record received from another database, or deletes it if
+// data is nil. The caller must hold the table's write lock.
+func (db *DB) syncRec(tblName string, fileId string, data []byte) error {
+	if data == nil {
+		err := db.removeRec(context.Background(), tblName, fileId)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return db.writeRec(context.Background(), tblName, fileId, data, true)
+}
+
+// readSyncBase returns the state of the last sync with the named peer, or an
+// empty state if there was none.
+func (db *DB) readSyncBase(name string) (syncBase, error) {
+	if db.path == "" {
+		if base, ok := db.syncBases[name]; ok {
+			return base, nil
+		}
+		return syncBase{}, nil
+	}
+
+	data, err := db.fs.ReadFile(db.syncBasePath(name))
+	if os.IsNotExist(err) {
+		return syncBase{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var base syncBase
+
+	err = json.Unmarshal(data, &base)
+	if err != nil {
+		return nil, err
+	}
+
+	return base, nil
+}
+
+// writeSyncBase saves the state of a sync with the named peer.
+func (db *DB) writeSyncBase(name string, base syncBase) error {
+	if db.path == "" {
+		if db.syncBases == nil {
+			db.syncBases = make(map[string]syncBase)
+		}
+		db.syncBases[name] = base
+		return nil
+	}
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+
+	err = db.fs.MkdirAll(db.metaPath("sync"), db.modes.dir)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.fs, db.syncBasePath(name), data, db.modes.file)
+}
+
+// syncBasePath returns the path of the state of the last sync with the named
+// peer.
+func (db *DB) syncBasePath(name string) string {
+	return db.metaPath("sync", url.PathEscape(name)+".json")
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// lastWriteWins returns a conflict resolver keeping the version of a record
+// with the later timestamp in the supplied field. A change wins over a
+// deletion, and a version with a timestamp over one without. Versions that
+// cannot be told apart are ordered by their contents, so that the result does
+// not depend on which side started the sync.
+func lastWriteWins(field string) func(c Conflict) (json.RawMessage, error) {
+	if field == "" {
+		field = "updated_at"
+	}
+
+	return func(c Conflict) (json.RawMessage, error) {
+		if c.Local == nil {
+			return c.Remote, nil
+		}
+		if c.Remote == nil {
+			return c.Local, nil
+		}
+
+		lt, lok := recTimestamp(c.Local, field)
+		rt, rok := recTimestamp(c.Remote, field)
+
+		switch {
+		case lok && rok && !lt.Equal(rt):
+			if lt.After(rt) {
+				return c.Local, nil
+			}
+			return c.Remote, nil
+		case lok && !rok:
+			return c.Local, nil
+		case rok && !lok:
+			return c.Remote, nil
+		}
+
+		if bytes.Compare(c.Local, c.Remote) > 0 {
+			return c.Local, nil
+		}
+		return c.Remote, nil
+	}
+}
+
+// recTimestamp returns the time held by a field of a marshalled record, and
+// whether there is one.
+func recTimestamp(data []byte, field string) (time.Time, bool) {
+	var fields map[string]interface{}
+
+	if json.Unmarshal(data, &fields) != nil {
+		return time.Time{}, false
+	}
+
+	switch v := fields[field].(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	}
+
+	return time.Time{}, false
+}
+
+// recSum returns the checksum of a marshalled record, or an empty string if
+// there is no record.
+func recSum(data []byte) string {
+	if data == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%08x", crc32.Checksum(data, checksumTable))
+}
+
+// unionIds returns the ids of the records of both tables, in numeric order.
+func unionIds(a map[string][]byte, b map[string][]byte) []string {
+	var fileIds []string
+
+	for fileId := range a {
+		fileIds = append(fileIds, fileId)
+	}
+	for fileId := range b {
+		if _, ok := a[fileId]; !ok {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+	return fileIds
+}
+
+// idNum returns the number in a record id, or zero if it is not a number.
+func idNum(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,228 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// CopyTable creates a new table holding a copy of every record of another
+// table, with the same ids, such as to keep a snapshot of a table before a
+// risky batch edit. The new table is indexed on the same fields as the
+// original, until the database is closed; to keep it indexed, pass it to
+// OpenDB with the other tables. The new table stays locked until the copy is
+// complete, while the original can still be read. It takes the name of the
+// table to copy and the name of the new table. It returns any error
+// encountered.
+func (db *DB) CopyTable(srcTblName string, dstTblName string) error {
+	return db.CopyTableCtx(context.Background(), srcTblName, dstTblName)
+}
+
+// CopyTableCtx is CopyTable with a context, which is handed to the
+// authorizer.
+func (db *DB) CopyTableCtx(ctx context.Context, srcTblName string, dstTblName string) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	ops, err := db.beginOps(ctx, []string{srcTblName, dstTblName}, "copy")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	srcLock := db.tblLock(srcTblName)
+	if srcLock == nil {
+		return tableNotFoundErr(srcTblName)
+	}
+
+	fldNames, _ := db.indexFields(srcTblName)
+
+	dstLock, err := db.addTable(dstTblName, fldNames)
+	if err != nil {
+		return err
+	}
+	defer dstLock.Unlock()
+
+	srcLock.RLock()
+	defer srcLock.RUnlock()
+
+	fileIds, err := db.engine.ids(srcTblName)
+	if err != nil {
+		return err
+	}
+
+	for _, fileId := range sortedIdList(fileIds) {
+		data, err := db.readRec(srcTblName, fileId)
+		if err != nil {
+			return err
+		}
+
+		err = db.writeRec(ctx, dstTblName, fileId, data, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterTable makes a table known to the database, indexed on the supplied
+// fields, or not indexed if they are nil. A table that does not exist yet is
+// created. A table that exists, such as one created on disk by another
+// program since the database was opened, gets the new index fields, replacing
+// any it had, and its indexes are rebuilt. It takes a table name and the
+// fields to index. It returns any error encountered.
+func (db *DB) RegisterTable(tblName string, fldNames []string) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		if err := db.checkWritable(); err != nil {
+			return err
+		}
+
+		rwLock, err := db.addTable(tblName, fldNames)
+		if err != nil {
+			return err
+		}
+		rwLock.Unlock()
+
+		return nil
+	}
+
+	rwLock.Lock()
+	defer rwLock.Unlock()
+
+	db.tblMu.Lock()
+	db.setIndexFields(tblName, fldNames)
+	db.saveTblConfig(tblName)
+	db.tblMu.Unlock()
+
+	return db.initTblIndexes(context.Background(), tblName)
+}
+
+// TableNames returns the names of all tables of the database, in order,
+// including tables created in storage since the database was opened. It
+// returns any error encountered.
+func (db *DB) TableNames() ([]string, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	tblNames, err := db.engine.tableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tblName := range tblNames {
+		db.tblLock(tblName)
+	}
+
+	return db.tableNames(), nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// addTable creates an empty table indexed on the supplied fields, or not
+// indexed if they are nil. It returns the lock of the new table, locked for
+// writing, so that the caller can fill the table before anyone else uses it.
+func (db *DB) addTable(tblName string, fldNames []string) (*tblMutex, error) {
+	if tblName == "" || isHidden(tblName) || strings.ContainsAny(tblName, `/\`) {
+		return nil, fmt.Errorf("ivy: invalid table name %q", tblName)
+	}
+
+	db.tblMu.Lock()
+	defer db.tblMu.Unlock()
+
+	if _, ok := db.rwLocks[tblName]; ok {
+		return nil, fmt.Errorf("%w: table %s already exists", ErrConflict, tblName)
+	}
+
+	err := db.engine.createTable(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	db.setIndexFields(tblName, fldNames)
+	db.saveTblConfig(tblName)
+
+	rwLock := db.newTblLock(tblName)
+	rwLock.Lock()
+
+	db.rwLocks[tblName] = rwLock
+
+	return rwLock, nil
+}
+
+// discoverTable registers a table that was created in storage since the
+// database was opened, such as by another program, unindexed. It returns the
+// lock of the table, or nil if there is no such table.
+func (db *DB) discoverTable(tblName string) *tblMutex {
+	if tblName == "" || isHidden(tblName) || strings.ContainsAny(tblName, `/\`) {
+		return nil
+	}
+
+	tblNames, err := db.engine.tableNames()
+	if err != nil || !stringInSlice(tblName, tblNames) {
+		return nil
+	}
+
+	db.tblMu.Lock()
+	defer db.tblMu.Unlock()
+
+	if rwLock, ok := db.rwLocks[tblName]; ok {
+		return rwLock
+	}
+
+	rwLock := db.newTblLock(tblName)
+	db.rwLocks[tblName] = rwLock
+
+	db.logger.Info("ivy: table found", "table", tblName)
+
+	return rwLock
+}
+
+// setIndexFields sets the fields a table is indexed on, with empty indexes,
+// or makes it unindexed if they are nil. The caller holds tblMu.
+func (db *DB) setIndexFields(tblName string, fldNames []string) {
+	// The map may be the caller's, so it is copied rather than changed.
+	fieldsToIndex := make(map[string][]string, len(db.fieldsToIndex)+1)
+	for name, fields := range db.fieldsToIndex {
+		fieldsToIndex[name] = fields
+	}
+
+	delete(fieldsToIndex, tblName)
+	delete(db.tagIndexes, tblName)
+	delete(db.fldIndexes, tblName)
+
+	if fldNames != nil {
+		fieldsToIndex[tblName] = append([]string(nil), fldNames...)
+
+		db.tagIndexes[tblName] = make(map[string][]string)
+		db.fldIndexes[tblName] = make(map[string]map[string][]string)
+
+		for _, fldName := range fldNames {
+			if fldName != "tags" {
+				db.fldIndexes[tblName][fldName] = make(map[string][]string)
+			}
+		}
+	}
+
+	db.fieldsToIndex = fieldsToIndex
+}
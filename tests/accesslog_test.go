@@ -0,0 +1,133 @@
+package ivy
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-accesslog")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "access.log")
+
+	db, err := ivy.OpenDBWithOptions("", map[string][]string{"planes": {"enginetype"}}, ivy.Options{
+		Storage:   ivy.MemoryStorage,
+		AccessLog: &ivy.AccessLogOptions{Path: logPath, Actor: "hangar"},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	fileId, err := db.Create("planes", Plane{Name: "Spitfire", EngineType: "piston", Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	plane := Plane{}
+	db.Find("planes", &plane, fileId)
+	db.Find("planes", &plane, "99")
+	db.QueryString("planes", "enginetype = 'piston'")
+	db.Delete("planes", fileId)
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	entries := readAccessLog(t, logPath)
+
+	expected := []struct{ op, id string }{
+		{"create", fileId}, {"find", fileId}, {"find", "99"}, {"query", ""}, {"delete", fileId},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), entries)
+	}
+
+	for i, e := range entries {
+		if e.Op != expected[i].op || e.Id != expected[i].id || e.Table != "planes" || e.Actor != "hangar" || e.Time.IsZero() {
+			t.Errorf("Unexpected entry %d: %+v", i, e)
+		}
+
+		if (e.Outcome == "ok") == (i == 2) {
+			t.Errorf("Unexpected outcome of entry %d: %+v", i, e)
+		}
+	}
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-accesslog")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "access.log")
+
+	db, err := ivy.OpenDBWithOptions("", map[string][]string{"planes": nil}, ivy.Options{
+		Storage:   ivy.MemoryStorage,
+		AccessLog: &ivy.AccessLogOptions{Path: logPath, MaxSize: 300, MaxFiles: 2},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		db.FindAllIds("planes")
+	}
+
+	for _, name := range []string{"access.log", "access.log.1", "access.log.2"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal("Expected a rotated log file:", err)
+		}
+
+		if info.Size() > 300 {
+			t.Errorf("Expected %s to be at most 300 bytes, got %d", name, info.Size())
+		}
+
+		for _, e := range readAccessLog(t, filepath.Join(dir, name)) {
+			if e.Op != "ids" {
+				t.Errorf("Unexpected entry in %s: %+v", name, e)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "access.log.3")); !os.IsNotExist(err) {
+		t.Error("Expected only 2 rotated files, got", err)
+	}
+}
+
+func readAccessLog(t *testing.T, path string) []ivy.AccessLogEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer f.Close()
+
+	var entries []ivy.AccessLogEntry
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e ivy.AccessLogEntry
+
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			t.Fatal("Unmarshal failed:", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
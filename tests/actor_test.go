@@ -0,0 +1,144 @@
+package ivy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type Document struct {
+	Title     string `json:"title"`
+	CreatedBy string `json:"created_by"`
+	UpdatedBy string `json:"updated_by"`
+}
+
+func (d *Document) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestActor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-actor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	events := make(chan ivy.WebhookEvent, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event ivy.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer srv.Close()
+
+	logPath := filepath.Join(dir, "access.log")
+
+	adb, err := ivy.OpenDBWithOptions("", map[string][]string{"docs": nil}, ivy.Options{
+		Storage:     ivy.MemoryStorage,
+		ActorStamps: true,
+		AccessLog:   &ivy.AccessLogOptions{Path: logPath, Actor: "service"},
+		Webhooks:    []ivy.Webhook{{URL: srv.URL}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	alice := ivy.WithActor(context.Background(), "alice")
+	bob := ivy.WithActor(context.Background(), "bob")
+
+	id, err := adb.CreateCtx(alice, "docs", Document{Title: "Draft"})
+	if err != nil {
+		t.Fatal("CreateCtx failed:", err)
+	}
+
+	err = adb.UpdateCtx(bob, "docs", Document{Title: "Final", CreatedBy: "mallory"}, id)
+	if err != nil {
+		t.Fatal("UpdateCtx failed:", err)
+	}
+
+	doc := Document{}
+	if err := adb.Find("docs", &doc, id); err != nil || doc.CreatedBy != "alice" || doc.UpdatedBy != "bob" {
+		t.Error("Expected the document to be created by alice and updated by bob, got", doc, err)
+	}
+
+	for _, want := range []string{"alice", "bob"} {
+		if event := <-events; event.Actor != want {
+			t.Error("Expected a webhook event by", want, "got", event.Actor)
+		}
+	}
+
+	adb.Close()
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var actors []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry ivy.AccessLogEntry
+		json.Unmarshal(scanner.Bytes(), &entry)
+		actors = append(actors, entry.Actor)
+	}
+
+	if len(actors) != 3 || actors[0] != "alice" || actors[1] != "bob" || actors[2] != "service" {
+		t.Error("Expected the access log actors [alice bob service], got", actors)
+	}
+
+	if actor, ok := ivy.ActorFromContext(alice); !ok || actor != "alice" {
+		t.Error("Expected ActorFromContext to return alice, got", actor, ok)
+	}
+}
+
+func TestActorBulkWrites(t *testing.T) {
+	adb, err := ivy.OpenDBWithOptions("", map[string][]string{"docs": nil}, ivy.Options{
+		Storage:     ivy.MemoryStorage,
+		ActorStamps: true,
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer adb.Close()
+
+	alice := ivy.WithActor(context.Background(), "alice")
+	bob := ivy.WithActor(context.Background(), "bob")
+
+	id, err := adb.CreateCtx(alice, "docs", Document{Title: "Template"})
+	if err != nil {
+		t.Fatal("CreateCtx failed:", err)
+	}
+
+	copyId, err := adb.DuplicateCtx(bob, "docs", id)
+	if err != nil {
+		t.Fatal("DuplicateCtx failed:", err)
+	}
+
+	report, err := adb.ImportCSVCtx(bob, "docs", strings.NewReader("title\nImported\n"), nil)
+	if err != nil {
+		t.Fatal("ImportCSVCtx failed:", err)
+	}
+
+	if len(report.Ids) != 1 {
+		t.Fatal("Expected one imported record, got", report.Ids)
+	}
+
+	fileIds := []string{copyId, report.Ids[0]}
+
+	for _, fileId := range fileIds {
+		doc := Document{}
+		if err := adb.Find("docs", &doc, fileId); err != nil || doc.CreatedBy != "bob" || doc.UpdatedBy != "bob" {
+			t.Errorf("Expected record %s to be created by bob, got %v %v", fileId, doc, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, srv *httptest.Server, method string, path string, body string) (int, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal("NewRequest failed:", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("ReadAll failed:", err)
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+func TestAdminHandler(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"planes": {"enginetype", "tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	db.Create("planes", Plane{Name: "Spitfire", EngineType: "piston", Tags: []string{"uk", "ww2"}})
+	db.Create("planes", Plane{Name: "Meteor", EngineType: "jet", Tags: []string{"uk"}})
+	db.Create("planes", Plane{Name: "Mustang", EngineType: "piston", Speed: 703, Tags: []string{"us", "ww2"}})
+
+	srv := httptest.NewServer(db.AdminHandler())
+	defer srv.Close()
+
+	status, body := adminRequest(t, srv, "GET", "/", "")
+	if status != http.StatusOK || !strings.Contains(body, "<title>Ivy</title>") {
+		t.Errorf("Expected the admin page, got %d %.40q", status, body)
+	}
+
+	status, body = adminRequest(t, srv, "GET", "/api/tables", "")
+	if status != http.StatusOK || !strings.Contains(body, `"name":"planes","records":3`) {
+		t.Errorf("Unexpected tables %d %s", status, body)
+	}
+
+	status, body = adminRequest(t, srv, "GET", "/api/tables/planes", "")
+	if status != http.StatusOK || !strings.Contains(body, `{"field":"enginetype","values":2,"entries":3}`) ||
+		!strings.Contains(body, `{"field":"tags","values":3,"entries":5}`) {
+		t.Errorf("Unexpected table %d %s", status, body)
+	}
+
+	var page struct {
+		Total   int
+		Records []struct {
+			Id   string
+			Data Plane
+		}
+	}
+
+	searches := []struct {
+		query string
+		ids   string
+	}{
+		{"", "1 2 3"},
+		{"?offset=1&limit=1", "2"},
+		{"?field=enginetype&value=piston", "1 3"},
+		{"?tags=ww2,uk", "1"},
+		{"?q=speed+%3E+700", "3"},
+	}
+
+	for _, search := range searches {
+		status, body = adminRequest(t, srv, "GET", "/api/tables/planes/records"+search.query, "")
+
+		page.Records = nil
+		json.Unmarshal([]byte(body), &page)
+
+		var ids []string
+		for _, rec := range page.Records {
+			ids = append(ids, rec.Id)
+		}
+
+		if status != http.StatusOK || strings.Join(ids, " ") != search.ids {
+			t.Errorf("Search %q: expected %q, got %d %s", search.query, search.ids, status, body)
+		}
+	}
+
+	if page.Total != 1 || page.Records[0].Data.Name != "Mustang" {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	status, body = adminRequest(t, srv, "POST", "/api/tables/planes/records", `{"name":"Harrier","enginetype":"jet","tags":[]}`)
+	if status != http.StatusCreated || strings.TrimSpace(body) != `{"id":"4"}` {
+		t.Errorf("Unexpected create %d %s", status, body)
+	}
+
+	status, _ = adminRequest(t, srv, "PUT", "/api/tables/planes/records/4", `{"name":"Harrier II","enginetype":"jet","tags":["vtol"]}`)
+	if status != http.StatusNoContent {
+		t.Errorf("Expected update to succeed, got %d", status)
+	}
+
+	plane := Plane{}
+	db.Find("planes", &plane, "4")
+	if plane.Name != "Harrier II" {
+		t.Errorf("Expected the record to be updated, got %+v", plane)
+	}
+
+	status, body = adminRequest(t, srv, "GET", "/api/tables/planes/records/4", "")
+	if status != http.StatusOK || !strings.Contains(body, `"vtol"`) {
+		t.Errorf("Unexpected record %d %s", status, body)
+	}
+
+	status, _ = adminRequest(t, srv, "DELETE", "/api/tables/planes/records/4", "")
+	if status != http.StatusNoContent {
+		t.Errorf("Expected delete to succeed, got %d", status)
+	}
+
+	errorRequests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/api/tables/trains", "", http.StatusNotFound},
+		{"GET", "/api/tables/planes/records/4", "", http.StatusNotFound},
+		{"PUT", "/api/tables/planes/records/4", `{"name":"Ghost"}`, http.StatusNotFound},
+		{"POST", "/api/tables/planes/records", `["not", "an", "object"]`, http.StatusBadRequest},
+		{"GET", "/api/tables/planes/records?q=speed+%3E", "", http.StatusBadRequest},
+		{"GET", "/api/tables/planes/records?limit=x", "", http.StatusBadRequest},
+		{"DELETE", "/api/tables/planes", "", http.StatusMethodNotAllowed},
+		{"GET", "/api/elsewhere", "", http.StatusNotFound},
+	}
+
+	for _, req := range errorRequests {
+		status, body = adminRequest(t, srv, req.method, req.path, req.body)
+		if status != req.status {
+			t.Errorf("%s %s: expected %d, got %d %s", req.method, req.path, req.status, status, body)
+		}
+	}
+}
+
+func TestAdminHandlerRestricted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "contacts"), 0700)
+
+	policy := ivy.WithReadPolicy("contacts", ivy.ReadPolicy{Drop: []string{"age"}, Mask: map[string]int{"name": 4}})
+
+	pdb, err := ivy.OpenDB(dir, policy)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer pdb.Close()
+
+	pdb.Create("contacts", Contact{Name: "ann@example.com", Age: 30, Tags: []string{}})
+
+	rdb, err := ivy.OpenDB(dir, ivy.WithOptions(ivy.Options{NoLock: true}), policy, ivy.WithRestricted())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	srv := httptest.NewServer(rdb.AdminHandler())
+	defer srv.Close()
+
+	for _, path := range []string{"/api/tables/contacts/records", "/api/tables/contacts/records/1"} {
+		status, body := adminRequest(t, srv, "GET", path, "")
+		if status != http.StatusOK || !strings.Contains(body, `"name":"***********.com"`) ||
+			strings.Contains(body, "ann@") || strings.Contains(body, `"age"`) {
+			t.Errorf("%s: expected a masked record, got %d %s", path, status, body)
+		}
+	}
+}
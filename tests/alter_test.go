@@ -0,0 +1,99 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type Bird struct {
+	Name     string  `json:"name"`
+	Wingspan float64 `json:"wingspan"`
+	Color    string  `json:"color"`
+	Obsolete string  `json:"obsolete,omitempty"`
+}
+
+func (b *Bird) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestAlterTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-alter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "birds"), 0700)
+
+	fieldsToIndex := map[string][]string{"birds": {"color"}}
+
+	open := func() *ivy.DB {
+		tdb, err := ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+		return tdb
+	}
+
+	tdb := open()
+
+	for _, name := range []string{"wren", "robin", "jay", "finch", "owl", "lark", "kite"} {
+		rec := map[string]interface{}{"name": name, "colour": "brown", "obsolete": "x"}
+		if _, err := tdb.Create("birds", rec); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	changes := []ivy.FieldChange{ivy.AddField("wingspan", 0.5), ivy.DropField("obsolete"), ivy.RenameField("colour", "color")}
+
+	alt, err := tdb.AlterTableWithOptions("birds", ivy.AlterOptions{BatchSize: 2, Pause: 20 * time.Millisecond}, changes...)
+	if err != nil {
+		t.Fatal("AlterTable failed:", err)
+	}
+
+	if _, err := tdb.AlterTable("birds", ivy.DropField("name")); !errors.Is(err, ivy.ErrConflict) {
+		t.Error("Expected ErrConflict while the table is being altered, got", err)
+	}
+
+	// Closing interrupts the alteration, which the next OpenDB resumes.
+	tdb.Close()
+
+	tdb = open()
+	defer tdb.Close()
+
+	if alt = tdb.Alteration("birds"); alt != nil {
+		if err := alt.Wait(); err != nil {
+			t.Fatal("Resumed alteration failed:", err)
+		}
+		if p := alt.Progress(); !p.Finished || p.Total != 7 {
+			t.Error("Expected the resumed alteration to go through every record, got", p)
+		}
+	}
+
+	ids, _ := tdb.FindAllIds("birds")
+	for _, fileId := range ids {
+		bird := Bird{}
+		if err := tdb.Find("birds", &bird, fileId); err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		if bird.Wingspan != 0.5 || bird.Color != "brown" || bird.Obsolete != "" {
+			t.Error("Expected the record to be altered, got", bird)
+		}
+	}
+
+	if ids, _ := tdb.FindAllIdsForField("birds", "color", "brown"); len(ids) != 7 {
+		t.Error("Expected the index to hold the renamed field, got", ids)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".ivy", "alter", "birds.json")); !os.IsNotExist(err) {
+		t.Error("Expected the progress of a finished alteration to be removed, got", err)
+	}
+
+	if _, err := tdb.AlterTable("birds", ivy.FieldChange{Op: "bogus", Field: "name"}); err == nil {
+		t.Error("Expected an unknown field change to fail")
+	}
+}
@@ -0,0 +1,79 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	dest := filepath.Join(dir, "cold")
+
+	adb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"docs": {"title"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer adb.Close()
+
+	var oldIds []string
+	for _, title := range []string{"old", "new", "old"} {
+		id, err := adb.Create("docs", Document{Title: title})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		if title == "old" {
+			oldIds = append(oldIds, id)
+		}
+	}
+
+	n, err := adb.Archive("docs", ivy.M{"title": "old"}, dest)
+	if err != nil || n != 2 {
+		t.Fatal("Expected 2 records to be archived, got", n, err)
+	}
+
+	if ids, err := adb.FindAllIdsForField("docs", "title", "old"); err != nil || len(ids) != 0 {
+		t.Error("Expected the archived records to leave the table, got", ids, err)
+	}
+
+	if n, err := adb.Archive("docs", ivy.M{"title": "old"}, dest); err != nil || n != 0 {
+		t.Error("Expected nothing more to archive, got", n, err)
+	}
+
+	archive, err := ivy.OpenArchive(dest)
+	if err != nil {
+		t.Fatal("OpenArchive failed:", err)
+	}
+
+	if names, err := archive.TableNames(); err != nil || !reflect.DeepEqual(names, []string{"docs"}) {
+		t.Error("Expected the archived table docs, got", names, err)
+	}
+
+	if ids, err := archive.Ids("docs"); err != nil || !reflect.DeepEqual(ids, oldIds) {
+		t.Error("Expected the archived ids", oldIds, "got", ids, err)
+	}
+
+	doc := Document{}
+	if err := archive.Find("docs", &doc, oldIds[1]); err != nil || doc.Title != "old" {
+		t.Error("Expected to find the archived record, got", doc, err)
+	}
+
+	if ids, err := archive.Filter("docs", ivy.M{"title": ivy.M{"$ne": "old"}}); err != nil || len(ids) != 0 {
+		t.Error("Expected no archived record to match, got", ids, err)
+	}
+
+	if _, err := archive.Ids("planes"); !errors.Is(err, ivy.ErrTableNotFound) {
+		t.Error("Expected ErrTableNotFound for a table never archived, got", err)
+	}
+}
@@ -0,0 +1,89 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAuditChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	// A record written before the audit chain was turned on.
+	oldPath := filepath.Join(dir, "docs", "1.json")
+	ioutil.WriteFile(oldPath, []byte(`{"title":"Old"}`), 0600)
+
+	adb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"docs": nil}), ivy.WithAuditChain())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer adb.Close()
+
+	alice := ivy.WithActor(context.Background(), "alice")
+
+	id, err := adb.CreateCtx(alice, "docs", Document{Title: "Draft"})
+	if err != nil {
+		t.Fatal("CreateCtx failed:", err)
+	}
+
+	if err := adb.UpdateCtx(alice, "docs", Document{Title: "Final"}, id); err != nil {
+		t.Fatal("UpdateCtx failed:", err)
+	}
+
+	delId, err := adb.Create("docs", Document{Title: "Scratch"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if err := adb.Delete("docs", delId); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	report, err := adb.VerifyAuditChain()
+	if err != nil {
+		t.Fatal("VerifyAuditChain failed:", err)
+	}
+	if report.Entries != 5 || report.Records != 2 || report.Head == "" {
+		t.Error("Expected 5 entries and 2 records, got", report)
+	}
+
+	// A record edited behind the database's back.
+	ioutil.WriteFile(oldPath, []byte(`{"title":"Forged"}`), 0600)
+
+	_, err = adb.VerifyAuditChain()
+	if !errors.Is(err, ivy.ErrTampered) || !strings.Contains(err.Error(), "docs/1") {
+		t.Error("Expected ErrTampered for docs/1, got", err)
+	}
+
+	ioutil.WriteFile(oldPath, []byte(`{"title":"Old"}`), 0600)
+
+	if _, err := adb.VerifyAuditChain(); err != nil {
+		t.Error("Expected the restored record to verify, got", err)
+	}
+
+	// An entry of the log edited to hide who made a change.
+	logPath := filepath.Join(dir, ".ivy", "audit.log")
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(logPath, bytes.Replace(data, []byte(`"alice"`), []byte(`"bob"`), 1), 0600)
+
+	_, err = adb.VerifyAuditChain()
+	if !errors.Is(err, ivy.ErrTampered) || !strings.Contains(err.Error(), "entry 2") {
+		t.Error("Expected ErrTampered for entry 2, got", err)
+	}
+}
@@ -0,0 +1,92 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenAuth(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"planes": nil, "notes": nil})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	db.Create("planes", Plane{Name: "Spitfire", Tags: []string{}})
+
+	var auth ivy.TokenAuth
+
+	err = json.Unmarshal([]byte(`{
+		"reader": {"name": "dashboard", "role": "read"},
+		"writer": {"role": "write", "tables": ["notes"]},
+		"root": {"role": "admin"}
+	}`), &auth)
+	if err != nil {
+		t.Fatal("Unmarshal failed:", err)
+	}
+
+	if auth["writer"].Role != ivy.RoleWrite || auth["reader"].Name != "dashboard" {
+		t.Fatalf("Unexpected tokens %+v", auth)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", http.StripPrefix("/admin", db.AdminHandler()))
+	mux.Handle("/changes", db.ReplicationHandler())
+
+	srv := httptest.NewServer(auth.Wrap(mux))
+	defer srv.Close()
+
+	requests := []struct {
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		// The page asks for a token itself.
+		{"", "GET", "/admin/", http.StatusOK},
+		{"", "GET", "/admin/api/tables", http.StatusUnauthorized},
+		{"bogus", "GET", "/admin/api/tables", http.StatusUnauthorized},
+		{"reader", "GET", "/admin/api/tables", http.StatusOK},
+		{"reader", "GET", "/admin/api/tables/planes/records/1", http.StatusOK},
+		{"reader", "POST", "/admin/api/tables/notes/records", http.StatusForbidden},
+		{"writer", "POST", "/admin/api/tables/planes/records", http.StatusForbidden},
+		{"writer", "POST", "/admin/api/tables/notes/records", http.StatusCreated},
+		{"writer", "DELETE", "/admin/api/tables/notes/records/1", http.StatusNoContent},
+		{"writer", "GET", "/changes", http.StatusForbidden},
+		{"root", "DELETE", "/admin/api/tables/planes/records/1", http.StatusNoContent},
+	}
+
+	for _, req := range requests {
+		r, err := http.NewRequest(req.method, srv.URL+req.path, strings.NewReader(`{"text":"hi","tags":[]}`))
+		if err != nil {
+			t.Fatal("NewRequest failed:", err)
+		}
+
+		if req.token != "" {
+			r.Header.Set("Authorization", "Bearer "+req.token)
+		}
+
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != req.status {
+			t.Errorf("%s %s as %q: expected %d, got %d", req.method, req.path, req.token, req.status, resp.StatusCode)
+		}
+	}
+
+	if _, ok := auth.Authenticate(""); ok {
+		t.Error("Expected an empty secret to be refused")
+	}
+
+	var role ivy.Role
+	if err := role.UnmarshalText([]byte("owner")); err == nil {
+		t.Error("Expected an unknown role to be refused")
+	}
+}
@@ -0,0 +1,306 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type userKey struct{}
+
+func TestAuthorizer(t *testing.T) {
+	errReadOnly := errors.New("guests may only read")
+
+	var calls []string
+
+	authorizer := func(ctx context.Context, tblName string, op string, fileId string) error {
+		calls = append(calls, op+" "+tblName+" "+fileId)
+
+		user, _ := ctx.Value(userKey{}).(string)
+		switch {
+		case user == "admin":
+			return nil
+		case user == "guest" && (op == "find" || op == "query"):
+			return nil
+		case user == "guest":
+			return errReadOnly
+		}
+
+		return errors.New("unknown user")
+	}
+
+	adb, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(map[string][]string{"planes": {"tags"}}), ivy.WithAuthorizer(authorizer))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer adb.Close()
+
+	admin := context.WithValue(context.Background(), userKey{}, "admin")
+	guest := context.WithValue(context.Background(), userKey{}, "guest")
+
+	id, err := adb.CreateCtx(admin, "planes", Plane{Name: "Spitfire", Tags: []string{}})
+	if err != nil {
+		t.Fatal("CreateCtx failed:", err)
+	}
+
+	plane := Plane{}
+	if err := adb.FindCtx(guest, "planes", &plane, id); err != nil || plane.Name != "Spitfire" {
+		t.Error("Expected a guest to find the plane, got", plane.Name, err)
+	}
+
+	ids, err := adb.QueryStringCtx(guest, "planes", "name = ?", "Spitfire")
+	if err != nil || len(ids) != 1 {
+		t.Error("Expected a guest to query the planes, got", ids, err)
+	}
+
+	err = adb.DeleteCtx(guest, "planes", id)
+	if !errors.Is(err, ivy.ErrForbidden) || !errors.Is(err, errReadOnly) {
+		t.Error("Expected a guest's delete to fail with ErrForbidden and the authorizer's error, got", err)
+	}
+
+	// Calls without a context of their own have no user.
+	err = adb.Update("planes", Plane{Name: "Hurricane", Tags: []string{}}, id)
+	if !errors.Is(err, ivy.ErrForbidden) {
+		t.Error("Expected an update without a user to fail with ErrForbidden, got", err)
+	}
+
+	if err := adb.FindCtx(admin, "planes", &plane, id); err != nil || plane.Name != "Spitfire" {
+		t.Error("Expected the refused operations to change nothing, got", plane.Name, err)
+	}
+
+	want := []string{"create planes ", "find planes 1", "query planes ", "delete planes 1", "update planes 1", "find planes 1"}
+	if len(calls) != len(want) {
+		t.Fatal("Expected the authorizer calls", want, "got", calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Error("Expected the authorizer calls", want, "got", calls)
+			break
+		}
+	}
+}
+
+func TestAuthorizerCoversEveryRecordOperation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-authorizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	deny := false
+	errDenied := errors.New("denied")
+
+	authorizer := func(ctx context.Context, tblName string, op string, fileId string) error {
+		if deny {
+			return errDenied
+		}
+		return nil
+	}
+
+	adb, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(map[string][]string{"planes": {"tags"}}), ivy.WithAuthorizer(authorizer))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer adb.Close()
+
+	peer, err := ivy.OpenMemDB(map[string][]string{"planes": nil})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer peer.Close()
+
+	id, _ := adb.Create("planes", Plane{Name: "Spitfire", Tags: []string{}})
+
+	var backup bytes.Buffer
+	if err := adb.Backup(&backup); err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+	manifest, err := ivy.ReadBackupManifest(bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Fatal("ReadBackupManifest failed:", err)
+	}
+
+	fixtures := filepath.Join(dir, "fixtures")
+	os.Mkdir(fixtures, 0700)
+	ioutil.WriteFile(filepath.Join(fixtures, "planes.json"), []byte(`{"9": {"name": "Mosquito"}}`), 0600)
+
+	srv := httptest.NewServer(adb.AdminHandler())
+	defer srv.Close()
+
+	deny = true
+
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"ExportCSV", func() error { return adb.ExportCSV("planes", ioutil.Discard) }},
+		{"ExportJSON", func() error { return adb.ExportJSON(ioutil.Discard) }},
+		{"ExportToSQLite", func() error { return adb.ExportToSQLite(filepath.Join(dir, "planes.sqlite")) }},
+		{"CopyTo", func() error { return adb.CopyTo(filepath.Join(dir, "copy"), ivy.Options{}) }},
+		{"Backup", func() error { return adb.Backup(ioutil.Discard) }},
+		{"BackupIncremental", func() error { return adb.BackupIncremental(ioutil.Discard, manifest) }},
+		{"BackupTo", func() error {
+			upload := ivy.UploaderFunc(func(name string, r io.Reader) error { return nil })
+			return adb.BackupTo(upload, "backup.tar", ivy.UploadOptions{})
+		}},
+		{"Restore", func() error {
+			return adb.Restore(bytes.NewReader(backup.Bytes()), ivy.RestoreOptions{Table: "planes"})
+		}},
+		{"Changes", func() error { _, err := adb.Changes(0, 0); return err }},
+		{"Follow", func() error { _, err := adb.Follow(nil, ivy.FollowOptions{}); return err }},
+		{"SyncWith", func() error { _, err := adb.SyncWith(peer, ivy.SyncOptions{Peer: "peer"}); return err }},
+		{"Watch", func() error { _, err := adb.Watch("planes", ivy.WatchOptions{}); return err }},
+		{"Subscribe", func() error { _, err := adb.Subscribe("planes", "name = ?", "Spitfire"); return err }},
+		{"CopyTable", func() error { return adb.CopyTable("planes", "planes_copy") }},
+		{"ImportCSV", func() error {
+			_, err := adb.ImportCSV("planes", strings.NewReader("name\nMosquito\n"), nil)
+			return err
+		}},
+		{"ImportTable", func() error {
+			_, err := adb.ImportTable("planes", strings.NewReader(`{"id":"9","data":{"name":"Mosquito"}}`), ivy.ImportOptions{})
+			return err
+		}},
+		{"ImportJSON", func() error { return adb.ImportJSON(strings.NewReader(`{"planes": {"9": {"name": "Mosquito"}}}`)) }},
+		{"ImportMongo", func() error {
+			_, err := adb.ImportMongo("planes", strings.NewReader(`{"name": "Mosquito"}`), ivy.MongoImportOptions{})
+			return err
+		}},
+		{"LoadFixtures", func() error { return adb.LoadFixtures(fixtures, ivy.FixtureOptions{}) }},
+		{"AlterTable", func() error { _, err := adb.AlterTable("planes", ivy.DropField("speed")); return err }},
+		{"Repair", func() error { _, err := adb.Repair("planes", ivy.RepairOptions{}); return err }},
+		{"Compact", func() error { _, err := adb.Compact("planes"); return err }},
+		{"Verify", func() error { _, err := adb.Verify("planes"); return err }},
+		{"Reindex", func() error { return adb.Reindex("planes") }},
+		{"PinTable", func() error { return adb.PinTable("planes") }},
+	}
+
+	for _, c := range calls {
+		if err := c.call(); !errors.Is(err, ivy.ErrForbidden) || !errors.Is(err, errDenied) {
+			t.Errorf("%s: expected ErrForbidden, got %v", c.name, err)
+		}
+	}
+
+	for _, path := range []string{"/api/tables/planes/records", "/api/tables/planes/records/" + id} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal("Get failed:", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s: expected 403, got %d", path, resp.StatusCode)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	adb.ReplicationHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/changes", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("ReplicationHandler: expected 403, got %d", rec.Code)
+	}
+
+	deny = false
+
+	if ids, err := adb.FindAllIds("planes"); err != nil || len(ids) != 1 {
+		t.Error("Expected the refused operations to change nothing, got", ids, err)
+	}
+}
+
+func TestAuthorizerContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-authorizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authorizer := func(ctx context.Context, tblName string, op string, fileId string) error {
+		if user, _ := ctx.Value(userKey{}).(string); user != "admin" {
+			return errors.New("unknown user")
+		}
+		return nil
+	}
+
+	adb, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(map[string][]string{"planes": {"tags"}}), ivy.WithAuthorizer(authorizer))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer adb.Close()
+
+	admin := context.WithValue(context.Background(), userKey{}, "admin")
+
+	id, err := adb.CreateCtx(admin, "planes", Plane{Name: "Spitfire", Tags: []string{}})
+	if err != nil {
+		t.Fatal("CreateCtx failed:", err)
+	}
+
+	var backup bytes.Buffer
+	if err := adb.BackupCtx(admin, &backup); err != nil {
+		t.Fatal("BackupCtx failed:", err)
+	}
+
+	fixtures := filepath.Join(dir, "fixtures")
+	os.Mkdir(fixtures, 0700)
+	ioutil.WriteFile(filepath.Join(fixtures, "planes.json"), []byte(`{"9": {"name": "Mosquito"}}`), 0600)
+
+	snap, err := adb.Snapshot()
+	if err != nil {
+		t.Fatal("Snapshot failed:", err)
+	}
+	defer snap.Close()
+
+	// Every call is refused without the user, and allowed with it.
+	calls := []struct {
+		name  string
+		plain func() error
+		ctx   func(ctx context.Context) error
+	}{
+		{"Duplicate",
+			func() error { _, err := adb.Duplicate("planes", id); return err },
+			func(ctx context.Context) error { _, err := adb.DuplicateCtx(ctx, "planes", id); return err }},
+		{"ImportCSV",
+			func() error {
+				_, err := adb.ImportCSV("planes", strings.NewReader("name\nMosquito\n"), nil)
+				return err
+			},
+			func(ctx context.Context) error {
+				_, err := adb.ImportCSVCtx(ctx, "planes", strings.NewReader("name\nMosquito\n"), nil)
+				return err
+			}},
+		{"LoadFixtures",
+			func() error { return adb.LoadFixtures(fixtures, ivy.FixtureOptions{}) },
+			func(ctx context.Context) error { return adb.LoadFixturesCtx(ctx, fixtures, ivy.FixtureOptions{}) }},
+		{"ExportJSON",
+			func() error { return adb.ExportJSON(ioutil.Discard) },
+			func(ctx context.Context) error { return adb.ExportJSONCtx(ctx, ioutil.Discard) }},
+		{"Restore",
+			func() error {
+				return adb.Restore(bytes.NewReader(backup.Bytes()), ivy.RestoreOptions{Table: "planes", Id: id})
+			},
+			func(ctx context.Context) error {
+				return adb.RestoreCtx(ctx, bytes.NewReader(backup.Bytes()), ivy.RestoreOptions{Table: "planes", Id: id})
+			}},
+		{"Compact",
+			func() error { _, err := adb.Compact("planes"); return err },
+			func(ctx context.Context) error { _, err := adb.CompactCtx(ctx, "planes"); return err }},
+		{"Snapshot.FindAllIds",
+			func() error { _, err := snap.FindAllIds("planes"); return err },
+			func(ctx context.Context) error { _, err := snap.FindAllIdsCtx(ctx, "planes"); return err }},
+	}
+
+	for _, c := range calls {
+		if err := c.plain(); !errors.Is(err, ivy.ErrForbidden) {
+			t.Errorf("%s: expected ErrForbidden without a user, got %v", c.name, err)
+		}
+		if err := c.ctx(admin); err != nil {
+			t.Errorf("%sCtx: expected the admin to be allowed, got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package ivy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcPath := filepath.Join(dir, "src")
+
+	for _, tbl := range []string{"foos", "empties"} {
+		err = os.MkdirAll(filepath.Join(srcPath, tbl), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+
+	src, err := ivy.OpenDBWithOptions(srcPath, fieldsToIndex, ivy.Options{Checksums: true})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer src.Close()
+
+	for _, bar := range []string{"one", "two", "three"} {
+		if _, err := src.Create("foos", Foo{Bar: bar, Tags: []string{"b"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err = src.Backup(&buf)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	dstPath := filepath.Join(dir, "dst")
+
+	err = ivy.RestoreBackup(bytes.NewReader(buf.Bytes()), dstPath)
+	if err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dstPath, "empties")); err != nil {
+		t.Error("Expected empty table to be restored:", err)
+	}
+
+	dst, err := ivy.OpenDB(dstPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer dst.Close()
+
+	ids, err := dst.FindAllIdsForTags("foos", []string{"b"})
+	if err != nil || len(ids) != 3 {
+		t.Error("Expected 3 restored records, got", ids, err)
+	}
+
+	foo := Foo{}
+	err = dst.Find("foos", &foo, "2")
+	if err != nil || foo.Bar != "two" {
+		t.Error("Expected restored record 2 to be 'two', got", foo.Bar, err)
+	}
+
+	// Restoring over an existing database is refused.
+	err = ivy.RestoreBackup(bytes.NewReader(buf.Bytes()), dstPath)
+	if err == nil {
+		t.Error("Expected RestoreBackup into a non-empty directory to fail")
+	}
+}
+
+func TestHotBackup(t *testing.T) {
+	hdb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer hdb.Close()
+
+	for i := 0; i < 200; i++ {
+		if _, err := hdb.Create("foos", Foo{Bar: "hot", Tags: []string{"h"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	done := make(chan struct{})
+	writes := 0
+
+	// Keep writing, and deleting, while the backup runs.
+	go func() {
+		defer close(done)
+		for i := 1; i <= 200; i += 2 {
+			if err := hdb.Delete("foos", strconv.Itoa(i)); err != nil {
+				t.Error("Delete failed:", err)
+				return
+			}
+			if _, err := hdb.Create("foos", Foo{Bar: "new", Tags: []string{"h"}}); err != nil {
+				t.Error("Create failed:", err)
+				return
+			}
+			writes++
+		}
+	}()
+
+	var buf bytes.Buffer
+
+	err = hdb.Backup(&buf)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	<-done
+
+	if writes != 100 {
+		t.Error("Expected all writes to succeed during the backup, got", writes)
+	}
+
+	dir, err := ioutil.TempDir("", "ivy-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ivy.RestoreBackup(&buf, filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "db", "foos"))
+	if err != nil || len(files) == 0 {
+		t.Error("Expected records in the restored table, got", len(files), err)
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	idb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer idb.Close()
+
+	for i := 0; i < 50; i++ {
+		if _, err := idb.Create("foos", Foo{Bar: "static", Tags: []string{"i"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	var full bytes.Buffer
+	if err := idb.Backup(&full); err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	manifest, err := ivy.ReadBackupManifest(bytes.NewReader(full.Bytes()))
+	if err != nil {
+		t.Fatal("ReadBackupManifest failed:", err)
+	}
+	if manifest.Incremental || len(manifest.Tables["foos"]) != 50 {
+		t.Fatal("Expected a full manifest of 50 records, got", manifest)
+	}
+
+	if err := idb.Update("foos", Foo{Bar: "changed", Tags: []string{"i"}}, "7"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := idb.Delete("foos", "8"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+	if _, err := idb.Create("foos", Foo{Bar: "added", Tags: []string{"i"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	var incr bytes.Buffer
+	if err := idb.BackupIncremental(&incr, manifest); err != nil {
+		t.Fatal("BackupIncremental failed:", err)
+	}
+
+	if incr.Len() >= full.Len() {
+		t.Error("Expected the incremental backup to be smaller than the full one, got", incr.Len(), full.Len())
+	}
+
+	dbPath := filepath.Join(dir, "db")
+
+	if err := ivy.RestoreBackup(bytes.NewReader(incr.Bytes()), dbPath); err == nil {
+		t.Error("Expected RestoreBackup to refuse an incremental backup")
+	}
+
+	if err := ivy.RestoreBackup(&full, dbPath); err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+	if err := ivy.RestoreIncremental(&incr, dbPath); err != nil {
+		t.Fatal("RestoreIncremental failed:", err)
+	}
+
+	rdb, err := ivy.OpenDB(dbPath, ivy.WithIndexes(map[string][]string{"foos": {"tags"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	ids, err := rdb.FindAllIds("foos")
+	if err != nil || len(ids) != 50 {
+		t.Error("Expected 50 records after the incremental restore, got", len(ids), err)
+	}
+
+	foo := Foo{}
+	if err := rdb.Find("foos", &foo, "7"); err != nil || foo.Bar != "changed" {
+		t.Error("Expected record 7 to be 'changed', got", foo.Bar, err)
+	}
+	if err := rdb.Find("foos", &foo, "8"); !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected record 8 to be deleted, got", err)
+	}
+	if err := rdb.Find("foos", &foo, "51"); err != nil || foo.Bar != "added" {
+		t.Error("Expected record 51 to be 'added', got", foo.Bar, err)
+	}
+}
+
+func TestSelectiveRestore(t *testing.T) {
+	sdb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags", "bar"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer sdb.Close()
+
+	for _, bar := range []string{"one", "two", "three"} {
+		if _, err := sdb.Create("foos", Foo{Bar: bar, Tags: []string{"r"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := sdb.Backup(&buf); err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+	archive := buf.Bytes()
+
+	if err := sdb.Update("foos", Foo{Bar: "oops", Tags: []string{"r"}}, "1"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := sdb.Update("foos", Foo{Bar: "oops", Tags: []string{"r"}}, "2"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	// Restore a single record.
+	err = sdb.Restore(bytes.NewReader(archive), ivy.RestoreOptions{Table: "foos", Id: "1"})
+	if err != nil {
+		t.Fatal("Restore failed:", err)
+	}
+
+	foo := Foo{}
+	if err := sdb.Find("foos", &foo, "1"); err != nil || foo.Bar != "one" {
+		t.Error("Expected record 1 to be restored, got", foo.Bar, err)
+	}
+	if err := sdb.Find("foos", &foo, "2"); err != nil || foo.Bar != "oops" {
+		t.Error("Expected record 2 to be left alone, got", foo.Bar, err)
+	}
+
+	err = sdb.Restore(bytes.NewReader(archive), ivy.RestoreOptions{Table: "foos", Id: "99"})
+	if !os.IsNotExist(err) {
+		t.Error("Expected restoring a missing record to fail with 'does not exist', got", err)
+	}
+
+	// Restore the whole table.
+	if _, err := sdb.Create("foos", Foo{Bar: "later", Tags: []string{"r"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	err = sdb.Restore(bytes.NewReader(archive), ivy.RestoreOptions{Table: "foos"})
+	if err != nil {
+		t.Fatal("Restore failed:", err)
+	}
+
+	ids, err := sdb.FindAllIdsForField("foos", "bar", "two")
+	if err != nil || len(ids) != 1 || ids[0] != "2" {
+		t.Error("Expected the index to find restored record 2, got", ids, err)
+	}
+
+	ids, err = sdb.FindAllIdsForTags("foos", []string{"r"})
+	if err != nil || len(ids) != 3 {
+		t.Error("Expected the record created after the backup to be removed, got", ids, err)
+	}
+}
+
+func TestRestoreChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "foos"), 0700)
+
+	rdb, err := ivy.OpenDB(dir)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	rdb.Create("foos", Foo{Bar: "one", Tags: []string{}})
+
+	var buf bytes.Buffer
+	if err := rdb.Backup(&buf); err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	// An archive naming a record file with an id that is not a number.
+	var crafted bytes.Buffer
+	gw := gzip.NewWriter(&crafted)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"foos/..json", "foos/1.json"} {
+		data := []byte(`{"bar": "crafted"}`)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0600, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+
+	err = rdb.Restore(&crafted, ivy.RestoreOptions{Table: "foos"})
+	if !errors.Is(err, ivy.ErrInvalidID) {
+		t.Error("Expected a crafted id to fail with ErrInvalidID, got", err)
+	}
+
+	err = rdb.Restore(bytes.NewReader(buf.Bytes()), ivy.RestoreOptions{Table: "foos", Id: "../1"})
+	if !errors.Is(err, ivy.ErrInvalidID) {
+		t.Error("Expected an invalid id to fail with ErrInvalidID, got", err)
+	}
+
+	rdb.Close()
+
+	rdb, err = ivy.OpenDB(dir, ivy.WithReadOnly())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	err = rdb.Restore(bytes.NewReader(buf.Bytes()), ivy.RestoreOptions{Table: "foos"})
+	if !errors.Is(err, ivy.ErrReadOnly) {
+		t.Error("Expected Restore into a read-only database to fail with ErrReadOnly, got", err)
+	}
+
+	foo := Foo{}
+	if err := rdb.Find("foos", &foo, "1"); err != nil || foo.Bar != "one" {
+		t.Error("Expected the record to be left alone, got", foo.Bar, err)
+	}
+}
@@ -0,0 +1,143 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-backupcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fieldsToIndex := map[string][]string{"foos": {"tags"}}
+
+	src, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer src.Close()
+
+	// Enough data to span several chunks.
+	big := strings.Repeat("secret ", 30000)
+	for _, bar := range []string{"one", big} {
+		if _, err := src.Create("foos", Foo{Bar: bar, Tags: []string{"c"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	key := bytes.Repeat([]byte{7}, 32)
+
+	var buf bytes.Buffer
+
+	ew, err := ivy.EncryptBackup(&buf, key)
+	if err != nil {
+		t.Fatal("EncryptBackup failed:", err)
+	}
+
+	err = src.Backup(ew)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	err = ew.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Error("Expected encrypted backup not to contain plaintext")
+	}
+
+	// A wrong key fails to decrypt.
+	r, err := ivy.DecryptBackup(bytes.NewReader(buf.Bytes()), bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatal("DecryptBackup failed:", err)
+	}
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ivy.ErrBadBackupKey) {
+		t.Error("Expected ErrBadBackupKey for wrong key, got", err)
+	}
+
+	// A truncated backup fails to decrypt.
+	r, err = ivy.DecryptBackup(bytes.NewReader(buf.Bytes()[:buf.Len()-100]), key)
+	if err != nil {
+		t.Fatal("DecryptBackup failed:", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); !errors.Is(err, ivy.ErrBadBackupKey) {
+		t.Error("Expected ErrBadBackupKey for truncated backup, got", err)
+	}
+
+	r, err = ivy.DecryptBackup(bytes.NewReader(buf.Bytes()), key)
+	if err != nil {
+		t.Fatal("DecryptBackup failed:", err)
+	}
+
+	dstPath := filepath.Join(dir, "dst")
+
+	err = ivy.RestoreBackup(r, dstPath)
+	if err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+
+	dst, err := ivy.OpenDB(dstPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer dst.Close()
+
+	foo := Foo{}
+	err = dst.Find("foos", &foo, "2")
+	if err != nil || foo.Bar != big {
+		t.Error("Expected restored record 2 to match, got error", err)
+	}
+}
+
+func TestEncryptedBackupTo(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Create("foos", Foo{Bar: "secret", Tags: []string{"c"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	key := bytes.Repeat([]byte{1}, 16)
+
+	var received []byte
+
+	uploader := ivy.UploaderFunc(func(name string, r io.Reader) error {
+		var err error
+		received, err = ioutil.ReadAll(r)
+		return err
+	})
+
+	err = db.BackupTo(uploader, "backup.enc", ivy.UploadOptions{Key: key})
+	if err != nil {
+		t.Fatal("BackupTo failed:", err)
+	}
+
+	r, err := ivy.DecryptBackup(bytes.NewReader(received), key)
+	if err != nil {
+		t.Fatal("DecryptBackup failed:", err)
+	}
+
+	manifest, err := ivy.ReadBackupManifest(r)
+	if err != nil {
+		t.Fatal("ReadBackupManifest failed:", err)
+	}
+
+	if len(manifest.Tables["foos"]) != 1 {
+		t.Error("Expected 1 record in manifest, got", manifest.Tables)
+	}
+}
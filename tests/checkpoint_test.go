@@ -0,0 +1,161 @@
+package ivy
+
+import (
+	"bytes"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIndexCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+	opts := ivy.Options{PersistentIndexes: true}
+
+	cdb, err := ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	id, err := cdb.Create("foos", Foo{Bar: "checkpointed", Tags: []string{"cp"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	cdb.Close()
+
+	checkpoint := filepath.Join(dir, ".ivy", "indexes", "foos.json")
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatal("Expected index checkpoint to be written:", err)
+	}
+
+	matches, _ := filepath.Glob(checkpoint + ".tmp-*")
+	if len(matches) != 0 {
+		t.Error("Expected no temporary checkpoint files, got", matches)
+	}
+
+	// A valid checkpoint is loaded instead of scanning the records.
+	cdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	ids, err := cdb.FindAllIdsForTags("foos", []string{"cp"})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Error("Expected checkpointed tag index to return", id, "got", ids, err)
+	}
+
+	cdb.Close()
+
+	// Change a record behind ivy's back; the stale checkpoint must be ignored.
+	err = ioutil.WriteFile(filepath.Join(dir, "foos", id+".json"), []byte(`{"bar":"edited","tags":["hand"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer cdb.Close()
+
+	ids, err = cdb.FindAllIdsForTags("foos", []string{"hand"})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Error("Expected rebuilt tag index to return", id, "got", ids, err)
+	}
+
+	ids, err = cdb.FindAllIdsForField("foos", "bar", "checkpointed")
+	if err != nil || len(ids) != 0 {
+		t.Error("Expected stale field index entries to be gone, got", ids, err)
+	}
+}
+
+func TestIndexCheckpointReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+
+	// NoLock lets the test reopen a database it never closed, as if the
+	// process had crashed.
+	opts := ivy.Options{PersistentIndexes: true, WAL: &ivy.WALOptions{}, NoLock: true,
+		Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	cdb, err := ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	for _, bar := range []string{"one", "two", "three"} {
+		if _, err := cdb.Create("foos", Foo{Bar: bar, Tags: []string{"cp"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	if err := cdb.Checkpoint(); err != nil {
+		t.Fatal("Checkpoint failed:", err)
+	}
+
+	// Changes after the checkpoint are only in the write-ahead log.
+	if _, err := cdb.Create("foos", Foo{Bar: "four", Tags: []string{"late"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if err := cdb.Update("foos", Foo{Bar: "two", Tags: []string{"late"}}, "2"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := cdb.Delete("foos", "3"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	cdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer cdb.Close()
+
+	if !strings.Contains(buf.String(), "replayed logged changes") {
+		t.Error("Expected the changes since the checkpoint to be replayed, got", buf.String())
+	}
+
+	ids, err := cdb.FindAllIdsForTags("foos", []string{"late"})
+	sort.Strings(ids)
+	if err != nil || !reflect.DeepEqual(ids, []string{"2", "4"}) {
+		t.Error("Expected the replayed tag index to return [2 4], got", ids, err)
+	}
+
+	ids, err = cdb.FindAllIdsForTags("foos", []string{"cp"})
+	if err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Error("Expected the replayed tag index to return [1], got", ids, err)
+	}
+
+	ids, err = cdb.FindAllIdsForField("foos", "bar", "three")
+	if err != nil || len(ids) != 0 {
+		t.Error("Expected the deleted record to leave the field index, got", ids, err)
+	}
+}
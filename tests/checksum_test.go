@@ -0,0 +1,141 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+
+	cdb, err := ivy.OpenDBWithOptions(dir, fieldsToIndex, ivy.Options{Checksums: true})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer cdb.Close()
+
+	goodId, err := cdb.Create("foos", Foo{Bar: "good", Tags: []string{"a"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	badId, err := cdb.Create("foos", Foo{Bar: "bad", Tags: []string{"b"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	foo := Foo{}
+	err = cdb.Find("foos", &foo, goodId)
+	if err != nil || foo.Bar != "good" {
+		t.Fatal("Expected to find 'good', got", foo.Bar, err)
+	}
+
+	// Flip a byte in the stored record, keeping it valid json.
+	badPath := filepath.Join(dir, "foos", badId+".json")
+	data, err := ioutil.ReadFile(badPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"_checksum"`)) {
+		t.Fatal("Expected record to hold a checksum, got", string(data))
+	}
+	err = ioutil.WriteFile(badPath, bytes.Replace(data, []byte("bad"), []byte("bag"), 1), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cdb.Find("foos", &foo, badId)
+	if !errors.Is(err, ivy.ErrCorrupt) {
+		t.Error("Expected ErrCorrupt, got", err)
+	}
+
+	report, err := cdb.Verify("foos")
+	if err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+	if report.Checked != 2 || report.Unchecked != 0 {
+		t.Error("Expected 2 checked records, got", report.Checked, report.Unchecked)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0] != badId {
+		t.Error("Expected", badId, "to be reported corrupt, got", report.Corrupt)
+	}
+
+	// Overwriting a corrupt record repairs it.
+	err = cdb.Update("foos", Foo{Bar: "fixed", Tags: []string{"b"}}, badId)
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	ids, err := cdb.FindAllIdsForField("foos", "bar", "fixed")
+	if err != nil || len(ids) != 1 || ids[0] != badId {
+		t.Error("Expected index to return", badId, "got", ids, err)
+	}
+
+	report, err = cdb.Verify("foos")
+	if err != nil || len(report.Corrupt) != 0 {
+		t.Error("Expected no corrupt records, got", report, err)
+	}
+}
+
+func TestChecksumsLegacyRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "foos", "1.json"), []byte(`{"bar":"legacy","tags":[]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "foos", "2.json"), []byte(`{"bar":`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"bar"}}, ivy.Options{Checksums: true})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer cdb.Close()
+
+	foo := Foo{}
+	err = cdb.Find("foos", &foo, "1")
+	if err != nil || foo.Bar != "legacy" {
+		t.Error("Expected to find 'legacy', got", foo.Bar, err)
+	}
+
+	err = cdb.Find("foos", &foo, "2")
+	if !errors.Is(err, ivy.ErrCorrupt) {
+		t.Error("Expected ErrCorrupt for invalid json, got", err)
+	}
+
+	report, err := cdb.Verify("foos")
+	if err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+	if report.Unchecked != 2 || len(report.Corrupt) != 1 || report.Corrupt[0] != "2" {
+		t.Error("Expected record 2 to be reported corrupt, got", report)
+	}
+}
@@ -0,0 +1,87 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	cdb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"docs": {"title"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	fileId, _ := cdb.Create("docs", Document{Title: "draft"})
+
+	cfg := cdb.Config()
+	if cfg.Version != 1 || cfg.Storage != "file" || cfg.Codec != "ivy.JSONCodec" || !reflect.DeepEqual(cfg.Indexes["docs"], []string{"title"}) {
+		t.Error("Expected the settings of the database, got", cfg)
+	}
+
+	cdb.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, ".ivy", "config.json")); err != nil {
+		t.Fatal("Expected the config file to be written, got", err)
+	}
+
+	mismatches := []ivy.Options{
+		{Storage: ivy.PackedStorage},
+		{Codec: &countingCodec{}},
+		{Layout: &ivy.DefaultLayout{Naming: map[string]ivy.FileNaming{"docs": {Pad: 6}}}},
+	}
+
+	for _, opts := range mismatches {
+		if _, err := ivy.OpenDBWithOptions(dir, nil, opts); !errors.Is(err, ivy.ErrConfigMismatch) {
+			t.Error("Expected ErrConfigMismatch, got", err)
+		}
+	}
+
+	// The index fields are read from the config file.
+	cdb, err = ivy.OpenDB(dir, ivy.WithReadOnly())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	if ids, err := cdb.FindAllIdsForField("docs", "title", "draft"); err != nil || !reflect.DeepEqual(ids, []string{fileId}) {
+		t.Error("Expected the table to be indexed as in the config file, got", ids, err)
+	}
+
+	cdb.Close()
+
+	cdb, err = ivy.OpenDB(dir, ivy.WithCodec(&countingCodec{}), ivy.WithReconfigure())
+	if err != nil {
+		t.Fatal("OpenDB with Reconfigure failed:", err)
+	}
+
+	if cfg := cdb.Config(); cfg.Codec != "*ivy.countingCodec" {
+		t.Error("Expected the codec to be replaced, got", cfg.Codec)
+	}
+
+	cdb.Close()
+
+	if _, err := ivy.OpenDB(dir); !errors.Is(err, ivy.ErrConfigMismatch) {
+		t.Error("Expected ErrConfigMismatch after reconfiguring, got", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, ".ivy", "config.json"), []byte(`{"version": 99}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ivy.OpenDB(dir); !errors.Is(err, ivy.ErrConfigMismatch) {
+		t.Error("Expected ErrConfigMismatch for a later format version, got", err)
+	}
+}
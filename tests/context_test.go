@@ -0,0 +1,149 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestContextCancelled(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := pdb.FindCtx(ctx, "planes", &Plane{}, "1")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected FindCtx to return context.Canceled, got %v", err)
+	}
+
+	_, err = pdb.FindAllIdsForFieldCtx(ctx, "planes", "name", "Zero")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected FindAllIdsForFieldCtx to return context.Canceled, got %v", err)
+	}
+
+	_, err = pdb.QueryStringCtx(ctx, "planes", "speed > 300")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected QueryStringCtx to return context.Canceled, got %v", err)
+	}
+
+	ids, _ := pdb.FindAllIds("planes")
+
+	var buf bytes.Buffer
+
+	err = pdb.StreamCtx(ctx, "planes", ids, &buf, ivy.StreamJSON)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected StreamCtx to return context.Canceled, got %v", err)
+	}
+
+	_, err = pdb.CreateCtx(ctx, "planes", &Plane{Name: "Hurricane", EngineType: "inline", Tags: []string{}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected CreateCtx to return context.Canceled, got %v", err)
+	}
+
+	err = pdb.DeleteCtx(ctx, "planes", "1")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected DeleteCtx to return context.Canceled, got %v", err)
+	}
+
+	after, _ := pdb.FindAllIds("planes")
+	if len(after) != len(ids) {
+		t.Errorf("Expected %d records after the cancelled writes, got %d", len(ids), len(after))
+	}
+}
+
+func TestReindexCtx(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	before, err := pdb.FindAllIdsForField("planes", "enginetype", "radial")
+	if err != nil {
+		t.Fatal("FindAllIdsForField failed:", err)
+	}
+
+	sort.Strings(before)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = pdb.ReindexCtx(ctx, "planes")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected ReindexCtx to return context.Canceled, got %v", err)
+	}
+
+	after, err := pdb.FindAllIdsForField("planes", "enginetype", "radial")
+	if err != nil {
+		t.Fatal("FindAllIdsForField failed:", err)
+	}
+	sort.Strings(after)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected the indexes to be kept, got %v instead of %v", after, before)
+	}
+
+	err = pdb.Reindex("planes")
+	if err != nil {
+		t.Fatal("Reindex failed:", err)
+	}
+
+	after, _ = pdb.FindAllIdsForField("planes", "enginetype", "radial")
+	sort.Strings(after)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected the rebuilt indexes to match, got %v instead of %v", after, before)
+	}
+}
+
+func TestContextCancelledScans(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := pdb.FindFirstIdForFieldCtx(ctx, "planes", "name", "Zero")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected FindFirstIdForFieldCtx to return context.Canceled, got %v", err)
+	}
+
+	_, err = pdb.Select("name").QueryCtx(ctx, "planes", "speed > 300")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Selection.QueryCtx to return context.Canceled, got %v", err)
+	}
+
+	_, err = pdb.SubscribeCtx(ctx, "planes", "speed > 300")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected SubscribeCtx to return context.Canceled, got %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	err = pdb.BackupCtx(ctx, &buf)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected BackupCtx to return context.Canceled, got %v", err)
+	}
+
+	// Feeds stay open until their context is done.
+	ctx, cancel = context.WithCancel(context.Background())
+
+	w, err := pdb.WatchCtx(ctx, "planes", ivy.WatchOptions{})
+	if err != nil {
+		t.Fatal("WatchCtx failed:", err)
+	}
+	defer w.Close()
+
+	cancel()
+
+	select {
+	case _, ok := <-w.C:
+		if ok {
+			t.Error("Expected no event from the watcher")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the watcher to be closed once its context is done")
+	}
+}
@@ -0,0 +1,86 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCopyTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "docs"), 0700)
+
+	fieldsToIndex := map[string][]string{"docs": {"title"}}
+
+	sdb, err := ivy.OpenDB(src, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer sdb.Close()
+
+	var ids []string
+	for _, title := range []string{"a", "b", "c", "d"} {
+		fileId, err := sdb.Create("docs", Document{Title: title})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		ids = append(ids, fileId)
+	}
+
+	if err := sdb.Delete("docs", ids[1]); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+
+	if err := sdb.CopyTo(dst, ivy.Options{Storage: ivy.PackedStorage, Checksums: true}); err != nil {
+		t.Fatal("CopyTo failed:", err)
+	}
+
+	if err := sdb.CopyTo(dst, ivy.Options{}); !errors.Is(err, ivy.ErrConflict) {
+		t.Error("Expected ErrConflict copying to a directory that is not empty, got", err)
+	}
+
+	if err := sdb.CopyTo(src, ivy.Options{}); !errors.Is(err, ivy.ErrConflict) {
+		t.Error("Expected ErrConflict copying the database onto itself, got", err)
+	}
+
+	// The source stays usable after the copy.
+	if err := sdb.Update("docs", Document{Title: "changed"}, ids[0]); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	cdb, err := ivy.OpenDB(dst, ivy.WithIndexes(fieldsToIndex), ivy.WithStorage(ivy.PackedStorage), ivy.WithChecksums())
+	if err != nil {
+		t.Fatal("OpenDB of the copy failed:", err)
+	}
+	defer cdb.Close()
+
+	want := []string{ids[0], ids[2], ids[3]}
+	if got, err := cdb.FindAllIds("docs"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Error("Expected the copy to hold the ids", want, "got", got, err)
+	}
+
+	doc := Document{}
+	if err := cdb.Find("docs", &doc, ids[0]); err != nil || doc.Title != "a" {
+		t.Error("Expected the copy to hold the record as it was, got", doc, err)
+	}
+
+	if got, err := cdb.FindAllIdsForField("docs", "title", "c"); err != nil || !reflect.DeepEqual(got, []string{ids[2]}) {
+		t.Error("Expected the copy to be indexed, got", got, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "docs.ivy")); err != nil {
+		t.Error("Expected the copy to use packed storage, got", err)
+	}
+}
@@ -0,0 +1,305 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type Customer struct {
+	Name string `json:"name"`
+	SSN  string `json:"ssn"`
+}
+
+func (c *Customer) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	for _, storage := range []ivy.Storage{ivy.FileStorage, ivy.PackedStorage} {
+		dir, err := ioutil.TempDir("", "ivy-crypt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if storage == ivy.FileStorage {
+			os.Mkdir(filepath.Join(dir, "planes"), 0700)
+		}
+
+		open := func(options ...ivy.Option) (*ivy.DB, error) {
+			return ivy.OpenDB(dir, append([]ivy.Option{
+				ivy.WithOptions(ivy.Options{Storage: storage, PersistentIndexes: true, WAL: &ivy.WALOptions{KeepSegments: true}}),
+				ivy.WithIndexes(map[string][]string{"planes": {"name"}}),
+			}, options...)...)
+		}
+
+		edb, err := open(ivy.WithEncryptionKey(key))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+
+		id, err := edb.Create("planes", Plane{Name: "Spitfire", Tags: []string{"secret"}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+
+		err = edb.Close()
+		if err != nil {
+			t.Fatal("Close failed:", err)
+		}
+
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			data, _ := ioutil.ReadFile(path)
+			if bytes.Contains(data, []byte("Spitfire")) || bytes.Contains(data, []byte("secret")) {
+				t.Error("Expected", path, "to be encrypted")
+			}
+			return nil
+		})
+
+		edb, err = open(ivy.WithEncryptionKey(key))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+
+		plane := Plane{}
+		if err := edb.Find("planes", &plane, id); err != nil || plane.Name != "Spitfire" {
+			t.Error("Expected to read the encrypted record, got", plane.Name, err)
+		}
+
+		ids, err := edb.FindAllIdsForField("planes", "name", "Spitfire")
+		if err != nil || len(ids) != 1 || ids[0] != id {
+			t.Error("Expected the encrypted index to find", id, "got", ids, err)
+		}
+
+		edb.Close()
+
+		_, err = open(ivy.WithEncryptionKey(bytes.Repeat([]byte{8}, 32)))
+		if !errors.Is(err, ivy.ErrBadKey) {
+			t.Error("Expected OpenDB with the wrong key to fail with ErrBadKey, got", err)
+		}
+
+		_, err = open()
+		if !errors.Is(err, ivy.ErrBadKey) {
+			t.Error("Expected OpenDB without a key to fail with ErrBadKey, got", err)
+		}
+	}
+
+	_, err := ivy.OpenDBWithOptions("", nil, ivy.Options{Storage: ivy.MemoryStorage, EncryptionKey: key})
+	if err == nil {
+		t.Error("Expected an encrypted memory database to fail")
+	}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-fieldcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "customers"), 0700)
+
+	key := bytes.Repeat([]byte{7}, 32)
+
+	fdb, err := ivy.OpenDB(dir, ivy.WithEncryptedFields("customers", "ssn"), ivy.WithFieldKey(key))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	id, err := fdb.Create("customers", Customer{Name: "Ada", SSN: "123-45-6789"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "customers", id+".json"))
+	if !bytes.Contains(data, []byte("Ada")) || bytes.Contains(data, []byte("123-45-6789")) {
+		t.Error("Expected only the ssn to be encrypted, got", string(data))
+	}
+
+	customer := Customer{}
+	if err := fdb.Find("customers", &customer, id); err != nil || customer.SSN != "123-45-6789" {
+		t.Error("Expected Find to decrypt the ssn, got", customer.SSN, err)
+	}
+
+	recs, err := fdb.Select("ssn").FindMany("customers", []string{id})
+	if err != nil || len(recs) != 1 || recs[0]["ssn"] != "123-45-6789" {
+		t.Error("Expected Select to decrypt the ssn, got", recs, err)
+	}
+
+	fdb.Close()
+
+	fdb, err = ivy.OpenDB(dir)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	customer = Customer{}
+	if err := fdb.Find("customers", &customer, id); err != nil || !strings.HasPrefix(customer.SSN, "ivyenc:") {
+		t.Error("Expected Find without the key to read the encrypted ssn, got", customer.SSN, err)
+	}
+
+	fdb.Close()
+
+	fdb, err = ivy.OpenDB(dir, ivy.WithEncryptedFields("customers", "ssn"), ivy.WithFieldKey(bytes.Repeat([]byte{8}, 32)))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer fdb.Close()
+
+	err = fdb.Find("customers", &customer, id)
+	if !errors.Is(err, ivy.ErrBadKey) {
+		t.Error("Expected Find with the wrong key to fail with ErrBadKey, got", err)
+	}
+}
+
+func TestShred(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-shred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "customers"), 0700)
+
+	options := []ivy.Option{
+		ivy.WithEncryptedFields("customers", "ssn"),
+		ivy.WithFieldKey(bytes.Repeat([]byte{7}, 32)),
+		ivy.WithRecordKeys("customers"),
+	}
+
+	sdb, err := ivy.OpenDB(dir, options...)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	ada, err := sdb.Create("customers", Customer{Name: "Ada", SSN: "123-45-6789"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	bob, err := sdb.Create("customers", Customer{Name: "Bob", SSN: "987-65-4321"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	var backup bytes.Buffer
+	if err := sdb.Backup(&backup); err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	if err := sdb.Shred("customers", ada); err != nil {
+		t.Fatal("Shred failed:", err)
+	}
+
+	customer := Customer{}
+	if err := sdb.Find("customers", &customer, ada); err != nil || customer.Name != "Ada" || customer.SSN != "" {
+		t.Error("Expected the shredded ssn to read as null, got", customer, err)
+	}
+
+	customer = Customer{}
+	if err := sdb.Find("customers", &customer, bob); err != nil || customer.SSN != "987-65-4321" {
+		t.Error("Expected the other ssn to survive, got", customer.SSN, err)
+	}
+
+	// The copy in the backup cannot be decrypted either.
+	err = sdb.Restore(&backup, ivy.RestoreOptions{Table: "customers", Id: ada})
+	if err != nil {
+		t.Fatal("Restore failed:", err)
+	}
+
+	customer = Customer{}
+	if err := sdb.Find("customers", &customer, ada); err != nil || customer.SSN != "" {
+		t.Error("Expected the restored ssn to stay shredded, got", customer.SSN, err)
+	}
+
+	sdb.Close()
+
+	sdb, err = ivy.OpenDB(dir, options...)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer sdb.Close()
+
+	customer = Customer{}
+	if err := sdb.Find("customers", &customer, bob); err != nil || customer.SSN != "987-65-4321" {
+		t.Error("Expected the data key to survive reopening, got", customer.SSN, err)
+	}
+
+	customer = Customer{}
+	if err := sdb.Find("customers", &customer, ada); err != nil || customer.SSN != "" {
+		t.Error("Expected the ssn to stay shredded after reopening, got", customer.SSN, err)
+	}
+}
+
+func TestRecordKeysFollowRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-recordkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "customers"), 0700)
+
+	rdb, err := ivy.OpenDB(dir,
+		ivy.WithEncryptedFields("customers", "ssn"),
+		ivy.WithFieldKey(bytes.Repeat([]byte{7}, 32)),
+		ivy.WithRecordKeys("customers"),
+	)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	ada, _ := rdb.Create("customers", Customer{Name: "Ada", SSN: "123-45-6789"})
+
+	copyId, err := rdb.DuplicateWithOverrides("customers", ada, map[string]interface{}{"name": "Ada II"})
+	if err != nil {
+		t.Fatal("DuplicateWithOverrides failed:", err)
+	}
+
+	customer := Customer{}
+	if err := rdb.Find("customers", &customer, copyId); err != nil || customer.SSN != "123-45-6789" {
+		t.Error("Expected the copy to keep the ssn, got", customer, err)
+	}
+
+	// The copy has a data key of its own.
+	if err := rdb.Shred("customers", ada); err != nil {
+		t.Fatal("Shred failed:", err)
+	}
+
+	customer = Customer{}
+	if err := rdb.Find("customers", &customer, copyId); err != nil || customer.SSN != "123-45-6789" {
+		t.Error("Expected the copy to survive shredding the original, got", customer, err)
+	}
+
+	keyPath := filepath.Join(dir, ".ivy", "keys", "customers", copyId+".key")
+
+	if err := rdb.Delete("customers", copyId); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Error("Expected Delete to destroy the data key, got", err)
+	}
+
+	newId, _ := rdb.Create("customers", Customer{Name: "Cy", SSN: "555-55-5555"})
+	if newId != copyId {
+		t.Fatal("Expected the id to be reused, got", newId)
+	}
+
+	customer = Customer{}
+	if err := rdb.Find("customers", &customer, newId); err != nil || customer.SSN != "555-55-5555" {
+		t.Error("Expected the new record to read its ssn, got", customer, err)
+	}
+}
@@ -0,0 +1,117 @@
+package ivy
+
+import (
+	"bytes"
+	"github.com/jameycribbs/ivy"
+	"strings"
+	"testing"
+)
+
+type Contact struct {
+	Name    string            `json:"name"`
+	Age     int               `json:"age"`
+	Address map[string]string `json:"address,omitempty"`
+	Tags    []string          `json:"tags"`
+}
+
+func (c *Contact) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestExportCSV(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"contacts": {"tags", "name"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	db.Create("contacts", Contact{Name: "Ann", Age: 30, Address: map[string]string{"city": "Oslo"}, Tags: []string{"a", "b"}})
+	db.Create("contacts", Contact{Name: "Bob, Jr.", Age: 41, Tags: []string{}})
+
+	var buf bytes.Buffer
+
+	err = db.ExportCSV("contacts", &buf)
+	if err != nil {
+		t.Fatal("ExportCSV failed:", err)
+	}
+
+	want := "id,address.city,age,name,tags\n1,Oslo,30,Ann,a;b\n2,,41,\"Bob, Jr.\",\n"
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	// Selected fields of query results, without a header.
+	ids, err := db.FindAllIdsForField("contacts", "name", "Bob, Jr.")
+	if err != nil {
+		t.Fatal("FindAllIdsForField failed:", err)
+	}
+
+	buf.Reset()
+
+	err = db.ExportCSVWithOptions("contacts", &buf, ivy.CSVOptions{Fields: []string{"age", "name"}, Ids: ids, NoHeader: true})
+	if err != nil {
+		t.Fatal("ExportCSVWithOptions failed:", err)
+	}
+
+	want = "2,41,\"Bob, Jr.\"\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"contacts": {"tags", "name"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	input := "Full Name,Years,City,Labels,Ignored\n" +
+		"Ann,30,Oslo,\"a, b\",x\n" +
+		"Bob,forty,Bergen,,x\n" +
+		"Cat,,,c,x\n" +
+		"Dan,1\n"
+
+	mapping := ivy.CSVMapping{
+		"Full Name": {Field: "name"},
+		"Years":     {Field: "age", Type: ivy.CSVInt},
+		"City":      {Field: "address.city"},
+		"Labels":    {Field: "tags", Type: ivy.CSVTags},
+	}
+
+	report, err := db.ImportCSV("contacts", strings.NewReader(input), mapping)
+	if err != nil {
+		t.Fatal("ImportCSV failed:", err)
+	}
+
+	if len(report.Ids) != 2 || report.Ids[0] != "1" || report.Ids[1] != "2" {
+		t.Error("Expected records 1 and 2 to be created, got", report.Ids)
+	}
+
+	if len(report.Errors) != 2 || report.Errors[0].Row != 3 || report.Errors[1].Row != 5 {
+		t.Error("Expected errors for rows 3 and 5, got", report.Errors)
+	}
+
+	contact := Contact{}
+	err = db.Find("contacts", &contact, "1")
+	if err != nil || contact.Name != "Ann" || contact.Age != 30 || contact.Address["city"] != "Oslo" ||
+		len(contact.Tags) != 2 || contact.Tags[1] != "b" {
+		t.Errorf("Expected Ann to be imported, got %+v (%v)", contact, err)
+	}
+
+	ids, err := db.FindAllIdsForTags("contacts", []string{"c"})
+	if err != nil || len(ids) != 1 || ids[0] != "2" {
+		t.Error("Expected Cat to be indexed by tag, got", ids, err)
+	}
+
+	// An export can be imported again.
+	var buf bytes.Buffer
+	db.ExportCSV("contacts", &buf, "name", "tags")
+
+	report, err = db.ImportCSV("contacts", &buf, ivy.CSVMapping{
+		"name": {Field: "name"},
+		"tags": {Field: "tags", Type: ivy.CSVTags},
+	})
+	if err != nil || len(report.Ids) != 2 || len(report.Errors) != 0 {
+		t.Error("Expected 2 records imported from export, got", report, err)
+	}
+}
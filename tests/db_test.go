@@ -1,6 +1,7 @@
 package ivy
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jameycribbs/ivy"
 	"io/ioutil"
@@ -26,7 +27,7 @@ func init() {
 	fieldsToIndex := make(map[string][]string)
 	fieldsToIndex["foos"] = []string{"tags", "bar"}
 
-	db, err = ivy.OpenDB("data", fieldsToIndex)
+	db, err = ivy.OpenDB("data", ivy.WithIndexes(fieldsToIndex))
 	if err != nil {
 		fmt.Println("Failed to open database:", err)
 		os.Exit(1)
@@ -107,8 +108,8 @@ func TestDelete(t *testing.T) {
 
 	err = db.Find("foos", &foo, id)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			t.Error("Expected Find error to be 'file does not exist', got ", err)
+		if !errors.Is(err, ivy.ErrNotFound) {
+			t.Error("Expected Find error to be ErrNotFound, got ", err)
 		}
 	} else {
 		t.Error("Expected Find error, got no error.")
@@ -116,6 +117,47 @@ func TestDelete(t *testing.T) {
 
 }
 
+func TestDuplicate(t *testing.T) {
+	id, err := db.Create("foos", Foo{Bar: "template", Tags: []string{"plane"}})
+	if err != nil {
+		t.Error("Create failed:", err)
+	}
+	defer db.Delete("foos", id)
+
+	copyId, err := db.Duplicate("foos", id)
+	if err != nil {
+		t.Error("Duplicate failed:", err)
+	}
+	defer db.Delete("foos", copyId)
+
+	foo := Foo{}
+
+	err = db.Find("foos", &foo, copyId)
+	if err != nil {
+		t.Error("Find failed:", err)
+	}
+
+	if copyId == id || foo.Bar != "template" || foo.Tags[0] != "plane" {
+		t.Error("Expected a copy of", id, "got", copyId, foo)
+	}
+
+	overrideId, err := db.DuplicateWithOverrides("foos", id, map[string]interface{}{"bar": "copy", "tags": []string{"copy"}})
+	if err != nil {
+		t.Error("DuplicateWithOverrides failed:", err)
+	}
+	defer db.Delete("foos", overrideId)
+
+	ids, err := db.FindAllIdsForField("foos", "bar", "copy")
+	if err != nil || len(ids) != 1 || ids[0] != overrideId {
+		t.Error("Expected the copy to be indexed with its new value, got", ids, err)
+	}
+
+	_, err = db.Duplicate("foos", "9999")
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected Duplicate error to be ErrNotFound, got ", err)
+	}
+}
+
 //=============================================================================
 // Setup Stuff
 //=============================================================================
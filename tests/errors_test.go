@@ -0,0 +1,49 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/fs"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	var plane Plane
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Find of a missing record", pdb.Find("planes", &plane, "99"), ivy.ErrNotFound},
+		{"Delete of a missing record", pdb.Delete("planes", "99"), ivy.ErrNotFound},
+		{"Find in a missing table", pdb.Find("trains", &plane, "1"), ivy.ErrTableNotFound},
+		{"Update in a missing table", pdb.Update("trains", &plane, "1"), ivy.ErrTableNotFound},
+		{"Find of an invalid id", pdb.Find("planes", &plane, "abc"), ivy.ErrInvalidID},
+		{"Update of an invalid id", pdb.Update("planes", &plane, "abc"), ivy.ErrInvalidID},
+		{"Delete of an invalid id", pdb.Delete("planes", "1.5"), ivy.ErrInvalidID},
+		{"CopyTable to an existing table", pdb.CopyTable("planes", "planes"), ivy.ErrConflict},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, test.err)
+		}
+	}
+
+	_, err := pdb.QueryString("trains", "speed > 1")
+	if !errors.Is(err, ivy.ErrTableNotFound) {
+		t.Error("Expected QueryString in a missing table to return ErrTableNotFound, got", err)
+	}
+
+	err = pdb.Find("planes", &plane, "99")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Expected a missing record to satisfy fs.ErrNotExist, got", err)
+	}
+	if err.Error() != "ivy: record not found: planes/99" {
+		t.Errorf("Unexpected error message %q", err)
+	}
+}
@@ -0,0 +1,50 @@
+package ivy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"testing"
+)
+
+func TestEventTables(t *testing.T) {
+	edb, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(map[string][]string{"events": nil}),
+		ivy.WithEventTable("events"))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer edb.Close()
+
+	for _, text := range []string{"opened", "deposited", "withdrew"} {
+		if _, err := edb.Create("events", Note{Text: text, Tags: []string{}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	if err := edb.Update("events", Note{Text: "closed", Tags: []string{}}, "2"); !errors.Is(err, ivy.ErrImmutable) {
+		t.Errorf("Expected Update to fail with ErrImmutable, got %v", err)
+	}
+
+	if err := edb.Update("events", Note{Text: "closed", Tags: []string{}}, "9"); !errors.Is(err, ivy.ErrImmutable) {
+		t.Errorf("Expected Update of a new id to fail with ErrImmutable, got %v", err)
+	}
+
+	if err := edb.Delete("events", "3"); !errors.Is(err, ivy.ErrImmutable) {
+		t.Errorf("Expected Delete to fail with ErrImmutable, got %v", err)
+	}
+
+	events, err := edb.EventsSince("events", 1, 0)
+	if err != nil || len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+		t.Fatalf("Unexpected events %+v %v", events, err)
+	}
+
+	var note Note
+	if err := json.Unmarshal(events[1].Data, &note); err != nil || note.Text != "withdrew" {
+		t.Errorf("Unexpected event data %s", events[1].Data)
+	}
+
+	events, err = edb.EventsSince("events", 0, 1)
+	if err != nil || len(events) != 1 || events[0].Seq != 1 {
+		t.Errorf("Unexpected limited events %+v %v", events, err)
+	}
+}
@@ -0,0 +1,56 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"planes": {"enginetype"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	db.Create("planes", Plane{Name: "Spitfire", EngineType: "piston", Speed: 370, Tags: []string{}})
+	db.Create("planes", Plane{Name: "Mustang", EngineType: "piston", Speed: 440, Tags: []string{}})
+	db.Create("planes", Plane{Name: "Meteor", EngineType: "jet", Speed: 600, Tags: []string{}})
+
+	plans := []struct {
+		query string
+		plan  ivy.QueryPlan
+	}{
+		{"enginetype = 'piston'",
+			ivy.QueryPlan{Index: "field", Field: "enginetype", Scanned: 2, Records: 3}},
+		{"speed > 400 AND enginetype = 'jet' ORDER BY speed DESC, name",
+			ivy.QueryPlan{Index: "field", Field: "enginetype", Scanned: 1, Records: 3, Sort: true, OrderBy: []string{"speed DESC", "name"}}},
+		{"enginetype = 'piston' OR speed > 500",
+			ivy.QueryPlan{Index: "none", Scanned: 3, Records: 3}},
+		{"name = 'Meteor' LIMIT 1",
+			ivy.QueryPlan{Index: "none", Scanned: 3, Records: 3}},
+	}
+
+	for _, p := range plans {
+		plan, err := db.Explain("planes", p.query)
+		if err != nil {
+			t.Fatal("Explain failed:", err)
+		}
+
+		if !reflect.DeepEqual(*plan, p.plan) {
+			t.Errorf("%s: expected %+v, got %+v", p.query, p.plan, *plan)
+		}
+	}
+
+	if _, err := db.Explain("planes", "speed >"); err == nil {
+		t.Error("Expected an invalid query to fail")
+	}
+
+	if _, err := db.Explain("ships", "name = 'x'"); err == nil {
+		t.Error("Expected a missing table to fail")
+	}
+
+	if m := db.Metrics().Tables["planes"]; m.IndexHits != 0 || m.Scans != 0 {
+		t.Errorf("Expected Explain not to count as queries, got %+v", m)
+	}
+}
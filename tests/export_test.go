@@ -0,0 +1,186 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"strings"
+	"testing"
+)
+
+type Author struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func (a *Author) AfterFind(db *ivy.DB, fileId string) {
+}
+
+type Post struct {
+	Title    string   `json:"title"`
+	AuthorId string   `json:"author_id"`
+	ReplyTo  int      `json:"reply_to"`
+	Tags     []string `json:"tags"`
+}
+
+func (p *Post) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestExportImportTable(t *testing.T) {
+	fieldsToIndex := map[string][]string{"authors": {"tags"}, "posts": {"tags"}}
+
+	src, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer src.Close()
+
+	dst, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer dst.Close()
+
+	src.Create("authors", Author{Name: "ann", Tags: []string{}})
+	src.Create("posts", Post{Title: "hello", AuthorId: "1", Tags: []string{}})
+	src.Create("posts", Post{Title: "re: hello", AuthorId: "1", ReplyTo: 1, Tags: []string{}})
+
+	// The destination already uses ids 1 and 2.
+	dst.Create("authors", Author{Name: "bob", Tags: []string{}})
+	dst.Create("authors", Author{Name: "cat", Tags: []string{}})
+	dst.Create("posts", Post{Title: "existing", AuthorId: "2", Tags: []string{}})
+
+	var authors, posts bytes.Buffer
+
+	if err := src.ExportTable("authors", &authors); err != nil {
+		t.Fatal("ExportTable failed:", err)
+	}
+	if err := src.ExportTable("posts", &posts); err != nil {
+		t.Fatal("ExportTable failed:", err)
+	}
+
+	if lines := strings.Count(posts.String(), "\n"); lines != 2 {
+		t.Error("Expected 2 lines in export, got", lines)
+	}
+
+	var decoded map[string]interface{}
+	json.Unmarshal([]byte(strings.SplitN(posts.String(), "\n", 2)[0]), &decoded)
+	if decoded["id"] != "1" {
+		t.Error("Expected export lines to hold the record id, got", decoded)
+	}
+
+	authorIds, err := dst.ImportTable("authors", &authors, ivy.ImportOptions{})
+	if err != nil {
+		t.Fatal("ImportTable failed:", err)
+	}
+	if authorIds["1"] != "3" {
+		t.Error("Expected author 1 to be remapped to 3, got", authorIds)
+	}
+
+	postIds, err := dst.ImportTable("posts", &posts, ivy.ImportOptions{
+		ForeignKeys: map[string]string{"author_id": "authors", "reply_to": "posts"},
+		IdMaps:      map[string]map[string]string{"authors": authorIds},
+	})
+	if err != nil {
+		t.Fatal("ImportTable failed:", err)
+	}
+	if postIds["1"] != "3" || postIds["2"] != "2" {
+		t.Error("Expected post 1 remapped to 3 and post 2 kept, got", postIds)
+	}
+
+	post := Post{}
+	if err := dst.Find("posts", &post, "2"); err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if post.Title != "re: hello" || post.AuthorId != "3" || post.ReplyTo != 3 {
+		t.Errorf("Expected foreign keys to be rewritten, got %+v", post)
+	}
+
+	// Existing records are untouched.
+	if err := dst.Find("posts", &post, "1"); err != nil || post.Title != "existing" {
+		t.Error("Expected existing post to be kept, got", post.Title, err)
+	}
+
+	// Skipping collisions imports nothing that is taken.
+	var again bytes.Buffer
+	src.ExportTable("authors", &again)
+
+	ids, err := dst.ImportTable("authors", &again, ivy.ImportOptions{OnCollision: ivy.SkipOnCollision})
+	if err != nil || len(ids) != 0 {
+		t.Error("Expected no records imported, got", ids, err)
+	}
+
+	// Bad input is rejected.
+	_, err = dst.ImportTable("authors", strings.NewReader(`{"id":"x","data":{}}`), ivy.ImportOptions{})
+	if err == nil {
+		t.Error("Expected an error for an invalid id")
+	}
+}
+
+func TestExportImportJSON(t *testing.T) {
+	fieldsToIndex := map[string][]string{"authors": {"tags"}, "posts": {"tags"}}
+
+	src, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer src.Close()
+
+	src.Create("authors", Author{Name: "ann", Tags: []string{"a"}})
+	src.Create("posts", Post{Title: "hello", AuthorId: "1", Tags: []string{"p"}})
+	src.Create("posts", Post{Title: "bye", AuthorId: "1", Tags: []string{"p"}})
+
+	var buf bytes.Buffer
+
+	err = src.ExportJSON(&buf)
+	if err != nil {
+		t.Fatal("ExportJSON failed:", err)
+	}
+
+	want := `{
+  "authors": {
+    "1": {"name":"ann","tags":["a"]}
+  },
+  "posts": {
+    "1": {"title":"hello","author_id":"1","reply_to":0,"tags":["p"]},
+    "2": {"title":"bye","author_id":"1","reply_to":0,"tags":["p"]}
+  }
+}
+`
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	var all map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &all); err != nil {
+		t.Error("Expected export to be valid JSON:", err)
+	}
+
+	dst, err := ivy.OpenMemDB(fieldsToIndex)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer dst.Close()
+
+	err = dst.ImportJSON(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("ImportJSON failed:", err)
+	}
+
+	ids, err := dst.FindAllIdsForTags("posts", []string{"p"})
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected 2 imported posts, got", ids, err)
+	}
+
+	var again bytes.Buffer
+	dst.ExportJSON(&again)
+
+	if again.String() != want {
+		t.Errorf("Expected export of import to match, got\n%s", again.String())
+	}
+
+	err = dst.ImportJSON(strings.NewReader(`{"missing": {}}`))
+	if err == nil {
+		t.Error("Expected an error importing into a missing table")
+	}
+}
@@ -0,0 +1,307 @@
+package ivy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindAllIdsForFieldTypes(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"things": nil}
+		if indexed {
+			fieldsToIndex["things"] = []string{"value", "tags"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, rec := range []string{
+			`{"value": "437", "tags": []}`,
+			`{"value": 437, "tags": [1, true]}`,
+			`{"value": true, "tags": []}`,
+			`{"value": null, "tags": []}`,
+			`{"tags": []}`,
+		} {
+			if _, err := tdb.Create("things", json.RawMessage(rec)); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		tests := []struct {
+			value string
+			ids   []string
+		}{
+			{"437", []string{"1", "2"}},
+			{"437.0", []string{"1", "2"}},
+			{"true", []string{"3"}},
+			{"", nil},
+			{"null", nil},
+		}
+
+		for _, test := range tests {
+			ids, err := tdb.FindAllIdsForField("things", "value", test.value)
+			if err != nil {
+				t.Errorf("indexed %v, %q: FindAllIdsForField failed: %v", indexed, test.value, err)
+				continue
+			}
+
+			sort.Strings(ids)
+			if len(ids) == 0 {
+				ids = nil
+			}
+
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("indexed %v, %q: expected %v, got %v", indexed, test.value, test.ids, ids)
+			}
+		}
+
+		_, err = tdb.FindFirstIdForField("things", "value", "missing")
+		if !errors.Is(err, ivy.ErrNotFound) {
+			t.Errorf("indexed %v: expected FindFirstIdForField to return ErrNotFound, got %v", indexed, err)
+		}
+
+		if indexed {
+			ids, _ := tdb.FindAllIdsForTags("things", []string{"1", "true"})
+			if !reflect.DeepEqual(ids, []string{"2"}) {
+				t.Errorf("Expected non-string tags to be indexed, got %v", ids)
+			}
+		}
+
+		if _, err := tdb.Create("things", json.RawMessage(`{"value": [1, 2], "tags": []}`)); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+
+		var typeErr *ivy.FieldTypeError
+
+		_, err = tdb.FindAllIdsForField("things", "value", "1")
+		if !indexed && (!errors.As(err, &typeErr) || typeErr.Id != "6" || !errors.Is(err, ivy.ErrIncomparable)) {
+			t.Errorf("Expected a FieldTypeError for an array, got %v", err)
+		}
+
+		tdb.Close()
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	for _, useNumber := range []bool{false, true} {
+		for _, indexed := range []bool{false, true} {
+			options := []ivy.Option{ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(map[string][]string{"things": nil})}
+			if indexed {
+				options = append(options, ivy.WithIndexes(map[string][]string{"things": {"ext_id"}}))
+			}
+			if useNumber {
+				options = append(options, ivy.WithUseNumber())
+			}
+
+			tdb, err := ivy.OpenDB("", options...)
+			if err != nil {
+				t.Fatal("OpenDB failed:", err)
+			}
+
+			for _, rec := range []string{
+				`{"ext_id": 9007199254740993, "tags": []}`,
+				`{"ext_id": 9007199254740992, "tags": []}`,
+				`{"ext_id": 7, "tags": []}`,
+			} {
+				if _, err := tdb.Create("things", json.RawMessage(rec)); err != nil {
+					t.Fatal("Create failed:", err)
+				}
+			}
+
+			want := []string{"1", "2"}
+			if useNumber {
+				want = []string{"1"}
+			}
+
+			ids, err := tdb.FindAllIdsForField("things", "ext_id", "9007199254740993")
+			sort.Strings(ids)
+			if err != nil || !reflect.DeepEqual(ids, want) {
+				t.Errorf("UseNumber %v, indexed %v: expected FindAllIdsForField to return %v, got %v %v", useNumber, indexed, want, ids, err)
+			}
+
+			ids, err = tdb.QueryString("things", "ext_id = 9007199254740993")
+			if err != nil || !reflect.DeepEqual(ids, want) {
+				t.Errorf("UseNumber %v, indexed %v: expected the query to return %v, got %v %v", useNumber, indexed, want, ids, err)
+			}
+
+			ids, err = tdb.QueryString("things", "ext_id = 7.0")
+			if err != nil || !reflect.DeepEqual(ids, []string{"3"}) {
+				t.Errorf("UseNumber %v, indexed %v: expected 7.0 to match 7, got %v %v", useNumber, indexed, ids, err)
+			}
+
+			if useNumber {
+				ids, err = tdb.QueryString("things", "ext_id > 9007199254740992 ORDER BY ext_id DESC")
+				if err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+					t.Errorf("indexed %v: expected an exact comparison, got %v %v", indexed, ids, err)
+				}
+			}
+
+			tdb.Close()
+		}
+	}
+}
+
+func TestNestedFields(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"planes": nil}
+		if indexed {
+			fieldsToIndex["planes"] = []string{"engine.type", "engine.hp"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, rec := range []string{
+			`{"name": "Corsair", "engine": {"type": "radial", "hp": 2000}, "tags": []}`,
+			`{"name": "Spitfire", "engine": {"type": "inline", "hp": 1470}, "tags": []}`,
+			`{"name": "Glider", "engine": null, "tags": []}`,
+		} {
+			if _, err := tdb.Create("planes", json.RawMessage(rec)); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		ids, err := tdb.FindAllIdsForField("planes", "engine.type", "radial")
+		if err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("indexed %v: expected engine.type radial to find 1, got %v %v", indexed, ids, err)
+		}
+
+		ids, err = tdb.FindAllIdsForField("planes", "engine.hp", "1470")
+		if err != nil || !reflect.DeepEqual(ids, []string{"2"}) {
+			t.Errorf("indexed %v: expected engine.hp 1470 to find 2, got %v %v", indexed, ids, err)
+		}
+
+		err = tdb.Update("planes", json.RawMessage(`{"name": "Corsair", "engine": {"type": "jet", "hp": 0}, "tags": []}`), "1")
+		if err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		ids, _ = tdb.FindAllIdsForField("planes", "engine.type", "radial")
+		if len(ids) != 0 {
+			t.Errorf("indexed %v: expected the update to change the index, got %v", indexed, ids)
+		}
+
+		plan, err := tdb.Explain("planes", "engine.type = 'jet'")
+		if err != nil || (plan.Index == "field") != indexed {
+			t.Errorf("indexed %v: unexpected plan %v %v", indexed, plan, err)
+		}
+
+		ids, _ = tdb.QueryString("planes", "engine.type = 'jet'")
+		if !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("indexed %v: expected the query to find 1, got %v", indexed, ids)
+		}
+
+		tdb.Close()
+	}
+}
+
+func TestFindAllIdsForFieldContains(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"planes": nil}
+		if indexed {
+			fieldsToIndex["planes"] = []string{"aliases[]", "crew[]"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, rec := range []string{
+			`{"name": "F6F", "aliases": ["Hellcat", "Hellion"], "crew": [1], "tags": []}`,
+			`{"name": "F4U", "aliases": ["Corsair", "Whistling Death"], "crew": [1, 2], "tags": []}`,
+			`{"name": "Glider", "aliases": [], "tags": []}`,
+		} {
+			if _, err := tdb.Create("planes", json.RawMessage(rec)); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		tests := []struct {
+			field string
+			value string
+			ids   []string
+		}{
+			{"aliases", "Hellcat", []string{"1"}},
+			{"aliases", "Whistling Death", []string{"2"}},
+			{"aliases", "F6F", nil},
+			{"crew", "2.0", []string{"2"}},
+		}
+
+		for _, test := range tests {
+			ids, err := tdb.FindAllIdsForFieldContains("planes", test.field, test.value)
+			sort.Strings(ids)
+			if len(ids) == 0 {
+				ids = nil
+			}
+
+			if err != nil || !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("indexed %v, %s %q: expected %v, got %v %v", indexed, test.field, test.value, test.ids, ids, err)
+			}
+		}
+
+		err = tdb.Update("planes", json.RawMessage(`{"name": "F6F", "aliases": ["Hellcat"], "tags": []}`), "1")
+		if err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		ids, _ := tdb.FindAllIdsForFieldContains("planes", "crew", "1")
+		if !reflect.DeepEqual(ids, []string{"2"}) {
+			t.Errorf("indexed %v: expected the update to change the index, got %v", indexed, ids)
+		}
+
+		_, err = tdb.FindAllIdsForFieldContains("planes", "name", "F6F")
+		if !indexed && !errors.Is(err, ivy.ErrIncomparable) {
+			t.Errorf("Expected a FieldTypeError for a string field, got %v", err)
+		}
+
+		tdb.Close()
+	}
+}
+
+func TestFindAllIdsWhereMissing(t *testing.T) {
+	tdb, err := ivy.OpenMemDB(map[string][]string{"planes": {"maker.country"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer tdb.Close()
+
+	for _, rec := range []string{
+		`{"name": "F6F", "maker": {"country": "US"}, "tags": []}`,
+		`{"name": "", "maker": {"country": null}, "tags": []}`,
+		`{"maker": {}, "tags": []}`,
+		`{"name": "Zero", "maker": {"country": ""}, "tags": []}`,
+		`{"name": "Spitfire", "maker": "Supermarine", "tags": []}`,
+	} {
+		if _, err := tdb.Create("planes", json.RawMessage(rec)); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	tests := []struct {
+		field string
+		ids   []string
+	}{
+		{"name", []string{"2", "3"}},
+		{"maker", []string{"3"}},
+		{"maker.country", []string{"2", "3", "4", "5"}},
+		{"tags", []string{"1", "2", "3", "4", "5"}},
+	}
+
+	for _, test := range tests {
+		ids, err := tdb.FindAllIdsWhereMissing("planes", test.field)
+		sort.Strings(ids)
+
+		if err != nil || !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%s: expected %v, got %v %v", test.field, test.ids, ids, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	wdb, err := ivy.OpenDB(dir,
+		ivy.WithIndexes(map[string][]string{"docs": {"title"}}),
+		ivy.WithPinnedTables("docs"),
+		ivy.WithWatchFiles(10*time.Millisecond))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer wdb.Close()
+
+	aId, _ := wdb.Create("docs", Document{Title: "draft"})
+	bId, _ := wdb.Create("docs", Document{Title: "draft"})
+
+	doc := Document{}
+	if err := wdb.Find("docs", &doc, aId); err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	edit := func(fileId string, title string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "docs", fileId+".json"), []byte(`{"title":"`+title+`"}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit(aId, "final")
+	edit("9", "final")
+	os.Remove(filepath.Join(dir, "docs", bId+".json"))
+
+	want := []string{aId, "9"}
+
+	var ids []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if ids, _ = wdb.FindAllIdsForField("docs", "title", "final"); reflect.DeepEqual(ids, want) {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatal("Expected the indexes to follow the edits, got", ids)
+	}
+
+	if ids, _ := wdb.FindAllIdsForField("docs", "title", "draft"); len(ids) != 0 {
+		t.Error("Expected the old values to be gone from the indexes, got", ids)
+	}
+
+	if err := wdb.Find("docs", &doc, aId); err != nil || doc.Title != "final" {
+		t.Error("Expected the pinned record to be read again, got", doc, err)
+	}
+
+	// Writes of the database itself keep the indexes as they are.
+	if err := wdb.Update("docs", Document{Title: "done"}, aId); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if ids, _ := wdb.FindAllIdsForField("docs", "title", "done"); !reflect.DeepEqual(ids, []string{aId}) {
+		t.Error("Expected the update to be indexed once, got", ids)
+	}
+
+	if _, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithWatchFiles(time.Second)); err == nil {
+		t.Error("Expected watching files to require file storage")
+	}
+}
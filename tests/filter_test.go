@@ -0,0 +1,64 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	tests := []struct {
+		filter ivy.M
+		ids    []string
+	}{
+		{ivy.M{"speed": ivy.M{"$gte": 300}, "enginetype": ivy.M{"$in": []string{"radial", "inline"}}}, []string{"1", "2", "3", "5"}},
+		{ivy.M{}, []string{"1", "2", "3", "4", "5", "6"}},
+		{ivy.M{"enginetype": "radial", "military": true}, []string{"3", "5"}},
+		{ivy.M{"speed": ivy.M{"$gt": 174, "$lt": 437}}, []string{"2", "5"}},
+		{ivy.M{"speed": ivy.M{"$not": ivy.M{"$gt": 300}}}, []string{"4", "6"}},
+		{ivy.M{"enginetype": ivy.M{"$nin": []string{"radial", "inline"}}}, []string{"6"}},
+		{ivy.M{"enginetype": ivy.M{"$ne": "radial"}, "speed": 437}, []string{"1"}},
+		{ivy.M{"maker.country": "US"}, []string{"1", "3"}},
+		{ivy.M{"maker": ivy.M{"$exists": false}}, []string{"4", "5", "6"}},
+		{ivy.M{"$or": []ivy.M{{"speed": ivy.M{"$lt": 100}}, {"name": "Zero"}}}, []string{"4", "5"}},
+		{ivy.M{"$and": []interface{}{map[string]interface{}{"military": true}, ivy.M{"speed": ivy.M{"$lte": 370.0}}}}, []string{"2", "5"}},
+	}
+
+	for _, test := range tests {
+		ids, err := pdb.Filter("planes", test.filter)
+		if err != nil {
+			t.Errorf("%v: Filter failed: %v", test.filter, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%v: expected %v, got %v", test.filter, test.ids, ids)
+		}
+	}
+
+	hits := pdb.Metrics().Tables["planes"].IndexHits
+
+	ids, err := pdb.Filter("planes", ivy.M{"enginetype": ivy.M{"$in": []string{"turboprop", "jet"}}})
+	if err != nil || !reflect.DeepEqual(ids, []string{"6"}) {
+		t.Errorf("Expected $in to find 6, got %v %v", ids, err)
+	}
+
+	if pdb.Metrics().Tables["planes"].IndexHits != hits+1 {
+		t.Errorf("Expected $in on an indexed field to use the index")
+	}
+
+	for _, filter := range []ivy.M{
+		{"speed": ivy.M{"$near": 300}},
+		{"$where": "speed > 300"},
+		{"speed": ivy.M{"$in": 300}},
+		{"maker": ivy.M{"country": "US"}},
+		{"$or": []ivy.M{}},
+	} {
+		if _, err := pdb.Filter("planes", filter); err == nil {
+			t.Errorf("%v: expected an invalid filter error", filter)
+		}
+	}
+}
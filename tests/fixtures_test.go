@@ -0,0 +1,101 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"notes": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Create("notes", Note{Text: "old one", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if _, err := db.Create("notes", Note{Text: "old two", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if _, err := db.Create("notes", Note{Text: "old three", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	dir := filepath.Join("testdata", "fixtures")
+
+	err = db.LoadFixtures(dir, ivy.FixtureOptions{})
+	if err != nil {
+		t.Fatal("LoadFixtures failed:", err)
+	}
+
+	ids, err := db.FindAllIds("notes")
+	if err != nil || len(ids) != 4 {
+		t.Error("Expected 4 notes, got", ids, err)
+	}
+
+	note := Note{}
+	err = db.Find("notes", &note, "2")
+	if err != nil || note.Text != "Read the manual" {
+		t.Error("Expected fixture to replace record 2, got", note.Text, err)
+	}
+
+	ids, err = db.FindAllIdsForTags("notes", []string{"todo"})
+	if err != nil || len(ids) != 1 || ids[0] != "2" {
+		t.Error("Expected fixtures to be indexed, got", ids, err)
+	}
+
+	err = db.LoadFixtures(dir, ivy.FixtureOptions{Truncate: true})
+	if err != nil {
+		t.Fatal("LoadFixtures failed:", err)
+	}
+
+	ids, err = db.FindAllIds("notes")
+	if err != nil || len(ids) != 3 {
+		t.Error("Expected 3 notes after truncating, got", ids, err)
+	}
+
+	id, err := db.Create("notes", Note{Text: "new", Tags: []string{}})
+	if err != nil || id != "11" {
+		t.Error("Expected new record to follow the fixtures, got", id, err)
+	}
+}
+
+func TestLoadFixturesErrors(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"notes": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	files := map[string]string{
+		"planes.json": `{"1": {}}`,
+		"notes.json":  `{"x": {}}`,
+		"notes.yaml":  "1:\n  text: hi\n",
+	}
+
+	for name, content := range files {
+		dir, err := ioutil.TempDir("", "ivy-fixtures")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = db.LoadFixtures(dir, ivy.FixtureOptions{}); err == nil {
+			t.Error("Expected an error loading", name)
+		}
+	}
+
+	ids, err := db.FindAllIds("notes")
+	if err != nil || len(ids) != 0 {
+		t.Error("Expected no records to be loaded, got", ids, err)
+	}
+}
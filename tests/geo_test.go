@@ -0,0 +1,68 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"airfields": nil}
+		if indexed {
+			fieldsToIndex["airfields"] = []string{"lat,lng@geo"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, rec := range []string{
+			`{"name": "Duxford", "lat": 52.09, "lng": 0.13, "tags": []}`,
+			`{"name": "Biggin Hill", "lat": 51.33, "lng": 0.03, "tags": []}`,
+			`{"name": "Oshkosh", "lat": 43.98, "lng": -88.56, "tags": []}`,
+			`{"name": "Nowhere", "lat": "north", "lng": 0, "tags": []}`,
+			`{"name": "Taveuni", "lat": -16.69, "lng": -179.88, "tags": []}`,
+		} {
+			if _, err := tdb.Create("airfields", json.RawMessage(rec)); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		tests := []struct {
+			box ivy.Bounds
+			ids []string
+		}{
+			{ivy.Bounds{MinLat: 50, MinLng: -2, MaxLat: 53, MaxLng: 2}, []string{"1", "2"}},
+			{ivy.Bounds{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180}, []string{"1", "2", "3", "5"}},
+			{ivy.Bounds{MinLat: -20, MinLng: 170, MaxLat: -10, MaxLng: -170}, []string{"5"}},
+			{ivy.Bounds{MinLat: 0, MinLng: 0, MaxLat: 10, MaxLng: 10}, nil},
+		}
+
+		for _, test := range tests {
+			ids, err := tdb.FindAllIdsInBounds("airfields", "lat", "lng", test.box)
+			if err != nil || !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("indexed %v, %+v: expected %v, got %v %v", indexed, test.box, test.ids, ids, err)
+			}
+		}
+
+		ids, err := tdb.FindNearestIds("airfields", "lat", "lng", 51.5, -0.12, 3)
+		if err != nil || !reflect.DeepEqual(ids, []string{"2", "1", "3"}) {
+			t.Errorf("indexed %v: expected the nearest to London, got %v %v", indexed, ids, err)
+		}
+
+		err = tdb.Update("airfields", json.RawMessage(`{"name": "Duxford", "tags": []}`), "1")
+		if err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		ids, _ = tdb.FindNearestIds("airfields", "lat", "lng", 51.5, -0.12, 2)
+		if !reflect.DeepEqual(ids, []string{"2", "3"}) {
+			t.Errorf("indexed %v: expected the update to change the index, got %v", indexed, ids)
+		}
+
+		tdb.Close()
+	}
+}
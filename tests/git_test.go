@@ -0,0 +1,100 @@
+package ivy
+
+import (
+	"context"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "ivy-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	gitOpts := ivy.GitOptions{Name: "ivy test", Email: "ivy@example.com"}
+
+	if _, err := ivy.OpenDB(dir, ivy.WithGit(gitOpts)); err == nil {
+		t.Fatal("Expected OpenDB to fail outside a git work tree")
+	}
+
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatal("git init failed:", err, string(out))
+	}
+
+	gdb, err := ivy.OpenDB(dir, ivy.WithGit(gitOpts))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer gdb.Close()
+
+	ctx := ivy.WithActor(context.Background(), "ann")
+
+	aId, _ := gdb.CreateCtx(ctx, "docs", Document{Title: "draft"})
+	bId, _ := gdb.Create("docs", Document{Title: "draft"})
+
+	if err := gdb.Update("docs", Document{Title: "final"}, aId); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := gdb.Delete("docs", bId); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	err = gdb.Transact(context.Background(), []string{"docs"}, func(tx *ivy.Tx) error {
+		if _, err := tx.Create("docs", Document{Title: "one"}); err != nil {
+			return err
+		}
+		_, err := tx.Create("docs", Document{Title: "two"})
+		return err
+	})
+	if err != nil {
+		t.Fatal("Transact failed:", err)
+	}
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal("git failed:", err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	want := []string{
+		"ivy: transaction of 2 changes",
+		"ivy: delete docs/" + bId,
+		"ivy: update docs/" + aId,
+		"ivy: create docs/" + bId,
+		"ivy: create docs/" + aId,
+	}
+
+	if got := strings.Split(git("log", "--format=%s"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the commits %q, got %q", want, got)
+	}
+
+	if body := git("log", "--format=%b", "-1", "HEAD~4"); !strings.Contains(body, "Actor: ann") {
+		t.Errorf("Expected the commit to name the actor, got %q", body)
+	}
+
+	if author := git("log", "--format=%an <%ae>", "-1"); author != "ivy test <ivy@example.com>" {
+		t.Error("Expected the configured author, got", author)
+	}
+
+	if status := git("status", "--porcelain", "--", "docs"); status != "" {
+		t.Error("Expected every change to be committed, got", status)
+	}
+}
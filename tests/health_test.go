@@ -0,0 +1,127 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-health")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	db, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"bar", "tags"}}, ivy.Options{
+		WAL:    &ivy.WALOptions{NoSync: true, SegmentSize: 1024},
+		Quotas: map[string]ivy.Quota{"foos": {MaxRecords: 3}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer db.Close()
+
+	check := func(h *ivy.Health, name string) ivy.HealthCheck {
+		for _, c := range h.Checks {
+			if c.Name == name {
+				return c
+			}
+		}
+
+		t.Fatalf("Expected a %s check, got %+v", name, h)
+		return ivy.HealthCheck{}
+	}
+
+	h := db.Health(context.Background())
+	if !h.Healthy || len(h.Checks) != 5 {
+		t.Fatalf("Expected a healthy database, got %+v", h)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".ivy", "health-probe")); !os.IsNotExist(err) {
+		t.Error("Expected the probe file to be removed, got", err)
+	}
+
+	db.Create("foos", Foo{Bar: "small", Tags: []string{}})
+
+	if c := check(db.Health(context.Background()), "wal"); !c.OK {
+		t.Errorf("Expected the log not to be backed up yet, got %+v", c)
+	}
+
+	// Together these take more than the four segments the log may hold
+	// before a checkpoint.
+	for i := 0; i < 2; i++ {
+		db.Create("foos", Foo{Bar: strings.Repeat("x", 2000), Tags: []string{}})
+	}
+
+	h = db.Health(context.Background())
+	if h.Healthy || check(h, "wal").OK || check(h, "quotas").OK {
+		t.Errorf("Expected the log and the quota to fail, got %+v", h)
+	}
+
+	if !strings.Contains(check(h, "quotas").Message, "table foos holds 3 records") {
+		t.Error("Unexpected quota message", check(h, "quotas").Message)
+	}
+
+	err = db.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	if c := check(db.Health(context.Background()), "wal"); !c.OK {
+		t.Errorf("Expected a checkpoint to clear the backlog, got %+v", c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if c := check(db.Health(ctx), "storage"); c.OK || c.Message != context.Canceled.Error() {
+		t.Errorf("Expected a canceled check, got %+v", c)
+	}
+
+	rec := httptest.NewRecorder()
+	db.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	var served ivy.Health
+
+	err = json.NewDecoder(rec.Body).Decode(&served)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+
+	if rec.Code != http.StatusServiceUnavailable || served.Healthy || len(served.Checks) != 5 {
+		t.Errorf("Expected 503 and the failed checks, got %d %+v", rec.Code, served)
+	}
+
+	db.Close()
+
+	h = db.Health(context.Background())
+	if h.Healthy || check(h, "open").Message != ivy.ErrClosed.Error() {
+		t.Errorf("Expected a closed database to be unhealthy, got %+v", h)
+	}
+}
+
+func TestHealthMemory(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"foos": {"bar"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	h := db.Health(context.Background())
+	if !h.Healthy || h.Checks[1].Message != "in memory" {
+		t.Errorf("Expected a healthy in-memory database, got %+v", h)
+	}
+}
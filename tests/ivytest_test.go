@@ -0,0 +1,34 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy/ivytest"
+	"path/filepath"
+	"testing"
+)
+
+func TestIvytest(t *testing.T) {
+	fdb := ivytest.NewTempDBWithFixtures(t, map[string][]string{"notes": {"tags"}}, filepath.Join("testdata", "fixtures"))
+
+	ids, err := fdb.FindAllIdsForTags("notes", []string{"demo"})
+	if err != nil || len(ids) != 2 {
+		t.Errorf("Expected the fixtures to be loaded and indexed, got %v %v", ids, err)
+	}
+
+	tdb := ivytest.NewTempDB(t, map[string][]string{"notes": {"tags"}})
+
+	ids, err = tdb.FindAllIds("notes")
+	if err != nil || len(ids) != 0 {
+		t.Errorf("Expected an empty table of its own, got %v %v", ids, err)
+	}
+
+	fileId, err := tdb.Create("notes", Note{Text: "hello", Tags: []string{}})
+	if err != nil || fileId != "1" {
+		t.Errorf("Expected to create the first record, got %q %v", fileId, err)
+	}
+
+	mdb := ivytest.NewMemDB(t, map[string][]string{"notes": nil})
+
+	if _, err := mdb.Create("notes", Note{Text: "hello", Tags: []string{}}); err != nil {
+		t.Error("Create failed:", err)
+	}
+}
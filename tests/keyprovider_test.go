@@ -0,0 +1,95 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{9}, 32)
+
+	t.Setenv("IVY_TEST_KEY", base64.StdEncoding.EncodeToString(key))
+
+	kdb, err := ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(ivy.EnvKey("IVY_TEST_KEY")))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	kdb.Close()
+
+	fetches := 0
+	kms := ivy.KeyFunc(func(ctx context.Context) ([]byte, error) {
+		fetches++
+		if fetches > 2 {
+			return nil, errors.New("kms unavailable")
+		}
+		return key, nil
+	})
+
+	cache := ivy.NewKeyCache(kms, 0)
+
+	for i := 0; i < 3; i++ {
+		kdb, err = ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(cache))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+		kdb.Close()
+	}
+
+	if fetches != 1 {
+		t.Error("Expected the cached key to be fetched once, got", fetches)
+	}
+
+	if err := cache.Refresh(context.Background()); err != nil || fetches != 2 {
+		t.Error("Expected Refresh to fetch the key again, got", fetches, err)
+	}
+
+	// An outage of the key management service keeps the cached key.
+	if err := cache.Refresh(context.Background()); err == nil {
+		t.Error("Expected Refresh to fail")
+	}
+
+	kdb, err = ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(cache))
+	if err != nil {
+		t.Fatal("Expected the cached key to open the database, got", err)
+	}
+	kdb.Close()
+
+	_, err = ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(ivy.StaticKey(bytes.Repeat([]byte{1}, 32))))
+	if !errors.Is(err, ivy.ErrBadKey) {
+		t.Error("Expected ErrBadKey for the wrong key, got", err)
+	}
+
+	_, err = ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(ivy.EnvKey("IVY_TEST_NO_SUCH_KEY")))
+	if err == nil {
+		t.Error("Expected an error for a missing environment variable")
+	}
+
+	_, err = ivy.OpenDB(dir, ivy.WithEncryptionKey(key), ivy.WithEncryptionKeyProvider(ivy.StaticKey(key)))
+	if err == nil {
+		t.Error("Expected an error for both a key and a key provider")
+	}
+
+	// A key management service that does not answer makes opening fail.
+	hung := ivy.KeyFunc(func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	_, err = ivy.OpenDB(dir, ivy.WithEncryptionKeyProvider(hung), ivy.WithKeyTimeout(10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected fetching the key to time out, got", err)
+	}
+}
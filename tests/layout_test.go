@@ -0,0 +1,62 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardedLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ivy.Options{Layout: &ivy.ShardedLayout{Shards: 4}}
+
+	sdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags", "bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer sdb.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := sdb.Create("foos", Foo{Bar: "sharded", Tags: []string{"shard"}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	shards, _ := ioutil.ReadDir(filepath.Join(dir, "foos"))
+	if len(shards) < 2 {
+		t.Error("Expected records to be spread over shard directories, got", len(shards))
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			t.Error("Expected only shard directories in the table directory, got", shard.Name())
+		}
+	}
+
+	ids, err := sdb.FindAllIdsForField("foos", "bar", "sharded")
+	if err != nil || len(ids) != 10 {
+		t.Error("Expected 10 records, got", len(ids), err)
+	}
+
+	err = sdb.Delete("foos", "3")
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	ids, err = sdb.FindAllIds("foos")
+	if err != nil || len(ids) != 9 {
+		t.Error("Expected 9 records after delete, got", len(ids), err)
+	}
+}
@@ -0,0 +1,105 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ivy.Options{WriteBehind: &ivy.WriteBehindOptions{FlushInterval: time.Hour}}
+
+	ldb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	id, err := ldb.Create("foos", Foo{Bar: "pending", Tags: []string{"a"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	err = ldb.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	// Close flushes the pending write.
+	if _, err := os.Stat(filepath.Join(dir, "foos", id+".json")); err != nil {
+		t.Error("Expected Close to flush pending writes:", err)
+	}
+
+	foo := Foo{}
+	if err := ldb.Find("foos", &foo, id); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected Find to return ErrClosed, got", err)
+	}
+	if _, err := ldb.Create("foos", Foo{Bar: "late"}); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected Create to return ErrClosed, got", err)
+	}
+	if _, err := ldb.FindAllIdsForTags("foos", []string{"a"}); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected FindAllIdsForTags to return ErrClosed, got", err)
+	}
+	if err := ldb.Close(); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected second Close to return ErrClosed, got", err)
+	}
+}
+
+func TestCloseWaitsForOperations(t *testing.T) {
+	ldb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := ldb.Create("foos", Foo{Bar: "busy", Tags: []string{"a"}})
+				if errors.Is(err, ivy.ErrClosed) {
+					return
+				}
+				if err != nil {
+					t.Error("Create failed:", err)
+					return
+				}
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	err = ldb.Close()
+	if err != nil {
+		t.Error("Close failed:", err)
+	}
+
+	wg.Wait()
+
+	if created == 0 {
+		t.Error("Expected some records to be created before Close")
+	}
+}
@@ -0,0 +1,99 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags"}}
+
+	ldb, err := ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	lockPath := filepath.Join(dir, ".ivy", "lock")
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatal("Expected lock file to be created:", err)
+	}
+
+	_, err = ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex))
+	if !errors.Is(err, ivy.ErrLocked) {
+		t.Error("Expected second OpenDB to return ErrLocked, got", err)
+	}
+
+	ldb.Close()
+
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Error("Expected Close to remove the lock file, got", err)
+	}
+}
+
+func TestStaleLockAndTempCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"foos", filepath.Join(".ivy", "indexes")} {
+		err = os.MkdirAll(filepath.Join(dir, sub), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	// A lock file of a crashed process. PIDs never get this high.
+	stale := `{"pid":2147483600,"host":"` + hostname + `","acquired":"2020-01-01T00:00:00Z"}`
+
+	files := map[string]string{
+		filepath.Join(".ivy", "lock"):                          stale,
+		filepath.Join(".ivy", "indexes", "foos.json.tmp-aaaa"): `{`,
+		filepath.Join("foos", "1.json"):                        `{"bar":"kept","tags":[]}`,
+		filepath.Join("foos", "2.json.tmp-bbbb"):               `{"bar":`,
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ldb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"foos": {"tags"}}))
+	if err != nil {
+		t.Fatal("Expected OpenDB to take over the stale lock, got", err)
+	}
+	defer ldb.Close()
+
+	for _, name := range []string{
+		filepath.Join(".ivy", "indexes", "foos.json.tmp-aaaa"),
+		filepath.Join("foos", "2.json.tmp-bbbb"),
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error("Expected", name, "to be removed, got", err)
+		}
+	}
+
+	ids, err := ldb.FindAllIds("foos")
+	if err != nil || len(ids) != 1 || ids[0] != "1" {
+		t.Error("Expected only record 1, got", ids, err)
+	}
+}
@@ -0,0 +1,115 @@
+package ivy
+
+import (
+	"bytes"
+	"github.com/jameycribbs/ivy"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowRecord is a record whose marshalling takes a while, which keeps its
+// table locked for writing while it is created.
+type slowRecord struct {
+	started chan bool
+	release chan bool
+}
+
+func (r *slowRecord) MarshalJSON() ([]byte, error) {
+	r.started <- true
+	<-r.release
+	return []byte(`{"tags":[]}`), nil
+}
+
+func TestLockDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	var bufMu sync.Mutex
+
+	logger := slog.New(slog.NewTextHandler(writerFunc(func(p []byte) (int, error) {
+		bufMu.Lock()
+		defer bufMu.Unlock()
+		return buf.Write(p)
+	}), nil))
+
+	db, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": nil}, ivy.Options{
+		Storage:         ivy.MemoryStorage,
+		LockWaitWarning: 10 * time.Millisecond,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer db.Close()
+
+	rec := &slowRecord{started: make(chan bool), release: make(chan bool)}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		db.Create("foos", rec)
+	}()
+	<-rec.started
+
+	go func() {
+		defer wg.Done()
+		db.FindAllIds("foos")
+	}()
+
+	// Wait for the reader to queue up behind the writer.
+	for i := 0; i < 100; i++ {
+		if mustStats(t, db).Tables["foos"].Lock.Waiting == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	foos := mustStats(t, db).Tables["foos"]
+
+	if foos.Lock.Held != "write" || foos.Lock.Waiting != 1 || foos.Lock.HeldSince.IsZero() {
+		t.Errorf("Expected the lock to be held for writing with a waiter, got %+v", foos.Lock)
+	}
+
+	if len(foos.Operations) != 2 || foos.Operations[0].Op != "create" || foos.Operations[1].Op != "ids" ||
+		foos.Operations[0].Duration < 20*time.Millisecond {
+		t.Errorf("Expected the create and the ids in progress, got %+v", foos.Operations)
+	}
+
+	close(rec.release)
+	wg.Wait()
+
+	foos = mustStats(t, db).Tables["foos"]
+
+	if foos.Lock.Held != "" || foos.Lock.Waiting != 0 || foos.Lock.Contended != 1 ||
+		foos.Lock.MaxWait < 20*time.Millisecond || foos.Records != 1 || len(foos.Operations) != 0 {
+		t.Errorf("Expected a free lock after one contended wait, got %+v", foos)
+	}
+
+	bufMu.Lock()
+	defer bufMu.Unlock()
+
+	if !strings.Contains(buf.String(), `msg="ivy: slow table lock" table=foos mode=read`) {
+		t.Errorf("Expected a slow lock warning, got %q", buf.String())
+	}
+}
+
+func mustStats(t *testing.T, db *ivy.DB) *ivy.Stats {
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal("Stats failed:", err)
+	}
+
+	return stats
+}
+
+// writerFunc is an io.Writer calling a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,85 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-logger")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "notes"), 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "notes", "1.json"), []byte(`{"text": "trunc`), 0600)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	db, err := ivy.OpenDBWithOptions(dir, map[string][]string{"notes": {"text"}}, ivy.Options{Logger: logger})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	fileId, err := db.Create("notes", Note{Text: "hello", Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	db.Delete("notes", fileId)
+	db.Close()
+
+	var events []map[string]interface{}
+
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event map[string]interface{}
+
+		err := dec.Decode(&event)
+		if err != nil {
+			t.Fatal("Decode failed:", err)
+		}
+
+		events = append(events, event)
+	}
+
+	find := func(msg string, attr string, value interface{}) map[string]interface{} {
+		for _, event := range events {
+			if event["msg"] == msg && event[attr] == value {
+				return event
+			}
+		}
+
+		t.Errorf("Expected a %q event with %s %v, got %v", msg, attr, value, events)
+		return nil
+	}
+
+	if event := find("ivy: corrupt record left out of the indexes", "id", "1"); event != nil {
+		if event["level"] != "WARN" || event["table"] != "notes" || event["err"] == nil {
+			t.Error("Unexpected event", event)
+		}
+	}
+
+	find("ivy: indexes rebuilt", "table", "notes")
+	find("ivy: write", "op", "create")
+
+	if event := find("ivy: write", "op", "delete"); event != nil && event["id"] != fileId {
+		t.Error("Unexpected event", event)
+	}
+}
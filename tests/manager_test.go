@@ -0,0 +1,115 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	root, err := ioutil.TempDir("", "ivy-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var dirs []string
+	for _, name := range []string{"a", "b", "c"} {
+		dir := filepath.Join(root, name)
+		os.MkdirAll(filepath.Join(dir, "docs"), 0700)
+		dirs = append(dirs, dir)
+	}
+
+	m := ivy.NewManager(ivy.ManagerOptions{
+		Options: []ivy.Option{ivy.WithIndexes(map[string][]string{"docs": {"title"}})},
+		MaxOpen: 2,
+	})
+
+	ctx := context.Background()
+
+	for _, dir := range dirs {
+		err := m.Do(ctx, dir, func(db *ivy.DB) error {
+			_, err := db.Create("docs", Document{Title: filepath.Base(dir)})
+			return err
+		})
+		if err != nil {
+			t.Fatal("Do failed:", err)
+		}
+	}
+
+	if open := m.Open(); len(open) != 2 {
+		t.Error("Expected 2 open databases, got", open)
+	}
+
+	a, err := m.Acquire(ctx, dirs[0])
+	if err != nil {
+		t.Fatal("Acquire failed:", err)
+	}
+
+	if ids, err := a.FindAllIdsForField("docs", "title", "a"); err != nil || len(ids) != 1 {
+		t.Error("Expected the evicted database to be opened again, got", ids, err)
+	}
+
+	b, err := m.Acquire(ctx, dirs[1])
+	if err != nil {
+		t.Fatal("Acquire failed:", err)
+	}
+
+	// Both open databases are in use.
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = m.Acquire(short, dirs[2])
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected Acquire to wait for a database to be released, got", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		c, err := m.Acquire(ctx, dirs[2])
+		if err == nil {
+			m.Release(c)
+		}
+		acquired <- err
+	}()
+
+	m.Release(b)
+
+	if err := <-acquired; err != nil {
+		t.Error("Expected Acquire to succeed once a database was released, got", err)
+	}
+
+	m.Release(a)
+
+	if err := m.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	if _, err := m.Acquire(ctx, dirs[0]); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected ErrClosed after Close, got", err)
+	}
+
+	if _, err := a.Create("docs", Document{Title: "late"}); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected the databases to be closed with the manager, got", err)
+	}
+
+	idle := ivy.NewManager(ivy.ManagerOptions{IdleTimeout: 20 * time.Millisecond})
+	defer idle.Close()
+
+	if err := idle.Do(ctx, dirs[0], func(db *ivy.DB) error { return nil }); err != nil {
+		t.Fatal("Do failed:", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(idle.Open()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if open := idle.Open(); len(open) != 0 {
+		t.Error("Expected the idle database to be closed, got", open)
+	}
+}
@@ -0,0 +1,46 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"testing"
+)
+
+func TestOpenMemDB(t *testing.T) {
+	mdb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags", "bar"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer mdb.Close()
+
+	id, err := mdb.Create("foos", Foo{Bar: "mem", Tags: []string{"volatile"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	ids, err := mdb.FindAllIdsForTags("foos", []string{"volatile"})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Error("Expected tag search to return", id, "got", ids, err)
+	}
+
+	err = mdb.Update("foos", Foo{Bar: "mem2", Tags: []string{"volatile"}}, id)
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	id2, err := mdb.FindFirstIdForField("foos", "bar", "mem2")
+	if err != nil || id2 != id {
+		t.Error("Expected field search to return", id, "got", id2, err)
+	}
+
+	err = mdb.Delete("foos", id)
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	foo := Foo{}
+	err = mdb.Find("foos", &foo, id)
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected Find error to be ErrNotFound, got ", err)
+	}
+}
@@ -0,0 +1,76 @@
+package ivy
+
+import (
+	"expvar"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"planes": {"enginetype"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	fileId, _ := db.Create("planes", Plane{Name: "Spitfire", EngineType: "piston", Tags: []string{}})
+	db.Create("planes", Plane{Name: "Meteor", EngineType: "jet", Tags: []string{}})
+	db.Update("planes", Plane{Name: "Spitfire Mk IX", EngineType: "piston", Tags: []string{}}, fileId)
+
+	plane := Plane{}
+	db.Find("planes", &plane, fileId)
+
+	db.FindAllIdsForField("planes", "enginetype", "jet")
+	db.FindAllIdsForField("planes", "name", "Meteor")
+	db.QueryString("planes", "enginetype = 'jet'")
+	db.QueryString("planes", "speed > 100")
+
+	db.Delete("planes", fileId)
+
+	m := db.Metrics().Tables["planes"]
+
+	ops := map[string]uint64{"create": 2, "update": 1, "delete": 1, "find": 1, "query": 4}
+	for op, n := range ops {
+		if m.Ops[op] != n {
+			t.Errorf("Expected %d %s operations, got %d", n, op, m.Ops[op])
+		}
+	}
+
+	if m.IndexHits != 2 || m.Scans != 2 || m.ScanTime.Count != 2 {
+		t.Errorf("Expected 2 index hits and 2 scans, got %+v", m)
+	}
+
+	if m.BytesRead == 0 || m.BytesWritten == 0 {
+		t.Errorf("Expected bytes to be counted, got %+v", m)
+	}
+
+	if m.IndexRebuilds.Count != 1 || len(m.IndexRebuilds.Counts) != len(m.IndexRebuilds.Bounds) {
+		t.Errorf("Expected the index rebuild at open, got %+v", m.IndexRebuilds)
+	}
+
+	rec := httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	for _, line := range []string{
+		`# TYPE ivy_operations_total counter`,
+		`ivy_operations_total{table="planes",op="create"} 2`,
+		`ivy_scans_total{table="planes"} 2`,
+		`ivy_scan_duration_seconds_bucket{table="planes",le="+Inf"} 2`,
+		`ivy_index_rebuild_duration_seconds_count{table="planes"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected %q in\n%s", line, body)
+		}
+	}
+
+	db.PublishExpvar("ivy_test_metrics")
+
+	if v := expvar.Get("ivy_test_metrics"); v == nil || !strings.Contains(v.String(), `"index_hits":2`) {
+		t.Error("Expected the metrics to be published, got", v)
+	}
+}
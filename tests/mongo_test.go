@@ -0,0 +1,93 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type MongoUser struct {
+	MongoId  string                 `json:"_id"`
+	Name     string                 `json:"name"`
+	Visits   int64                  `json:"visits"`
+	Joined   string                 `json:"joined"`
+	Friend   string                 `json:"friend"`
+	Settings map[string]interface{} `json:"settings"`
+	Scores   []float64              `json:"scores"`
+}
+
+func (u *MongoUser) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestImportMongo(t *testing.T) {
+	mdb, err := ivy.OpenMemDB(nil)
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer mdb.Close()
+
+	export := `{"_id":{"$oid":"5f1d7f6e2c3b4a0011aa0001"},"name":"ann","visits":{"$numberLong":"9007199254740993"},"joined":{"$date":"2020-07-26T12:00:00.000Z"},"settings":{"theme":"dark","since":{"$date":{"$numberLong":"1595764800000"}}},"scores":[{"$numberInt":"3"},{"$numberDouble":"4.5"}]}
+{"_id":{"$oid":"5f1d7f6e2c3b4a0011aa0002"},"name":"bob","friend":{"$oid":"5f1d7f6e2c3b4a0011aa0001"},"joined":{"$date":1595764800000}}
+{"_id":{"$oid":"5f1d7f6e2c3b4a0011aa0003"},"name":"cy","visits":{"$numberInt":"many"}}
+`
+
+	report, err := mdb.ImportMongo("users", strings.NewReader(export), ivy.MongoImportOptions{})
+	if err != nil {
+		t.Fatal("ImportMongo failed:", err)
+	}
+
+	if !reflect.DeepEqual(report.Ids, []string{"1", "2"}) {
+		t.Error("Expected new ids for the ObjectIds, got", report.Ids)
+	}
+
+	if len(report.Errors) != 1 || report.Errors[0].Doc != 3 {
+		t.Error("Expected the third document to be reported, got", report.Errors)
+	}
+
+	if report.Remapped["5f1d7f6e2c3b4a0011aa0002"] != "2" {
+		t.Error("Expected the ObjectIds to be mapped to the new ids, got", report.Remapped)
+	}
+
+	ann := MongoUser{}
+	if err := mdb.Find("users", &ann, "1"); err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	want := MongoUser{
+		MongoId:  "5f1d7f6e2c3b4a0011aa0001",
+		Name:     "ann",
+		Visits:   9007199254740993,
+		Joined:   "2020-07-26T12:00:00Z",
+		Settings: map[string]interface{}{"theme": "dark", "since": "2020-07-26T12:00:00Z"},
+		Scores:   []float64{3, 4.5},
+	}
+	if !reflect.DeepEqual(ann, want) {
+		t.Errorf("Expected the type wrappers to be converted, got %+v", ann)
+	}
+
+	bob := MongoUser{}
+	if err := mdb.Find("users", &bob, "2"); err != nil || bob.Friend != ann.MongoId || bob.Joined != ann.Joined {
+		t.Errorf("Expected references to keep the ObjectId, got %+v %v", bob, err)
+	}
+
+	// Integer ids of a JSON array export are kept.
+	export = `[
+		{"_id": 7, "title": "seven"},
+		{"_id": {"$numberLong": "12"}, "title": "twelve"}
+	]`
+
+	report, err = mdb.ImportMongo("posts", strings.NewReader(export), ivy.MongoImportOptions{IdField: "-"})
+	if err != nil {
+		t.Fatal("ImportMongo failed:", err)
+	}
+
+	if !reflect.DeepEqual(report.Ids, []string{"7", "12"}) || len(report.Remapped) != 0 {
+		t.Error("Expected the integer ids to be kept, got", report.Ids, report.Remapped)
+	}
+
+	doc := Document{}
+	if err := mdb.Find("posts", &doc, "12"); err != nil || doc.Title != "twelve" {
+		t.Error("Expected the record to keep its id, got", doc, err)
+	}
+}
@@ -0,0 +1,108 @@
+package ivy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-namespaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+
+	logPath := filepath.Join(dir, "access.log")
+
+	open := func() *ivy.DB {
+		ndb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"docs": {"title"}}, ivy.Options{
+			AccessLog: &ivy.AccessLogOptions{Path: logPath},
+		})
+		if err != nil {
+			t.Fatal("OpenDBWithOptions failed:", err)
+		}
+		return ndb
+	}
+
+	ndb := open()
+
+	acme, err := ndb.Namespace("acme")
+	if err != nil {
+		t.Fatal("Namespace failed:", err)
+	}
+
+	if again, err := ndb.Namespace("acme"); err != nil || again != acme {
+		t.Error("Expected the same namespace to be returned again, got", again, err)
+	}
+
+	globex, err := ndb.Namespace("globex")
+	if err != nil {
+		t.Fatal("Namespace failed:", err)
+	}
+
+	if _, err := acme.Create("docs", Document{Title: "Plan"}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	for _, d := range []*ivy.DB{ndb, globex} {
+		if ids, err := d.FindAllIdsForField("docs", "title", "Plan"); err != nil || len(ids) != 0 {
+			t.Error("Expected the record to stay in its namespace, got", ids, err)
+		}
+	}
+
+	if _, err := ndb.Namespace("../escape"); err == nil {
+		t.Error("Expected an error for an invalid namespace name")
+	}
+
+	if err := ndb.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	if _, err := acme.Create("docs", Document{Title: "Late"}); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected closing the database to close its namespaces, got", err)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var e ivy.AccessLogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Op == "create" && e.Namespace == "acme" {
+			found = true
+		}
+	}
+	f.Close()
+
+	if !found {
+		t.Error("Expected the access log to name the namespace of the create")
+	}
+
+	ndb = open()
+	defer ndb.Close()
+
+	names, err := ndb.Namespaces()
+	if err != nil || !reflect.DeepEqual(names, []string{"acme", "globex"}) {
+		t.Error("Expected the namespaces acme and globex, got", names, err)
+	}
+
+	acme, err = ndb.Namespace("acme")
+	if err != nil {
+		t.Fatal("Namespace failed:", err)
+	}
+
+	if ids, err := acme.FindAllIdsForField("docs", "title", "Plan"); err != nil || len(ids) != 1 {
+		t.Error("Expected the record to be found again, got", ids, err)
+	}
+}
@@ -0,0 +1,76 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileNaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ivy.Options{Layout: &ivy.DefaultLayout{Naming: map[string]ivy.FileNaming{
+		"foos": {Ext: ".rec", Pad: 6, SlugField: "bar"},
+	}}}
+
+	ndb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags", "bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer ndb.Close()
+
+	id, err := ndb.Create("foos", Foo{Bar: "P-51D Mustang", Tags: []string{"us"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if id != "1" {
+		t.Error("Expected unpadded id '1', got", id)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "foos", "000001-p-51d-mustang.rec")); err != nil {
+		t.Error("Expected padded, slugged file name:", err)
+	}
+
+	err = ndb.Update("foos", Foo{Bar: "Spitfire", Tags: []string{"uk"}}, id)
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "foos"))
+	if len(files) != 1 || files[0].Name() != "000001-spitfire.rec" {
+		t.Error("Expected record file to be renamed, got", files)
+	}
+
+	foo := Foo{}
+	err = ndb.Find("foos", &foo, id)
+	if err != nil || foo.Bar != "Spitfire" {
+		t.Error("Expected to find updated record, got", foo.Bar, err)
+	}
+
+	id2, err := ndb.Create("foos", Foo{Bar: "Zero", Tags: []string{"jp"}})
+	if err != nil || id2 != "2" {
+		t.Error("Expected second id to be '2', got", id2, err)
+	}
+
+	err = ndb.Delete("foos", id)
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	ids, err := ndb.FindAllIds("foos")
+	if err != nil || len(ids) != 1 || ids[0] != "2" {
+		t.Error("Expected only id '2' to be left, got", ids, err)
+	}
+}
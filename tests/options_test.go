@@ -0,0 +1,116 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type countingCodec struct {
+	ivy.JSONCodec
+	marshalled   int
+	unmarshalled int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.marshalled++
+	return c.JSONCodec.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshalled++
+	return c.JSONCodec.Unmarshal(data, v)
+}
+
+func TestOpenDBOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-options")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "planes"), 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	codec := &countingCodec{}
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	db, err := ivy.OpenDB(dir,
+		ivy.WithIndexes(map[string][]string{"planes": {"enginetype"}}),
+		ivy.WithIndexes(map[string][]string{"planes": {"tags"}}),
+		ivy.WithCodec(codec),
+		ivy.WithLogger(logger))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	fileId, err := db.Create("planes", &Plane{Name: "Spitfire", EngineType: "inline", Tags: []string{"uk"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	var plane Plane
+
+	err = db.Find("planes", &plane, fileId)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	if codec.marshalled != 1 || codec.unmarshalled != 1 {
+		t.Errorf("Expected the codec to be used, got %d marshals and %d unmarshals", codec.marshalled, codec.unmarshalled)
+	}
+
+	ids, _ := db.FindAllIdsForField("planes", "enginetype", "inline")
+	tagIds, _ := db.FindAllIdsForTags("planes", []string{"uk"})
+	if len(ids) != 1 || len(tagIds) != 1 {
+		t.Errorf("Expected both indexes to find the record, got %v and %v", ids, tagIds)
+	}
+
+	if !strings.Contains(buf.String(), `msg="ivy: write"`) {
+		t.Errorf("Expected the logger to receive the write, got %q", buf.String())
+	}
+
+	db.Close()
+
+	rdb, err := ivy.OpenDB(dir, ivy.WithCodec(codec), ivy.WithReadOnly())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	err = rdb.Find("planes", &plane, fileId)
+	if err != nil || plane.Name != "Spitfire" {
+		t.Errorf("Expected to read the record, got %v %v", plane, err)
+	}
+
+	_, err = rdb.Create("planes", &Plane{Name: "Mustang", Tags: []string{}})
+	if !errors.Is(err, ivy.ErrReadOnly) {
+		t.Errorf("Expected Create to return ErrReadOnly, got %v", err)
+	}
+
+	err = rdb.Delete("planes", fileId)
+	if !errors.Is(err, ivy.ErrReadOnly) {
+		t.Errorf("Expected Delete to return ErrReadOnly, got %v", err)
+	}
+
+	mdb, err := ivy.OpenDB("", ivy.WithIndexes(map[string][]string{"planes": nil}), ivy.WithStorage(ivy.MemoryStorage), ivy.WithChecksums())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer mdb.Close()
+
+	if _, err := mdb.Create("planes", &Plane{Name: "Zero", Tags: []string{}}); err != nil {
+		t.Error("Expected Create on a memory database to succeed, got", err)
+	}
+}
@@ -0,0 +1,113 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	var mu sync.Mutex
+	var published []ivy.OutboxEvent
+
+	failures := 1
+	delivered := make(chan struct{}, 10)
+
+	publish := func(ctx context.Context, event ivy.OutboxEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		published = append(published, event)
+
+		if failures > 0 {
+			failures--
+			return errors.New("broker unavailable")
+		}
+
+		delivered <- struct{}{}
+		return nil
+	}
+
+	opts := ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Outbox:  &ivy.OutboxOptions{Publish: publish, Interval: 10 * time.Millisecond},
+	}
+
+	odb, err := ivy.OpenDBWithOptions("", map[string][]string{"docs": nil}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer odb.Close()
+
+	var emitted string
+
+	err = odb.Transact(context.Background(), []string{"docs"}, func(tx *ivy.Tx) error {
+		fileId, err := tx.Create("docs", Document{Title: "draft"})
+		if err != nil {
+			return err
+		}
+
+		payload, _ := json.Marshal(map[string]string{"id": fileId})
+
+		emitted, err = tx.Emit(ivy.OutboxEvent{Topic: "docs.created", Key: fileId, Payload: payload})
+		return err
+	})
+	if err != nil {
+		t.Fatal("Transact failed:", err)
+	}
+
+	// Events of a transaction rolled back are never published.
+	err = odb.Transact(context.Background(), []string{"docs"}, func(tx *ivy.Tx) error {
+		if _, err := tx.Emit(ivy.OutboxEvent{Topic: "docs.created"}); err != nil {
+			return err
+		}
+		return errors.New("changed my mind")
+	})
+	if err == nil {
+		t.Fatal("Expected the transaction to fail")
+	}
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the event to be published")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	if len(published) != 2 || published[0].Id != emitted || published[1].Id != emitted {
+		t.Error("Expected the event to be published again after failing, got", published)
+	}
+	if event := published[len(published)-1]; event.Topic != "docs.created" || event.Key != "1" || string(event.Payload) != `{"id":"1"}` {
+		t.Error("Unexpected event", event)
+	}
+	mu.Unlock()
+
+	if ids, err := odb.FindAllIds("outbox"); err != nil || len(ids) != 0 {
+		t.Error("Expected the outbox to be empty, got", ids, err)
+	}
+
+	err = odb.Transact(context.Background(), []string{"outbox"}, func(tx *ivy.Tx) error { return nil })
+	if err == nil {
+		t.Error("Expected a transaction of the outbox table to be refused")
+	}
+
+	plain, err := ivy.OpenMemDB(map[string][]string{"docs": nil})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer plain.Close()
+
+	err = plain.Transact(context.Background(), []string{"docs"}, func(tx *ivy.Tx) error {
+		_, err := tx.Emit(ivy.OutboxEvent{Topic: "docs.created"})
+		return err
+	})
+	if err == nil {
+		t.Error("Expected Emit to fail without an outbox")
+	}
+}
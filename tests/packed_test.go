@@ -0,0 +1,322 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackedStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-packed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+	opts := ivy.Options{Storage: ivy.PackedStorage}
+
+	pdb, err := ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	var ids []string
+	for _, bar := range []string{"a", "b", "c"} {
+		id, err := pdb.Create("foos", Foo{Bar: bar, Tags: []string{"packed"}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Grow a record so that it has to move to a new slot.
+	err = pdb.Update("foos", Foo{Bar: strings.Repeat("x", 500), Tags: []string{"packed"}}, ids[0])
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	err = pdb.Delete("foos", ids[1])
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	pdb.Close()
+
+	var files []string
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			files = append(files, info.Name())
+		}
+	}
+	if len(files) != 1 || files[0] != "foos.ivy" {
+		t.Fatal("Expected a single foos.ivy data file, got", files)
+	}
+
+	pdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer pdb.Close()
+
+	foo := Foo{}
+	err = pdb.Find("foos", &foo, ids[0])
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if foo.Bar != strings.Repeat("x", 500) {
+		t.Error("Expected updated record to survive reopening, got", foo.Bar)
+	}
+
+	err = pdb.Find("foos", &foo, ids[1])
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected Find error to be ErrNotFound, got ", err)
+	}
+
+	ids2, err := pdb.FindAllIdsForField("foos", "bar", "c")
+	if err != nil || len(ids2) != 1 || ids2[0] != ids[2] {
+		t.Error("Expected field search to return", ids[2], "got", ids2, err)
+	}
+
+	// Deleted space should be reused rather than growing the file.
+	info, _ := os.Stat(filepath.Join(dir, "foos.ivy"))
+	sizeBefore := info.Size()
+
+	_, err = pdb.Create("foos", Foo{Bar: "d", Tags: []string{"packed"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	info, _ = os.Stat(filepath.Join(dir, "foos.ivy"))
+	if info.Size() != sizeBefore {
+		t.Error("Expected free slot to be reused, file grew from", sizeBefore, "to", info.Size())
+	}
+}
+
+func TestPackedStorageInterruptedUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-packed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := ivy.Options{Storage: ivy.PackedStorage}
+
+	pdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": nil}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	id, err := pdb.Create("foos", Foo{Bar: "a", Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if _, err := pdb.Create("foos", Foo{Bar: "b", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	// The record moves to a third slot at the end of the file, and then back
+	// to its first slot, leaving the third one free.
+	if err := pdb.Update("foos", Foo{Bar: strings.Repeat("x", 500), Tags: []string{}}, id); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := pdb.Update("foos", Foo{Bar: "c", Tags: []string{}}, id); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	pdb.Close()
+
+	// Mark the third slot live again, as if a crash had happened before it
+	// was freed. Slot headers hold the capacity, the flags, the id length,
+	// the record length and the sequence number.
+	path := filepath.Join(dir, "foos.ivy")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := 8
+	for i := 0; i < 2; i++ {
+		offset += 19 + int(binary.LittleEndian.Uint32(data[offset:]))
+	}
+
+	header := data[offset : offset+19]
+	payload := data[offset+19:]
+	if header[4] != 0 || string(payload[:len(id)]) != id {
+		t.Fatal("Expected the third slot to be the free old version of the record")
+	}
+
+	header[4] = 1
+	binary.LittleEndian.PutUint16(header[5:], uint16(len(id)))
+	binary.LittleEndian.PutUint32(header[7:], uint32(bytes.IndexByte(payload[len(id):], 0)))
+	binary.LittleEndian.PutUint64(header[11:], 3)
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pdb, err = ivy.OpenDBWithOptions(dir, map[string][]string{"foos": nil}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer pdb.Close()
+
+	foo := Foo{}
+	if err := pdb.Find("foos", &foo, id); err != nil || foo.Bar != "c" {
+		t.Error("Expected the latest version of the record to win, got", foo.Bar, err)
+	}
+}
+
+func TestPackedStorageMmapReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := ivy.Options{Storage: ivy.PackedStorage, MmapReads: true}
+
+	mdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer mdb.Close()
+
+	// Write enough data to outgrow the initial mapping.
+	bar := strings.Repeat("y", 1000)
+	var ids []string
+	for i := 0; i < 100; i++ {
+		id, err := mdb.Create("foos", Foo{Bar: bar, Tags: []string{}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		foo := Foo{}
+		err = mdb.Find("foos", &foo, id)
+		if err != nil {
+			t.Fatal("Find failed:", err)
+		}
+		if foo.Bar != bar {
+			t.Fatal("Expected mapped record to match, got", len(foo.Bar), "bytes")
+		}
+	}
+
+	_, err = ivy.OpenDBWithOptions(dir, nil, ivy.Options{MmapReads: true})
+	if err == nil {
+		t.Error("Expected MmapReads without packed storage to fail")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := ivy.Options{Storage: ivy.PackedStorage, MmapReads: true}
+
+	cdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	bar := strings.Repeat("z", 1000)
+	var ids []string
+	for i := 0; i < 50; i++ {
+		id, err := cdb.Create("foos", Foo{Bar: bar, Tags: []string{}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids[:40] {
+		if err := cdb.Delete("foos", id); err != nil {
+			t.Fatal("Delete failed:", err)
+		}
+	}
+	ids = ids[40:]
+
+	// Readers and writers carry on while the table is compacted.
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 20; i++ {
+			foo := Foo{}
+			if err := cdb.Find("foos", &foo, ids[i%len(ids)]); err != nil {
+				done <- err
+				return
+			}
+			if err := cdb.Update("foos", Foo{Bar: bar, Tags: []string{"kept"}}, ids[0]); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	report, err := cdb.Compact("foos")
+	if err != nil {
+		t.Fatal("Compact failed:", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("Read during Compact failed:", err)
+	}
+
+	if report.BytesAfter >= report.BytesBefore/2 {
+		t.Error("Expected Compact to reclaim the deleted records, got", report.BytesBefore, "to", report.BytesAfter)
+	}
+
+	// Updates free the slot of the previous version, so the updates made
+	// after the compaction leave free slots behind.
+	report, err = cdb.Compact("foos")
+	if err != nil {
+		t.Fatal("Compact failed:", err)
+	}
+
+	info, _ := os.Stat(filepath.Join(dir, "foos.ivy"))
+	if info.Size() != report.BytesAfter {
+		t.Error("Expected the table file to shrink to", report.BytesAfter, "got", info.Size())
+	}
+
+	report, err = cdb.Compact("foos")
+	if err != nil || report.BytesAfter != report.BytesBefore {
+		t.Error("Expected compacting a compact table to change nothing, got", report, err)
+	}
+
+	_, err = cdb.Compact("bars")
+	if !errors.Is(err, ivy.ErrTableNotFound) {
+		t.Error("Expected Compact of a missing table to fail with ErrTableNotFound, got", err)
+	}
+
+	cdb.Close()
+
+	cdb, err = ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer cdb.Close()
+
+	for _, id := range ids {
+		foo := Foo{}
+		if err := cdb.Find("foos", &foo, id); err != nil || foo.Bar != bar {
+			t.Fatal("Expected record", id, "to survive compaction, got", len(foo.Bar), err)
+		}
+	}
+
+	all, err := cdb.FindAllIds("foos")
+	if err != nil || len(all) != len(ids) {
+		t.Error("Expected", len(ids), "records after compaction, got", len(all), err)
+	}
+}
@@ -0,0 +1,53 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFileModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "ivy-perms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pdb, err := ivy.OpenDB(dir, ivy.WithFileModes(0640, 0750|os.ModeSetgid), ivy.WithExactModes(), ivy.WithAuditChain())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer pdb.Close()
+
+	if err := pdb.RegisterTable("notes", nil); err != nil {
+		t.Fatal("RegisterTable failed:", err)
+	}
+
+	id, err := pdb.Create("notes", Document{Title: "Shared"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(dir, "notes"):             os.ModeDir | os.ModeSetgid | 0750,
+		filepath.Join(dir, "notes", id+".json"): 0640,
+		filepath.Join(dir, ".ivy"):              os.ModeDir | os.ModeSetgid | 0750,
+		filepath.Join(dir, ".ivy", "audit.log"): 0640,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode() != want {
+			t.Error("Expected", path, "to have mode", want, "got", info.Mode())
+		}
+	}
+}
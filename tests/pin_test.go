@@ -0,0 +1,91 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tblName := range []string{"settings", "docs"} {
+		os.Mkdir(filepath.Join(dir, tblName), 0700)
+	}
+
+	fieldsToIndex := map[string][]string{"settings": {"title"}, "docs": {"title"}}
+
+	pdb, err := ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer pdb.Close()
+
+	settingId, err := pdb.Create("settings", Document{Title: "dark"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	docId, err := pdb.Create("docs", Document{Title: "Plan"})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if err := pdb.PinTable("settings"); err != nil {
+		t.Fatal("PinTable failed:", err)
+	}
+
+	if err := pdb.Pin("docs", docId); err != nil {
+		t.Fatal("Pin failed:", err)
+	}
+
+	// Pinned records are served from memory once their files are gone.
+	removeFiles := func() {
+		os.Remove(filepath.Join(dir, "settings", settingId+".json"))
+		os.Remove(filepath.Join(dir, "docs", docId+".json"))
+	}
+	removeFiles()
+
+	setting := Document{}
+	if err := pdb.Find("settings", &setting, settingId); err != nil || setting.Title != "dark" {
+		t.Error("Expected the pinned setting to be found, got", setting, err)
+	}
+
+	doc := Document{}
+	if err := pdb.Find("docs", &doc, docId); err != nil || doc.Title != "Plan" {
+		t.Error("Expected the pinned record to be found, got", doc, err)
+	}
+
+	if err := pdb.Update("settings", Document{Title: "light"}, settingId); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	removeFiles()
+
+	if err := pdb.Find("settings", &setting, settingId); err != nil || setting.Title != "light" {
+		t.Error("Expected the pinned setting to be refreshed by the update, got", setting, err)
+	}
+
+	if err := pdb.Unpin("docs"); err != nil {
+		t.Fatal("Unpin failed:", err)
+	}
+
+	if err := pdb.Find("docs", &doc, docId); !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected the unpinned record to be read from disk, got", err)
+	}
+
+	if err := pdb.Pin("docs", "../x"); !errors.Is(err, ivy.ErrInvalidID) {
+		t.Error("Expected ErrInvalidID for an invalid id, got", err)
+	}
+
+	_, err = ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(fieldsToIndex), ivy.WithPinnedTables("planes"))
+	if !errors.Is(err, ivy.ErrTableNotFound) {
+		t.Error("Expected ErrTableNotFound for a missing pinned table, got", err)
+	}
+}
@@ -0,0 +1,111 @@
+package ivy
+
+import (
+	"bytes"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRestoreToTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-pitr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "db")
+	archiveDir := filepath.Join(dir, "archive")
+
+	err = os.MkdirAll(filepath.Join(dbPath, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags"}}
+
+	db, err := ivy.OpenDBWithOptions(dbPath, fieldsToIndex, ivy.Options{
+		WAL: &ivy.WALOptions{NoSync: true, SegmentSize: 512, ArchiveDir: archiveDir},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	if _, err := db.Create("foos", Foo{Bar: "before backup", Tags: []string{"p"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	var buf bytes.Buffer
+
+	err = db.Backup(&buf)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.Create("foos", Foo{Bar: "good", Tags: []string{"p"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	// Checkpoints drop old segments from the live log, but not the archive.
+	err = db.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	good := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	// A bad bulk update.
+	for i := 1; i <= 6; i++ {
+		if err := db.Update("foos", Foo{Bar: "bad", Tags: []string{"p"}}, strconv.Itoa(i)); err != nil {
+			t.Fatal("Update failed:", err)
+		}
+	}
+	if err := db.Delete("foos", "1"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	restoredPath := filepath.Join(dir, "restored")
+
+	err = ivy.RestoreToTime(bytes.NewReader(buf.Bytes()), archiveDir, good, restoredPath)
+	if err != nil {
+		t.Fatal("RestoreToTime failed:", err)
+	}
+
+	restored, err := ivy.OpenDB(restoredPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer restored.Close()
+
+	ids, err := restored.FindAllIds("foos")
+	if err != nil || len(ids) != 6 {
+		t.Error("Expected 6 records, got", ids, err)
+	}
+
+	for _, fileId := range ids {
+		foo := Foo{}
+		err = restored.Find("foos", &foo, fileId)
+		if err != nil || foo.Bar == "bad" {
+			t.Error("Expected record", fileId, "as it was before the bad update, got", foo.Bar, err)
+		}
+	}
+
+	// Restoring to a time before the backup is refused.
+	err = ivy.RestoreToTime(bytes.NewReader(buf.Bytes()), archiveDir, good.Add(-time.Hour), filepath.Join(dir, "early"))
+	if err == nil {
+		t.Error("Expected an error restoring to before the backup")
+	}
+}
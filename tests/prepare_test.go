@@ -0,0 +1,80 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	q, err := pdb.Prepare("planes", ivy.M{"enginetype": ivy.Param("engine"), "speed": ivy.M{"$gte": ivy.Param("min")}})
+	if err != nil {
+		t.Fatal("Prepare failed:", err)
+	}
+
+	if q.Index() != "enginetype" {
+		t.Errorf("Expected the query to look up the enginetype index, got %q", q.Index())
+	}
+
+	tests := []struct {
+		params ivy.M
+		ids    []string
+	}{
+		{ivy.M{"engine": "radial", "min": 300}, []string{"3", "5"}},
+		{ivy.M{"engine": "inline", "min": 400}, []string{"1"}},
+		{ivy.M{"engine": "jet", "min": 0}, []string{}},
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, test := range tests {
+				ids, err := q.Execute(test.params)
+				if err != nil {
+					t.Errorf("%v: Execute failed: %v", test.params, err)
+					continue
+				}
+
+				if len(ids) == 0 {
+					ids = []string{}
+				}
+
+				if !reflect.DeepEqual(ids, test.ids) {
+					t.Errorf("%v: expected %v, got %v", test.params, test.ids, ids)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if _, err := q.Execute(ivy.M{"engine": "radial"}); err == nil {
+		t.Error("Expected an error for a missing parameter")
+	}
+
+	in, err := pdb.Prepare("planes", ivy.M{"enginetype": ivy.M{"$in": ivy.Param("engines")}})
+	if err != nil {
+		t.Fatal("Prepare failed:", err)
+	}
+
+	ids, err := in.Execute(ivy.M{"engines": []string{"turboprop", "radial"}})
+	if err != nil || !reflect.DeepEqual(ids, []string{"3", "4", "5", "6"}) {
+		t.Errorf("Expected $in to bind a slice, got %v %v", ids, err)
+	}
+
+	if _, err := pdb.Filter("planes", ivy.M{"speed": ivy.Param("min")}); err == nil {
+		t.Error("Expected Filter to reject a parameter")
+	}
+
+	if _, err := pdb.Prepare("nosuchtable", ivy.M{}); err == nil {
+		t.Error("Expected Prepare to fail for a missing table")
+	}
+}
@@ -0,0 +1,201 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Plane struct {
+	Name       string            `json:"name"`
+	EngineType string            `json:"enginetype"`
+	Speed      float64           `json:"speed"`
+	Military   bool              `json:"military"`
+	Maker      map[string]string `json:"maker,omitempty"`
+	Tags       []string          `json:"tags"`
+}
+
+func (p *Plane) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func openPlanes(t *testing.T) *ivy.DB {
+	pdb, err := ivy.OpenMemDB(map[string][]string{"planes": {"tags", "enginetype"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+
+	planes := []Plane{
+		{Name: "P-51 Mustang", EngineType: "inline", Speed: 437, Military: true, Maker: map[string]string{"country": "US"}},
+		{Name: "Spitfire", EngineType: "inline", Speed: 370, Military: true, Maker: map[string]string{"country": "UK"}},
+		{Name: "F4U Corsair", EngineType: "radial", Speed: 446, Military: true, Maker: map[string]string{"country": "US"}},
+		{Name: "Piper Cub", EngineType: "radial", Speed: 87},
+		{Name: "Zero", EngineType: "radial", Speed: 331, Military: true},
+		{Name: "Pilatus PC-6", EngineType: "turboprop", Speed: 174},
+	}
+
+	for _, plane := range planes {
+		plane.Tags = []string{}
+		if _, err := pdb.Create("planes", plane); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	return pdb
+}
+
+func TestQueryString(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"enginetype = 'radial' AND speed > 300 ORDER BY speed DESC LIMIT 5", []string{"3", "5"}},
+		{"", []string{"1", "2", "3", "4", "5", "6"}},
+		{"ORDER BY speed LIMIT 2 OFFSET 1", []string{"6", "5"}},
+		{"speed >= 370 and speed <= 437", []string{"1", "2"}},
+		{"enginetype = 'radial' OR NOT military = true", []string{"3", "4", "5", "6"}},
+		{"(enginetype = 'inline' OR enginetype = 'turboprop') AND speed < 400", []string{"2", "6"}},
+		{"maker.country = 'US' ORDER BY name", []string{"3", "1"}},
+		{"maker = NULL", []string{"4", "5", "6"}},
+		{"maker.country <> 'US'", []string{"2", "4", "5", "6"}},
+		{"name = 'Spitfire' AND speed = '370'", []string{}},
+		{`"enginetype" = 'jet'`, []string{}},
+		{"ORDER BY maker.country DESC, speed DESC", []string{"3", "1", "2", "5", "6", "4"}},
+		{"LIMIT 10 OFFSET 6", []string{}},
+	}
+
+	for _, test := range tests {
+		ids, err := pdb.QueryString("planes", test.query)
+		if err != nil {
+			t.Errorf("QueryString(%q) failed: %v", test.query, err)
+			continue
+		}
+
+		if len(ids) == 0 && len(test.ids) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("QueryString(%q): expected %v, got %v", test.query, test.ids, ids)
+		}
+	}
+}
+
+func TestQueryStringErrors(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	tests := map[string]string{
+		"speed >":                     "expected a value at end of query",
+		"speed > 300 AND":             "expected a condition at end of query",
+		"name = 'Zero":                "unterminated quote at position 8",
+		"speed ! 3":                   "unexpected ! at position 7",
+		"(speed > 3":                  "expected ) at end of query",
+		"speed > 3 LIMIT -1":          "expected a non-negative integer at position 17",
+		"speed > 3 ORDER speed":       "expected BY at position 17",
+		"speed > 3 speed < 4":         `unexpected "speed" at position 11`,
+		"name = 'Zero' ORDER BY 'x'":  "expected a field name at position 24",
+		"name LIKE 'Z%'":              "expected a comparison operator at position 6",
+		"speed > 1.2.3":               `invalid number "1.2.3" at position 9`,
+		"speed > 300 ORDER BY speed,": "expected a field name at end of query",
+	}
+
+	for query, msg := range tests {
+		_, err := pdb.QueryString("planes", query)
+		if err == nil || !strings.HasSuffix(err.Error(), msg) {
+			t.Errorf("QueryString(%q): expected error ending in %q, got %v", query, msg, err)
+		}
+	}
+
+	if _, err := pdb.QueryString("trains", ""); err == nil {
+		t.Error("Expected an error for a missing table")
+	}
+}
+
+func TestQueryStringPlaceholders(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	if _, err := pdb.Create("planes", Plane{Name: "O'Brien's ' OR name = 'Zero", EngineType: "radial", Speed: 120, Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	tests := []struct {
+		query string
+		args  []interface{}
+		ids   []string
+	}{
+		{"enginetype = ? AND speed > ?", []interface{}{"radial", 300}, []string{"3", "5"}},
+		{"name = ?", []interface{}{"O'Brien's ' OR name = 'Zero"}, []string{"7"}},
+		{"name = ?", []interface{}{"Zero' OR name = 'Spitfire"}, nil},
+		{"military = ? AND maker = ?", []interface{}{false, nil}, []string{"4", "6", "7"}},
+		{"speed >= ? ORDER BY speed DESC", []interface{}{437.0}, []string{"3", "1"}},
+	}
+
+	for _, test := range tests {
+		ids, err := pdb.QueryString("planes", test.query, test.args...)
+		if err != nil {
+			t.Errorf("%q %v: QueryString failed: %v", test.query, test.args, err)
+			continue
+		}
+
+		if len(ids) == 0 {
+			ids = nil
+		}
+
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%q %v: expected %v, got %v", test.query, test.args, test.ids, ids)
+		}
+	}
+
+	plan, err := pdb.Explain("planes", "enginetype = ?", "radial")
+	if err != nil || plan.Index != "field" {
+		t.Errorf("Expected a bound placeholder to use the index, got %v %v", plan, err)
+	}
+
+	for _, args := range [][]interface{}{{}, {"radial", "extra"}, {[]string{"radial"}}} {
+		if _, err := pdb.QueryString("planes", "enginetype = ?", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestQueryStringWithLimits(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	tests := []struct {
+		query     string
+		limits    ivy.QueryLimits
+		ids       []string
+		scanned   int
+		truncated bool
+	}{
+		{"speed > 300", ivy.QueryLimits{}, []string{"1", "2", "3", "5"}, 6, false},
+		{"speed > 300", ivy.QueryLimits{MaxScanned: 3}, []string{"1", "2", "3"}, 3, true},
+		{"speed > 300", ivy.QueryLimits{MaxResults: 2}, []string{"1", "2"}, 2, true},
+		{"speed > 300 ORDER BY speed", ivy.QueryLimits{MaxResults: 3}, []string{"2", "1", "3"}, 3, true},
+		{"enginetype = 'radial'", ivy.QueryLimits{MaxScanned: 3}, []string{"3", "4", "5"}, 3, false},
+		{"speed > 300", ivy.QueryLimits{MaxScanned: 6}, []string{"1", "2", "3", "5"}, 6, false},
+	}
+
+	for _, test := range tests {
+		res, err := pdb.QueryStringWithLimits("planes", test.query, test.limits)
+		if err != nil {
+			t.Errorf("%q %+v: QueryStringWithLimits failed: %v", test.query, test.limits, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res.Ids, test.ids) || res.Scanned != test.scanned || res.Truncated != test.truncated {
+			t.Errorf("%q %+v: expected %v %d %v, got %+v", test.query, test.limits, test.ids, test.scanned, test.truncated, res)
+		}
+	}
+
+	res, err := pdb.QueryStringWithLimits("planes", "speed > ?", ivy.QueryLimits{Timeout: time.Nanosecond}, 0)
+	if err != nil || !res.Truncated || len(res.Ids) == 6 {
+		t.Errorf("Expected the timeout to truncate the result, got %+v %v", res, err)
+	}
+}
@@ -0,0 +1,173 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"strings"
+	"testing"
+)
+
+func TestQuotaReject(t *testing.T) {
+	qdb, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": {"tags"}}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Quotas:  map[string]ivy.Quota{"foos": {MaxRecords: 2}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer qdb.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := qdb.Create("foos", Foo{Bar: "fits", Tags: []string{}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	_, err = qdb.Create("foos", Foo{Bar: "too many", Tags: []string{}})
+	if !errors.Is(err, ivy.ErrQuotaExceeded) {
+		t.Fatal("Expected ErrQuotaExceeded, got", err)
+	}
+
+	var quotaErr *ivy.QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Table != "foos" || quotaErr.Records != 2 {
+		t.Error("Expected a QuotaError for foos holding 2 records, got", err)
+	}
+
+	// Deleting a record makes room again.
+	if err := qdb.Delete("foos", "1"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+	if _, err := qdb.Create("foos", Foo{Bar: "fits again", Tags: []string{}}); err != nil {
+		t.Error("Expected Create to succeed after Delete, got", err)
+	}
+}
+
+func TestQuotaEvictOldest(t *testing.T) {
+	qdb, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": {"tags"}}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Quotas:  map[string]ivy.Quota{"foos": {MaxBytes: 150, Eviction: ivy.EvictOldest}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer qdb.Close()
+
+	// Each record marshals to roughly 40 bytes, so only the last few fit.
+	for i := 0; i < 10; i++ {
+		if _, err := qdb.Create("foos", Foo{Bar: "cached", Tags: []string{"c"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	ids, err := qdb.FindAllIds("foos")
+	if err != nil {
+		t.Fatal("FindAllIds failed:", err)
+	}
+	if len(ids) == 0 || len(ids) >= 10 {
+		t.Fatal("Expected older records to be evicted, got", ids)
+	}
+
+	foo := Foo{}
+	if err := qdb.Find("foos", &foo, "10"); err != nil {
+		t.Error("Expected newest record to be kept, got", err)
+	}
+	if err := qdb.Find("foos", &foo, "1"); err == nil {
+		t.Error("Expected oldest record to be evicted")
+	}
+
+	tagged, err := qdb.FindAllIdsForTags("foos", []string{"c"})
+	if err != nil || len(tagged) != len(ids) {
+		t.Error("Expected evicted records to leave the tag index, got", tagged, err)
+	}
+
+	_, err = qdb.Create("foos", Foo{Bar: string(make([]byte, 200)), Tags: []string{}})
+	if !errors.Is(err, ivy.ErrQuotaExceeded) {
+		t.Error("Expected a record larger than the quota to be rejected, got", err)
+	}
+}
+
+func TestQuotaUpdate(t *testing.T) {
+	qdb, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": {"tags"}}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Quotas:  map[string]ivy.Quota{"foos": {MaxRecords: 1, MaxBytes: 100}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer qdb.Close()
+
+	id, err := qdb.Create("foos", Foo{Bar: "fits", Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	// Updating a record only counts its growth.
+	if err := qdb.Update("foos", Foo{Bar: "still fits", Tags: []string{}}, id); err != nil {
+		t.Error("Expected Update within the quota to succeed, got", err)
+	}
+
+	err = qdb.Update("foos", Foo{Bar: strings.Repeat("x", 100), Tags: []string{}}, id)
+	if !errors.Is(err, ivy.ErrQuotaExceeded) {
+		t.Error("Expected growing a record past MaxBytes to fail with ErrQuotaExceeded, got", err)
+	}
+
+	// Updating a missing record adds one.
+	err = qdb.Update("foos", Foo{Bar: "new", Tags: []string{}}, "2")
+	if !errors.Is(err, ivy.ErrQuotaExceeded) {
+		t.Error("Expected Update of a missing record past MaxRecords to fail with ErrQuotaExceeded, got", err)
+	}
+
+	foo := Foo{}
+	if err := qdb.Find("foos", &foo, id); err != nil || foo.Bar != "still fits" {
+		t.Error("Expected the record to keep its last version, got", foo.Bar, err)
+	}
+}
+
+func TestQuotaEvictInTransaction(t *testing.T) {
+	qdb, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": {"tags"}}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Quotas:  map[string]ivy.Quota{"foos": {MaxRecords: 2, Eviction: ivy.EvictOldest}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer qdb.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := qdb.Create("foos", Foo{Bar: "kept", Tags: []string{}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	errAbort := errors.New("abort")
+
+	err = qdb.Transact(context.Background(), []string{"foos"}, func(tx *ivy.Tx) error {
+		if _, err := tx.Create("foos", Foo{Bar: "evicts", Tags: []string{}}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatal("Expected the transaction to abort, got", err)
+	}
+
+	// The eviction is undone with the write that caused it.
+	foo := Foo{}
+	if err := qdb.Find("foos", &foo, "1"); err != nil || foo.Bar != "kept" {
+		t.Error("Expected the evicted record to be back, got", foo.Bar, err)
+	}
+
+	ids, err := qdb.FindAllIds("foos")
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected the two records from before the transaction, got", ids, err)
+	}
+
+	// The usage is back as it was, so the next write evicts again.
+	if _, err := qdb.Create("foos", Foo{Bar: "evicts", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if err := qdb.Find("foos", &foo, "1"); err == nil {
+		t.Error("Expected the oldest record to be evicted")
+	}
+}
@@ -0,0 +1,47 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"strings"
+	"testing"
+)
+
+func TestMaxRecordSize(t *testing.T) {
+	sdb, err := ivy.OpenDBWithOptions("", map[string][]string{"foos": {"tags"}}, ivy.Options{
+		Storage:       ivy.MemoryStorage,
+		MaxRecordSize: 100,
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer sdb.Close()
+
+	id, err := sdb.Create("foos", Foo{Bar: "small", Tags: []string{"s"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	blob := strings.Repeat("x", 1000)
+
+	_, err = sdb.Create("foos", Foo{Bar: blob, Tags: []string{"s"}})
+	if !errors.Is(err, ivy.ErrRecordTooLarge) {
+		t.Error("Expected Create to return ErrRecordTooLarge, got", err)
+	}
+
+	err = sdb.Update("foos", Foo{Bar: blob, Tags: []string{"s"}}, id)
+	if !errors.Is(err, ivy.ErrRecordTooLarge) {
+		t.Error("Expected Update to return ErrRecordTooLarge, got", err)
+	}
+
+	foo := Foo{}
+	err = sdb.Find("foos", &foo, id)
+	if err != nil || foo.Bar != "small" {
+		t.Error("Expected the rejected Update to leave the record alone, got", foo.Bar, err)
+	}
+
+	ids, err := sdb.FindAllIds("foos")
+	if err != nil || len(ids) != 1 {
+		t.Error("Expected the rejected Create not to add a record, got", ids, err)
+	}
+}
@@ -0,0 +1,216 @@
+package ivy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policies := map[string]ivy.ExportPolicy{
+		"contacts": {Drop: []string{"age", "address.street"}, Hash: []string{"name"}, Salt: "pepper"},
+	}
+
+	rdb, err := ivy.OpenDBWithOptions("", map[string][]string{"contacts": {"tags"}},
+		ivy.Options{Storage: ivy.MemoryStorage, ExportPolicies: policies})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer rdb.Close()
+
+	_, err = rdb.Create("contacts", Contact{Name: "ann@example.com", Age: 30,
+		Address: map[string]string{"street": "Main St 1", "city": "Oslo"}, Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	sum := sha256.Sum256([]byte("pepperann@example.com"))
+	hashed := hex.EncodeToString(sum[:])
+
+	// Records read from the database are left alone.
+	contact := Contact{}
+	err = rdb.Find("contacts", &contact, "1")
+	if err != nil || contact.Name != "ann@example.com" || contact.Age != 30 {
+		t.Error("Expected the original record, got", contact, err)
+	}
+
+	exports := map[string]func(w *bytes.Buffer) error{
+		"ExportTable": func(w *bytes.Buffer) error { return rdb.ExportTable("contacts", w) },
+		"ExportJSON":  func(w *bytes.Buffer) error { return rdb.ExportJSON(w) },
+		"ExportCSV":   func(w *bytes.Buffer) error { return rdb.ExportCSV("contacts", w) },
+		"Stream":      func(w *bytes.Buffer) error { return rdb.Stream("contacts", []string{"1"}, w, ivy.StreamNDJSON) },
+	}
+
+	for name, export := range exports {
+		var buf bytes.Buffer
+
+		err = export(&buf)
+		if err != nil {
+			t.Fatal(name, "failed:", err)
+		}
+
+		out := buf.String()
+		if strings.Contains(out, "ann@") || strings.Contains(out, "age") || strings.Contains(out, "Main") ||
+			!strings.Contains(out, hashed) || !strings.Contains(out, "Oslo") {
+			t.Errorf("Expected %s to be redacted, got %s", name, out)
+		}
+	}
+
+	// Backups are redacted too.
+	var buf bytes.Buffer
+
+	err = rdb.Backup(&buf)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	dbPath := filepath.Join(dir, "restored")
+
+	err = ivy.RestoreBackup(&buf, dbPath)
+	if err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+
+	restored, err := ivy.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer restored.Close()
+
+	contact = Contact{}
+	err = restored.Find("contacts", &contact, "1")
+	if err != nil || contact.Name != hashed || contact.Age != 0 || contact.Address["street"] != "" ||
+		contact.Address["city"] != "Oslo" {
+		t.Error("Expected a redacted backup, got", contact, err)
+	}
+}
+
+func TestReadPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-restrict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "contacts"), 0700)
+
+	policy := ivy.WithReadPolicy("contacts", ivy.ReadPolicy{Drop: []string{"age"}, Mask: map[string]int{"name": 4, "address.city": 0}})
+
+	pdb, err := ivy.OpenDB(dir, policy)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer pdb.Close()
+
+	_, err = pdb.Create("contacts", Contact{Name: "ann@example.com", Age: 30,
+		Address: map[string]string{"city": "Oslo"}, Tags: []string{}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	// Without the restricted profile, records are read as they are.
+	contact := Contact{}
+	err = pdb.Find("contacts", &contact, "1")
+	if err != nil || contact.Name != "ann@example.com" || contact.Age != 30 {
+		t.Error("Expected the original record, got", contact, err)
+	}
+
+	rdb, err := ivy.OpenDB(dir, ivy.WithOptions(ivy.Options{NoLock: true}), policy, ivy.WithRestricted())
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	contact = Contact{}
+	err = rdb.Find("contacts", &contact, "1")
+	if err != nil || contact.Name != "***********.com" || contact.Age != 0 || contact.Address["city"] != "****" {
+		t.Error("Expected a masked record, got", contact, err)
+	}
+
+	recs, err := rdb.Select("name").FindMany("contacts", []string{"1"})
+	if err != nil || len(recs) != 1 || recs[0]["name"] != "***********.com" {
+		t.Error("Expected Select to mask the name, got", recs, err)
+	}
+
+	var buf bytes.Buffer
+	if err := rdb.ExportTable("contacts", &buf); err != nil {
+		t.Fatal("ExportTable failed:", err)
+	}
+	if strings.Contains(buf.String(), "ann@") || strings.Contains(buf.String(), "Oslo") {
+		t.Error("Expected the export to be masked, got", buf.String())
+	}
+
+	ids, err := rdb.FindAllIdsForField("contacts", "name", "ann@example.com")
+	if err != nil || len(ids) != 1 {
+		t.Error("Expected searches to match the original value, got", ids, err)
+	}
+
+	err = rdb.Update("contacts", contact, "1")
+	if !errors.Is(err, ivy.ErrReadOnly) {
+		t.Error("Expected Update of a masked record to fail with ErrReadOnly, got", err)
+	}
+}
+
+func TestReadPoliciesChangeFeeds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-restrict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "payments"), 0700)
+
+	rdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"payments": nil}, ivy.Options{
+		WAL:          &ivy.WALOptions{},
+		EventTables:  []string{"payments"},
+		ReadPolicies: map[string]ivy.ReadPolicy{"payments": {Mask: map[string]int{"card": 4}}},
+		Restricted:   true,
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer rdb.Close()
+
+	if _, err := rdb.Create("payments", ivy.M{"card": "4111111111111111"}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	events, err := rdb.EventsSince("payments", 0, 0)
+	if err != nil || len(events) != 1 {
+		t.Fatal("EventsSince failed:", events, err)
+	}
+	if data := string(events[0].Data); !strings.Contains(data, "************1111") || strings.Contains(data, "4111111111111111") {
+		t.Error("Expected EventsSince to mask the card, got", data)
+	}
+
+	for _, tblName := range []string{"payments", ""} {
+		if _, err := rdb.Watch(tblName, ivy.WatchOptions{}); !errors.Is(err, ivy.ErrReadOnly) {
+			t.Errorf("Expected Watch(%q) of a masked table to fail with ErrReadOnly, got %v", tblName, err)
+		}
+	}
+
+	if _, err := rdb.Changes(0, 0); !errors.Is(err, ivy.ErrReadOnly) {
+		t.Error("Expected Changes to fail with ErrReadOnly, got", err)
+	}
+
+	rec := httptest.NewRecorder()
+	rdb.ReplicationHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?since=0", nil))
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "4111111111111111") {
+		t.Error("Expected the replication handler to refuse, got", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,99 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tblDir := filepath.Join(dir, "foos")
+
+	err = os.Mkdir(tblDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"1.json": `{"bar":"ok","tags":["x"]}`,
+		"2.json": `{"bar":"trunc","tags":["x","y`,
+		"3.json": `{"bar":"half","tags":["x"],"fileid":`,
+		"4.json": `garbage`,
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(tblDir, name), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rdb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"foos": {"tags", "bar"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	// OpenDB already cleans up temporary files; this one shows up later.
+	err = ioutil.WriteFile(filepath.Join(tblDir, "1.json.tmp-abcdef"), []byte(`{"bar":"ok"`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := rdb.Repair("foos", ivy.RepairOptions{Recover: true, DryRun: true})
+	if err != nil {
+		t.Fatal("Repair failed:", err)
+	}
+	if len(report.Recovered) != 2 || len(report.Quarantined) != 1 {
+		t.Error("Expected 2 recoverable and 1 quarantinable record, got", report)
+	}
+	if _, err := os.Stat(filepath.Join(tblDir, "4.json")); err != nil {
+		t.Error("Expected a dry run not to touch the table:", err)
+	}
+
+	report, err = rdb.Repair("foos", ivy.RepairOptions{Recover: true})
+	if err != nil {
+		t.Fatal("Repair failed:", err)
+	}
+	if report.Checked != 4 {
+		t.Error("Expected 4 checked records, got", report.Checked)
+	}
+	if len(report.TempFiles) != 1 {
+		t.Error("Expected 1 temporary file to be removed, got", report.TempFiles)
+	}
+	if len(report.Quarantined) != 1 || report.Quarantined[0] != "4" {
+		t.Error("Expected record 4 to be quarantined, got", report.Quarantined)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".ivy", "quarantine", "foos", "4.json")); err != nil {
+		t.Error("Expected record 4 in the quarantine directory:", err)
+	}
+
+	foo := Foo{}
+	err = rdb.Find("foos", &foo, "2")
+	if err != nil || foo.Bar != "trunc" || len(foo.Tags) != 2 || foo.Tags[1] != "y" {
+		t.Error("Expected record 2 to be recovered, got", foo, err)
+	}
+
+	err = rdb.Find("foos", &foo, "3")
+	if err != nil || foo.Bar != "half" {
+		t.Error("Expected record 3 to be recovered, got", foo, err)
+	}
+
+	ids, err := rdb.FindAllIdsForTags("foos", []string{"x"})
+	if err != nil || len(ids) != 3 {
+		t.Error("Expected rebuilt tag index to return 3 ids, got", ids, err)
+	}
+
+	ids, err = rdb.FindAllIdsForField("foos", "bar", "half")
+	if err != nil || len(ids) != 1 || ids[0] != "3" {
+		t.Error("Expected rebuilt field index to return 3, got", ids, err)
+	}
+}
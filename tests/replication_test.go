@@ -0,0 +1,196 @@
+package ivy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	primaryPath := filepath.Join(dir, "primary")
+
+	err = os.MkdirAll(filepath.Join(primaryPath, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+
+	primary, err := ivy.OpenDBWithOptions(primaryPath, fieldsToIndex, ivy.Options{
+		Checksums: true,
+		WAL:       &ivy.WALOptions{NoSync: true, KeepSegments: true},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer primary.Close()
+
+	for _, bar := range []string{"one", "two"} {
+		if _, err := primary.Create("foos", Foo{Bar: bar, Tags: []string{"r"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	// Seed the follower from a backup.
+	var buf bytes.Buffer
+
+	err = primary.Backup(&buf)
+	if err != nil {
+		t.Fatal("Backup failed:", err)
+	}
+
+	manifest, err := ivy.ReadBackupManifest(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("ReadBackupManifest failed:", err)
+	}
+	if manifest.LSN == 0 {
+		t.Fatal("Expected manifest to record an LSN")
+	}
+
+	followerPath := filepath.Join(dir, "follower")
+
+	err = ivy.RestoreBackup(bytes.NewReader(buf.Bytes()), followerPath)
+	if err != nil {
+		t.Fatal("RestoreBackup failed:", err)
+	}
+
+	// Changes made after the backup reach the follower.
+	if _, err := primary.Create("foos", Foo{Bar: "three", Tags: []string{"r"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if err := primary.Update("foos", Foo{Bar: "uno", Tags: []string{"r"}}, "1"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := primary.Delete("foos", "2"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	server := httptest.NewServer(primary.ReplicationHandler())
+	defer server.Close()
+
+	follower, err := ivy.OpenDB(followerPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	src := &ivy.HTTPReplicationSource{URL: server.URL}
+
+	f, err := follower.Follow(src, ivy.FollowOptions{From: manifest.LSN, Interval: time.Hour, BatchSize: 2})
+	if err != nil {
+		t.Fatal("Follow failed:", err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	ids, err := follower.FindAllIdsForTags("foos", []string{"r"})
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected 2 records in follower, got", ids, err)
+	}
+
+	ids, err = follower.FindAllIdsForField("foos", "bar", "uno")
+	if err != nil || len(ids) != 1 || ids[0] != "1" {
+		t.Error("Expected updated record in follower, got", ids, err)
+	}
+
+	if _, err := follower.Create("foos", Foo{Bar: "x", Tags: []string{}}); !errors.Is(err, ivy.ErrFollower) {
+		t.Error("Expected ErrFollower, got", err)
+	}
+
+	position := f.Position()
+
+	f.Stop()
+
+	err = follower.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	// A restarted follower resumes from where it stopped.
+	follower, err = ivy.OpenDB(followerPath, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer follower.Close()
+
+	f, err = follower.Follow(primary, ivy.FollowOptions{Interval: time.Hour})
+	if err != nil {
+		t.Fatal("Follow failed:", err)
+	}
+
+	if f.Position() != position {
+		t.Error("Expected follower to resume from", position, "got", f.Position())
+	}
+
+	if _, err := primary.Create("foos", Foo{Bar: "four", Tags: []string{"r"}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	foo := Foo{}
+	err = follower.Find("foos", &foo, "4")
+	if err != nil || foo.Bar != "four" {
+		t.Error("Expected record 4 in follower, got", foo.Bar, err)
+	}
+
+	// Once stopped, the follower can be written to.
+	f.Stop()
+
+	if _, err := follower.Create("foos", Foo{Bar: "five", Tags: []string{}}); err != nil {
+		t.Error("Expected Create to succeed after Stop, got", err)
+	}
+}
+
+func TestReplicationGap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-replication-gap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags"}}, ivy.Options{
+		WAL: &ivy.WALOptions{NoSync: true, SegmentSize: 256},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.Create("foos", Foo{Bar: "gap", Tags: []string{"g"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	err = db.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	if _, err := db.Changes(0, 0); !errors.Is(err, ivy.ErrReplicationGap) {
+		t.Error("Expected ErrReplicationGap, got", err)
+	}
+}
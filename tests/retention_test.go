@@ -0,0 +1,105 @@
+package ivy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "notes"), 0700)
+
+	dest := filepath.Join(dir, "cold")
+
+	rdb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"notes": {"title", "created_at@time"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer rdb.Close()
+
+	day := 24 * time.Hour
+	ages := []time.Duration{3 * 365 * day, 3 * 365 * day, 365 * day, 10 * day}
+
+	var ids []string
+	for i, age := range ages {
+		rec := fmt.Sprintf(`{"title": "n%d", "created_at": %q}`, i, time.Now().Add(-age).UTC().Format(time.RFC3339))
+
+		id, err := rdb.Create("notes", json.RawMessage(rec))
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if n, err := rdb.Archive("notes", ivy.M{"title": "n0"}, dest); err != nil || n != 1 {
+		t.Fatal("Archive failed:", n, err)
+	}
+
+	policy := ivy.RetentionPolicy{
+		Table:        "notes",
+		Field:        "created_at",
+		ArchiveAfter: 180 * day,
+		DeleteAfter:  2 * 365 * day,
+		Dest:         dest,
+		DryRun:       true,
+	}
+
+	want := ivy.RetentionReport{
+		Table:    "notes",
+		DryRun:   true,
+		Archived: []string{ids[2]},
+		Deleted:  []string{ids[1]},
+		Pruned:   []string{ids[0]},
+	}
+
+	report, err := rdb.ApplyRetention(policy)
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Fatalf("Expected the dry run to report %+v, got %+v, %v", want, report, err)
+	}
+
+	if left, _ := rdb.FindAllIdsForFieldBetweenTimes("notes", "created_at", time.Time{}, time.Time{}); len(left) != 3 {
+		t.Error("Expected the dry run to leave the table alone, got", left)
+	}
+
+	policy.DryRun = false
+	want.DryRun = false
+
+	report, err = rdb.ApplyRetention(policy)
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Fatalf("Expected the policy to report %+v, got %+v, %v", want, report, err)
+	}
+
+	if left, _ := rdb.FindAllIdsForFieldBetweenTimes("notes", "created_at", time.Time{}, time.Time{}); !reflect.DeepEqual(left, ids[3:]) {
+		t.Error("Expected only the recent record to stay in the table, got", left)
+	}
+
+	archive, err := ivy.OpenArchive(dest)
+	if err != nil {
+		t.Fatal("OpenArchive failed:", err)
+	}
+
+	if archived, err := archive.Ids("notes"); err != nil || !reflect.DeepEqual(archived, ids[2:3]) {
+		t.Error("Expected only the year-old record in the archive, got", archived, err)
+	}
+
+	report, err = rdb.ApplyRetention(policy)
+	if err != nil || len(report.Archived)+len(report.Deleted)+len(report.Pruned) != 0 {
+		t.Error("Expected nothing more to do, got", report, err)
+	}
+
+	if _, err := rdb.ApplyRetention(ivy.RetentionPolicy{Table: "notes", Field: "created_at", ArchiveAfter: day}); err == nil {
+		t.Error("Expected a policy archiving without a directory to be rejected")
+	}
+}
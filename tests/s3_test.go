@@ -0,0 +1,244 @@
+package ivy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestS3FileSystem(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "ivy-s3-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	fs := &ivy.S3FileSystem{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "test",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		CacheDir:        cacheDir,
+	}
+
+	err = fs.MkdirAll("data/foos", 0700)
+	if err != nil {
+		t.Fatal("MkdirAll failed:", err)
+	}
+
+	s3db, err := ivy.OpenDBWithOptions("data", map[string][]string{"foos": {"tags", "bar"}}, ivy.Options{FileSystem: fs})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer s3db.Close()
+
+	id, err := s3db.Create("foos", Foo{Bar: "s3", Tags: []string{"cloud"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if _, ok := fake.objects["data/foos/"+id+".json"]; !ok {
+		t.Error("Expected record object to be stored in the bucket")
+	}
+
+	foo := Foo{}
+	err = s3db.Find("foos", &foo, id)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	if foo.Bar != "s3" {
+		t.Error("Expected 's3', got ", foo.Bar)
+	}
+
+	ids, err := s3db.FindAllIdsForTags("foos", []string{"cloud"})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Error("Expected tag search to return", id, "got", ids, err)
+	}
+
+	// Creating a second record rebuilds the indexes; the first record should
+	// come from the local cache rather than the bucket.
+	gets := fake.objectGets
+	_, err = s3db.Create("foos", Foo{Bar: "s3-2", Tags: []string{"cloud"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if fake.objectGets != gets {
+		t.Error("Expected cached records not to be downloaded again, got", fake.objectGets-gets, "GETs")
+	}
+
+	err = s3db.Delete("foos", id)
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	err = s3db.Find("foos", &foo, id)
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected Find error to be ErrNotFound, got ", err)
+	}
+
+	for _, auth := range fake.auths {
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") {
+			t.Fatal("Expected signed request, got Authorization:", auth)
+		}
+	}
+}
+
+//=============================================================================
+// Fake S3 Server
+//=============================================================================
+
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	parts      map[string][][]byte
+	auths      []string
+	objectGets int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), parts: make(map[string][][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auths = append(f.auths, r.Header.Get("Authorization"))
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/test"), "/")
+
+	if key == "" && r.Method == "GET" {
+		f.list(w, r)
+		return
+	}
+
+	if f.multipart(w, r, key) {
+		return
+	}
+
+	data, ok := f.objects[key]
+	etag := etagOf(data)
+
+	switch r.Method {
+	case "GET":
+		f.objectGets++
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case "HEAD":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = body
+		w.Header().Set("ETag", etagOf(body))
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// multipart handles the requests of multipart uploads. It answers whether the
+// request was one of them.
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string) bool {
+	query := r.URL.Query()
+	uploadId := query.Get("uploadId")
+
+	switch {
+	case r.Method == "POST" && query.Has("uploads"):
+		f.parts[key] = nil
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == "PUT" && uploadId != "":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.parts[uploadId] = append(f.parts[uploadId], body)
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == "POST" && uploadId != "":
+		var data []byte
+		for _, part := range f.parts[uploadId] {
+			data = append(data, part...)
+		}
+		f.objects[key] = data
+		delete(f.parts, uploadId)
+	case r.Method == "DELETE" && uploadId != "":
+		delete(f.parts, uploadId)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	type content struct {
+		Key  string
+		ETag string
+		Size int64
+	}
+	var result struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Contents       []content
+		CommonPrefixes []struct{ Prefix string }
+	}
+
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rest := k[len(prefix):]
+		if i := strings.Index(rest, "/"); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+1]
+			if !seen[p] {
+				seen[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct{ Prefix string }{p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{Key: k, ETag: etagOf(f.objects[k]), Size: int64(len(f.objects[k]))})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,81 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSavedQueries(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	err := pdb.SaveQuery("fast", ivy.SavedQuery{Table: "planes", Query: "enginetype = ? AND speed >= ?"})
+	if err != nil {
+		t.Fatal("SaveQuery failed:", err)
+	}
+
+	ids, err := pdb.RunSaved("fast", "radial", 300)
+	if err != nil || !reflect.DeepEqual(ids, []string{"3", "5"}) {
+		t.Errorf("Expected [3 5], got %v %v", ids, err)
+	}
+
+	err = pdb.SaveQuery("fast", ivy.SavedQuery{Table: "planes", Query: "speed >= ?", Description: "Fast planes"})
+	if err != nil {
+		t.Fatal("SaveQuery failed:", err)
+	}
+
+	err = pdb.SaveQuery("all", ivy.SavedQuery{Table: "planes", Query: "speed >= 0"})
+	if err != nil {
+		t.Fatal("SaveQuery failed:", err)
+	}
+
+	queries, err := pdb.SavedQueries()
+	if err != nil || len(queries) != 2 || queries[0].Name != "all" || queries[1].Description != "Fast planes" {
+		t.Errorf("Unexpected saved queries %+v %v", queries, err)
+	}
+
+	if err := pdb.SaveQuery("broken", ivy.SavedQuery{Table: "planes", Query: "speed >="}); err == nil {
+		t.Error("Expected SaveQuery of an invalid query to fail")
+	}
+
+	if _, err := pdb.RunSaved("fast"); err == nil {
+		t.Error("Expected RunSaved without its arguments to fail")
+	}
+
+	srv := httptest.NewServer(pdb.AdminHandler())
+	defer srv.Close()
+
+	status, body := adminRequest(t, srv, "POST", "/api/queries/fast/run", "[400]")
+	if status != http.StatusOK || body != "[\"1\",\"3\"]\n" {
+		t.Errorf("Unexpected run %d %s", status, body)
+	}
+
+	status, _ = adminRequest(t, srv, "PUT", "/api/queries/slow", `{"table":"planes","query":"speed < ?"}`)
+	if status != http.StatusNoContent {
+		t.Errorf("Expected the query to be saved, got %d", status)
+	}
+
+	status, _ = adminRequest(t, srv, "DELETE", "/api/queries/all", "")
+	if status != http.StatusNoContent {
+		t.Errorf("Expected the query to be deleted, got %d", status)
+	}
+
+	status, body = adminRequest(t, srv, "GET", "/api/queries", "")
+	if status != http.StatusOK || !strings.HasPrefix(body, `[{"name":"fast",`) || !strings.Contains(body, `},{"name":"slow",`) {
+		t.Errorf("Unexpected saved queries %d %s", status, body)
+	}
+
+	status, _ = adminRequest(t, srv, "POST", "/api/queries/all/run", "")
+	if status != http.StatusNotFound {
+		t.Errorf("Expected a deleted query to be missing, got %d", status)
+	}
+
+	if _, err := pdb.RunSaved("all"); !errors.Is(err, ivy.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
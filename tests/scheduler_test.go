@@ -0,0 +1,86 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	ran := make(chan struct{}, 10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	stopped := make(chan error, 1)
+
+	jobs := []ivy.Job{
+		{Name: "tick", Interval: 10 * time.Millisecond, Jitter: 5 * time.Millisecond, Run: func(ctx context.Context, db *ivy.DB) error {
+			select {
+			case ran <- struct{}{}:
+			default:
+			}
+			return nil
+		}},
+		{Name: "slow", Interval: time.Hour, Run: func(ctx context.Context, db *ivy.DB) error {
+			started <- struct{}{}
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				stopped <- ctx.Err()
+				return ctx.Err()
+			}
+		}},
+	}
+
+	jdb, err := ivy.OpenDBWithOptions("", map[string][]string{"notes": nil}, ivy.Options{Storage: ivy.MemoryStorage, Jobs: jobs})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the job to run")
+		}
+	}
+
+	done := make(chan error)
+	go func() { done <- jdb.RunJob("slow") }()
+	<-started
+
+	if err := jdb.RunJob("slow"); !errors.Is(err, ivy.ErrConflict) {
+		t.Errorf("Expected ErrConflict for a running job, got %v", err)
+	}
+
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("Expected RunJob to succeed, got %v", err)
+	}
+
+	if err := jdb.RunJob("none"); !errors.Is(err, ivy.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Close cancels a running job and waits for it.
+	go jdb.RunJob("slow")
+	<-started
+
+	jdb.Close()
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the job to be cancelled, got %v", err)
+		}
+	default:
+		t.Error("Expected Close to wait for the running job")
+	}
+
+	_, err = ivy.OpenDBWithOptions("", nil, ivy.Options{Storage: ivy.MemoryStorage, Jobs: []ivy.Job{{Name: "bad"}}})
+	if err == nil {
+		t.Error("Expected a job without an interval to be rejected")
+	}
+}
@@ -0,0 +1,54 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	var plane Plane
+
+	err := pdb.Select("name", "maker.country").Find("planes", &plane, "3")
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	want := Plane{Name: "F4U Corsair", Maker: map[string]string{"country": "US"}}
+	if !reflect.DeepEqual(plane, want) {
+		t.Errorf("Expected %v, got %v", want, plane)
+	}
+
+	var m map[string]interface{}
+
+	err = pdb.Select("speed", "nosuchfield").Find("planes", &m, "2")
+	if err != nil || !reflect.DeepEqual(m, map[string]interface{}{"speed": 370.0}) {
+		t.Errorf("Expected just the speed, got %v %v", m, err)
+	}
+
+	recs, err := pdb.Select("name").Query("planes", "enginetype = ? ORDER BY speed DESC", "radial")
+	if err != nil {
+		t.Fatal("Query failed:", err)
+	}
+
+	names := []interface{}{}
+	for _, rec := range recs {
+		if len(rec) != 1 {
+			t.Errorf("Expected one field, got %v", rec)
+		}
+		names = append(names, rec["name"])
+	}
+
+	if !reflect.DeepEqual(names, []interface{}{"F4U Corsair", "Zero", "Piper Cub"}) {
+		t.Errorf("Unexpected names %v", names)
+	}
+
+	_, err = pdb.Select("name").FindMany("planes", []string{"1", "99"})
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
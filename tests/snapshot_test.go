@@ -0,0 +1,90 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	sdb, err := ivy.OpenMemDB(map[string][]string{"docs": {"title"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer sdb.Close()
+
+	aId, _ := sdb.Create("docs", Document{Title: "draft"})
+	bId, _ := sdb.Create("docs", Document{Title: "draft"})
+
+	if err := sdb.RegisterTable("notes", nil); err != nil {
+		t.Fatal("RegisterTable failed:", err)
+	}
+	noteId, _ := sdb.Create("notes", Document{Title: "unindexed"})
+
+	snap, err := sdb.Snapshot()
+	if err != nil {
+		t.Fatal("Snapshot failed:", err)
+	}
+
+	if err := sdb.Update("notes", Document{Title: "changed"}, noteId); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	if err := sdb.Update("docs", Document{Title: "final"}, aId); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := sdb.Delete("docs", bId); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+	if _, err := sdb.Create("docs", Document{Title: "final"}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	doc := Document{}
+	if err := snap.Find("docs", &doc, aId); err != nil || doc.Title != "draft" {
+		t.Error("Expected the snapshot to hold the record as it was, got", doc, err)
+	}
+
+	if err := snap.Find("docs", &doc, bId); err != nil || doc.Title != "draft" {
+		t.Error("Expected the snapshot to hold the deleted record, got", doc, err)
+	}
+
+	if err := snap.Find("notes", &doc, noteId); err != nil || doc.Title != "unindexed" {
+		t.Error("Expected the snapshot to hold the record of an unindexed table as it was, got", doc, err)
+	}
+
+	if names := snap.TableNames(); !reflect.DeepEqual(names, []string{"docs", "notes"}) {
+		t.Error("Expected the snapshot to hold every table, got", names)
+	}
+
+	want := []string{aId, bId}
+
+	if ids, err := snap.FindAllIds("docs"); err != nil || !reflect.DeepEqual(ids, want) {
+		t.Error("Expected the ids", want, "got", ids, err)
+	}
+
+	if ids, err := snap.FindAllIdsForField("docs", "title", "draft"); err != nil || len(ids) != 2 {
+		t.Error("Expected the index of the snapshot to be unchanged, got", ids, err)
+	}
+
+	if ids, err := snap.Filter("docs", ivy.M{"title": "draft"}); err != nil || !reflect.DeepEqual(ids, want) {
+		t.Error("Expected the filter to match the records as they were, got", ids, err)
+	}
+
+	if ids, err := snap.QueryString("docs", "title = ?", "final"); err != nil || len(ids) != 0 {
+		t.Error("Expected no record of the snapshot to be final, got", ids, err)
+	}
+
+	if ids, err := sdb.FindAllIdsForField("docs", "title", "final"); err != nil || len(ids) != 2 {
+		t.Error("Expected the database to see the writes, got", ids, err)
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	if err := snap.Find("docs", &doc, aId); !errors.Is(err, ivy.ErrClosed) {
+		t.Error("Expected ErrClosed after Close, got", err)
+	}
+}
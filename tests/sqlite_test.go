@@ -0,0 +1,168 @@
+package ivy
+
+import (
+	"fmt"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type User struct {
+	Id       int      `json:"id"`
+	Name     string   `json:"name"`
+	Email    *string  `json:"e-mail"`
+	Age      int      `json:"age"`
+	Score    float64  `json:"score"`
+	Avatar   []byte   `json:"avatar"`
+	Nickname *string  `json:"nickname"`
+	Tags     []string `json:"tags"`
+}
+
+func (u *User) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestImportFromSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ivy.ImportFromSQLite(filepath.Join("testdata", "app.sqlite"), dir, map[string]ivy.SQLiteTable{
+		"users": {TagsColumn: "labels"},
+		"notes": {Table: "memos"},
+	})
+	if err != nil {
+		t.Fatal("ImportFromSQLite failed:", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "kv")); !os.IsNotExist(err) {
+		t.Error("Expected unmapped table not to be imported")
+	}
+
+	db, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"users": {"tags", "name"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer db.Close()
+
+	ids, err := db.FindAllIds("users")
+	if err != nil || len(ids) != 61 {
+		t.Fatal("Expected 61 users, got", len(ids), err)
+	}
+
+	ids, err = db.FindAllIdsForTags("users", []string{"admin"})
+	if err != nil || len(ids) != 30 {
+		t.Error("Expected 30 admins, got", len(ids), err)
+	}
+
+	user := User{}
+	err = db.Find("users", &user, "3")
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if user.Id != 3 || user.Name != "user3" || *user.Email != "user3@example.com" || user.Age != -300000 ||
+		user.Score != 4.5 || string(user.Avatar) != "\x00\xff\x10" || user.Nickname != nil {
+		t.Errorf("Unexpected user 3: %+v", user)
+	}
+
+	// A row spilling onto overflow pages.
+	user = User{}
+	err = db.Find("users", &user, "5")
+	if err != nil || user.Name != strings.Repeat("ab", 2000) {
+		t.Error("Expected long name of user 5 to be imported, got", len(user.Name), err)
+	}
+
+	// A row written after a column was added.
+	user = User{}
+	err = db.Find("users", &user, "100")
+	if err != nil || user.Nickname == nil || *user.Nickname != "lee" || user.Email != nil || len(user.Tags) != 0 {
+		t.Errorf("Unexpected user 100: %+v (%v)", user, err)
+	}
+
+	ids, err = db.FindAllIdsForField("users", "name", "user42")
+	if err != nil || len(ids) != 1 || ids[0] != "42" {
+		t.Error("Expected user42 to be found by name, got", ids, err)
+	}
+
+	ids, err = db.FindAllIds("memos")
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected 2 memos, got", ids, err)
+	}
+
+	// Importing into tables that hold records is refused.
+	err = ivy.ImportFromSQLite(filepath.Join("testdata", "app.sqlite"), dir, map[string]ivy.SQLiteTable{"notes": {Table: "memos"}})
+	if err == nil {
+		t.Error("Expected an error importing into a non-empty table")
+	}
+}
+
+func TestExportToSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := ivy.OpenMemDB(map[string][]string{"users": {"tags"}, "notes": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	// Enough records, some of them long, to need interior and overflow pages.
+	for i := 1; i <= 2000; i++ {
+		name := fmt.Sprintf("user%d", i)
+		if i%500 == 0 {
+			name = strings.Repeat("xy", 5000)
+		}
+
+		_, err = db.Create("users", User{Name: name, Age: i * 1000, Score: float64(i) / 4, Tags: []string{"a", "b"}})
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	path := filepath.Join(dir, "export.sqlite")
+
+	err = db.ExportToSQLite(path)
+	if err != nil {
+		t.Fatal("ExportToSQLite failed:", err)
+	}
+
+	if err = db.ExportToSQLite(path); err == nil {
+		t.Error("Expected an error exporting over an existing file")
+	}
+
+	// The exported file reads back with the SQLite importer.
+	dbPath := filepath.Join(dir, "db")
+
+	err = ivy.ImportFromSQLite(path, dbPath, map[string]ivy.SQLiteTable{"users": {TagsColumn: "tags"}})
+	if err != nil {
+		t.Fatal("ImportFromSQLite failed:", err)
+	}
+
+	imported, err := ivy.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer imported.Close()
+
+	ids, err := imported.FindAllIds("users")
+	if err != nil || len(ids) != 2000 {
+		t.Fatal("Expected 2000 users, got", len(ids), err)
+	}
+
+	user := User{}
+	err = imported.Find("users", &user, "1500")
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+	if user.Name != strings.Repeat("xy", 5000) || user.Age != 1500000 || user.Score != 375 ||
+		user.Id != 0 || len(user.Tags) != 2 {
+		t.Errorf("Unexpected user 1500: %+v", user)
+	}
+}
@@ -0,0 +1,74 @@
+package ivy
+
+import (
+	"bytes"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStableOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "planes"), 0700)
+
+	for _, checksums := range []bool{false, true} {
+		opts := ivy.Options{StableOutput: &ivy.StableOutputOptions{Indent: "  "}, Checksums: checksums}
+
+		sdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"planes": {"name"}}, opts)
+		if err != nil {
+			t.Fatal("OpenDBWithOptions failed:", err)
+		}
+
+		plane := Plane{Name: "Spitfire <Mk I>", EngineType: "inline", Speed: 362.5, Maker: map[string]string{"name": "Supermarine", "country": "UK"}, Tags: []string{"ww2"}}
+
+		fileId, err := sdb.Create("planes", plane)
+		if err != nil {
+			t.Fatal("Create failed:", err)
+		}
+
+		path := filepath.Join(dir, "planes", fileId+".json")
+
+		before, _ := ioutil.ReadFile(path)
+
+		if !bytes.HasSuffix(before, []byte("}\n")) || !bytes.Contains(before, []byte("\n  \"enginetype\": \"inline\",\n")) {
+			t.Errorf("Expected an indented record ending with a newline, got %s", before)
+		}
+
+		if !bytes.Contains(before, []byte(`"Spitfire <Mk I>"`)) {
+			t.Errorf("Expected HTML characters to be kept, got %s", before)
+		}
+
+		// The same values, marshalled from a map in another order.
+		same := map[string]interface{}{
+			"tags":       []string{"ww2"},
+			"speed":      362.5,
+			"name":       "Spitfire <Mk I>",
+			"military":   false,
+			"maker":      map[string]string{"country": "UK", "name": "Supermarine"},
+			"enginetype": "inline",
+		}
+
+		if err := sdb.Update("planes", same, fileId); err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		after, _ := ioutil.ReadFile(path)
+		if !bytes.Equal(before, after) {
+			t.Errorf("Expected an unchanged record to keep its bytes, got\n%s\nthen\n%s", before, after)
+		}
+
+		found := Plane{}
+		if err := sdb.Find("planes", &found, fileId); err != nil || found.Maker["name"] != "Supermarine" {
+			t.Error("Expected the record to be readable, got", found, err)
+		}
+
+		sdb.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-stats")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tblName := range []string{"planes", "notes"} {
+		err = os.MkdirAll(filepath.Join(dir, tblName), 0700)
+		if err != nil {
+			t.Fatal("MkdirAll failed:", err)
+		}
+	}
+
+	db, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"planes": {"enginetype", "tags"}, "notes": nil}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer db.Close()
+
+	before := time.Now().Add(-time.Second)
+
+	db.Create("planes", Plane{Name: "Spitfire", EngineType: "piston", Tags: []string{"ww2", "raf"}})
+	db.Create("planes", Plane{Name: "Mustang", EngineType: "piston", Tags: []string{"ww2"}})
+	db.Create("planes", Plane{Name: "Meteor", EngineType: "jet", Tags: []string{}})
+
+	db.FindAllIdsForField("planes", "enginetype", "jet")
+	db.QueryString("planes", "speed > 100")
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal("Stats failed:", err)
+	}
+
+	planes := stats.Tables["planes"]
+
+	if planes.Records != 3 || planes.Bytes == 0 {
+		t.Errorf("Expected 3 records and their size, got %+v", planes)
+	}
+
+	if planes.IndexHitRate != 0.5 {
+		t.Errorf("Expected an index hit rate of 0.5, got %v", planes.IndexHitRate)
+	}
+
+	if planes.LastWrite.Before(before) {
+		t.Errorf("Expected the last write to be recent, got %v", planes.LastWrite)
+	}
+
+	expected := map[string]ivy.IndexStats{
+		"enginetype": {Field: "enginetype", Values: 2, Entries: 3},
+		"tags":       {Field: "tags", Values: 2, Entries: 3},
+	}
+
+	if len(planes.Indexes) != len(expected) {
+		t.Fatalf("Expected %d indexes, got %+v", len(expected), planes.Indexes)
+	}
+
+	for _, is := range planes.Indexes {
+		if is != expected[is.Field] {
+			t.Errorf("Expected %+v, got %+v", expected[is.Field], is)
+		}
+	}
+
+	notes, ok := stats.Tables["notes"]
+	if !ok || notes.Records != 0 || notes.Bytes != 0 || len(notes.Indexes) != 0 || notes.IndexHitRate != 0 {
+		t.Errorf("Expected an empty notes table, got %+v", notes)
+	}
+}
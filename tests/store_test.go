@@ -0,0 +1,76 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"testing"
+)
+
+// fakeStore keeps the records of one table in memory. The methods it does not
+// override panic, as they are left to the nil embedded Store.
+type fakeStore struct {
+	ivy.Store
+	recs map[string][]byte
+}
+
+func (s *fakeStore) Find(tblName string, rec ivy.Record, fileId string) error {
+	data, ok := s.recs[fileId]
+	if !ok {
+		return ivy.ErrNotFound
+	}
+
+	return json.Unmarshal(data, rec)
+}
+
+func (s *fakeStore) Update(tblName string, rec interface{}, fileId string) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.recs[fileId] = data
+
+	return nil
+}
+
+// renamePlane is application code depending on a Store.
+func renamePlane(store ivy.Store, fileId string, name string) error {
+	var plane Plane
+
+	err := store.Find("planes", &plane, fileId)
+	if err != nil {
+		return err
+	}
+
+	plane.Name = name
+
+	return store.Update("planes", &plane, fileId)
+}
+
+func TestStore(t *testing.T) {
+	fake := &fakeStore{recs: map[string][]byte{"1": []byte(`{"name":"Spitfire","tags":[]}`)}}
+
+	err := renamePlane(fake, "1", "Spitfire Mk IX")
+	if err != nil {
+		t.Fatal("renamePlane failed:", err)
+	}
+
+	if string(fake.recs["1"]) != `{"name":"Spitfire Mk IX","enginetype":"","speed":0,"military":false,"tags":[]}` {
+		t.Errorf("Unexpected record %s", fake.recs["1"])
+	}
+
+	pdb := openPlanes(t)
+	defer pdb.Close()
+
+	err = renamePlane(pdb, "2", "Spitfire Mk IX")
+	if err != nil {
+		t.Fatal("renamePlane failed:", err)
+	}
+
+	var plane Plane
+
+	err = pdb.Find("planes", &plane, "2")
+	if err != nil || plane.Name != "Spitfire Mk IX" {
+		t.Errorf("Expected the plane to be renamed, got %v %v", plane, err)
+	}
+}
@@ -0,0 +1,86 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"sort"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	db, err := ivy.OpenMemDB(map[string][]string{"contacts": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer db.Close()
+
+	db.Create("contacts", Contact{Name: "Ann", Age: 30, Tags: []string{"a", "x"}})
+	db.Create("contacts", Contact{Name: "Bob", Age: 41, Tags: []string{}})
+	db.Create("contacts", Contact{Name: "Cy", Age: 30, Address: map[string]string{"city": "Oslo"}, Tags: []string{"x"}})
+
+	ids, err := db.FindAllIdsForTags("contacts", []string{"x"})
+	if err != nil || len(ids) != 2 {
+		t.Fatal("Expected 2 ids, got", ids, err)
+	}
+	sort.Strings(ids)
+
+	// A record deleted after the query is left out.
+	ids = append(ids, "2")
+	db.Delete("contacts", "2")
+
+	var buf bytes.Buffer
+
+	err = db.Stream("contacts", ids, &buf, ivy.StreamJSON)
+	if err != nil {
+		t.Fatal("Stream failed:", err)
+	}
+
+	var recs []struct {
+		Id   string
+		Data Contact
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &recs)
+	if err != nil || len(recs) != 2 || recs[0].Id != "1" || recs[1].Data.Name != "Cy" {
+		t.Errorf("Unexpected JSON stream %s (%v)", buf.String(), err)
+	}
+
+	buf.Reset()
+
+	err = db.Stream("contacts", ids, &buf, ivy.StreamNDJSON)
+	if err != nil {
+		t.Fatal("Stream failed:", err)
+	}
+
+	// The lines are a table export.
+	other, err := ivy.OpenMemDB(map[string][]string{"contacts": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer other.Close()
+
+	idMap, err := other.ImportTable("contacts", &buf, ivy.ImportOptions{})
+	if err != nil || len(idMap) != 2 {
+		t.Error("Expected 2 records imported, got", idMap, err)
+	}
+
+	buf.Reset()
+
+	err = db.Stream("contacts", ids, &buf, ivy.StreamCSV)
+	if err != nil {
+		t.Fatal("Stream failed:", err)
+	}
+
+	want := "id,address.city,age,name,tags\n1,,30,Ann,a;x\n3,Oslo,30,Cy,x\n"
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	buf.Reset()
+
+	err = db.Stream("contacts", nil, &buf, ivy.StreamJSON)
+	if err != nil || buf.String() != "[]\n" {
+		t.Errorf("Expected an empty array, got %q (%v)", buf.String(), err)
+	}
+}
@@ -0,0 +1,65 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func nextUpdate(t *testing.T, sub *ivy.Subscription) (ivy.QueryUpdate, bool) {
+	select {
+	case update, ok := <-sub.C:
+		return update, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an update")
+		return ivy.QueryUpdate{}, false
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	pdb := openPlanes(t)
+
+	sub, err := pdb.Subscribe("planes", "enginetype = ?", "radial")
+	if err != nil {
+		t.Fatal("Subscribe failed:", err)
+	}
+
+	update, _ := nextUpdate(t, sub)
+	if !reflect.DeepEqual(update.Ids, []string{"3", "4", "5"}) || !reflect.DeepEqual(update.Added, update.Ids) {
+		t.Errorf("Unexpected first update %+v", update)
+	}
+
+	// A write that does not match sends nothing.
+	if _, err := pdb.Create("planes", Plane{Name: "Meteor", EngineType: "jet", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if _, err := pdb.Create("planes", Plane{Name: "Sea Fury", EngineType: "radial", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	update, _ = nextUpdate(t, sub)
+	if !reflect.DeepEqual(update.Ids, []string{"3", "4", "5", "8"}) || !reflect.DeepEqual(update.Added, []string{"8"}) || update.Removed != nil {
+		t.Errorf("Unexpected update after create %+v", update)
+	}
+
+	if err := pdb.Update("planes", Plane{Name: "Piper Cub", EngineType: "inline", Tags: []string{}}, "4"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	update, _ = nextUpdate(t, sub)
+	if !reflect.DeepEqual(update.Ids, []string{"3", "5", "8"}) || update.Added != nil || !reflect.DeepEqual(update.Removed, []string{"4"}) {
+		t.Errorf("Unexpected update after update %+v", update)
+	}
+
+	pdb.Close()
+
+	if _, ok := nextUpdate(t, sub); ok {
+		t.Error("Expected closing the database to close the subscription")
+	}
+
+	if _, err := pdb.Subscribe("trains", ""); err == nil {
+		t.Error("Expected Subscribe to fail on a closed database")
+	}
+}
@@ -0,0 +1,176 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type Note struct {
+	Text      string   `json:"text"`
+	UpdatedAt string   `json:"updated_at"`
+	Tags      []string `json:"tags"`
+}
+
+func (n *Note) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestSyncWith(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fieldsToIndex := map[string][]string{"notes": {"tags"}}
+
+	var dbs []*ivy.DB
+
+	for _, name := range []string{"laptop", "server"} {
+		err = os.MkdirAll(filepath.Join(dir, name, "notes"), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := ivy.OpenDB(filepath.Join(dir, name), ivy.WithIndexes(fieldsToIndex))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+		defer db.Close()
+
+		dbs = append(dbs, db)
+	}
+
+	laptop, server := dbs[0], dbs[1]
+
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := server.Create("notes", Note{Text: text, UpdatedAt: "2024-01-01T00:00:00Z", Tags: []string{}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	report, err := laptop.SyncWith(server, ivy.SyncOptions{})
+	if err != nil {
+		t.Fatal("SyncWith failed:", err)
+	}
+	if report.Pulled != 3 {
+		t.Error("Expected 3 records pulled, got", report)
+	}
+
+	// Offline changes on both sides.
+	if err := laptop.Update("notes", Note{Text: "one laptop", UpdatedAt: "2024-01-03T00:00:00Z", Tags: []string{}}, "1"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := server.Update("notes", Note{Text: "one server", UpdatedAt: "2024-01-02T00:00:00Z", Tags: []string{}}, "1"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if err := laptop.Delete("notes", "2"); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+	if err := server.Update("notes", Note{Text: "three server", UpdatedAt: "2024-01-02T00:00:00Z", Tags: []string{}}, "3"); err != nil {
+		t.Fatal("Update failed:", err)
+	}
+	if _, err := laptop.Create("notes", Note{Text: "four laptop", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+	if _, err := server.Create("notes", Note{Text: "four server", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	report, err = laptop.SyncWith(server, ivy.SyncOptions{})
+	if err != nil {
+		t.Fatal("SyncWith failed:", err)
+	}
+
+	want := ivy.SyncReport{Pulled: 1, Pushed: 1, Conflicts: 1, Renumbered: 1}
+	if *report != want {
+		t.Errorf("Expected report %+v, got %+v", want, *report)
+	}
+
+	expected := map[string]string{"1": "one laptop", "3": "three server", "4": "four laptop", "5": "four server"}
+
+	for _, db := range dbs {
+		ids, err := db.FindAllIds("notes")
+		if err != nil || len(ids) != len(expected) {
+			t.Error("Expected", len(expected), "records, got", ids, err)
+		}
+
+		for fileId, text := range expected {
+			note := Note{}
+			err = db.Find("notes", &note, fileId)
+			if err != nil || note.Text != text {
+				t.Errorf("Expected record %s to be %q, got %q (%v)", fileId, text, note.Text, err)
+			}
+		}
+	}
+
+	// Nothing is left to do afterwards.
+	report, err = server.SyncWith(laptop, ivy.SyncOptions{})
+	if err != nil {
+		t.Fatal("SyncWith failed:", err)
+	}
+	if report.Pulled+report.Pushed+report.Conflicts+report.Renumbered != 0 {
+		t.Error("Expected an empty sync, got", report)
+	}
+}
+
+func TestSyncWithResolve(t *testing.T) {
+	var dbs []*ivy.DB
+
+	for i := 0; i < 2; i++ {
+		db, err := ivy.OpenMemDB(map[string][]string{"notes": {"tags"}})
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+		defer db.Close()
+
+		dbs = append(dbs, db)
+	}
+
+	a, b := dbs[0], dbs[1]
+
+	if _, err := a.Create("notes", Note{Text: "base", Tags: []string{}}); err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	if _, err := a.SyncWith(b, ivy.SyncOptions{}); err == nil {
+		t.Error("Expected an error without a peer name for an in-memory database")
+	}
+
+	opts := ivy.SyncOptions{
+		Peer: "b",
+		Resolve: func(c ivy.Conflict) (json.RawMessage, error) {
+			var local, remote Note
+			json.Unmarshal(c.Local, &local)
+			json.Unmarshal(c.Remote, &remote)
+
+			return json.Marshal(Note{Text: local.Text + "+" + remote.Text, Tags: []string{}})
+		},
+	}
+
+	if _, err := a.SyncWith(b, opts); err != nil {
+		t.Fatal("SyncWith failed:", err)
+	}
+
+	a.Update("notes", Note{Text: "a", Tags: []string{}}, "1")
+	b.Update("notes", Note{Text: "b", Tags: []string{}}, "1")
+
+	report, err := a.SyncWith(b, opts)
+	if err != nil {
+		t.Fatal("SyncWith failed:", err)
+	}
+	if report.Conflicts != 1 {
+		t.Error("Expected 1 conflict, got", report)
+	}
+
+	for _, db := range dbs {
+		note := Note{}
+		err = db.Find("notes", &note, "1")
+		if err != nil || note.Text != "a+b" {
+			t.Error("Expected merged record, got", note.Text, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCopyTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags", "bar"}}
+
+	tdb, err := ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+
+	for _, bar := range []string{"one", "two", "three"} {
+		if _, err := tdb.Create("foos", Foo{Bar: bar, Tags: []string{bar}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+	tdb.Delete("foos", "2")
+
+	// Reads keep going during the copy.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				foo := Foo{}
+				tdb.Find("foos", &foo, "1")
+				tdb.FindAllIdsForTags("foos_backup", []string{"one"})
+			}
+		}
+	}()
+
+	err = tdb.CopyTable("foos", "foos_backup")
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal("CopyTable failed:", err)
+	}
+
+	names, err := tdb.TableNames()
+	if err != nil || len(names) != 2 || names[0] != "foos" || names[1] != "foos_backup" {
+		t.Error("Expected tables foos and foos_backup, got", names, err)
+	}
+
+	if len(fieldsToIndex) != 1 {
+		t.Error("Expected the caller's index map to be left alone, got", fieldsToIndex)
+	}
+
+	if err = tdb.CopyTable("foos", "foos_backup"); err == nil {
+		t.Error("Expected an error copying onto an existing table")
+	}
+	if err = tdb.CopyTable("bars", "bars_backup"); err == nil {
+		t.Error("Expected an error copying a missing table")
+	}
+	if err = tdb.CopyTable("foos", "../foos"); err == nil {
+		t.Error("Expected an error for an invalid table name")
+	}
+
+	ids, err := tdb.FindAllIds("foos_backup")
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected 2 copied records, got", ids, err)
+	}
+
+	id, err := tdb.FindFirstIdForField("foos_backup", "bar", "three")
+	if err != nil || id != "3" {
+		t.Error("Expected copy to be indexed, got", id, err)
+	}
+
+	// The copy is independent of the original.
+	err = tdb.Update("foos", Foo{Bar: "changed", Tags: []string{}}, "1")
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	foo := Foo{}
+	err = tdb.Find("foos_backup", &foo, "1")
+	if err != nil || foo.Bar != "one" {
+		t.Error("Expected copy to keep the original record, got", foo.Bar, err)
+	}
+
+	id, err = tdb.Create("foos_backup", Foo{Bar: "four", Tags: []string{}})
+	if err != nil || id != "4" {
+		t.Error("Expected new record in the copy to get id 4, got", id, err)
+	}
+
+	err = tdb.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	if err = tdb.CopyTable("foos", "foos_closed"); err != ivy.ErrClosed {
+		t.Error("Expected ErrClosed, got", err)
+	}
+
+	// The copy is a table like any other.
+	tdb, err = ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"foos_backup": {"tags"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer tdb.Close()
+
+	ids, err = tdb.FindAllIdsForTags("foos_backup", []string{"three"})
+	if err != nil || len(ids) != 1 || ids[0] != "3" {
+		t.Error("Expected copy to be found after reopening, got", ids, err)
+	}
+}
+
+func TestRegisterTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdb, err := ivy.OpenDB(dir, ivy.WithIndexes(map[string][]string{"foos": {"tags"}}))
+	if err != nil {
+		t.Fatal("OpenDB failed:", err)
+	}
+	defer tdb.Close()
+
+	// Another program adds a table after the database was opened.
+	err = os.Mkdir(filepath.Join(dir, "bars"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "bars", "1.json"), []byte(`{"bar":"one","tags":[]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var foo Foo
+
+	err = tdb.Find("bars", &foo, "1")
+	if err != nil || foo.Bar != "one" {
+		t.Errorf("Expected to find the record of the new table, got %v %v", foo, err)
+	}
+
+	tblNames, _ := tdb.TableNames()
+	if len(tblNames) != 2 || tblNames[0] != "bars" {
+		t.Errorf("Expected the new table to be listed, got %v", tblNames)
+	}
+
+	err = tdb.RegisterTable("bars", []string{"bar"})
+	if err != nil {
+		t.Fatal("RegisterTable failed:", err)
+	}
+
+	plan, err := tdb.Explain("bars", "bar = 'one'")
+	if err != nil || plan.Index != "field" {
+		t.Errorf("Expected the registered table to be indexed, got %v %v", plan, err)
+	}
+
+	ids, err := tdb.FindAllIdsForField("bars", "bar", "one")
+	if err != nil || len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("Expected the index to hold the record, got %v %v", ids, err)
+	}
+
+	err = tdb.RegisterTable("bazs", []string{"tags"})
+	if err != nil {
+		t.Fatal("RegisterTable failed:", err)
+	}
+
+	if _, err := tdb.Create("bazs", Foo{Bar: "new", Tags: []string{"x"}}); err != nil {
+		t.Error("Create in the registered table failed:", err)
+	}
+
+	ids, _ = tdb.FindAllIdsForTags("bazs", []string{"x"})
+	if len(ids) != 1 {
+		t.Errorf("Expected the tag index of the new table to hold the record, got %v", ids)
+	}
+}
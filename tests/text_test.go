@@ -0,0 +1,148 @@
+package ivy
+
+import (
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+)
+
+func TestSearchText(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"notes": nil}
+		if indexed {
+			fieldsToIndex["notes"] = []string{"text@text"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, text := range []string{
+			"The Lancaster was a heavy bomber of the RAF.",
+			"Bomber Command flew the Lancaster, the Halifax and the Stirling, bomber after bomber.",
+			"The Spitfire was a fighter.",
+			"Lancaster",
+			"",
+		} {
+			if _, err := tdb.Create("notes", Note{Text: text, Tags: []string{}}); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		tests := []struct {
+			search string
+			ids    []string
+		}{
+			{"lancaster", []string{"4", "1", "2"}},
+			{"BOMBER", []string{"2", "1"}},
+			{"heavy bomber", []string{"1", "2"}},
+			{"spitfire fighter", []string{"3"}},
+			{"mosquito", []string{}},
+			{"  ", []string{}},
+		}
+
+		for _, test := range tests {
+			matches, err := tdb.SearchText("notes", "text", test.search)
+			if err != nil {
+				t.Errorf("indexed %v, %q: SearchText failed: %v", indexed, test.search, err)
+				continue
+			}
+
+			ids := []string{}
+			for i, m := range matches {
+				ids = append(ids, m.Id)
+				if m.Score <= 0 || (i > 0 && m.Score > matches[i-1].Score) {
+					t.Errorf("indexed %v, %q: unexpected scores %v", indexed, test.search, matches)
+				}
+			}
+
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("indexed %v, %q: expected %v, got %v", indexed, test.search, test.ids, matches)
+			}
+		}
+
+		if indexed {
+			scan, err := ivy.OpenMemDB(map[string][]string{"notes": nil})
+			if err != nil {
+				t.Fatal("OpenMemDB failed:", err)
+			}
+			for _, id := range []string{"1", "2", "3", "4", "5"} {
+				var note Note
+				if err := tdb.Find("notes", &note, id); err != nil {
+					t.Fatal("Find failed:", err)
+				}
+				if _, err := scan.Create("notes", note); err != nil {
+					t.Fatal("Create failed:", err)
+				}
+			}
+
+			want, _ := scan.SearchText("notes", "text", "lancaster bomber")
+			got, _ := tdb.SearchText("notes", "text", "lancaster bomber")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected the index to score as the scan does, got %v and %v", got, want)
+			}
+			scan.Close()
+		}
+
+		if err := tdb.Update("notes", Note{Text: "A Lancaster", Tags: []string{}}, "2"); err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		matches, _ := tdb.SearchText("notes", "text", "bomber")
+		if len(matches) != 1 || matches[0].Id != "1" {
+			t.Errorf("indexed %v: expected the update to change the index, got %v", indexed, matches)
+		}
+
+		tdb.Close()
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := ivy.Analyzer{
+		StopWords: ivy.EnglishStopWords,
+		Stem:      true,
+		Filters: []ivy.TokenFilter{func(term string) string {
+			if term == "lanc" {
+				return "lancaster"
+			}
+			return term
+		}},
+	}
+
+	terms := a.Terms("The Bombers of the Factories flew Lancs, Stirlings and a Lanc.")
+	want := []string{"bomber", "factory", "flew", "lancaster", "stirling", "lancaster"}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("Expected %v, got %v", want, terms)
+	}
+
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"notes": nil}
+		if indexed {
+			fieldsToIndex["notes"] = []string{"text@text"}
+		}
+
+		tdb, err := ivy.OpenDB("", ivy.WithStorage(ivy.MemoryStorage), ivy.WithIndexes(fieldsToIndex), ivy.WithAnalyzer("notes", a))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+
+		for _, text := range []string{"A heavy bomber", "The bombers of the war", "The end"} {
+			if _, err := tdb.Create("notes", Note{Text: text, Tags: []string{}}); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		matches, err := tdb.SearchText("notes", "text", "bombers")
+		if err != nil || len(matches) != 2 {
+			t.Errorf("indexed %v: expected bombers to match bomber, got %v %v", indexed, matches, err)
+		}
+
+		matches, err = tdb.SearchText("notes", "text", "the")
+		if err != nil || len(matches) != 0 {
+			t.Errorf("indexed %v: expected stop words not to match, got %v %v", indexed, matches, err)
+		}
+
+		tdb.Close()
+	}
+}
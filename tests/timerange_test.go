@@ -0,0 +1,68 @@
+package ivy
+
+import (
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindAllIdsForFieldBetweenTimes(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		fieldsToIndex := map[string][]string{"notes": nil}
+		if indexed {
+			fieldsToIndex["notes"] = []string{"created_at@time"}
+		}
+
+		tdb, err := ivy.OpenMemDB(fieldsToIndex)
+		if err != nil {
+			t.Fatal("OpenMemDB failed:", err)
+		}
+
+		for _, rec := range []string{
+			`{"created_at": "2024-05-03T09:00:00Z", "tags": []}`,
+			`{"created_at": "2024-05-01T12:00:00+02:00", "tags": []}`,
+			`{"created_at": 1714644000, "tags": []}`,
+			`{"created_at": "yesterday", "tags": []}`,
+			`{"created_at": "2024-06-01T00:00:00Z", "tags": []}`,
+			`{"tags": []}`,
+		} {
+			if _, err := tdb.Create("notes", json.RawMessage(rec)); err != nil {
+				t.Fatal("Create failed:", err)
+			}
+		}
+
+		may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		june := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		tests := []struct {
+			from, to time.Time
+			ids      []string
+		}{
+			{may, june, []string{"2", "3", "1"}},
+			{may.Add(24 * time.Hour), time.Time{}, []string{"3", "1", "5"}},
+			{time.Time{}, may.Add(12 * time.Hour), []string{"2"}},
+			{june, june, nil},
+		}
+
+		for _, test := range tests {
+			ids, err := tdb.FindAllIdsForFieldBetweenTimes("notes", "created_at", test.from, test.to)
+			if err != nil || !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("indexed %v, %v to %v: expected %v, got %v %v", indexed, test.from, test.to, test.ids, ids, err)
+			}
+		}
+
+		err = tdb.Update("notes", json.RawMessage(`{"created_at": "2023-01-01T00:00:00Z", "tags": []}`), "1")
+		if err != nil {
+			t.Fatal("Update failed:", err)
+		}
+
+		ids, _ := tdb.FindAllIdsForFieldBetweenTimes("notes", "created_at", time.Time{}, may)
+		if !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("indexed %v: expected the update to change the index, got %v", indexed, ids)
+		}
+
+		tdb.Close()
+	}
+}
@@ -0,0 +1,250 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type Account struct {
+	Owner   string `json:"owner"`
+	Balance int    `json:"balance"`
+}
+
+func (a *Account) AfterFind(db *ivy.DB, fileId string) {
+}
+
+func TestTransact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tblName := range []string{"accounts", "transfers"} {
+		os.Mkdir(filepath.Join(dir, tblName), 0700)
+	}
+
+	fieldsToIndex := map[string][]string{"accounts": {"owner"}, "transfers": nil}
+
+	open := func() *ivy.DB {
+		tdb, err := ivy.OpenDB(dir, ivy.WithIndexes(fieldsToIndex), ivy.WithWAL(ivy.WALOptions{}))
+		if err != nil {
+			t.Fatal("OpenDB failed:", err)
+		}
+		return tdb
+	}
+
+	tdb := open()
+
+	aId, _ := tdb.Create("accounts", Account{Owner: "ann", Balance: 100})
+	bId, _ := tdb.Create("accounts", Account{Owner: "bob", Balance: 0})
+
+	ctx := context.Background()
+	tables := []string{"accounts", "transfers"}
+
+	transfer := func(tx *ivy.Tx, amount int) error {
+		a, b := Account{}, Account{}
+		if err := tx.Find("accounts", &a, aId); err != nil {
+			return err
+		}
+		if err := tx.Find("accounts", &b, bId); err != nil {
+			return err
+		}
+
+		a.Balance -= amount
+		b.Balance += amount
+
+		if err := tx.Update("accounts", a, aId); err != nil {
+			return err
+		}
+		if err := tx.Update("accounts", b, bId); err != nil {
+			return err
+		}
+		if _, err := tx.Create("transfers", Document{Title: "transfer"}); err != nil {
+			return err
+		}
+
+		if a.Balance < 0 {
+			return errors.New("insufficient funds")
+		}
+
+		return nil
+	}
+
+	balances := func(d *ivy.DB) []int {
+		a, b := Account{}, Account{}
+		d.Find("accounts", &a, aId)
+		d.Find("accounts", &b, bId)
+		return []int{a.Balance, b.Balance}
+	}
+
+	if err := tdb.Transact(ctx, tables, func(tx *ivy.Tx) error { return transfer(tx, 30) }); err != nil {
+		t.Fatal("Transact failed:", err)
+	}
+
+	if got := balances(tdb); !reflect.DeepEqual(got, []int{70, 30}) {
+		t.Error("Expected the transfer to be committed, got", got)
+	}
+
+	err = tdb.Transact(ctx, tables, func(tx *ivy.Tx) error { return transfer(tx, 100) })
+	if err == nil || err.Error() != "insufficient funds" {
+		t.Error("Expected the error of the function, got", err)
+	}
+
+	if got := balances(tdb); !reflect.DeepEqual(got, []int{70, 30}) {
+		t.Error("Expected the failed transfer to be rolled back, got", got)
+	}
+
+	if ids, _ := tdb.FindAllIds("transfers"); len(ids) != 1 {
+		t.Error("Expected the record created by the failed transfer to be gone, got", ids)
+	}
+
+	if ids, _ := tdb.FindAllIdsForField("accounts", "owner", "ann"); !reflect.DeepEqual(ids, []string{aId}) {
+		t.Error("Expected the indexes to be restored, got", ids)
+	}
+
+	// A failed write rolls the transaction back even if it is ignored.
+	err = tdb.Transact(ctx, []string{"accounts"}, func(tx *ivy.Tx) error {
+		tx.Update("accounts", Account{Owner: "ann", Balance: 0}, aId)
+		tx.Delete("accounts", "99")
+		return nil
+	})
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected the error of the failed write, got", err)
+	}
+
+	err = tdb.Transact(ctx, []string{"accounts"}, func(tx *ivy.Tx) error {
+		_, err := tx.Create("transfers", Document{})
+		return err
+	})
+	if err == nil {
+		t.Error("Expected a write to a table outside the transaction to fail")
+	}
+
+	// A panic rolls the transaction back and unlocks the tables before it
+	// carries on.
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Error("Expected the panic to carry on, got", p)
+			}
+		}()
+
+		tdb.Transact(ctx, tables, func(tx *ivy.Tx) error {
+			transfer(tx, 10)
+			panic("boom")
+		})
+	}()
+
+	if got := balances(tdb); !reflect.DeepEqual(got, []int{70, 30}) {
+		t.Error("Expected the transfer to be rolled back after the panic, got", got)
+	}
+
+	tdb.Close()
+
+	tdb = open()
+	defer tdb.Close()
+
+	if got := balances(tdb); !reflect.DeepEqual(got, []int{70, 30}) {
+		t.Error("Expected the balances to survive reopening, got", got)
+	}
+}
+
+func TestMVCC(t *testing.T) {
+	mdb, err := ivy.OpenDBWithOptions("", map[string][]string{"accounts": {"owner"}}, ivy.Options{Storage: ivy.MemoryStorage, MVCC: true})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer mdb.Close()
+
+	aId, _ := mdb.Create("accounts", Account{Owner: "ann", Balance: 100})
+	bId, _ := mdb.Create("accounts", Account{Owner: "bob", Balance: 0})
+
+	before, err := mdb.Snapshot()
+	if err != nil {
+		t.Fatal("Snapshot failed:", err)
+	}
+
+	var during *ivy.Snapshot
+
+	err = mdb.Transact(context.Background(), []string{"accounts"}, func(tx *ivy.Tx) error {
+		if err := tx.Update("accounts", Account{Owner: "ann", Balance: 60}, aId); err != nil {
+			return err
+		}
+
+		// Taking a snapshot does not wait for the transaction.
+		taken := make(chan error)
+		go func() {
+			var err error
+			during, err = mdb.Snapshot()
+			taken <- err
+		}()
+		if err := <-taken; err != nil {
+			return err
+		}
+
+		if err := tx.Update("accounts", Account{Owner: "bob", Balance: 40}, bId); err != nil {
+			return err
+		}
+		if _, err := tx.Create("accounts", Account{Owner: "cy", Balance: 1}); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Transact failed:", err)
+	}
+
+	if err := mdb.Delete("accounts", aId); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	for _, snap := range []*ivy.Snapshot{before, during} {
+		a, b := Account{}, Account{}
+		if err := snap.Find("accounts", &a, aId); err != nil || a.Balance != 100 {
+			t.Error("Expected the snapshot to see none of the transaction, got", a, err)
+		}
+		if err := snap.Find("accounts", &b, bId); err != nil || b.Balance != 0 {
+			t.Error("Expected the snapshot to see none of the transaction, got", b, err)
+		}
+
+		if ids, err := snap.FindAllIds("accounts"); err != nil || !reflect.DeepEqual(ids, []string{aId, bId}) {
+			t.Error("Expected the ids before the transaction, got", ids, err)
+		}
+
+		if ids, err := snap.Filter("accounts", ivy.M{"balance": ivy.M{"$gt": 10}}); err != nil || !reflect.DeepEqual(ids, []string{aId}) {
+			t.Error("Expected the filter to see the balances before the transaction, got", ids, err)
+		}
+	}
+
+	after, err := mdb.Snapshot()
+	if err != nil {
+		t.Fatal("Snapshot failed:", err)
+	}
+
+	if ids, err := after.FindAllIds("accounts"); err != nil || len(ids) != 2 || ids[0] != bId {
+		t.Error("Expected a later snapshot to see the transaction and the delete, got", ids, err)
+	}
+
+	before.Close()
+	during.Close()
+
+	report, err := mdb.Compact("accounts")
+	if err != nil || report.Versions == 0 {
+		t.Error("Expected Compact to drop the versions no snapshot reads, got", report, err)
+	}
+
+	b := Account{}
+	if err := after.Find("accounts", &b, bId); err != nil || b.Balance != 40 {
+		t.Error("Expected the later snapshot to be unaffected by Compact, got", b, err)
+	}
+
+	after.Close()
+}
@@ -0,0 +1,93 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"testing"
+)
+
+func TestTypedTable(t *testing.T) {
+	db := openPlanes(t)
+	defer db.Close()
+
+	planes := ivy.Table[*Plane](db, "planes")
+
+	if planes.Name() != "planes" {
+		t.Error("Unexpected name", planes.Name())
+	}
+
+	fileId, err := planes.Create(&Plane{Name: "Spitfire", EngineType: "inline", Speed: 370, Tags: []string{"ww2"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	plane, err := planes.Find(fileId)
+	if err != nil {
+		t.Fatal("Find failed:", err)
+	}
+
+	if plane.Name != "Spitfire" || plane.Speed != 370 {
+		t.Errorf("Unexpected plane %+v", plane)
+	}
+
+	plane.Speed = 378
+
+	err = planes.Update(plane, fileId)
+	if err != nil {
+		t.Fatal("Update failed:", err)
+	}
+
+	fast, err := planes.Query("enginetype = 'inline' AND speed > 375 ORDER BY speed DESC")
+	if err != nil {
+		t.Fatal("Query failed:", err)
+	}
+
+	if len(fast) < 2 || fast[len(fast)-1].Name != "Spitfire" || fast[0].Speed < fast[1].Speed {
+		t.Errorf("Unexpected query results %+v", fast)
+	}
+
+	inline, err := planes.FindAllForField("enginetype", "inline")
+	if err != nil {
+		t.Fatal("FindAllForField failed:", err)
+	}
+
+	for _, p := range inline {
+		if p.EngineType != "inline" {
+			t.Errorf("Unexpected plane %+v", p)
+		}
+	}
+
+	ww2, err := planes.FindAllForTags([]string{"ww2"})
+	if err != nil || len(ww2) != 1 || ww2[0].Name != "Spitfire" {
+		t.Errorf("Unexpected tag results %+v, %v", ww2, err)
+	}
+
+	all, err := planes.All()
+	if err != nil {
+		t.Fatal("All failed:", err)
+	}
+
+	ids, _ := db.FindAllIds("planes")
+	if len(all) != len(ids) || all[len(all)-1].Name != "Spitfire" {
+		t.Errorf("Expected all %d planes in id order, got %+v", len(ids), all)
+	}
+
+	err = planes.Delete(fileId)
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	if plane, err := planes.Find(fileId); !errors.Is(err, ivy.ErrNotFound) || plane != nil {
+		t.Errorf("Expected a deleted plane not to be found, got %+v, %v", plane, err)
+	}
+}
+
+func TestTypedTableNeedsPointer(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Table to panic on a non-pointer record type")
+		}
+	}()
+
+	ivy.Table[ivy.Record](nil, "planes")
+}
@@ -0,0 +1,136 @@
+package ivy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/jameycribbs/ivy"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackupToHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var received []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if r.Method != "PUT" || r.URL.Path != "/backups/nightly.tar.gz" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Fail the first attempt halfway through.
+		if attempts == 1 {
+			io.CopyN(ioutil.Discard, r.Body, 10)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	udb, err := ivy.OpenMemDB(map[string][]string{"foos": {"tags"}})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer udb.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := udb.Create("foos", Foo{Bar: "remote", Tags: []string{"u"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	uploader := &ivy.HTTPUploader{
+		URL:    server.URL + "/backups/",
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	}
+
+	err = udb.BackupTo(uploader, "nightly.tar.gz", ivy.UploadOptions{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal("BackupTo failed:", err)
+	}
+
+	if attempts != 2 {
+		t.Error("Expected the upload to be retried once, got", attempts, "attempts")
+	}
+
+	dir, err := ioutil.TempDir("", "ivy-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ivy.RestoreBackup(bytes.NewReader(received), filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal("Expected the uploaded archive to restore, got", err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "db", "foos"))
+	if len(files) != 10 {
+		t.Error("Expected 10 restored records, got", len(files))
+	}
+
+	// Give up after the configured number of attempts.
+	attempts = 0
+	failing := ivy.UploaderFunc(func(name string, r io.Reader) error {
+		attempts++
+		return io.ErrUnexpectedEOF
+	})
+
+	err = udb.BackupTo(failing, "nightly.tar.gz", ivy.UploadOptions{Attempts: 2, Backoff: time.Millisecond})
+	if err != io.ErrUnexpectedEOF || attempts != 2 {
+		t.Error("Expected 2 failed attempts, got", attempts, err)
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	fs := &ivy.S3FileSystem{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "test",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+
+	small := []byte("small backup")
+
+	err := fs.Upload("backups/small", bytes.NewReader(small))
+	if err != nil {
+		t.Fatal("Upload failed:", err)
+	}
+	if !bytes.Equal(fake.objects["backups/small"], small) {
+		t.Error("Expected small upload to be stored, got", len(fake.objects["backups/small"]), "bytes")
+	}
+
+	// Larger uploads go through a multipart upload.
+	large := make([]byte, 6<<20)
+	rand.Read(large)
+
+	err = fs.Upload("backups/large", bytes.NewReader(large))
+	if err != nil {
+		t.Fatal("Upload failed:", err)
+	}
+	if !bytes.Equal(fake.objects["backups/large"], large) {
+		t.Error("Expected large upload to be stored, got", len(fake.objects["backups/large"]), "bytes")
+	}
+	if len(fake.parts) != 0 {
+		t.Error("Expected the multipart upload to be completed")
+	}
+}
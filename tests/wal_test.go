@@ -0,0 +1,183 @@
+package ivy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/jameycribbs/ivy"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendWALEntry appends an entry to a write-ahead log segment the way ivy
+// writes them.
+func appendWALEntry(t *testing.T, segment string, entry map[string]interface{}) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli)))
+
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(header, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsToIndex := map[string][]string{"foos": {"tags"}}
+
+	// NoLock lets the test reopen a database it never closed, as if the
+	// process had crashed.
+	opts := ivy.Options{WAL: &ivy.WALOptions{}, NoLock: true}
+
+	wdb, err := ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	if r := wdb.Recovery(); r == nil || !r.Clean {
+		t.Fatal("Expected a clean start, got", r)
+	}
+
+	for _, bar := range []string{"one", "two"} {
+		if _, err := wdb.Create("foos", Foo{Bar: bar, Tags: []string{"wal"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, ".ivy", "wal", "*.wal"))
+	if len(segments) != 1 {
+		t.Fatal("Expected one log segment, got", segments)
+	}
+
+	// Record 2 was logged but never reached its file...
+	err = os.Remove(filepath.Join(dir, "foos", "2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...record 3 was written by a transaction that never committed...
+	appendWALEntry(t, segments[0], map[string]interface{}{
+		"lsn": 3, "tx": 3, "op": "put", "table": "foos", "id": "3",
+		"data": []byte(`{"bar":"three","tags":["wal"]}`),
+	})
+	err = ioutil.WriteFile(filepath.Join(dir, "foos", "3.json"), []byte(`{"bar":"three","tags":["wal"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...and the process died halfway through appending another entry.
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{42, 0, 0, 0, 1, 2})
+	f.Close()
+
+	wdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	r := wdb.Recovery()
+	if r.Clean || r.Replayed != 2 || r.RolledBack != 1 || r.TruncatedBytes != 6 {
+		t.Errorf("Unexpected recovery report %+v", r)
+	}
+
+	foo := Foo{}
+	err = wdb.Find("foos", &foo, "2")
+	if err != nil || foo.Bar != "two" {
+		t.Error("Expected record 2 to be replayed, got", foo.Bar, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "foos", "3.json")); !os.IsNotExist(err) {
+		t.Error("Expected uncommitted record 3 to be rolled back, got", err)
+	}
+
+	ids, err := wdb.FindAllIdsForTags("foos", []string{"wal"})
+	if err != nil || len(ids) != 2 {
+		t.Error("Expected the indexes to cover the recovered records, got", ids, err)
+	}
+
+	err = wdb.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+
+	wdb, err = ivy.OpenDBWithOptions(dir, fieldsToIndex, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer wdb.Close()
+
+	if r := wdb.Recovery(); !r.Clean {
+		t.Errorf("Expected a clean start after Close, got %+v", r)
+	}
+}
+
+func TestWALSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ivy.Options{WAL: &ivy.WALOptions{SegmentSize: 512, NoSync: true}}
+
+	wdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := wdb.Create("foos", Foo{Bar: "segmented", Tags: []string{"s"}}); err != nil {
+			t.Fatal("Create failed:", err)
+		}
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, ".ivy", "wal", "*.wal"))
+	if len(segments) < 2 {
+		t.Fatal("Expected the log to be split into segments, got", segments)
+	}
+
+	// A checkpoint drops the segments recovery no longer needs.
+	err = wdb.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	segments, _ = filepath.Glob(filepath.Join(dir, ".ivy", "wal", "*.wal"))
+	if len(segments) != 1 {
+		t.Error("Expected old segments to be removed, got", segments)
+	}
+
+	wdb.Close()
+}
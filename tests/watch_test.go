@@ -0,0 +1,120 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, w *ivy.Watcher) (ivy.WatchEvent, bool) {
+	select {
+	case event, ok := <-w.C:
+		return event, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return ivy.WatchEvent{}, false
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tblName := range []string{"notes", "planes"} {
+		if err := os.MkdirAll(filepath.Join(dir, tblName), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"notes": nil, "planes": nil}, ivy.Options{
+		WAL: &ivy.WALOptions{NoSync: true, KeepSegments: true},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer wdb.Close()
+
+	w, err := wdb.Watch("notes", ivy.WatchOptions{})
+	if err != nil {
+		t.Fatal("Watch failed:", err)
+	}
+
+	fileId, _ := wdb.Create("notes", Note{Text: "first", Tags: []string{}})
+	wdb.Create("planes", Plane{Name: "Spitfire", Tags: []string{}})
+	wdb.Update("notes", Note{Text: "second", Tags: []string{}}, fileId)
+	wdb.Delete("notes", fileId)
+
+	var seqs []uint64
+
+	for _, op := range []string{"create", "update", "delete"} {
+		event, _ := nextEvent(t, w)
+		if event.Op != op || event.Table != "notes" || event.Id != fileId || (op == "delete") != (event.Data == nil) {
+			t.Errorf("Expected a %s of notes/%s, got %+v", op, fileId, event)
+		}
+		seqs = append(seqs, event.Seq)
+	}
+
+	w.Close()
+
+	if _, ok := nextEvent(t, w); ok {
+		t.Error("Expected Close to close the watcher")
+	}
+
+	// Resume after the create, on every table.
+	w, err = wdb.Watch("", ivy.WatchOptions{Replay: true, Since: seqs[0]})
+	if err != nil {
+		t.Fatal("Watch failed:", err)
+	}
+	defer w.Close()
+
+	wdb.Create("notes", Note{Text: "third", Tags: []string{}})
+
+	for _, want := range []string{"planes/create", "notes/update", "notes/delete", "notes/create"} {
+		event, _ := nextEvent(t, w)
+		if event.Table+"/"+event.Op != want {
+			t.Errorf("Expected %s, got %+v", want, event)
+		}
+	}
+
+	if _, err := wdb.Watch("trains", ivy.WatchOptions{}); !errors.Is(err, ivy.ErrTableNotFound) {
+		t.Errorf("Expected ErrTableNotFound, got %v", err)
+	}
+}
+
+func TestWatchLagged(t *testing.T) {
+	mdb, err := ivy.OpenMemDB(map[string][]string{"notes": nil})
+	if err != nil {
+		t.Fatal("OpenMemDB failed:", err)
+	}
+	defer mdb.Close()
+
+	if _, err := mdb.Watch("notes", ivy.WatchOptions{Replay: true}); err == nil {
+		t.Error("Expected replaying without the write-ahead log to fail")
+	}
+
+	w, err := mdb.Watch("notes", ivy.WatchOptions{MaxPending: 2})
+	if err != nil {
+		t.Fatal("Watch failed:", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		mdb.Create("notes", Note{Text: "note", Tags: []string{}})
+	}
+
+	for {
+		if _, ok := nextEvent(t, w); !ok {
+			break
+		}
+	}
+
+	if !errors.Is(w.Err(), ivy.ErrWatchLagged) {
+		t.Errorf("Expected ErrWatchLagged, got %v", w.Err())
+	}
+}
@@ -0,0 +1,159 @@
+package ivy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []ivy.WebhookEvent
+	var failures int
+	received := make(chan struct{}, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		if r.Header.Get("X-Ivy-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Error("Unexpected signature", r.Header.Get("X-Ivy-Signature"))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Fail every other request, so that every event has to be retried.
+		failures++
+		if failures%2 == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+
+		var event ivy.WebhookEvent
+		json.Unmarshal(body, &event)
+		events = append(events, event)
+
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	db, err := ivy.OpenDBWithOptions("", map[string][]string{"contacts": nil, "notes": nil}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Webhooks: []ivy.Webhook{{
+			URL:     srv.URL,
+			Tables:  []string{"contacts"},
+			Secret:  "s3cret",
+			Backoff: time.Millisecond,
+		}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+	defer db.Close()
+
+	fileId, _ := db.Create("contacts", Contact{Name: "Ann", Tags: []string{}})
+	db.Create("notes", Note{Text: "Unwatched", Tags: []string{}})
+	db.Update("contacts", Contact{Name: "Anne", Tags: []string{}}, fileId)
+	db.Delete("contacts", fileId)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for webhook events")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", events)
+	}
+
+	var rec Contact
+	json.Unmarshal(events[1].Data, &rec)
+
+	if events[0].Op != "create" || events[1].Op != "update" || events[2].Op != "delete" ||
+		events[1].Table != "contacts" || events[1].Id != fileId || rec.Name != "Anne" ||
+		events[2].Data != nil || events[0].Time.IsZero() {
+		t.Errorf("Unexpected events %+v", events)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone fishing", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	errs := map[string]error{}
+	failed := make(chan struct{}, 10)
+
+	db, err := ivy.OpenDBWithOptions("", map[string][]string{"contacts": nil}, ivy.Options{
+		Storage: ivy.MemoryStorage,
+		Webhooks: []ivy.Webhook{{
+			URL:       srv.URL,
+			Attempts:  2,
+			Backoff:   time.Millisecond,
+			QueueSize: 1,
+			OnError: func(event ivy.WebhookEvent, err error) {
+				mu.Lock()
+				errs[event.Id] = err
+				mu.Unlock()
+				failed <- struct{}{}
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	db.Create("contacts", Contact{Name: "Ann", Tags: []string{}})
+
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the event to fail")
+	}
+
+	mu.Lock()
+	if errs["1"] == nil || errors.Is(errs["1"], ivy.ErrClosed) {
+		t.Error("Expected the event to fail, got", errs["1"])
+	}
+	mu.Unlock()
+
+	// With a queue of one, events written faster than they fail are dropped
+	// or, when the database is closed, given up on.
+	for i := 0; i < 5; i++ {
+		db.Create("contacts", Contact{Name: "Bob", Tags: []string{}})
+	}
+
+	db.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	full := 0
+	for _, err := range errs {
+		if errors.Is(err, ivy.ErrWebhookQueueFull) {
+			full++
+		}
+	}
+
+	if len(errs) != 6 || full == 0 {
+		t.Errorf("Expected every event to fail and some to be dropped, got %v", errs)
+	}
+}
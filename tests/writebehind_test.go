@@ -0,0 +1,79 @@
+package ivy
+
+import (
+	"errors"
+	"github.com/jameycribbs/ivy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivy-writebehind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "foos"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ivy.Options{WriteBehind: &ivy.WriteBehindOptions{FlushInterval: time.Hour}}
+
+	wdb, err := ivy.OpenDBWithOptions(dir, map[string][]string{"foos": {"tags", "bar"}}, opts)
+	if err != nil {
+		t.Fatal("OpenDBWithOptions failed:", err)
+	}
+
+	id, err := wdb.Create("foos", Foo{Bar: "pending", Tags: []string{"wb"}})
+	if err != nil {
+		t.Fatal("Create failed:", err)
+	}
+
+	recFile := filepath.Join(dir, "foos", id+".json")
+
+	if _, err := os.Stat(recFile); !os.IsNotExist(err) {
+		t.Error("Expected record not to be flushed yet")
+	}
+
+	foo := Foo{}
+	err = wdb.Find("foos", &foo, id)
+	if err != nil || foo.Bar != "pending" {
+		t.Error("Expected pending record to be readable, got", foo.Bar, err)
+	}
+
+	id2, err := wdb.Create("foos", Foo{Bar: "second", Tags: []string{"wb"}})
+	if err != nil || id2 == id {
+		t.Error("Expected a new id for the second record, got", id2, err)
+	}
+
+	err = wdb.Sync()
+	if err != nil {
+		t.Fatal("Sync failed:", err)
+	}
+
+	if _, err := os.Stat(recFile); err != nil {
+		t.Error("Expected record to be flushed by Sync, got", err)
+	}
+
+	err = wdb.Delete("foos", id)
+	if err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	err = wdb.Find("foos", &foo, id)
+	if !errors.Is(err, ivy.ErrNotFound) {
+		t.Error("Expected Find error to be ErrNotFound, got ", err)
+	}
+
+	// Close flushes the pending delete.
+	wdb.Close()
+
+	if _, err := os.Stat(recFile); !os.IsNotExist(err) {
+		t.Error("Expected record file to be removed on Close")
+	}
+}
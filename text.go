@@ -0,0 +1,285 @@
+package ivy
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parameters of the BM25 score of text searches.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Type TextMatch is a struct holding a record found by DB.SearchText and its
+// score, which is higher for better matches.
+type TextMatch struct {
+	Id    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// textStats holds what the BM25 score of the records matching a search needs
+// to know about a field of a table: the number of records holding text in it,
+// their total length in terms, and the length of, and the number of times a
+// term of the search occurs in, every matching record.
+type textStats struct {
+	docs     int
+	totalLen int
+	lens     map[string]int
+	freqs    map[string]map[string]int
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// SearchText returns the records holding any of the words of a search in a
+// text field, best matches first, ranked by their BM25 score: records holding
+// more of the words, more often, in shorter text, and holding the rarer words
+// rank higher. Records with the same score are in id order. Text is split into
+// terms by the table's Analyzer, which by default splits it into words at
+// anything but letters and digits, compared without regard to case. A field
+// is indexed for text searches by naming it with an "@text" suffix in the
+// fields to index, such as "body@text", so that records are found and scored
+// from the index without decoding any of them. It takes a table name, a field
+// name, which may be a path into nested objects, and the search. It returns
+// the matching records with their scores and any error encountered.
+func (db *DB) SearchText(tblName string, searchField string, search string) ([]TextMatch, error) {
+	return db.SearchTextCtx(context.Background(), tblName, searchField, search)
+}
+
+// SearchTextCtx is SearchText with a context. A search of a field without an
+// index, which reads every record, stops with the context's error as soon as
+// the context is done.
+func (db *DB) SearchTextCtx(ctx context.Context, tblName string, searchField string, search string) (_ []TextMatch, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	analyzer := db.analyzers[tblName]
+
+	terms := uniqueTerms(analyzer.Terms(search))
+	if len(terms) == 0 {
+		return []TextMatch{}, nil
+	}
+
+	var stats *textStats
+
+	if fldIndex, ok := db.fldIndex(tblName)[searchField+"@text"]; ok {
+		db.metrics.countIndexHit(tblName)
+
+		stats = indexTextStats(fldIndex, terms)
+	} else {
+		start := time.Now()
+		defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+		stats, err = db.scanTextStats(ctx, tblName, searchField, analyzer, terms)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stats.rank(terms), nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// scanTextStats reads every record of a table to find the text statistics of
+// a field for the terms of a search. The caller must hold the table's read
+// lock.
+func (db *DB) scanTextStats(ctx context.Context, tblName string, searchField string, analyzer Analyzer, terms []string) (*textStats, error) {
+	stats := &textStats{lens: make(map[string]int), freqs: make(map[string]map[string]int)}
+
+	fileIds, err := db.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range fileIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := db.readRec(tblName, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := db.decodeFields(data)
+		if err != nil {
+			return nil, err
+		}
+
+		text, _ := fieldValue(rec, searchField).(string)
+
+		recTerms := analyzer.Terms(text)
+		if len(recTerms) == 0 {
+			continue
+		}
+
+		stats.docs++
+		stats.totalLen += len(recTerms)
+
+		for _, term := range recTerms {
+			if !stringInSlice(term, terms) {
+				continue
+			}
+
+			if stats.freqs[term] == nil {
+				stats.freqs[term] = make(map[string]int)
+			}
+			stats.freqs[term][fileId]++
+			stats.lens[fileId] = len(recTerms)
+		}
+	}
+
+	return stats, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// rank returns the records matching any of the terms, by descending score.
+func (s *textStats) rank(terms []string) []TextMatch {
+	scores := make(map[string]float64)
+
+	avgLen := 0.0
+	if s.docs > 0 {
+		avgLen = float64(s.totalLen) / float64(s.docs)
+	}
+
+	for _, term := range terms {
+		freqs := s.freqs[term]
+		if len(freqs) == 0 {
+			continue
+		}
+
+		df := float64(len(freqs))
+		idf := math.Log(1 + (float64(s.docs)-df+0.5)/(df+0.5))
+
+		for fileId, freq := range freqs {
+			tf := float64(freq)
+			norm := 1 - bm25B + bm25B*float64(s.lens[fileId])/avgLen
+			scores[fileId] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	matches := make([]TextMatch, 0, len(scores))
+	for fileId, score := range scores {
+		matches = append(matches, TextMatch{Id: fileId, Score: score})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return idNum(matches[i].Id) < idNum(matches[j].Id)
+	})
+
+	return matches
+}
+
+// indexTextStats returns the text statistics of a field for the terms of a
+// search, from the field's text index; see textKeys.
+func indexTextStats(fldIndex map[string][]string, terms []string) *textStats {
+	stats := &textStats{lens: make(map[string]int), freqs: make(map[string]map[string]int)}
+
+	for _, term := range terms {
+		freqs := make(map[string]int)
+
+		for n, key := 1, term; len(fldIndex[key]) > 0; n, key = n+1, term+"\x00"+strconv.Itoa(n+1) {
+			for _, fileId := range fldIndex[key] {
+				freqs[fileId] = n
+			}
+		}
+
+		if len(freqs) > 0 {
+			stats.freqs[term] = freqs
+		}
+	}
+
+	for key, fileIds := range fldIndex {
+		if !strings.HasPrefix(key, "\x00") {
+			continue
+		}
+
+		n, _ := strconv.Atoi(key[1:])
+
+		stats.docs += len(fileIds)
+		stats.totalLen += n * len(fileIds)
+
+		for _, fileId := range fileIds {
+			stats.lens[fileId] = n
+		}
+	}
+
+	return stats
+}
+
+// textKeys returns the keys of a text field value in a text index. Every term
+// of the text is a key; a term occurring n times is also a key followed by a
+// NUL and every count from 2 to n, so that the index holds how often it
+// occurs; and a NUL followed by the number of terms of the text is a key, so
+// that the index holds the length of the text. Values that are not strings
+// have no keys.
+func textKeys(analyzer Analyzer, value interface{}) []string {
+	text, _ := value.(string)
+
+	terms := analyzer.Terms(text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, term := range terms {
+		counts[term]++
+	}
+
+	keys := []string{"\x00" + strconv.Itoa(len(terms))}
+
+	for term, count := range counts {
+		keys = append(keys, term)
+		for n := 2; n <= count; n++ {
+			keys = append(keys, term+"\x00"+strconv.Itoa(n))
+		}
+	}
+
+	return keys
+}
+
+// uniqueTerms returns terms without duplicates, in their order.
+func uniqueTerms(terms []string) []string {
+	var unique []string
+
+	for _, term := range terms {
+		if !stringInSlice(term, unique) {
+			unique = append(unique, term)
+		}
+	}
+
+	return unique
+}
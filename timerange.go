@@ -0,0 +1,173 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// timeKeyLayout is the layout of the keys of time indexes, which sort as
+// strings in the order of their instants.
+const timeKeyLayout = "2006-01-02T15:04:05.000000000Z"
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// FindAllIdsForFieldBetweenTimes returns the ids of the records holding a time
+// in a field from from up to, but not including, to, ordered by that time and
+// then by id. A zero from or to leaves the range open at that end. Times are
+// RFC 3339 strings, such as "2024-05-01T12:00:00Z", or integer Unix
+// timestamps in seconds; records holding anything else in the field never
+// match. A field is indexed by its times, rather than as it is written, by
+// naming it with an "@time" suffix in the fields to index, such as
+// "created_at@time", so that a range is read from the index without decoding
+// any record. It takes a table name, a field name, and the start and end of
+// the range. It returns a slice of record ids and any error encountered.
+func (db *DB) FindAllIdsForFieldBetweenTimes(tblName string, searchField string, from time.Time, to time.Time) ([]string, error) {
+	return db.FindAllIdsForFieldBetweenTimesCtx(context.Background(), tblName, searchField, from, to)
+}
+
+// FindAllIdsForFieldBetweenTimesCtx is FindAllIdsForFieldBetweenTimes with a
+// context. A search of a field without an index, which reads every record,
+// stops with the context's error as soon as the context is done.
+func (db *DB) FindAllIdsForFieldBetweenTimesCtx(ctx context.Context, tblName string, searchField string, from time.Time, to time.Time) (_ []string, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	op, err := db.beginOp(ctx, tblName, "", "query")
+	defer func() { db.endOp(op, "", err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	rwLock := db.tblLock(tblName)
+	if rwLock == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+
+	db.metrics.countOp(tblName, "query")
+
+	return db.idsBetweenTimes(ctx, tblName, searchField, from, to)
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// idsBetweenTimes returns the ids of the records of a table holding a time in
+// a field within a range; see FindAllIdsForFieldBetweenTimes. The caller must
+// hold the table's lock.
+func (db *DB) idsBetweenTimes(ctx context.Context, tblName string, searchField string, from time.Time, to time.Time) ([]string, error) {
+	var fromKey, toKey string
+	if !from.IsZero() {
+		fromKey = from.UTC().Format(timeKeyLayout)
+	}
+	if !to.IsZero() {
+		toKey = to.UTC().Format(timeKeyLayout)
+	}
+
+	inRange := func(key string) bool {
+		return key >= fromKey && (toKey == "" || key < toKey)
+	}
+
+	// The ids of the records in range, keyed by their times.
+	found := make(map[string][]string)
+
+	if fldIndex, ok := db.fldIndex(tblName)[searchField+"@time"]; ok {
+		db.metrics.countIndexHit(tblName)
+
+		for key, fileIds := range fldIndex {
+			if inRange(key) {
+				found[key] = fileIds
+			}
+		}
+	} else {
+		start := time.Now()
+		defer func() { db.metrics.observeScan(tblName, time.Since(start)) }()
+
+		fileIds, err := db.engine.ids(tblName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fileId := range fileIds {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			data, err := db.readRec(tblName, fileId)
+			if err != nil {
+				return nil, err
+			}
+
+			rec, err := db.decodeFields(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if key, ok := timeKey(fieldValue(rec, searchField)); ok && inRange(key) {
+				found[key] = append(found[key], fileId)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ids []string
+
+	for _, key := range keys {
+		fileIds := append([]string(nil), found[key]...)
+		sort.Slice(fileIds, func(i, j int) bool { return idNum(fileIds[i]) < idNum(fileIds[j]) })
+
+		ids = append(ids, fileIds...)
+	}
+
+	return ids, nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// timeKey returns the key of a time index for a field value, and whether the
+// value holds a time.
+func timeKey(value interface{}) (string, bool) {
+	t, ok := timeValue(value)
+	if !ok {
+		return "", false
+	}
+
+	return t.UTC().Format(timeKeyLayout), true
+}
+
+// timeValue returns the time a field value holds: an RFC 3339 string or an
+// integer Unix timestamp in seconds. It returns false for any other value.
+func timeValue(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(v), 0), true
+	}
+
+	return time.Time{}, false
+}
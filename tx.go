@@ -0,0 +1,427 @@
+package ivy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// txKey is the key of the transaction of a write in a context.
+type txKey struct{}
+
+// Type Tx is a struct holding a transaction started by DB.Transact. Its
+// writes are applied as they are made, but are only reported to webhooks,
+// watchers, subscriptions and the audit log, and made visible to the readers
+// of Options.MVCC, once the transaction commits, and are undone if it does
+// not. A Tx is not safe for concurrent use.
+type Tx struct {
+	db      *DB
+	ctx     context.Context
+	tables  map[string]bool
+	walTx   uint64
+	version uint64
+	lsns    []uint64
+	changes []txChange
+	commits []func()
+	undoing bool
+	err     error
+	done    bool
+
+	// outboxLocked is set once Emit locks the outbox table.
+	outboxLocked bool
+
+	// gitChanges are the changes to commit to git once the transaction
+	// commits; see Options.Git.
+	gitChanges []gitChange
+}
+
+// txChange is a write of a transaction, with the stored version of the
+// record it replaced, nil if the record was new, to undo it.
+type txChange struct {
+	tblName string
+	fileId  string
+	old     []byte
+}
+
+// Create creates a new record in a table of the transaction; see DB.Create.
+// It returns the id of the record and any error encountered.
+func (tx *Tx) Create(tblName string, rec interface{}) (fileId string, err error) {
+	db := tx.db
+
+	op, err := db.beginOp(tx.ctx, tblName, "", "create")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	if err := tx.check(tblName); err != nil {
+		return "", err
+	}
+
+	fileId, err = db.nextAvailableFileId(tblName)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	data, err := db.codec.Marshal(rec)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	err = db.writeRec(tx.ctx, tblName, fileId, data, false)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+
+	return fileId, nil
+}
+
+// Update updates a record of a table of the transaction; see DB.Update. It
+// returns any error encountered.
+func (tx *Tx) Update(tblName string, rec interface{}, fileId string) (err error) {
+	db := tx.db
+
+	op, err := db.beginOp(tx.ctx, tblName, fileId, "update")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return tx.fail(err)
+	}
+
+	if err := tx.check(tblName); err != nil {
+		return err
+	}
+
+	if err := db.checkMutable(tblName); err != nil {
+		return tx.fail(err)
+	}
+
+	if err := db.checkUnrestricted(tblName); err != nil {
+		return tx.fail(err)
+	}
+
+	if err := checkId(fileId); err != nil {
+		return tx.fail(err)
+	}
+
+	data, err := db.codec.Marshal(rec)
+	if err != nil {
+		return tx.fail(err)
+	}
+
+	return tx.fail(db.writeRec(tx.ctx, tblName, fileId, data, true))
+}
+
+// Delete deletes a record of a table of the transaction; see DB.Delete. It
+// returns any error encountered.
+func (tx *Tx) Delete(tblName string, fileId string) (err error) {
+	db := tx.db
+
+	op, err := db.beginOp(tx.ctx, tblName, fileId, "delete")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return tx.fail(err)
+	}
+
+	if err := tx.check(tblName); err != nil {
+		return err
+	}
+
+	if err := db.checkMutable(tblName); err != nil {
+		return tx.fail(err)
+	}
+
+	if err := checkId(fileId); err != nil {
+		return tx.fail(err)
+	}
+
+	err = db.removeRec(tx.ctx, tblName, fileId)
+	if err != nil {
+		return tx.fail(recErr(tblName, fileId, err))
+	}
+
+	return nil
+}
+
+// Find loads up a Record struct with a record of a table of the transaction,
+// including the writes of the transaction; see DB.Find. It returns any error
+// encountered.
+func (tx *Tx) Find(tblName string, rec Record, fileId string) (err error) {
+	db := tx.db
+
+	op, err := db.beginOp(tx.ctx, tblName, fileId, "find")
+	defer func() { db.endOp(op, fileId, err) }()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.check(tblName); err != nil {
+		return err
+	}
+
+	if err := checkId(fileId); err != nil {
+		return err
+	}
+
+	err = db.loadRec(tblName, rec, fileId)
+	if err != nil {
+		return recErr(tblName, fileId, err)
+	}
+
+	rec.AfterFind(db, fileId)
+
+	return nil
+}
+
+// check returns an error if the transaction is over or does not hold the
+// lock of a table.
+func (tx *Tx) check(tblName string) error {
+	if tx.done {
+		return ErrClosed
+	}
+
+	if !tx.tables[tblName] {
+		return fmt.Errorf("ivy: table %s is not part of the transaction", tblName)
+	}
+
+	return nil
+}
+
+// fail records the first error of a write, which rolls the transaction back
+// whatever the function of Transact returns, and returns it.
+func (tx *Tx) fail(err error) error {
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+
+	return err
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Transact runs fn with a transaction writing to the supplied tables, such
+// as to move stock from one warehouse record to another, so that either all
+// its writes happen or none do. The tables are write-locked, in order, until
+// the transaction is over. The transaction commits if fn returns nil and
+// every write succeeded, and is rolled back otherwise, including if fn
+// panics, in which case the panic carries on once the transaction is rolled
+// back; with the write-ahead log, a transaction interrupted by a crash is
+// rolled back by OpenDB. It
+// takes a context, the names of the tables and the function. It returns the
+// error of fn or of the first write that failed, or any other error
+// encountered.
+func (db *DB) Transact(ctx context.Context, tblNames []string, fn func(tx *Tx) error) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	tx := &Tx{db: db, tables: make(map[string]bool)}
+
+	for _, tblName := range tblNames {
+		if db.tblLock(tblName) == nil {
+			return tableNotFoundErr(tblName)
+		}
+		if db.outbox != nil && tblName == db.outbox.opts.Table {
+			return fmt.Errorf("ivy: the outbox table %s is written with Tx.Emit", tblName)
+		}
+		tx.tables[tblName] = true
+	}
+
+	names := make([]string, 0, len(tx.tables))
+	for tblName := range tx.tables {
+		names = append(names, tblName)
+	}
+	sort.Strings(names)
+
+	for _, tblName := range names {
+		rwLock := db.tblLock(tblName)
+		rwLock.Lock()
+		defer rwLock.Unlock()
+	}
+
+	defer func() {
+		if tx.outboxLocked {
+			db.tblLock(db.outbox.opts.Table).Unlock()
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if db.wal != nil {
+		tx.walTx = db.wal.newTx()
+	}
+	if db.versions != nil {
+		tx.version = db.versions.begin()
+		defer db.versions.publish(tx.version)
+	}
+
+	tx.ctx = context.WithValue(ctx, txKey{}, tx)
+
+	defer func() { tx.done = true }()
+
+	defer func() {
+		if p := recover(); p != nil {
+			if rerr := db.rollbackTx(tx); rerr != nil {
+				db.logger.Error("ivy: transaction rollback failed", "err", rerr)
+			}
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err == nil {
+		err = tx.err
+	}
+	if err == nil {
+		err = db.commitTx(tx)
+		if err == nil {
+			return nil
+		}
+	}
+
+	if rerr := db.rollbackTx(tx); rerr != nil {
+		db.logger.Error("ivy: transaction rollback failed", "err", rerr)
+		return fmt.Errorf("%w; rollback failed: %w", err, rerr)
+	}
+
+	return err
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// commitTx commits a transaction and reports its writes.
+func (db *DB) commitTx(tx *Tx) error {
+	if db.wal != nil && len(tx.lsns) > 0 {
+		lsn, err := db.wal.log(walEntry{Tx: tx.walTx, Op: walCommit})
+		if err != nil {
+			return err
+		}
+		db.wal.applied(lsn)
+
+		for _, lsn := range tx.lsns {
+			db.wal.applied(lsn)
+		}
+	}
+
+	for _, commit := range tx.commits {
+		commit()
+	}
+
+	db.gitCommit(tx.gitChanges)
+
+	return nil
+}
+
+// rollbackTx undoes the writes of a transaction, in reverse order, without
+// reporting them.
+func (db *DB) rollbackTx(tx *Tx) error {
+	tx.undoing = true
+
+	var err error
+
+	for i := len(tx.changes) - 1; i >= 0 && err == nil; i-- {
+		err = db.undoChange(tx, tx.changes[i])
+	}
+
+	if db.wal != nil && len(tx.lsns) > 0 {
+		if err == nil {
+			lsn, lerr := db.wal.log(walEntry{Tx: tx.walTx, Op: walAbort})
+			if lerr != nil {
+				err = lerr
+			} else {
+				db.wal.applied(lsn)
+			}
+		}
+
+		// Without an abort entry, OpenDB rolls the transaction back, as
+		// it was never committed.
+		for _, lsn := range tx.lsns {
+			db.wal.applied(lsn)
+		}
+	}
+
+	tx.commits = nil
+
+	return err
+}
+
+// undoChange puts a record written by a transaction back as it was before.
+// The caller must hold the table's write lock.
+func (db *DB) undoChange(tx *Tx, ch txChange) error {
+	curRaw, err := db.engine.read(ch.tblName, ch.fileId)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if curRaw == nil && ch.old == nil {
+		return nil
+	}
+
+	var curData, oldData []byte
+
+	rebuildIndexes := false
+
+	if curRaw != nil {
+		if curData, err = db.decodeRec(ch.tblName, ch.fileId, curRaw); err != nil {
+			rebuildIndexes = true
+		}
+	}
+	if ch.old != nil {
+		if oldData, err = db.decodeRec(ch.tblName, ch.fileId, ch.old); err != nil {
+			rebuildIndexes = true
+		}
+	}
+
+	_, err = db.logWrite(tx, ch.tblName, ch.fileId, ch.old, curRaw)
+	if err != nil {
+		return err
+	}
+
+	if ch.old == nil {
+		db.trackUsage(ch.tblName, ch.fileId, -1)
+	} else {
+		db.trackUsage(ch.tblName, ch.fileId, int64(len(ch.old)))
+	}
+
+	db.bumpGeneration(ch.tblName)
+
+	if rebuildIndexes {
+		return db.initTblIndexes(context.Background(), ch.tblName)
+	}
+
+	return db.updateTblIndexes(ch.tblName, ch.fileId, curData, oldData)
+}
+
+// onCommit runs fn now, or once the transaction of a write commits, which
+// is nil for a write of its own.
+func (db *DB) onCommit(tx *Tx, fn func()) {
+	if tx == nil {
+		fn()
+		return
+	}
+
+	if !tx.undoing {
+		tx.commits = append(tx.commits, fn)
+	}
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// txFromContext returns the transaction of a write, or nil if the write is
+// not part of one.
+func txFromContext(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	return tx
+}
@@ -0,0 +1,149 @@
+package ivy
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Type TypedTable is a struct giving access to the records of a table as
+// values of one Go type, so that callers do not pass table names and empty
+// interfaces around. It is returned by Table. T is a pointer to the record
+// struct, such as *Plane, and is allocated by the methods that return
+// records.
+type TypedTable[T Record] struct {
+	db      *DB
+	name    string
+	recType reflect.Type
+}
+
+// Table returns the table of a database with the supplied name, with its
+// records typed as T, which must be a pointer to a struct:
+//
+//	planes := ivy.Table[*Plane](db, "planes")
+//	plane, err := planes.Find("1")
+//
+// The table is not checked for existence until it is used. It panics if T is
+// not a pointer type. It takes a database and a table name. It returns the
+// typed table.
+func Table[T Record](db *DB, tblName string) *TypedTable[T] {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("ivy: Table needs a pointer record type, not %v", typ))
+	}
+
+	return &TypedTable[T]{db: db, name: tblName, recType: typ.Elem()}
+}
+
+// Name returns the name of the table.
+func (t *TypedTable[T]) Name() string {
+	return t.name
+}
+
+// Find returns the record with the supplied id. It takes a record id. It
+// returns the record and any error encountered.
+func (t *TypedTable[T]) Find(fileId string) (T, error) {
+	rec := t.newRec()
+
+	err := t.db.Find(t.name, rec, fileId)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return rec, nil
+}
+
+// FindMany returns the records with the supplied ids, in the same order. It
+// takes the record ids. It returns the records and any error encountered.
+func (t *TypedTable[T]) FindMany(fileIds []string) ([]T, error) {
+	recs := make([]T, 0, len(fileIds))
+
+	for _, fileId := range fileIds {
+		rec, err := t.Find(fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, rec)
+	}
+
+	return recs, nil
+}
+
+// All returns every record of the table, in id order. It returns the records
+// and any error encountered.
+func (t *TypedTable[T]) All() ([]T, error) {
+	fileIds, err := t.db.FindAllIds(t.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.FindMany(sortedIdList(fileIds))
+}
+
+// FindAllForField returns the records whose field has the supplied value, as
+// DB.FindAllIdsForField finds them. It takes a field name and a value. It
+// returns the records and any error encountered.
+func (t *TypedTable[T]) FindAllForField(fldName string, value string) ([]T, error) {
+	fileIds, err := t.db.FindAllIdsForField(t.name, fldName, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.FindMany(fileIds)
+}
+
+// FindAllForTags returns the records that have all of the supplied tags, as
+// DB.FindAllIdsForTags finds them. It takes the tags. It returns the records
+// and any error encountered.
+func (t *TypedTable[T]) FindAllForTags(tags []string) ([]T, error) {
+	fileIds, err := t.db.FindAllIdsForTags(t.name, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.FindMany(fileIds)
+}
+
+// Query returns the records matching a query of the query language, in the
+// order of the query's results; see DB.QueryString. It takes the query and
+// the arguments of its placeholders. It returns the records and any error
+// encountered.
+func (t *TypedTable[T]) Query(queryStr string, args ...interface{}) ([]T, error) {
+	fileIds, err := t.QueryIds(queryStr, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.FindMany(fileIds)
+}
+
+// QueryIds returns the ids of the records matching a query of the query
+// language; see DB.QueryString. It takes the query and the arguments of its
+// placeholders. It returns the record ids and any error encountered.
+func (t *TypedTable[T]) QueryIds(queryStr string, args ...interface{}) ([]string, error) {
+	return t.db.QueryString(t.name, queryStr, args...)
+}
+
+// Create creates a new record. It takes the record. It returns the id of the
+// new record and any error encountered.
+func (t *TypedTable[T]) Create(rec T) (string, error) {
+	return t.db.Create(t.name, rec)
+}
+
+// Update replaces a record. It takes the record and its id. It returns any
+// error encountered.
+func (t *TypedTable[T]) Update(rec T, fileId string) error {
+	return t.db.Update(t.name, rec, fileId)
+}
+
+// Delete deletes a record. It takes the record id. It returns any error
+// encountered.
+func (t *TypedTable[T]) Delete(fileId string) error {
+	return t.db.Delete(t.name, fileId)
+}
+
+// newRec returns a new, empty record.
+func (t *TypedTable[T]) newRec() T {
+	return reflect.New(t.recType).Interface().(T)
+}
@@ -0,0 +1,207 @@
+package ivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Type Uploader is an interface for sending backups to a remote destination.
+// Upload stores everything read from r under the supplied name, returning an
+// error if it fails. S3FileSystem and HTTPUploader implement it; other
+// destinations, such as an SFTP server, can be plugged in by implementing it,
+// or with UploaderFunc.
+type Uploader interface {
+	Upload(name string, r io.Reader) error
+}
+
+// Type UploaderFunc is an adapter to allow the use of ordinary functions as
+// Uploaders.
+type UploaderFunc func(name string, r io.Reader) error
+
+// Upload calls f(name, r).
+func (f UploaderFunc) Upload(name string, r io.Reader) error {
+	return f(name, r)
+}
+
+// Type HTTPUploader is an Uploader that sends backups to a web server with an
+// HTTP PUT request to URL, followed by a slash and the backup name. The body
+// is streamed with chunked transfer encoding.
+type HTTPUploader struct {
+	// URL is the base URL backups are uploaded to.
+	URL string
+
+	// Header holds extra request headers, such as Authorization.
+	Header http.Header
+
+	// Client is the HTTP client used for requests. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Upload sends everything read from r to the server.
+func (u *HTTPUploader) Upload(name string, r io.Reader) error {
+	req, err := http.NewRequest("PUT", strings.TrimRight(u.URL, "/")+"/"+name, r)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range u.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ivy: upload of %s failed: %v: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// errUploadStopped is the error seen by a backup whose upload stopped early.
+var errUploadStopped = errors.New("ivy: upload stopped")
+
+// Type UploadOptions is a struct holding the options of DB.BackupTo.
+type UploadOptions struct {
+	// Attempts is the number of times the upload is tried before giving up.
+	// It defaults to 3.
+	Attempts int
+
+	// Backoff is how long to wait before the first retry. It doubles after
+	// every failed attempt. It defaults to one second.
+	Backoff time.Duration
+
+	// Since, if set, makes the backup an incremental one, as with
+	// DB.BackupIncremental.
+	Since *BackupManifest
+
+	// Key, if set, encrypts the backup with EncryptBackup before it is
+	// uploaded.
+	Key []byte
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// BackupTo streams a backup, as written by Backup, to a remote destination.
+// The archive is never stored locally; if an upload fails, the backup is
+// written again for the next attempt. It takes the uploader to send the
+// backup to, the name to store it under, and the upload options. It returns
+// any error encountered, which is the error of the last attempt if every
+// attempt failed.
+func (db *DB) BackupTo(u Uploader, name string, opts UploadOptions) error {
+	return db.BackupToCtx(context.Background(), u, name, opts)
+}
+
+// BackupToCtx is BackupTo with a context, which is handed to the authorizer
+// and stops reading records when it is done.
+func (db *DB) BackupToCtx(ctx context.Context, u Uploader, name string, opts UploadOptions) (err error) {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.leave()
+
+	ops, err := db.beginOps(ctx, db.tableNames(), "backup")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return err
+	}
+
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+
+		retry, err = db.uploadBackup(ctx, u, name, opts.Since, opts.Key)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// uploadBackup writes a backup into a pipe that feeds the uploader. It returns
+// whether a failure is worth retrying, which it is unless writing the backup
+// itself failed, and any error encountered.
+func (db *DB) uploadBackup(ctx context.Context, u Uploader, name string, since *BackupManifest, key []byte) (bool, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		err := db.writeBackup(ctx, pw, since, key)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := u.Upload(name, pr)
+	if err == nil {
+		if n, _ := io.Copy(ioutil.Discard, pr); n > 0 {
+			err = fmt.Errorf("ivy: upload of %s ended before the end of the backup", name)
+		}
+	}
+
+	// Unblock the backup if the uploader gave up early.
+	pr.CloseWithError(errUploadStopped)
+
+	backupErr := <-done
+	if backupErr != nil && !errors.Is(backupErr, errUploadStopped) {
+		return false, backupErr
+	}
+
+	return true, err
+}
+
+// writeBackup writes a backup to w, encrypted with key if it is set.
+func (db *DB) writeBackup(ctx context.Context, w io.Writer, since *BackupManifest, key []byte) error {
+	if key == nil {
+		return db.backup(ctx, w, since)
+	}
+
+	ew, err := EncryptBackup(w, key)
+	if err != nil {
+		return err
+	}
+
+	err = db.backup(ctx, ew, since)
+	if err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
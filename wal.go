@@ -0,0 +1,765 @@
+package ivy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type WALOptions configures the write-ahead log. With a write-ahead log,
+// every change is appended to a log in the database's .ivy/wal directory, and
+// synced to disk, before it is applied to the records. If the program
+// crashes, the next OpenDB replays the changes that were logged but may not
+// have reached the records, and rolls back the ones that were never
+// committed. The write-ahead log requires the local file system.
+type WALOptions struct {
+	// SegmentSize is the size, in bytes, at which the log moves on to a new
+	// segment file. It defaults to 16MB.
+	SegmentSize int64
+
+	// NoSync skips syncing the log to disk after every change. Changes made
+	// shortly before a power failure may then be lost, but the records can
+	// still be recovered to a consistent state.
+	NoSync bool
+
+	// KeepSegments keeps segments that are no longer needed for recovery
+	// instead of deleting them at each checkpoint.
+	KeepSegments bool
+
+	// ArchiveDir, if set, is a directory every segment is copied to when the
+	// log moves on to the next one, and the current segment when the database
+	// is closed. Together with a backup, the archive allows RestoreToTime to
+	// recover the database as it was at any moment since the backup.
+	ArchiveDir string
+}
+
+// Type RecoveryReport is a struct describing what OpenDB did to recover from
+// an unclean shutdown. Clean is true if the database was closed properly, in
+// which case there was nothing to do. FromLSN and ToLSN are the range of log
+// sequence numbers that were examined. Replayed is the number of committed
+// changes that were applied again, RolledBack the number of uncommitted
+// changes that were undone, and TruncatedBytes the size of a partially
+// written entry that was cut off the end of the log.
+type RecoveryReport struct {
+	Clean          bool
+	FromLSN        uint64
+	ToLSN          uint64
+	Replayed       int
+	RolledBack     int
+	TruncatedBytes int64
+}
+
+// Operations recorded in the write-ahead log.
+const (
+	walPut        = "put"
+	walDelete     = "delete"
+	walCommit     = "commit"
+	walAbort      = "abort"
+	walCheckpoint = "checkpoint"
+)
+
+// walSegmentExt is the extension of write-ahead log segment files, which are
+// named after the sequence number of their first entry.
+const walSegmentExt = ".wal"
+
+// walHeaderSize is the size of the header preceding every log entry: the
+// length and the CRC-32 checksum of the entry.
+const walHeaderSize = 8
+
+// walEntry is an entry of the write-ahead log.
+//
+// Changes are grouped into transactions. A put or delete entry carries the new
+// and the old version of the record, so that it can be both redone and
+// undone. A transaction is committed by its last change having Commit set, or
+// by a separate commit entry. A checkpoint entry records that every change
+// before sequence number Redo has reached stable storage.
+type walEntry struct {
+	LSN    uint64    `json:"lsn"`
+	Time   time.Time `json:"time"`
+	Tx     uint64    `json:"tx,omitempty"`
+	Op     string    `json:"op"`
+	Table  string    `json:"table,omitempty"`
+	Id     string    `json:"id,omitempty"`
+	Data   []byte    `json:"data,omitempty"`
+	Old    []byte    `json:"old,omitempty"`
+	Commit bool      `json:"commit,omitempty"`
+	Redo   uint64    `json:"redo,omitempty"`
+}
+
+// wal is an append-only, segmented write-ahead log.
+type wal struct {
+	dir          string
+	segmentSize  int64
+	noSync       bool
+	keepSegments bool
+	archiveDir   string
+	sealer       *sealer
+	modes        fileModes
+
+	mu       sync.Mutex
+	f        *os.File
+	segStart uint64
+	segSize  int64
+	nextLSN  uint64
+	nextTx   uint64
+	inflight map[uint64]bool
+	openTxs  map[uint64]uint64
+
+	// unchecked is the number of bytes logged since the last checkpoint.
+	unchecked int64
+}
+
+// openWAL opens the write-ahead log in dir, creating it if necessary with the
+// supplied modes. It returns the log, every entry in it, and the number of
+// bytes of a partially written entry that was cut off its end.
+func openWAL(dir string, opts WALOptions, s *sealer, modes fileModes) (*wal, []walEntry, int64, error) {
+	w := &wal{
+		dir:          dir,
+		segmentSize:  opts.SegmentSize,
+		noSync:       opts.NoSync,
+		keepSegments: opts.KeepSegments,
+		archiveDir:   opts.ArchiveDir,
+		sealer:       s,
+		modes:        modes,
+		nextLSN:      1,
+		nextTx:       1,
+		inflight:     make(map[uint64]bool),
+		openTxs:      make(map[uint64]uint64),
+	}
+
+	if w.segmentSize <= 0 {
+		w.segmentSize = 16 << 20
+	}
+
+	err := modes.mkdirAll(dir)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if w.archiveDir != "" {
+		err = modes.mkdirAll(w.archiveDir)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	starts, err := w.segments()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var entries []walEntry
+	var truncated int64
+
+	for i, start := range starts {
+		last := i == len(starts)-1
+
+		segEntries, valid, size, err := readWALSegment(w.segmentPath(start), s)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		if valid < size {
+			if !last {
+				return nil, nil, 0, fmt.Errorf("%w: write-ahead log segment %s", ErrCorrupt, w.segmentPath(start))
+			}
+
+			// The program crashed while appending the last entry.
+			truncated = size - valid
+
+			err = os.Truncate(w.segmentPath(start), valid)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+		}
+
+		entries = append(entries, segEntries...)
+
+		if last {
+			w.segStart = start
+			w.segSize = valid
+		}
+	}
+
+	for _, e := range entries {
+		if e.LSN >= w.nextLSN {
+			w.nextLSN = e.LSN + 1
+		}
+		if e.Tx >= w.nextTx {
+			w.nextTx = e.Tx + 1
+		}
+	}
+
+	if len(starts) == 0 {
+		w.segStart = w.nextLSN
+	}
+
+	w.f, err = modes.openFile(w.segmentPath(w.segStart), os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return w, entries, truncated, nil
+}
+
+// newTx returns a new transaction id.
+func (w *wal) newTx() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx := w.nextTx
+	w.nextTx++
+
+	return tx
+}
+
+// log appends an entry to the log, assigning its sequence number and time,
+// and syncs it to disk. Puts and deletes count as in flight until applied is
+// called with their sequence number. It returns the sequence number.
+func (w *wal) log(e walEntry) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e.LSN = w.nextLSN
+	e.Time = time.Now().UTC()
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err = w.sealer.seal(payload, "wal")
+	if err != nil {
+		return 0, err
+	}
+
+	if w.segSize > 0 && w.segSize+int64(walHeaderSize+len(payload)) > w.segmentSize {
+		err = w.rotate(e.LSN)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, checksumTable))
+	buf = append(buf, payload...)
+
+	_, err = w.f.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	if !w.noSync {
+		err = w.f.Sync()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	w.segSize += int64(len(buf))
+	w.unchecked += int64(len(buf))
+	w.nextLSN++
+
+	switch e.Op {
+	case walPut, walDelete:
+		w.inflight[e.LSN] = true
+		if !e.Commit {
+			if _, ok := w.openTxs[e.Tx]; !ok {
+				w.openTxs[e.Tx] = e.LSN
+			}
+		} else {
+			delete(w.openTxs, e.Tx)
+		}
+	case walCommit, walAbort:
+		delete(w.openTxs, e.Tx)
+	}
+
+	return e.LSN, nil
+}
+
+// last returns the sequence number of the last entry logged, or zero if there
+// is none.
+func (w *wal) last() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.nextLSN - 1
+}
+
+// applied records that a change has been applied to the storage engine.
+func (w *wal) applied(lsn uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.inflight, lsn)
+}
+
+// checkpoint syncs the storage engine, using the supplied function, and then
+// logs that every change that was applied before it started has reached
+// stable storage. Segments that are no longer needed for recovery are then
+// deleted, unless they are to be kept.
+func (w *wal) checkpoint(sync func() error) error {
+	redo := w.horizon()
+	unchecked := w.backlog()
+
+	err := sync()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.log(walEntry{Op: walCheckpoint, Redo: redo})
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.unchecked -= unchecked
+	w.mu.Unlock()
+
+	if w.keepSegments {
+		return nil
+	}
+
+	return w.removeSegmentsBefore(redo)
+}
+
+// horizon returns the lowest sequence number that may still belong to a
+// change that is not applied, or a transaction that is not finished. Every
+// change before it is final.
+func (w *wal) horizon() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	horizon := w.nextLSN
+	for lsn := range w.inflight {
+		if lsn < horizon {
+			horizon = lsn
+		}
+	}
+	for _, lsn := range w.openTxs {
+		if lsn < horizon {
+			horizon = lsn
+		}
+	}
+
+	return horizon
+}
+
+// backlog returns the number of bytes logged since the last checkpoint, which
+// recovery may have to read.
+func (w *wal) backlog() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.unchecked
+}
+
+// close closes the current segment, archiving it if there is an archive.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.f.Close()
+	if err != nil {
+		return err
+	}
+
+	return w.archive(w.segStart)
+}
+
+// rotate moves on to a new segment starting with the supplied sequence
+// number. The caller must hold w.mu.
+func (w *wal) rotate(start uint64) error {
+	err := w.f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = w.archive(w.segStart)
+	if err != nil {
+		return err
+	}
+
+	f, err := w.modes.openFile(w.segmentPath(start), os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	w.f = f
+	w.segStart = start
+	w.segSize = 0
+
+	return nil
+}
+
+// archive copies a segment to the archive directory, if there is one,
+// replacing an earlier, shorter copy of it. The caller must hold w.mu.
+func (w *wal) archive(start uint64) error {
+	if w.archiveDir == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(w.segmentPath(start))
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(w.modes.fs(), filepath.Join(w.archiveDir, filepath.Base(w.segmentPath(start))), data, w.modes.file)
+}
+
+// removeSegmentsBefore deletes the segments that only hold entries with
+// sequence numbers lower than lsn. The current segment is always kept.
+func (w *wal) removeSegmentsBefore(lsn uint64) error {
+	w.mu.Lock()
+	current := w.segStart
+	w.mu.Unlock()
+
+	starts, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(starts); i++ {
+		if starts[i+1] > lsn || starts[i] == current {
+			break
+		}
+
+		err = os.Remove(w.segmentPath(starts[i]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segments returns the starting sequence numbers of all segments, in order.
+func (w *wal) segments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []uint64
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		starts = append(starts, start)
+	}
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	return starts, nil
+}
+
+// segmentPath returns the path of the segment starting with the supplied
+// sequence number.
+func (w *wal) segmentPath(start uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016x%s", start, walSegmentExt))
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Recovery returns the report of the recovery done by OpenDB, or nil if the
+// database has no write-ahead log.
+func (db *DB) Recovery() *RecoveryReport {
+	return db.recovery
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// openWAL opens the write-ahead log of the database. It returns the entries
+// in the log and the size of a partially written entry cut off its end.
+func (db *DB) openWAL(opts WALOptions) ([]walEntry, int64, error) {
+	w, entries, truncated, err := openWAL(db.metaPath("wal"), opts, db.sealer, db.modes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	db.wal = w
+
+	return entries, truncated, nil
+}
+
+// recover brings the records up to date with the write-ahead log after an
+// unclean shutdown: committed changes since the last checkpoint are applied
+// again, and changes of transactions that never committed, or were aborted,
+// are undone. It then writes a checkpoint, so that the same changes are not
+// recovered again.
+func (db *DB) recover(entries []walEntry, truncated int64) (*RecoveryReport, error) {
+	report := &RecoveryReport{TruncatedBytes: truncated}
+
+	if len(entries) == 0 {
+		report.Clean = truncated == 0
+		return report, nil
+	}
+
+	last := entries[len(entries)-1]
+	if last.Op == walCheckpoint && last.Redo == last.LSN && truncated == 0 {
+		report.Clean = true
+		return report, nil
+	}
+
+	var start uint64
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Op == walCheckpoint {
+			start = entries[i].Redo
+			break
+		}
+	}
+
+	committed := make(map[uint64]bool)
+	aborted := make(map[uint64]bool)
+
+	var changes []walEntry
+
+	for _, e := range entries {
+		if e.LSN < start {
+			continue
+		}
+
+		switch e.Op {
+		case walPut, walDelete:
+			changes = append(changes, e)
+			if e.Commit {
+				committed[e.Tx] = true
+			}
+		case walCommit:
+			committed[e.Tx] = true
+		case walAbort:
+			aborted[e.Tx] = true
+		}
+	}
+
+	if len(changes) > 0 {
+		report.FromLSN = changes[0].LSN
+		report.ToLSN = changes[len(changes)-1].LSN
+	}
+
+	// Redo committed changes in order...
+	for _, e := range changes {
+		if !committed[e.Tx] || aborted[e.Tx] {
+			continue
+		}
+
+		data := e.Data
+		if e.Op == walDelete {
+			data = nil
+		}
+
+		err := db.restoreRec(e.Table, e.Id, data)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Replayed++
+	}
+
+	// ...and undo the others in reverse order.
+	for i := len(changes) - 1; i >= 0; i-- {
+		e := changes[i]
+		if committed[e.Tx] && !aborted[e.Tx] {
+			continue
+		}
+
+		err := db.restoreRec(e.Table, e.Id, e.Old)
+		if err != nil {
+			return nil, err
+		}
+
+		report.RolledBack++
+	}
+
+	err := db.wal.checkpoint(db.engine.sync)
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Warn("ivy: recovered from the write-ahead log", "from", report.FromLSN, "to", report.ToLSN,
+		"replayed", report.Replayed, "rolled_back", report.RolledBack, "truncated_bytes", report.TruncatedBytes)
+
+	return report, nil
+}
+
+// logWrite logs a change of a single record as a transaction of its own, or
+// as part of tx if it is not nil, applies it to the storage engine and, if
+// that fails, logs that the change was aborted. A nil data slice deletes the
+// record; old is the stored version of the record the change replaces, or nil
+// if there is none. It returns the sequence number of the change in the log,
+// or zero without a log.
+func (db *DB) logWrite(tx *Tx, tblName string, fileId string, data []byte, old []byte) (uint64, error) {
+	db.saveForSnapshots(tblName, fileId, old)
+
+	if db.versions != nil {
+		var version uint64
+		if tx != nil {
+			version = tx.version
+		} else {
+			version = db.versions.begin()
+			defer db.versions.publish(version)
+		}
+
+		// Undoing a transaction restores the versions saved by its writes.
+		if tx == nil || !tx.undoing {
+			db.versions.save(tblName, fileId, old, version)
+		}
+	}
+
+	var lsn uint64
+
+	if db.wal != nil {
+		e := walEntry{Tx: db.wal.newTx(), Op: walPut, Table: tblName, Id: fileId, Data: data, Old: old, Commit: true}
+		if tx != nil {
+			e.Tx, e.Commit = tx.walTx, false
+		}
+		if data == nil {
+			e.Op = walDelete
+		}
+
+		var err error
+
+		lsn, err = db.wal.log(e)
+		if err != nil {
+			return 0, err
+		}
+
+		// The changes of a transaction stay in flight until it is over,
+		// so that a checkpoint does not hide them from recovery.
+		if tx != nil {
+			tx.lsns = append(tx.lsns, lsn)
+		} else {
+			defer db.wal.applied(lsn)
+		}
+
+		err = db.applyWrite(tblName, fileId, data)
+		if err != nil {
+			if tx == nil {
+				db.wal.log(walEntry{Tx: e.Tx, Op: walAbort})
+			}
+			return 0, err
+		}
+	} else {
+		err := db.applyWrite(tblName, fileId, data)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if tx != nil && !tx.undoing {
+		tx.changes = append(tx.changes, txChange{tblName: tblName, fileId: fileId, old: old})
+	}
+
+	return lsn, nil
+}
+
+// applyWrite writes a record to the storage engine, or removes it if data is
+// nil, and refreshes the record if it is pinned.
+func (db *DB) applyWrite(tblName string, fileId string, data []byte) error {
+	var err error
+	if data == nil {
+		err = db.engine.remove(tblName, fileId)
+	} else {
+		err = db.engine.write(tblName, fileId, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	db.refreshPin(tblName, fileId, data)
+	db.watchWrite(tblName, fileId)
+
+	return nil
+}
+
+// restoreRec puts a record back into the state described by data, as
+// applyWrite does, except that removing a missing record is not an error.
+func (db *DB) restoreRec(tblName string, fileId string, data []byte) error {
+	err := db.applyWrite(tblName, fileId, data)
+	if data == nil && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	db.auditRec(tblName, fileId, auditRecover, "", data)
+
+	return nil
+}
+
+//=============================================================================
+// Helper Functions
+//=============================================================================
+
+// readWALSegment reads the entries of a segment file, decrypting them with s.
+// It returns the entries, the length of the part of the file holding
+// complete, valid entries, and the size of the file.
+func readWALSegment(path string, s *sealer) ([]walEntry, int64, int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var entries []walEntry
+	var offset int64
+
+	r := bytes.NewReader(data)
+
+	for r.Len() >= walHeaderSize {
+		header := make([]byte, walHeaderSize)
+		r.Read(header)
+
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		sum := binary.LittleEndian.Uint32(header[4:8])
+
+		if length > int64(r.Len()) {
+			break
+		}
+
+		payload := make([]byte, length)
+		r.Read(payload)
+
+		if crc32.Checksum(payload, checksumTable) != sum {
+			break
+		}
+
+		payload, err = s.open(payload, "wal")
+		if err != nil {
+			break
+		}
+
+		var e walEntry
+		if json.Unmarshal(payload, &e) != nil {
+			break
+		}
+
+		entries = append(entries, e)
+		offset += walHeaderSize + length
+	}
+
+	return entries, offset, int64(len(data)), nil
+}
@@ -0,0 +1,321 @@
+package ivy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultWatchPending is the default of WatchOptions.MaxPending.
+const defaultWatchPending = 10000
+
+// Type WatchEvent is a struct describing a change of a record sent by a
+// Watcher. Seq is the sequence number of the change in the write-ahead log,
+// or zero without one. Op is "create", "update" or "delete". Data holds the
+// new version of the record, or is nil if the record was deleted.
+type WatchEvent struct {
+	Seq   uint64          `json:"seq"`
+	Time  time.Time       `json:"time"`
+	Table string          `json:"table"`
+	Id    string          `json:"id"`
+	Op    string          `json:"op"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Type WatchOptions is a struct holding the options of DB.Watch.
+type WatchOptions struct {
+	// Replay sends the changes after the sequence number Since that are
+	// still in the write-ahead log before the live ones, such as to resume
+	// after the last event a consumer handled. It requires the write-ahead
+	// log, and fails with ErrReplicationGap if some of the changes are no
+	// longer in it.
+	Replay bool
+	Since  uint64
+
+	// MaxPending is the largest number of events waiting for the receiver.
+	// A watcher with more is closed, and Err returns ErrWatchLagged. It
+	// defaults to 10000.
+	MaxPending int
+}
+
+// Type Watcher is a struct holding a change feed returned by DB.Watch. C
+// receives an event for every change of a record, in the order the changes
+// were made, and is closed when the watcher or the database is closed.
+type Watcher struct {
+	C <-chan WatchEvent
+
+	db         *DB
+	tblName    string
+	maxPending int
+	c          chan WatchEvent
+	wake       chan struct{}
+	done       chan struct{}
+	once       sync.Once
+
+	mu    sync.Mutex
+	queue []WatchEvent
+	after uint64
+	err   error
+}
+
+// Close stops the watcher and closes C.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.done)
+
+		w.db.watchMu.Lock()
+		delete(w.db.watchers, w)
+		w.db.watchMu.Unlock()
+	})
+}
+
+// Err returns the error that closed the watcher, if any, such as
+// ErrWatchLagged.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// enqueue queues an event for the receiver, closing the watcher if too many
+// are waiting.
+func (w *Watcher) enqueue(event WatchEvent) {
+	w.mu.Lock()
+
+	if w.err != nil || (event.Seq != 0 && event.Seq <= w.after) {
+		// The watcher is closing, or the event was replayed already.
+		w.mu.Unlock()
+		return
+	}
+
+	if len(w.queue) >= w.maxPending {
+		w.err = ErrWatchLagged
+		w.queue = nil
+		w.mu.Unlock()
+
+		go w.Close()
+		return
+	}
+
+	w.queue = append(w.queue, event)
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued events on C.
+func (w *Watcher) run() {
+	defer close(w.c)
+
+	for {
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+
+		for _, event := range queue {
+			select {
+			case w.c <- event:
+			case <-w.done:
+				return
+			}
+		}
+
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+//*****************************************************************************
+// Public DB Methods
+//*****************************************************************************
+
+// Watch returns a Watcher sending every change made to the records of a
+// table, or of every table if the table name is empty, so that consumers
+// such as caches and search indexes can follow the database without webhooks
+// or polling. Changes can be replayed from the write-ahead log before the
+// live ones; see WatchOptions. A restricted database cannot watch tables
+// with a read policy. Close the watcher when done with it. It takes a table
+// name and the options. It returns the watcher and any error encountered.
+func (db *DB) Watch(tblName string, opts WatchOptions) (*Watcher, error) {
+	return db.WatchCtx(context.Background(), tblName, opts)
+}
+
+// WatchCtx is Watch with a context. The watcher is closed once the context
+// is done.
+func (db *DB) WatchCtx(ctx context.Context, tblName string, opts WatchOptions) (_ *Watcher, err error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.leave()
+
+	tblNames := []string{tblName}
+	if tblName == "" {
+		tblNames = db.tableNames()
+	}
+
+	ops, err := db.beginOps(ctx, tblNames, "watch")
+	defer func() { db.endOps(ops, err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	if tblName != "" && db.tblLock(tblName) == nil {
+		return nil, tableNotFoundErr(tblName)
+	}
+
+	// The changes carry the records as written, which a restricted database
+	// must not hand out.
+	for _, name := range tblNames {
+		if err := db.checkUnrestricted(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Replay && db.wal == nil {
+		return nil, errors.New("ivy: replaying changes requires the write-ahead log")
+	}
+
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaultWatchPending
+	}
+
+	c := make(chan WatchEvent)
+
+	w := &Watcher{
+		C:          c,
+		db:         db,
+		tblName:    tblName,
+		maxPending: opts.MaxPending,
+		c:          c,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	// Live changes are queued from now on, so that none made while the
+	// replayed ones are read is missed.
+	db.watchMu.Lock()
+	if db.watchers == nil {
+		db.watchers = make(map[*Watcher]bool)
+	}
+	db.watchers[w] = true
+	db.watchMu.Unlock()
+
+	if opts.Replay {
+		changes, err := db.readChanges(opts.Since, 0)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		var replayed []WatchEvent
+		var last uint64
+
+		for _, change := range changes {
+			last = change.LSN
+
+			if tblName != "" && change.Table != tblName {
+				continue
+			}
+
+			data, err := db.restrictChange(change.Table, change.Id, change.Data)
+			if err != nil {
+				w.Close()
+				return nil, err
+			}
+
+			replayed = append(replayed, WatchEvent{Seq: change.LSN, Time: change.Time, Table: change.Table,
+				Id: change.Id, Op: change.op, Data: data})
+		}
+
+		w.mu.Lock()
+		w.after = last
+		for _, event := range w.queue {
+			if event.Seq > last {
+				replayed = append(replayed, event)
+			}
+		}
+		w.queue = replayed
+		w.mu.Unlock()
+	}
+
+	go w.run()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				w.Close()
+			case <-w.done:
+			}
+		}()
+	}
+
+	return w, nil
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// notifyWatchers queues a change of a record for the watchers of its table. A
+// nil data slice means that the record was deleted. The caller must hold the
+// table's write lock.
+func (db *DB) notifyWatchers(seq uint64, tblName string, fileId string, op string, data []byte) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	if len(db.watchers) == 0 {
+		return
+	}
+
+	data, err := db.restrictChange(tblName, fileId, data)
+	if err != nil {
+		db.logger.Error("ivy: change not sent to watchers", "table", tblName, "id", fileId, "err", err)
+		return
+	}
+
+	event := WatchEvent{Seq: seq, Time: time.Now().UTC(), Table: tblName, Id: fileId, Op: op}
+	if data != nil {
+		event.Data = append(json.RawMessage(nil), data...)
+	}
+
+	for w := range db.watchers {
+		if w.tblName == "" || w.tblName == tblName {
+			w.enqueue(event)
+		}
+	}
+}
+
+// restrictChange applies the read policy of a table to the record of a
+// change, which is nil if the record was deleted.
+func (db *DB) restrictChange(tblName string, fileId string, data []byte) ([]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	return db.restrict(tblName, fileId, data)
+}
+
+// closeWatchers closes every watcher.
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	watchers := make([]*Watcher, 0, len(db.watchers))
+	for w := range db.watchers {
+		watchers = append(watchers, w)
+	}
+	db.watchMu.Unlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+}
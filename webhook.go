@@ -0,0 +1,309 @@
+package ivy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookQueueSize is the number of events a webhook queues by default.
+const webhookQueueSize = 1000
+
+// webhookMaxBackoff caps the wait between the attempts to deliver an event.
+const webhookMaxBackoff = time.Minute
+
+// Type Webhook is a struct describing an HTTP endpoint that is sent the
+// changes of records. Every committed create, update and delete of a record of
+// the selected tables, whether made by Create, Update and Delete or by an
+// import, a sync, a replication follower or a quota eviction, is POSTed to URL
+// as a JSON WebhookEvent. Events are delivered by a goroutine, one at a time
+// and in order, so writes never wait for them. A delivery that fails, or is
+// answered with a status other than 2xx, is retried with exponential backoff.
+// Close cancels the delivery in progress.
+type Webhook struct {
+	// URL is the address events are POSTed to.
+	URL string
+
+	// Tables lists the tables whose changes are sent. If it is empty, the
+	// changes of all tables are.
+	Tables []string
+
+	// Header holds extra request headers, such as Authorization.
+	Header http.Header
+
+	// Secret, if set, signs every event: the X-Ivy-Signature header holds
+	// "sha256=" followed by the hex-encoded HMAC-SHA256 of the body, keyed
+	// with the secret, so that the receiver can check where it came from.
+	Secret string
+
+	// Client is the HTTP client used for requests. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// Attempts is the number of times an event is sent before giving up on
+	// it. It defaults to 3.
+	Attempts int
+
+	// Backoff is how long to wait before the first retry. It doubles after
+	// every failed attempt, up to a minute. It defaults to one second.
+	Backoff time.Duration
+
+	// QueueSize is the number of events waiting to be delivered, beyond
+	// which new events are dropped. It defaults to 1000.
+	QueueSize int
+
+	// OnError, if set, is called with every event that is given up on or
+	// dropped, and the reason why: the error of the last attempt,
+	// ErrWebhookQueueFull, or ErrClosed for events still queued when the
+	// database is closed. It is called from the delivery goroutine, or from
+	// the writing one for a full queue, and must not block.
+	OnError func(event WebhookEvent, err error)
+}
+
+// Type WebhookEvent is a struct holding a change of a record, as sent to
+// webhooks. Op is "create", "update" or "delete". Namespace is the namespace
+// the record belongs to, if any; see DB.Namespace. Data holds the new version
+// of the record, or is nil if it was deleted.
+type WebhookEvent struct {
+	Table     string          `json:"table"`
+	Id        string          `json:"id"`
+	Op        string          `json:"op"`
+	Actor     string          `json:"actor,omitempty"`
+	Namespace string          `json:"namespace,omitempty"`
+	Time      time.Time       `json:"time"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// webhook delivers events to a Webhook.
+type webhook struct {
+	Webhook
+	tables map[string]bool
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	queue  []WebhookEvent
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newWebhook returns a webhook with its defaults filled in and starts
+// delivering its events.
+func newWebhook(hook Webhook, logger *slog.Logger) *webhook {
+	if hook.Attempts <= 0 {
+		hook.Attempts = 3
+	}
+	if hook.Backoff <= 0 {
+		hook.Backoff = time.Second
+	}
+	if hook.QueueSize <= 0 {
+		hook.QueueSize = webhookQueueSize
+	}
+
+	w := &webhook{
+		Webhook: hook,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+
+	if len(hook.Tables) > 0 {
+		w.tables = make(map[string]bool)
+		for _, tblName := range hook.Tables {
+			w.tables[tblName] = true
+		}
+	}
+
+	go w.run()
+
+	return w
+}
+
+// enqueue queues an event for delivery, dropping it if the queue is full.
+func (w *webhook) enqueue(event WebhookEvent) {
+	w.mu.Lock()
+	full := len(w.queue) >= w.QueueSize
+	if !full {
+		w.queue = append(w.queue, event)
+	}
+	w.mu.Unlock()
+
+	if full {
+		w.fail(event, ErrWebhookQueueFull)
+		return
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued events until the webhook is closed.
+func (w *webhook) run() {
+	defer close(w.done)
+
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+
+			select {
+			case <-w.wake:
+				continue
+			case <-w.ctx.Done():
+				return
+			}
+		}
+		event := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		err := w.deliver(event)
+		if err != nil {
+			w.fail(event, err)
+		}
+	}
+}
+
+// deliver sends an event, retrying until it is accepted, the attempts run
+// out or the webhook is closed.
+func (w *webhook) deliver(event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			return nil
+		}
+		if w.ctx.Err() != nil {
+			return ErrClosed
+		}
+		if attempt == w.Attempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return ErrClosed
+		}
+
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// post sends the body of an event once.
+func (w *webhook) post(body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Ivy-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ivy: webhook %s failed: %v: %s", w.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// fail reports an event that was not delivered.
+func (w *webhook) fail(event WebhookEvent, err error) {
+	w.logger.Warn("ivy: webhook event not delivered", "url", w.URL, "table", event.Table, "id", event.Id,
+		"op", event.Op, "err", err)
+
+	if w.OnError != nil {
+		w.OnError(event, err)
+	}
+}
+
+// close stops delivering events, cancelling a request in flight, and reports
+// the events left in the queue.
+func (w *webhook) close() {
+	w.cancel()
+	<-w.done
+
+	w.mu.Lock()
+	queue := w.queue
+	w.queue = nil
+	w.mu.Unlock()
+
+	for _, event := range queue {
+		w.fail(event, ErrClosed)
+	}
+}
+
+//*****************************************************************************
+// Private DB Methods
+//*****************************************************************************
+
+// notifyWebhooks queues a change of a record for the webhooks watching its
+// table. A nil data slice means that the record was deleted.
+func (db *DB) notifyWebhooks(tblName string, fileId string, op string, actor string, data []byte) {
+	if len(db.webhooks) == 0 {
+		return
+	}
+
+	event := WebhookEvent{Table: tblName, Id: fileId, Op: op, Actor: actor, Namespace: db.namespace, Time: time.Now().UTC()}
+	if data != nil {
+		event.Data = append(json.RawMessage(nil), data...)
+	}
+
+	for _, w := range db.webhooks {
+		if w.tables == nil || w.tables[tblName] {
+			w.enqueue(event)
+		}
+	}
+}
+
+// closeWebhooks stops delivering events to the webhooks.
+func (db *DB) closeWebhooks() {
+	for _, w := range db.webhooks {
+		w.close()
+	}
+}
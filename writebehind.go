@@ -0,0 +1,312 @@
+package ivy
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Type WriteBehindOptions configures write-behind mode. In write-behind mode,
+// Create, Update and Delete only update in-memory state and return
+// immediately; a background goroutine flushes the pending changes to the
+// storage engine in batches. Reads always see the latest writes. Changes that
+// have not been flushed yet are lost if the program crashes, so call DB.Sync
+// when a write has to be durable.
+type WriteBehindOptions struct {
+	// FlushInterval is how often pending writes are flushed. It defaults to
+	// one second.
+	FlushInterval time.Duration
+
+	// BatchSize is the number of pending writes that triggers an early flush.
+	// It defaults to 1000.
+	BatchSize int
+}
+
+// pendingWrite is a change that has not been flushed yet. A nil data slice
+// means the record was deleted.
+type pendingWrite struct {
+	data []byte
+}
+
+// writeBehindEngine wraps another engine, buffering writes in memory and
+// flushing them in the background.
+type writeBehindEngine struct {
+	engine
+	flushInterval time.Duration
+	batchSize     int
+
+	mu       sync.Mutex
+	pending  map[string]map[string]*pendingWrite
+	flushing map[string]map[string]*pendingWrite
+	count    int
+
+	logger  *slog.Logger
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newWriteBehindEngine wraps base and starts the background flusher.
+func newWriteBehindEngine(base engine, opts WriteBehindOptions, logger *slog.Logger) *writeBehindEngine {
+	e := &writeBehindEngine{
+		engine:        base,
+		logger:        logger,
+		flushInterval: opts.FlushInterval,
+		batchSize:     opts.BatchSize,
+		pending:       make(map[string]map[string]*pendingWrite),
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if e.flushInterval <= 0 {
+		e.flushInterval = time.Second
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = 1000
+	}
+
+	go e.run()
+
+	return e
+}
+
+func (e *writeBehindEngine) ids(tblName string) ([]string, error) {
+	// Keep a flush from moving changes into the wrapped engine between
+	// listing its ids and merging in the unflushed changes.
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	ids, err := e.engine.ids(tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changes := e.changes(tblName)
+	if len(changes) == 0 {
+		return ids, nil
+	}
+
+	var merged []string
+	for _, id := range ids {
+		if _, ok := changes[id]; !ok {
+			merged = append(merged, id)
+		}
+	}
+	for id, w := range changes {
+		if w.data != nil {
+			merged = append(merged, id)
+		}
+	}
+	sort.Strings(merged)
+
+	return merged, nil
+}
+
+// stat counts the records with the unflushed changes, but their bytes and
+// modification time only once they are flushed.
+func (e *writeBehindEngine) stat(tblName string) (tblStat, error) {
+	st, err := e.engine.stat(tblName)
+	if err != nil {
+		return tblStat{}, err
+	}
+
+	ids, err := e.ids(tblName)
+	if err != nil {
+		return tblStat{}, err
+	}
+	st.records = len(ids)
+
+	return st, nil
+}
+
+func (e *writeBehindEngine) read(tblName string, fileId string) ([]byte, error) {
+	e.mu.Lock()
+	w := e.lookup(tblName, fileId)
+	e.mu.Unlock()
+
+	if w == nil {
+		return e.engine.read(tblName, fileId)
+	}
+	if w.data == nil {
+		return nil, notExist("open", tblName, fileId)
+	}
+
+	return append([]byte(nil), w.data...), nil
+}
+
+func (e *writeBehindEngine) write(tblName string, fileId string, data []byte) error {
+	e.queue(tblName, fileId, &pendingWrite{data: append([]byte(nil), data...)})
+	return nil
+}
+
+func (e *writeBehindEngine) remove(tblName string, fileId string) error {
+	if _, err := e.read(tblName, fileId); err != nil {
+		return err
+	}
+
+	e.queue(tblName, fileId, &pendingWrite{})
+	return nil
+}
+
+// sync flushes all pending writes and then syncs the wrapped engine.
+func (e *writeBehindEngine) sync() error {
+	err := e.flush()
+	if err != nil {
+		return err
+	}
+
+	return e.engine.sync()
+}
+
+// close stops the background flusher, flushes all pending writes, and closes
+// the wrapped engine.
+func (e *writeBehindEngine) close() error {
+	close(e.stop)
+	<-e.done
+
+	err := e.flush()
+
+	if cerr := e.engine.close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// run flushes pending writes periodically, or early when a batch is full.
+func (e *writeBehindEngine) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.kick:
+		case <-e.stop:
+			return
+		}
+
+		// Failed changes are retried on the next flush.
+		if err := e.flush(); err != nil {
+			e.logger.Error("ivy: write-behind flush failed", "err", err)
+		}
+	}
+}
+
+// queue records a pending write, kicking the flusher if the batch is full.
+func (e *writeBehindEngine) queue(tblName string, fileId string, w *pendingWrite) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.pending[tblName] == nil {
+		e.pending[tblName] = make(map[string]*pendingWrite)
+	}
+	if _, ok := e.pending[tblName][fileId]; !ok {
+		e.count++
+	}
+	e.pending[tblName][fileId] = w
+
+	if e.count >= e.batchSize {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush writes all pending changes to the wrapped engine. While a batch is
+// being flushed it stays visible to readers. Changes that fail to flush are
+// put back so that they are retried, unless they have been superseded.
+func (e *writeBehindEngine) flush() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	batch := e.pending
+	e.flushing = batch
+	e.pending = make(map[string]map[string]*pendingWrite)
+	e.count = 0
+	e.mu.Unlock()
+
+	var firstErr error
+	failed := make(map[string]map[string]*pendingWrite)
+
+	for tblName, changes := range batch {
+		for fileId, w := range changes {
+			var err error
+
+			if w.data == nil {
+				err = e.engine.remove(tblName, fileId)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				err = e.engine.write(tblName, fileId, w.data)
+			}
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				if failed[tblName] == nil {
+					failed[tblName] = make(map[string]*pendingWrite)
+				}
+				failed[tblName][fileId] = w
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.flushing = nil
+
+	for tblName, changes := range failed {
+		for fileId, w := range changes {
+			if e.pending[tblName] == nil {
+				e.pending[tblName] = make(map[string]*pendingWrite)
+			}
+			if _, ok := e.pending[tblName][fileId]; !ok {
+				e.pending[tblName][fileId] = w
+				e.count++
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// lookup returns the most recent unflushed change of a record, or nil. The
+// caller must hold e.mu.
+func (e *writeBehindEngine) lookup(tblName string, fileId string) *pendingWrite {
+	if w, ok := e.pending[tblName][fileId]; ok {
+		return w
+	}
+	if w, ok := e.flushing[tblName][fileId]; ok {
+		return w
+	}
+	return nil
+}
+
+// changes returns the unflushed changes of a table. The caller must hold e.mu.
+func (e *writeBehindEngine) changes(tblName string) map[string]*pendingWrite {
+	changes := make(map[string]*pendingWrite)
+
+	for id, w := range e.flushing[tblName] {
+		changes[id] = w
+	}
+	for id, w := range e.pending[tblName] {
+		changes[id] = w
+	}
+
+	return changes
+}